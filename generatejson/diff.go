@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/go-installapplications/pkg/config"
+)
+
+// runDiff prints the item-level changes between two bootstrap files. Like
+// diff(1) it returns 0 when they are the same, 1 when they differ and 2 on
+// trouble.
+func runDiff(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(out, "Usage: generatejson diff old.json new.json")
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	var bootstraps [2]*config.Bootstrap
+	for i, path := range fs.Args() {
+		b, err := config.LoadBootstrapWithOptions(path, false)
+		if err != nil {
+			fmt.Fprintf(out, "%s: %v\n", path, err)
+			return 2
+		}
+		bootstraps[i] = b
+	}
+
+	changes := diffBootstraps(bootstraps[0], bootstraps[1])
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "No changes")
+		return 0
+	}
+	for _, change := range changes {
+		fmt.Fprintln(out, change)
+	}
+	return 1
+}
+
+// phaseItems is one item list of a bootstrap: a phase or a hook.
+type phaseItems struct {
+	name  string
+	items []config.Item
+}
+
+func bootstrapLists(b *config.Bootstrap) []phaseItems {
+	lists := []phaseItems{
+		{"preflight", b.Preflight},
+		{"setupassistant", b.SetupAssistant},
+		{"userland", b.Userland},
+	}
+	for _, phase := range bootstrapPhases {
+		lists = append(lists,
+			phaseItems{"hooks.pre_" + phase, b.Hooks.Pre(phase)},
+			phaseItems{"hooks.post_" + phase, b.Hooks.Post(phase)})
+	}
+	return lists
+}
+
+// diffBootstraps lists the changes from old to new, one line each, marked
+// + for added items, - for removed ones and ~ for changed fields, moves and
+// reordering. Items are matched by name within the bootstrap, so an item
+// keeps its identity when it moves between phases.
+func diffBootstraps(old, new *config.Bootstrap) []string {
+	oldItems, oldOrder := indexItems(old)
+	newItems, newOrder := indexItems(new)
+
+	var changes []string
+	for _, key := range oldOrder {
+		if _, ok := newItems[key]; !ok {
+			changes = append(changes, fmt.Sprintf("- %s: %s (removed)", oldItems[key].list, key))
+		}
+	}
+	for _, key := range newOrder {
+		n := newItems[key]
+		o, ok := oldItems[key]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("+ %s: %s (added)", n.list, key))
+			continue
+		}
+		if o.list != n.list {
+			changes = append(changes, fmt.Sprintf("~ %s: moved from %s to %s", key, o.list, n.list))
+		}
+		for _, field := range diffItemFields(o.item, n.item) {
+			changes = append(changes, fmt.Sprintf("~ %s: %s: %s", n.list, key, field))
+		}
+	}
+	changes = append(changes, diffOrder(old, new, oldItems, newItems)...)
+
+	if !reflect.DeepEqual(old.PreflightOptions, new.PreflightOptions) {
+		changes = append(changes, fmt.Sprintf("~ preflight_options: %s -> %s",
+			formatValue(reflect.ValueOf(old.PreflightOptions)), formatValue(reflect.ValueOf(new.PreflightOptions))))
+	}
+	return changes
+}
+
+// locatedItem is an item and the list it is in.
+type locatedItem struct {
+	list string
+	item config.Item
+}
+
+// itemKey identifies an item across bootstraps: its name, or its file.
+func itemKey(item config.Item) string {
+	if item.Name != "" {
+		return item.Name
+	}
+	return item.File
+}
+
+// indexItems returns the items of b by key, and the keys in bootstrap
+// order. Of items sharing a key, the first counts.
+func indexItems(b *config.Bootstrap) (map[string]locatedItem, []string) {
+	found := map[string]locatedItem{}
+	var order []string
+	for _, list := range bootstrapLists(b) {
+		for _, item := range list.items {
+			key := itemKey(item)
+			if _, dup := found[key]; dup {
+				continue
+			}
+			found[key] = locatedItem{list.name, item}
+			order = append(order, key)
+		}
+	}
+	return found, order
+}
+
+// diffOrder reports lists whose common items run in another order.
+func diffOrder(old, new *config.Bootstrap, oldItems, newItems map[string]locatedItem) []string {
+	var changes []string
+	newLists := bootstrapLists(new)
+	for i, list := range bootstrapLists(old) {
+		var before, after []string
+		for _, item := range list.items {
+			if _, ok := newItems[itemKey(item)]; ok {
+				before = append(before, itemKey(item))
+			}
+		}
+		for _, item := range newLists[i].items {
+			if _, ok := oldItems[itemKey(item)]; ok {
+				after = append(after, itemKey(item))
+			}
+		}
+		// only compare items that stayed in this list
+		before, after = common(before, after), common(after, before)
+		if !reflect.DeepEqual(before, after) {
+			changes = append(changes, fmt.Sprintf("~ %s: order %s -> %s", list.name, strings.Join(before, ", "), strings.Join(after, ", ")))
+		}
+	}
+	return changes
+}
+
+// common returns the elements of a that are in b, in a's order.
+func common(a, b []string) []string {
+	in := map[string]bool{}
+	for _, s := range b {
+		in[s] = true
+	}
+	var out []string
+	for _, s := range a {
+		if in[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// diffItemFields describes the fields that differ, by JSON name.
+func diffItemFields(old, new config.Item) []string {
+	var fields []string
+	ov, nv := reflect.ValueOf(old), reflect.ValueOf(new)
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		switch name {
+		case "content", "content_base64":
+			fields = append(fields, name+" changed")
+		default:
+			fields = append(fields, fmt.Sprintf("%s %s -> %s", name, formatValue(ov.Field(i)), formatValue(nv.Field(i))))
+		}
+	}
+	return fields
+}
+
+// formatValue shows a field value; unset values show as (none).
+func formatValue(v reflect.Value) string {
+	if v.IsZero() {
+		return "(none)"
+	}
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("%q", v.String())
+	case reflect.Struct:
+		return fmt.Sprintf("%+v", v.Interface())
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
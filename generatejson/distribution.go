@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// Distribution is the part of a product archive's Distribution file that
+// names the product and its component packages.
+type Distribution struct {
+	XMLName xml.Name `xml:"installer-gui-script"`
+	Product *struct {
+		ID      string `xml:"id,attr"`
+		Version string `xml:"version,attr"`
+	} `xml:"product"`
+	PkgRefs []struct {
+		ID      string `xml:"id,attr"`
+		Version string `xml:"version,attr"`
+	} `xml:"pkg-ref"`
+}
+
+// getDistributionInfo returns the identifier and version of a product
+// archive (a distribution package with component packages): those of its
+// product element, or, when productbuild wrote none, those of the first
+// component package reference that has a version.
+func getDistributionInfo(archive *xarArchive) (string, string, error) {
+	xmlData, err := archive.ReadFile("Distribution")
+	if err != nil {
+		return "", "", err
+	}
+
+	var dist Distribution
+	if err := xml.Unmarshal(xmlData, &dist); err != nil {
+		return "", "", fmt.Errorf("error parsing Distribution XML: %v", err)
+	}
+
+	if dist.Product != nil && dist.Product.ID != "" {
+		return dist.Product.ID, dist.Product.Version, nil
+	}
+	for _, ref := range dist.PkgRefs {
+		if ref.ID != "" && ref.Version != "" {
+			return ref.ID, ref.Version, nil
+		}
+	}
+	return "", "", fmt.Errorf("distribution names no product or versioned package")
+}
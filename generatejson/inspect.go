@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+)
+
+// itemFacts is what is read from an item's file: its hash and, for
+// packages, the identifier and version.
+type itemFacts struct {
+	hash       string
+	pkgID      string
+	pkgVersion string
+	err        error
+}
+
+// inspectItems hashes and introspects the items' files with jobs workers,
+// as reading dozens of multi-GB packages one after another dominates the
+// run. A file listed by several items is read once. The facts are in item
+// order; a file that cannot be read has its error in them.
+func inspectItems(items ItemList, jobs int, log *logger) []itemFacts {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	// one job per distinct file and whether its package info is needed
+	type job struct {
+		path    string
+		pkgInfo bool
+	}
+	var queue []job
+	index := map[job]int{}
+	jobOf := make([]int, len(items))
+	for i, item := range items {
+		j := job{item.Path, isPackageType(item.Type)}
+		n, ok := index[j]
+		if !ok {
+			n = len(queue)
+			index[j] = n
+			queue = append(queue, j)
+		}
+		jobOf[i] = n
+	}
+
+	results := make([]itemFacts, len(queue))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < len(queue); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range next {
+				j := queue[n]
+				var facts itemFacts
+				facts.hash, facts.err = getHash(j.path)
+				if facts.err == nil && j.pkgInfo {
+					facts.pkgID, facts.pkgVersion, facts.err = getPkgInfo(j.path, log)
+				}
+				results[n] = facts
+			}
+		}()
+	}
+	for n := range queue {
+		next <- n
+	}
+	close(next)
+	wg.Wait()
+
+	facts := make([]itemFacts, len(items))
+	for i := range items {
+		facts[i] = results[jobOf[i]]
+	}
+	return facts
+}
+
+// isPackageType reports whether items of type carry a package whose
+// identifier and version go into the bootstrap.
+func isPackageType(itemType string) bool {
+	return itemType == "package" || itemType == "munki"
+}
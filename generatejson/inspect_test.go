@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspectItems(t *testing.T) {
+	dir := t.TempDir()
+	var items ItemList
+	for _, name := range []string{"a.sh", "b.sh", "c.sh"} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(name), 0644)
+		items = append(items, InputItem{Path: path, Type: "rootscript"})
+	}
+	pkg := writeXar(t, "tool.pkg", []xarEntry{
+		{"PackageInfo", `<pkg-info identifier="com.example.tool" version="1.0"/>`},
+	})
+	items = append(items,
+		InputItem{Path: pkg, Type: "package"},
+		InputItem{Path: items[0].Path, Type: "userscript"},
+	)
+
+	serial := inspectItems(items, 1, testLogger())
+	parallel := inspectItems(items, 4, testLogger())
+	for i := range items {
+		if serial[i] != parallel[i] {
+			t.Errorf("item %d: serial %+v, parallel %+v", i, serial[i], parallel[i])
+		}
+		if hash, _ := getHash(items[i].Path); serial[i].hash != hash || serial[i].err != nil {
+			t.Errorf("item %d: hash %s", i, serial[i].hash)
+		}
+	}
+	if parallel[3].pkgID != "com.example.tool" || parallel[3].pkgVersion != "1.0" {
+		t.Errorf("package facts = %+v", parallel[3])
+	}
+	if parallel[0].pkgID != "" || parallel[4] != parallel[0] {
+		t.Errorf("script facts = %+v, %+v", parallel[0], parallel[4])
+	}
+
+	missing := inspectItems(ItemList{{Path: filepath.Join(dir, "missing.pkg"), Type: "package"}}, 2, testLogger())
+	if missing[0].err == nil || missing[0].hash != "" {
+		t.Errorf("missing file facts = %+v", missing[0])
+	}
+}
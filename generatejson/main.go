@@ -1,17 +1,18 @@
 package main
 
 import (
+	"crypto"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 )
@@ -28,6 +29,29 @@ type InputItem struct {
 	Retries         string
 	RetryWait       string
 	Required        string
+
+	// Client item fields passed through to the JSON
+	FailPolicy      string
+	ParallelGroup   string
+	ParallelSafe    string
+	Rollback        string
+	RunAs           string
+	VerifySignature string
+	TeamID          string
+	SigningID       string
+	Owner           string
+	Mode            string
+	DirMode         string
+	Backup          string
+	StripQuarantine string
+	XAttrs          string
+	Group           string
+	ChoicesXML      string
+	AllowUntrusted  string
+	Target          string
+	Action          string
+	Label           string
+	Domain          string
 }
 
 // JSONItem represents an item in the final JSON output
@@ -50,6 +74,36 @@ type JSONItem struct {
 	PkgRequired bool `json:"pkg_required,omitempty"`
 	Retries     int  `json:"retries,omitempty"`
 	RetryWait   int  `json:"retrywait,omitempty"`
+
+	FailPolicy    string `json:"fail_policy,omitempty"`
+	ParallelGroup string `json:"parallel_group,omitempty"`
+	ParallelSafe  bool   `json:"parallel_safe,omitempty"`
+	Rollback      string `json:"rollback,omitempty"`
+	Group         string `json:"group,omitempty"`
+	RunAs         string `json:"run_as,omitempty"`
+
+	// Package installer options
+	ChoicesXML     string `json:"choices_xml,omitempty"`
+	AllowUntrusted bool   `json:"allow_untrusted,omitempty"`
+	Target         string `json:"target,omitempty"`
+
+	// launchd items
+	Action string `json:"action,omitempty"`
+	Label  string `json:"label,omitempty"`
+	Domain string `json:"domain,omitempty"`
+
+	// Signature checks of packages and placed files
+	VerifySignature bool   `json:"verify_signature,omitempty"`
+	TeamID          string `json:"team_id,omitempty"`
+	SigningID       string `json:"signing_id,omitempty"`
+
+	// rootfile/userfile metadata
+	Owner           string            `json:"owner,omitempty"`
+	Mode            string            `json:"mode,omitempty"`
+	DirMode         string            `json:"dir_mode,omitempty"`
+	Backup          bool              `json:"backup,omitempty"`
+	StripQuarantine bool              `json:"strip_quarantine,omitempty"`
+	XAttrs          map[string]string `json:"xattrs,omitempty"`
 }
 
 // JSONOutput represents the final JSON structure that will be written to file
@@ -67,14 +121,17 @@ func (i *ItemList) String() string {
 	return fmt.Sprintf("%v", *i)
 }
 
-// Set implements the flag.Value interface
+// Set implements the flag.Value interface. Only item-path is required; the
+// other keys may be left out and take their defaults. Values containing
+// spaces can be quoted: item-name="Company Portal".
 func (i *ItemList) Set(value string) error {
-	parts := strings.Fields(value)
-	if len(parts) != 10 {
-		return fmt.Errorf("item must have exactly 10 key=value pairs, got %d", len(parts))
+	parts, err := splitFields(value)
+	if err != nil {
+		return err
 	}
 
 	item := InputItem{}
+	seen := map[string]bool{}
 
 	// Parse each key=value pair
 	for _, part := range parts {
@@ -84,95 +141,410 @@ func (i *ItemList) Set(value string) error {
 		}
 
 		key, value := kv[0], kv[1]
+		if seen[key] {
+			return fmt.Errorf("duplicate item key: %s", key)
+		}
+		seen[key] = true
 
-		switch key {
-		case "item-name":
-			item.Name = value
-		case "item-path":
-			item.Path = value
-		case "item-stage":
-			item.Stage = value
-		case "item-type":
-			item.Type = value
-		case "item-url":
-			item.URL = value
-		case "script-do-not-wait":
-			item.ScriptDoNotWait = value
-		case "pkg-skip-if":
-			item.PkgSkipIf = value
-		case "retries":
-			item.Retries = value
-		case "retrywait":
-			item.RetryWait = value
-		case "required":
-			item.Required = value
-		default:
-			return fmt.Errorf("unknown item key: %s", key)
+		if err := item.set(key, value); err != nil {
+			return err
 		}
 	}
 
+	if err := item.complete(); err != nil {
+		return err
+	}
+
 	*i = append(*i, item)
 	return nil
 }
 
+// set sets the field an item key names.
+func (item *InputItem) set(key, value string) error {
+	switch key {
+	case "item-name":
+		item.Name = value
+	case "item-path":
+		item.Path = value
+	case "item-stage":
+		item.Stage = value
+	case "item-type":
+		item.Type = value
+	case "item-url":
+		item.URL = value
+	case "script-do-not-wait":
+		item.ScriptDoNotWait = value
+	case "pkg-skip-if", "skip-if":
+		item.PkgSkipIf = value
+	case "retries":
+		item.Retries = value
+	case "retrywait":
+		item.RetryWait = value
+	case "required":
+		item.Required = value
+	case "fail-policy":
+		item.FailPolicy = value
+	case "parallel-group":
+		item.ParallelGroup = value
+	case "parallel-safe":
+		item.ParallelSafe = value
+	case "rollback":
+		item.Rollback = value
+	case "run-as":
+		item.RunAs = value
+	case "verify-signature":
+		item.VerifySignature = value
+	case "team-id":
+		item.TeamID = value
+	case "signing-id":
+		item.SigningID = value
+	case "owner":
+		item.Owner = value
+	case "mode":
+		item.Mode = value
+	case "dir-mode":
+		item.DirMode = value
+	case "backup":
+		item.Backup = value
+	case "strip-quarantine":
+		item.StripQuarantine = value
+	case "xattrs":
+		item.XAttrs = value
+	case "group":
+		item.Group = value
+	case "choices-xml":
+		item.ChoicesXML = value
+	case "allow-untrusted":
+		item.AllowUntrusted = value
+	case "target":
+		item.Target = value
+	case "action":
+		item.Action = value
+	case "label":
+		item.Label = value
+	case "domain":
+		item.Domain = value
+	default:
+		return fmt.Errorf("unknown item key: %s", key)
+	}
+	return nil
+}
+
+// complete checks that item-path is set and fills in the item-type.
+func (item *InputItem) complete() error {
+	if item.Path == "" {
+		return fmt.Errorf("item-path is required")
+	}
+	if item.Type == "" {
+		item.Type = inferItemType(item.Path)
+		if item.Type == "" {
+			return fmt.Errorf("cannot tell the item-type of %s from its extension; set item-type", item.Path)
+		}
+	}
+	return nil
+}
+
+// splitFields splits value at unquoted whitespace like a shell would: single
+// quotes keep everything literally, double quotes and backslashes let a
+// value contain spaces, quotes or backslashes.
+func splitFields(value string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField := false
+	var quote rune
+	escaped := false
+
+	for _, r := range value {
+		switch {
+		case escaped:
+			field.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				field.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inField = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				field.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in item: %s", quote, value)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash in item: %s", value)
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// inferItemType is the item-type used when none is given: package for
+// packages and rootscript for scripts; other files need an explicit type.
+func inferItemType(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pkg", ".mpkg":
+		return "package"
+	case ".sh", ".bash", ".zsh", ".py", ".rb", ".pl", ".swift":
+		return "rootscript"
+	}
+	return ""
+}
+
 func main() {
-	baseURL := flag.String("base-url", "", "Base URL to where root dir is hosted")
-	output := flag.String("output", "", "Required: Output directory for the generated json file")
-	compat := flag.Bool("compat", false, "Use /Library/installapplications for generated paths")
-	installPathFlag := flag.String("install-path", "", "Override base install path used for scripts/packages (default: /Library/go-installapplications; ignored if --compat is set)")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:], os.Stdout))
+		case "diff":
+			os.Exit(runDiff(os.Args[2:], os.Stdout))
+		case "merge":
+			os.Exit(runMerge(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
 
-	var items ItemList
-	flag.Var(&items, "item", "Required: Options for item. Format: item-name=NAME item-path=PATH item-stage=STAGE item-type=TYPE item-url=URL script-do-not-wait=BOOL pkg-skip-if=ARCH retries=INT retrywait=INT required=BOOL")
+// options are the flags of a generate run.
+type options struct {
+	baseURL, output   string
+	compat            bool
+	installPath       string
+	upload            string
+	signKey           string
+	signFormat        string
+	signKeyID         string
+	jobs              int
+	manifest          string
+	items             ItemList
+	quiet, jsonOutput bool
+}
 
-	flag.Parse()
+// run generates the bootstrap and returns the exit code. With --json the
+// report is the only output on stdout and progress goes to stderr.
+func run(args []string, stdout, stderr io.Writer) int {
+	var opts options
+	fs := flag.NewFlagSet("generatejson", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.baseURL, "base-url", "", "Base URL to where root dir is hosted")
+	fs.StringVar(&opts.output, "output", "", "Required: Output directory for the generated json file")
+	fs.BoolVar(&opts.compat, "compat", false, "Use /Library/installapplications for generated paths")
+	fs.StringVar(&opts.installPath, "install-path", "", "Override base install path used for scripts/packages (default: /Library/go-installapplications; ignored if --compat is set)")
+
+	fs.StringVar(&opts.upload, "upload", "", "Upload the items hosted below --base-url and bootstrap.json to s3://BUCKET/PREFIX, gs://BUCKET/PREFIX, az://ACCOUNT/CONTAINER/PREFIX or webdav+https://HOST/PATH")
+	fs.StringVar(&opts.signKey, "sign-key", "", "PEM private key (Ed25519, ECDSA or RSA) to sign bootstrap.json with")
+	fs.StringVar(&opts.signFormat, "sign-format", SignDetached, "Signature written next to bootstrap.json: detached (bootstrap.json.sig) or jws (bootstrap.jws)")
+	fs.StringVar(&opts.signKeyID, "sign-key-id", "", "Key ID (kid) put in the JWS header")
+	fs.IntVar(&opts.jobs, "jobs", runtime.NumCPU(), "Number of files hashed and inspected at once")
+	fs.StringVar(&opts.manifest, "manifest", "", "YAML (.yaml, .yml) or CSV (.csv) file listing items with the --item keys; --item flags add to it")
+	fs.BoolVar(&opts.quiet, "quiet", false, "Only print warnings and errors")
+	fs.BoolVar(&opts.jsonOutput, "json", false, "Print a JSON report of the run on stdout; progress goes to stderr")
+
+	fs.Var(&opts.items, "item", "Required: Options for item. Format: item-path=PATH [item-name=NAME item-stage=STAGE item-type=TYPE item-url=URL script-do-not-wait=BOOL pkg-skip-if=ARCH retries=INT retrywait=INT required=BOOL ...]; see the README for the client item keys; quote values with spaces")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
 
-	if *output == "" {
-		log.Fatal("output is required")
+	log := &logger{out: stdout, errOut: stderr, quiet: opts.quiet}
+	if opts.jsonOutput {
+		log.out = stderr
 	}
 
-	if *baseURL == "" {
-		log.Fatal("base-url is required")
+	rep, err := generate(opts, log)
+	rep.ExitCode = exitCode(err)
+	rep.OK = err == nil
+	rep.Warnings = log.warnings
+	if err != nil {
+		rep.Errors = errorLines(err)
+		for _, line := range rep.Errors {
+			fmt.Fprintf(stderr, "error: %s\n", line)
+		}
+	}
+	if opts.jsonOutput {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+	}
+	return rep.ExitCode
+}
+
+// generate writes bootstrap.json and its signature and uploads them. The
+// report is filled in as far as the run got.
+func generate(opts options, log *logger) (*report, error) {
+	rep := &report{}
+	items := opts.items
+
+	if opts.output == "" {
+		return rep, fail(exitUsage, errors.New("--output is required"))
+	}
+	if opts.baseURL == "" {
+		return rep, fail(exitUsage, errors.New("--base-url is required"))
+	}
+	if opts.jobs < 1 {
+		return rep, fail(exitUsage, errors.New("--jobs must be at least 1"))
+	}
+
+	// Check the signing key and the upload destination before hashing anything
+	var signKey crypto.Signer
+	if opts.signKey != "" {
+		if opts.signFormat != SignDetached && opts.signFormat != SignJWS {
+			return rep, fail(exitUsage, fmt.Errorf("--sign-format must be %s or %s", SignDetached, SignJWS))
+		}
+		var err error
+		signKey, err = loadSigningKey(opts.signKey)
+		if err != nil {
+			return rep, fail(exitUsage, fmt.Errorf("error loading signing key: %w", err))
+		}
+	}
+
+	var up uploader
+	if opts.upload != "" {
+		var err error
+		up, err = newUploader(opts.upload)
+		if err != nil {
+			return rep, fail(exitUsage, err)
+		}
+	}
+
+	if opts.manifest != "" {
+		manifestItems, err := readManifest(opts.manifest)
+		if err != nil {
+			return rep, fail(exitUsage, fmt.Errorf("error reading manifest: %w", err))
+		}
+		items = append(manifestItems, items...)
 	}
 
 	if len(items) == 0 {
-		log.Fatal("at least one --item is required")
+		return rep, fail(exitUsage, errors.New("at least one --item or manifest item is required"))
 	}
 
 	// Determine base install path behavior
 	baseInstallPath := "/Library/go-installapplications"
-	if *compat {
-		if *installPathFlag != "" {
-			log.Fatal("--compat cannot be used together with --install-path; choose one")
+	if opts.compat {
+		if opts.installPath != "" {
+			return rep, fail(exitUsage, errors.New("--compat cannot be used together with --install-path; choose one"))
 		}
 		baseInstallPath = "/Library/installapplications"
-	} else if *installPathFlag != "" {
-		baseInstallPath = *installPathFlag
+	} else if opts.installPath != "" {
+		baseInstallPath = opts.installPath
 	}
 
-	fmt.Printf("Base URL: %s\n", *baseURL)
-	fmt.Printf("Output: %s\n", *output)
-	fmt.Printf("Items (%d):\n", len(items))
+	log.Infof("Base URL: %s", opts.baseURL)
+	log.Infof("Output: %s", opts.output)
+	log.Infof("Items (%d):", len(items))
 	for i, item := range items {
-		fmt.Printf("  Item %d: %+v\n", i+1, item)
+		log.Infof("  Item %d: %+v", i+1, item)
 	}
 
-	stages := buildItemDict(items, *baseURL, baseInstallPath)
+	// Nothing is written when an item is bad, so a bootstrap with a bogus
+	// hash never replaces a good one
+	stages, err := buildItemDict(items, opts.baseURL, baseInstallPath, opts.jobs, log)
+	if err != nil {
+		return rep, fail(exitItems, err)
+	}
+	if err := validateOutput(stages); err != nil {
+		return rep, fail(exitItems, fmt.Errorf("generated bootstrap is not valid: %w", err))
+	}
+	rep.Items = reportItems(stages)
 
 	jsonData, err := json.MarshalIndent(stages, "", "  ")
 	if err != nil {
-		log.Fatalf("Error marshaling JSON: %v", err)
+		return rep, fail(exitOutput, fmt.Errorf("error marshaling JSON: %w", err))
 	}
 
-	savePath := filepath.Join(*output, "bootstrap.json")
-	err = os.WriteFile(savePath, jsonData, 0644)
-	if err != nil {
-		log.Fatalf("Error writing JSON file to %s: %v", savePath, err)
+	savePath := filepath.Join(opts.output, "bootstrap.json")
+	if err := os.WriteFile(savePath, jsonData, 0644); err != nil {
+		return rep, fail(exitOutput, fmt.Errorf("error writing JSON file to %s: %w", savePath, err))
+	}
+	rep.Bootstrap = savePath
+	log.Infof("Json saved to %s", savePath)
+	published := []string{savePath}
+
+	if signKey != nil {
+		var signature []byte
+		sigPath := savePath + ".sig"
+		if opts.signFormat == SignJWS {
+			signature, err = signJWS(signKey, opts.signKeyID, jsonData)
+			sigPath = filepath.Join(opts.output, "bootstrap.jws")
+		} else {
+			signature, err = signDetached(signKey, jsonData)
+		}
+		if err != nil {
+			return rep, fail(exitOutput, fmt.Errorf("error signing bootstrap: %w", err))
+		}
+		if err := os.WriteFile(sigPath, signature, 0644); err != nil {
+			return rep, fail(exitOutput, fmt.Errorf("error writing signature to %s: %w", sigPath, err))
+		}
+		rep.Signature = sigPath
+		log.Infof("Signature saved to %s", sigPath)
+		published = append(published, sigPath)
+	}
+
+	if up != nil {
+		rep.Uploaded, err = uploadArtifacts(up, items, opts.baseURL, published, log)
+		if err != nil {
+			return rep, fail(exitUpload, fmt.Errorf("error uploading: %w", err))
+		}
+		log.Infof("Uploaded to %s", opts.upload)
 	}
+	return rep, nil
+}
 
-	fmt.Printf("Json saved to %s\n", savePath)
+// reportItems lists the generated items for the report.
+func reportItems(output JSONOutput) []reportItem {
+	var items []reportItem
+	for _, stage := range []struct {
+		name  string
+		items []JSONItem
+	}{
+		{"preflight", output.Preflight},
+		{"setupassistant", output.SetupAssistant},
+		{"userland", output.Userland},
+	} {
+		for _, item := range stage.items {
+			items = append(items, reportItem{
+				Stage:     stage.name,
+				Name:      item.Name,
+				Type:      item.Type,
+				URL:       item.URL,
+				Hash:      item.Hash,
+				PackageID: item.PackageID,
+				Version:   item.Version,
+			})
+		}
+	}
+	return items
 }
 
-func buildItemDict(items ItemList, baseURL string, baseInstallPath string) JSONOutput {
+// buildItemDict turns the items into the bootstrap. The errors of all
+// items are returned together, so one run reports every problem.
+func buildItemDict(items ItemList, baseURL string, baseInstallPath string, jobs int, log *logger) (JSONOutput, error) {
 	// Initialize the output structure
 	output := JSONOutput{
 		Preflight:      []JSONItem{},
@@ -180,159 +552,227 @@ func buildItemDict(items ItemList, baseURL string, baseInstallPath string) JSONO
 		Userland:       []JSONItem{},
 	}
 
-	// Process each input item
-	for _, inputItem := range items {
-		jsonItem := JSONItem{}
+	facts := inspectItems(items, jobs, log)
 
-		fileExt := filepath.Ext(inputItem.Path)
-		fileName := filepath.Base(inputItem.Path)
-		filePath := inputItem.Path
+	var errs []error
+	// Process each input item
+	for i, inputItem := range items {
+		jsonItem, err := buildItem(inputItem, facts[i], baseURL, baseInstallPath)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("item %d (%s): %w", i+1, inputItem.Path, err))
+			continue
+		}
 
-		if inputItem.Type != "package" && inputItem.Type != "rootscript" && inputItem.Type != "rootfile" && inputItem.Type != "userscript" && inputItem.Type != "userfile" {
-			fmt.Printf("Invalid type: %s for %s\n", inputItem.Type, filePath)
-			os.Exit(1)
+		// Add to appropriate stage
+		switch inputItem.Stage {
+		case "preflight":
+			output.Preflight = append(output.Preflight, jsonItem)
+		case "setupassistant":
+			output.SetupAssistant = append(output.SetupAssistant, jsonItem)
+		default:
+			output.Userland = append(output.Userland, jsonItem)
 		}
+	}
 
-		jsonItem.Type = inputItem.Type
+	return output, errors.Join(errs...)
+}
 
-		// Ensure the type is set correctly for packages
-		if fileExt == ".pkg" {
-			jsonItem.Type = "package"
-		}
+// buildItem turns one item into its JSON, with the hash and package info
+// read from its file.
+func buildItem(inputItem InputItem, facts itemFacts, baseURL string, baseInstallPath string) (JSONItem, error) {
+	jsonItem := JSONItem{}
 
-		if inputItem.Stage == "" {
-			inputItem.Stage = "userland"
-		}
-		if inputItem.Stage != "preflight" && inputItem.Stage != "setupassistant" && inputItem.Stage != "userland" {
-			fmt.Printf("Invalid stage: %s for %s\n", inputItem.Stage, filePath)
-			os.Exit(1)
-		}
+	fileExt := filepath.Ext(inputItem.Path)
+	fileName := filepath.Base(inputItem.Path)
 
-		if inputItem.URL == "" {
-			jsonItem.URL = fmt.Sprintf("%s/%s/%s", baseURL, inputItem.Stage, fileName)
-		} else {
-			jsonItem.URL = inputItem.URL
-		}
+	switch inputItem.Type {
+	case "package", "rootscript", "rootfile", "userscript", "userfile", "munki", "launchd":
+	default:
+		return jsonItem, fmt.Errorf("invalid type: %s", inputItem.Type)
+	}
 
-		if inputItem.Name == "" {
-			jsonItem.Name = fileName
-		} else {
-			jsonItem.Name = inputItem.Name
-		}
+	jsonItem.Type = inputItem.Type
 
-		jsonItem.Hash = getHash(filePath)
+	// Ensure the type is set correctly for packages
+	if fileExt == ".pkg" && inputItem.Type != "munki" {
+		jsonItem.Type = "package"
+	}
 
-		if inputItem.Type == "rootscript" || inputItem.Type == "userscript" {
-			if inputItem.Type == "userscript" {
-				jsonItem.File = filepath.Join(baseInstallPath, "userscripts", fileName)
-			} else {
-				jsonItem.File = filepath.Join(baseInstallPath, fileName)
-			}
+	switch inputItem.Stage {
+	case "", "preflight", "setupassistant", "userland":
+	default:
+		return jsonItem, fmt.Errorf("invalid stage: %s", inputItem.Stage)
+	}
+	if inputItem.Type == "munki" && inputItem.Stage != "" && inputItem.Stage != "userland" {
+		return jsonItem, fmt.Errorf("munki items must be in the userland stage")
+	}
 
-			jsonItem.DoNotWait = false
-			switch inputItem.ScriptDoNotWait {
-			case "true", "True", "1", "yes", "y", "false", "False", "0", "no", "n":
-				if inputItem.ScriptDoNotWait == "true" || inputItem.ScriptDoNotWait == "True" || inputItem.ScriptDoNotWait == "1" || inputItem.ScriptDoNotWait == "yes" || inputItem.ScriptDoNotWait == "y" {
-					jsonItem.DoNotWait = true
-				}
-			default:
-				fmt.Printf("Invalid script-do-not-wait: %s for %s\n", inputItem.ScriptDoNotWait, filePath)
-				os.Exit(1)
-			}
-		}
+	// The file must be readable; its hash goes into the bootstrap
+	if facts.err != nil {
+		return jsonItem, facts.err
+	}
 
-		if inputItem.Type == "package" {
-			pkgId, pkgVersion := getPkgInfo(filePath)
-			jsonItem.File = filepath.Join(baseInstallPath, fileName)
-			jsonItem.PackageID = pkgId
-			jsonItem.Version = pkgVersion
-
-			if inputItem.PkgSkipIf != "false" && inputItem.PkgSkipIf != "False" && inputItem.PkgSkipIf != "0" && inputItem.PkgSkipIf != "no" && inputItem.PkgSkipIf != "n" && inputItem.PkgSkipIf != "" {
-				switch inputItem.PkgSkipIf {
-				case "intel", "arm64", "x86_64", "apple_silicon":
-					jsonItem.SkipIf = inputItem.PkgSkipIf
-				default:
-					fmt.Printf("Invalid pkg-skip-if: %s for %s\n", inputItem.PkgSkipIf, filePath)
-					os.Exit(1)
-				}
-			}
+	jsonItem.URL = itemDownloadURL(inputItem, baseURL)
 
-			// Handle the pkg_required field (input key is 'required' for IA compatibility)
-			jsonItem.PkgRequired = false
-			if inputItem.Required != "false" && inputItem.Required != "False" && inputItem.Required != "0" && inputItem.Required != "no" && inputItem.Required != "n" && inputItem.Required != "" {
-				switch inputItem.Required {
-				case "true", "True", "1", "yes", "y":
-					jsonItem.PkgRequired = true
-				default:
-					fmt.Printf("Invalid required: %s for %s\n", inputItem.Required, filePath)
-					os.Exit(1)
-				}
-			}
+	if inputItem.Name == "" {
+		jsonItem.Name = fileName
+	} else {
+		jsonItem.Name = inputItem.Name
+	}
+
+	jsonItem.Hash = facts.hash
+
+	if inputItem.Type == "rootscript" || inputItem.Type == "userscript" {
+		if inputItem.Type == "userscript" {
+			jsonItem.File = filepath.Join(baseInstallPath, "userscripts", fileName)
+		} else {
+			jsonItem.File = filepath.Join(baseInstallPath, fileName)
 		}
+	}
 
-		// rootfile/userfile: file is a destination path as provided by item-path
-		if inputItem.Type == "rootfile" || inputItem.Type == "userfile" {
-			jsonItem.File = inputItem.Path
+	// munki items carry the munkitools package
+	if isPackageType(inputItem.Type) {
+		jsonItem.File = filepath.Join(baseInstallPath, fileName)
+		jsonItem.PackageID = facts.pkgID
+		jsonItem.Version = facts.pkgVersion
+	}
+
+	// rootfile/userfile/launchd: file is a destination path as provided by
+	// item-path
+	if inputItem.Type == "rootfile" || inputItem.Type == "userfile" || inputItem.Type == "launchd" {
+		jsonItem.File = inputItem.Path
+	}
+
+	// skip_if applies to every item type
+	switch inputItem.PkgSkipIf {
+	case "", "false", "False", "0", "no", "n":
+	case "intel", "arm64", "x86_64", "apple_silicon":
+		jsonItem.SkipIf = inputItem.PkgSkipIf
+	default:
+		return jsonItem, fmt.Errorf("invalid skip-if: %s", inputItem.PkgSkipIf)
+	}
+
+	jsonItem.FailPolicy = inputItem.FailPolicy
+	jsonItem.ParallelGroup = inputItem.ParallelGroup
+	jsonItem.Rollback = inputItem.Rollback
+	jsonItem.RunAs = inputItem.RunAs
+	jsonItem.TeamID = inputItem.TeamID
+	jsonItem.SigningID = inputItem.SigningID
+	jsonItem.Owner = inputItem.Owner
+	jsonItem.Mode = inputItem.Mode
+	jsonItem.DirMode = inputItem.DirMode
+	jsonItem.Group = inputItem.Group
+	jsonItem.ChoicesXML = inputItem.ChoicesXML
+	jsonItem.Target = inputItem.Target
+	jsonItem.Action = inputItem.Action
+	jsonItem.Label = inputItem.Label
+	jsonItem.Domain = inputItem.Domain
+	xattrs, err := parseXAttrs(inputItem.XAttrs)
+	if err != nil {
+		return jsonItem, err
+	}
+	jsonItem.XAttrs = xattrs
+
+	// script-do-not-wait only applies to scripts and required (mapped to
+	// pkg_required for IA compatibility) only to packages
+	isScript := inputItem.Type == "rootscript" || inputItem.Type == "userscript"
+	for _, b := range []struct {
+		key   string
+		value string
+		out   *bool
+	}{
+		{"script-do-not-wait", inputItem.ScriptDoNotWait, &jsonItem.DoNotWait},
+		{"required", inputItem.Required, &jsonItem.PkgRequired},
+		{"parallel-safe", inputItem.ParallelSafe, &jsonItem.ParallelSafe},
+		{"verify-signature", inputItem.VerifySignature, &jsonItem.VerifySignature},
+		{"backup", inputItem.Backup, &jsonItem.Backup},
+		{"strip-quarantine", inputItem.StripQuarantine, &jsonItem.StripQuarantine},
+		{"allow-untrusted", inputItem.AllowUntrusted, &jsonItem.AllowUntrusted},
+	} {
+		value, ok := parseBool(b.value)
+		if !ok {
+			return jsonItem, fmt.Errorf("invalid %s: %s", b.key, b.value)
 		}
+		if (b.key == "script-do-not-wait" && !isScript) || (b.key == "required" && !isPackageType(inputItem.Type)) {
+			continue
+		}
+		*b.out = value
+	}
 
-		if inputItem.Retries != "" {
-			retries, err := strconv.Atoi(inputItem.Retries)
-			if err != nil {
-				fmt.Printf("Invalid retries value: %s for %s\n", inputItem.Retries, filePath)
-				os.Exit(1)
-			}
-			jsonItem.Retries = retries
+	if inputItem.Retries != "" {
+		retries, err := strconv.Atoi(inputItem.Retries)
+		if err != nil {
+			return jsonItem, fmt.Errorf("invalid retries value: %s", inputItem.Retries)
 		}
-		if inputItem.RetryWait != "" {
-			retryWait, err := strconv.Atoi(inputItem.RetryWait)
-			if err != nil {
-				fmt.Printf("Invalid retry-wait value: %s for %s\n", inputItem.RetryWait, filePath)
-				os.Exit(1)
-			}
-			jsonItem.RetryWait = retryWait
+		jsonItem.Retries = retries
+	}
+	if inputItem.RetryWait != "" {
+		retryWait, err := strconv.Atoi(inputItem.RetryWait)
+		if err != nil {
+			return jsonItem, fmt.Errorf("invalid retrywait value: %s", inputItem.RetryWait)
 		}
+		jsonItem.RetryWait = retryWait
+	}
 
-		// Add to appropriate stage
-		switch inputItem.Stage {
-		case "preflight":
-			output.Preflight = append(output.Preflight, jsonItem)
-		case "setupassistant":
-			output.SetupAssistant = append(output.SetupAssistant, jsonItem)
-		case "userland":
-			output.Userland = append(output.Userland, jsonItem)
-		default:
-			// Default to userland if stage is not specified or invalid
-			output.Userland = append(output.Userland, jsonItem)
+	return jsonItem, nil
+}
+
+// parseXAttrs reads the xattrs key, name=value pairs separated by commas,
+// e.g. xattrs=com.example.managed=1,com.example.owner=it.
+func parseXAttrs(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	xattrs := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		name, v, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid xattrs: %s (want name=value,...)", value)
 		}
+		xattrs[name] = v
 	}
+	return xattrs, nil
+}
 
-	return output
+// parseBool reads the boolean values item keys take; empty is false.
+func parseBool(value string) (bool, bool) {
+	switch value {
+	case "true", "True", "1", "yes", "y":
+		return true, true
+	case "", "false", "False", "0", "no", "n":
+		return false, true
+	}
+	return false, false
 }
 
-func getHash(filePath string) string {
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		fmt.Printf("FILE NOT FOUND - CHECK YOUR PATH: %s\n", filePath)
-		return "FILE NOT FOUND - CHECK YOUR PATH"
+// itemDownloadURL is the item's item-url, or {base-url}/{stage}/{basename}.
+func itemDownloadURL(item InputItem, baseURL string) string {
+	if item.URL != "" {
+		return item.URL
 	}
+	stage := item.Stage
+	if stage == "" {
+		stage = "userland"
+	}
+	return fmt.Sprintf("%s/%s/%s", baseURL, stage, filepath.Base(item.Path))
+}
 
+// getHash returns the hex SHA-256 of the file.
+func getHash(filePath string) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		fmt.Printf("Error opening file %s: %v\n", filePath, err)
-		return "ERROR OPENING FILE"
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("file not found, check the item path: %s", filePath)
+		}
+		return "", err
 	}
 	defer file.Close()
 
 	hasher := sha256.New()
-
-	_, err = io.Copy(hasher, file)
-	if err != nil {
-		fmt.Printf("Error reading file %s: %v\n", filePath, err)
-		return "ERROR READING FILE"
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", fmt.Errorf("error reading %s: %w", filePath, err)
 	}
-
-	hashBytes := hasher.Sum(nil)
-	return hex.EncodeToString(hashBytes)
+	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
 
 type PackageInfo struct {
@@ -341,75 +781,48 @@ type PackageInfo struct {
 	Version    string   `xml:"version,attr"`
 }
 
-func getPkgInfo(filePath string) (string, string) {
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		fmt.Printf("Package file not found: %s\n", filePath)
-		return "", ""
-	}
-
-	pkgInfoPath, err := getPkgInfoPath(filePath)
+// getPkgInfo returns the identifier and version of the package.
+func getPkgInfo(filePath string, log *logger) (string, string, error) {
+	archive, err := openXar(filePath)
 	if err != nil {
-		fmt.Printf("Error getting PackageInfo path: %v\n", err)
-		return "", ""
+		return "", "", fmt.Errorf("error reading package: %w", err)
+	}
+	defer archive.Close()
+
+	// A distribution package has component packages and a Distribution
+	// that describes the product, rather than a top-level PackageInfo
+	if !archive.Has("PackageInfo") && archive.Has("Distribution") {
+		pkgId, pkgVersion, err := getDistributionInfo(archive)
+		if err == nil {
+			return pkgId, pkgVersion, nil
+		}
+		log.Warnf("%s: error reading Distribution, using the first component package: %v", filePath, err)
 	}
 
-	extractedPath, err := extractPackageInfo(filePath, pkgInfoPath)
+	pkgInfoPath, err := getPkgInfoPath(archive)
 	if err != nil {
-		fmt.Printf("Error extracting PackageInfo: %v\n", err)
-		return "", ""
+		return "", "", fmt.Errorf("%s: %w", filePath, err)
 	}
-	defer os.Remove(extractedPath)
 
-	xmlData, err := os.ReadFile(extractedPath)
+	xmlData, err := archive.ReadFile(pkgInfoPath)
 	if err != nil {
-		fmt.Printf("Error reading PackageInfo XML: %v\n", err)
-		return "", ""
+		return "", "", fmt.Errorf("error extracting PackageInfo: %w", err)
 	}
 
 	var pkgInfo PackageInfo
-	err = xml.Unmarshal(xmlData, &pkgInfo)
-	if err != nil {
-		fmt.Printf("Error parsing PackageInfo XML: %v\n", err)
-		return "", ""
+	if err := xml.Unmarshal(xmlData, &pkgInfo); err != nil {
+		return "", "", fmt.Errorf("%s: error parsing PackageInfo XML: %w", filePath, err)
 	}
 
-	return pkgInfo.Identifier, pkgInfo.Version
+	return pkgInfo.Identifier, pkgInfo.Version, nil
 }
 
-func getPkgInfoPath(filePath string) (string, error) {
-	// Use xar to list the contents of the package
-	cmd := exec.Command("/usr/bin/xar", "-tf", filePath)
-	output, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("error running xar -tf: %v", err)
-	}
-
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "PackageInfo") || strings.HasSuffix(line, ".pkg/PackageInfo") {
-			return line, nil
+func getPkgInfoPath(archive *xarArchive) (string, error) {
+	for _, name := range archive.Names() {
+		if name == "PackageInfo" || strings.HasSuffix(name, ".pkg/PackageInfo") {
+			return name, nil
 		}
 	}
 
 	return "", fmt.Errorf("PackageInfo not found in package")
 }
-
-func extractPackageInfo(pkgPath, pkgInfoPath string) (string, error) {
-	// Create temporary directory
-	tmpDir, err := os.MkdirTemp("", "pkginfo-*")
-	if err != nil {
-		return "", fmt.Errorf("error creating temp dir: %v", err)
-	}
-
-	cmd := exec.Command("/usr/bin/xar", "-xf", pkgPath, "-C", tmpDir, pkgInfoPath)
-	err = cmd.Run()
-	if err != nil {
-		os.RemoveAll(tmpDir)
-		return "", fmt.Errorf("error extracting PackageInfo: %v", err)
-	}
-
-	extractedPath := filepath.Join(tmpDir, pkgInfoPath)
-	return extractedPath, nil
-}
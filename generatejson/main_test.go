@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+)
+
+func TestSplitFields(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want []string
+	}{
+		{"item-path=/a.pkg  retries=3", []string{"item-path=/a.pkg", "retries=3"}},
+		{`item-name="Company Portal" item-path=/a.pkg`, []string{"item-name=Company Portal", "item-path=/a.pkg"}},
+		{`item-path='/Volumes/My Disk/it'"'"'s.sh'`, []string{"item-path=/Volumes/My Disk/it's.sh"}},
+		{`item-path=/My\ Disk/a.pkg item-name="say \"hi\""`, []string{"item-path=/My Disk/a.pkg", `item-name=say "hi"`}},
+		{`item-url=""`, []string{"item-url="}},
+	} {
+		got, err := splitFields(c.in)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitFields(%s) = %q, %v, want %q", c.in, got, err, c.want)
+		}
+	}
+	for _, in := range []string{`item-name="open`, `item-path=/a.pkg\`} {
+		if _, err := splitFields(in); err == nil {
+			t.Errorf("splitFields(%s) should fail", in)
+		}
+	}
+}
+
+func TestItemListSet(t *testing.T) {
+	var items ItemList
+	if err := items.Set(`item-path="/local/Company Portal.pkg"`); err != nil {
+		t.Fatal(err)
+	}
+	if err := items.Set("item-path=/local/setup.sh item-stage=preflight"); err != nil {
+		t.Fatal(err)
+	}
+	want := ItemList{
+		{Path: "/local/Company Portal.pkg", Type: "package"},
+		{Path: "/local/setup.sh", Stage: "preflight", Type: "rootscript"},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Fatalf("items = %+v, want %+v", items, want)
+	}
+
+	for in, wantErr := range map[string]string{
+		"item-name=x":                       "item-path is required",
+		"item-path=/local/wallpaper.jpg":    "set item-type",
+		"item-path=/a.pkg item-path=/b.pkg": "duplicate item key",
+		"item-path=/a.pkg colour=blue":      "unknown item key",
+		"item-path=/a.pkg retries":          "invalid key=value",
+	} {
+		if err := items.Set(in); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("Set(%s) = %v, want error containing %q", in, err, wantErr)
+		}
+	}
+}
+
+// testLogger discards progress and warnings.
+func testLogger() *logger {
+	return &logger{out: io.Discard, errOut: io.Discard}
+}
+
+// writeItemFile writes a file to build an item from.
+func writeItemFile(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuildItemClientFieldsRoundTrip(t *testing.T) {
+	var items ItemList
+	for _, in := range []string{
+		"item-path=/local/Office.pkg group=office choices-xml=/Library/go-installapplications/choices.xml allow-untrusted=true target=/",
+		"item-path=/Library/LaunchDaemons/com.example.agent.plist item-type=launchd action=bootstrap label=com.example.agent domain=system",
+		"item-path=/Library/Helper item-type=rootfile xattrs=com.example.managed=1,com.example.owner=it",
+	} {
+		if err := items.Set(in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out JSONOutput
+	for _, item := range items {
+		facts := itemFacts{hash: strings.Repeat("0", 64)}
+		if item.Type == "package" {
+			facts.pkgID, facts.pkgVersion = "com.microsoft.office", "16.0"
+		}
+		built, err := buildItem(item, facts, "https://server.example", "/Library/go-installapplications")
+		if err != nil {
+			t.Fatalf("%s: %v", item.Path, err)
+		}
+		out.Userland = append(out.Userland, built)
+	}
+	if err := validateOutput(out); err != nil {
+		t.Fatalf("validateOutput: %v", err)
+	}
+
+	// The client reads back every field the keys set
+	data, err := json.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bootstrap config.Bootstrap
+	if err := json.Unmarshal(data, &bootstrap); err != nil {
+		t.Fatal(err)
+	}
+	pkg, svc, file := bootstrap.Userland[0], bootstrap.Userland[1], bootstrap.Userland[2]
+	if pkg.Group != "office" || pkg.ChoicesXML != "/Library/go-installapplications/choices.xml" || !pkg.AllowUntrusted || pkg.Target != "/" {
+		t.Errorf("package = %+v", pkg)
+	}
+	if svc.Type != "launchd" || svc.File != "/Library/LaunchDaemons/com.example.agent.plist" ||
+		svc.Action != "bootstrap" || svc.Label != "com.example.agent" || svc.Domain != "system" {
+		t.Errorf("launchd = %+v", svc)
+	}
+	if want := map[string]string{"com.example.managed": "1", "com.example.owner": "it"}; !reflect.DeepEqual(file.XAttrs, want) {
+		t.Errorf("xattrs = %v, want %v", file.XAttrs, want)
+	}
+
+	bad := InputItem{Path: "/Library/Helper", Type: "rootfile", XAttrs: "com.example.managed"}
+	if _, err := buildItem(bad, itemFacts{}, "https://server.example", "/Library/go-installapplications"); err == nil {
+		t.Error("accepted xattrs without a value")
+	}
+}
+
+func TestBuildItemDictDefaults(t *testing.T) {
+	items := ItemList{{Path: writeItemFile(t, "setup.sh"), Type: "rootscript"}}
+	out, err := buildItemDict(items, "https://server.example", "/Library/go-installapplications", 1, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Userland) != 1 {
+		t.Fatalf("userland = %+v, want the item", out.Userland)
+	}
+	got := out.Userland[0]
+	if got.Name != "setup.sh" || got.URL != "https://server.example/userland/setup.sh" ||
+		got.File != "/Library/go-installapplications/setup.sh" || got.DoNotWait {
+		t.Errorf("item = %+v", got)
+	}
+}
+
+func TestBuildItemDictClientFields(t *testing.T) {
+	var items ItemList
+	for _, in := range []string{
+		"item-path=" + writeItemFile(t, "setup.sh") + " item-stage=setupassistant fail-policy=failable skip-if=intel run-as=_svc parallel-group=tools rollback='rm -f /tmp/x'",
+		"item-path=" + writeItemFile(t, "logo.png") + " item-type=rootfile owner=root:wheel mode=0644 backup=yes strip-quarantine=true verify-signature=1 team-id=ABCDE12345",
+	} {
+		if err := items.Set(in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := buildItemDict(items, "https://server.example", "/Library/go-installapplications", 1, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, file := out.SetupAssistant[0], out.Userland[0]
+	if script.FailPolicy != "failable" || script.SkipIf != "intel" || script.RunAs != "_svc" ||
+		script.ParallelGroup != "tools" || script.Rollback != "rm -f /tmp/x" {
+		t.Errorf("script = %+v", script)
+	}
+	if file.Owner != "root:wheel" || file.Mode != "0644" || !file.Backup || !file.StripQuarantine ||
+		!file.VerifySignature || file.TeamID != "ABCDE12345" {
+		t.Errorf("file = %+v", file)
+	}
+	if err := validateOutput(out); err != nil {
+		t.Errorf("validateOutput: %v", err)
+	}
+
+	out.Userland[0].FailPolicy = "sometimes"
+	if err := validateOutput(out); err == nil {
+		t.Errorf("validateOutput accepted fail_policy %q", out.Userland[0].FailPolicy)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readManifest reads the items of a YAML (.yaml, .yml) or CSV (.csv)
+// manifest. Both use the --item keys:
+//
+//	items:
+//	  - item-path: packages/Company Portal.pkg
+//	    item-stage: setupassistant
+//	    required: true
+//
+//	item-path,item-stage,required
+//	packages/Company Portal.pkg,setupassistant,true
+//
+// A relative item-path is relative to the manifest's directory, except for
+// rootfile and userfile items, whose item-path is the destination.
+func readManifest(path string) (ItemList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var items ItemList
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		items, err = parseYAMLManifest(data)
+	case ".csv":
+		items, err = parseCSVManifest(data)
+	default:
+		return nil, fmt.Errorf("%s: unknown manifest format; use .yaml, .yml or .csv", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for i := range items {
+		if items[i].Type == "rootfile" || items[i].Type == "userfile" || filepath.IsAbs(items[i].Path) {
+			continue
+		}
+		items[i].Path = filepath.Join(dir, items[i].Path)
+	}
+	return items, nil
+}
+
+// yamlManifest is the document of a YAML manifest.
+type yamlManifest struct {
+	Items []map[string]string `yaml:"items"`
+}
+
+func parseYAMLManifest(data []byte) (ItemList, error) {
+	var manifest yamlManifest
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&manifest); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	var items ItemList
+	for n, fields := range manifest.Items {
+		item := InputItem{}
+		for key, value := range fields {
+			if err := item.set(key, value); err != nil {
+				return nil, fmt.Errorf("item %d: %w", n+1, err)
+			}
+		}
+		if err := item.complete(); err != nil {
+			return nil, fmt.Errorf("item %d: %w", n+1, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// parseCSVManifest reads a header row of item keys and one item per row;
+// an empty cell leaves the key at its default. Lines starting with # are
+// comments.
+func parseCSVManifest(data []byte) (ItemList, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for n, key := range header {
+		key = strings.TrimSpace(key)
+		if err := (&InputItem{}).set(key, ""); err != nil {
+			return nil, fmt.Errorf("header: %w", err)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("header: duplicate item key: %s", key)
+		}
+		seen[key] = true
+		header[n] = key
+	}
+
+	var items ItemList
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := r.FieldPos(0)
+
+		item := InputItem{}
+		for n, value := range record {
+			if err := item.set(header[n], strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		if err := item.complete(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
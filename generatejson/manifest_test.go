@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeManifest(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadManifest(t *testing.T) {
+	yamlPath := writeManifest(t, "bootstrap.yaml", `items:
+  - item-path: packages/Company Portal.pkg
+    item-stage: setupassistant
+    required: true
+    retries: 3
+  - item-path: /Library/Desktop/wallpaper.jpg
+    item-type: rootfile
+`)
+	csvPath := writeManifest(t, "bootstrap.csv", `# items for the lab Macs
+item-path,item-stage,required,retries,item-type
+packages/Company Portal.pkg,setupassistant,true,3,
+/Library/Desktop/wallpaper.jpg,,,,rootfile
+`)
+	for _, path := range []string{yamlPath, csvPath} {
+		items, err := readManifest(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		want := ItemList{
+			{Path: filepath.Join(filepath.Dir(path), "packages/Company Portal.pkg"), Stage: "setupassistant", Type: "package", Required: "true", Retries: "3"},
+			{Path: "/Library/Desktop/wallpaper.jpg", Type: "rootfile"},
+		}
+		if !reflect.DeepEqual(items, want) {
+			t.Errorf("%s: items = %+v, want %+v", filepath.Base(path), items, want)
+		}
+	}
+}
+
+func TestReadManifestErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown.yaml":   "items:\n  - item-path: a.pkg\n    colour: blue\n",
+		"toplevel.yaml":  "item:\n  - item-path: a.pkg\n",
+		"nopath.yaml":    "items:\n  - item-name: a\n",
+		"header.csv":     "item-path,colour\na.pkg,blue\n",
+		"nopath.csv":     "item-path,item-name\n,a\n",
+		"columns.csv":    "item-path,item-name\na.pkg\n",
+		"bootstrap.json": "{}",
+	} {
+		if _, err := readManifest(writeManifest(t, name, content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	_, err := readManifest(writeManifest(t, "line.csv", "item-path,item-type\na.pkg,package\nwallpaper.jpg,\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("error = %v, want one naming line 3", err)
+	}
+}
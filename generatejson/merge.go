@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/go-installapplications/pkg/config"
+)
+
+// runMerge combines a base bootstrap with overlay fragments, such as the
+// add-ons of a department, and returns the exit code. Overlay items follow
+// the base's in their phase. An item (or group) is identified by its name
+// across all phases: repeating an identical item is fine, but a different
+// item under a name that is already taken is a conflict, which fails the
+// merge unless --override lets the later file win.
+func runMerge(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("output", "", "File to write the merged bootstrap to (default: stdout)")
+	override := fs.Bool("override", false, "Let later files replace conflicting items, keeping the earlier position")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: generatejson merge [--output merged.json] [--override] base.json overlay.json [...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	m := newMerger(*override)
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return exitUsage
+		}
+		if err := m.add(path, data); err != nil {
+			fmt.Fprintf(stderr, "error: %s: %v\n", path, err)
+			return exitUsage
+		}
+	}
+	for _, note := range m.overridden {
+		fmt.Fprintf(stderr, "warning: %s\n", note)
+	}
+	if len(m.conflicts) > 0 {
+		for _, conflict := range m.conflicts {
+			fmt.Fprintf(stderr, "error: %s\n", conflict)
+		}
+		return exitItems
+	}
+
+	merged, err := m.bootstrap()
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return exitItems
+	}
+	var bootstrap config.Bootstrap
+	if err := json.Unmarshal(merged, &bootstrap); err != nil {
+		fmt.Fprintf(stderr, "error: merged bootstrap: %v\n", err)
+		return exitItems
+	}
+	if err := config.ValidateBootstrap(&bootstrap); err != nil {
+		fmt.Fprintf(stderr, "error: merged bootstrap is not valid: %v\n", err)
+		return exitItems
+	}
+
+	if *output == "" {
+		stdout.Write(merged)
+		return exitOK
+	}
+	if err := os.WriteFile(*output, merged, 0644); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return exitOutput
+	}
+	fmt.Fprintf(stderr, "Merged %d files into %s\n", fs.NArg(), *output)
+	return exitOK
+}
+
+// mergeEntry is an item or group of a merged list and where it came from.
+type mergeEntry struct {
+	key    string // "name" of an item, "group name" of a group
+	source string
+	raw    json.RawMessage
+}
+
+// merger accumulates bootstraps.
+type merger struct {
+	override bool
+	lists    map[string][]mergeEntry // by phase, or hooks.<hook>
+	where    map[string]string       // entry key to the list it is in
+	other    map[string]json.RawMessage
+	sources  map[string]string // top-level key to the file that set it
+
+	conflicts  []string
+	overridden []string
+}
+
+func newMerger(override bool) *merger {
+	return &merger{
+		override: override,
+		lists:    map[string][]mergeEntry{},
+		where:    map[string]string{},
+		other:    map[string]json.RawMessage{},
+		sources:  map[string]string{},
+	}
+}
+
+// add merges the bootstrap in data, read from source.
+func (m *merger) add(source string, data []byte) error {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(top) {
+		switch key {
+		case "preflight", "setupassistant", "userland":
+			if err := m.addList(source, key, top[key]); err != nil {
+				return err
+			}
+		case "hooks":
+			var hooks map[string]json.RawMessage
+			if err := json.Unmarshal(top[key], &hooks); err != nil {
+				return fmt.Errorf("hooks: %w", err)
+			}
+			for _, hook := range sortedKeys(hooks) {
+				if err := m.addList(source, "hooks."+hook, hooks[hook]); err != nil {
+					return err
+				}
+			}
+		default:
+			m.addValue(source, key, top[key])
+		}
+	}
+	return nil
+}
+
+// addList merges the entries of one phase or hook list.
+func (m *merger) addList(source, list string, data json.RawMessage) error {
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("%s: %w", list, err)
+	}
+	for n, raw := range entries {
+		var probe struct {
+			Name  string `json:"name"`
+			File  string `json:"file"`
+			Group string `json:"group"`
+			Items json.RawMessage
+		}
+		if err := json.Unmarshal(raw, &probe); err != nil {
+			return fmt.Errorf("%s[%d]: %w", list, n, err)
+		}
+		key := probe.Name
+		if probe.Items != nil {
+			key = "group " + probe.Group
+		} else if key == "" {
+			key = probe.File
+		}
+		m.addEntry(list, mergeEntry{key: key, source: source, raw: raw})
+	}
+	return nil
+}
+
+func (m *merger) addEntry(list string, entry mergeEntry) {
+	prevList, taken := m.where[entry.key]
+	if !taken {
+		m.where[entry.key] = list
+		m.lists[list] = append(m.lists[list], entry)
+		return
+	}
+	entries := m.lists[prevList]
+	i := 0
+	for entries[i].key != entry.key {
+		i++
+	}
+	prev := entries[i]
+	if prevList == list && sameJSON(prev.raw, entry.raw) {
+		return
+	}
+
+	what := fmt.Sprintf("%q in %s (%s) and %s (%s)", entry.key, prevList, prev.source, list, entry.source)
+	if !m.override {
+		m.conflicts = append(m.conflicts, "conflicting "+what)
+		return
+	}
+	m.overridden = append(m.overridden, "overriding "+what)
+	if prevList == list {
+		entries[i] = entry
+		return
+	}
+	m.lists[prevList] = append(entries[:i:i], entries[i+1:]...)
+	m.where[entry.key] = list
+	m.lists[list] = append(m.lists[list], entry)
+}
+
+// addValue merges a top-level value other than a list, e.g.
+// preflight_options.
+func (m *merger) addValue(source, key string, value json.RawMessage) {
+	prev, ok := m.other[key]
+	if !ok || sameJSON(prev, value) {
+		m.other[key] = value
+		m.sources[key] = source
+		return
+	}
+	what := fmt.Sprintf("%s in %s and %s", key, m.sources[key], source)
+	if !m.override {
+		m.conflicts = append(m.conflicts, "conflicting "+what)
+		return
+	}
+	m.overridden = append(m.overridden, "overriding "+what)
+	m.other[key] = value
+	m.sources[key] = source
+}
+
+// bootstrap returns the merged bootstrap, indented.
+func (m *merger) bootstrap() ([]byte, error) {
+	top := map[string]any{}
+	for key, value := range m.other {
+		top[key] = value
+	}
+	hooks := map[string][]json.RawMessage{}
+	for list, entries := range m.lists {
+		raws := make([]json.RawMessage, 0, len(entries))
+		for _, e := range entries {
+			raws = append(raws, e.raw)
+		}
+		if hook, ok := strings.CutPrefix(list, "hooks."); ok {
+			if len(raws) > 0 {
+				hooks[hook] = raws
+			}
+			continue
+		}
+		top[list] = raws
+	}
+	if len(hooks) > 0 {
+		top["hooks"] = hooks
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(top); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sameJSON reports whether a and b hold the same JSON value.
+func sameJSON(a, b json.RawMessage) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
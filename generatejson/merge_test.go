@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRunMerge(t *testing.T) {
+	base := writeBootstrap(t, "base.json", `{
+		"preflight_options": {"timeout": 30},
+		"setupassistant": [
+			{"name": "App", "type": "package", "file": "/tmp/app.pkg", "url": "https://x/app.pkg", "hash": "a"},
+			{"name": "Dock", "type": "rootscript", "file": "/tmp/dock.sh", "url": "https://x/dock.sh", "hash": "d"}
+		],
+		"hooks": {"pre_userland": [{"name": "hook", "type": "rootscript", "file": "/tmp/h.sh", "url": "https://x/h.sh"}]}
+	}`)
+	overlay := writeBootstrap(t, "design.json", `{
+		"setupassistant": [
+			{"name": "App", "type": "package", "file": "/tmp/app.pkg", "url": "https://x/app.pkg", "hash": "a"},
+			{"name": "Sketch", "type": "package", "file": "/tmp/sketch.pkg", "url": "https://x/sketch.pkg", "hash": "s"}
+		],
+		"userland": [{"group": "fonts", "items": [{"name": "Font", "type": "rootscript", "file": "/tmp/f.sh", "url": "https://x/f.sh"}]}]
+	}`)
+
+	var stdout, stderr bytes.Buffer
+	if code := runMerge([]string{base, overlay}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	var merged struct {
+		PreflightOptions map[string]int `json:"preflight_options"`
+		SetupAssistant   []struct {
+			Name string `json:"name"`
+		} `json:"setupassistant"`
+		Userland []map[string]any            `json:"userland"`
+		Hooks    map[string][]map[string]any `json:"hooks"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &merged); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range merged.SetupAssistant {
+		names = append(names, item.Name)
+	}
+	if got := strings.Join(names, ", "); got != "App, Dock, Sketch" {
+		t.Errorf("setupassistant = %s", got)
+	}
+	if len(merged.Userland) != 1 || merged.Userland[0]["group"] != "fonts" {
+		t.Errorf("userland = %v", merged.Userland)
+	}
+	if len(merged.Hooks["pre_userland"]) != 1 || merged.PreflightOptions["timeout"] != 30 {
+		t.Errorf("hooks = %v, preflight_options = %v", merged.Hooks, merged.PreflightOptions)
+	}
+}
+
+func TestRunMergeConflicts(t *testing.T) {
+	base := writeBootstrap(t, "base.json", `{
+		"setupassistant": [
+			{"name": "App", "type": "package", "file": "/tmp/app.pkg", "url": "https://x/app.pkg", "hash": "a"},
+			{"name": "Dock", "type": "rootscript", "file": "/tmp/dock.sh", "url": "https://x/dock.sh"}
+		]
+	}`)
+	overlay := writeBootstrap(t, "overlay.json", `{
+		"setupassistant": [{"name": "App", "type": "package", "file": "/tmp/app.pkg", "url": "https://x/app.pkg", "hash": "b"}],
+		"userland": [{"name": "Dock", "type": "rootscript", "file": "/tmp/dock.sh", "url": "https://x/dock.sh"}]
+	}`)
+
+	var stdout, stderr bytes.Buffer
+	if code := runMerge([]string{base, overlay}, &stdout, &stderr); code != exitItems {
+		t.Fatalf("exit %d, want %d", code, exitItems)
+	}
+	for _, want := range []string{`conflicting "App" in setupassistant`, `conflicting "Dock" in setupassistant`} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("stderr lacks %q:\n%s", want, stderr.String())
+		}
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("wrote a bootstrap despite conflicts: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if code := runMerge([]string{"--override", base, overlay}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("--override: exit %d: %s", code, stderr.String())
+	}
+	var merged map[string][]map[string]any
+	if err := json.Unmarshal(stdout.Bytes(), &merged); err != nil {
+		t.Fatal(err)
+	}
+	if sa := merged["setupassistant"]; len(sa) != 1 || sa[0]["hash"] != "b" {
+		t.Errorf("setupassistant = %v", sa)
+	}
+	if ul := merged["userland"]; len(ul) != 1 || ul[0]["name"] != "Dock" {
+		t.Errorf("userland = %v", ul)
+	}
+}
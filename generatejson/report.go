@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Exit codes of a generate run.
+const (
+	exitOK     = 0
+	exitItems  = 1 // an item is invalid or its file cannot be read
+	exitUsage  = 2 // bad flags, manifest, signing key or upload destination
+	exitOutput = 3 // bootstrap.json or its signature cannot be written
+	exitUpload = 4 // the upload failed
+)
+
+// runError is a failure and the exit code it ends the run with.
+type runError struct {
+	code int
+	err  error
+}
+
+func (e *runError) Error() string { return e.err.Error() }
+func (e *runError) Unwrap() error { return e.err }
+
+// fail returns a runError with code wrapping err.
+func fail(code int, err error) error {
+	return &runError{code: code, err: err}
+}
+
+// exitCode is the exit code for err: exitOK for nil, the code of a
+// runError, and exitItems otherwise.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var re *runError
+	if errors.As(err, &re) {
+		return re.code
+	}
+	return exitItems
+}
+
+// errorLines splits err into its messages, one per joined error.
+func errorLines(err error) []string {
+	var re *runError
+	if errors.As(err, &re) {
+		err = re.err
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var lines []string
+		for _, e := range joined.Unwrap() {
+			lines = append(lines, errorLines(e)...)
+		}
+		return lines
+	}
+	return []string{err.Error()}
+}
+
+// logger writes progress to out unless quiet, and warnings to errOut. The
+// warnings are also kept for the JSON report. It is safe for concurrent use.
+type logger struct {
+	out, errOut io.Writer
+	quiet       bool
+
+	mu       sync.Mutex
+	warnings []string
+}
+
+// Infof logs progress.
+func (l *logger) Infof(format string, args ...any) {
+	if l.quiet {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.out, format+"\n", args...)
+}
+
+// Warnf logs a problem that does not fail the run.
+func (l *logger) Warnf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, msg)
+	fmt.Fprintf(l.errOut, "warning: %s\n", msg)
+}
+
+// report is the --json output of a generate run.
+type report struct {
+	OK        bool         `json:"ok"`
+	ExitCode  int          `json:"exit_code"`
+	Bootstrap string       `json:"bootstrap,omitempty"`
+	Signature string       `json:"signature,omitempty"`
+	Uploaded  []string     `json:"uploaded,omitempty"`
+	Items     []reportItem `json:"items,omitempty"`
+	Warnings  []string     `json:"warnings,omitempty"`
+	Errors    []string     `json:"errors,omitempty"`
+}
+
+// reportItem is a generated item in the report.
+type reportItem struct {
+	Stage     string `json:"stage"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	URL       string `json:"url"`
+	Hash      string `json:"hash"`
+	PackageID string `json:"packageid,omitempty"`
+	Version   string `json:"version,omitempty"`
+}
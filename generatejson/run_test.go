@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunFailsWithoutWritingOnBadItems(t *testing.T) {
+	out := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	code := run([]string{
+		"--base-url", "https://cdn.example", "--output", out,
+		"--item", "item-path=" + writeItemFile(t, "setup.sh"),
+		"--item", "item-path=" + filepath.Join(out, "missing.pkg"),
+		"--item", "item-path=" + writeItemFile(t, "notes.txt") + " item-type=rootfile retries=many",
+	}, &stdout, &stderr)
+
+	if code != exitItems {
+		t.Errorf("exit code = %d, want %d", code, exitItems)
+	}
+	for _, want := range []string{"error: item 2 (", "file not found", "error: item 3 (", "invalid retries value: many"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("stderr does not contain %q:\n%s", want, stderr.String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "bootstrap.json")); !os.IsNotExist(err) {
+		t.Errorf("bootstrap.json was written: %v", err)
+	}
+}
+
+func TestRunJSONReport(t *testing.T) {
+	out := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	code := run([]string{
+		"--json", "--base-url", "https://cdn.example", "--output", out,
+		"--item", "item-path=" + writeItemFile(t, "setup.sh") + " item-stage=setupassistant",
+	}, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("exit code = %d, stderr:\n%s", code, stderr.String())
+	}
+
+	var rep report
+	if err := json.Unmarshal(stdout.Bytes(), &rep); err != nil {
+		t.Fatalf("stdout is not a JSON report: %v\n%s", err, stdout.String())
+	}
+	if !rep.OK || rep.Bootstrap != filepath.Join(out, "bootstrap.json") || len(rep.Items) != 1 ||
+		rep.Items[0].Stage != "setupassistant" || len(rep.Items[0].Hash) != 64 {
+		t.Errorf("report = %+v", rep)
+	}
+	if !strings.Contains(stderr.String(), "Json saved to") {
+		t.Errorf("progress should go to stderr with --json:\n%s", stderr.String())
+	}
+
+	stdout.Reset()
+	code = run([]string{"--json", "--output", out}, &stdout, &stderr)
+	json.Unmarshal(stdout.Bytes(), &rep)
+	if code != exitUsage || rep.OK || rep.ExitCode != exitUsage || len(rep.Errors) != 1 {
+		t.Errorf("missing --base-url: exit %d, report %+v", code, rep)
+	}
+}
+
+func TestRunQuiet(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{
+		"--quiet", "--base-url", "https://cdn.example", "--output", t.TempDir(),
+		"--item", "item-path=" + writeItemFile(t, "setup.sh"),
+	}, &stdout, &stderr)
+	if code != exitOK || stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Errorf("exit %d, stdout %q, stderr %q", code, stdout.String(), stderr.String())
+	}
+}
+
+func TestRunUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"--base-url", "https://cdn.example"},
+		{"--output", "/tmp", "--base-url", "https://cdn.example"},
+		{"--item", "item-name=no-path"},
+		{"--no-such-flag"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if code := run(args, &stdout, &stderr); code != exitUsage {
+			t.Errorf("run(%q) = %d, want %d", args, code, exitUsage)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// Signature formats written next to bootstrap.json.
+const (
+	// SignDetached writes bootstrap.json.sig: the base64 signature of the
+	// exact bytes of bootstrap.json (Ed25519; ECDSA ASN.1 or RSA PKCS #1
+	// v1.5 over SHA-256)
+	SignDetached = "detached"
+	// SignJWS writes bootstrap.jws: a JWS compact serialization with
+	// bootstrap.json as its payload
+	SignJWS = "jws"
+)
+
+// loadSigningKey reads a PEM private key: PKCS #8, PKCS #1 RSA or SEC 1 EC.
+func loadSigningKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM private key", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, key)
+	}
+	if _, err := jwsAlgorithm(signer); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return signer, nil
+}
+
+// jwsAlgorithm is the JWS "alg" of key.
+func jwsAlgorithm(key crypto.Signer) (string, error) {
+	switch pub := key.Public().(type) {
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return "ES256", nil
+		case elliptic.P384():
+			return "ES384", nil
+		case elliptic.P521():
+			return "ES512", nil
+		}
+		return "", fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+	}
+	return "", fmt.Errorf("unsupported key type %T", key.Public())
+}
+
+// signBytes signs data with the hash the key's JWS algorithm uses. ECDSA
+// signatures are ASN.1, as a detached signature carries them.
+func signBytes(key crypto.Signer, data []byte) ([]byte, error) {
+	alg, err := jwsAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+	switch alg {
+	case "EdDSA":
+		return key.Sign(rand.Reader, data, crypto.Hash(0))
+	case "ES384":
+		digest := sha512.Sum384(data)
+		return key.Sign(rand.Reader, digest[:], crypto.SHA384)
+	case "ES512":
+		digest := sha512.Sum512(data)
+		return key.Sign(rand.Reader, digest[:], crypto.SHA512)
+	}
+	digest := sha256.Sum256(data)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// signDetached returns the content of bootstrap.json.sig.
+func signDetached(key crypto.Signer, bootstrap []byte) ([]byte, error) {
+	signature, err := signBytes(key, bootstrap)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(signature) + "\n"), nil
+}
+
+// signJWS returns the JWS compact serialization of bootstrap; keyID, if
+// set, is its "kid" so clients can pick the key among several.
+func signJWS(key crypto.Signer, keyID string, bootstrap []byte) ([]byte, error) {
+	alg, err := jwsAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid,omitempty"`
+		Cty string `json:"cty"`
+	}{alg, keyID, "json"})
+	if err != nil {
+		return nil, err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(bootstrap)
+
+	signature, err := signBytes(key, []byte(signingInput))
+	if err != nil {
+		return nil, err
+	}
+	if pub, ok := key.Public().(*ecdsa.PublicKey); ok {
+		// JWS carries ECDSA signatures as R || S, not ASN.1
+		if signature, err = ecdsaRawSignature(signature, pub.Curve); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)), nil
+}
+
+// ecdsaRawSignature converts an ASN.1 ECDSA signature to R || S.
+func ecdsaRawSignature(der []byte, curve elliptic.Curve) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, err
+	}
+	if sig.R == nil || sig.S == nil {
+		return nil, errors.New("invalid ECDSA signature")
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	raw := make([]byte, 2*size)
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+	return raw, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeKey(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testKeys(t *testing.T) map[string]string {
+	t.Helper()
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pkcs8 := func(key any) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	ecDER, _ := x509.MarshalECPrivateKey(p384)
+	return map[string]string{
+		"EdDSA": writeKey(t, "PRIVATE KEY", pkcs8(edKey)),
+		"ES256": writeKey(t, "PRIVATE KEY", pkcs8(p256)),
+		"ES384": writeKey(t, "EC PRIVATE KEY", ecDER),
+		"RS256": writeKey(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+	}
+}
+
+// verify checks a signature made by signBytes, with ECDSA in ASN.1 or raw.
+func verify(t *testing.T, pub crypto.PublicKey, data, signature []byte, raw bool) bool {
+	t.Helper()
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, data, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		var digest []byte
+		switch pub.Curve {
+		case elliptic.P256():
+			d := sha256.Sum256(data)
+			digest = d[:]
+		case elliptic.P384():
+			d := sha512.Sum384(data)
+			digest = d[:]
+		}
+		if !raw {
+			return ecdsa.VerifyASN1(pub, digest, signature)
+		}
+		size := len(signature) / 2
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+	}
+	t.Fatalf("unexpected key %T", pub)
+	return false
+}
+
+func TestSignBootstrap(t *testing.T) {
+	bootstrap := []byte(`{"userland":[]}`)
+	for alg, path := range testKeys(t) {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+
+		detached, err := signDetached(key, bootstrap)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(detached)))
+		if err != nil || !verify(t, key.Public(), bootstrap, signature, false) {
+			t.Errorf("%s: detached signature does not verify (%v)", alg, err)
+		}
+
+		jws, err := signJWS(key, "build-2026", bootstrap)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		parts := strings.Split(string(jws), ".")
+		if len(parts) != 3 {
+			t.Fatalf("%s: JWS has %d parts", alg, len(parts))
+		}
+		var header struct{ Alg, Kid string }
+		headerJSON, _ := base64.RawURLEncoding.DecodeString(parts[0])
+		json.Unmarshal(headerJSON, &header)
+		if header.Alg != alg || header.Kid != "build-2026" {
+			t.Errorf("%s: header %s", alg, headerJSON)
+		}
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		if string(payload) != string(bootstrap) {
+			t.Errorf("%s: payload %s", alg, payload)
+		}
+		signature, _ = base64.RawURLEncoding.DecodeString(parts[2])
+		if !verify(t, key.Public(), []byte(parts[0]+"."+parts[1]), signature, true) {
+			t.Errorf("%s: JWS signature does not verify", alg)
+		}
+	}
+}
+
+func TestLoadSigningKeyErrors(t *testing.T) {
+	p224, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(p224)
+	for name, path := range map[string]string{
+		"curve":  writeKey(t, "EC PRIVATE KEY", der),
+		"notkey": writeKey(t, "CERTIFICATE", []byte("junk")),
+		"nopem":  filepath.Join(t.TempDir(), "missing.pem"),
+	} {
+		if _, err := loadSigningKey(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// uploader puts files under a key (a slash-separated path below the
+// destination's prefix) at a hosting endpoint.
+type uploader interface {
+	put(key, filePath, contentType string) error
+}
+
+// newUploader returns the uploader for an --upload destination:
+//
+//	s3://BUCKET/PREFIX            AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN,
+//	                              AWS_REGION (us-east-1), AWS_ENDPOINT_URL for S3-compatible stores
+//	gs://BUCKET/PREFIX            GOOGLE_OAUTH_ACCESS_TOKEN, e.g. from gcloud auth print-access-token
+//	az://ACCOUNT/CONTAINER/PREFIX AZURE_STORAGE_SAS_TOKEN
+//	webdav+https://HOST/PATH      WEBDAV_USERNAME and WEBDAV_PASSWORD, if the server needs them
+func newUploader(dest string) (uploader, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload destination %s: %v", dest, err)
+	}
+	prefix := strings.Trim(u.Path, "/")
+
+	switch u.Scheme {
+	case "s3":
+		up := &s3Uploader{
+			bucket:    u.Host,
+			prefix:    prefix,
+			region:    firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+			accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			token:     os.Getenv("AWS_SESSION_TOKEN"),
+			endpoint:  os.Getenv("AWS_ENDPOINT_URL"),
+		}
+		if up.region == "" {
+			up.region = "us-east-1"
+		}
+		if up.accessKey == "" || up.secretKey == "" {
+			return nil, fmt.Errorf("s3 upload needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		return up, nil
+	case "gs":
+		token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("gs upload needs GOOGLE_OAUTH_ACCESS_TOKEN")
+		}
+		return &bearerUploader{
+			base:  "https://storage.googleapis.com/" + u.Host,
+			path:  prefix,
+			token: token,
+		}, nil
+	case "az":
+		sas := strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?")
+		container, blobPrefix, _ := strings.Cut(prefix, "/")
+		if container == "" {
+			return nil, fmt.Errorf("az upload destination needs a container: az://ACCOUNT/CONTAINER/PREFIX")
+		}
+		if sas == "" {
+			return nil, fmt.Errorf("az upload needs AZURE_STORAGE_SAS_TOKEN")
+		}
+		return &azureUploader{
+			base:   "https://" + u.Host + ".blob.core.windows.net/" + container,
+			prefix: blobPrefix,
+			sas:    sas,
+		}, nil
+	case "webdav+http", "webdav+https":
+		u.Scheme = strings.TrimPrefix(u.Scheme, "webdav+")
+		u.Path = "/" + prefix
+		return &webdavUploader{
+			base:     strings.TrimSuffix(u.String(), "/"),
+			user:     os.Getenv("WEBDAV_USERNAME"),
+			password: os.Getenv("WEBDAV_PASSWORD"),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported upload destination %s (use s3://, gs://, az://, webdav+https://)", dest)
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// uploadKey is where an item's file goes: its URL below base-url, which
+// mirrors the hosting layout. Items hosted elsewhere are not uploaded.
+func uploadKey(itemURL, baseURL string) (string, bool) {
+	rel, ok := strings.CutPrefix(itemURL, strings.TrimSuffix(baseURL, "/")+"/")
+	if !ok || rel == "" {
+		return "", false
+	}
+	if unescaped, err := url.PathUnescape(rel); err == nil {
+		rel = unescaped
+	}
+	return rel, true
+}
+
+// escapeKey escapes each segment of key for a URL path.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// putFile sends the file as the body of a PUT request prepared by prepare
+// and checks for a 2xx response.
+func putFile(client *http.Client, target, filePath, contentType string, prepare func(req *http.Request) error) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, target, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", contentType)
+	if prepare != nil {
+		if err := prepare(req); err != nil {
+			return err
+		}
+	}
+	return doUpload(client, req)
+}
+
+func doUpload(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, redactQuery(req.URL), resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// redactQuery drops the query, which may hold a SAS token, for messages.
+func redactQuery(u *url.URL) string {
+	c := *u
+	c.RawQuery = ""
+	return c.String()
+}
+
+// s3Uploader puts objects with AWS Signature Version 4; the payload is not
+// signed so multi-GB packages are not read twice. A single PUT takes up to
+// 5 GB.
+type s3Uploader struct {
+	bucket, prefix, region string
+	accessKey, secretKey   string
+	token                  string
+	endpoint               string // path-style S3-compatible endpoint; empty for AWS
+	now                    func() time.Time
+}
+
+func (s *s3Uploader) put(key, filePath, contentType string) error {
+	target := "https://" + s.bucket + ".s3." + s.region + ".amazonaws.com/" + escapeKey(path.Join(s.prefix, key))
+	if s.endpoint != "" {
+		target = strings.TrimSuffix(s.endpoint, "/") + "/" + s.bucket + "/" + escapeKey(path.Join(s.prefix, key))
+	}
+	return putFile(http.DefaultClient, target, filePath, contentType, func(req *http.Request) error {
+		now := time.Now
+		if s.now != nil {
+			now = s.now
+		}
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+		if s.token != "" {
+			req.Header.Set("X-Amz-Security-Token", s.token)
+		}
+		signV4(req, s.accessKey, s.secretKey, s.region, "s3", "UNSIGNED-PAYLOAD", now())
+		return nil
+	})
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header covering the
+// host and all headers set on req.
+func signV4(req *http.Request, accessKey, secretKey, region, service, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := q[k]
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// bearerUploader puts objects with an OAuth access token, as the Google
+// Cloud Storage XML API takes them.
+type bearerUploader struct {
+	base, path, token string
+}
+
+func (b *bearerUploader) put(key, filePath, contentType string) error {
+	target := b.base + "/" + escapeKey(path.Join(b.path, key))
+	return putFile(http.DefaultClient, target, filePath, contentType, func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+		return nil
+	})
+}
+
+// azureUploader puts block blobs authorized by a SAS token.
+type azureUploader struct {
+	base, prefix, sas string
+}
+
+func (a *azureUploader) put(key, filePath, contentType string) error {
+	target := a.base + "/" + escapeKey(path.Join(a.prefix, key)) + "?" + a.sas
+	return putFile(http.DefaultClient, target, filePath, contentType, func(req *http.Request) error {
+		req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+		req.Header.Set("X-Ms-Version", "2021-08-06")
+		return nil
+	})
+}
+
+// webdavUploader puts files on a WebDAV server, creating the collections
+// they go in.
+type webdavUploader struct {
+	base, user, password string
+	created              map[string]bool
+}
+
+func (w *webdavUploader) put(key, filePath, contentType string) error {
+	if err := w.mkcol(path.Dir(key)); err != nil {
+		return err
+	}
+	return putFile(http.DefaultClient, w.base+"/"+escapeKey(key), filePath, contentType, func(req *http.Request) error {
+		w.auth(req)
+		return nil
+	})
+}
+
+// mkcol creates dir and its parents; 405 means a collection exists.
+func (w *webdavUploader) mkcol(dir string) error {
+	if dir == "." || dir == "/" || w.created[dir] {
+		return nil
+	}
+	if err := w.mkcol(path.Dir(dir)); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("MKCOL", w.base+"/"+escapeKey(dir)+"/", nil)
+	if err != nil {
+		return err
+	}
+	w.auth(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusMethodNotAllowed {
+		return fmt.Errorf("MKCOL %s: %s", req.URL, resp.Status)
+	}
+	if w.created == nil {
+		w.created = map[string]bool{}
+	}
+	w.created[dir] = true
+	return nil
+}
+
+func (w *webdavUploader) auth(req *http.Request) {
+	if w.user != "" {
+		req.SetBasicAuth(w.user, w.password)
+	}
+}
+
+// uploadArtifacts uploads the files of items hosted below baseURL, then the
+// generated files (bootstrap.json and its signature) by their base names,
+// so the bootstrap never names files that are not there yet. It returns the
+// keys uploaded.
+func uploadArtifacts(up uploader, items ItemList, baseURL string, generated []string, log *logger) ([]string, error) {
+	var uploaded []string
+	for _, item := range items {
+		itemURL := itemDownloadURL(item, baseURL)
+		key, ok := uploadKey(itemURL, baseURL)
+		if !ok {
+			log.Infof("Not uploading %s: %s is not below --base-url", item.Path, itemURL)
+			continue
+		}
+		log.Infof("Uploading %s to %s", item.Path, key)
+		if err := up.put(key, item.Path, "application/octet-stream"); err != nil {
+			return uploaded, fmt.Errorf("uploading %s: %w", item.Path, err)
+		}
+		uploaded = append(uploaded, key)
+	}
+	for _, file := range generated {
+		key := filepath.Base(file)
+		contentType := "application/octet-stream"
+		switch filepath.Ext(file) {
+		case ".json":
+			contentType = "application/json"
+		case ".jws":
+			contentType = "application/jose"
+		}
+		log.Infof("Uploading %s to %s", file, key)
+		if err := up.put(key, file, contentType); err != nil {
+			return uploaded, fmt.Errorf("uploading %s: %w", file, err)
+		}
+		uploaded = append(uploaded, key)
+	}
+	return uploaded, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-installapplications/pkg/config"
+)
+
+// runValidate lints bootstrap files with the checks the client runs when it
+// loads one, plus keys the client would silently ignore. It returns the
+// exit code: 0 when every file is valid.
+func runValidate(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(out, "Usage: generatejson validate bootstrap.json [...]")
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	code := 0
+	for _, path := range fs.Args() {
+		problems := validateBootstrapFile(path)
+		if len(problems) == 0 {
+			fmt.Fprintf(out, "%s: OK\n", path)
+			continue
+		}
+		code = 1
+		for _, problem := range problems {
+			fmt.Fprintf(out, "%s: %s\n", path, problem)
+		}
+	}
+	return code
+}
+
+// validateBootstrapFile returns the problems of the bootstrap at path.
+func validateBootstrapFile(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return []string{fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	problems = append(problems, unknownBootstrapKeys(raw)...)
+
+	var bootstrap config.Bootstrap
+	if err := json.Unmarshal(data, &bootstrap); err != nil {
+		return append(problems, err.Error())
+	}
+	if err := config.ValidateBootstrap(&bootstrap); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+// validateOutput checks a generated bootstrap as the client will when it
+// loads it, e.g. fail_policy values, run_as and file modes.
+func validateOutput(output JSONOutput) error {
+	data, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	var bootstrap config.Bootstrap
+	if err := json.Unmarshal(data, &bootstrap); err != nil {
+		return err
+	}
+	return config.ValidateBootstrap(&bootstrap)
+}
+
+// bootstrapPhases are the item lists of a bootstrap.
+var bootstrapPhases = []string{"preflight", "setupassistant", "userland"}
+
+// unknownBootstrapKeys reports the keys of the bootstrap, its items and
+// groups that the client does not know.
+func unknownBootstrapKeys(raw map[string]json.RawMessage) []string {
+	topKeys := jsonKeys(reflect.TypeOf(config.Bootstrap{}))
+	itemKeys := jsonKeys(reflect.TypeOf(config.Item{}))
+	itemKeys["required"] = true // accepted for pkg_required
+	groupKeys := jsonKeys(reflect.TypeOf(config.Group{}))
+
+	var problems []string
+	for _, key := range sortedKeys(raw) {
+		if !topKeys[key] {
+			problems = append(problems, fmt.Sprintf("unknown key %q", key))
+		}
+	}
+
+	checkItems := func(where string, entries []map[string]json.RawMessage) {
+		for n, entry := range entries {
+			name := fmt.Sprintf("%s[%d]", where, n)
+			if _, group := entry["items"]; group {
+				for _, key := range sortedKeys(entry) {
+					if !groupKeys[key] {
+						problems = append(problems, fmt.Sprintf("%s: unknown group key %q", name, key))
+					}
+				}
+				var members []map[string]json.RawMessage
+				if json.Unmarshal(entry["items"], &members) == nil {
+					for m, member := range members {
+						for _, key := range sortedKeys(member) {
+							if !itemKeys[key] {
+								problems = append(problems, fmt.Sprintf("%s.items[%d]: unknown item key %q", name, m, key))
+							}
+						}
+					}
+				}
+				continue
+			}
+			for _, key := range sortedKeys(entry) {
+				if !itemKeys[key] {
+					problems = append(problems, fmt.Sprintf("%s: unknown item key %q", name, key))
+				}
+			}
+		}
+	}
+
+	for _, phase := range bootstrapPhases {
+		var entries []map[string]json.RawMessage
+		if json.Unmarshal(raw[phase], &entries) == nil {
+			checkItems(phase, entries)
+		}
+	}
+	var hooks map[string][]map[string]json.RawMessage
+	if json.Unmarshal(raw["hooks"], &hooks) == nil {
+		hookKeys := jsonKeys(reflect.TypeOf(config.Hooks{}))
+		for _, key := range sortedKeys(hooks) {
+			if !hookKeys[key] {
+				problems = append(problems, fmt.Sprintf("hooks: unknown key %q", key))
+				continue
+			}
+			checkItems("hooks."+key, hooks[key])
+		}
+	}
+	return problems
+}
+
+// jsonKeys returns the JSON names of t's fields.
+func jsonKeys(t reflect.Type) map[string]bool {
+	keys := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeBootstrap(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidateBootstrapFile(t *testing.T) {
+	valid := writeBootstrap(t, "valid.json", `{
+		"setupassistant": [{"name": "App", "type": "package", "file": "/Library/go-installapplications/app.pkg", "url": "https://x/app.pkg", "hash": "abc", "required": true}],
+		"userland": [{"group": "browsers", "items": [{"name": "Chrome", "type": "package", "file": "/tmp/c.pkg", "url": "https://x/c.pkg"}]}]
+	}`)
+	if problems := validateBootstrapFile(valid); len(problems) != 0 {
+		t.Errorf("valid bootstrap: %q", problems)
+	}
+
+	invalid := writeBootstrap(t, "invalid.json", `{
+		"preflight": [{"name": "pkg", "type": "package", "file": "/tmp/a.pkg", "url": "https://x/a.pkg"}],
+		"userland": [
+			{"name": "a", "type": "rootscript", "file": "/tmp/a.sh", "url": "https://x/a.sh", "retrys": 3},
+			{"group": "g", "paralel": true, "items": [{"name": "b", "type": "rootscript", "file": "/tmp/b.sh", "url": "https://x/b.sh", "hsah": ""}]}
+		],
+		"hooks": {"pre_usrland": []},
+		"setup_assistant": []
+	}`)
+	want := []string{
+		`unknown key "setup_assistant"`,
+		`userland[0]: unknown item key "retrys"`,
+		`userland[1]: unknown group key "paralel"`,
+		`userland[1].items[0]: unknown item key "hsah"`,
+		`hooks: unknown key "pre_usrland"`,
+		"preflight phase only supports rootscript type, got: package",
+	}
+	if problems := validateBootstrapFile(invalid); !reflect.DeepEqual(problems, want) {
+		t.Errorf("problems =\n%s\nwant\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+	}
+
+	var out bytes.Buffer
+	if code := runValidate([]string{valid, invalid}, &out); code != 1 {
+		t.Errorf("runValidate = %d, want 1", code)
+	}
+	if !strings.Contains(out.String(), valid+": OK") || !strings.Contains(out.String(), invalid+`: unknown key "setup_assistant"`) {
+		t.Errorf("output:\n%s", out.String())
+	}
+}
+
+func TestDiffBootstraps(t *testing.T) {
+	old := writeBootstrap(t, "old.json", `{
+		"setupassistant": [
+			{"name": "Chrome", "type": "package", "file": "/tmp/c.pkg", "url": "https://x/c.pkg", "version": "120.0"},
+			{"name": "Zoom", "type": "package", "file": "/tmp/z.pkg", "url": "https://x/z.pkg"},
+			{"name": "Dock", "type": "rootscript", "file": "/tmp/d.sh", "content": "echo 1"}
+		],
+		"userland": [
+			{"name": "a", "type": "userscript", "file": "/tmp/a.sh", "url": "https://x/a.sh"},
+			{"name": "b", "type": "userscript", "file": "/tmp/b.sh", "url": "https://x/b.sh"}
+		]
+	}`)
+	new := writeBootstrap(t, "new.json", `{
+		"setupassistant": [
+			{"name": "Chrome", "type": "package", "file": "/tmp/c.pkg", "url": "https://x/c.pkg", "version": "121.0", "retries": 3},
+			{"name": "Slack", "type": "package", "file": "/tmp/s.pkg", "url": "https://x/s.pkg"}
+		],
+		"userland": [
+			{"name": "b", "type": "userscript", "file": "/tmp/b.sh", "url": "https://x/b.sh"},
+			{"name": "a", "type": "userscript", "file": "/tmp/a.sh", "url": "https://x/a.sh"},
+			{"name": "Dock", "type": "rootscript", "file": "/tmp/d.sh", "content": "echo 2"}
+		]
+	}`)
+
+	var out bytes.Buffer
+	if code := runDiff([]string{old, new}, &out); code != 1 {
+		t.Errorf("runDiff = %d, want 1", code)
+	}
+	want := `- setupassistant: Zoom (removed)
+~ setupassistant: Chrome: version "120.0" -> "121.0"
+~ setupassistant: Chrome: retries (none) -> 3
++ setupassistant: Slack (added)
+~ Dock: moved from setupassistant to userland
+~ userland: Dock: content changed
+~ userland: order a, b -> b, a
+`
+	if out.String() != want {
+		t.Errorf("diff =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if code := runDiff([]string{old, old}, &out); code != 0 || out.String() != "No changes\n" {
+		t.Errorf("runDiff(old, old) = %d, %q", code, out.String())
+	}
+	if code := runDiff([]string{old}, &out); code != 2 {
+		t.Errorf("runDiff with one file = %d, want 2", code)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"compress/bzip2"
+	"compress/zlib"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// xarMagic starts every xar archive, such as a flat package.
+const xarMagic = "xar!"
+
+// xarHeader is the fixed part of a xar header; HeaderSize may be larger.
+type xarHeader struct {
+	Magic                 [4]byte
+	HeaderSize            uint16
+	Version               uint16
+	TOCLengthCompressed   uint64
+	TOCLengthUncompressed uint64
+	ChecksumAlgorithm     uint32
+}
+
+// xarArchive is a xar archive read without /usr/bin/xar, so packages can be
+// inspected on machines other than Macs.
+type xarArchive struct {
+	f       *os.File
+	heap    int64              // offset of the heap, which file data is relative to
+	entries map[string]xarFile // by path, e.g. "Payload.pkg/PackageInfo"
+	names   []string           // paths in table of contents order
+}
+
+type xarFile struct {
+	Name  string    `xml:"name"`
+	Type  string    `xml:"type"`
+	Data  *xarData  `xml:"data"`
+	Files []xarFile `xml:"file"`
+}
+
+type xarData struct {
+	Offset   int64 `xml:"offset"`
+	Length   int64 `xml:"length"`
+	Size     int64 `xml:"size"`
+	Encoding struct {
+		Style string `xml:"style,attr"`
+	} `xml:"encoding"`
+	ExtractedChecksum struct {
+		Style string `xml:"style,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"extracted-checksum"`
+}
+
+type xarTOC struct {
+	Files []xarFile `xml:"toc>file"`
+}
+
+// openXar reads the table of contents of the xar archive at name.
+func openXar(name string) (*xarArchive, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	a, err := readXar(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return a, nil
+}
+
+func readXar(f *os.File) (*xarArchive, error) {
+	var h xarHeader
+	if err := binary.Read(f, binary.BigEndian, &h); err != nil {
+		return nil, fmt.Errorf("not a xar archive: %w", err)
+	}
+	if string(h.Magic[:]) != xarMagic {
+		return nil, errors.New("not a xar archive")
+	}
+	if _, err := f.Seek(int64(h.HeaderSize), io.SeekStart); err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(io.LimitReader(f, int64(h.TOCLengthCompressed)))
+	if err != nil {
+		return nil, fmt.Errorf("table of contents: %w", err)
+	}
+	defer zr.Close()
+	var toc xarTOC
+	if err := xml.NewDecoder(zr).Decode(&toc); err != nil {
+		return nil, fmt.Errorf("table of contents: %w", err)
+	}
+
+	a := &xarArchive{
+		f:       f,
+		heap:    int64(h.HeaderSize) + int64(h.TOCLengthCompressed),
+		entries: map[string]xarFile{},
+	}
+	a.add("", toc.Files)
+	return a, nil
+}
+
+func (a *xarArchive) add(dir string, files []xarFile) {
+	for _, file := range files {
+		name := path.Join(dir, file.Name)
+		a.entries[name] = file
+		a.names = append(a.names, name)
+		a.add(name, file.Files)
+	}
+}
+
+// Close closes the archive file.
+func (a *xarArchive) Close() error {
+	return a.f.Close()
+}
+
+// Names returns the paths of the archive's files and directories.
+func (a *xarArchive) Names() []string {
+	return a.names
+}
+
+// Has reports whether the archive has a file or directory at name.
+func (a *xarArchive) Has(name string) bool {
+	_, ok := a.entries[name]
+	return ok
+}
+
+// ReadFile returns the extracted content of the file at name, checked
+// against its extracted checksum.
+func (a *xarArchive) ReadFile(name string) ([]byte, error) {
+	file, ok := a.entries[name]
+	if !ok || file.Type != "file" || file.Data == nil {
+		return nil, fmt.Errorf("%s: no such file in archive", name)
+	}
+	data := file.Data
+
+	var r io.Reader = io.NewSectionReader(a.f, a.heap+data.Offset, data.Length)
+	switch data.Encoding.Style {
+	case "", "application/octet-stream":
+	case "application/x-gzip": // xar's name for a zlib stream
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		defer zr.Close()
+		r = zr
+	case "application/x-bzip2":
+		r = bzip2.NewReader(r)
+	default:
+		return nil, fmt.Errorf("%s: unsupported encoding %s", name, data.Encoding.Style)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(r, data.Size+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if int64(len(content)) != data.Size {
+		return nil, fmt.Errorf("%s: extracted %d bytes, want %d", name, len(content), data.Size)
+	}
+	if err := checkXarChecksum(content, data.ExtractedChecksum.Style, data.ExtractedChecksum.Value); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return content, nil
+}
+
+// checkXarChecksum compares content to an extracted-checksum; unknown
+// styles are not checked.
+func checkXarChecksum(content []byte, style, want string) error {
+	var h hash.Hash
+	switch strings.ToLower(style) {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil
+	}
+	h.Write(content)
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, strings.TrimSpace(want)) {
+		return fmt.Errorf("%s checksum mismatch", style)
+	}
+	return nil
+}
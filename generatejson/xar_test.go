@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// xarEntry is a file of a test archive; a name with a slash is put in a
+// directory.
+type xarEntry struct {
+	name    string
+	content string
+}
+
+// writeXar writes a xar archive with zlib-encoded files, as pkgbuild and
+// productbuild do, and returns its path.
+func writeXar(t *testing.T, name string, entries []xarEntry) string {
+	t.Helper()
+	var heap bytes.Buffer
+	var toc strings.Builder
+	toc.WriteString(`<?xml version="1.0" encoding="UTF-8"?><xar><toc>`)
+	id := 0
+	dir := ""
+	for _, e := range entries {
+		d, base := filepath.Split(e.name)
+		d = strings.TrimSuffix(d, "/")
+		if d != dir {
+			if dir != "" {
+				toc.WriteString(`</file>`)
+			}
+			if d != "" {
+				id++
+				fmt.Fprintf(&toc, `<file id="%d"><name>%s</name><type>directory</type>`, id, d)
+			}
+			dir = d
+		}
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write([]byte(e.content))
+		zw.Close()
+		sum := sha1.Sum([]byte(e.content))
+		id++
+		fmt.Fprintf(&toc, `<file id="%d"><name>%s</name><type>file</type><data>`+
+			`<offset>%d</offset><length>%d</length><size>%d</size>`+
+			`<encoding style="application/x-gzip"/><extracted-checksum style="sha1">%s</extracted-checksum>`+
+			`</data></file>`, id, base, heap.Len(), compressed.Len(), len(e.content), hex.EncodeToString(sum[:]))
+		heap.Write(compressed.Bytes())
+	}
+	if dir != "" {
+		toc.WriteString(`</file>`)
+	}
+	toc.WriteString(`</toc></xar>`)
+
+	var tocCompressed bytes.Buffer
+	zw := zlib.NewWriter(&tocCompressed)
+	zw.Write([]byte(toc.String()))
+	zw.Close()
+
+	var out bytes.Buffer
+	h := xarHeader{
+		HeaderSize:            28,
+		Version:               1,
+		TOCLengthCompressed:   uint64(tocCompressed.Len()),
+		TOCLengthUncompressed: uint64(toc.Len()),
+	}
+	copy(h.Magic[:], xarMagic)
+	binary.Write(&out, binary.BigEndian, h)
+	out.Write(tocCompressed.Bytes())
+	out.Write(heap.Bytes())
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestXarReadFile(t *testing.T) {
+	path := writeXar(t, "app.pkg", []xarEntry{
+		{"Distribution", "<installer-gui-script/>"},
+		{"app.pkg/PackageInfo", `<pkg-info identifier="com.example.app" version="1.2"/>`},
+		{"app.pkg/Scripts", "scripts"},
+	})
+	a, err := openXar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	want := []string{"Distribution", "app.pkg", "app.pkg/PackageInfo", "app.pkg/Scripts"}
+	if !reflect.DeepEqual(a.Names(), want) {
+		t.Errorf("Names() = %q, want %q", a.Names(), want)
+	}
+	got, err := a.ReadFile("app.pkg/Scripts")
+	if err != nil || string(got) != "scripts" {
+		t.Errorf("ReadFile = %q, %v", got, err)
+	}
+	if _, err := a.ReadFile("app.pkg"); err == nil {
+		t.Errorf("reading a directory should fail")
+	}
+
+	// corrupt the checksum
+	a.entries["Distribution"].Data.ExtractedChecksum.Value = strings.Repeat("0", 40)
+	if _, err := a.ReadFile("Distribution"); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("checksum mismatch: %v", err)
+	}
+}
+
+func TestOpenXarRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not.pkg")
+	os.WriteFile(path, []byte("PK\x03\x04 not a xar archive at all"), 0644)
+	if _, err := openXar(path); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestGetPkgInfo(t *testing.T) {
+	path := writeXar(t, "component.pkg", []xarEntry{
+		{"Bom", "bom"},
+		{"PackageInfo", `<pkg-info format-version="2" identifier="com.example.tool" version="3.0.1"/>`},
+	})
+	id, version, err := getPkgInfo(path, testLogger())
+	if id != "com.example.tool" || version != "3.0.1" || err != nil {
+		t.Errorf("getPkgInfo = %q, %q, %v", id, version, err)
+	}
+}
+
+func TestGetPkgInfoDistribution(t *testing.T) {
+	components := []xarEntry{
+		{"a.pkg/PackageInfo", `<pkg-info identifier="com.example.a" version="1.0"/>`},
+		{"b.pkg/PackageInfo", `<pkg-info identifier="com.example.b" version="2.0"/>`},
+	}
+	for _, c := range []struct {
+		name, distribution, wantID, wantVersion string
+	}{
+		{
+			"product",
+			`<installer-gui-script minSpecVersion="2">
+				<product id="com.example.suite" version="5.1"/>
+				<pkg-ref id="com.example.a" version="1.0">#a.pkg</pkg-ref>
+				<choice id="default"><pkg-ref id="com.example.a"/></choice>
+			</installer-gui-script>`,
+			"com.example.suite", "5.1",
+		},
+		{
+			"pkg-ref",
+			`<installer-gui-script minSpecVersion="2">
+				<pkg-ref id="com.example.b"/>
+				<choices-outline><line choice="default"/></choices-outline>
+				<pkg-ref id="com.example.b" version="2.0" onConclusion="none">#b.pkg</pkg-ref>
+			</installer-gui-script>`,
+			"com.example.b", "2.0",
+		},
+		{
+			"unusable",
+			`<installer-gui-script minSpecVersion="2"><title>Suite</title></installer-gui-script>`,
+			"com.example.a", "1.0",
+		},
+	} {
+		path := writeXar(t, c.name+".pkg", append([]xarEntry{{"Distribution", c.distribution}}, components...))
+		id, version, err := getPkgInfo(path, testLogger())
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if id != c.wantID || version != c.wantVersion {
+			t.Errorf("%s: getPkgInfo = %q, %q, want %q, %q", c.name, id, version, c.wantID, c.wantVersion)
+		}
+	}
+}
@@ -2,4 +2,7 @@ module github.com/go-installapplications
 
 go 1.21
 
-require howett.net/plist v1.0.1
+require (
+	gopkg.in/yaml.v3 v3.0.1
+	howett.net/plist v1.0.1
+)
@@ -6,14 +6,31 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-installapplications/pkg/audit"
 	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/ipc"
 	"github.com/go-installapplications/pkg/mode"
 	"github.com/go-installapplications/pkg/retry"
 	"github.com/go-installapplications/pkg/utils"
+	"github.com/go-installapplications/pkg/version"
 )
 
+// validatedModes refuse to start when ValidateSettings finds a problem.
+var validatedModes = map[string]bool{
+	"daemon":           true,
+	"standalone":       true,
+	"agent-standalone": true,
+	"simulate":         true,
+	"remediate":        true,
+	"webhook":          true,
+	"install":          true,
+}
+
 func main() {
 	// Normalize boolean flags so forms like "--reboot false" are treated as "--reboot=false"
 	os.Args = utils.NormalizeBooleanFlags(os.Args, map[string]struct{}{
@@ -21,12 +38,23 @@ func main() {
 		"verbose":                    {},
 		"reboot":                     {},
 		"cleanup-on-failure":         {},
+		"rollback-on-failure":        {},
 		"keep-failed-files":          {},
 		"dry-run":                    {},
+		"compat":                     {},
 		"track-background-processes": {},
 		"reset-retries":              {},
 		"with-preflight":             {},
 		"no-restart-on-error":        {},
+		"swiftdialog":                {},
+		"jamf-recon":                 {},
+		"keep-logs":                  {},
+		"json":                       {},
+		"version":                    {},
+		"wait-for-desktop":           {},
+		"verify-package-signatures":  {},
+		"print-config":               {},
+		"strict-profile":             {},
 	})
 
 	// Create a new config with defaults
@@ -36,31 +64,49 @@ func main() {
 	jsonURL := flag.String("jsonurl", "", "URL to bootstrap JSON file")
 	installPath := flag.String("installpath", "", "Installation path (default: /Library/go-installapplications)")
 	iapath := flag.String("iapath", "", "Install path (same as --installpath, e.g. /Library/installapplications)")
-	compat := flag.Bool("compat", false, "Use /Library/installapplications as install path. Mutually exclusive with --installpath")
+	compat := flag.Bool("compat", false, "Use the original InstallApplications install path, identifiers, preference domain and state folder. Mutually exclusive with --installpath")
 	debug := flag.Bool("debug", false, "Enable debug logging (default: false)")
 	verbose := flag.Bool("verbose", false, "Enable verbose logging (default: false)")
 	reboot := flag.Bool("reboot", false, "Reboot after completion (default: false)")
+	rebootDelay := flag.Int("reboot-delay", 5, "Seconds between the reboot warning and the reboot")
+	rebootMessage := flag.String("reboot-message", "", "Message shown to the console user before the reboot; {{delay}} is replaced by the delay (default: none)")
+	rebootMethod := flag.String("reboot-method", "", "How to reboot: shutdown, or mdm for a RestartDevice command through the Jamf Pro API (default: shutdown)")
+	rebootCondition := flag.String("reboot-condition", "", "When to reboot: always, installed to skip the reboot when every item was skipped, or required to reboot only when a package requires it (default: always)")
 
 	maxRetries := flag.Int("max-retries", 3, "Maximum number of retries for failed installs")
 	retryDelay := flag.Int("retry-delay", 5, "Delay between retries in seconds")
+	daemonMaxRetries := flag.Int("daemon-max-retries", 3, "Daemon attempts allowed before it gives up (0 = no limit)")
+	daemonRetryLimits := flag.String("daemon-retry-limits", "", "Per-category daemon retry limits replacing --daemon-max-retries, e.g. network=0,validation=1 (categories: network, validation, install, ipc; 0 = no limit)")
+	retryStatePath := flag.String("retry-state-path", "", "File holding the daemon attempt count (default: per profile domain under /var/tmp/go-installapplications)")
 
 	cleanupOnFailure := flag.Bool("cleanup-on-failure", true, "Cleanup on failure (default: true, set to false to disable)")
+	rollbackOnFailure := flag.Bool("rollback-on-failure", false, "Undo placed files and run item rollback commands when the run fails (default: false)")
 	cleanupOnSuccess := flag.Bool("cleanup-on-success", true, "Cleanup on success (default: true, set to false to disable)")
 	keepFailedFiles := flag.Bool("keep-failed-files", false, "Keep failed files (default: false, set to true to keep)")
+	cleanupScope := flag.String("cleanup-scope", "", "Comma-separated list of what cleanup removes: downloads, install_path, launchd, retry_state, logs (default: downloads,install_path,launchd)")
+	cleanupPreserve := flag.String("cleanup-preserve", "", "Comma-separated absolute paths cleanup never removes")
 
 	dryRun := flag.Bool("dry-run", false, "Dry run - don't actually install anything (default: false)")
 
 	trackBgProcesses := flag.Bool("track-background-processes", false, "Track and wait for background processes (default: false, set to true to enable)")
 	backgroundTimeout := flag.Int("background-timeout", 300, "Timeout for background processes in seconds")
+	phaseTimeout := flag.Int("phase-timeout", 0, "Fail a phase that runs longer than this many seconds (0 = no limit)")
+	runDeadline := flag.Int("run-deadline", 0, "Fail the run if it takes longer than this many seconds (0 = no limit)")
+	remediationInterval := flag.Int("remediation-interval", 0, "After a successful daemon run, re-check installed items every this many seconds and reinstall drifted ones (0 = off)")
+	remediationPath := flag.String("remediation-path", "/Library/go-installapplications-remediation", "Where the remediation job keeps its binary and bootstrap")
 
-	modeFlag := flag.String("mode", "", "Operating mode: daemon, agent, standalone (default: standalone)")
+	modeFlag := flag.String("mode", "", "Operating mode: daemon, agent, standalone, agent-standalone, webhook, install, uninstall, status, plists, healthcheck, simulate, remediate (default: standalone)")
 	resetRetries := flag.Bool("reset-retries", false, "Clear retry state before running (useful for testing)")
 	profileDomain := flag.String("profile-domain", config.DefaultProfileDomain, "macOS preference domain to read from")
 
 	// Download and IPC settings
 	downloadMaxConcurrency := flag.Int("download-max-concurrency", 4, "Maximum concurrent downloads")
+	installMaxConcurrency := flag.Int("install-max-concurrency", 4, "Maximum items of a parallel batch installed at once (0 = no limit)")
 	waitForAgentTimeout := flag.Int("wait-for-agent-timeout", 86400, "How long daemon waits for agent socket (seconds)")
 	agentRequestTimeout := flag.Int("agent-request-timeout", 7200, "Timeout per agent RPC request (seconds)")
+	userlandGatePolicy := flag.String("userland-gate-policy", "", "What daemon userland waits for: agent (default), login, deadline")
+	waitForDesktop := flag.Bool("wait-for-desktop", false, "Hold daemon user items until the user's first login reaches the desktop (default: false)")
+	userlandGateDeadline := flag.Int("userland-gate-deadline", 3600, "Deadline policy: how long to wait for the agent before running user items via launchctl asuser (seconds)")
 
 	// Compat flags
 	followRedirects := flag.Bool("follow-redirects", false, "Follow HTTP redirects (default: false)")
@@ -74,6 +120,8 @@ func main() {
 	httpAuthPassword := flag.String("http-auth-password", "", "HTTP Basic Auth password")
 
 	hashCheckPolicy := flag.String("hash-check-policy", "", "Hash check policy: Strict (require hash, fail on mismatch), Warning (accept missing, fail on mismatch — default), Ignore (accept missing and mismatches)")
+	verifyPackageSignatures := flag.Bool("verify-package-signatures", false, "Check every package's signature and notarization before installing it (default: false)")
+	allowedTeamIDs := flag.String("allowed-team-ids", "", "Comma-separated Team IDs packages must be signed by when their signature is verified")
 
 	// Remote logging NOT YET IMPLEMENTED
 	// logDestination := flag.String("log-destination", "", "Remote log destination URL (optional)")
@@ -81,21 +129,75 @@ func main() {
 	// var logHeaders utils.MultiValueHeader
 	// flag.Var(&logHeaders, "log-header", "Header for remote logs in Name=Value form (repeatable)")
 	logFilePath := flag.String("log-file", "", "Force logs to also go to this file (in addition to console)")
+	logLevels := flag.String("log-levels", "", "Per-component log levels replacing --debug/--verbose for that component, e.g. download=debug,ipc=error (components: download, installer, ipc, manager; levels: debug, verbose, info, error)")
 
 	retainLogFiles := flag.Bool("retain-log-files", false, "Retain log files from previous runs (default: false, set to true to retain)")
+	keepLogs := flag.Bool("keep-logs", false, "Uninstall mode: keep log files and the audit log (default: false)")
+	statusJSON := flag.Bool("json", false, "Status mode and dry runs: print the report or plan as JSON (default: false)")
+	statusLines := flag.Int("lines", 20, "Status mode: number of lines shown from the end of each log")
+	plistOutputDir := flag.String("output-dir", ".", "Plists mode: directory to write the generated launchd plists to")
+	onlyPhase := flag.String("only-phase", "", "Run only this phase: preflight, setupassistant or userland")
+	onlyItems := flag.String("only-item", "", "Comma-separated item names to run; all other items are left out")
+	simulationFile := flag.String("simulation", "", "Simulate mode: JSON file with item durations and injected failures")
 
 	withPreflight := flag.Bool("with-preflight", false, "Run preflight phase in standalone mode (default: false, standalone skips preflight by default)")
-	noRestartOnError := flag.Bool("no-restart-on-error", false, "Exit with code 0 on errors to prevent daemon restart (default: false)")
+	noRestartOnError := flag.Bool("no-restart-on-error", false, "Exit with code 0 on errors to prevent a launchd restart in daemon mode (default: false)")
+
+	// Progress UI
+	swiftDialog := flag.Bool("swiftdialog", false, "Show install progress in swiftDialog (default: false)")
+
+	// Metrics
+	metricsListen := flag.String("metrics-listen", "", "Serve Prometheus metrics on this address during the run (e.g. 127.0.0.1:9464)")
+	metricsPushURL := flag.String("metrics-push-url", "", "Push metrics to this Pushgateway/OTLP URL at run end")
+	metricsPushFormat := flag.String("metrics-push-format", "", "Metrics push format: pushgateway (default) or otlp")
+
+	// Tracing
+	tracingEndpoint := flag.String("tracing-endpoint", "", "Export OpenTelemetry spans to this OTLP/HTTP traces URL (e.g. http://localhost:4318/v1/traces)")
+
+	// Status reporting
+	statusURL := flag.String("status-url", "", "PUT per-item and final status JSON to this URL")
+	statusPlist := flag.String("status-plist", "", "Write completion status and per-item results to this plist (default: /Library/Preferences/com.github.go-installapplications.status.plist, empty to disable)")
+
+	// Jamf Pro
+	jamfRecon := flag.Bool("jamf-recon", false, "Run jamf recon after a successful bootstrap (default: false)")
+	jamfPolicyEvents := flag.String("jamf-policy-events", "", "Comma-separated custom triggers to run with jamf policy -event after a successful bootstrap")
+
+	// Webhook mode
+	webhookListen := flag.String("webhook-listen", "", "Address for webhook mode to listen on (e.g. :8443)")
+	webhookProfileDomain := flag.String("webhook-profile-domain", "", "Profile domain for runs started by webhook mode (default: --profile-domain)")
+
+	// Audit
+	auditLog := flag.String("audit-log", "", "Append-only audit log of privileged actions (default: /var/log/go-installapplications/audit.log, empty to disable)")
+
+	showVersion := flag.Bool("version", false, "Print version and build information, then exit")
+	strictProfile := flag.Bool("strict-profile", false, "Stop with a configuration error when the profile has keys that name no setting (default: false, warn and ignore them)")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration with the source of each value (default, profile, env, flag), check it and exit (with --json: as JSON)")
 
 	// Parse the command-line arguments
 	flag.Parse()
 
-	// Handle retry reset first if requested
-	if *resetRetries {
-		if err := retry.ClearRetryCount(); err != nil {
-			fmt.Printf("Warning: failed to clear retry state: %v\n", err)
-		} else {
-			fmt.Printf("Retry state cleared\n")
+	if *showVersion {
+		fmt.Println(version.Get())
+		os.Exit(0)
+	}
+
+	// Snapshots after each source, for --print-config
+	layers := []mode.ConfigLayer{{Source: "default", Settings: cfg.RedactedForLogging()}}
+
+	// Create a map to track which flags were explicitly set
+	flagsSet := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		flagsSet[f.Name] = true
+	})
+
+	// Compat mode changes where state lives and which profile is read, so it
+	// applies before either is touched
+	domain := *profileDomain
+	if *compat {
+		cfg.ApplyCompat()
+		ipc.SetSocketDir(config.CompatSocketDir)
+		if !flagsSet["profile-domain"] {
+			domain = config.CompatIdentifier
 		}
 	}
 
@@ -103,45 +205,84 @@ func main() {
 	if *modeFlag != "" {
 		cfg.Mode = *modeFlag
 	}
+	cfg.ProfileDomain = domain
 
-	// Check for required privileges early
-	if (cfg.Mode == "standalone" || cfg.Mode == "daemon") && !utils.IsRootUser() {
+	// Check for required privileges early; --print-config only reads
+	if !*printConfig && (cfg.Mode == "standalone" || cfg.Mode == "daemon" || cfg.Mode == "webhook" || cfg.Mode == "install" || cfg.Mode == "uninstall") && !utils.IsRootUser() {
 		fmt.Printf("Error: %s mode requires root privileges (sudo)\n", cfg.Mode)
 		fmt.Printf("Please run with: sudo ./go-installapplications --mode %s [other options]\n", cfg.Mode)
-		os.Exit(1)
+		os.Exit(int(utils.ExitConfig))
 	}
 
+	// agent-standalone runs the user's items as that user
+	if !*printConfig && cfg.Mode == "agent-standalone" && utils.IsRootUser() {
+		fmt.Println("Error: agent-standalone mode runs as the logged-in user; run it without sudo")
+		os.Exit(int(utils.ExitConfig))
+	}
+
+	// --mode, --profile-domain and --compat were applied above, before the
+	// profile is read
+	layers = append(layers, mode.ConfigLayer{Source: "flag", Settings: cfg.RedactedForLogging()})
+
 	// Try to read from mobile config with graceful fallback
 	var profileResult *config.ProfileResult
-	if result, err := cfg.ReadFromProfile(*profileDomain); err != nil {
+	var readProblems []string
+	if result, err := cfg.ReadFromProfile(domain); err != nil {
 		// Mobile config reading failed - log and continue with defaults
 		profileResult = &config.ProfileResult{ConfigFound: false, BootstrapSource: "none"}
-		fmt.Printf("Warning: mobile config reading failed (continuing with defaults): %v\n", err)
+		readProblems = append(readProblems, fmt.Sprintf("mobile config reading failed: %v", err))
+		if !*printConfig {
+			fmt.Printf("Warning: mobile config reading failed (continuing with defaults): %v\n", err)
+		}
 	} else {
 		profileResult = result
+		for _, problem := range result.UnknownKeyProblems() {
+			readProblems = append(readProblems, problem)
+			if !*printConfig {
+				fmt.Printf("Warning: %s; ignoring it\n", problem)
+			}
+		}
 	}
+	layers = append(layers, mode.ConfigLayer{Source: "profile", Settings: cfg.RedactedForLogging()})
 
-	// Create a map to track which flags were explicitly set
-	flagsSet := make(map[string]bool)
-	flag.Visit(func(f *flag.Flag) {
-		flagsSet[f.Name] = true
-	})
+	// configProblem stops the run on an invalid flag or setting; with
+	// --print-config it is collected and reported with the rest
+	configProblem := func(problem string) {
+		if *printConfig {
+			readProblems = append(readProblems, problem)
+			return
+		}
+		fmt.Printf("Error: %s\n", problem)
+		os.Exit(int(utils.ExitConfig))
+	}
+
+	// GIA_* environment variables override the profile; flags override both
+	if unknown, err := cfg.ApplyEnvironment(os.Environ()); err != nil {
+		configProblem(err.Error())
+	} else {
+		for _, name := range unknown {
+			readProblems = append(readProblems, name+" is not a known setting")
+			if !*printConfig {
+				fmt.Printf("Warning: %s is not a known setting; ignoring it\n", name)
+			}
+		}
+	}
+	layers = append(layers, mode.ConfigLayer{Source: "env", Settings: cfg.RedactedForLogging()})
 
 	// Only override mobile config with command line flags that were explicitly set
 	if flagsSet["jsonurl"] {
 		// Command-line should take precedence over embedded profile settings
-		if profileResult.BootstrapSource == "embedded" {
+		if profileResult.BootstrapSource == "embedded" && !*printConfig {
 			fmt.Printf("Warning: --jsonurl overrides embedded bootstrap section from mobile config\n")
 		}
 		cfg.JSONURL = *jsonURL
 	}
 	// Handle compatibility and install path
 	if flagsSet["compat"] && flagsSet["installpath"] {
-		fmt.Println("Error: --compat cannot be used together with --installpath; choose one")
-		os.Exit(1)
+		configProblem("--compat cannot be used together with --installpath; choose one")
 	}
 	if flagsSet["compat"] && *compat {
-		cfg.InstallPath = "/Library/installapplications"
+		cfg.InstallPath = config.CompatInstallPath
 	} else if flagsSet["installpath"] {
 		cfg.InstallPath = *installPath
 	}
@@ -157,15 +298,70 @@ func main() {
 	if flagsSet["log-file"] {
 		cfg.LogFilePath = *logFilePath
 	}
+	if flagsSet["log-levels"] {
+		cfg.LogLevels = map[string]string{}
+		for _, entry := range strings.Split(*logLevels, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			component, level, ok := strings.Cut(entry, "=")
+			if !ok {
+				configProblem(fmt.Sprintf("invalid --log-levels entry %q (want component=level)", entry))
+				continue
+			}
+			cfg.LogLevels[component] = level
+		}
+	}
+	if _, err := utils.ParseComponentLevels(cfg.LogLevels); err != nil {
+		configProblem(fmt.Sprintf("invalid LogLevels: %v", err))
+	}
 	if flagsSet["reboot"] {
 		cfg.Reboot = *reboot
 	}
+	if flagsSet["reboot-delay"] {
+		cfg.RebootDelay = time.Duration(*rebootDelay) * time.Second
+	}
+	if flagsSet["reboot-message"] {
+		cfg.RebootMessage = *rebootMessage
+	}
+	if flagsSet["reboot-method"] {
+		cfg.RebootMethod = *rebootMethod
+	}
+	if flagsSet["reboot-condition"] {
+		cfg.RebootCondition = *rebootCondition
+	}
 	if flagsSet["max-retries"] {
 		cfg.MaxRetries = *maxRetries
 	}
 	if flagsSet["retry-delay"] {
 		cfg.RetryDelay = *retryDelay
 	}
+	if flagsSet["daemon-max-retries"] {
+		cfg.DaemonMaxRetries = *daemonMaxRetries
+	}
+	if flagsSet["retry-state-path"] {
+		cfg.RetryStatePath = *retryStatePath
+	}
+	if flagsSet["daemon-retry-limits"] {
+		cfg.DaemonRetryLimits = map[string]int{}
+		for _, limit := range strings.Split(*daemonRetryLimits, ",") {
+			if limit = strings.TrimSpace(limit); limit == "" {
+				continue
+			}
+			category, n, ok := strings.Cut(limit, "=")
+			count, err := strconv.Atoi(n)
+			if !ok || err != nil {
+				configProblem(fmt.Sprintf("invalid --daemon-retry-limits entry %q (want category=count)", limit))
+				continue
+			}
+			cfg.DaemonRetryLimits[category] = count
+		}
+	}
+	for category := range cfg.DaemonRetryLimits {
+		if !slices.Contains(retry.Categories, category) {
+			configProblem(fmt.Sprintf("unknown retry category %q (valid: %s)", category, strings.Join(retry.Categories, ", ")))
+		}
+	}
 	// Compat flags
 	if flagsSet["follow-redirects"] {
 		cfg.FollowRedirects = *followRedirects
@@ -188,9 +384,34 @@ func main() {
 	if flagsSet["skip-validation"] {
 		cfg.SkipValidation = *skipValidation
 	}
-	cfg.CleanupOnFailure = *cleanupOnFailure
-	cfg.CleanupOnSuccess = *cleanupOnSuccess
-	cfg.KeepFailedFiles = *keepFailedFiles
+	if flagsSet["cleanup-on-failure"] {
+		cfg.CleanupOnFailure = *cleanupOnFailure
+	}
+	if flagsSet["cleanup-on-success"] {
+		cfg.CleanupOnSuccess = *cleanupOnSuccess
+	}
+	if flagsSet["keep-failed-files"] {
+		cfg.KeepFailedFiles = *keepFailedFiles
+	}
+	if flagsSet["cleanup-scope"] {
+		cfg.CleanupScope = nil
+		for _, scope := range strings.Split(*cleanupScope, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				cfg.CleanupScope = append(cfg.CleanupScope, scope)
+			}
+		}
+	}
+	if flagsSet["cleanup-preserve"] {
+		cfg.CleanupPreserve = nil
+		for _, path := range strings.Split(*cleanupPreserve, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				cfg.CleanupPreserve = append(cfg.CleanupPreserve, path)
+			}
+		}
+	}
+	if flagsSet["rollback-on-failure"] {
+		cfg.RollbackOnFailure = *rollbackOnFailure
+	}
 	if flagsSet["dry-run"] {
 		cfg.DryRun = *dryRun
 	}
@@ -200,23 +421,119 @@ func main() {
 	if flagsSet["background-timeout"] {
 		cfg.BackgroundTimeout = time.Duration(*backgroundTimeout) * time.Second
 	}
+	if flagsSet["phase-timeout"] {
+		cfg.PhaseTimeout = time.Duration(*phaseTimeout) * time.Second
+	}
+	if flagsSet["run-deadline"] {
+		cfg.RunDeadline = time.Duration(*runDeadline) * time.Second
+	}
+	if flagsSet["remediation-interval"] {
+		cfg.RemediationInterval = time.Duration(*remediationInterval) * time.Second
+	}
+	if flagsSet["remediation-path"] {
+		cfg.RemediationPath = *remediationPath
+	}
 	if flagsSet["retain-log-files"] {
 		cfg.RetainLogFiles = *retainLogFiles
 	}
+	if flagsSet["keep-logs"] {
+		cfg.KeepLogs = *keepLogs
+	}
+	if flagsSet["json"] {
+		cfg.StatusJSON = *statusJSON
+	}
+	if flagsSet["lines"] {
+		cfg.StatusLogLines = *statusLines
+	}
+	if flagsSet["output-dir"] {
+		cfg.PlistOutputDir = *plistOutputDir
+	}
 	if flagsSet["with-preflight"] {
 		cfg.WithPreflight = *withPreflight
 	}
 	if flagsSet["no-restart-on-error"] {
 		cfg.NoRestartOnError = *noRestartOnError
 	}
+	if flagsSet["swiftdialog"] {
+		cfg.SwiftDialog = *swiftDialog
+	}
+	if flagsSet["metrics-listen"] {
+		cfg.MetricsListenAddress = *metricsListen
+	}
+	if flagsSet["metrics-push-url"] {
+		cfg.MetricsPushURL = *metricsPushURL
+	}
+	if flagsSet["metrics-push-format"] && *metricsPushFormat != "" {
+		cfg.MetricsPushFormat = *metricsPushFormat
+	}
+	if flagsSet["tracing-endpoint"] {
+		cfg.TracingEndpoint = *tracingEndpoint
+	}
+	if flagsSet["status-url"] {
+		cfg.StatusURL = *statusURL
+	}
+	if flagsSet["status-plist"] {
+		cfg.StatusPlistPath = *statusPlist
+	}
+	if flagsSet["jamf-recon"] {
+		cfg.JamfRecon = *jamfRecon
+	}
+	if flagsSet["only-phase"] {
+		cfg.OnlyPhase = *onlyPhase
+		switch cfg.OnlyPhase {
+		case "", "preflight", "setupassistant", "userland":
+		default:
+			configProblem(fmt.Sprintf("invalid --only-phase %q (valid: preflight, setupassistant, userland)", cfg.OnlyPhase))
+		}
+	}
+	if flagsSet["simulation"] {
+		cfg.SimulationFile = *simulationFile
+	}
+	if flagsSet["only-item"] {
+		cfg.OnlyItems = nil
+		for _, name := range strings.Split(*onlyItems, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				cfg.OnlyItems = append(cfg.OnlyItems, name)
+			}
+		}
+	}
+	if flagsSet["jamf-policy-events"] {
+		cfg.JamfPolicyEvents = nil
+		for _, event := range strings.Split(*jamfPolicyEvents, ",") {
+			if event = strings.TrimSpace(event); event != "" {
+				cfg.JamfPolicyEvents = append(cfg.JamfPolicyEvents, event)
+			}
+		}
+	}
+	if flagsSet["webhook-listen"] {
+		cfg.WebhookListenAddress = *webhookListen
+	}
+	if flagsSet["webhook-profile-domain"] {
+		cfg.WebhookProfileDomain = *webhookProfileDomain
+	}
+	if flagsSet["audit-log"] {
+		cfg.AuditLogPath = *auditLog
+	}
 
 	// Download and IPC settings
 	if flagsSet["download-max-concurrency"] {
 		cfg.DownloadMaxConcurrency = *downloadMaxConcurrency
 	}
+	if flagsSet["install-max-concurrency"] {
+		cfg.InstallMaxConcurrency = *installMaxConcurrency
+	}
 	if flagsSet["wait-for-agent-timeout"] {
 		cfg.WaitForAgentTimeout = time.Duration(*waitForAgentTimeout) * time.Second
 	}
+	if flagsSet["userland-gate-policy"] && *userlandGatePolicy != "" {
+		cfg.UserlandGatePolicy = *userlandGatePolicy
+	}
+	if flagsSet["userland-gate-deadline"] {
+		cfg.UserlandGateDeadline = time.Duration(*userlandGateDeadline) * time.Second
+	}
+	if flagsSet["wait-for-desktop"] {
+		cfg.UserlandWaitForDesktop = *waitForDesktop
+	}
 	if flagsSet["agent-request-timeout"] {
 		cfg.AgentRequestTimeout = time.Duration(*agentRequestTimeout) * time.Second
 	}
@@ -232,12 +549,47 @@ func main() {
 	if flagsSet["hash-check-policy"] && *hashCheckPolicy != "" {
 		cfg.HashCheckPolicy = *hashCheckPolicy
 	}
+	if flagsSet["verify-package-signatures"] {
+		cfg.VerifyPackageSignatures = *verifyPackageSignatures
+	}
+	if flagsSet["allowed-team-ids"] {
+		cfg.AllowedTeamIDs = nil
+		for _, id := range strings.Split(*allowedTeamIDs, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				cfg.AllowedTeamIDs = append(cfg.AllowedTeamIDs, id)
+			}
+		}
+	}
+
+	if flagsSet["strict-profile"] {
+		cfg.StrictProfile = *strictProfile
+	}
+	if cfg.StrictProfile && len(profileResult.UnknownKeys) > 0 && !*printConfig {
+		fmt.Println("Error: the profile has unknown keys and StrictProfile is set")
+		os.Exit(int(utils.ExitConfig))
+	}
+	// Settings that conflict stop the modes that act on them, as
+	// --print-config reports them; the modes that only read or undo still run
+	if !*printConfig && validatedModes[cfg.Mode] {
+		if err := cfg.ValidateSettings(); err != nil {
+			fmt.Printf("Error: invalid configuration:\n%v\n", err)
+			os.Exit(int(utils.ExitConfig))
+		}
+	}
+
+	if *printConfig {
+		layers = append(layers, mode.ConfigLayer{Source: "flag", Settings: cfg.RedactedForLogging()})
+		mode.PrintConfig(cfg, layers, profileResult.BootstrapSource, readProblems, utils.NewLoggerWithWriter(false, false, os.Stderr))
+	}
 
 	// Create logger (with file logging for standalone mode)
-	var logger *utils.Logger
+	var logger utils.Logger
 	var err error
 
-	if cfg.Mode == "standalone" {
+	if cfg.Mode == "status" || (cfg.DryRun && cfg.StatusJSON) {
+		// Status mode and --dry-run --json print JSON on stdout; keep logs off it
+		logger = utils.NewLoggerWithWriter(cfg.Debug, cfg.Verbose, os.Stderr)
+	} else if cfg.Mode == "standalone" {
 		// Standalone mode
 		var logFilePath string
 		if cfg.LogFilePath != "" {
@@ -306,6 +658,8 @@ func main() {
 		}
 	}
 
+	_ = utils.ApplyLogLevels(logger, cfg) // LogLevels was checked above
+
 	// Remote log shipping temporarily disabled for initial release
 	// if cfg.LogDestination != "" {
 	// 	// Default provider to generic if unspecified but destination is set
@@ -316,10 +670,35 @@ func main() {
 	// 	logger.EnableRemoteShipping(cfg.LogDestination, cfg.LogHeaders, provider)
 	// }
 
+	// Retry state is kept per profile domain; handle a reset before any mode
+	// reads it
+	retry.SetStateFile(cfg.RetryStateFile())
+	retry.SetLogger(logger)
+	retry.SetMaxRetries(cfg.DaemonMaxRetries)
+	retry.SetCategoryLimits(cfg.DaemonRetryLimits)
+	if *resetRetries {
+		if err := retry.ClearRetryCount(); err != nil {
+			fmt.Printf("Warning: failed to clear retry state: %v\n", err)
+		} else {
+			fmt.Printf("Retry state cleared\n")
+		}
+	}
+
+	// Audit log: only privileged modes write it. The agent runs as the console
+	// user, so the daemon records the actions it delegates instead.
+	if cfg.AuditLogPath != "" && utils.IsRootUser() {
+		if auditLog, err := audit.Open(cfg.AuditLogPath, cfg.Mode); err != nil {
+			logger.Info("⚠️  Audit log disabled: %v", err)
+		} else {
+			audit.SetDefault(auditLog)
+			defer auditLog.Close()
+		}
+	}
+
 	// Log configuration source with details
 	if profileResult.ConfigFound {
-		logger.Info("Starting go-installapplications in %s mode (mobile config found)", cfg.Mode)
-		logger.Debug("Profile domain: %s", *profileDomain)
+		logger.Info("Starting go-installapplications %s in %s mode (mobile config found)", version.Version, cfg.Mode)
+		logger.Debug("Profile domain: %s", cfg.ProfileDomain)
 		logger.Debug("Bootstrap source: %s", profileResult.BootstrapSource)
 		logger.Debug("Config hierarchy: defaults → shared → %s → command line", cfg.Mode)
 
@@ -336,10 +715,12 @@ func main() {
 			}
 		}
 	} else {
-		logger.Info("Starting go-installapplications in %s mode (using defaults + command line)", cfg.Mode)
-		logger.Debug("No mobile config found at domain: %s", *profileDomain)
+		logger.Info("Starting go-installapplications %s in %s mode (using defaults + command line)", version.Version, cfg.Mode)
+		logger.Debug("No mobile config found at domain: %s", cfg.ProfileDomain)
 	}
 
+	logger.Debug("Build: %s", version.Get())
+	logger.Info("System: %s", utils.GetSystemInfo())
 	logger.Debug("System architecture: %s", utils.GetArchitectureInfo())
 	if cfg.TrackBackgroundProcesses {
 		logger.Debug("Background process tracking enabled (timeout: %v)", cfg.BackgroundTimeout)
@@ -356,6 +737,16 @@ func main() {
 		}
 	}
 
+	// A panic in a mode is written to a crash report next to the mode's log
+	// and exits with a code of its own instead of a bare Go trace
+	crashLog := cfg.DefaultDaemonLogPath
+	if cfg.Mode == "agent" || cfg.Mode == "agent-standalone" {
+		crashLog = cfg.DefaultAgentLogPath
+	}
+	utils.SetCrashDir(filepath.Dir(crashLog))
+	utils.SetRebootHooks(mode.RebootHooks(cfg, logger))
+	defer utils.Recover(logger, cfg.Mode+" mode")
+
 	// Route to appropriate mode handler
 	switch cfg.Mode {
 	case "daemon":
@@ -364,9 +755,27 @@ func main() {
 		mode.RunAgent(cfg, logger)
 	case "standalone":
 		mode.RunStandalone(cfg, logger)
+	case "webhook":
+		mode.RunWebhook(cfg, logger, mode.WebhookRunFlags(flag.Visit))
+	case "install":
+		mode.RunInstall(cfg, logger)
+	case "uninstall":
+		mode.RunUninstall(cfg, logger)
+	case "status":
+		mode.RunStatus(cfg, logger)
+	case "plists":
+		mode.RunPlists(cfg, logger)
+	case "healthcheck":
+		mode.RunHealthcheck(cfg, logger)
+	case "agent-standalone":
+		mode.RunAgentStandalone(cfg, logger)
+	case "simulate":
+		mode.RunSimulate(cfg, logger)
+	case "remediate":
+		mode.RunRemediate(cfg, logger)
 	default:
 		logger.Error("Unknown mode: %s", cfg.Mode)
-		fmt.Printf("Valid modes: daemon, agent, standalone\n")
-		os.Exit(1)
+		fmt.Printf("Valid modes: daemon, agent, standalone, webhook, install, uninstall, status, plists, healthcheck, agent-standalone, simulate, remediate\n")
+		os.Exit(int(utils.ExitConfig))
 	}
 }
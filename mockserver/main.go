@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redirectPrefix is where redirected requests end up; a request for /x is
+// answered with a 302 to /_redirected/x when redirects are on.
+const redirectPrefix = "/_redirected"
+
+// baseURLPlaceholder is replaced in served .json files with the URL the
+// server was reached at, so a bootstrap.json can point its items back here.
+const baseURLPlaceholder = "{{BASE_URL}}"
+
+// Options controls how the mock server answers
+type Options struct {
+	Dir          string
+	Latency      time.Duration
+	AuthUser     string
+	AuthPassword string
+	Header       string // required "Name: value" request header
+	Redirect     bool
+	FailRate     float64
+	FailFirst    int
+	FailStatus   int
+	FailPaths    FailList
+}
+
+// FailList is a custom type that implements flag.Value for the --fail flag:
+// "PATH=STATUS" makes every request for PATH answer STATUS
+type FailList map[string]int
+
+// String implements the flag.Value interface
+func (f *FailList) String() string {
+	return fmt.Sprintf("%v", *f)
+}
+
+// Set implements the flag.Value interface
+func (f *FailList) Set(value string) error {
+	p, status, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("fail must be PATH=STATUS, got %q", value)
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 100 || code > 599 {
+		return fmt.Errorf("invalid status %q for %s", status, p)
+	}
+	if *f == nil {
+		*f = FailList{}
+	}
+	(*f)["/"+strings.TrimPrefix(p, "/")] = code
+	return nil
+}
+
+// server serves Options.Dir with the configured behaviors
+type server struct {
+	opts  Options
+	files http.Handler
+	rand  func() float64
+
+	mu       sync.Mutex
+	requests map[string]int // requests seen per path
+}
+
+func newServer(opts Options) *server {
+	if opts.FailStatus == 0 {
+		opts.FailStatus = http.StatusServiceUnavailable
+	}
+	return &server{
+		opts:     opts,
+		files:    http.FileServer(http.Dir(opts.Dir)),
+		rand:     rand.Float64,
+		requests: make(map[string]int),
+	}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status, reason := s.respond(w, r)
+	log.Printf("%s %s -> %d %s", r.Method, r.URL.Path, status, reason)
+}
+
+// respond answers r and returns the status it chose and why
+func (s *server) respond(w http.ResponseWriter, r *http.Request) (int, string) {
+	if s.opts.Latency > 0 {
+		time.Sleep(s.opts.Latency)
+	}
+
+	if s.opts.AuthUser != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != s.opts.AuthUser || pass != s.opts.AuthPassword {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mockserver"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return http.StatusUnauthorized, "(basic auth)"
+		}
+	}
+	if s.opts.Header != "" {
+		name, value, _ := strings.Cut(s.opts.Header, ":")
+		if r.Header.Get(strings.TrimSpace(name)) != strings.TrimSpace(value) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return http.StatusForbidden, "(missing header " + strings.TrimSpace(name) + ")"
+		}
+	}
+
+	p := path.Clean("/" + r.URL.Path)
+	if s.opts.Redirect {
+		if !strings.HasPrefix(p, redirectPrefix+"/") {
+			http.Redirect(w, r, redirectPrefix+p, http.StatusFound)
+			return http.StatusFound, "(redirect)"
+		}
+		p = strings.TrimPrefix(p, redirectPrefix)
+	}
+
+	s.mu.Lock()
+	s.requests[p]++
+	n := s.requests[p]
+	s.mu.Unlock()
+	if code, ok := s.opts.FailPaths[p]; ok {
+		http.Error(w, http.StatusText(code), code)
+		return code, "(--fail)"
+	}
+	if n <= s.opts.FailFirst {
+		http.Error(w, http.StatusText(s.opts.FailStatus), s.opts.FailStatus)
+		return s.opts.FailStatus, fmt.Sprintf("(attempt %d of --fail-first %d)", n, s.opts.FailFirst)
+	}
+	if s.opts.FailRate > 0 && s.rand() < s.opts.FailRate {
+		http.Error(w, http.StatusText(s.opts.FailStatus), s.opts.FailStatus)
+		return s.opts.FailStatus, "(--fail-rate)"
+	}
+
+	if strings.HasSuffix(p, ".json") {
+		if data, err := os.ReadFile(filepath.Join(s.opts.Dir, filepath.FromSlash(p))); err == nil {
+			scheme := "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+			body := strings.ReplaceAll(string(data), baseURLPlaceholder, scheme+"://"+r.Host)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			if r.Method != http.MethodHead {
+				_, _ = w.Write([]byte(body))
+			}
+			return http.StatusOK, ""
+		}
+	}
+
+	r.URL.Path = p
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	s.files.ServeHTTP(rec, r)
+	return rec.status, ""
+}
+
+// statusRecorder remembers the status written by the file server for logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func main() {
+	var opts Options
+	listen := flag.String("listen", "127.0.0.1:8080", "Address to listen on")
+	flag.StringVar(&opts.Dir, "dir", ".", "Directory to serve (bootstrap.json and item files)")
+	flag.DurationVar(&opts.Latency, "latency", 0, "Delay added before every response, e.g. 500ms")
+	flag.StringVar(&opts.AuthUser, "auth-user", "", "Require HTTP basic auth with this user")
+	flag.StringVar(&opts.AuthPassword, "auth-password", "", "Password for --auth-user")
+	flag.StringVar(&opts.Header, "require-header", "", `Require a request header, e.g. "Authorization: Bearer token"`)
+	flag.BoolVar(&opts.Redirect, "redirect", false, "Answer every request with a 302 to "+redirectPrefix+"/PATH before serving it")
+	flag.Float64Var(&opts.FailRate, "fail-rate", 0, "Fraction of requests (0-1) that fail with --fail-status")
+	flag.IntVar(&opts.FailFirst, "fail-first", 0, "Fail the first N requests for each path with --fail-status")
+	flag.IntVar(&opts.FailStatus, "fail-status", http.StatusServiceUnavailable, "Status code for --fail-rate and --fail-first")
+	flag.Var(&opts.FailPaths, "fail", "Always fail a path. Format: PATH=STATUS (repeatable)")
+	flag.Parse()
+
+	if info, err := os.Stat(opts.Dir); err != nil || !info.IsDir() {
+		log.Fatalf("dir %q is not a directory", opts.Dir)
+	}
+	if opts.FailRate < 0 || opts.FailRate > 1 {
+		log.Fatal("fail-rate must be between 0 and 1")
+	}
+	if opts.AuthPassword != "" && opts.AuthUser == "" {
+		log.Fatal("auth-password requires auth-user")
+	}
+	if opts.Header != "" && !strings.Contains(opts.Header, ":") {
+		log.Fatal(`require-header must be "Name: value"`)
+	}
+
+	log.Printf("Serving %s on http://%s", opts.Dir, *listen)
+	log.Fatal(http.ListenAndServe(*listen, newServer(opts)))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServer_Behaviors(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "bootstrap.json"), []byte(`{"url": "{{BASE_URL}}/app.pkg"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app.pkg"), []byte("pkg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var fails FailList
+	if err := fails.Set("broken.pkg=500"); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(newServer(Options{
+		Dir:          dir,
+		AuthUser:     "admin",
+		AuthPassword: "secret",
+		Redirect:     true,
+		FailFirst:    1,
+		FailPaths:    fails,
+	}))
+	defer ts.Close()
+
+	get := func(p string, auth bool) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+p, nil)
+		if auth {
+			req.SetBasicAuth("admin", "secret")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, _ := get("/app.pkg", false); code != http.StatusUnauthorized {
+		t.Fatalf("without auth = %d", code)
+	}
+	if code, _ := get("/app.pkg", true); code != http.StatusServiceUnavailable {
+		t.Fatalf("first attempt = %d, want 503", code)
+	}
+	if code, body := get("/app.pkg", true); code != http.StatusOK || body != "pkg" {
+		t.Fatalf("second attempt = %d %q", code, body)
+	}
+	get("/bootstrap.json", true)
+	if code, body := get("/bootstrap.json", true); code != http.StatusOK || !strings.Contains(body, ts.URL+"/app.pkg") {
+		t.Fatalf("bootstrap = %d %q", code, body)
+	}
+	if code, _ := get("/broken.pkg", true); code != http.StatusInternalServerError {
+		t.Fatalf("--fail path = %d", code)
+	}
+}
@@ -0,0 +1,190 @@
+// Package audit writes an append-only record of every privileged action a
+// run performs (packages installed, scripts executed, files placed, ownership
+// changes, service bootouts). It is kept apart from the debug logs so it
+// survives log rotation and RetainLogFiles=false, and so compliance review
+// does not have to parse free-form log lines.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultPath is where the audit log is written unless configured otherwise.
+const DefaultPath = "/var/log/go-installapplications/audit.log"
+
+// Actions recorded in Event.Action.
+const (
+	ActionPackageInstall = "package_install"
+	ActionScriptExecute  = "script_execute"
+	ActionFilePlace      = "file_place"
+	ActionChown          = "chown"
+	ActionServiceBootout = "service_bootout"
+	ActionServiceLoad    = "service_bootstrap"
+	ActionFileRemove     = "file_remove"
+	ActionReboot         = "reboot"
+	ActionShutdown       = "shutdown" // run stopped by a signal
+	ActionTimeout        = "timeout"  // phase or run stopped by PhaseTimeout or RunDeadline
+	ActionCrash          = "crash"    // a panic, with the crash report in Details
+
+	// launchd items also record service_bootstrap and service_bootout
+	ActionServiceEnable    = "service_enable"
+	ActionServiceDisable   = "service_disable"
+	ActionServiceKickstart = "service_kickstart"
+
+	// vppapp items: the install request sent to the MDM hook
+	ActionAppInstall = "app_install"
+
+	// Files replaced by items with backup, and put back by rollback
+	ActionFileBackup  = "file_backup"
+	ActionFileRestore = "file_restore"
+)
+
+// Outcomes recorded in Event.Outcome.
+const (
+	OutcomeSuccess     = "success"
+	OutcomeFailure     = "failure"
+	OutcomeStarted     = "started" // background scripts whose exit is not awaited
+	OutcomeDryRun      = "dry_run"
+	OutcomeInterrupted = "interrupted" // stopped by a shutdown signal
+	OutcomeTimedOut    = "timed_out"   // stopped by PhaseTimeout or RunDeadline
+)
+
+// Event is one audit record, written as a single JSON line.
+type Event struct {
+	Time    time.Time         `json:"time"`
+	Mode    string            `json:"mode,omitempty"`
+	PID     int               `json:"pid"`
+	UID     int               `json:"uid"`
+	Action  string            `json:"action"`
+	Target  string            `json:"target"`
+	SHA256  string            `json:"sha256,omitempty"`
+	Outcome string            `json:"outcome"`
+	Error   string            `json:"error,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Log appends events to a file. All methods are safe on a nil *Log so
+// callers never need to check whether auditing is enabled.
+type Log struct {
+	mu   sync.Mutex
+	w    io.WriteCloser
+	mode string
+}
+
+// Open opens (or creates) the audit log at path for appending. The file is
+// created 0600 since it names every script and package on the machine.
+func Open(path, mode string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Log{w: f, mode: mode}, nil
+}
+
+// Record fills in the time, mode and process identity and appends e.
+// Write errors are reported to stderr only; auditing never fails a run.
+func (l *Log) Record(e Event) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.Mode == "" {
+		e.Mode = l.mode
+	}
+	e.PID = os.Getpid()
+	e.UID = os.Geteuid()
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit: failed to encode event: %v\n", err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "audit: failed to write event: %v\n", err)
+	}
+}
+
+// Close closes the underlying file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Close()
+}
+
+// std is the process-wide audit log set by main; nil disables auditing.
+var (
+	stdMu sync.RWMutex
+	std   *Log
+)
+
+// SetDefault installs l as the process-wide audit log and returns the
+// previous one.
+func SetDefault(l *Log) *Log {
+	stdMu.Lock()
+	defer stdMu.Unlock()
+	prev := std
+	std = l
+	return prev
+}
+
+// Default returns the process-wide audit log, or nil when disabled.
+func Default() *Log {
+	stdMu.RLock()
+	defer stdMu.RUnlock()
+	return std
+}
+
+// Record appends e to the process-wide audit log.
+func Record(e Event) {
+	Default().Record(e)
+}
+
+// Outcome maps an action's error to OutcomeSuccess or OutcomeFailure.
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+// ErrorString returns err's message, or "" for nil.
+func ErrorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// FileSHA256 returns the hex SHA-256 of the file at path, or "" if it cannot
+// be read. Scripts are hashed right before they run so the record shows
+// exactly what was executed.
+func FileSHA256(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
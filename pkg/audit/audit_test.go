@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	var events []Event
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("decode %q: %v", sc.Text(), err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// Reopening the log must append, never truncate, and the file stays private.
+func TestLog_AppendsAcrossOpens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "audit.log")
+	for i, target := range []string{"/tmp/a.pkg", "/tmp/b.sh"} {
+		l, err := Open(path, "daemon")
+		if err != nil {
+			t.Fatalf("open %d: %v", i, err)
+		}
+		var err2 error
+		if i == 1 {
+			err2 = errors.New("exit status 1")
+		}
+		l.Record(Event{Action: ActionScriptExecute, Target: target, Outcome: Outcome(err2), Error: ErrorString(err2)})
+		if err := l.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	}
+
+	events := readEvents(t, path)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Target != "/tmp/a.pkg" || events[0].Outcome != OutcomeSuccess || events[0].Mode != "daemon" {
+		t.Fatalf("unexpected first event: %+v", events[0])
+	}
+	if events[1].Outcome != OutcomeFailure || events[1].Error != "exit status 1" {
+		t.Fatalf("unexpected second event: %+v", events[1])
+	}
+	if events[0].Time.IsZero() || events[0].PID != os.Getpid() {
+		t.Fatalf("identity not filled in: %+v", events[0])
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected 0600 audit log, got %v (%v)", info.Mode().Perm(), err)
+	}
+}
+
+func TestRecord_DisabledIsNoop(t *testing.T) {
+	prev := SetDefault(nil)
+	defer SetDefault(prev)
+	Record(Event{Action: ActionReboot}) // must not panic
+	var l *Log
+	l.Record(Event{Action: ActionReboot})
+	if err := l.Close(); err != nil {
+		t.Fatalf("nil close: %v", err)
+	}
+}
+
+func TestFileSHA256(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := FileSHA256(path); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Fatalf("unexpected hash %s", got)
+	}
+	if got := FileSHA256(path + ".missing"); got != "" {
+		t.Fatalf("expected empty hash for missing file, got %s", got)
+	}
+}
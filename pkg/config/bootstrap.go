@@ -1,9 +1,14 @@
 package config
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
 // Bootstrap represents the JSON structure for InstallApplications
@@ -11,6 +16,67 @@ type Bootstrap struct {
 	Preflight      []Item `json:"preflight,omitempty"`
 	SetupAssistant []Item `json:"setupassistant,omitempty"`
 	Userland       []Item `json:"userland,omitempty"`
+	Hooks          *Hooks `json:"hooks,omitempty"`
+	// PreflightOptions opt in to several preflight checks
+	PreflightOptions *PreflightOptions `json:"preflight_options,omitempty"`
+}
+
+// PreflightOptions lift the single-rootscript limit of preflight, for
+// "already provisioned" checks that span several domains (MDM, EDR, disk
+// encryption). The scripts run in order and keep the exit-code contract:
+// the Mac counts as provisioned only when every script exits 0, and the
+// first non-zero exit continues the bootstrap without running the rest.
+type PreflightOptions struct {
+	// Multiple allows more than one rootscript in preflight
+	Multiple bool `json:"multiple,omitempty"`
+	// Timeout limits all preflight scripts together, in seconds; when it
+	// passes the bootstrap continues as if a check had failed. 0 is no limit.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// Hooks are scripts run before and after a phase, e.g. a network check
+// before setupassistant or a cleanup after userland. Each list runs as its
+// own step with the usual downloads, retries and fail_policy; a hook that
+// stops its step fails the phase. Hooks only run for phases that have items.
+type Hooks struct {
+	PrePreflight       []Item `json:"pre_preflight,omitempty"`
+	PostPreflight      []Item `json:"post_preflight,omitempty"` // not run when preflight passes
+	PreSetupAssistant  []Item `json:"pre_setupassistant,omitempty"`
+	PostSetupAssistant []Item `json:"post_setupassistant,omitempty"`
+	PreUserland        []Item `json:"pre_userland,omitempty"`
+	PostUserland       []Item `json:"post_userland,omitempty"`
+}
+
+// Pre returns the hooks to run before phase. h may be nil.
+func (h *Hooks) Pre(phase string) []Item {
+	if h == nil {
+		return nil
+	}
+	switch phase {
+	case "preflight":
+		return h.PrePreflight
+	case "setupassistant":
+		return h.PreSetupAssistant
+	case "userland":
+		return h.PreUserland
+	}
+	return nil
+}
+
+// Post returns the hooks to run after phase. h may be nil.
+func (h *Hooks) Post(phase string) []Item {
+	if h == nil {
+		return nil
+	}
+	switch phase {
+	case "preflight":
+		return h.PostPreflight
+	case "setupassistant":
+		return h.PostSetupAssistant
+	case "userland":
+		return h.PostUserland
+	}
+	return nil
 }
 
 // Item represents a single installation item (package, script, or file)
@@ -18,11 +84,15 @@ type Item struct {
 	// Required fields
 	File string `json:"file"`
 	Name string `json:"name"`
-	Type string `json:"type"` // "package", "rootscript", "userscript", "rootfile", "userfile"
+	Type string `json:"type"` // "package", "rootscript", "userscript", "rootfile", "userfile", "munki", "launchd", "vppapp"
 
 	// Download fields
 	URL  string `json:"url,omitempty"`
 	Hash string `json:"hash,omitempty"`
+	// Content (plain text) or ContentBase64 carries a small script or file
+	// inline; it is written to File in place of a download
+	Content       string `json:"content,omitempty"`
+	ContentBase64 string `json:"content_base64,omitempty"`
 
 	// Package specific fields
 	PackageID string `json:"packageid,omitempty"`
@@ -31,7 +101,7 @@ type Item struct {
 	// Execution control
 	DoNotWait   bool   `json:"donotwait,omitempty"`
 	PkgRequired bool   `json:"pkg_required,omitempty"` // UnmarshalJSON also accepts "required"
-	SkipIf      string `json:"skip_if,omitempty"`     // "x86_64", "intel", "arm64", "apple_silicon"
+	SkipIf      string `json:"skip_if,omitempty"`      // "x86_64", "intel", "arm64", "apple_silicon", "virtual_machine", "physical", "macos<14"...
 
 	// Retry settings (NEW)
 	Retries   int `json:"retries,omitempty"`
@@ -45,8 +115,126 @@ type Item struct {
 	// alpha/alpha/beta/alpha forms three batches: {alpha,alpha}, {beta}, {alpha}.
 	// Items with an empty value run sequentially as singleton batches.
 	ParallelGroup string `json:"parallel_group,omitempty"`
+	// ParallelSafe marks an item without a parallel_group as independent of
+	// its neighbours: consecutive parallel_safe items form one parallel batch.
+	ParallelSafe bool `json:"parallel_safe,omitempty"`
+
+	// Rollback is a shell command, run as root, that undoes the item when
+	// the run fails and RollbackOnFailure is set
+	Rollback string `json:"rollback,omitempty"`
+
+	// Group is the name of the group the item was declared in, if any. Once
+	// a member fails, the rest of its group is skipped.
+	Group string `json:"group,omitempty"`
+
+	// Package installer options. ChoicesXML is the path of a choices file
+	// for installer -applyChoiceChangesXML, usually placed by a rootfile item
+	// earlier in the bootstrap; Target is the volume to install to ("/" when
+	// empty).
+	ChoicesXML     string `json:"choices_xml,omitempty"`
+	AllowUntrusted bool   `json:"allow_untrusted,omitempty"`
+	Target         string `json:"target,omitempty"`
+	// VerifySignature checks the package's signature before it is installed,
+	// like VerifyPackageSignatures does for every package; TeamID also
+	// requires that Team ID, in place of AllowedTeamIDs. On rootfile and
+	// userfile items they check the code signature of the placed file, and
+	// SigningID requires its signing identifier.
+	VerifySignature bool   `json:"verify_signature,omitempty"`
+	TeamID          string `json:"team_id,omitempty"`
+	SigningID       string `json:"signing_id,omitempty"`
+
+	// RunAs runs a rootscript as another user: RunAsConsole for the console
+	// user, or the short name of a local account such as a service account
+	RunAs string `json:"run_as,omitempty"`
+
+	// launchd items manage a launchd service: Action is one of
+	// LaunchdActions, Label the service label and Domain "system" (the
+	// default) or "gui" for the console user's session. File is the job's
+	// plist; bootstrap needs it, the other actions need File or Label.
+	Action string `json:"action,omitempty"`
+	Label  string `json:"label,omitempty"`
+	Domain string `json:"domain,omitempty"`
+
+	// vppapp items have the MDM install a VPP-licensed App Store app:
+	// AdamID is the app's App Store ID and BundleID its bundle identifier.
+	// File, if set, is where the app lands (e.g. /Applications/Keynote.app);
+	// the item is skipped when it exists and waits for it otherwise.
+	AdamID   string `json:"adam_id,omitempty"`
+	BundleID string `json:"bundle_id,omitempty"`
+
+	// rootfile and userfile items: Owner is "user", "user:group" or
+	// ":group", as for chown ("group" already names item groups); Mode and
+	// DirMode are octal strings such as "0440". Mode replaces the default
+	// permissions of the type, and DirMode is given to any directory
+	// created for the file. A userfile always belongs to the console user.
+	Owner   string `json:"owner,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+	DirMode string `json:"dir_mode,omitempty"`
+	// Backup keeps the file a download replaces (see Config.FileBackupDir)
+	// and puts it back when the item is rolled back
+	Backup bool `json:"backup,omitempty"`
+	// StripQuarantine removes com.apple.quarantine from the placed file so a
+	// helper app does not raise a Gatekeeper prompt at first launch; XAttrs
+	// sets extended attributes on it, name to value
+	StripQuarantine bool              `json:"strip_quarantine,omitempty"`
+	XAttrs          map[string]string `json:"xattrs,omitempty"`
+}
+
+// HasInlineContent reports whether the item carries its file inline.
+func (i Item) HasInlineContent() bool {
+	return i.Content != "" || i.ContentBase64 != ""
+}
+
+// Delivered reports whether the client writes the item's file, from its
+// url or its inline content, rather than finding it already on disk.
+func (i Item) Delivered() bool {
+	return i.URL != "" || i.HasInlineContent()
 }
 
+// InlineContent returns the file the item carries inline, decoding
+// content_base64.
+func (i Item) InlineContent() ([]byte, error) {
+	if i.ContentBase64 == "" {
+		return []byte(i.Content), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(i.ContentBase64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content_base64 for '%s': %w", i.Name, err)
+	}
+	return data, nil
+}
+
+// ParseFileMode parses the octal mode or dir_mode of an item. An empty
+// string is mode 0, meaning the default.
+func ParseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 07777 {
+		return 0, fmt.Errorf("not an octal file mode: %q", s)
+	}
+	perm := os.FileMode(mode).Perm()
+	if mode&04000 != 0 {
+		perm |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		perm |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		perm |= os.ModeSticky
+	}
+	return perm, nil
+}
+
+// LaunchdActions are the actions of a launchd item, each run as
+// `launchctl <action>`.
+var LaunchdActions = []string{"bootstrap", "bootout", "enable", "disable", "kickstart"}
+
+// RunAsConsole is the run_as value that runs a rootscript as the console
+// user.
+const RunAsConsole = "console"
+
 // itemRaw is used for JSON unmarshaling so both "pkg_required" and "required" set PkgRequired.
 type itemRaw struct {
 	File          string `json:"file"`
@@ -54,6 +242,8 @@ type itemRaw struct {
 	Type          string `json:"type"`
 	URL           string `json:"url,omitempty"`
 	Hash          string `json:"hash,omitempty"`
+	Content       string `json:"content,omitempty"`
+	ContentBase64 string `json:"content_base64,omitempty"`
 	PackageID     string `json:"packageid,omitempty"`
 	Version       string `json:"version,omitempty"`
 	DoNotWait     bool   `json:"donotwait,omitempty"`
@@ -64,6 +254,34 @@ type itemRaw struct {
 	RetryWait     int    `json:"retrywait,omitempty"`
 	FailPolicy    string `json:"fail_policy,omitempty"`
 	ParallelGroup string `json:"parallel_group,omitempty"`
+	ParallelSafe  bool   `json:"parallel_safe,omitempty"`
+	Rollback      string `json:"rollback,omitempty"`
+	Group         string `json:"group,omitempty"`
+
+	ChoicesXML     string `json:"choices_xml,omitempty"`
+	AllowUntrusted bool   `json:"allow_untrusted,omitempty"`
+	Target         string `json:"target,omitempty"`
+
+	VerifySignature bool   `json:"verify_signature,omitempty"`
+	TeamID          string `json:"team_id,omitempty"`
+	SigningID       string `json:"signing_id,omitempty"`
+
+	RunAs string `json:"run_as,omitempty"`
+
+	Action string `json:"action,omitempty"`
+	Label  string `json:"label,omitempty"`
+	Domain string `json:"domain,omitempty"`
+
+	AdamID   string `json:"adam_id,omitempty"`
+	BundleID string `json:"bundle_id,omitempty"`
+
+	Owner   string `json:"owner,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+	DirMode string `json:"dir_mode,omitempty"`
+	Backup  bool   `json:"backup,omitempty"`
+
+	StripQuarantine bool              `json:"strip_quarantine,omitempty"`
+	XAttrs          map[string]string `json:"xattrs,omitempty"`
 }
 
 // UnmarshalJSON accepts both "pkg_required" and "required" for PkgRequired.
@@ -77,6 +295,8 @@ func (i *Item) UnmarshalJSON(data []byte) error {
 	i.Type = raw.Type
 	i.URL = raw.URL
 	i.Hash = raw.Hash
+	i.Content = raw.Content
+	i.ContentBase64 = raw.ContentBase64
 	i.PackageID = raw.PackageID
 	i.Version = raw.Version
 	i.DoNotWait = raw.DoNotWait
@@ -86,18 +306,205 @@ func (i *Item) UnmarshalJSON(data []byte) error {
 	i.RetryWait = raw.RetryWait
 	i.FailPolicy = raw.FailPolicy
 	i.ParallelGroup = raw.ParallelGroup
+	i.ParallelSafe = raw.ParallelSafe
+	i.Rollback = raw.Rollback
+	i.Group = raw.Group
+	i.ChoicesXML = raw.ChoicesXML
+	i.AllowUntrusted = raw.AllowUntrusted
+	i.Target = raw.Target
+	i.VerifySignature = raw.VerifySignature
+	i.TeamID = raw.TeamID
+	i.SigningID = raw.SigningID
+	i.RunAs = raw.RunAs
+	i.Action = raw.Action
+	i.Label = raw.Label
+	i.Domain = raw.Domain
+	i.AdamID = raw.AdamID
+	i.BundleID = raw.BundleID
+	i.Owner = raw.Owner
+	i.Mode = raw.Mode
+	i.DirMode = raw.DirMode
+	i.Backup = raw.Backup
+	i.StripQuarantine = raw.StripQuarantine
+	i.XAttrs = raw.XAttrs
 	return nil
 }
 
+// Group is a named list of items declared in place of an item in a phase:
+//
+//	{"group": "browsers", "fail_policy": "failable", "parallel": true, "items": [...]}
+//
+// Its fail_policy applies to every member, and once a member fails the rest
+// of the group is skipped, so the group fails as a unit. With parallel set,
+// the members install as one parallel_group batch. Groups are expanded into
+// their items when the bootstrap is loaded; each member keeps the group's
+// name in Item.Group.
+type Group struct {
+	Name       string            `json:"group"`
+	FailPolicy string            `json:"fail_policy,omitempty"`
+	Parallel   bool              `json:"parallel,omitempty"`
+	Items      []json.RawMessage `json:"items"`
+}
+
+// bootstrapRaw is used for JSON unmarshaling so phase lists can hold groups.
+type bootstrapRaw struct {
+	Preflight      []json.RawMessage `json:"preflight,omitempty"`
+	SetupAssistant []json.RawMessage `json:"setupassistant,omitempty"`
+	Userland       []json.RawMessage `json:"userland,omitempty"`
+	Hooks          *Hooks            `json:"hooks,omitempty"`
+
+	PreflightOptions *PreflightOptions `json:"preflight_options,omitempty"`
+}
+
+// UnmarshalJSON expands groups in the phase lists into their items.
+func (b *Bootstrap) UnmarshalJSON(data []byte) error {
+	var raw bootstrapRaw
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var err error
+	if b.Preflight, err = expandGroups("preflight", raw.Preflight); err != nil {
+		return err
+	}
+	if b.SetupAssistant, err = expandGroups("setupassistant", raw.SetupAssistant); err != nil {
+		return err
+	}
+	if b.Userland, err = expandGroups("userland", raw.Userland); err != nil {
+		return err
+	}
+	b.Hooks = raw.Hooks
+	b.PreflightOptions = raw.PreflightOptions
+	return nil
+}
+
+// isGroup reports whether a phase list entry is a group rather than an item.
+func isGroup(entry json.RawMessage) (bool, error) {
+	var probe struct {
+		Items json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(entry, &probe); err != nil {
+		return false, err
+	}
+	return probe.Items != nil, nil
+}
+
+// expandGroups decodes a phase list, replacing each group with its members.
+func expandGroups(phase string, entries []json.RawMessage) ([]Item, error) {
+	var items []Item
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		group, err := isGroup(entry)
+		if err != nil {
+			return nil, err
+		}
+		if !group {
+			var item Item
+			if err := json.Unmarshal(entry, &item); err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		var g Group
+		if err := json.Unmarshal(entry, &g); err != nil {
+			return nil, err
+		}
+		if g.Name == "" {
+			return nil, fmt.Errorf("%s: group without a name", phase)
+		}
+		if seen[g.Name] {
+			return nil, fmt.Errorf("%s: duplicate group '%s'", phase, g.Name)
+		}
+		seen[g.Name] = true
+		if len(g.Items) == 0 {
+			return nil, fmt.Errorf("%s: group '%s' has no items", phase, g.Name)
+		}
+		if err := validateFailPolicy(g.FailPolicy); err != nil {
+			return nil, fmt.Errorf("invalid fail_policy for group '%s': %w", g.Name, err)
+		}
+		for _, entry := range g.Items {
+			nested, err := isGroup(entry)
+			if err != nil {
+				return nil, err
+			}
+			if nested {
+				return nil, fmt.Errorf("%s: group '%s' contains a group; groups cannot be nested", phase, g.Name)
+			}
+			var item Item
+			if err := json.Unmarshal(entry, &item); err != nil {
+				return nil, err
+			}
+			item.Group = g.Name
+			if g.FailPolicy != "" {
+				if item.FailPolicy != "" && item.FailPolicy != g.FailPolicy {
+					return nil, fmt.Errorf("item '%s' sets fail_policy '%s' but its group '%s' uses '%s'", item.Name, item.FailPolicy, g.Name, g.FailPolicy)
+				}
+				item.FailPolicy = g.FailPolicy
+			}
+			if g.Parallel {
+				if item.ParallelGroup != "" && item.ParallelGroup != g.Name {
+					return nil, fmt.Errorf("item '%s' sets parallel_group '%s' inside parallel group '%s'", item.Name, item.ParallelGroup, g.Name)
+				}
+				item.ParallelGroup = g.Name
+			}
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// FailedGroups records the groups that have had a member fail during a
+// phase. The zero value is not usable; make one with make.
+type FailedGroups map[string]bool
+
+// Fail marks item's group, if any, as failed.
+func (f FailedGroups) Fail(item Item) {
+	if item.Group != "" {
+		f[item.Group] = true
+	}
+}
+
+// Skipped reports whether item belongs to a group that has already failed.
+func (f FailedGroups) Skipped(item Item) bool {
+	return item.Group != "" && f[item.Group]
+}
+
+// parallelSafeKey batches consecutive parallel_safe items. The NUL byte keeps
+// it from clashing with a parallel_group name.
+const parallelSafeKey = "\x00parallel_safe"
+
+// batchKey is what an item is batched by: its ParallelGroup, or
+// parallelSafeKey for a ParallelSafe item without one.
+func batchKey(it Item) string {
+	if it.ParallelGroup == "" && it.ParallelSafe {
+		return parallelSafeKey
+	}
+	return it.ParallelGroup
+}
+
+// BatchName labels a batch from BatchByParallelGroup in logs: its
+// parallel_group, or "parallel_safe".
+func BatchName(batch []Item) string {
+	if len(batch) == 0 {
+		return ""
+	}
+	if batchKey(batch[0]) == parallelSafeKey {
+		return "parallel_safe"
+	}
+	return batch[0].ParallelGroup
+}
+
 // BatchByParallelGroup partitions items into batches where consecutive items
 // sharing the same non-empty ParallelGroup form a single batch (run in
-// parallel by the caller). Items with an empty ParallelGroup form singleton
-// batches and run sequentially. Identity is positional: the run
+// parallel by the caller). Consecutive ParallelSafe items without a group
+// form a batch the same way. Other items form singleton batches and run
+// sequentially. Identity is positional: the run
 // alpha/alpha/beta/alpha returns three batches — {alpha,alpha}, {beta}, {alpha}.
 //
 // This is a pure helper; it does not validate item types or phases. Callers
-// should still validate (e.g. preflight allows only a single rootscript and
-// should ignore parallel_group).
+// should still validate (e.g. preflight allows only rootscripts and should
+// ignore parallel_group).
 func BatchByParallelGroup(items []Item) [][]Item {
 	if len(items) == 0 {
 		return nil
@@ -106,7 +513,8 @@ func BatchByParallelGroup(items []Item) [][]Item {
 	var current []Item
 	currentKey := ""
 	for _, it := range items {
-		if it.ParallelGroup == "" {
+		key := batchKey(it)
+		if key == "" {
 			// flush current group, then add a singleton
 			if len(current) > 0 {
 				batches = append(batches, current)
@@ -116,7 +524,7 @@ func BatchByParallelGroup(items []Item) [][]Item {
 			batches = append(batches, []Item{it})
 			continue
 		}
-		if it.ParallelGroup == currentKey {
+		if key == currentKey {
 			current = append(current, it)
 			continue
 		}
@@ -125,7 +533,7 @@ func BatchByParallelGroup(items []Item) [][]Item {
 			batches = append(batches, current)
 		}
 		current = []Item{it}
-		currentKey = it.ParallelGroup
+		currentKey = key
 	}
 	if len(current) > 0 {
 		batches = append(batches, current)
@@ -164,9 +572,13 @@ func LoadBootstrapWithOptions(filename string, validate bool) (*Bootstrap, error
 
 // ValidateBootstrap validates that items are appropriate for their phases
 func ValidateBootstrap(bootstrap *Bootstrap) error {
-	// Preflight supports a single rootscript only
-	if len(bootstrap.Preflight) > 1 {
-		return fmt.Errorf("preflight phase only supports a single rootscript")
+	// Preflight supports a single rootscript unless several are opted in to
+	opts := bootstrap.PreflightOptions
+	if len(bootstrap.Preflight) > 1 && (opts == nil || !opts.Multiple) {
+		return fmt.Errorf("preflight phase only supports a single rootscript (set preflight_options.multiple for more)")
+	}
+	if opts != nil && opts.Timeout < 0 {
+		return fmt.Errorf("preflight_options.timeout must not be negative: %d", opts.Timeout)
 	}
 	for _, item := range bootstrap.Preflight {
 		if err := validateItemForPhase(item, "preflight"); err != nil {
@@ -192,6 +604,43 @@ func ValidateBootstrap(bootstrap *Bootstrap) error {
 		}
 	}
 
+	if err := validateChoicesOrder(append(append([]Item{}, bootstrap.SetupAssistant...), bootstrap.Userland...)); err != nil {
+		return err
+	}
+
+	// Hooks are scripts; userscripts only around userland
+	for _, phase := range []string{"preflight", "setupassistant", "userland"} {
+		for _, hook := range [][]Item{bootstrap.Hooks.Pre(phase), bootstrap.Hooks.Post(phase)} {
+			for _, item := range hook {
+				if item.Type != "rootscript" && item.Type != "userscript" {
+					return fmt.Errorf("hook '%s' must be a rootscript or userscript, got: %s", item.Name, item.Type)
+				}
+				if phase == "preflight" && item.Type != "rootscript" {
+					return fmt.Errorf("preflight hooks only support rootscript type: %s", item.Name)
+				}
+				if err := validateItemForPhase(item, phase); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateChoicesOrder ensures that a choices file placed by a rootfile item
+// is placed before the package that applies it. items are in run order.
+func validateChoicesOrder(items []Item) error {
+	for i, item := range items {
+		if item.ChoicesXML == "" {
+			continue
+		}
+		for _, later := range items[i+1:] {
+			if later.Type == "rootfile" && later.File == item.ChoicesXML {
+				return fmt.Errorf("choices_xml of '%s' is placed by '%s', which runs after it", item.Name, later.Name)
+			}
+		}
+	}
 	return nil
 }
 
@@ -199,10 +648,10 @@ func ValidateBootstrap(bootstrap *Bootstrap) error {
 func validateItemForPhase(item Item, phase string) error {
 	// Validate allowed item types early
 	switch item.Type {
-	case "package", "rootscript", "userscript", "rootfile", "userfile":
+	case "package", "rootscript", "userscript", "rootfile", "userfile", "munki", "launchd", "vppapp":
 		// ok
 	default:
-		return fmt.Errorf("invalid item type '%s' for '%s' (allowed: package, rootscript, userscript, rootfile, userfile)", item.Type, item.Name)
+		return fmt.Errorf("invalid item type '%s' for '%s' (allowed: package, rootscript, userscript, rootfile, userfile, munki, launchd, vppapp)", item.Type, item.Name)
 	}
 
 	switch phase {
@@ -211,12 +660,65 @@ func validateItemForPhase(item Item, phase string) error {
 		if item.Type == "userscript" || item.Type == "userfile" {
 			return fmt.Errorf("phase '%s' only supports root operations (package, rootscript, rootfile), not '%s'", phase, item.Type)
 		}
+		// Munki needs the network and hands off the rest of the setup
+		if item.Type == "munki" {
+			return fmt.Errorf("munki items are only allowed in the userland phase, not '%s'", phase)
+		}
 	case "userland":
 		// Userland phase supports all types - no restrictions
 	default:
 		return fmt.Errorf("unknown phase: %s", phase)
 	}
 
+	// installer options only apply to items that install a package
+	if item.ChoicesXML != "" || item.AllowUntrusted || item.Target != "" {
+		if item.Type != "package" && item.Type != "munki" {
+			return fmt.Errorf("choices_xml, allow_untrusted and target only apply to package items, not '%s' (%s)", item.Type, item.Name)
+		}
+		if item.ChoicesXML != "" && !filepath.IsAbs(item.ChoicesXML) {
+			return fmt.Errorf("choices_xml of '%s' must be an absolute path: %s", item.Name, item.ChoicesXML)
+		}
+		if item.Target != "" && !filepath.IsAbs(item.Target) {
+			return fmt.Errorf("target of '%s' must be an absolute volume path: %s", item.Name, item.Target)
+		}
+	}
+
+	if item.HasInlineContent() {
+		if err := validateInlineContent(item); err != nil {
+			return err
+		}
+	}
+
+	if item.VerifySignature || item.TeamID != "" || item.SigningID != "" {
+		if err := validateSignatureCheck(item); err != nil {
+			return err
+		}
+	}
+
+	if item.Type == "launchd" || item.Action != "" || item.Label != "" || item.Domain != "" {
+		if err := validateLaunchdItem(item, phase); err != nil {
+			return err
+		}
+	}
+
+	if item.Type == "vppapp" || item.AdamID != "" || item.BundleID != "" {
+		if err := validateVPPApp(item); err != nil {
+			return err
+		}
+	}
+
+	if item.Owner != "" || item.Mode != "" || item.DirMode != "" || item.Backup || item.StripQuarantine || len(item.XAttrs) > 0 {
+		if err := validateFileMetadata(item); err != nil {
+			return err
+		}
+	}
+
+	if item.RunAs != "" {
+		if err := validateRunAs(item, phase); err != nil {
+			return err
+		}
+	}
+
 	// Validate fail policy if specified
 	if item.FailPolicy != "" {
 		if err := validateFailPolicy(item.FailPolicy); err != nil {
@@ -227,6 +729,177 @@ func validateItemForPhase(item Item, phase string) error {
 	return nil
 }
 
+// validateInlineContent checks the content or content_base64 of an item.
+// Inline content spares a short script or file its own URL and hash.
+func validateInlineContent(item Item) error {
+	switch item.Type {
+	case "rootscript", "userscript", "rootfile", "userfile":
+	default:
+		return fmt.Errorf("content and content_base64 only apply to script and file items, not '%s' (%s)", item.Type, item.Name)
+	}
+	if item.Content != "" && item.ContentBase64 != "" {
+		return fmt.Errorf("item '%s' cannot set both content and content_base64", item.Name)
+	}
+	if item.URL != "" {
+		return fmt.Errorf("item '%s' cannot set both url and inline content", item.Name)
+	}
+	// A userfile is staged by the daemon and placed by the agent, which
+	// resolves "~/"
+	if !filepath.IsAbs(item.File) && !(item.Type == "userfile" && strings.HasPrefix(item.File, "~/")) {
+		return fmt.Errorf("inline content of '%s' needs an absolute file path: %q", item.Name, item.File)
+	}
+	_, err := item.InlineContent()
+	return err
+}
+
+// teamIDPattern matches an Apple Team ID, and signingIDPattern a code
+// signing identifier; both end up quoted in a codesign requirement.
+var (
+	teamIDPattern    = regexp.MustCompile(`^[A-Z0-9]{10}$`)
+	signingIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+)
+
+// validateSignatureCheck checks the verify_signature, team_id and signing_id
+// of an item: packages check their installer signature, rootfiles and
+// userfiles the code signature of the placed file.
+func validateSignatureCheck(item Item) error {
+	switch item.Type {
+	case "package", "munki":
+		if item.SigningID != "" {
+			return fmt.Errorf("signing_id only applies to rootfile and userfile items, not '%s' (%s)", item.Type, item.Name)
+		}
+		return nil
+	case "rootfile", "userfile":
+	default:
+		return fmt.Errorf("verify_signature and team_id only apply to package, rootfile and userfile items, not '%s' (%s)", item.Type, item.Name)
+	}
+	if item.TeamID != "" && !teamIDPattern.MatchString(item.TeamID) {
+		return fmt.Errorf("team_id of '%s' is not a Team ID: %q", item.Name, item.TeamID)
+	}
+	if item.SigningID != "" && !signingIDPattern.MatchString(item.SigningID) {
+		return fmt.Errorf("signing_id of '%s' is not a signing identifier: %q", item.Name, item.SigningID)
+	}
+	return nil
+}
+
+// validateLaunchdItem checks the action, label and domain of a launchd item.
+// The gui domain needs a logged-in user, so only userland can use it.
+func validateLaunchdItem(item Item, phase string) error {
+	if item.Type != "launchd" {
+		return fmt.Errorf("action, label and domain only apply to launchd items, not '%s' (%s)", item.Type, item.Name)
+	}
+	valid := false
+	for _, a := range LaunchdActions {
+		valid = valid || item.Action == a
+	}
+	if !valid {
+		return fmt.Errorf("invalid action '%s' for launchd item '%s' (allowed: %s)", item.Action, item.Name, strings.Join(LaunchdActions, ", "))
+	}
+	if item.Action == "bootstrap" && item.File == "" {
+		return fmt.Errorf("launchd item '%s' needs the plist in file to bootstrap", item.Name)
+	}
+	if item.File == "" && item.Label == "" {
+		return fmt.Errorf("launchd item '%s' needs a label or a plist in file", item.Name)
+	}
+	switch item.Domain {
+	case "", "system":
+	case "gui":
+		if phase != "userland" {
+			return fmt.Errorf("launchd item '%s' can only use the gui domain in the userland phase, not '%s'", item.Name, phase)
+		}
+	default:
+		return fmt.Errorf("invalid domain '%s' for launchd item '%s' (allowed: system, gui)", item.Domain, item.Name)
+	}
+	return nil
+}
+
+// validateVPPApp checks a vppapp item. The app comes from the App Store
+// through the MDM, so there is nothing to download.
+func validateVPPApp(item Item) error {
+	if item.Type != "vppapp" {
+		return fmt.Errorf("adam_id and bundle_id only apply to vppapp items, not '%s' (%s)", item.Type, item.Name)
+	}
+	if item.AdamID == "" {
+		return fmt.Errorf("vppapp item '%s' needs the app's adam_id", item.Name)
+	}
+	if _, err := strconv.ParseUint(item.AdamID, 10, 64); err != nil {
+		return fmt.Errorf("adam_id of '%s' must be a numeric App Store ID: %q", item.Name, item.AdamID)
+	}
+	if item.URL != "" || item.Hash != "" {
+		return fmt.Errorf("vppapp item '%s' is installed by the MDM and cannot have a url or hash", item.Name)
+	}
+	if item.File != "" && !filepath.IsAbs(item.File) {
+		return fmt.Errorf("file of vppapp item '%s' must be the absolute path of the installed app: %s", item.Name, item.File)
+	}
+	return nil
+}
+
+// ownerPattern matches a user or group name, or a numeric ID.
+var ownerPattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_.-]*|[0-9]+)$`)
+
+// validateFileMetadata checks the owner, mode, dir_mode, backup and
+// extended attributes of a rootfile or userfile item.
+func validateFileMetadata(item Item) error {
+	if item.Type != "rootfile" && item.Type != "userfile" {
+		return fmt.Errorf("owner, mode, dir_mode, backup, strip_quarantine and xattrs only apply to rootfile and userfile items, not '%s' (%s)", item.Type, item.Name)
+	}
+	for name := range item.XAttrs {
+		// xattr would read a leading "-" as an option
+		if name == "" || strings.HasPrefix(name, "-") || strings.ContainsRune(name, 0) {
+			return fmt.Errorf("invalid xattr name for '%s': %q", item.Name, name)
+		}
+	}
+	// Only a download or inline content replaces the destination; "~/" is
+	// resolved by the agent, out of reach of the root process that keeps
+	// the backup
+	if item.Backup && (!item.Delivered() || !filepath.IsAbs(item.File)) {
+		return fmt.Errorf("backup of '%s' needs a url or inline content and an absolute file path", item.Name)
+	}
+	if item.Owner != "" {
+		if item.Type == "userfile" {
+			return fmt.Errorf("userfile '%s' always belongs to the console user and cannot set owner", item.Name)
+		}
+		user, group, _ := strings.Cut(item.Owner, ":")
+		if (user == "" && group == "") || (user != "" && !ownerPattern.MatchString(user)) || (group != "" && !ownerPattern.MatchString(group)) {
+			return fmt.Errorf("owner of '%s' must be user, user:group or :group: %q", item.Name, item.Owner)
+		}
+	}
+	mode, err := ParseFileMode(item.Mode)
+	if err != nil {
+		return fmt.Errorf("invalid mode for '%s': %w", item.Name, err)
+	}
+	if item.Type == "userfile" && mode != mode.Perm() {
+		return fmt.Errorf("mode of userfile '%s' cannot set setuid, setgid or sticky bits: %s", item.Name, item.Mode)
+	}
+	if _, err := ParseFileMode(item.DirMode); err != nil {
+		return fmt.Errorf("invalid dir_mode for '%s': %w", item.Name, err)
+	}
+	return nil
+}
+
+// runAsPattern matches local account short names. A leading "-" or "#"
+// would be read by sudo as an option or a UID.
+var runAsPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// validateRunAs checks the run_as of an item: only rootscripts switch users,
+// preflight always runs as root, and nobody is logged in to be the console
+// user before the userland phase.
+func validateRunAs(item Item, phase string) error {
+	if item.Type != "rootscript" {
+		return fmt.Errorf("run_as only applies to rootscript items, not '%s' (%s)", item.Type, item.Name)
+	}
+	if phase == "preflight" {
+		return fmt.Errorf("run_as is not supported in preflight (%s)", item.Name)
+	}
+	if item.RunAs == RunAsConsole && phase != "userland" {
+		return fmt.Errorf("run_as console is only allowed in the userland phase, not '%s' (%s)", phase, item.Name)
+	}
+	if !runAsPattern.MatchString(item.RunAs) {
+		return fmt.Errorf("run_as of '%s' is not a valid user name: %q", item.Name, item.RunAs)
+	}
+	return nil
+}
+
 // validateFailPolicy ensures fail policy values are valid
 func validateFailPolicy(policy string) error {
 	switch policy {
@@ -249,7 +922,8 @@ func (item *Item) GetEffectiveFailPolicy() string {
 
 // ShouldStopOnError applies the item's fail policy to decide whether a phase should abort.
 // operation should be one of "script execution", "package installation",
-// "file placement", "download", or "package receipt check".
+// "file placement", "service management", "download", or "package receipt
+// check".
 // Returns true to abort the phase, false to keep going.
 func (item *Item) ShouldStopOnError(operation string) bool {
 	switch item.GetEffectiveFailPolicy() {
@@ -262,3 +936,73 @@ func (item *Item) ShouldStopOnError(operation string) bool {
 		return true
 	}
 }
+
+// Restrict returns a copy of b limited to phase (when not empty) and to the
+// items named in items (when not empty), keeping their order. It lets a run
+// replay one phase or one failing item. Hooks are kept for the phases that
+// remain, and only named hook items are kept when items is set. Unknown phases and names that match
+// no remaining item are errors, so a typo does not turn into an empty run.
+func (b *Bootstrap) Restrict(phase string, items []string) (*Bootstrap, error) {
+	out := *b
+	switch phase {
+	case "":
+	case "preflight":
+		out = Bootstrap{Preflight: b.Preflight, PreflightOptions: b.PreflightOptions}
+	case "setupassistant":
+		out = Bootstrap{SetupAssistant: b.SetupAssistant}
+	case "userland":
+		out = Bootstrap{Userland: b.Userland}
+	default:
+		return nil, fmt.Errorf("unknown phase %q (want preflight, setupassistant or userland)", phase)
+	}
+	if phase != "" && b.Hooks != nil {
+		out.Hooks = &Hooks{}
+		out.Hooks.setPhase(phase, b.Hooks.Pre(phase), b.Hooks.Post(phase))
+	}
+	if len(items) == 0 {
+		return &out, nil
+	}
+
+	wanted := make(map[string]bool, len(items))
+	for _, name := range items {
+		wanted[name] = false
+	}
+	keep := func(phaseItems []Item) []Item {
+		var kept []Item
+		for _, item := range phaseItems {
+			if _, ok := wanted[item.Name]; ok {
+				wanted[item.Name] = true
+				kept = append(kept, item)
+			}
+		}
+		return kept
+	}
+	out.Preflight = keep(out.Preflight)
+	out.SetupAssistant = keep(out.SetupAssistant)
+	out.Userland = keep(out.Userland)
+	if out.Hooks != nil {
+		hooks := &Hooks{}
+		for _, p := range []string{"preflight", "setupassistant", "userland"} {
+			hooks.setPhase(p, keep(out.Hooks.Pre(p)), keep(out.Hooks.Post(p)))
+		}
+		out.Hooks = hooks
+	}
+	for _, name := range items {
+		if !wanted[name] {
+			return nil, fmt.Errorf("no item named %q in the bootstrap", name)
+		}
+	}
+	return &out, nil
+}
+
+// setPhase replaces the hooks of phase.
+func (h *Hooks) setPhase(phase string, pre, post []Item) {
+	switch phase {
+	case "preflight":
+		h.PrePreflight, h.PostPreflight = pre, post
+	case "setupassistant":
+		h.PreSetupAssistant, h.PostSetupAssistant = pre, post
+	case "userland":
+		h.PreUserland, h.PostUserland = pre, post
+	}
+}
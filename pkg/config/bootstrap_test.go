@@ -37,6 +37,286 @@ func TestPreflightOnlyRootscript(t *testing.T) {
 	}
 }
 
+func TestPreflightMultipleOptIn(t *testing.T) {
+	dir := t.TempDir()
+	checks := `"preflight": [
+    {"name": "mdm", "file": "/tmp/mdm.sh", "type": "rootscript"},
+    {"name": "edr", "file": "/tmp/edr.sh", "type": "rootscript"}
+  ]`
+	if _, err := LoadBootstrap(writeTemp(t, dir, "single.json", "{"+checks+"}")); err == nil {
+		t.Fatalf("expected error for several preflight scripts without the opt-in")
+	}
+	b, err := LoadBootstrap(writeTemp(t, dir, "multiple.json", `{`+checks+`, "preflight_options": {"multiple": true, "timeout": 120}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(b.Preflight) != 2 || b.PreflightOptions == nil || b.PreflightOptions.Timeout != 120 {
+		t.Fatalf("unexpected bootstrap: %+v", b)
+	}
+	if restricted, err := b.Restrict("preflight", nil); err != nil || restricted.PreflightOptions != b.PreflightOptions {
+		t.Errorf("Restrict dropped preflight_options: %+v, %v", restricted, err)
+	}
+
+	b.PreflightOptions.Timeout = -1
+	if err := ValidateBootstrap(b); err == nil {
+		t.Errorf("expected error for a negative timeout")
+	}
+}
+
+func TestValidateInlineContent(t *testing.T) {
+	valid := &Bootstrap{
+		Preflight: []Item{{Name: "check", Type: "rootscript", File: "/tmp/check.sh", Content: "#!/bin/sh\nexit 0\n"}},
+		Userland: []Item{
+			{Name: "defaults", Type: "userscript", File: "/tmp/defaults.sh", Content: "defaults write -g AppleShowAllExtensions -bool true"},
+			{Name: "dock", Type: "userfile", File: "~/Library/Preferences/com.apple.dock.plist", ContentBase64: "YnBsaXN0MDA="},
+			{Name: "conf", Type: "rootfile", File: "/etc/example.conf", Content: "enabled=1\n", Backup: true},
+		},
+	}
+	if err := ValidateBootstrap(valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, b := range map[string]*Bootstrap{
+		"package":       {Userland: []Item{{Name: "p", Type: "package", File: "/tmp/p.pkg", Content: "xar!"}}},
+		"home rootfile": {Userland: []Item{{Name: "f", Type: "rootfile", File: "~/f.conf", Content: "x"}}},
+		"both fields":   {Preflight: []Item{{Name: "s", Type: "rootscript", File: "/tmp/s.sh", Content: "exit 0", ContentBase64: "ZXhpdCAw"}}},
+		"with url":      {Preflight: []Item{{Name: "s", Type: "rootscript", File: "/tmp/s.sh", URL: "https://example.com/s.sh", Content: "exit 0"}}},
+		"relative file": {Preflight: []Item{{Name: "s", Type: "rootscript", File: "s.sh", Content: "exit 0"}}},
+		"bad base64":    {Preflight: []Item{{Name: "s", Type: "rootscript", File: "/tmp/s.sh", ContentBase64: "not base64!"}}},
+	} {
+		if err := ValidateBootstrap(b); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestValidateHooks(t *testing.T) {
+	dir := t.TempDir()
+	p := writeTemp(t, dir, "bootstrap.json", `{
+  "setupassistant": [{"name": "App", "file": "/tmp/app.pkg", "type": "package"}],
+  "hooks": {
+    "pre_setupassistant": [{"name": "network", "file": "/tmp/net.sh", "type": "rootscript", "fail_policy": "failure_is_not_an_option"}],
+    "post_userland": [{"name": "tidy", "file": "/tmp/tidy.sh", "type": "userscript"}]
+  }
+}`)
+	b, err := LoadBootstrap(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pre := b.Hooks.Pre("setupassistant"); len(pre) != 1 || pre[0].FailPolicy != "failure_is_not_an_option" {
+		t.Fatalf("pre_setupassistant = %+v", pre)
+	}
+	if len(b.Hooks.Post("userland")) != 1 || b.Hooks.Pre("userland") != nil {
+		t.Fatalf("hooks = %+v", b.Hooks)
+	}
+
+	for name, hooks := range map[string]*Hooks{
+		"package hook":                 {PreUserland: []Item{{Name: "pkg", File: "/tmp/x.pkg", Type: "package"}}},
+		"userscript in setupassistant": {PostSetupAssistant: []Item{{Name: "user", File: "/tmp/u.sh", Type: "userscript"}}},
+		"bad fail_policy":              {PrePreflight: []Item{{Name: "net", File: "/tmp/n.sh", Type: "rootscript", FailPolicy: "sometimes"}}},
+	} {
+		if err := ValidateBootstrap(&Bootstrap{Hooks: hooks}); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestValidatePackageInstallerOptions(t *testing.T) {
+	choices := Item{Name: "choices", File: "/Library/go-installapplications/choices.xml", Type: "rootfile"}
+	pkg := Item{Name: "Vendor", File: "/tmp/vendor.pkg", Type: "package", ChoicesXML: choices.File, AllowUntrusted: true, Target: "/"}
+	if err := ValidateBootstrap(&Bootstrap{SetupAssistant: []Item{choices}, Userland: []Item{pkg}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, b := range map[string]*Bootstrap{
+		"choices placed after the package": {SetupAssistant: []Item{pkg}, Userland: []Item{choices}},
+		"relative choices_xml":             {Userland: []Item{{Name: "p", File: "/tmp/p.pkg", Type: "package", ChoicesXML: "choices.xml"}}},
+		"relative target":                  {Userland: []Item{{Name: "p", File: "/tmp/p.pkg", Type: "package", Target: "Data"}}},
+		"options on a script":              {Userland: []Item{{Name: "s", File: "/tmp/s.sh", Type: "rootscript", AllowUntrusted: true}}},
+	} {
+		if err := ValidateBootstrap(b); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestValidateRunAs(t *testing.T) {
+	valid := &Bootstrap{
+		SetupAssistant: []Item{{Name: "svc", File: "/tmp/svc.sh", Type: "rootscript", RunAs: "_jamf"}},
+		Userland:       []Item{{Name: "console", File: "/tmp/c.sh", Type: "rootscript", RunAs: RunAsConsole}},
+	}
+	if err := ValidateBootstrap(valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, b := range map[string]*Bootstrap{
+		"run_as on a package":          {Userland: []Item{{Name: "p", File: "/tmp/p.pkg", Type: "package", RunAs: "admin"}}},
+		"run_as on a userscript":       {Userland: []Item{{Name: "u", File: "/tmp/u.sh", Type: "userscript", RunAs: "admin"}}},
+		"run_as in preflight":          {Preflight: []Item{{Name: "pre", File: "/tmp/pre.sh", Type: "rootscript", RunAs: "admin"}}},
+		"console before userland":      {SetupAssistant: []Item{{Name: "s", File: "/tmp/s.sh", Type: "rootscript", RunAs: RunAsConsole}}},
+		"option-like user name":        {Userland: []Item{{Name: "s", File: "/tmp/s.sh", Type: "rootscript", RunAs: "-s"}}},
+		"uid instead of the user name": {Userland: []Item{{Name: "s", File: "/tmp/s.sh", Type: "rootscript", RunAs: "#501"}}},
+	} {
+		if err := ValidateBootstrap(b); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestValidateLaunchdItems(t *testing.T) {
+	valid := &Bootstrap{
+		SetupAssistant: []Item{
+			{Name: "Load daemon", Type: "launchd", Action: "bootstrap", File: "/Library/LaunchDaemons/com.example.d.plist"},
+			{Name: "Enable daemon", Type: "launchd", Action: "enable", Label: "com.example.d", Domain: "system"},
+		},
+		Userland: []Item{{Name: "Restart agent", Type: "launchd", Action: "kickstart", Label: "com.example.a", Domain: "gui"}},
+	}
+	if err := ValidateBootstrap(valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, b := range map[string]*Bootstrap{
+		"unknown action":          {Userland: []Item{{Name: "l", Type: "launchd", Action: "load", Label: "com.example.a"}}},
+		"bootstrap without plist": {Userland: []Item{{Name: "l", Type: "launchd", Action: "bootstrap", Label: "com.example.a"}}},
+		"no label or plist":       {Userland: []Item{{Name: "l", Type: "launchd", Action: "kickstart"}}},
+		"gui before userland":     {SetupAssistant: []Item{{Name: "l", Type: "launchd", Action: "kickstart", Label: "com.example.a", Domain: "gui"}}},
+		"unknown domain":          {Userland: []Item{{Name: "l", Type: "launchd", Action: "kickstart", Label: "com.example.a", Domain: "user"}}},
+		"action on a script":      {Userland: []Item{{Name: "s", File: "/tmp/s.sh", Type: "rootscript", Action: "kickstart"}}},
+	} {
+		if err := ValidateBootstrap(b); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestValidateVPPAppItems(t *testing.T) {
+	valid := &Bootstrap{
+		SetupAssistant: []Item{{Name: "Keynote", Type: "vppapp", AdamID: "409183694"}},
+		Userland:       []Item{{Name: "Xcode", Type: "vppapp", AdamID: "497799835", BundleID: "com.apple.dt.Xcode", File: "/Applications/Xcode.app"}},
+	}
+	if err := ValidateBootstrap(valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, b := range map[string]*Bootstrap{
+		"no adam_id":          {Userland: []Item{{Name: "a", Type: "vppapp"}}},
+		"non-numeric adam_id": {Userland: []Item{{Name: "a", Type: "vppapp", AdamID: "id409183694"}}},
+		"with url":            {Userland: []Item{{Name: "a", Type: "vppapp", AdamID: "409183694", URL: "https://example.com/a.pkg"}}},
+		"relative app path":   {Userland: []Item{{Name: "a", Type: "vppapp", AdamID: "409183694", File: "Keynote.app"}}},
+		"adam_id on package":  {Userland: []Item{{Name: "p", Type: "package", File: "/tmp/p.pkg", AdamID: "409183694"}}},
+	} {
+		if err := ValidateBootstrap(b); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestValidateFileMetadata(t *testing.T) {
+	valid := &Bootstrap{
+		SetupAssistant: []Item{{Name: "sudoers", Type: "rootfile", File: "/etc/sudoers.d/admins", Owner: "root:wheel", Mode: "0440", DirMode: "0750"}},
+		Userland: []Item{
+			{Name: "prefs", Type: "userfile", File: "/tmp/prefs.plist", Mode: "600", DirMode: "0700"},
+			{Name: "helper", Type: "userfile", File: "/tmp/helper", StripQuarantine: true, XAttrs: map[string]string{"com.example.managed": "1"}},
+		},
+	}
+	if err := ValidateBootstrap(valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, b := range map[string]*Bootstrap{
+		"owner on userfile":  {Userland: []Item{{Name: "u", Type: "userfile", File: "/tmp/u", Owner: "root"}}},
+		"bad owner":          {Userland: []Item{{Name: "r", Type: "rootfile", File: "/tmp/r", Owner: "root:-g"}}},
+		"empty owner parts":  {Userland: []Item{{Name: "r", Type: "rootfile", File: "/tmp/r", Owner: ":"}}},
+		"non-octal mode":     {Userland: []Item{{Name: "r", Type: "rootfile", File: "/tmp/r", Mode: "0855"}}},
+		"mode too large":     {Userland: []Item{{Name: "r", Type: "rootfile", File: "/tmp/r", Mode: "17777"}}},
+		"setuid userfile":    {Userland: []Item{{Name: "u", Type: "userfile", File: "/tmp/u", Mode: "4755"}}},
+		"bad dir_mode":       {Userland: []Item{{Name: "r", Type: "rootfile", File: "/tmp/r", DirMode: "rwx"}}},
+		"backup without url": {Userland: []Item{{Name: "r", Type: "rootfile", File: "/tmp/r", Backup: true}}},
+		"backup of ~/ path":  {Userland: []Item{{Name: "u", Type: "userfile", File: "~/.zshrc", URL: "https://example.com/zshrc", Backup: true}}},
+		"mode on a package":  {Userland: []Item{{Name: "p", Type: "package", File: "/tmp/p.pkg", Mode: "0644"}}},
+		"xattrs on a script": {Userland: []Item{{Name: "s", Type: "userscript", File: "/tmp/s.sh", StripQuarantine: true}}},
+		"option xattr name":  {Userland: []Item{{Name: "r", Type: "rootfile", File: "/tmp/r", XAttrs: map[string]string{"-c": "x"}}}},
+	} {
+		if err := ValidateBootstrap(b); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+
+	if mode, err := ParseFileMode("4755"); err != nil || mode != os.ModeSetuid|0755 {
+		t.Errorf("ParseFileMode(4755) = %v, %v", mode, err)
+	}
+}
+
+func TestValidateSignatureCheck(t *testing.T) {
+	valid := &Bootstrap{Userland: []Item{
+		{Name: "pkg", Type: "package", File: "/tmp/p.pkg", TeamID: "ABCDE12345"},
+		{Name: "helper", Type: "rootfile", File: "/tmp/helper", TeamID: "ABCDE12345", SigningID: "com.example.helper"},
+		{Name: "tool", Type: "userfile", File: "/tmp/tool", VerifySignature: true},
+	}}
+	if err := ValidateBootstrap(valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, b := range map[string]*Bootstrap{
+		"script":              {Userland: []Item{{Name: "s", Type: "rootscript", File: "/tmp/s.sh", VerifySignature: true}}},
+		"signing_id on a pkg": {Userland: []Item{{Name: "p", Type: "package", File: "/tmp/p.pkg", SigningID: "com.example.p"}}},
+		"quoted team_id":      {Userland: []Item{{Name: "r", Type: "rootfile", File: "/tmp/r", TeamID: `ABCDE"1234`}}},
+		"quoted signing_id":   {Userland: []Item{{Name: "r", Type: "rootfile", File: "/tmp/r", SigningID: `x" or true`}}},
+	} {
+		if err := ValidateBootstrap(b); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestLoadBootstrapGroups(t *testing.T) {
+	dir := t.TempDir()
+	p := writeTemp(t, dir, "bootstrap.json", `{
+  "userland": [
+    {"name": "Dock", "file": "/tmp/dock.sh", "type": "userscript"},
+    {"group": "browsers", "fail_policy": "failable", "parallel": true, "items": [
+      {"name": "Chrome", "file": "/tmp/chrome.pkg", "type": "package"},
+      {"name": "Firefox", "file": "/tmp/firefox.pkg", "type": "package"}
+    ]},
+    {"group": "security", "fail_policy": "failure_is_not_an_option", "items": [
+      {"name": "EDR", "file": "/tmp/edr.pkg", "type": "package"}
+    ]}
+  ]
+}`)
+	b, err := LoadBootstrap(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Userland) != 4 {
+		t.Fatalf("want 4 userland items, got %+v", b.Userland)
+	}
+	for _, item := range b.Userland[1:3] {
+		if item.Group != "browsers" || item.FailPolicy != "failable" || item.ParallelGroup != "browsers" {
+			t.Errorf("browser member = %+v", item)
+		}
+	}
+	if edr := b.Userland[3]; edr.Group != "security" || edr.FailPolicy != "failure_is_not_an_option" || edr.ParallelGroup != "" {
+		t.Errorf("security member = %+v", edr)
+	}
+	if b.Userland[0].Group != "" {
+		t.Errorf("ungrouped item got group %q", b.Userland[0].Group)
+	}
+
+	for name, userland := range map[string]string{
+		"no name":     `[{"items": [{"name": "a", "file": "/tmp/a.sh", "type": "rootscript"}]}]`,
+		"empty":       `[{"group": "g", "items": []}]`,
+		"duplicate":   `[{"group": "g", "items": [{"name": "a", "type": "rootscript"}]}, {"group": "g", "items": [{"name": "b", "type": "rootscript"}]}]`,
+		"nested":      `[{"group": "g", "items": [{"group": "h", "items": [{"name": "a", "type": "rootscript"}]}]}]`,
+		"conflicting": `[{"group": "g", "fail_policy": "failable", "items": [{"name": "a", "type": "rootscript", "fail_policy": "failure_is_not_an_option"}]}]`,
+		"bad policy":  `[{"group": "g", "fail_policy": "sometimes", "items": [{"name": "a", "type": "rootscript"}]}]`,
+	} {
+		p := writeTemp(t, dir, "bad.json", `{"userland": `+userland+`}`)
+		if _, err := LoadBootstrapWithOptions(p, false); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
 func TestLoadBootstrapWithSkipValidation(t *testing.T) {
 	tdir := t.TempDir()
 	// Create a bootstrap with an invalid type
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"howett.net/plist"
+)
+
+// ErrPreferencesUnreadable is returned, wrapped, when the preferences of a
+// domain cannot be read or parsed, as opposed to a key that is not set.
+var ErrPreferencesUnreadable = errors.New("cannot read preferences")
+
+// copyAppValues reads keys of domain as CFPreferences resolves them; a
+// variable so tests can replace it.
+var copyAppValues = cfCopyAppValues
+
+// defaultsExport runs defaults with args and returns what it prints; a
+// variable so tests can replace it.
+var defaultsExport = func(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("defaults", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, err
+}
+
+// cfCopyAppValues resolves each key in the order CFPreferences does: forced
+// values from managed preferences first, then by-host and per-user
+// preferences. All three come from defaults export, so they are served from
+// cfprefsd's cache rather than plists that may not have been written out
+// yet; where defaults does not exist, as off macOS, the managed and user
+// plists are read instead. Keys without a value are left out. A preference
+// source that cannot be read fails with ErrPreferencesUnreadable.
+func cfCopyAppValues(domain string, keys []string) (map[string]interface{}, error) {
+	managedPath := filepath.Join(managedPrefsDir, domain+".plist")
+	var sources []map[string]interface{}
+	if _, err := os.Stat(managedPath); err == nil {
+		managed, err := exportDomain("export", strings.TrimSuffix(managedPath, ".plist"), "-")
+		if errors.Is(err, exec.ErrNotFound) {
+			managed, err = readPlistValues(managedPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, managed)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %v", ErrPreferencesUnreadable, err)
+	}
+	for _, args := range [][]string{{"-currentHost", "export", domain, "-"}, {"export", domain, "-"}} {
+		values, err := exportDomain(args...)
+		if errors.Is(err, exec.ErrNotFound) {
+			values, err = readUserPlist(domain)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, values)
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, values)
+	}
+
+	values := make(map[string]interface{})
+	for _, key := range keys {
+		for _, source := range sources {
+			if value, ok := source[key]; ok {
+				values[key] = value
+				break
+			}
+		}
+	}
+	return values, nil
+}
+
+// exportDomain runs defaults with args, an export to stdout, and parses the
+// plist it prints. An exec.ErrNotFound is returned as is, so callers can
+// fall back to the plist files.
+func exportDomain(args ...string) (map[string]interface{}, error) {
+	out, err := defaultsExport(args...)
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, err
+	}
+	domain := args[len(args)-2]
+	if err != nil {
+		return nil, fmt.Errorf("%w of %s: defaults %s: %v", ErrPreferencesUnreadable, domain, strings.Join(args, " "), err)
+	}
+	var values map[string]interface{}
+	if _, err := plist.Unmarshal(out, &values); err != nil {
+		return nil, fmt.Errorf("%w of %s: %v", ErrPreferencesUnreadable, domain, err)
+	}
+	return values, nil
+}
+
+// readUserPlist reads the user's plist for domain, as written by defaults
+// write.
+func readUserPlist(domain string) (map[string]interface{}, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil
+	}
+	return readPlistValues(filepath.Join(homeDir, "Library", "Preferences", domain+".plist"))
+}
+
+// readPlistValues reads the plist at path. A plist that does not exist has
+// no values.
+func readPlistValues(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPreferencesUnreadable, err)
+	}
+	var values map[string]interface{}
+	if _, err := plist.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("%w: cannot parse %s: %v", ErrPreferencesUnreadable, path, err)
+	}
+	return values, nil
+}
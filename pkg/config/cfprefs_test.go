@@ -0,0 +1,92 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// stubPreferences points managedPrefsDir at a temporary directory holding
+// managed, if set, and makes defaults export it, byHost and user.
+func stubPreferences(t *testing.T, managed, byHost, user string) {
+	t.Helper()
+	dir := t.TempDir()
+	if managed != "" {
+		if err := os.WriteFile(filepath.Join(dir, "com.example.prefs.plist"), []byte(managed), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	origDir, origExport := managedPrefsDir, defaultsExport
+	managedPrefsDir = dir
+	defaultsExport = func(args ...string) ([]byte, error) {
+		switch {
+		case args[0] == "-currentHost":
+			return []byte(byHost), nil
+		case filepath.IsAbs(args[1]):
+			return os.ReadFile(args[1] + ".plist")
+		}
+		return []byte(user), nil
+	}
+	t.Cleanup(func() { managedPrefsDir, defaultsExport = origDir, origExport })
+}
+
+func plistDict(body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?><plist version="1.0"><dict>` + body + `</dict></plist>`
+}
+
+func TestCopyAppValuesLayersSources(t *testing.T) {
+	stubPreferences(t,
+		plistDict(`<key>shared</key><string>managed</string>`),
+		plistDict(`<key>shared</key><string>host</string><key>daemon</key><string>host</string>`),
+		plistDict(`<key>daemon</key><string>user</string><key>bootstrap</key><string>user</string><key>other</key><true/>`))
+
+	values, err := cfCopyAppValues("com.example.prefs", []string{"shared", "daemon", "bootstrap", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"shared": "managed", "daemon": "host", "bootstrap": "user"}
+	if len(values) != len(want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+	for key, value := range want {
+		if values[key] != value {
+			t.Errorf("%s = %v, want %s", key, values[key], value)
+		}
+	}
+}
+
+func TestCopyAppValuesReadFailure(t *testing.T) {
+	stubPreferences(t, "not a plist", plistDict(""), plistDict(""))
+	if _, err := cfCopyAppValues("com.example.prefs", []string{"shared"}); !errors.Is(err, ErrPreferencesUnreadable) {
+		t.Errorf("unparsable managed plist: err = %v", err)
+	}
+
+	stubPreferences(t, "", plistDict(""), plistDict(""))
+	defaultsExport = func(args ...string) ([]byte, error) { return nil, errors.New("exit status 1") }
+	if _, err := cfCopyAppValues("com.example.prefs", []string{"shared"}); !errors.Is(err, ErrPreferencesUnreadable) {
+		t.Errorf("failed defaults export: err = %v", err)
+	}
+
+	// Without defaults, as off macOS, only the plists are read
+	stubPreferences(t, plistDict(`<key>shared</key><string>managed</string>`), "", "")
+	defaultsExport = func(args ...string) ([]byte, error) { return nil, exec.ErrNotFound }
+	t.Setenv("HOME", t.TempDir())
+	values, err := cfCopyAppValues("com.example.prefs", []string{"shared"})
+	if err != nil || values["shared"] != "managed" {
+		t.Errorf("without defaults: values = %v, err = %v", values, err)
+	}
+
+	cfg := NewConfig()
+	cfg.ProfileDomain = "com.example.prefs"
+	if err := os.WriteFile(filepath.Join(managedPrefsDir, "com.example.prefs.plist"), []byte("not a plist"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.ProfileValue("shared"); !errors.Is(err, ErrPreferencesUnreadable) {
+		t.Errorf("ProfileValue on an unreadable profile: err = %v", err)
+	}
+	if _, err := cfg.ReadFromProfile("com.example.prefs"); !errors.Is(err, ErrPreferencesUnreadable) {
+		t.Errorf("ReadFromProfile on an unreadable profile: err = %v", err)
+	}
+}
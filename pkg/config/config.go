@@ -1,58 +1,175 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
-// Config represents the main configuration for go-installapplications
+// UserlandGatePolicy values.
+const (
+	UserlandGateAgent    = "agent"    // wait for the console user's agent (default)
+	UserlandGateLogin    = "login"    // wait for a console user, then run user items via launchctl asuser
+	UserlandGateDeadline = "deadline" // wait for the agent until UserlandGateDeadline, then run user items best-effort via launchctl asuser
+)
+
+// RebootMethod values.
+const (
+	RebootShutdown = "shutdown" // /sbin/shutdown -r now (default)
+	RebootMDM      = "mdm"      // a RestartDevice command through the Jamf Pro API, falling back to shutdown
+)
+
+// RebootCondition values.
+const (
+	RebootAlways      = "always"    // every successful run (default)
+	RebootIfInstalled = "installed" // only when an item was installed or run, not all skipped
+	RebootIfRequired  = "required"  // only when an installed package requires a restart
+)
+
+// CleanupScope values: what the cleanup at the end of a run removes.
+const (
+	CleanupDownloads   = "downloads"    // the files the run downloaded (with CleanupOnSuccess/CleanupOnFailure)
+	CleanupInstallPath = "install_path" // InstallPath, except the per-item logs
+	CleanupLaunchd     = "launchd"      // the LaunchDaemon and LaunchAgent: booted out and their plists removed
+	CleanupRetryState  = "retry_state"  // the daemon's attempt count, after a successful run only
+	CleanupLogs        = "logs"         // the per-item logs in ItemLogDir
+)
+
+// CleanupScopes are the valid CleanupScope values.
+var CleanupScopes = []string{CleanupDownloads, CleanupInstallPath, CleanupLaunchd, CleanupRetryState, CleanupLogs}
+
+// DefaultCleanupScope is what cleanup removes when CleanupScope is empty.
+var DefaultCleanupScope = []string{CleanupDownloads, CleanupInstallPath, CleanupLaunchd}
+
+// IdentityAuth values.
+const (
+	IdentityAuthMTLS      = "mtls"      // present the identity as TLS client certificate
+	IdentityAuthSignature = "signature" // sign each request, see identity.SignRequest
+)
+
+// Config represents the main configuration for go-installapplications.
+//
+// Each exported field is read from the profile key of the same name (see
+// applySettingsMap). The profile tag excludes fields that only come from
+// flags or defaults (profile:"-") and ignores empty strings for settings
+// that cannot be turned off (profile:",nonempty").
 type Config struct {
 	JSONURL     string `json:"jsonurl"`
-	InstallPath string `json:"install_path"`
+	InstallPath string `json:"install_path" profile:",nonempty"`
 	Debug       bool   `json:"debug"`
 	Verbose     bool   `json:"verbose"`
 	Reboot      bool   `json:"reboot"`
+	// RebootDelay is how long the console user is warned with RebootMessage
+	// ("{{delay}}" is replaced by the delay; empty = no warning) before the
+	// reboot. RebootMethod and RebootCondition are the values above.
+	RebootDelay     time.Duration `json:"reboot_delay"`
+	RebootMessage   string        `json:"reboot_message,omitempty"`
+	RebootMethod    string        `json:"reboot_method" profile:",nonempty"`
+	RebootCondition string        `json:"reboot_condition" profile:",nonempty"`
 
 	// Retry settings
 	MaxRetries int `json:"max_retries"`
 	RetryDelay int `json:"retry_delay"` // seconds
 
+	// Daemon attempts: how many times launchd may restart a failed daemon
+	// before it gives up (0 = no limit), and where the count is kept (empty =
+	// derived from the profile domain, see RetryStateFile)
+	DaemonMaxRetries int    `json:"daemon_max_retries"`
+	RetryStatePath   string `json:"retry_state_path,omitempty" profile:",nonempty"`
+	// DaemonRetryLimits replaces DaemonMaxRetries for failures of a category
+	// (network, validation, install, ipc), e.g. no limit for network failures
+	// but 1 attempt for validation failures; 0 = no limit
+	DaemonRetryLimits map[string]int `json:"daemon_retry_limits,omitempty"`
+
 	// Cleanup settings
 	CleanupOnFailure bool `json:"cleanup_on_failure"`
 	KeepFailedFiles  bool `json:"keep_failed_files"`  // For debugging
 	CleanupOnSuccess bool `json:"cleanup_on_success"` // Remove downloaded artifacts after success
+	// CleanupScope is what the cleanup at the end of a run removes, see
+	// CleanupScopes; empty = DefaultCleanupScope. CleanupPreserve lists
+	// absolute paths cleanup never removes, with everything under them.
+	CleanupScope    []string `json:"cleanup_scope,omitempty"`
+	CleanupPreserve []string `json:"cleanup_preserve,omitempty"`
+	// RollbackOnFailure undoes the items of a failed run: placed files are
+	// removed and each item's rollback command is run, newest first
+	RollbackOnFailure bool `json:"rollback_on_failure"`
 
 	// Execution settings
 	DryRun bool `json:"dry_run"` // Don't actually install/execute anything
 
 	TrackBackgroundProcesses bool          `json:"track_background_processes"` // New enhancement!
 	BackgroundTimeout        time.Duration `json:"background_timeout"`         // How long to wait for background processes
+	// PhaseTimeout and RunDeadline stop a phase (hooks included) or the whole
+	// run that takes longer, failing it with the usual cleanup. 0 = no limit.
+	PhaseTimeout time.Duration `json:"phase_timeout"`
+	RunDeadline  time.Duration `json:"run_deadline"`
+	// RemediationInterval has a successful daemon run leave a LaunchDaemon
+	// behind that re-checks the installed items this often and reinstalls
+	// the ones that drifted. 0 = off. RemediationPath keeps the job's binary
+	// and bootstrap, outside InstallPath, which cleanup removes.
+	RemediationInterval time.Duration `json:"remediation_interval"`
+	RemediationPath     string        `json:"remediation_path"`
 	// Download concurrency
 	DownloadMaxConcurrency int `json:"download_max_concurrency"`
+	// InstallMaxConcurrency bounds how many items of a parallel batch
+	// (parallel_group or parallel_safe) install at once. 0 = no limit.
+	InstallMaxConcurrency int `json:"install_max_concurrency"`
 	// IPC and coordination
 	WaitForAgentTimeout time.Duration `json:"wait_for_agent_timeout"` // How long daemon waits for agent socket
 	AgentRequestTimeout time.Duration `json:"agent_request_timeout"`  // How long daemon waits for a single agent RPC
+	// UserlandGatePolicy is what the daemon's userland phase waits for once
+	// Setup Assistant is over: UserlandGateAgent, UserlandGateLogin or
+	// UserlandGateDeadline.
+	UserlandGatePolicy   string        `json:"userland_gate_policy" profile:",nonempty"`
+	UserlandGateDeadline time.Duration `json:"userland_gate_deadline"` // "deadline" policy: how long to wait for the agent
+	// UserlandWaitForDesktop holds user items until the console user's first
+	// full login has reached the desktop (Dock up, no Setup Assistant).
+	// Implied while FileVault is set to be enabled at the next login.
+	UserlandWaitForDesktop bool `json:"userland_wait_for_desktop"`
 
 	// HTTP Authentication
-	HTTPAuthUser        string            `json:"http_auth_user,omitempty"`
-	HTTPAuthPassword    string            `json:"http_auth_password,omitempty"`
-	HTTPHeaders         map[string]string `json:"http_headers,omitempty"`         // Custom headers
-	HeaderAuthorization string            `json:"header_authorization,omitempty"` // for --headers convenience
+	HTTPAuthUser        string            `json:"http_auth_user,omitempty" profile:",nonempty"`
+	HTTPAuthPassword    string            `json:"http_auth_password,omitempty" profile:",nonempty"`
+	HTTPHeaders         map[string]string `json:"http_headers,omitempty"`                             // Custom headers
+	HeaderAuthorization string            `json:"header_authorization,omitempty" profile:",nonempty"` // for --headers convenience
+
+	// IdentityAuth authenticates the bootstrap and item downloads with the
+	// System keychain identity whose certificate is IdentityCommonName, such
+	// as the MDM identity: IdentityAuthMTLS or IdentityAuthSignature. Empty
+	// is off.
+	IdentityAuth       string `json:"identity_auth,omitempty"`
+	IdentityCommonName string `json:"identity_common_name,omitempty"`
 
 	// Remote log shipping (generic)
-	LogDestination string            `json:"log_destination,omitempty"`
-	LogProvider    string            `json:"log_provider,omitempty"` // e.g., "generic", "datadog"
-	LogHeaders     map[string]string `json:"log_headers,omitempty"`
-	LogFilePath    string            `json:"log_file_path,omitempty"` // optional: force logging to this file (also logs to console)
+	LogDestination string            `json:"log_destination,omitempty" profile:"-"`
+	LogProvider    string            `json:"log_provider,omitempty" profile:"-"` // e.g., "generic", "datadog"
+	LogHeaders     map[string]string `json:"log_headers,omitempty" profile:"-"`
+	LogFilePath    string            `json:"log_file_path,omitempty" profile:",nonempty"` // optional: force logging to this file (also logs to console)
+	// LogLevels sets the lowest level logged by a component (download,
+	// installer, ipc, manager) in place of Debug and Verbose, e.g.
+	// {"download": "debug", "ipc": "error"}.
+	LogLevels map[string]string `json:"log_levels,omitempty"`
+
+	// StrictProfile stops the run when the profile has keys that name no
+	// setting, instead of warning and ignoring them
+	StrictProfile bool `json:"strict_profile"`
 
 	// Mode settings
-	Mode string `json:"mode"` // "daemon", "agent", or "standalone"
+	Mode string `json:"mode" profile:"-"` // "daemon", "agent", "standalone" or "webhook"
+
+	// ProfileDomain is the preference domain the config was read from; the
+	// embedded bootstrap is loaded from the same domain
+	ProfileDomain string `json:"profile_domain" profile:"-"`
 
 	// Compat flags
+	Compat                 bool   `json:"compat" profile:"-"` // original InstallApplications paths and identifiers, see ApplyCompat
 	FollowRedirects        bool   `json:"follow_redirects"`
 	SkipValidation         bool   `json:"skip_validation"`
-	LaunchAgentIdentifier  string `json:"launch_agent_identifier"`
-	LaunchDaemonIdentifier string `json:"launch_daemon_identifier"`
+	LaunchAgentIdentifier  string `json:"launch_agent_identifier" profile:",nonempty"`
+	LaunchDaemonIdentifier string `json:"launch_daemon_identifier" profile:",nonempty"`
 
 	// HashCheckPolicy controls how missing / mismatching SHA-256 hashes are
 	// treated on downloads (case-insensitive):
@@ -61,21 +178,113 @@ type Config struct {
 	//                mismatches fail. (default)
 	//   - "Ignore":  missing hash is silently allowed; mismatches log a
 	//                warning but do not fail.
-	HashCheckPolicy string `json:"hash_check_policy"`
+	HashCheckPolicy string `json:"hash_check_policy" profile:",nonempty"`
+
+	// VerifyPackageSignatures checks every package before it is installed:
+	// pkgutil --check-signature must pass, spctl must accept it for install
+	// and, when AllowedTeamIDs is set, it must be signed by one of those
+	// Team IDs. Items opt in on their own with verify_signature or team_id.
+	VerifyPackageSignatures bool     `json:"verify_package_signatures"`
+	AllowedTeamIDs          []string `json:"allowed_team_ids,omitempty"`
+
+	RetainLogFiles bool `json:"retain_log_files"`      // Retain log files from previous runs
+	KeepLogs       bool `json:"keep_logs" profile:"-"` // Uninstall mode: leave log files and the audit log in place
 
-	RetainLogFiles bool `json:"retain_log_files"` // Retain log files from previous runs
+	// Status mode output
+	StatusJSON     bool `json:"status_json" profile:"-"`      // print the report as JSON
+	StatusLogLines int  `json:"status_log_lines" profile:"-"` // lines shown from the end of each log
+
+	// PlistOutputDir is where plists mode writes the generated launchd plists
+	PlistOutputDir string `json:"plist_output_dir" profile:"-"`
+
+	// Restrict a run to one phase and/or named items, for debugging one
+	// failing item without replaying the whole enrollment
+	OnlyPhase string   `json:"only_phase,omitempty" profile:"-"`
+	OnlyItems []string `json:"only_items,omitempty" profile:"-"`
+
+	// SimulationFile sets item durations and injected failures for simulate
+	// mode; empty uses the defaults
+	SimulationFile string `json:"simulation_file,omitempty" profile:"-"`
 
 	WithPreflight    bool `json:"with_preflight"`      // Run preflight phase in standalone mode
 	NoRestartOnError bool `json:"no_restart_on_error"` // Exit 0 on errors to prevent restart
 
+	// swiftDialog progress UI (launched in the console user's session)
+	SwiftDialog            bool     `json:"swift_dialog"`
+	SwiftDialogPath        string   `json:"swift_dialog_path,omitempty" profile:",nonempty"`
+	SwiftDialogCommandFile string   `json:"swift_dialog_command_file,omitempty" profile:",nonempty"`
+	SwiftDialogTitle       string   `json:"swift_dialog_title,omitempty"`
+	SwiftDialogMessage     string   `json:"swift_dialog_message,omitempty"`
+	SwiftDialogIcon        string   `json:"swift_dialog_icon,omitempty"`
+	SwiftDialogArgs        []string `json:"swift_dialog_args,omitempty"` // extra dialog CLI arguments
+
+	// Metrics (Prometheus text / OTLP). Both outputs are disabled when empty.
+	MetricsListenAddress string        `json:"metrics_listen_address,omitempty"`                  // serve /metrics during the run, e.g. 127.0.0.1:9464
+	MetricsLinger        time.Duration `json:"metrics_linger"`                                    // keep /metrics up after the run
+	MetricsPushURL       string        `json:"metrics_push_url,omitempty"`                        // Pushgateway base URL or OTLP /v1/metrics URL
+	MetricsPushFormat    string        `json:"metrics_push_format,omitempty" profile:",nonempty"` // "pushgateway" (default) or "otlp"
+	MetricsJob           string        `json:"metrics_job,omitempty" profile:",nonempty"`         // Pushgateway job / OTLP service name
+
+	// OpenTelemetry tracing (OTLP/HTTP JSON). Disabled when the endpoint is empty.
+	TracingEndpoint    string `json:"tracing_endpoint,omitempty"` // e.g. http://collector:4318/v1/traces
+	TracingServiceName string `json:"tracing_service_name,omitempty" profile:",nonempty"`
+
+	// Status reporting: per-item and final status JSON is PUT to StatusURL.
+	// Disabled when empty. StatusHeaders are sent with every report.
+	StatusURL     string            `json:"status_url,omitempty"`
+	StatusHeaders map[string]string `json:"status_headers,omitempty"`
+	// StatusPlistPath receives completion status and per-item results for MDM
+	// extension attribute scripts. Disabled when empty.
+	StatusPlistPath string `json:"status_plist_path,omitempty"`
+
+	// FileBackupDir keeps the files that items with backup replace, under
+	// their full path. Empty keeps each next to the file as <file>.bak.
+	FileBackupDir string `json:"file_backup_dir,omitempty"`
+
+	// Munki handoff (the "munki" item type): written to ManagedInstalls
+	// before the initial managedsoftwareupdate run
+	MunkiSoftwareRepoURL  string `json:"munki_software_repo_url,omitempty"`
+	MunkiClientIdentifier string `json:"munki_client_identifier,omitempty"`
+
+	// App Store apps (the "vppapp" item type): the install request is POSTed
+	// to VPPInstallURL, a hook that sends the MDM InstallApplication command.
+	// VPPInstallTimeout bounds the wait for the app to appear.
+	VPPInstallURL     string            `json:"vpp_install_url,omitempty"`
+	VPPInstallHeaders map[string]string `json:"vpp_install_headers,omitempty"`
+	VPPInstallTimeout time.Duration     `json:"vpp_install_timeout"`
+
+	// Webhook mode: MicroMDM/NanoMDM webhook listener that starts standalone runs
+	WebhookListenAddress string   `json:"webhook_listen_address,omitempty"` // e.g. :8443
+	WebhookSecret        string   `json:"webhook_secret,omitempty"`         // bearer token or basic auth password
+	WebhookProfileDomain string   `json:"webhook_profile_domain,omitempty"` // profile domain for triggered runs
+	WebhookTopics        []string `json:"webhook_topics,omitempty"`         // default: mdm.Authenticate
+	WebhookUDIDs         []string `json:"webhook_udids,omitempty"`          // default: this Mac's hardware UUID
+	WebhookTLSCertFile   string   `json:"webhook_tls_cert_file,omitempty"`
+	WebhookTLSKeyFile    string   `json:"webhook_tls_key_file,omitempty"`
+
+	// Jamf Pro integration, run after a successful bootstrap
+	JamfRecon        bool     `json:"jamf_recon"`                   // submit inventory
+	JamfPolicyEvents []string `json:"jamf_policy_events,omitempty"` // custom triggers for `jamf policy -event`
+	JamfBinaryPath   string   `json:"jamf_binary_path,omitempty" profile:",nonempty"`
+	JamfURL          string   `json:"jamf_url,omitempty"`       // with JamfAPIToken: inventory via the API instead of the binary
+	JamfAPIToken     string   `json:"jamf_api_token,omitempty"` // bearer token for JamfURL
+
+	// AuditLogPath is the append-only record of privileged actions (installs,
+	// script runs, file placement, chown, bootouts). Empty disables it.
+	AuditLogPath string `json:"audit_log_path,omitempty"`
+
 	// Bootstrap configuration (can be set from top-level or mode-specific sections)
 	bootstrapConfig interface{} `json:"-"` // Internal field for bootstrap configuration
 
-	DefaultBootstrapPath string `json:"default_bootstrap_path"`
+	// Phase sections of the profile, see ForPhase
+	phaseOverrides map[string]map[string]interface{}
+	phaseBase      map[string]interface{}
+
+	DefaultBootstrapPath string `json:"default_bootstrap_path" profile:"-"`
 
-	DefaultDaemonLogPath     string `json:"default_daemon_log_path"`
-	DefaultAgentLogPath      string `json:"default_agent_log_path"`
-	DefaultStandaloneLogPath string `json:"default_standalone_log_path"`
+	DefaultDaemonLogPath     string `json:"default_daemon_log_path" profile:"-"`
+	DefaultAgentLogPath      string `json:"default_agent_log_path" profile:"-"`
+	DefaultStandaloneLogPath string `json:"default_standalone_log_path" profile:"-"`
 }
 
 // NewConfig creates a new Config with defaults
@@ -86,7 +295,11 @@ func NewConfig() *Config {
 		Debug:                    false,
 		Verbose:                  false,
 		Reboot:                   false,
+		RebootDelay:              5 * time.Second,
+		RebootMethod:             RebootShutdown,
+		RebootCondition:          RebootAlways,
 		MaxRetries:               3,
+		DaemonMaxRetries:         3,
 		RetryDelay:               5,
 		CleanupOnFailure:         true, // Clean up by default
 		CleanupOnSuccess:         true,
@@ -95,9 +308,15 @@ func NewConfig() *Config {
 		TrackBackgroundProcesses: false,           // Backward compatible default
 		BackgroundTimeout:        time.Minute * 5, // 5 minute timeout for background processes
 		DownloadMaxConcurrency:   4,
+		InstallMaxConcurrency:    4,
+		RemediationPath:          "/Library/go-installapplications-remediation",
 		WaitForAgentTimeout:      time.Hour * 24, // Wait up to 24h for agent
 		AgentRequestTimeout:      time.Hour * 2,  // Per-request timeout
-		Mode:                     "standalone",   // Default to standalone for testing
+		UserlandGatePolicy:       UserlandGateAgent,
+		UserlandGateDeadline:     time.Hour,
+		UserlandWaitForDesktop:   false,
+		Mode:                     "standalone", // Default to standalone for testing
+		ProfileDomain:            DefaultProfileDomain,
 
 		// Remote log shipping defaults
 		LogDestination: "",
@@ -116,10 +335,33 @@ func NewConfig() *Config {
 		HashCheckPolicy: "Warning",
 
 		RetainLogFiles: false, // Create a new log file for each run
+		KeepLogs:       false,
+
+		StatusJSON:     false,
+		StatusLogLines: 20,
+
+		PlistOutputDir: ".",
 
 		WithPreflight:    false,
 		NoRestartOnError: false,
 
+		SwiftDialog:            false,
+		SwiftDialogPath:        "/usr/local/bin/dialog",
+		SwiftDialogCommandFile: "/var/tmp/dialog.log",
+
+		MetricsPushFormat: "pushgateway",
+		MetricsJob:        "go-installapplications",
+
+		TracingServiceName: "go-installapplications",
+
+		StatusPlistPath: "/Library/Preferences/com.github.go-installapplications.status.plist",
+
+		VPPInstallTimeout: 30 * time.Minute,
+
+		JamfBinaryPath: "/usr/local/bin/jamf",
+
+		AuditLogPath: "/var/log/go-installapplications/audit.log",
+
 		DefaultBootstrapPath: "/Library/go-installapplications/bootstrap.json",
 
 		DefaultDaemonLogPath:     "/var/log/go-installapplications/go-installapplications.daemon.log",
@@ -128,6 +370,99 @@ func NewConfig() *Config {
 	}
 }
 
+// InstallLimit is how many items of a parallel batch of n install at once.
+func (c *Config) InstallLimit(n int) int {
+	if c.InstallMaxConcurrency <= 0 || c.InstallMaxConcurrency > n {
+		return n
+	}
+	return c.InstallMaxConcurrency
+}
+
+// Paths and identifiers of the original InstallApplications, used by --compat.
+const (
+	CompatInstallPath = "/Library/installapplications"
+	CompatIdentifier  = "com.erikng.installapplications"
+	CompatStateDir    = "/var/tmp/installapplications"
+	CompatSocketDir   = "/var/run/installapplications"
+)
+
+// ApplyCompat switches the defaults that name folders and services to those
+// of the original InstallApplications, so a Mac set up for it keeps its
+// launchd labels, preference domain, folder layout and log files. The profile and
+// flags read afterwards still override them.
+func (c *Config) ApplyCompat() {
+	c.Compat = true
+	c.InstallPath = CompatInstallPath
+	c.DefaultBootstrapPath = CompatInstallPath + "/bootstrap.json"
+	c.LaunchAgentIdentifier = CompatIdentifier
+	c.LaunchDaemonIdentifier = CompatIdentifier
+	c.ProfileDomain = CompatIdentifier
+	c.DefaultDaemonLogPath = "/var/log/installapplications.log"
+	c.DefaultStandaloneLogPath = "/var/log/installapplications.log"
+	c.DefaultAgentLogPath = CompatStateDir + "/installapplications.user.log"
+}
+
+// RemediationIdentifier is the label of the remediation LaunchDaemon.
+func (c *Config) RemediationIdentifier() string {
+	return c.LaunchDaemonIdentifier + ".remediate"
+}
+
+// ItemLogDir is where the full output of each script and installer run is
+// saved; cleanup keeps it when it removes InstallPath.
+func (c *Config) ItemLogDir() string {
+	return c.InstallPath + "/logs"
+}
+
+// CleansUp reports whether scope, one of CleanupScopes, is part of
+// CleanupScope.
+func (c *Config) CleansUp(scope string) bool {
+	scopes := c.CleanupScope
+	if len(scopes) == 0 {
+		scopes = DefaultCleanupScope
+	}
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Preserved reports whether path is, or is inside, one of CleanupPreserve.
+func (c *Config) Preserved(path string) bool {
+	path = filepath.Clean(path)
+	for _, keep := range c.CleanupPreserve {
+		keep = filepath.Clean(keep)
+		if path == keep || strings.HasPrefix(path, keep+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultStateDir holds the daemon's retry state; the agent sockets are in
+// ipc.SocketDir.
+const DefaultStateDir = "/var/tmp/go-installapplications"
+
+// RetryStateFile is where the daemon keeps its attempt count: RetryStatePath
+// when set, otherwise .retry-state in the state folder, suffixed with the
+// profile domain when it is not the default one, so configurations tested
+// side by side on one Mac keep separate counts.
+func (c *Config) RetryStateFile() string {
+	if c.RetryStatePath != "" {
+		return c.RetryStatePath
+	}
+	dir, domain := DefaultStateDir, DefaultProfileDomain
+	if c.Compat {
+		dir, domain = CompatStateDir, CompatIdentifier
+	}
+	name := ".retry-state"
+	if c.ProfileDomain != "" && c.ProfileDomain != domain {
+		name += "." + c.ProfileDomain
+	}
+	return dir + "/" + name
+}
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
 	if c.JSONURL == "" {
@@ -136,6 +471,112 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// ValidateSettings checks the settings against each other: compat against
+// InstallPath, timeouts that cannot be negative or that cut each other short,
+// the placeholders of JSONURL and the values a few settings are limited to.
+// All problems are returned together.
+func (c *Config) ValidateSettings() error {
+	var errs []error
+	if c.Compat && c.InstallPath != CompatInstallPath {
+		errs = append(errs, fmt.Errorf("Compat uses %s, but InstallPath is %s; choose one", CompatInstallPath, c.InstallPath))
+	}
+	for name, d := range map[string]time.Duration{
+		"BackgroundTimeout":    c.BackgroundTimeout,
+		"PhaseTimeout":         c.PhaseTimeout,
+		"RunDeadline":          c.RunDeadline,
+		"RemediationInterval":  c.RemediationInterval,
+		"WaitForAgentTimeout":  c.WaitForAgentTimeout,
+		"AgentRequestTimeout":  c.AgentRequestTimeout,
+		"UserlandGateDeadline": c.UserlandGateDeadline,
+		"VPPInstallTimeout":    c.VPPInstallTimeout,
+		"MetricsLinger":        c.MetricsLinger,
+		"RebootDelay":          c.RebootDelay,
+	} {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("%s cannot be negative: %s", name, d))
+		}
+	}
+	for name, n := range map[string]int{
+		"MaxRetries":            c.MaxRetries,
+		"RetryDelay":            c.RetryDelay,
+		"DaemonMaxRetries":      c.DaemonMaxRetries,
+		"InstallMaxConcurrency": c.InstallMaxConcurrency,
+	} {
+		if n < 0 {
+			errs = append(errs, fmt.Errorf("%s cannot be negative: %d", name, n))
+		}
+	}
+	if c.DownloadMaxConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("DownloadMaxConcurrency must be at least 1: %d", c.DownloadMaxConcurrency))
+	}
+	if c.PhaseTimeout > 0 && c.RunDeadline > 0 && c.PhaseTimeout > c.RunDeadline {
+		errs = append(errs, fmt.Errorf("PhaseTimeout (%s) is longer than RunDeadline (%s), so it never applies", c.PhaseTimeout, c.RunDeadline))
+	}
+	switch c.UserlandGatePolicy {
+	case UserlandGateAgent, UserlandGateLogin:
+	case UserlandGateDeadline:
+		if c.UserlandGateDeadline <= 0 {
+			errs = append(errs, fmt.Errorf("UserlandGatePolicy %q needs a UserlandGateDeadline", c.UserlandGatePolicy))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown UserlandGatePolicy %q (valid: %s, %s, %s)", c.UserlandGatePolicy, UserlandGateAgent, UserlandGateLogin, UserlandGateDeadline))
+	}
+	if HasPlaceholders(c.JSONURL) {
+		if _, err := ExpandURL(c.JSONURL, func(string, string) (string, error) { return "x", nil }); err != nil {
+			errs = append(errs, fmt.Errorf("JSONURL: %w", err))
+		}
+	}
+	switch c.IdentityAuth {
+	case "":
+	case IdentityAuthMTLS, IdentityAuthSignature:
+		if c.IdentityCommonName == "" {
+			errs = append(errs, fmt.Errorf("IdentityAuth %q needs an IdentityCommonName", c.IdentityAuth))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown IdentityAuth %q (valid: %s, %s)", c.IdentityAuth, IdentityAuthMTLS, IdentityAuthSignature))
+	}
+	switch c.RebootMethod {
+	case RebootShutdown:
+	case RebootMDM:
+		if c.JamfURL == "" || c.JamfAPIToken == "" {
+			errs = append(errs, fmt.Errorf("RebootMethod %q needs a JamfURL and JamfAPIToken", c.RebootMethod))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown RebootMethod %q (valid: %s, %s)", c.RebootMethod, RebootShutdown, RebootMDM))
+	}
+	switch c.RebootCondition {
+	case RebootAlways, RebootIfInstalled, RebootIfRequired:
+	default:
+		errs = append(errs, fmt.Errorf("unknown RebootCondition %q (valid: %s, %s, %s)", c.RebootCondition, RebootAlways, RebootIfInstalled, RebootIfRequired))
+	}
+	for _, scope := range c.CleanupScope {
+		if !isCleanupScope(scope) {
+			errs = append(errs, fmt.Errorf("unknown CleanupScope %q (valid: %s)", scope, strings.Join(CleanupScopes, ", ")))
+		}
+	}
+	for _, path := range c.CleanupPreserve {
+		if !filepath.IsAbs(path) {
+			errs = append(errs, fmt.Errorf("CleanupPreserve paths must be absolute: %q", path))
+		}
+	}
+	switch strings.ToLower(c.HashCheckPolicy) {
+	case "", "strict", "warning", "ignore":
+	default:
+		errs = append(errs, fmt.Errorf("unknown HashCheckPolicy %q (valid: Strict, Warning, Ignore)", c.HashCheckPolicy))
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
+func isCleanupScope(scope string) bool {
+	for _, s := range CleanupScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
 // RedactedForLogging returns a redacted, human-friendly snapshot of the
 // effective configuration suitable for debug logs. Sensitive values are masked
 // and durations are rendered as strings.
@@ -159,9 +600,10 @@ func (c *Config) RedactedForLogging() map[string]interface{} {
 
 	snapshot := map[string]interface{}{
 		// Core
-		"Mode":        c.Mode,
-		"JSONURL":     c.JSONURL,
-		"InstallPath": c.InstallPath,
+		"Mode":          c.Mode,
+		"ProfileDomain": c.ProfileDomain,
+		"JSONURL":       c.JSONURL,
+		"InstallPath":   c.InstallPath,
 		// Logging
 		"Debug":          c.Debug,
 		"Verbose":        c.Verbose,
@@ -169,36 +611,117 @@ func (c *Config) RedactedForLogging() map[string]interface{} {
 		"LogProvider":    c.LogProvider,
 		"LogHeaders":     maskMap(c.LogHeaders),
 		"LogFilePath":    c.LogFilePath,
+		"LogLevels":      c.LogLevels,
+		"StrictProfile":  c.StrictProfile,
+		"RetainLogFiles": c.RetainLogFiles,
 		// Execution
-		"Reboot": c.Reboot,
-		"DryRun": c.DryRun,
+		"Reboot":          c.Reboot,
+		"RebootDelay":     c.RebootDelay.String(),
+		"RebootMessage":   c.RebootMessage,
+		"RebootMethod":    c.RebootMethod,
+		"RebootCondition": c.RebootCondition,
+		"DryRun":          c.DryRun,
 		// Retries
-		"MaxRetries": c.MaxRetries,
-		"RetryDelay": c.RetryDelay,
+		"MaxRetries":        c.MaxRetries,
+		"DaemonMaxRetries":  c.DaemonMaxRetries,
+		"NoRestartOnError":  c.NoRestartOnError,
+		"RetryStatePath":    c.RetryStatePath,
+		"DaemonRetryLimits": c.DaemonRetryLimits,
+		"RetryDelay":        c.RetryDelay,
 		// Cleanup
-		"CleanupOnFailure": c.CleanupOnFailure,
-		"CleanupOnSuccess": c.CleanupOnSuccess,
-		"KeepFailedFiles":  c.KeepFailedFiles,
+		"CleanupOnFailure":  c.CleanupOnFailure,
+		"RollbackOnFailure": c.RollbackOnFailure,
+		"CleanupOnSuccess":  c.CleanupOnSuccess,
+		"CleanupScope":      c.CleanupScope,
+		"CleanupPreserve":   c.CleanupPreserve,
+		"KeepFailedFiles":   c.KeepFailedFiles,
+		"KeepLogs":          c.KeepLogs,
+		// Status mode
+		"StatusJSON":     c.StatusJSON,
+		"StatusLogLines": c.StatusLogLines,
+		"PlistOutputDir": c.PlistOutputDir,
+		"OnlyPhase":      c.OnlyPhase,
+		"OnlyItems":      c.OnlyItems,
+		"SimulationFile": c.SimulationFile,
 		// Concurrency & background
 		"TrackBackgroundProcesses": c.TrackBackgroundProcesses,
 		"BackgroundTimeout":        c.BackgroundTimeout.String(),
 		"DownloadMaxConcurrency":   c.DownloadMaxConcurrency,
+		"InstallMaxConcurrency":    c.InstallMaxConcurrency,
+		"PhaseTimeout":             c.PhaseTimeout.String(),
+		"RunDeadline":              c.RunDeadline.String(),
+		"RemediationInterval":      c.RemediationInterval.String(),
+		"RemediationPath":          c.RemediationPath,
 		// IPC timeouts
-		"WaitForAgentTimeout": c.WaitForAgentTimeout.String(),
-		"AgentRequestTimeout": c.AgentRequestTimeout.String(),
+		"WaitForAgentTimeout":    c.WaitForAgentTimeout.String(),
+		"AgentRequestTimeout":    c.AgentRequestTimeout.String(),
+		"UserlandGatePolicy":     c.UserlandGatePolicy,
+		"UserlandGateDeadline":   c.UserlandGateDeadline.String(),
+		"UserlandWaitForDesktop": c.UserlandWaitForDesktop,
 		// HTTP auth & headers (redacted)
 		"HTTPAuthUser":        c.HTTPAuthUser,
 		"HTTPAuthPassword":    mask(c.HTTPAuthPassword),
 		"HTTPHeaders":         maskMap(c.HTTPHeaders),
 		"HeaderAuthorization": mask(c.HeaderAuthorization),
+		"IdentityAuth":        c.IdentityAuth,
+		"IdentityCommonName":  c.IdentityCommonName,
 		// Compatibility
-		"FollowRedirects":        c.FollowRedirects,
-		"SkipValidation":         c.SkipValidation,
-		"LaunchAgentIdentifier":  c.LaunchAgentIdentifier,
-		"LaunchDaemonIdentifier": c.LaunchDaemonIdentifier,
-		"HashCheckPolicy":        c.HashCheckPolicy,
+		"Compat":                  c.Compat,
+		"FollowRedirects":         c.FollowRedirects,
+		"SkipValidation":          c.SkipValidation,
+		"LaunchAgentIdentifier":   c.LaunchAgentIdentifier,
+		"LaunchDaemonIdentifier":  c.LaunchDaemonIdentifier,
+		"HashCheckPolicy":         c.HashCheckPolicy,
+		"VerifyPackageSignatures": c.VerifyPackageSignatures,
+		"AllowedTeamIDs":          c.AllowedTeamIDs,
 		// Bootstrap
 		"withPreflight": c.WithPreflight,
+		// Progress UI
+		"SwiftDialog":            c.SwiftDialog,
+		"SwiftDialogPath":        c.SwiftDialogPath,
+		"SwiftDialogCommandFile": c.SwiftDialogCommandFile,
+		"SwiftDialogTitle":       c.SwiftDialogTitle,
+		"SwiftDialogMessage":     c.SwiftDialogMessage,
+		"SwiftDialogIcon":        c.SwiftDialogIcon,
+		"SwiftDialogArgs":        c.SwiftDialogArgs,
+		// Metrics
+		"MetricsListenAddress": c.MetricsListenAddress,
+		"MetricsLinger":        c.MetricsLinger.String(),
+		"MetricsPushURL":       c.MetricsPushURL,
+		"MetricsPushFormat":    c.MetricsPushFormat,
+		"MetricsJob":           c.MetricsJob,
+		// Tracing
+		"TracingEndpoint":    c.TracingEndpoint,
+		"TracingServiceName": c.TracingServiceName,
+		// Status reporting
+		"StatusURL":       c.StatusURL,
+		"StatusHeaders":   maskMap(c.StatusHeaders),
+		"StatusPlistPath": c.StatusPlistPath,
+		// Placed files
+		"FileBackupDir": c.FileBackupDir,
+		// Munki
+		"MunkiSoftwareRepoURL":  c.MunkiSoftwareRepoURL,
+		"MunkiClientIdentifier": c.MunkiClientIdentifier,
+		// App Store apps
+		"VPPInstallURL":     c.VPPInstallURL,
+		"VPPInstallHeaders": maskMap(c.VPPInstallHeaders),
+		"VPPInstallTimeout": c.VPPInstallTimeout.String(),
+		// Webhook
+		"WebhookListenAddress": c.WebhookListenAddress,
+		"WebhookSecret":        mask(c.WebhookSecret),
+		"WebhookProfileDomain": c.WebhookProfileDomain,
+		"WebhookTopics":        c.WebhookTopics,
+		"WebhookUDIDs":         c.WebhookUDIDs,
+		"WebhookTLSCertFile":   c.WebhookTLSCertFile,
+		"WebhookTLSKeyFile":    c.WebhookTLSKeyFile,
+		// Jamf
+		"JamfRecon":        c.JamfRecon,
+		"JamfPolicyEvents": c.JamfPolicyEvents,
+		"JamfBinaryPath":   c.JamfBinaryPath,
+		"JamfURL":          c.JamfURL,
+		"JamfAPIToken":     mask(c.JamfAPIToken),
+		// Audit
+		"AuditLogPath": c.AuditLogPath,
 	}
 
 	return snapshot
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the name of every environment variable override.
+const EnvPrefix = "GIA_"
+
+// envExcluded are set before the profile is read, so they only come from
+// flags.
+var envExcluded = map[string]bool{"Mode": true, "ProfileDomain": true, "Compat": true}
+
+// envName is the environment variable that overrides a Config field: the
+// field's JSON name in upper case after EnvPrefix, e.g. GIA_MAX_RETRIES for
+// MaxRetries. It is "" for fields that cannot be overridden.
+func envName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if !field.IsExported() || name == "" || name == "-" || envExcluded[field.Name] {
+		return ""
+	}
+	return EnvPrefix + strings.ToUpper(name)
+}
+
+// ApplyEnvironment applies the GIA_* variables in environ (as returned by
+// os.Environ) on top of the profile; command line flags still override them.
+// Values are parsed as the field's type: booleans as strconv.ParseBool,
+// durations as seconds or a Go duration ("90s", "2h"), lists as
+// comma-separated values and maps as comma-separated key=value pairs.
+// GIA_* variables that name no field are returned, so typos can be reported.
+func (c *Config) ApplyEnvironment(environ []string) (unknown []string, err error) {
+	fields := map[string]reflect.Value{}
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if name := envName(v.Type().Field(i)); name != "" {
+			fields[name] = v.Field(i)
+		}
+	}
+
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		field, ok := fields[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if err := setFromEnv(field, value); err != nil {
+			return unknown, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// setFromEnv parses value into field.
+func setFromEnv(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(i))
+	case time.Duration:
+		if seconds, err := strconv.Atoi(value); err == nil {
+			field.SetInt(int64(time.Duration(seconds) * time.Second))
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("want seconds or a duration such as 90s: %q", value)
+		}
+		field.SetInt(int64(d))
+	case []string:
+		var list []string
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	case map[string]string:
+		m := map[string]string{}
+		err := eachPair(value, func(k, v string) error {
+			m[k] = v
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(m))
+	case map[string]int:
+		m := map[string]int{}
+		err := eachPair(value, func(k, v string) error {
+			i, err := strconv.Atoi(v)
+			m[k] = i
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// eachPair calls fn with each key=value pair of a comma-separated list.
+func eachPair(value string, fn func(k, v string) error) error {
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return fmt.Errorf("want key=value: %q", pair)
+		}
+		if err := fn(strings.TrimSpace(k), strings.TrimSpace(v)); err != nil {
+			return fmt.Errorf("%q: %w", pair, err)
+		}
+	}
+	return nil
+}
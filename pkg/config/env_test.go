@@ -0,0 +1,89 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEnvironment(t *testing.T) {
+	cfg := NewConfig()
+	unknown, err := cfg.ApplyEnvironment([]string{
+		"PATH=/usr/bin:/bin",
+		"GIA_JSONURL=https://server.example/bootstrap.json",
+		"GIA_MAX_RETRIES=7",
+		"GIA_DRY_RUN=true",
+		"GIA_CLEANUP_ON_SUCCESS=0",
+		"GIA_BACKGROUND_TIMEOUT=120",
+		"GIA_PHASE_TIMEOUT=30m",
+		"GIA_ALLOWED_TEAM_IDS=ABCDE12345, FGHIJ67890",
+		"GIA_DAEMON_RETRY_LIMITS=network=0,validation=1",
+		"GIA_HTTP_HEADERS=X-Token=abc=def",
+		"GIA_MODE=daemon",
+		"GIA_MAX_RETRYS=2",
+	})
+	if err != nil {
+		t.Fatalf("ApplyEnvironment: %v", err)
+	}
+	if want := []string{"GIA_MAX_RETRYS", "GIA_MODE"}; !reflect.DeepEqual(unknown, want) {
+		t.Errorf("unknown = %v, want %v", unknown, want)
+	}
+	if cfg.JSONURL != "https://server.example/bootstrap.json" || cfg.MaxRetries != 7 || !cfg.DryRun || cfg.CleanupOnSuccess {
+		t.Errorf("scalars not applied: %+v", cfg)
+	}
+	if cfg.BackgroundTimeout != 2*time.Minute || cfg.PhaseTimeout != 30*time.Minute {
+		t.Errorf("durations = %v, %v", cfg.BackgroundTimeout, cfg.PhaseTimeout)
+	}
+	if !reflect.DeepEqual(cfg.AllowedTeamIDs, []string{"ABCDE12345", "FGHIJ67890"}) {
+		t.Errorf("AllowedTeamIDs = %v", cfg.AllowedTeamIDs)
+	}
+	if !reflect.DeepEqual(cfg.DaemonRetryLimits, map[string]int{"network": 0, "validation": 1}) {
+		t.Errorf("DaemonRetryLimits = %v", cfg.DaemonRetryLimits)
+	}
+	if !reflect.DeepEqual(cfg.HTTPHeaders, map[string]string{"X-Token": "abc=def"}) {
+		t.Errorf("HTTPHeaders = %v", cfg.HTTPHeaders)
+	}
+	if cfg.Mode != "standalone" {
+		t.Errorf("Mode = %q, it only comes from flags", cfg.Mode)
+	}
+}
+
+func TestApplyEnvironment_InvalidValues(t *testing.T) {
+	for _, kv := range []string{
+		"GIA_DEBUG=maybe",
+		"GIA_MAX_RETRIES=three",
+		"GIA_RUN_DEADLINE=soon",
+		"GIA_DAEMON_RETRY_LIMITS=network",
+		"GIA_DAEMON_RETRY_LIMITS=network=none",
+	} {
+		if _, err := NewConfig().ApplyEnvironment([]string{kv}); err == nil || !strings.Contains(err.Error(), strings.SplitN(kv, "=", 2)[0]) {
+			t.Errorf("%s: err = %v", kv, err)
+		}
+	}
+}
+
+// Every field that has a variable must be of a type ApplyEnvironment can
+// parse, so new Config fields are covered without further changes.
+func TestApplyEnvironment_AllFields(t *testing.T) {
+	samples := map[reflect.Type]string{
+		reflect.TypeOf(""):                  "x",
+		reflect.TypeOf(false):               "true",
+		reflect.TypeOf(0):                   "1",
+		reflect.TypeOf(time.Duration(0)):    "1",
+		reflect.TypeOf([]string{}):          "a,b",
+		reflect.TypeOf(map[string]string{}): "a=b",
+		reflect.TypeOf(map[string]int{}):    "a=1",
+	}
+	typ := reflect.TypeOf(Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := envName(field)
+		if name == "" {
+			continue
+		}
+		if _, err := NewConfig().ApplyEnvironment([]string{name + "=" + samples[field.Type]}); err != nil {
+			t.Errorf("%s (%s): %v", name, field.Name, err)
+		}
+	}
+}
@@ -47,7 +47,13 @@ func TestItemUnmarshal_AllFields(t *testing.T) {
 		"skip_if":"intel",
 		"retries":7,
 		"retrywait":11,
-		"fail_policy":"failable"
+		"fail_policy":"failable",
+		"choices_xml":"/Library/installapplications/demo-choices.xml",
+		"allow_untrusted":true,
+		"target":"/Volumes/Data",
+		"run_as":"_svc",
+		"strip_quarantine":true,
+		"xattrs":{"com.example.managed":"1"}
 	}`
 	var it Item
 	if err := json.Unmarshal([]byte(body), &it); err != nil {
@@ -55,7 +61,9 @@ func TestItemUnmarshal_AllFields(t *testing.T) {
 	}
 	if it.Name != "Demo" || it.Type != "package" || it.URL == "" || it.Hash != "abc123" ||
 		it.PackageID != "com.example.demo" || it.Version != "1.2.3" || !it.DoNotWait ||
-		it.SkipIf != "intel" || it.Retries != 7 || it.RetryWait != 11 || it.FailPolicy != "failable" {
+		it.SkipIf != "intel" || it.Retries != 7 || it.RetryWait != 11 || it.FailPolicy != "failable" ||
+		it.ChoicesXML != "/Library/installapplications/demo-choices.xml" || !it.AllowUntrusted || it.Target != "/Volumes/Data" ||
+		it.RunAs != "_svc" || !it.StripQuarantine || it.XAttrs["com.example.managed"] != "1" {
 		t.Fatalf("unexpected struct: %+v", it)
 	}
 }
@@ -129,3 +137,14 @@ func TestValidateBootstrap_FailPolicyAccepted(t *testing.T) {
 		t.Fatalf("expected error for invalid fail_policy")
 	}
 }
+
+func TestValidateBootstrap_MunkiUserlandOnly(t *testing.T) {
+	b := &Bootstrap{Userland: []Item{{Name: "munki", Type: "munki"}}}
+	if err := ValidateBootstrap(b); err != nil {
+		t.Fatalf("munki should be valid in userland: %v", err)
+	}
+	b = &Bootstrap{SetupAssistant: []Item{{Name: "munki", Type: "munki"}}}
+	if err := ValidateBootstrap(b); err == nil {
+		t.Fatalf("expected error for munki in setupassistant")
+	}
+}
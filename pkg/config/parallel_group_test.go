@@ -86,3 +86,31 @@ func TestBatchByParallelGroup_Empty(t *testing.T) {
 		t.Fatalf("empty input -> nil; got %v", batches)
 	}
 }
+
+func TestBatchByParallelGroup_ParallelSafe(t *testing.T) {
+	items := []Item{
+		{Name: "s1", ParallelSafe: true},
+		{Name: "s2", ParallelSafe: true},
+		{Name: "g1", ParallelGroup: "a"},
+		{Name: "s3", ParallelSafe: true},
+		{Name: "plain"},
+		{Name: "g2", ParallelGroup: "a", ParallelSafe: true},
+	}
+	var got [][]string
+	var names []string
+	for _, batch := range BatchByParallelGroup(items) {
+		var ids []string
+		for _, it := range batch {
+			ids = append(ids, it.Name)
+		}
+		got = append(got, ids)
+		names = append(names, BatchName(batch))
+	}
+	want := [][]string{{"s1", "s2"}, {"g1"}, {"s3"}, {"plain"}, {"g2"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("batches got %v want %v", got, want)
+	}
+	if wantNames := []string{"parallel_safe", "a", "parallel_safe", "", "a"}; !reflect.DeepEqual(names, wantNames) {
+		t.Fatalf("names got %q want %q", names, wantNames)
+	}
+}
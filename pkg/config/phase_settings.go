@@ -0,0 +1,88 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+)
+
+// PhaseSettings are the settings a phase section of the profile can
+// override for that phase alone, e.g. userland.DownloadMaxConcurrency:
+// network and timing differ between Setup Assistant and the user's session.
+var PhaseSettings = []string{
+	"MaxRetries", "RetryDelay",
+	"BackgroundTimeout", "PhaseTimeout", "WaitForAgentTimeout",
+	"DownloadMaxConcurrency", "InstallMaxConcurrency",
+}
+
+// profilePhases name the phase sections of a settings dictionary.
+var profilePhases = map[string]bool{"preflight": true, "setupassistant": true, "userland": true}
+
+// applyPhaseSection merges the phase section settings into the overrides of
+// phase; a mode's section is applied after the shared one and wins.
+func (c *Config) applyPhaseSection(phase string, settings map[string]interface{}) {
+	if c.phaseOverrides == nil {
+		c.phaseOverrides = map[string]map[string]interface{}{}
+	}
+	if c.phaseOverrides[phase] == nil {
+		c.phaseOverrides[phase] = map[string]interface{}{}
+	}
+	for key, val := range settings {
+		c.phaseOverrides[phase][key] = val
+	}
+}
+
+// unknownPhaseKeys returns the keys of a phase section that are not one of
+// PhaseSettings, sorted.
+func unknownPhaseKeys(settings map[string]interface{}) []string {
+	var unknown []string
+	for key := range settings {
+		if !isPhaseSetting(key) {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func isPhaseSetting(key string) bool {
+	for _, name := range PhaseSettings {
+		if name == key {
+			return true
+		}
+	}
+	return false
+}
+
+// recordPhaseBase keeps the values the profile set for the whole run, so
+// ForPhase can tell the settings environment variables and flags changed.
+func (c *Config) recordPhaseBase() {
+	c.phaseBase = map[string]interface{}{}
+	v := reflect.ValueOf(c).Elem()
+	for _, name := range PhaseSettings {
+		c.phaseBase[name] = v.FieldByName(name).Interface()
+	}
+}
+
+// ForPhase returns the config phase runs with: c with the profile's
+// overrides for phase applied, or c itself when there are none. A setting
+// an environment variable or flag changed keeps that value.
+func (c *Config) ForPhase(phase string) *Config {
+	overrides := c.phaseOverrides[phase]
+	if len(overrides) == 0 {
+		return c
+	}
+	phaseCfg := *c
+	v := reflect.ValueOf(&phaseCfg).Elem()
+	for _, name := range PhaseSettings {
+		val, ok := overrides[name]
+		if !ok {
+			continue
+		}
+		field := v.FieldByName(name)
+		if base, ok := c.phaseBase[name]; ok && !reflect.DeepEqual(field.Interface(), base) {
+			continue
+		}
+		setProfileValue(field, val, false)
+	}
+	return &phaseCfg
+}
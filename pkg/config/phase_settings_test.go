@@ -0,0 +1,65 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestForPhase(t *testing.T) {
+	cfg := NewConfig()
+	shared := map[string]interface{}{
+		"DownloadMaxConcurrency": int64(8),
+		"userland":               map[string]interface{}{"DownloadMaxConcurrency": int64(2), "RetryDelay": int64(30)},
+		"setupassistant":         map[string]interface{}{"BackgroundTimeout": "10m"},
+	}
+	daemon := map[string]interface{}{
+		"userland": map[string]interface{}{"RetryDelay": int64(60)},
+	}
+	for _, settings := range []map[string]interface{}{shared, daemon} {
+		if err := cfg.applySettingsMap(settings); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+	}
+	cfg.recordPhaseBase()
+	cfg.MaxRetries = 9 // as if set by a flag
+
+	userland := cfg.ForPhase("userland")
+	if userland.DownloadMaxConcurrency != 2 || userland.RetryDelay != 60 || userland.MaxRetries != 9 {
+		t.Errorf("userland: concurrency=%d delay=%d retries=%d", userland.DownloadMaxConcurrency, userland.RetryDelay, userland.MaxRetries)
+	}
+	if sa := cfg.ForPhase("setupassistant"); sa.BackgroundTimeout != 10*time.Minute || sa.DownloadMaxConcurrency != 8 {
+		t.Errorf("setupassistant: background=%v concurrency=%d", sa.BackgroundTimeout, sa.DownloadMaxConcurrency)
+	}
+	if cfg.ForPhase("preflight") != cfg {
+		t.Errorf("a phase without overrides should use the run's config")
+	}
+	if cfg.DownloadMaxConcurrency != 8 || cfg.RetryDelay != 5 {
+		t.Errorf("overrides leaked into the run's config: %+v", cfg)
+	}
+}
+
+func TestForPhase_FlagWins(t *testing.T) {
+	cfg := NewConfig()
+	settings := map[string]interface{}{
+		"userland": map[string]interface{}{"DownloadMaxConcurrency": int64(2)},
+	}
+	if err := cfg.applySettingsMap(settings); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	cfg.recordPhaseBase()
+	cfg.DownloadMaxConcurrency = 16 // as if set by --download-max-concurrency
+	if got := cfg.ForPhase("userland").DownloadMaxConcurrency; got != 16 {
+		t.Errorf("DownloadMaxConcurrency = %d, want the flag's 16", got)
+	}
+}
+
+func TestUnknownPhaseKeys(t *testing.T) {
+	got := unknownPhaseKeys(map[string]interface{}{"RetryDelay": 1, "JSONURL": "x", "MaxRetrys": 2})
+	if len(got) != 2 || got[0] != "JSONURL" || got[1] != "MaxRetrys" {
+		t.Errorf("unknownPhaseKeys = %v", got)
+	}
+	r := &ProfileResult{UnknownKeys: []string{"shared.userland.MaxRetrys"}}
+	if p := r.UnknownKeyProblems(); len(p) != 1 || p[0] != "profile key shared.userland.MaxRetrys is not a known setting (did you mean MaxRetries?)" {
+		t.Errorf("UnknownKeyProblems = %q", p)
+	}
+}
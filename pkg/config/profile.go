@@ -3,13 +3,10 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
-	"strconv"
-	"time"
-
-	"howett.net/plist"
+	"reflect"
+	"strings"
 )
 
 const DefaultProfileDomain = "com.github.go-installapplications"
@@ -18,6 +15,26 @@ const DefaultProfileDomain = "com.github.go-installapplications"
 type ProfileResult struct {
 	ConfigFound     bool
 	BootstrapSource string // "json_url", "embedded", or "none"
+	// UnknownKeys are the keys of the shared and mode sections, and of their
+	// phase sections, that name no setting, as "section.Key" or
+	// "section.phase.Key"; they are ignored
+	UnknownKeys []string
+}
+
+// UnknownKeyProblems describes each of UnknownKeys, with the setting it most
+// likely means: "profile key shared.MaxRetrys is not a known setting (did
+// you mean MaxRetries?)".
+func (r *ProfileResult) UnknownKeyProblems() []string {
+	var problems []string
+	for _, key := range r.UnknownKeys {
+		problem := "profile key " + key + " is not a known setting"
+		name := key[strings.LastIndex(key, ".")+1:]
+		if suggestion := suggestProfileKey(name); suggestion != "" {
+			problem += " (did you mean " + suggestion + "?)"
+		}
+		problems = append(problems, problem)
+	}
+	return problems
 }
 
 // ReadFromProfile reads configuration from nested mobile config structure
@@ -25,13 +42,12 @@ func (c *Config) ReadFromProfile(domain string) (*ProfileResult, error) {
 	if domain == "" {
 		domain = DefaultProfileDomain
 	}
+	c.ProfileDomain = domain
 
-	// Try multiple locations where preferences might be stored
-	prefs := c.readManagedPrefs(domain)
-	if prefs == nil {
-		prefs = c.readUserPrefs(domain)
+	prefs, err := c.readPrefs(domain)
+	if err != nil {
+		return nil, err
 	}
-
 	if prefs == nil {
 		return &ProfileResult{ConfigFound: false, BootstrapSource: "none"}, nil
 	}
@@ -48,6 +64,25 @@ func (c *Config) ReadFromProfile(domain string) (*ProfileResult, error) {
 		return nil, fmt.Errorf("failed to apply mode settings: %w", err)
 	}
 
+	for _, section := range []string{"shared", c.Mode} {
+		if section == "agent" {
+			continue // not read, see applyModeSettings
+		}
+		if settings, ok := prefs[section].(map[string]interface{}); ok {
+			for _, key := range unknownProfileKeys(settings) {
+				result.UnknownKeys = append(result.UnknownKeys, section+"."+key)
+			}
+			for _, phase := range []string{"preflight", "setupassistant", "userland"} {
+				if phaseSettings, ok := settings[phase].(map[string]interface{}); ok {
+					for _, key := range unknownPhaseKeys(phaseSettings) {
+						result.UnknownKeys = append(result.UnknownKeys, section+"."+phase+"."+key)
+					}
+				}
+			}
+		}
+	}
+	c.recordPhaseBase()
+
 	// Step 3: Determine bootstrap source and validate
 	bootstrapSource, err := c.determineBootstrapSource(prefs)
 	if err != nil {
@@ -58,38 +93,38 @@ func (c *Config) ReadFromProfile(domain string) (*ProfileResult, error) {
 	return result, nil
 }
 
-// readManagedPrefs reads from managed preferences (mobile config)
-func (c *Config) readManagedPrefs(domain string) map[string]interface{} {
-	managedPath := fmt.Sprintf("/Library/Managed Preferences/%s.plist", domain)
-	return c.readPlistFile(managedPath)
-}
-
-// readUserPrefs reads from user preferences (manual defaults write)
-func (c *Config) readUserPrefs(domain string) map[string]interface{} {
-	homeDir, err := os.UserHomeDir()
+// CheckProfile reads the profile for domain as ReadFromProfile does for mode,
+// but reports problems instead of falling back to defaults. It returns
+// whether any of the profile's keys are set, and an error if the
+// preferences cannot be read (ErrPreferencesUnreadable), its settings cannot
+// be applied or, with StrictProfile, it has keys that name no setting.
+func CheckProfile(domain, mode string) (bool, error) {
+	c := NewConfig()
+	c.Mode = mode
+	result, err := c.ReadFromProfile(domain)
 	if err != nil {
-		return nil
+		return false, err
 	}
-
-	userPath := filepath.Join(homeDir, "Library", "Preferences", domain+".plist")
-	return c.readPlistFile(userPath)
-}
-
-// readPlistFile reads a plist file and returns its contents
-func (c *Config) readPlistFile(path string) map[string]interface{} {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil // File doesn't exist or can't be read
+	if c.StrictProfile && len(result.UnknownKeys) > 0 {
+		return true, errors.New(strings.Join(result.UnknownKeyProblems(), "; "))
 	}
-	defer file.Close()
+	return result.ConfigFound, nil
+}
 
-	var prefs map[string]interface{}
-	decoder := plist.NewDecoder(file)
-	if err := decoder.Decode(&prefs); err != nil {
-		return nil // Can't parse plist
+// readPrefs reads the profile for domain through copyAppValues, so forced
+// managed values, by-host preferences and cached writes count as they do
+// for any other macOS preference. It returns nil when none of the profile's
+// keys are set.
+func (c *Config) readPrefs(domain string) (map[string]interface{}, error) {
+	keys := []string{"shared", "bootstrap"}
+	if c.Mode != "" {
+		keys = append(keys, c.Mode)
+	}
+	prefs, err := copyAppValues(domain, keys)
+	if err != nil || len(prefs) == 0 {
+		return nil, err
 	}
-
-	return prefs
+	return prefs, nil
 }
 
 // applySharedSettings applies shared configuration settings
@@ -167,291 +202,41 @@ func (c *Config) determineBootstrapSource(prefs map[string]interface{}) (string,
 	}
 }
 
-// applySettingsMap applies a settings map to the config
+// applySettingsMap applies a settings map to the config: each key that
+// names a Config field (see profileFields), then the phase sections (see
+// ForPhase) and the bootstrap section of a mode. HeaderAuthorization
+// replaces the Authorization header of HTTPHeaders, whichever order they
+// are listed in.
 func (c *Config) applySettingsMap(settings map[string]interface{}) error {
-	if val, exists := settings["JSONURL"]; exists {
-		if str, ok := val.(string); ok {
-			if str == "" {
-				return fmt.Errorf("JSONURL cannot be empty string - omit the key instead")
-			}
-			c.JSONURL = str
-		}
-	}
-
-	if val, exists := settings["InstallPath"]; exists {
-		if str, ok := val.(string); ok && str != "" {
-			c.InstallPath = str
-		}
+	if str, ok := settings["JSONURL"].(string); ok && str == "" {
+		return fmt.Errorf("JSONURL cannot be empty string - omit the key instead")
 	}
 
-	if val, exists := settings["Debug"]; exists {
-		if b, ok := val.(bool); ok {
-			c.Debug = b
+	v := reflect.ValueOf(c).Elem()
+	for _, f := range profileFields() {
+		if val, exists := settings[f.key]; exists {
+			setProfileValue(v.Field(f.index), val, f.nonEmpty)
 		}
 	}
-
-	if val, exists := settings["Verbose"]; exists {
-		if b, ok := val.(bool); ok {
-			c.Verbose = b
-		}
-	}
-
-	if val, exists := settings["Reboot"]; exists {
-		switch v := val.(type) {
-		case bool:
-			c.Reboot = v
-		case string:
-			if parsed, err := strconv.ParseBool(v); err == nil {
-				c.Reboot = parsed
-			}
-		}
-	}
-
-	if val, exists := settings["MaxRetries"]; exists {
-		if i, ok := val.(int64); ok {
-			c.MaxRetries = int(i)
-		} else if i, ok := val.(int); ok {
-			c.MaxRetries = i
-		}
-	}
-
-	if val, exists := settings["RetryDelay"]; exists {
-		if i, ok := val.(int64); ok {
-			c.RetryDelay = int(i)
-		} else if i, ok := val.(int); ok {
-			c.RetryDelay = i
-		}
-	}
-
-	if val, exists := settings["CleanupOnFailure"]; exists {
-		if b, ok := val.(bool); ok {
-			c.CleanupOnFailure = b
-		}
-	}
-
-	if val, exists := settings["CleanupOnSuccess"]; exists {
-		if b, ok := val.(bool); ok {
-			c.CleanupOnSuccess = b
-		}
-	}
-
-	if val, exists := settings["KeepFailedFiles"]; exists {
-		if b, ok := val.(bool); ok {
-			c.KeepFailedFiles = b
-		}
-	}
-
-	if val, exists := settings["LogFilePath"]; exists {
-		if str, ok := val.(string); ok && str != "" {
-			c.LogFilePath = str
-		}
-	}
-
-	if val, exists := settings["RetainLogFiles"]; exists {
-		if b, ok := val.(bool); ok {
-			c.RetainLogFiles = b
-		}
-	}
-
-	if val, exists := settings["WithPreflight"]; exists {
-		if b, ok := val.(bool); ok {
-			c.WithPreflight = b
-		}
-	}
-
-	if val, exists := settings["NoRestartOnError"]; exists {
-		if b, ok := val.(bool); ok {
-			c.NoRestartOnError = b
-		}
-	}
-
-	if val, exists := settings["HashCheckPolicy"]; exists {
-		if str, ok := val.(string); ok && str != "" {
-			c.HashCheckPolicy = str
+	for phase := range profilePhases {
+		if phaseSettings, ok := settings[phase].(map[string]interface{}); ok {
+			c.applyPhaseSection(phase, phaseSettings)
 		}
 	}
-
-	if val, exists := settings["DryRun"]; exists {
-		if b, ok := val.(bool); ok {
-			c.DryRun = b
-		}
-	}
-
-	if val, exists := settings["TrackBackgroundProcesses"]; exists {
-		if b, ok := val.(bool); ok {
-			c.TrackBackgroundProcesses = b
-		}
-	}
-
-	if val, exists := settings["BackgroundTimeout"]; exists {
-		if i, ok := val.(int64); ok {
-			c.BackgroundTimeout = time.Duration(i) * time.Second
-		} else if i, ok := val.(int); ok {
-			c.BackgroundTimeout = time.Duration(i) * time.Second
-		} else if str, ok := val.(string); ok {
-			if duration, err := time.ParseDuration(str); err == nil {
-				c.BackgroundTimeout = duration
-			} else if seconds, err := strconv.Atoi(str); err == nil {
-				c.BackgroundTimeout = time.Duration(seconds) * time.Second
-			}
-		}
-	}
-
-	// Download concurrency
-	if val, exists := settings["DownloadMaxConcurrency"]; exists {
-		if i, ok := val.(int64); ok {
-			c.DownloadMaxConcurrency = int(i)
-		} else if i, ok := val.(int); ok {
-			c.DownloadMaxConcurrency = i
-		} else if str, ok := val.(string); ok {
-			if iv, err := strconv.Atoi(str); err == nil {
-				c.DownloadMaxConcurrency = iv
-			}
-		}
-	}
-
-	// IPC/coordination timeouts (accept seconds as int or duration string)
-	if val, exists := settings["WaitForAgentTimeout"]; exists {
-		if i, ok := val.(int64); ok {
-			c.WaitForAgentTimeout = time.Duration(i) * time.Second
-		} else if i, ok := val.(int); ok {
-			c.WaitForAgentTimeout = time.Duration(i) * time.Second
-		} else if str, ok := val.(string); ok {
-			if d, err := time.ParseDuration(str); err == nil {
-				c.WaitForAgentTimeout = d
-			} else if seconds, err := strconv.Atoi(str); err == nil {
-				c.WaitForAgentTimeout = time.Duration(seconds) * time.Second
-			}
-		}
-	}
-	if val, exists := settings["AgentRequestTimeout"]; exists {
-		if i, ok := val.(int64); ok {
-			c.AgentRequestTimeout = time.Duration(i) * time.Second
-		} else if i, ok := val.(int); ok {
-			c.AgentRequestTimeout = time.Duration(i) * time.Second
-		} else if str, ok := val.(string); ok {
-			if d, err := time.ParseDuration(str); err == nil {
-				c.AgentRequestTimeout = d
-			} else if seconds, err := strconv.Atoi(str); err == nil {
-				c.AgentRequestTimeout = time.Duration(seconds) * time.Second
-			}
-		}
-	}
-
-	// HTTP Authentication settings
-	if val, exists := settings["HTTPAuthUser"]; exists {
-		if str, ok := val.(string); ok && str != "" {
-			c.HTTPAuthUser = str
-		}
-	}
-
-	// Backwards-compatibility options
-	if val, exists := settings["FollowRedirects"]; exists {
-		if b, ok := val.(bool); ok {
-			c.FollowRedirects = b
-		}
-	}
-	if val, exists := settings["SkipValidation"]; exists {
-		if b, ok := val.(bool); ok {
-			c.SkipValidation = b
-		}
-	}
-
-	if val, exists := settings["LaunchAgentIdentifier"]; exists {
-		if str, ok := val.(string); ok && str != "" {
-			c.LaunchAgentIdentifier = str
-		}
-	}
-	if val, exists := settings["LaunchDaemonIdentifier"]; exists {
-		if str, ok := val.(string); ok && str != "" {
-			c.LaunchDaemonIdentifier = str
-		}
-	}
-
-	if val, exists := settings["HTTPAuthPassword"]; exists {
-		if str, ok := val.(string); ok && str != "" {
-			c.HTTPAuthPassword = str
-		}
-	}
-
-	// HTTP Headers (for advanced authentication or custom headers)
-	if val, exists := settings["HTTPHeaders"]; exists {
+	if str, ok := settings["HeaderAuthorization"].(string); ok && str != "" {
 		if c.HTTPHeaders == nil {
-			c.HTTPHeaders = make(map[string]string)
-		}
-
-		// Handle both dictionary format and array format
-		if headersMap, ok := val.(map[string]interface{}); ok {
-			// Dictionary format: {"Authorization": "Basic xyz", "X-API-Key": "abc"}
-			for key, value := range headersMap {
-				if strValue, ok := value.(string); ok {
-					c.HTTPHeaders[key] = strValue
-				}
-			}
-		} else if headersArray, ok := val.([]interface{}); ok {
-			// Array format: [{"name": "Authorization", "value": "Basic xyz"}]
-			for _, item := range headersArray {
-				if headerDict, ok := item.(map[string]interface{}); ok {
-					if name, nameOk := headerDict["name"].(string); nameOk {
-						if value, valueOk := headerDict["value"].(string); valueOk {
-							c.HTTPHeaders[name] = value
-						}
-					}
-				}
-			}
-		}
-	}
-
-	// Single Authorization header (--headers)
-	if val, exists := settings["HeaderAuthorization"]; exists {
-		if str, ok := val.(string); ok && str != "" {
-			c.HeaderAuthorization = str
-			if c.HTTPHeaders == nil {
-				c.HTTPHeaders = map[string]string{}
-			}
-			c.HTTPHeaders["Authorization"] = str
+			c.HTTPHeaders = map[string]string{}
 		}
+		c.HTTPHeaders["Authorization"] = str
 	}
 
-	// Remote log shipping: LogDestination, LogProvider, LogHeaders NOT YET IMPLEMENTED
-	// if val, exists := settings["LogDestination"]; exists {
-	// 	if str, ok := val.(string); ok && str != "" {
-	// 		c.LogDestination = str
-	// 	}
-	// }
-	// if val, exists := settings["LogProvider"]; exists {
-	// 	if str, ok := val.(string); ok && str != "" {
-	// 		c.LogProvider = str
-	// 	}
-	// }
-	// if val, exists := settings["LogHeaders"]; exists {
-	// 	if c.LogHeaders == nil {
-	// 		c.LogHeaders = make(map[string]string)
-	// 	}
-	// 	if headersMap, ok := val.(map[string]interface{}); ok {
-	// 		for key, value := range headersMap {
-	// 			if strValue, ok := value.(string); ok {
-	// 				c.LogHeaders[key] = strValue
-	// 			}
-	// 		}
-	// 	} else if headersArray, ok := val.([]interface{}); ok {
-	// 		for _, item := range headersArray {
-	// 			if headerDict, ok := item.(map[string]interface{}); ok {
-	// 				if name, nameOk := headerDict["name"].(string); nameOk {
-	// 					if value, valueOk := headerDict["value"].(string); valueOk {
-	// 						c.LogHeaders[name] = value
-	// 					}
-	// 				}
-	// 			}
-	// 		}
-	// 	}
-	// }
-
 	// Handle bootstrap section in settings
 	if val, exists := settings["bootstrap"]; exists {
 		c.bootstrapConfig = val
 	}
 
+	// Remote log shipping: LogDestination, LogProvider, LogHeaders NOT YET IMPLEMENTED
+
 	// Don't override Mode from profile - that should come from command line or defaults
 	return nil
 }
@@ -462,12 +247,10 @@ func (c *Config) LoadBootstrapFromProfile(domain string) (*Bootstrap, error) {
 		domain = DefaultProfileDomain
 	}
 
-	// Try multiple locations where preferences might be stored
-	prefs := c.readManagedPrefs(domain)
-	if prefs == nil {
-		prefs = c.readUserPrefs(domain)
+	prefs, err := c.readPrefs(domain)
+	if err != nil {
+		return nil, err
 	}
-
 	if prefs == nil {
 		return nil, fmt.Errorf("no mobile config found for domain: %s", domain)
 	}
@@ -506,3 +289,26 @@ func (c *Config) LoadBootstrapFromProfile(domain string) (*Bootstrap, error) {
 
 	return &bootstrapConfig, nil
 }
+
+// mergeHeaders copies headers from a profile value into dst. Both the
+// dictionary format {"Authorization": "Basic xyz"} and the array format
+// [{"name": "Authorization", "value": "Basic xyz"}] are accepted.
+func mergeHeaders(dst map[string]string, val interface{}) {
+	if headersMap, ok := val.(map[string]interface{}); ok {
+		for key, value := range headersMap {
+			if strValue, ok := value.(string); ok {
+				dst[key] = strValue
+			}
+		}
+	} else if headersArray, ok := val.([]interface{}); ok {
+		for _, item := range headersArray {
+			if headerDict, ok := item.(map[string]interface{}); ok {
+				if name, nameOk := headerDict["name"].(string); nameOk {
+					if value, valueOk := headerDict["value"].(string); valueOk {
+						dst[name] = value
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// profileField is a Config field and the profile key it is read from.
+type profileField struct {
+	key      string
+	index    int
+	nonEmpty bool
+}
+
+// profileFields lists the Config fields a profile can set, in the order of
+// the struct; see the Config doc comment for the profile tag.
+func profileFields() []profileField {
+	var fields []profileField
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("profile"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, profileField{key: name, index: i, nonEmpty: opts == "nonempty"})
+	}
+	return fields
+}
+
+// profileSectionKeys are the keys of a settings dictionary that are not
+// Config fields.
+var profileSectionKeys = map[string]bool{"bootstrap": true}
+
+// unknownProfileKeys returns the keys of settings that name no setting,
+// sorted, for warnings.
+func unknownProfileKeys(settings map[string]interface{}) []string {
+	known := map[string]bool{}
+	for _, f := range profileFields() {
+		known[f.key] = true
+	}
+	var unknown []string
+	for key := range settings {
+		if !known[key] && !profileSectionKeys[key] && !profilePhases[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// suggestProfileKey returns the setting an unknown key most likely means:
+// one that differs only in case, or by at most two edits (a third for long
+// keys). It is "" when there is none.
+func suggestProfileKey(key string) string {
+	best, bestDist := "", 3
+	if len(key) >= 16 {
+		bestDist = 4
+	}
+	for _, f := range profileFields() {
+		if strings.EqualFold(f.key, key) {
+			return f.key
+		}
+		if d := editDistance(strings.ToLower(f.key), strings.ToLower(key)); d < bestDist {
+			best, bestDist = f.key, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// setProfileValue sets field to val, coerced to the field's type. A value
+// that cannot be coerced is ignored, as if the key were absent. Lists
+// replace the field, headers are merged into it (see mergeHeaders).
+func setProfileValue(field reflect.Value, val interface{}, nonEmpty bool) {
+	switch field.Interface().(type) {
+	case string:
+		if str, ok := val.(string); ok && (str != "" || !nonEmpty) {
+			field.SetString(str)
+		}
+	case bool:
+		if b, ok := asBool(val); ok {
+			field.SetBool(b)
+		}
+	case int:
+		if i, ok := asInt(val); ok {
+			field.SetInt(int64(i))
+		}
+	case time.Duration:
+		if d, ok := asDuration(val); ok {
+			field.SetInt(int64(d))
+		}
+	case []string:
+		if arr, ok := val.([]interface{}); ok {
+			var list []string
+			for _, v := range arr {
+				if str, ok := v.(string); ok && str != "" {
+					list = append(list, str)
+				}
+			}
+			field.Set(reflect.ValueOf(list))
+		}
+	case map[string]string:
+		if field.IsNil() {
+			field.Set(reflect.ValueOf(map[string]string{}))
+		}
+		mergeHeaders(field.Interface().(map[string]string), val)
+	case map[string]int:
+		if m, ok := val.(map[string]interface{}); ok {
+			limits := map[string]int{}
+			for k, v := range m {
+				if i, ok := asInt(v); ok {
+					limits[k] = i
+				}
+			}
+			field.Set(reflect.ValueOf(limits))
+		}
+	}
+}
+
+// asBool accepts a boolean or a string such as "true" or "0".
+func asBool(val interface{}) (bool, bool) {
+	switch v := val.(type) {
+	case bool:
+		return v, true
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		return b, err == nil
+	}
+	return false, false
+}
+
+// asInt accepts the integer types plist and JSON decode to, a float without
+// a fraction and a numeric string.
+func asInt(val interface{}) (int, bool) {
+	switch v := val.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case uint64:
+		return int(v), true
+	case float64:
+		if v == math.Trunc(v) {
+			return int(v), true
+		}
+	case string:
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// asDuration accepts seconds as a number or numeric string, or a Go
+// duration string such as "30m".
+func asDuration(val interface{}) (time.Duration, bool) {
+	if str, ok := val.(string); ok {
+		if d, err := time.ParseDuration(strings.TrimSpace(str)); err == nil {
+			return d, true
+		}
+	}
+	if seconds, ok := asInt(val); ok {
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
@@ -18,15 +19,27 @@ func TestApplySettingsMap_AllKeys(t *testing.T) {
 		"Reboot":                   true,
 		"MaxRetries":               int64(7),
 		"RetryDelay":               int64(11),
+		"DaemonMaxRetries":         int64(0),
+		"RetryStatePath":           "/tmp/retry-state",
+		"DaemonRetryLimits":        map[string]interface{}{"network": int64(0), "validation": int64(1)},
 		"CleanupOnFailure":         false,
+		"RollbackOnFailure":        true,
 		"CleanupOnSuccess":         false,
 		"KeepFailedFiles":          true,
 		"DryRun":                   true,
 		"TrackBackgroundProcesses": true,
 		"BackgroundTimeout":        int64(120),
 		"DownloadMaxConcurrency":   int64(8),
+		"InstallMaxConcurrency":    int64(2),
+		"PhaseTimeout":             "30m",
+		"RemediationInterval":      int64(86400),
+		"RemediationPath":          "/Library/remediate",
+		"RunDeadline":              int64(7200),
 		"WaitForAgentTimeout":      int64(3600),
 		"AgentRequestTimeout":      int64(900),
+		"UserlandGatePolicy":       "deadline",
+		"UserlandGateDeadline":     int64(7200),
+		"UserlandWaitForDesktop":   true,
 		"HTTPAuthUser":             "alice",
 		"HTTPAuthPassword":         "s3cret",
 		"FollowRedirects":          true,
@@ -37,6 +50,44 @@ func TestApplySettingsMap_AllKeys(t *testing.T) {
 		"RetainLogFiles":           true,
 		"WithPreflight":            true,
 		"NoRestartOnError":         true,
+		"VerifyPackageSignatures":  true,
+		"AllowedTeamIDs":           []interface{}{"ABCDE12345", "UBF8T346G9"},
+		"SwiftDialog":              true,
+		"SwiftDialogPath":          "/opt/dialog",
+		"SwiftDialogCommandFile":   "/tmp/dialog.cmd",
+		"SwiftDialogTitle":         "Welcome",
+		"SwiftDialogMessage":       "Hang tight",
+		"SwiftDialogIcon":          "SF=laptopcomputer",
+		"SwiftDialogArgs":          []interface{}{"--blurscreen"},
+		"MetricsListenAddress":     "127.0.0.1:9464",
+		"MetricsLinger":            int64(30),
+		"MetricsPushURL":           "https://push.example",
+		"MetricsPushFormat":        "otlp",
+		"MetricsJob":               "onboarding",
+		"TracingEndpoint":          "http://collector:4318/v1/traces",
+		"TracingServiceName":       "enrollment",
+		"AuditLogPath":             "/var/log/example-audit.log",
+		"StatusURL":                "https://status.example/checkin",
+		"StatusHeaders":            map[string]interface{}{"Authorization": "Bearer t0k"},
+		"StatusPlistPath":          "/tmp/status.plist",
+		"FileBackupDir":            "/Library/Backups/gia",
+		"MunkiSoftwareRepoURL":     "https://munki.example/repo",
+		"MunkiClientIdentifier":    "engineering",
+		"VPPInstallURL":            "https://mdm.example/vpp",
+		"VPPInstallHeaders":        map[string]interface{}{"Authorization": "Basic eA=="},
+		"VPPInstallTimeout":        "45m",
+		"JamfRecon":                true,
+		"JamfPolicyEvents":         []interface{}{"enrollmentComplete", "dock"},
+		"JamfBinaryPath":           "/opt/jamf",
+		"JamfURL":                  "https://example.jamfcloud.com",
+		"JamfAPIToken":             "tok",
+		"WebhookListenAddress":     ":8443",
+		"WebhookSecret":            "hook",
+		"WebhookProfileDomain":     "com.example.rebootstrap",
+		"WebhookTopics":            []interface{}{"mdm.Authenticate", "mdm.TokenUpdate"},
+		"WebhookUDIDs":             []interface{}{"0000-ABCD"},
+		"WebhookTLSCertFile":       "/etc/hook.crt",
+		"WebhookTLSKeyFile":        "/etc/hook.key",
 	}
 	if err := cfg.applySettingsMap(settings); err != nil {
 		t.Fatalf("apply: %v", err)
@@ -45,18 +96,48 @@ func TestApplySettingsMap_AllKeys(t *testing.T) {
 		cfg.InstallPath != "/Library/custom-iapath" ||
 		!cfg.Debug || !cfg.Verbose || !cfg.Reboot ||
 		cfg.MaxRetries != 7 || cfg.RetryDelay != 11 ||
-		cfg.CleanupOnFailure || cfg.CleanupOnSuccess ||
+		cfg.DaemonMaxRetries != 0 || cfg.RetryStatePath != "/tmp/retry-state" ||
+		cfg.DaemonRetryLimits["network"] != 0 || cfg.DaemonRetryLimits["validation"] != 1 || len(cfg.DaemonRetryLimits) != 2 ||
+		cfg.CleanupOnFailure || cfg.CleanupOnSuccess || !cfg.RollbackOnFailure ||
 		!cfg.KeepFailedFiles || !cfg.DryRun || !cfg.TrackBackgroundProcesses ||
 		cfg.BackgroundTimeout != 120*time.Second ||
-		cfg.DownloadMaxConcurrency != 8 ||
+		cfg.DownloadMaxConcurrency != 8 || cfg.InstallMaxConcurrency != 2 ||
+		cfg.PhaseTimeout != 30*time.Minute || cfg.RunDeadline != 2*time.Hour ||
+		cfg.RemediationInterval != 24*time.Hour || cfg.RemediationPath != "/Library/remediate" ||
 		cfg.WaitForAgentTimeout != 3600*time.Second ||
 		cfg.AgentRequestTimeout != 900*time.Second ||
+		cfg.UserlandGatePolicy != UserlandGateDeadline || cfg.UserlandGateDeadline != 2*time.Hour ||
+		!cfg.UserlandWaitForDesktop ||
 		cfg.HTTPAuthUser != "alice" || cfg.HTTPAuthPassword != "s3cret" ||
 		!cfg.FollowRedirects || !cfg.SkipValidation ||
 		cfg.LaunchAgentIdentifier != "com.example.agent" ||
 		cfg.LaunchDaemonIdentifier != "com.example.daemon" ||
 		cfg.LogFilePath != "/var/log/example.log" ||
-		!cfg.RetainLogFiles || !cfg.WithPreflight || !cfg.NoRestartOnError {
+		!cfg.RetainLogFiles || !cfg.WithPreflight || !cfg.NoRestartOnError ||
+		!cfg.VerifyPackageSignatures || len(cfg.AllowedTeamIDs) != 2 || cfg.AllowedTeamIDs[1] != "UBF8T346G9" ||
+		!cfg.SwiftDialog || cfg.SwiftDialogPath != "/opt/dialog" ||
+		cfg.SwiftDialogCommandFile != "/tmp/dialog.cmd" ||
+		cfg.SwiftDialogTitle != "Welcome" || cfg.SwiftDialogMessage != "Hang tight" ||
+		cfg.SwiftDialogIcon != "SF=laptopcomputer" ||
+		len(cfg.SwiftDialogArgs) != 1 || cfg.SwiftDialogArgs[0] != "--blurscreen" ||
+		cfg.MetricsListenAddress != "127.0.0.1:9464" || cfg.MetricsLinger != 30*time.Second ||
+		cfg.MetricsPushURL != "https://push.example" || cfg.MetricsPushFormat != "otlp" ||
+		cfg.MetricsJob != "onboarding" ||
+		cfg.TracingEndpoint != "http://collector:4318/v1/traces" || cfg.TracingServiceName != "enrollment" ||
+		cfg.AuditLogPath != "/var/log/example-audit.log" ||
+		cfg.StatusURL != "https://status.example/checkin" || cfg.StatusHeaders["Authorization"] != "Bearer t0k" ||
+		cfg.StatusPlistPath != "/tmp/status.plist" ||
+		cfg.FileBackupDir != "/Library/Backups/gia" ||
+		cfg.MunkiSoftwareRepoURL != "https://munki.example/repo" || cfg.MunkiClientIdentifier != "engineering" ||
+		cfg.VPPInstallURL != "https://mdm.example/vpp" || cfg.VPPInstallHeaders["Authorization"] != "Basic eA==" ||
+		cfg.VPPInstallTimeout != 45*time.Minute ||
+		!cfg.JamfRecon || len(cfg.JamfPolicyEvents) != 2 || cfg.JamfPolicyEvents[1] != "dock" ||
+		cfg.JamfBinaryPath != "/opt/jamf" || cfg.JamfURL != "https://example.jamfcloud.com" ||
+		cfg.JamfAPIToken != "tok" ||
+		cfg.WebhookListenAddress != ":8443" || cfg.WebhookSecret != "hook" ||
+		cfg.WebhookProfileDomain != "com.example.rebootstrap" ||
+		len(cfg.WebhookTopics) != 2 || len(cfg.WebhookUDIDs) != 1 || cfg.WebhookUDIDs[0] != "0000-ABCD" ||
+		cfg.WebhookTLSCertFile != "/etc/hook.crt" || cfg.WebhookTLSKeyFile != "/etc/hook.key" {
 		t.Fatalf("settings not fully applied: %+v", cfg)
 	}
 }
@@ -123,3 +204,146 @@ func TestRedactedForLogging_MasksSecrets(t *testing.T) {
 		t.Fatalf("X-API-Key leaked")
 	}
 }
+
+// --print-config lists the snapshot, so every setting a profile can set
+// belongs in it (withPreflight keeps its historical spelling).
+func TestRedactedForLogging_ListsEverySetting(t *testing.T) {
+	snap := NewConfig().RedactedForLogging()
+	for _, f := range profileFields() {
+		found := false
+		for key := range snap {
+			if strings.EqualFold(key, f.key) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s is missing from RedactedForLogging", f.key)
+		}
+	}
+}
+
+// Keys used to be applied only when some unrelated key was present; check a
+// few of them on their own and next to a string-form BackgroundTimeout.
+func TestApplySettingsMap_KeysIndependent(t *testing.T) {
+	for _, settings := range []map[string]interface{}{
+		{"DownloadMaxConcurrency": int64(6), "WaitForAgentTimeout": int64(60), "AgentRequestTimeout": "2m"},
+		{"BackgroundTimeout": "90s", "DownloadMaxConcurrency": int64(6), "WaitForAgentTimeout": int64(60), "AgentRequestTimeout": "2m"},
+	} {
+		cfg := NewConfig()
+		if err := cfg.applySettingsMap(settings); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if cfg.DownloadMaxConcurrency != 6 || cfg.WaitForAgentTimeout != time.Minute || cfg.AgentRequestTimeout != 2*time.Minute {
+			t.Fatalf("settings %v not applied: concurrency=%d wait=%v request=%v",
+				settings, cfg.DownloadMaxConcurrency, cfg.WaitForAgentTimeout, cfg.AgentRequestTimeout)
+		}
+	}
+}
+
+func TestApplySettingsMap_Coercion(t *testing.T) {
+	cfg := NewConfig()
+	settings := map[string]interface{}{
+		"MaxRetries":            "4",
+		"RetryDelay":            float64(9),
+		"InstallMaxConcurrency": uint64(3),
+		"Debug":                 "1",
+		"RunDeadline":           "600",
+		"PhaseTimeout":          float64(30),
+		"MetricsLinger":         " 1m ",
+	}
+	if err := cfg.applySettingsMap(settings); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if cfg.MaxRetries != 4 || cfg.RetryDelay != 9 || cfg.InstallMaxConcurrency != 3 || !cfg.Debug ||
+		cfg.RunDeadline != 10*time.Minute || cfg.PhaseTimeout != 30*time.Second || cfg.MetricsLinger != time.Minute {
+		t.Fatalf("values not coerced: %+v", cfg)
+	}
+}
+
+func TestApplySettingsMap_IgnoresMistypedValues(t *testing.T) {
+	cfg := NewConfig()
+	want := *cfg
+	settings := map[string]interface{}{
+		"MaxRetries":        "many",
+		"RetryDelay":        float64(1.5),
+		"Debug":             "sometimes",
+		"BackgroundTimeout": true,
+		"InstallPath":       int64(1),
+		"AllowedTeamIDs":    "ABCDE12345",
+	}
+	if err := cfg.applySettingsMap(settings); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if cfg.MaxRetries != want.MaxRetries || cfg.RetryDelay != want.RetryDelay || cfg.Debug != want.Debug ||
+		cfg.BackgroundTimeout != want.BackgroundTimeout || cfg.InstallPath != want.InstallPath ||
+		len(cfg.AllowedTeamIDs) != len(want.AllowedTeamIDs) {
+		t.Fatalf("mistyped values changed the config: %+v", cfg)
+	}
+}
+
+// Settings decoded from JSON arrive as float64; fields excluded from the
+// profile and unknown keys are not applied.
+func TestApplySettingsMap_JSONAndExcluded(t *testing.T) {
+	cfg := NewConfig()
+	settings := map[string]interface{}{
+		"MaxRetries":        float64(2),
+		"DaemonRetryLimits": map[string]interface{}{"network": float64(0)},
+		"Mode":              "daemon",
+		"KeepLogs":          true,
+		"MaxRetrys":         float64(9),
+	}
+	if err := cfg.applySettingsMap(settings); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if cfg.MaxRetries != 2 || len(cfg.DaemonRetryLimits) != 1 {
+		t.Fatalf("JSON values not applied: %+v", cfg)
+	}
+	if cfg.Mode != "standalone" || cfg.KeepLogs {
+		t.Fatalf("flag-only settings applied from profile: Mode=%q KeepLogs=%v", cfg.Mode, cfg.KeepLogs)
+	}
+	if got := unknownProfileKeys(settings); len(got) != 3 || got[0] != "KeepLogs" || got[1] != "MaxRetrys" || got[2] != "Mode" {
+		t.Fatalf("unknownProfileKeys = %v", got)
+	}
+}
+
+func TestApplySettingsMap_HeaderAuthorizationWins(t *testing.T) {
+	cfg := NewConfig()
+	settings := map[string]interface{}{
+		"HeaderAuthorization": "Bearer b",
+		"HTTPHeaders":         map[string]interface{}{"Authorization": "Bearer a", "X-Key": "k"},
+	}
+	if err := cfg.applySettingsMap(settings); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if cfg.HTTPHeaders["Authorization"] != "Bearer b" || cfg.HTTPHeaders["X-Key"] != "k" {
+		t.Fatalf("HTTPHeaders = %v", cfg.HTTPHeaders)
+	}
+}
+
+func TestSuggestProfileKey(t *testing.T) {
+	for key, want := range map[string]string{
+		"MaxRetrys":             "MaxRetries",
+		"maxretries":            "MaxRetries",
+		"JsonUrl":               "JSONURL",
+		"DownloadMaxConcurency": "DownloadMaxConcurrency",
+		"BackgroundTimout":      "BackgroundTimeout",
+		"SomethingElseEntirely": "",
+		"Mode":                  "",
+	} {
+		if got := suggestProfileKey(key); got != want {
+			t.Errorf("suggestProfileKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestUnknownKeyProblems(t *testing.T) {
+	r := &ProfileResult{UnknownKeys: []string{"shared.MaxRetrys", "daemon.Frobnicate"}}
+	got := r.UnknownKeyProblems()
+	want := []string{
+		"profile key shared.MaxRetrys is not a known setting (did you mean MaxRetries?)",
+		"profile key daemon.Frobnicate is not a known setting",
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("UnknownKeyProblems = %q", got)
+	}
+}
@@ -1,6 +1,12 @@
 package config
 
-import "testing"
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestApplySettingsMap_HeadersAndCompat(t *testing.T) {
 	cfg := NewConfig()
@@ -28,3 +34,127 @@ func TestApplySettingsMap_HeadersAndCompat(t *testing.T) {
 		t.Fatalf("identifiers not set")
 	}
 }
+
+func TestCheckProfile_ReportsUnreadablePreferences(t *testing.T) {
+	domain := "com.example.prefs"
+	stubPreferences(t, "", plistDict(""), plistDict(""))
+	if found, err := CheckProfile(domain, "daemon"); found || err != nil {
+		t.Fatalf("no profile: got %v, %v", found, err)
+	}
+
+	// A value only cfprefsd has, by host, counts as a profile
+	stubPreferences(t, "", plistDict(`<key>shared</key><dict><key>JSONURL</key><string>https://example.com/bootstrap.json</string></dict>`), plistDict(""))
+	if found, err := CheckProfile(domain, "daemon"); !found || err != nil {
+		t.Fatalf("by-host profile: got %v, %v", found, err)
+	}
+
+	stubPreferences(t, "<plist><dict><key>shared</key>", plistDict(""), plistDict(""))
+	if _, err := CheckProfile(domain, "daemon"); !errors.Is(err, ErrPreferencesUnreadable) {
+		t.Fatalf("broken managed profile: got %v, want ErrPreferencesUnreadable", err)
+	}
+}
+
+func TestRetryStateFile_PerProfileDomain(t *testing.T) {
+	cfg := NewConfig()
+	if got := cfg.RetryStateFile(); got != "/var/tmp/go-installapplications/.retry-state" {
+		t.Fatalf("default = %s", got)
+	}
+	cfg.ProfileDomain = "com.example.testing"
+	if got := cfg.RetryStateFile(); got != "/var/tmp/go-installapplications/.retry-state.com.example.testing" {
+		t.Fatalf("other domain = %s", got)
+	}
+	cfg.RetryStatePath = "/tmp/state"
+	if got := cfg.RetryStateFile(); got != "/tmp/state" {
+		t.Fatalf("explicit path = %s", got)
+	}
+
+	cfg = NewConfig()
+	cfg.ApplyCompat()
+	if got := cfg.RetryStateFile(); got != "/var/tmp/installapplications/.retry-state" {
+		t.Fatalf("compat = %s", got)
+	}
+}
+
+func TestReadFromProfile_CFPreferences(t *testing.T) {
+	var gotKeys []string
+	orig := copyAppValues
+	copyAppValues = func(domain string, keys []string) (map[string]interface{}, error) {
+		gotKeys = keys
+		return map[string]interface{}{
+			"shared": map[string]interface{}{"JSONURL": "https://example.com/bootstrap.json", "Debug": true},
+			"daemon": map[string]interface{}{"MaxRetries": int64(9)},
+		}, nil
+	}
+	t.Cleanup(func() { copyAppValues = orig })
+
+	cfg := NewConfig()
+	cfg.Mode = "daemon"
+	result, err := cfg.ReadFromProfile("com.example.cfprefs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.ConfigFound || result.BootstrapSource != "json_url" {
+		t.Fatalf("result = %+v", result)
+	}
+	if !cfg.Debug || cfg.MaxRetries != 9 {
+		t.Errorf("settings not applied: Debug=%v MaxRetries=%d", cfg.Debug, cfg.MaxRetries)
+	}
+	if want := []string{"shared", "bootstrap", "daemon"}; !reflect.DeepEqual(gotKeys, want) {
+		t.Errorf("keys = %v, want %v", gotKeys, want)
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	if err := NewConfig().ValidateSettings(); err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+
+	cfg := NewConfig()
+	cfg.ApplyCompat()
+	cfg.InstallPath = "/Library/elsewhere"
+	cfg.PhaseTimeout = time.Hour
+	cfg.RunDeadline = time.Minute
+	cfg.RetryDelay = -1
+	cfg.UserlandGatePolicy = "later"
+	cfg.JSONURL = "https://server.example/{{serial_number}}.json"
+	cfg.CleanupScope = []string{CleanupDownloads, "everything"}
+	cfg.CleanupPreserve = []string{"diagnostics"}
+	cfg.RebootMethod = RebootMDM // without JamfURL and JamfAPIToken
+	cfg.RebootCondition = "sometimes"
+	err := cfg.ValidateSettings()
+	if err == nil {
+		t.Fatal("expected problems")
+	}
+	for _, want := range []string{"Compat", "PhaseTimeout", "RetryDelay", "UserlandGatePolicy", "JSONURL", "CleanupScope", "CleanupPreserve", "RebootMethod", "RebootCondition"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("no %s problem in:\n%v", want, err)
+		}
+	}
+}
+
+func TestCleanupScopeAndPreserve(t *testing.T) {
+	cfg := NewConfig()
+	for _, scope := range CleanupScopes {
+		want := scope == CleanupDownloads || scope == CleanupInstallPath || scope == CleanupLaunchd
+		if got := cfg.CleansUp(scope); got != want {
+			t.Errorf("default CleansUp(%s) = %v, want %v", scope, got, want)
+		}
+	}
+	cfg.CleanupScope = []string{CleanupDownloads}
+	if cfg.CleansUp(CleanupInstallPath) || !cfg.CleansUp(CleanupDownloads) {
+		t.Errorf("CleanupScope %v not honoured", cfg.CleanupScope)
+	}
+
+	cfg.CleanupPreserve = []string{"/Library/go-installapplications/diagnostics/"}
+	for path, want := range map[string]bool{
+		"/Library/go-installapplications/diagnostics":         true,
+		"/Library/go-installapplications/diagnostics/run.log": true,
+		"/Library/go-installapplications/diagnostics-old":     false,
+		"/Library/go-installapplications":                     false,
+		"/Library/go-installapplications/app.pkg":             false,
+	} {
+		if got := cfg.Preserved(path); got != want {
+			t.Errorf("Preserved(%s) = %v, want %v", path, got, want)
+		}
+	}
+}
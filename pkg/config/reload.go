@@ -0,0 +1,148 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Reloadable are the settings a profile change updates mid-run: logging,
+// retries, timeouts and concurrency. The others decide where the run keeps
+// its files and what it installs, and take effect at the next start.
+var Reloadable = []string{
+	"Debug", "Verbose", "LogLevels",
+	"MaxRetries", "RetryDelay",
+	"BackgroundTimeout", "PhaseTimeout", "WaitForAgentTimeout", "AgentRequestTimeout", "UserlandGateDeadline",
+	"DownloadMaxConcurrency", "InstallMaxConcurrency",
+}
+
+// Reloader carries changes of the profile into a running config. Refresh
+// rereads the profile from any goroutine; Apply copies what changed into
+// the config at a point where nothing else reads it.
+type Reloader struct {
+	read func() (*Config, error) // defaults and profile, without env or flags
+
+	mu      sync.Mutex
+	applied *Config // the profile as last applied
+	latest  *Config // the profile as last read
+}
+
+// NewReloader returns a Reloader for the profile cfg was read from.
+func NewReloader(cfg *Config) *Reloader {
+	r := &Reloader{read: func() (*Config, error) {
+		next := NewConfig()
+		if cfg.Compat {
+			next.ApplyCompat()
+		}
+		next.Mode = cfg.Mode
+		_, err := next.ReadFromProfile(cfg.ProfileDomain)
+		return next, err
+	}}
+	if current, err := r.read(); err == nil {
+		r.applied, r.latest = current, current
+	} else {
+		r.applied, r.latest = NewConfig(), NewConfig()
+	}
+	return r
+}
+
+// Refresh rereads the profile and reports whether a reloadable setting
+// changed since the last Apply.
+func (r *Reloader) Refresh() (bool, error) {
+	next, err := r.read()
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latest = next
+	return len(reloadableChanges(r.applied, next)) > 0, nil
+}
+
+// Apply copies the reloadable settings that changed in the profile into cfg
+// and returns their names. A setting an environment variable or flag set,
+// so that cfg no longer holds the profile's value, keeps its value.
+func (r *Reloader) Apply(cfg *Config) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var applied []string
+	live, old, next := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(r.applied).Elem(), reflect.ValueOf(r.latest).Elem()
+	for _, name := range reloadableChanges(r.applied, r.latest) {
+		if !reflect.DeepEqual(live.FieldByName(name).Interface(), old.FieldByName(name).Interface()) {
+			continue
+		}
+		live.FieldByName(name).Set(next.FieldByName(name))
+		if _, ok := cfg.phaseBase[name]; ok {
+			cfg.phaseBase[name] = next.FieldByName(name).Interface()
+		}
+		applied = append(applied, name)
+	}
+	cfg.phaseOverrides = r.latest.phaseOverrides
+	r.applied = r.latest
+	return applied
+}
+
+// reloadableChanges returns the reloadable settings that differ between a
+// and b.
+func reloadableChanges(a, b *Config) []string {
+	var changed []string
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for _, name := range Reloadable {
+		if !reflect.DeepEqual(va.FieldByName(name).Interface(), vb.FieldByName(name).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// managedPrefsDir is where macOS writes the preferences of installed
+// profiles; a variable so tests can replace it.
+var managedPrefsDir = "/Library/Managed Preferences"
+
+// profileRecheck is how often the managed preferences are checked when no
+// change was signalled, for writes the directory watch does not see.
+var profileRecheck = 30 * time.Second
+
+// WatchProfile calls onChange each time the managed preferences of domain
+// are written, until ctx is done. The directory is watched with kqueue on
+// macOS; the plist's size and modification time decide whether it changed.
+func WatchProfile(ctx context.Context, domain string, onChange func()) {
+	path := filepath.Join(managedPrefsDir, domain+".plist")
+	last := plistState(path)
+	for waitForDirChange(ctx, managedPrefsDir, profileRecheck) {
+		if state := plistState(path); state != last {
+			last = state
+			onChange()
+		}
+	}
+}
+
+// fileState identifies a version of a file; the zero value means there is
+// none.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func plistState(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{info.Size(), info.ModTime()}
+}
+
+// sleepUntil returns true at deadline, or false if ctx is done first.
+func sleepUntil(ctx context.Context, deadline time.Time) bool {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReloaderApply(t *testing.T) {
+	profile := NewConfig()
+	profile.MaxRetries = 5
+	r := &Reloader{read: func() (*Config, error) {
+		next := *profile
+		return &next, nil
+	}}
+	r.applied, _ = r.read()
+	r.latest = r.applied
+
+	live := NewConfig()
+	live.MaxRetries = 5
+	live.PhaseTimeout = time.Hour // set by a flag
+
+	profile.MaxRetries = 9
+	profile.Debug = true
+	profile.PhaseTimeout = time.Minute
+	profile.InstallPath = "/Library/elsewhere"
+	if changed, err := r.Refresh(); err != nil || !changed {
+		t.Fatalf("Refresh = %v, %v", changed, err)
+	}
+	if got, want := r.Apply(live), []string{"Debug", "MaxRetries"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply = %v, want %v", got, want)
+	}
+	if !live.Debug || live.MaxRetries != 9 || live.PhaseTimeout != time.Hour {
+		t.Errorf("live = Debug %v, MaxRetries %d, PhaseTimeout %v", live.Debug, live.MaxRetries, live.PhaseTimeout)
+	}
+	if live.InstallPath != NewConfig().InstallPath {
+		t.Errorf("InstallPath is not reloadable, got %s", live.InstallPath)
+	}
+	if changed, _ := r.Refresh(); changed || r.Apply(live) != nil {
+		t.Error("an unchanged profile reported changes")
+	}
+}
+
+func TestWatchProfile(t *testing.T) {
+	dir := t.TempDir()
+	origDir, origRecheck := managedPrefsDir, profileRecheck
+	managedPrefsDir, profileRecheck = dir, 10*time.Millisecond
+	t.Cleanup(func() { managedPrefsDir, profileRecheck = origDir, origRecheck })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		WatchProfile(ctx, "com.example.watch", func() { changes <- struct{}{} })
+		close(done)
+	}()
+
+	// Keep rewriting the plist, since the watch may start after a write
+	path, content := filepath.Join(dir, "com.example.watch.plist"), "<plist/>"
+	deadline := time.After(5 * time.Second)
+	for noticed := false; !noticed; {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		content += "\n"
+		select {
+		case <-changes:
+			noticed = true
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("the written profile was not noticed")
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchProfile did not return when ctx was done")
+	}
+}
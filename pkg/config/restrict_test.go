@@ -0,0 +1,51 @@
+package config
+
+import "testing"
+
+func TestBootstrapRestrict(t *testing.T) {
+	b := &Bootstrap{
+		Preflight:      []Item{{Name: "check"}},
+		SetupAssistant: []Item{{Name: "Crowdstrike"}, {Name: "Munki"}},
+		Userland:       []Item{{Name: "Dock"}, {Name: "Crowdstrike"}, {Name: "Wallpaper"}},
+	}
+
+	got, err := b.Restrict("userland", nil)
+	if err != nil || len(got.Preflight)+len(got.SetupAssistant) != 0 || len(got.Userland) != 3 {
+		t.Fatalf("phase only: %+v, %v", got, err)
+	}
+
+	got, err = b.Restrict("", []string{"Wallpaper", "Crowdstrike"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Preflight) != 0 || len(got.SetupAssistant) != 1 || len(got.Userland) != 2 ||
+		got.Userland[0].Name != "Crowdstrike" || got.Userland[1].Name != "Wallpaper" {
+		t.Fatalf("items only: %+v", got)
+	}
+
+	got, err = b.Restrict("setupassistant", []string{"Crowdstrike"})
+	if err != nil || len(got.SetupAssistant) != 1 || len(got.Userland) != 0 {
+		t.Fatalf("phase and item: %+v, %v", got, err)
+	}
+
+	if _, err := b.Restrict("preflight", []string{"Dock"}); err == nil {
+		t.Fatal("expected an error for an item outside the phase")
+	}
+	if _, err := b.Restrict("login", nil); err == nil {
+		t.Fatal("expected an error for an unknown phase")
+	}
+
+	b.Hooks = &Hooks{PreSetupAssistant: []Item{{Name: "network"}}, PostUserland: []Item{{Name: "tidy"}}}
+	got, err = b.Restrict("userland", nil)
+	if err != nil || got.Hooks.Pre("setupassistant") != nil || len(got.Hooks.Post("userland")) != 1 {
+		t.Fatalf("hooks for phase: %+v, %v", got.Hooks, err)
+	}
+	got, err = b.Restrict("", []string{"network", "Dock"})
+	if err != nil || len(got.Hooks.Pre("setupassistant")) != 1 || got.Hooks.Post("userland") != nil {
+		t.Fatalf("hooks for items: %+v, %v", got.Hooks, err)
+	}
+
+	if len(b.Userland) != 3 {
+		t.Fatal("Restrict modified the bootstrap")
+	}
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// URL placeholders, resolved for the Mac the bootstrap is fetched on.
+const (
+	PlaceholderSerial       = "serial"        // {{serial}}: the hardware serial number
+	PlaceholderUDID         = "udid"          // {{udid}}: the hardware UUID MDM servers report as the UDID
+	PlaceholderProfileValue = "profile_value" // {{profile_value:Key}}: a value of the profile, see ProfileValue
+)
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([a-z_]+)\s*(?::([^}]*))?\}\}`)
+
+// ExpandURL replaces the placeholders of a URL template, such as
+// https://server.example/{{serial}}.json, with the values lookup returns,
+// escaped for a path segment or a query value. Unknown placeholders and
+// values that cannot be looked up are errors, so a per-device URL never
+// silently turns into a shared one.
+func ExpandURL(template string, lookup func(name, arg string) (string, error)) (string, error) {
+	var errs []string
+	expanded := placeholderPattern.ReplaceAllStringFunc(template, func(m string) string {
+		parts := placeholderPattern.FindStringSubmatch(m)
+		name, arg := parts[1], strings.TrimSpace(parts[2])
+		switch name {
+		case PlaceholderSerial, PlaceholderUDID:
+		case PlaceholderProfileValue:
+			if arg == "" {
+				errs = append(errs, m+" needs a key, e.g. {{profile_value:Group}}")
+				return m
+			}
+		default:
+			errs = append(errs, "unknown placeholder "+m)
+			return m
+		}
+		value, err := lookup(name, arg)
+		if err == nil && value == "" {
+			err = fmt.Errorf("empty value")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", m, err))
+			return m
+		}
+		return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+	})
+	if len(errs) > 0 {
+		return "", fmt.Errorf("cannot expand %s: %s", template, strings.Join(errs, "; "))
+	}
+	return expanded, nil
+}
+
+// HasPlaceholders reports whether a URL template has placeholders for
+// ExpandURL.
+func HasPlaceholders(template string) bool {
+	return placeholderPattern.MatchString(template)
+}
+
+// ProfileValue returns a value of the profile for {{profile_value:...}}:
+// Key from the profile's own domain, or domain/Key from another, such as
+// one an MDM fills in per device group. Numbers and booleans are formatted;
+// dictionaries and arrays are errors, as is a profile that cannot be read
+// (ErrPreferencesUnreadable).
+func (c *Config) ProfileValue(ref string) (string, error) {
+	domain, key := c.ProfileDomain, ref
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		domain, key = ref[:i], ref[i+1:]
+	}
+	if domain == "" {
+		domain = DefaultProfileDomain
+	}
+	values, err := copyAppValues(domain, []string{key})
+	if err != nil {
+		return "", err
+	}
+	switch v := values[key].(type) {
+	case nil:
+		return "", fmt.Errorf("%s is not set in %s", key, domain)
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("%s in %s is not a string or number", key, domain)
+	}
+}
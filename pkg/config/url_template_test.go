@@ -0,0 +1,62 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExpandURL(t *testing.T) {
+	lookup := func(name, arg string) (string, error) {
+		switch name {
+		case PlaceholderSerial:
+			return "C02XL0GZJGH5", nil
+		case PlaceholderUDID:
+			return "0000-ABCD", nil
+		}
+		if arg == "Group" {
+			return "Sales & Marketing", nil
+		}
+		return "", errors.New("not set")
+	}
+	got, err := ExpandURL("https://server.example/{{serial}}/{{ udid }}.json?group={{profile_value:Group}}", lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://server.example/C02XL0GZJGH5/0000-ABCD.json?group=Sales%20%26%20Marketing"; got != want {
+		t.Errorf("ExpandURL = %s, want %s", got, want)
+	}
+
+	for template, want := range map[string]string{
+		"https://server.example/{{hostname}}.json":           "unknown placeholder {{hostname}}",
+		"https://server.example/{{profile_value}}.json":      "needs a key",
+		"https://server.example/{{profile_value:Site}}.json": "not set",
+	} {
+		if _, err := ExpandURL(template, lookup); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ExpandURL(%s) error = %v, want %q", template, err, want)
+		}
+	}
+}
+
+func TestProfileValue(t *testing.T) {
+	var gotDomain string
+	orig := copyAppValues
+	copyAppValues = func(domain string, keys []string) (map[string]interface{}, error) {
+		gotDomain = domain
+		return map[string]interface{}{"Group": "sales", "Floor": int64(3), "Tags": []interface{}{"a"}}, nil
+	}
+	t.Cleanup(func() { copyAppValues = orig })
+
+	cfg := NewConfig()
+	if v, err := cfg.ProfileValue("Group"); err != nil || v != "sales" || gotDomain != DefaultProfileDomain {
+		t.Errorf("ProfileValue(Group) = %q, %v from %s", v, err, gotDomain)
+	}
+	if v, err := cfg.ProfileValue("com.example.site/Floor"); err != nil || v != "3" || gotDomain != "com.example.site" {
+		t.Errorf("ProfileValue(com.example.site/Floor) = %q, %v from %s", v, err, gotDomain)
+	}
+	for _, ref := range []string{"Tags", "Missing"} {
+		if _, err := cfg.ProfileValue(ref); err == nil {
+			t.Errorf("ProfileValue(%s) should fail", ref)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"context"
+	"syscall"
+	"time"
+)
+
+// waitForDirChange returns true once dir has changed or recheck has passed,
+// and false once ctx is done. A directory that cannot be watched, e.g.
+// because no profile has been installed yet, is only rechecked.
+func waitForDirChange(ctx context.Context, dir string, recheck time.Duration) bool {
+	deadline := time.Now().Add(recheck)
+	fd, err := syscall.Open(dir, syscall.O_RDONLY, 0)
+	if err != nil {
+		return sleepUntil(ctx, deadline)
+	}
+	defer syscall.Close(fd)
+	kq, err := syscall.Kqueue()
+	if err != nil {
+		return sleepUntil(ctx, deadline)
+	}
+	defer syscall.Close(kq)
+
+	var watch syscall.Kevent_t
+	syscall.SetKevent(&watch, fd, syscall.EVFILT_VNODE, syscall.EV_ADD|syscall.EV_CLEAR)
+	watch.Fflags = syscall.NOTE_WRITE | syscall.NOTE_EXTEND | syscall.NOTE_ATTRIB | syscall.NOTE_DELETE | syscall.NOTE_RENAME
+	changes := []syscall.Kevent_t{watch}
+	events := make([]syscall.Kevent_t, 1)
+	// Wake up every second to notice ctx
+	for time.Now().Before(deadline) {
+		if ctx.Err() != nil {
+			return false
+		}
+		timeout := syscall.NsecToTimespec(int64(time.Second))
+		n, err := syscall.Kevent(kq, changes, events, &timeout)
+		if err != nil && err != syscall.EINTR {
+			return sleepUntil(ctx, deadline)
+		}
+		if n > 0 {
+			return true
+		}
+		changes = nil
+	}
+	return ctx.Err() == nil
+}
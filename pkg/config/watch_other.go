@@ -0,0 +1,14 @@
+//go:build !darwin
+
+package config
+
+import (
+	"context"
+	"time"
+)
+
+// waitForDirChange returns true once recheck has passed and false once ctx
+// is done; directories are only watched on macOS.
+func waitForDirChange(ctx context.Context, dir string, recheck time.Duration) bool {
+	return sleepUntil(ctx, time.Now().Add(recheck))
+}
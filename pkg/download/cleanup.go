@@ -4,21 +4,54 @@ import (
 	"fmt"
 	"os"
 	"sync"
+
+	"github.com/go-installapplications/pkg/utils"
 )
 
 // CleanupTracker keeps track of files that need cleanup
 type CleanupTracker struct {
-	mutex sync.Mutex
-	files map[string]bool // filepath -> shouldDelete (true=delete on failure; false=preserve)
+	mutex   sync.Mutex
+	files   map[string]bool   // filepath -> shouldDelete (true=delete on failure; false=preserve)
+	backups map[string]string // filepath -> backup dir of the file it replaced
+	dryRun  bool              // only report what would be removed
+	logger  utils.Logger
+	// preserved reports the paths never to remove, see config.Preserved
+	preserved func(string) bool
 }
 
-// NewCleanupTracker creates a new cleanup tracker
-func NewCleanupTracker() *CleanupTracker {
+// NewCleanupTracker creates a new cleanup tracker that reports through logger
+func NewCleanupTracker(logger utils.Logger) *CleanupTracker {
 	return &CleanupTracker{
-		files: make(map[string]bool),
+		files:   make(map[string]bool),
+		backups: make(map[string]string),
+		logger:  logger,
 	}
 }
 
+// SetDryRun makes Cleanup and CleanupAll report the files they would remove
+// and leave them in place
+func (ct *CleanupTracker) SetDryRun(dryRun bool) {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+	ct.dryRun = dryRun
+}
+
+// SetPreserve makes Cleanup and CleanupAll leave the files preserved
+// reports in place, such as cfg.Preserved
+func (ct *CleanupTracker) SetPreserve(preserved func(path string) bool) {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+	ct.preserved = preserved
+}
+
+// TrackBackup notes that the original of filepath was backed up to dir, so
+// Cleanup puts it back instead of leaving nothing
+func (ct *CleanupTracker) TrackBackup(filepath, dir string) {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+	ct.backups[filepath] = dir
+}
+
 // TrackFile adds a file to cleanup tracking
 func (ct *CleanupTracker) TrackFile(filepath string) {
 	ct.mutex.Lock()
@@ -33,7 +66,8 @@ func (ct *CleanupTracker) MarkSuccess(filepath string) {
 	ct.files[filepath] = false
 }
 
-// Cleanup removes all files marked for deletion
+// Cleanup removes all files marked for deletion and restores the backups
+// of those that replaced a file
 func (ct *CleanupTracker) Cleanup() error {
 	ct.mutex.Lock()
 	defer ct.mutex.Unlock()
@@ -41,9 +75,26 @@ func (ct *CleanupTracker) Cleanup() error {
 	var errors []error
 	for filepath, shouldDelete := range ct.files {
 		if shouldDelete {
-			fmt.Printf("Cleaning up failed file: %s\n", filepath)
-			if err := os.Remove(filepath); err != nil && !os.IsNotExist(err) {
+			if ct.preserved != nil && ct.preserved(filepath) {
+				continue
+			}
+			if ct.dryRun {
+				ct.logger.Info("[DRY RUN] Would clean up failed file: %s", filepath)
+				continue
+			}
+			ct.logger.Info("Cleaning up failed file: %s", filepath)
+			if err := remove(filepath); err != nil {
 				errors = append(errors, fmt.Errorf("failed to cleanup %s: %w", filepath, err))
+				continue
+			}
+			if dir, ok := ct.backups[filepath]; ok {
+				restored, err := utils.RestoreBackup(filepath, dir)
+				if restored || err != nil {
+					utils.RecordCleanup("restore", filepath, err)
+				}
+				if err != nil {
+					errors = append(errors, err)
+				}
 			}
 		}
 	}
@@ -62,7 +113,14 @@ func (ct *CleanupTracker) CleanupAll() error {
 
 	var errors []error
 	for filepath := range ct.files {
-		if err := os.Remove(filepath); err != nil && !os.IsNotExist(err) {
+		if ct.preserved != nil && ct.preserved(filepath) {
+			continue
+		}
+		if ct.dryRun {
+			ct.logger.Info("[DRY RUN] Would clean up file: %s", filepath)
+			continue
+		}
+		if err := remove(filepath); err != nil {
 			errors = append(errors, fmt.Errorf("failed to cleanup %s: %w", filepath, err))
 		}
 	}
@@ -71,3 +129,14 @@ func (ct *CleanupTracker) CleanupAll() error {
 	}
 	return nil
 }
+
+// remove removes a tracked file and records it in the cleanup report. A
+// file that is already gone is not an error.
+func remove(path string) error {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	utils.RecordCleanup("remove", path, err)
+	return err
+}
@@ -0,0 +1,76 @@
+package download
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/utils"
+)
+
+func TestCleanupTracker_DryRunRemovesNothing(t *testing.T) {
+	dir := t.TempDir()
+	failed, done := filepath.Join(dir, "failed.pkg"), filepath.Join(dir, "done.pkg")
+	for _, p := range []string{failed, done} {
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	ct := NewCleanupTracker(utils.NewLoggerWithWriter(false, false, &out))
+	ct.SetDryRun(true)
+	ct.TrackFile(failed)
+	ct.TrackFile(done)
+	ct.MarkSuccess(done)
+	if err := ct.Cleanup(); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	if err := ct.CleanupAll(); err != nil {
+		t.Fatalf("cleanup all: %v", err)
+	}
+	for _, p := range []string{failed, done} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("dry run removed %s: %v", p, err)
+		}
+	}
+	if !strings.Contains(out.String(), "[DRY RUN] Would clean up failed file: "+failed) {
+		t.Errorf("dry run not logged, got %q", out.String())
+	}
+
+	ct.SetDryRun(false)
+	if err := ct.CleanupAll(); err != nil {
+		t.Fatalf("cleanup all: %v", err)
+	}
+	if _, err := os.Stat(failed); !os.IsNotExist(err) {
+		t.Errorf("%s should be removed, stat err = %v", failed, err)
+	}
+}
+
+func TestCleanupTracker_KeepsPreservedFiles(t *testing.T) {
+	dir := t.TempDir()
+	kept, removed := filepath.Join(dir, "keep", "tool.pkg"), filepath.Join(dir, "app.pkg")
+	if err := os.MkdirAll(filepath.Dir(kept), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{kept, removed} {
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ct := NewCleanupTracker(utils.NewLoggerWithWriter(false, false, io.Discard))
+	ct.SetPreserve(func(path string) bool { return filepath.Dir(path) == filepath.Join(dir, "keep") })
+	ct.TrackFile(kept)
+	ct.TrackFile(removed)
+	if err := ct.CleanupAll(); err != nil {
+		t.Fatalf("cleanup all: %v", err)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("preserved file removed: %v", err)
+	}
+	if _, err := os.Stat(removed); !os.IsNotExist(err) {
+		t.Errorf("%s should be removed, stat err = %v", removed, err)
+	}
+}
@@ -1,15 +1,25 @@
 package download
 
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/identity"
+	"github.com/go-installapplications/pkg/metrics"
+	"github.com/go-installapplications/pkg/retry"
+	"github.com/go-installapplications/pkg/tracing"
 	"github.com/go-installapplications/pkg/utils"
+	"github.com/go-installapplications/pkg/version"
 )
 
 // HashCheckPolicy controls the verifier's treatment of missing/mismatching hashes.
@@ -56,7 +66,7 @@ func (p HashCheckPolicy) String() string {
 // Client handles HTTP downloads
 type Client struct {
 	httpClient       *http.Client
-	logger           *utils.Logger
+	logger           utils.Logger
 	authUser         string
 	authPassword     string
 	customHeaders    map[string]string
@@ -64,17 +74,25 @@ type Client struct {
 	defaultRetryWait int // seconds
 	followRedirects  bool
 	hashPolicy       HashCheckPolicy
+	metrics          *metrics.Recorder
+	tracer           *tracing.Tracer
+	ctx              context.Context
+	backupDir        string
+	workDir          string // see SetWorkDir
+	workMu           sync.Mutex
+	identity         *identity.Identity // signs each request, see SetIdentity
 }
 
 // NewClient creates a new download client
-func NewClient(logger *utils.Logger) *Client {
+func NewClient(logger utils.Logger) *Client {
 	client := &Client{
 		httpClient:       &http.Client{CheckRedirect: nil},
-		logger:           logger,
+		logger:           utils.Component(logger, utils.ComponentDownload),
 		customHeaders:    make(map[string]string),
 		defaultRetries:   3,
 		defaultRetryWait: 5,
 		followRedirects:  false, // Default to false to match config
+		ctx:              context.Background(),
 	}
 	// Set the HTTP client to not follow redirects by default
 	client.SetFollowRedirects(false)
@@ -82,16 +100,17 @@ func NewClient(logger *utils.Logger) *Client {
 }
 
 // NewClientWithAuth creates a download client with HTTP authentication
-func NewClientWithAuth(logger *utils.Logger, authUser, authPassword string, headers map[string]string) *Client {
+func NewClientWithAuth(logger utils.Logger, authUser, authPassword string, headers map[string]string) *Client {
 	client := &Client{
 		httpClient:       &http.Client{CheckRedirect: nil},
-		logger:           logger,
+		logger:           utils.Component(logger, utils.ComponentDownload),
 		authUser:         authUser,
 		authPassword:     authPassword,
 		customHeaders:    make(map[string]string),
 		defaultRetries:   3,
 		defaultRetryWait: 5,
 		followRedirects:  false, // Default to false to match config
+		ctx:              context.Background(),
 	}
 
 	// Set the HTTP client to not follow redirects by default
@@ -109,7 +128,7 @@ func NewClientWithAuth(logger *utils.Logger, authUser, authPassword string, head
 func (c *Client) SetFollowRedirects(follow bool) {
 	c.followRedirects = follow
 	if follow {
-		c.httpClient.CheckRedirect = nil
+		c.httpClient.CheckRedirect = c.checkRedirect
 	} else {
 		c.httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse // do not follow
@@ -117,6 +136,23 @@ func (c *Client) SetFollowRedirects(follow bool) {
 	}
 }
 
+// checkRedirect follows up to 10 redirects, as the default policy does. The
+// device signature covers the URL, so it is made again for a redirect to
+// the same scheme and host, and dropped for one elsewhere, which must not
+// get a signature it could replay.
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	for _, header := range []string{identity.HeaderCertificate, identity.HeaderDate, identity.HeaderSignature} {
+		req.Header.Del(header)
+	}
+	if c.identity == nil || req.URL.Scheme != via[0].URL.Scheme || req.URL.Host != via[0].URL.Host {
+		return nil
+	}
+	return c.identity.SignRequest(req, time.Now())
+}
+
 // SetRetryDefaults sets the default retry count and delay (seconds) used when an item doesn't specify them
 func (c *Client) SetRetryDefaults(retries, retryWaitSeconds int) {
 	if retries > 0 {
@@ -132,10 +168,117 @@ func (c *Client) SetHashCheckPolicy(p HashCheckPolicy) {
 	c.hashPolicy = p
 }
 
+// SetMetrics records download counts, bytes, retries and durations in m.
+// A nil Recorder disables recording.
+func (c *Client) SetMetrics(m *metrics.Recorder) {
+	c.metrics = m
+}
+
+// SetContext makes downloads stop when ctx is done: in-flight requests are
+// aborted, partially written files removed and no further retries made.
+func (c *Client) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// SetBackupDir sets where the files replaced by items with backup are kept
+// (see utils.BackupPath).
+func (c *Client) SetBackupDir(dir string) {
+	c.backupDir = dir
+}
+
+// SetIdentity authenticates requests with a device identity: as the TLS
+// client certificate with config.IdentityAuthMTLS, or with a signature
+// header on each request with config.IdentityAuthSignature (see
+// identity.SignRequest).
+func (c *Client) SetIdentity(id *identity.Identity, mode string) {
+	switch mode {
+	case config.IdentityAuthMTLS:
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{id.TLSCertificate()}}
+		c.httpClient.Transport = transport
+	case config.IdentityAuthSignature:
+		c.identity = id
+	}
+}
+
+// UseDeviceIdentity authenticates with the keychain identity of
+// cfg.IdentityAuth, if one is configured.
+func (c *Client) UseDeviceIdentity(cfg *config.Config) error {
+	if cfg.IdentityAuth == "" {
+		return nil
+	}
+	id, err := identity.Find(cfg.IdentityCommonName)
+	if err != nil {
+		return err
+	}
+	c.logger.Debug("Authenticating downloads with identity %q (%s, expires %s)",
+		cfg.IdentityCommonName, cfg.IdentityAuth, id.Certificate.NotAfter.Format("2006-01-02"))
+	c.SetIdentity(id, cfg.IdentityAuth)
+	return nil
+}
+
+// NewItemClient creates the client that downloads bootstrap items, with
+// cfg's authentication (device identity included), retries, redirects, hash
+// policy, file backups and working directory under InstallPath, whose
+// leftovers from earlier runs it removes. An error means the device
+// identity could not be used; the client still works without it.
+func NewItemClient(cfg *config.Config, logger utils.Logger) (*Client, error) {
+	var client *Client
+	if cfg.HTTPAuthUser != "" || len(cfg.HTTPHeaders) > 0 {
+		client = NewClientWithAuth(logger, cfg.HTTPAuthUser, cfg.HTTPAuthPassword, cfg.HTTPHeaders)
+		logger.Debug("Created authenticated download client")
+	} else {
+		client = NewClient(logger)
+	}
+	client.SetRetryDefaults(cfg.MaxRetries, cfg.RetryDelay)
+	client.SetFollowRedirects(cfg.FollowRedirects)
+	client.SetHashCheckPolicy(ParseHashCheckPolicy(cfg.HashCheckPolicy))
+	client.SetBackupDir(cfg.FileBackupDir)
+	client.SetWorkDir(WorkDir(cfg.InstallPath))
+	if !cfg.DryRun {
+		SweepWorkDirs(cfg.InstallPath, client.logger)
+	}
+	return client, client.UseDeviceIdentity(cfg)
+}
+
+// SetTracer records a download span under each item's span in t. A nil
+// Tracer disables tracing.
+func (c *Client) SetTracer(t *tracing.Tracer) {
+	c.tracer = t
+}
+
+// redactURL drops the query string and userinfo, which may carry tokens,
+// before a URL is recorded in telemetry.
+func redactURL(raw string) string {
+	if i := strings.IndexAny(raw, "?#"); i >= 0 {
+		raw = raw[:i]
+	}
+	if i := strings.Index(raw, "://"); i >= 0 {
+		if at := strings.Index(raw[i+3:], "@"); at >= 0 {
+			raw = raw[:i+3] + raw[i+3+at+1:]
+		}
+	}
+	return raw
+}
+
 // DownloadFileWithRetries downloads a file with item-specific retry settings
 func (c *Client) DownloadFileWithRetries(url, filepath, expectedHash string, retries int, retryWait int) error {
+	return c.download(url, filepath, expectedHash, retries, retryWait, nil)
+}
+
+// download is DownloadFileWithRetries; replaced, if not nil, is called once
+// filepath itself has been written to, so a failure before that leaves
+// whatever was there alone.
+func (c *Client) download(url, filepath, expectedHash string, retries int, retryWait int, replaced func()) (err error) {
 	c.logger.Debug("Downloading %s to %s", url, filepath)
 
+	started := time.Now()
+	var attempts int
+	var bytesWritten int64
+	defer func() {
+		c.metrics.ObserveDownload(time.Since(started), attempts, bytesWritten, err)
+	}()
+
 	// Use client defaults if not specified
 	if retries == 0 {
 		retries = c.defaultRetries
@@ -146,25 +289,54 @@ func (c *Client) DownloadFileWithRetries(url, filepath, expectedHash string, ret
 
 	c.logger.Debug("Using retry settings: %d retries, %d second delay", retries, retryWait)
 
+	// Download and verify in the working directory, out of reach until the
+	// file is moved into place
+	dest := filepath
+	if c.workDir != "" {
+		staged, err := c.stagingFile(dest)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(staged)
+		filepath = staged
+		c.logger.Verbose("Staging download in %s", staged)
+	}
+
+	// An in-place download replaces the file as soon as it is created
+	created := replaced
+	if filepath != dest {
+		created = nil
+	}
+
 	// Create the retry operation as a closure
 	downloadOperation := func() error {
-		return c.downloadOnce(url, filepath)
+		n, err := c.downloadOnce(url, filepath, created)
+		bytesWritten += n
+		return err
 	}
 
 	// Use item-specific retry logic
 	retryDuration := time.Duration(retryWait) * time.Second
-	attempts, err := utils.Retry(downloadOperation, retries, retryDuration, fmt.Sprintf("download %s", url), c.logger)
+	attempts, err = utils.RetryContext(c.ctx, downloadOperation, retries, retryDuration, fmt.Sprintf("download %s", url), c.logger)
 	if err != nil {
-		return err
+		return retry.Tag(retry.CategoryNetwork, err)
 	}
 
 	c.logger.Debug("Download completed in %d attempts", attempts)
 
 	// Verify hash if provided
 	if err := c.VerifyFileHash(filepath, expectedHash); err != nil {
-		return err
+		return retry.Tag(retry.CategoryValidation, err)
 	}
 
+	if filepath != dest {
+		if err := placeStaged(filepath, dest); err != nil {
+			return fmt.Errorf("failed to move download to %s: %w", dest, err)
+		}
+		if replaced != nil {
+			replaced()
+		}
+	}
 	return nil
 }
 
@@ -228,19 +400,67 @@ func (c *Client) VerifyFileHash(filepath, expectedHash string) error {
 	return nil
 }
 
-// downloadOnce performs a single download attempt
-func (c *Client) downloadOnce(url, filepath string) error {
+// downloadOnce performs a single download attempt and returns the number of
+// bytes written; created, if not nil, is called once filepath is created
+func (c *Client) downloadOnce(url, filepath string, created func()) (int64, error) {
 	c.logger.Debug("Making HTTP request to %s", url)
 
 	// Ensure the directory exists
 	if err := utils.EnsureDirForFile(filepath); err != nil {
-		return err
+		return 0, err
+	}
+
+	req, err := c.newRequest("GET", url)
+	if err != nil {
+		return 0, err
+	}
+
+	// Make HTTP request
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	c.logger.Debug("HTTP response status: %d", resp.StatusCode)
+	c.logger.Verbose("HTTP response headers: %v", resp.Header)
+
+	// Check if request was successful
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("download failed with status: %d", resp.StatusCode)
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequest("GET", url, nil)
+	// Create the output file
+	file, err := os.Create(filepath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file %s: %w", filepath, err)
+	}
+	defer file.Close()
+	if created != nil {
+		created()
+	}
+
+	// Copy data from response to file
+	bytesWritten, err := io.Copy(file, resp.Body)
+	if err != nil {
+		if c.ctx.Err() != nil {
+			// Interrupted: don't leave a truncated file behind
+			file.Close()
+			_ = os.Remove(filepath)
+		}
+		return bytesWritten, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	c.logger.Debug("Downloaded %d bytes to %s", bytesWritten, filepath)
+	return bytesWritten, nil
+}
+
+// newRequest builds a request carrying the configured authentication,
+// custom headers and User-Agent.
+func (c *Client) newRequest(method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(c.ctx, method, url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request for %s: %w", url, err)
+		return nil, fmt.Errorf("failed to create request for %s: %w", url, err)
 	}
 
 	// Add HTTP Basic Authentication if configured
@@ -259,7 +479,13 @@ func (c *Client) downloadOnce(url, filepath string) error {
 		}
 	}
 
-	req.Header.Set("User-Agent", "go-installapplications/1.0")
+	req.Header.Set("User-Agent", version.UserAgent())
+
+	if c.identity != nil {
+		if err := c.identity.SignRequest(req, time.Now()); err != nil {
+			return nil, fmt.Errorf("failed to sign request for %s: %w", redactURL(url), err)
+		}
+	}
 
 	// Log request headers in verbose mode (mask secret values)
 	if c.logger != nil {
@@ -273,35 +499,42 @@ func (c *Client) downloadOnce(url, filepath string) error {
 		}
 		c.logger.Verbose("HTTP request headers: %v", safe)
 	}
+	return req, nil
+}
 
-	// Make HTTP request
-	resp, err := c.httpClient.Do(req)
+// Head sends a HEAD request to url with the client's authentication and
+// headers and returns the response status code. Redirects follow the
+// client's FollowRedirects setting.
+func (c *Client) Head(url string) (int, error) {
+	resp, err := c.head(url)
 	if err != nil {
-		return fmt.Errorf("failed to download %s: %w", url, err)
+		return 0, err
 	}
-	defer resp.Body.Close()
-
-	c.logger.Debug("HTTP response status: %d", resp.StatusCode)
-	c.logger.Verbose("HTTP response headers: %v", resp.Header)
+	return resp.StatusCode, nil
+}
 
-	// Check if request was successful
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status: %d", resp.StatusCode)
+// ContentLength returns the size of the file at url from a HEAD request, or
+// -1 when the server does not say.
+func (c *Client) ContentLength(url string) (int64, error) {
+	resp, err := c.head(url)
+	if err != nil {
+		return -1, err
+	}
+	if resp.StatusCode >= 400 {
+		return -1, fmt.Errorf("HEAD %s: HTTP %d", redactURL(url), resp.StatusCode)
 	}
+	return resp.ContentLength, nil
+}
 
-	// Create the output file
-	file, err := os.Create(filepath)
+func (c *Client) head(url string) (*http.Response, error) {
+	req, err := c.newRequest("HEAD", url)
 	if err != nil {
-		return fmt.Errorf("failed to create file %s: %w", filepath, err)
+		return nil, err
 	}
-	defer file.Close()
-
-	// Copy data from response to file
-	bytesWritten, err := io.Copy(file, resp.Body)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+		return nil, fmt.Errorf("HEAD %s: %w", redactURL(url), err)
 	}
-
-	c.logger.Debug("Downloaded %d bytes to %s", bytesWritten, filepath)
-	return nil
+	resp.Body.Close()
+	return resp, nil
 }
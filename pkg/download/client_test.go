@@ -3,13 +3,18 @@ package download
 import (
 	"crypto/sha256"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/metrics"
 	"github.com/go-installapplications/pkg/utils"
+	"github.com/go-installapplications/pkg/version"
 )
 
 func TestSetFollowRedirects(t *testing.T) {
@@ -42,6 +47,60 @@ func TestSetFollowRedirects(t *testing.T) {
 	}
 }
 
+func TestDownloadSendsVersionUserAgent(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("User-Agent")
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	c := NewClient(utils.NewLogger(false, false))
+	if err := c.DownloadFile(srv.URL, filepath.Join(t.TempDir(), "out.txt"), ""); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if got != version.UserAgent() {
+		t.Fatalf("User-Agent = %q, want %q", got, version.UserAgent())
+	}
+}
+
+func TestHeadSendsHeadRequest(t *testing.T) {
+	var method, header string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, header = r.Method, r.Header.Get("X-Test")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := NewClientWithAuth(utils.NewLogger(false, false), "", "", map[string]string{"X-Test": "1"})
+	code, err := c.Head(srv.URL)
+	if err != nil {
+		t.Fatalf("head: %v", err)
+	}
+	if code != http.StatusNoContent || method != http.MethodHead || header != "1" {
+		t.Fatalf("got code=%d method=%q X-Test=%q", code, method, header)
+	}
+}
+
+func TestContentLength(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", "1234")
+	}))
+	defer srv.Close()
+
+	c := NewClient(utils.NewLogger(false, false))
+	if n, err := c.ContentLength(srv.URL + "/app.pkg"); err != nil || n != 1234 {
+		t.Fatalf("got %d, %v; want 1234", n, err)
+	}
+	if n, err := c.ContentLength(srv.URL + "/missing"); err == nil || n != -1 {
+		t.Fatalf("missing file: got %d, %v", n, err)
+	}
+}
+
 func TestVerifyFileHash(t *testing.T) {
 	tmp := t.TempDir()
 	p := filepath.Join(tmp, "f.bin")
@@ -61,3 +120,196 @@ func TestVerifyFileHash(t *testing.T) {
 		t.Fatalf("expected mismatch error")
 	}
 }
+
+func TestDownloadRecordsMetrics(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if hits == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "hello")
+	}))
+	defer srv.Close()
+
+	rec := metrics.NewRecorder()
+	c := NewClient(utils.NewLogger(false, false))
+	c.SetMetrics(rec)
+	dest := filepath.Join(t.TempDir(), "out.txt")
+	if err := c.DownloadFileWithRetries(srv.URL, dest, "", 2, 1); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+
+	var out strings.Builder
+	if err := rec.WriteText(&out); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for _, want := range []string{
+		`installapplications_downloads_total{result="success"} 1`,
+		`installapplications_download_retries_total 1`,
+		`installapplications_download_bytes_total 5`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestDownloadMultipleRestoresBackupOnFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "replacement")
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "zshrc")
+	if err := os.WriteFile(dest, []byte("original"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(utils.NewLogger(false, false))
+	items := []config.Item{{Name: "zshrc", Type: "userfile", File: dest, URL: srv.URL, Hash: "deadbeef", Backup: true}}
+	results := c.DownloadMultipleWithCleanup(items, 1, true)
+	if results[0].Error == nil {
+		t.Fatal("expected a hash mismatch")
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil || string(data) != "original" {
+		t.Fatalf("original not restored: %q %v", data, err)
+	}
+	if _, err := os.Stat(dest + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("backup left behind: %v", err)
+	}
+}
+
+func TestDownloadMultipleWritesInlineContent(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\nexit 0\n"
+	sum := sha256.Sum256([]byte(script))
+	items := []config.Item{
+		{Name: "plain", Type: "rootscript", File: filepath.Join(dir, "checks", "plain.sh"), Content: script, Hash: fmt.Sprintf("%x", sum)},
+		{Name: "encoded", Type: "rootscript", File: filepath.Join(dir, "encoded.sh"), ContentBase64: "IyEvYmluL3NoCmV4aXQgMAo="},
+		{Name: "tampered", Type: "rootscript", File: filepath.Join(dir, "tampered.sh"), Content: "#!/bin/sh\nexit 1\n", Hash: fmt.Sprintf("%x", sum)},
+	}
+	results := NewClient(utils.NewLogger(false, false)).DownloadMultipleWithCleanup(items, 1, false)
+	for _, r := range results[:2] {
+		if r.Error != nil {
+			t.Fatalf("%s: %v", r.Item.Name, r.Error)
+		}
+		if data, err := os.ReadFile(r.Item.File); err != nil || string(data) != script {
+			t.Errorf("%s: wrote %q, %v", r.Item.Name, data, err)
+		}
+	}
+	if results[2].Error == nil || !strings.Contains(results[2].Error.Error(), "hash") {
+		t.Errorf("tampered: err = %v", results[2].Error)
+	}
+	if _, err := os.Stat(items[2].File); !os.IsNotExist(err) {
+		t.Errorf("tampered content was written: %v", err)
+	}
+}
+
+func TestDownloadStagesInWorkDir(t *testing.T) {
+	tmp := t.TempDir()
+	workDir := filepath.Join(tmp, "install", ".downloads")
+	var mode os.FileMode
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, err := os.Stat(workDir); err == nil {
+			mode = info.Mode().Perm()
+		}
+		fmt.Fprint(w, "payload")
+	}))
+	defer srv.Close()
+
+	c := NewClient(utils.NewLoggerWithWriter(false, false, io.Discard))
+	c.SetRetryDefaults(1, 1)
+	c.SetWorkDir(workDir)
+	dest := filepath.Join(tmp, "pkgs", "app.pkg")
+
+	if err := c.DownloadFile(srv.URL, dest, "0000"); err == nil {
+		t.Fatal("expected a hash mismatch")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("unverified download reached %s: %v", dest, err)
+	}
+	if mode != 0700 {
+		t.Errorf("work dir mode = %v, want 0700", mode)
+	}
+
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte("payload")))
+	results := c.DownloadMultipleWithCleanup([]config.Item{{Name: "app", Type: "package", URL: srv.URL, File: dest, Hash: sum}}, 1, false)
+	if results[0].Error != nil {
+		t.Fatalf("download: %v", results[0].Error)
+	}
+	info, err := os.Stat(dest)
+	if err != nil || info.Mode().Perm() != 0644 {
+		t.Fatalf("dest = %v, %v; want 0644", info, err)
+	}
+	if _, err := os.Stat(workDir); !os.IsNotExist(err) {
+		t.Errorf("work dir left behind: %v", err)
+	}
+}
+
+func TestSweepWorkDirs(t *testing.T) {
+	installPath := t.TempDir()
+	defer func(prev func(int) bool) { processAlive = prev }(processAlive)
+	processAlive = func(pid int) bool { return pid == 100 }
+
+	// pid 100 is still running, 200 is not
+	dirs := map[string]bool{
+		".downloads-100":     true,
+		".downloads-200":     false,
+		".downloads-notapid": true,
+		"logs":               true,
+		fmt.Sprintf(".downloads-%d", os.Getpid()): true,
+	}
+	for name := range dirs {
+		if err := os.MkdirAll(filepath.Join(installPath, name, "partial"), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	SweepWorkDirs(installPath, utils.NewLoggerWithWriter(false, false, io.Discard))
+	for name, kept := range dirs {
+		if _, err := os.Stat(filepath.Join(installPath, name)); (err == nil) != kept {
+			t.Errorf("%s: kept = %v, want %v", name, err == nil, kept)
+		}
+	}
+}
+
+func TestFailedDownloadKeepsExistingFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "new")
+	}))
+	defer srv.Close()
+
+	tmp := t.TempDir()
+	staged, inPlace := filepath.Join(tmp, "staged.pkg"), filepath.Join(tmp, "inplace.pkg")
+	for _, p := range []string{staged, inPlace} {
+		if err := os.WriteFile(p, []byte("original"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A staged download that fails its hash check never reaches the file
+	c := NewClient(utils.NewLoggerWithWriter(false, false, io.Discard))
+	c.SetWorkDir(filepath.Join(tmp, ".downloads"))
+	items := []config.Item{{Name: "staged", Type: "package", File: staged, URL: srv.URL + "/pkg", Hash: strings.Repeat("0", 64), Retries: 1, RetryWait: 1}}
+	if results := c.DownloadMultipleWithCleanup(items, 1, true); results[0].Error == nil {
+		t.Fatal("staged download should fail")
+	}
+
+	// Nor does an in-place download the server refuses
+	c = NewClient(utils.NewLoggerWithWriter(false, false, io.Discard))
+	items = []config.Item{{Name: "inplace", Type: "package", File: inPlace, URL: srv.URL + "/missing", Retries: 1, RetryWait: 1}}
+	if results := c.DownloadMultipleWithCleanup(items, 1, true); results[0].Error == nil {
+		t.Fatal("in-place download should fail")
+	}
+
+	for _, p := range []string{staged, inPlace} {
+		if data, err := os.ReadFile(p); err != nil || string(data) != "original" {
+			t.Errorf("%s: %q, %v after a failed download", p, data, err)
+		}
+	}
+}
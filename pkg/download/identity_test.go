@@ -0,0 +1,134 @@
+package download
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/identity"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+func testIdentity(t *testing.T) *identity.Identity {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "0000-ABCD"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity.New(cert, key)
+}
+
+func TestIdentitySignatureHeaders(t *testing.T) {
+	id := testIdentity(t)
+	var verifyErr error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cert *x509.Certificate
+		cert, verifyErr = identity.VerifyRequest(r, time.Now(), time.Minute)
+		if verifyErr == nil && cert.Subject.CommonName != "0000-ABCD" {
+			verifyErr = fmt.Errorf("signed by %s", cert.Subject.CommonName)
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	c := NewClient(utils.NewLogger(false, false))
+	c.SetIdentity(id, config.IdentityAuthSignature)
+	if err := c.DownloadFile(srv.URL+"/bootstrap.json?group=a", filepath.Join(t.TempDir(), "out.json"), ""); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if verifyErr != nil {
+		t.Fatalf("server could not verify the request: %v", verifyErr)
+	}
+}
+
+func TestIdentityMTLS(t *testing.T) {
+	id := testIdentity(t)
+	var peer string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			peer = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	c := NewClient(utils.NewLogger(false, false))
+	c.SetIdentity(id, config.IdentityAuthMTLS)
+	// trust the test server's certificate
+	c.httpClient.Transport.(*http.Transport).TLSClientConfig.RootCAs =
+		srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	if err := c.DownloadFile(srv.URL, filepath.Join(t.TempDir(), "out.txt"), ""); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if peer != "0000-ABCD" {
+		t.Fatalf("client certificate = %q, want 0000-ABCD", peer)
+	}
+}
+
+// A redirect on the same host is signed for its own URL; one to another
+// host gets no signature it could replay.
+func TestIdentitySignatureRedirects(t *testing.T) {
+	var leaked bool
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get(identity.HeaderSignature) != "" || r.Header.Get(identity.HeaderCertificate) != ""
+		fmt.Fprint(w, "ok")
+	}))
+	defer other.Close()
+	var verifyErr error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/away":
+			http.Redirect(w, r, other.URL+"/final", http.StatusFound)
+		default:
+			_, verifyErr = identity.VerifyRequest(r, time.Now(), time.Minute)
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(utils.NewLogger(false, false))
+	c.SetFollowRedirects(true)
+	c.SetIdentity(testIdentity(t), config.IdentityAuthSignature)
+	if err := c.DownloadFile(srv.URL+"/moved", filepath.Join(t.TempDir(), "out.json"), ""); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if verifyErr != nil {
+		t.Errorf("same-host redirect not signed for its URL: %v", verifyErr)
+	}
+	if err := c.DownloadFile(srv.URL+"/away", filepath.Join(t.TempDir(), "out.json"), ""); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if leaked {
+		t.Error("device signature sent to another host")
+	}
+}
@@ -1,10 +1,17 @@
 package download
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
 )
 
 // DownloadResult represents the result of a download operation
@@ -24,27 +31,62 @@ func (c *Client) DownloadMultipleWithCleanup(items []config.Item, maxConcurrency
 	semaphore := make(chan struct{}, maxConcurrency)
 
 	// Create cleanup tracker
-	cleanup := NewCleanupTracker()
+	cleanup := NewCleanupTracker(c.logger)
 
 	for i, item := range items {
 		wg.Add(1)
 
 		go func(index int, item config.Item) {
 			defer wg.Done()
+			defer utils.Recover(c.logger, "download of "+item.Name)
 
 			// Acquire semaphore
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
+			// Queued downloads don't start once the run is stopping
+			if err := c.ctx.Err(); err != nil {
+				results[index] = DownloadResult{Item: item, Error: fmt.Errorf("download of %s not started: %w", item.Name, context.Cause(c.ctx))}
+				return
+			}
+
 			c.logger.Debug("Starting download: %s", item.Name)
 
-			if item.URL != "" {
-				// Track file for potential cleanup
-				cleanup.TrackFile(item.File)
+			if item.Delivered() {
+				// Directories created for a rootfile or userfile get its dir_mode
+				if item.DirMode != "" {
+					if err := ensureItemDir(item); err != nil {
+						results[index] = DownloadResult{Item: item, Error: err}
+						return
+					}
+				}
+
+				// Keep the file the download or inline content replaces
+				if item.Backup {
+					backedUp, err := utils.BackupFile(item.File, c.backupDir)
+					if err != nil {
+						results[index] = DownloadResult{Item: item, Error: err}
+						return
+					}
+					if backedUp {
+						cleanup.TrackBackup(item.File, c.backupDir)
+						c.logger.Info("Backed up %s to %s", item.File, utils.BackupPath(item.File, c.backupDir))
+					}
+				}
 
-				// Use item-specific retry settings
-				c.logger.Verbose("Item retry settings - Retries: %d, RetryWait: %ds", item.Retries, item.RetryWait)
-				err := c.DownloadFileWithRetries(item.URL, item.File, item.Hash, item.Retries, item.RetryWait)
+				var err error
+				if item.URL != "" {
+					// Use item-specific retry settings
+					c.logger.Verbose("Item retry settings - Retries: %d, RetryWait: %ds", item.Retries, item.RetryWait)
+					span := c.tracer.Item(item.Name).StartChild("download")
+					span.SetAttr("http.url", redactURL(item.URL))
+					// Track the file for cleanup only once the download has
+					// replaced it; a failure before that leaves it alone
+					err = c.download(item.URL, item.File, item.Hash, item.Retries, item.RetryWait, func() { cleanup.TrackFile(item.File) })
+					span.End(err)
+				} else {
+					err = c.writeInline(item)
+				}
 				if err != nil {
 					results[index] = DownloadResult{Item: item, Error: err}
 				} else {
@@ -58,6 +100,7 @@ func (c *Client) DownloadMultipleWithCleanup(items []config.Item, maxConcurrency
 	}
 
 	wg.Wait()
+	c.removeWorkDir()
 
 	// Check if any downloads failed
 	var failedCount int
@@ -77,3 +120,38 @@ func (c *Client) DownloadMultipleWithCleanup(items []config.Item, maxConcurrency
 
 	return results
 }
+
+// ensureItemDir creates the directories above an item's file with its
+// dir_mode.
+func ensureItemDir(item config.Item) error {
+	mode, err := config.ParseFileMode(item.DirMode)
+	if err != nil {
+		return fmt.Errorf("invalid dir_mode for %s: %w", item.Name, err)
+	}
+	return utils.EnsureDirForFileMode(item.File, mode)
+}
+
+// writeInline writes the content an item carries inline to its file, in
+// place of a download. The bootstrap itself vouches for the content, so a
+// hash is only checked when the item has one.
+func (c *Client) writeInline(item config.Item) error {
+	data, err := item.InlineContent()
+	if err != nil {
+		return err
+	}
+	if item.Hash != "" {
+		sum := sha256.Sum256(data)
+		if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, item.Hash) {
+			return fmt.Errorf("inline content of %s does not match its hash: expected %s, got %s", item.Name, item.Hash, got)
+		}
+	}
+	// dir_mode has already been applied to the directory
+	if err := os.MkdirAll(filepath.Dir(item.File), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", item.Name, err)
+	}
+	if err := utils.WriteFileAtomic(item.File, data, 0644); err != nil {
+		return fmt.Errorf("failed to write inline content of %s: %w", item.Name, err)
+	}
+	c.logger.Debug("Wrote inline content of %s to %s (%d bytes)", item.Name, item.File, len(data))
+	return nil
+}
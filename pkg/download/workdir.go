@@ -0,0 +1,149 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// workDirPrefix starts the name of every working directory; the pid of the
+// run that made it follows.
+const workDirPrefix = ".downloads-"
+
+// WorkDir is the per-run working directory under installPath that
+// downloads are written to before they are verified (see SetWorkDir).
+func WorkDir(installPath string) string {
+	return filepath.Join(installPath, fmt.Sprintf("%s%d", workDirPrefix, os.Getpid()))
+}
+
+// processAlive reports whether a process with pid exists; a variable so
+// tests can replace it.
+var processAlive = func(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// SweepWorkDirs removes the working directories under installPath left by
+// runs that were killed or crashed, those whose pid is no longer running.
+func SweepWorkDirs(installPath string, logger utils.Logger) {
+	entries, err := os.ReadDir(installPath)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(strings.TrimPrefix(e.Name(), workDirPrefix))
+		if !strings.HasPrefix(e.Name(), workDirPrefix) || err != nil || !e.IsDir() {
+			continue
+		}
+		if pid == os.Getpid() || processAlive(pid) {
+			continue
+		}
+		dir := filepath.Join(installPath, e.Name())
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Info("⚠️  Could not remove stale download working directory %s: %v", dir, err)
+			continue
+		}
+		logger.Debug("Removed stale download working directory %s", dir)
+	}
+}
+
+// SetWorkDir has downloads written to a private file in dir, created 0700
+// for this process alone, and moved to their destination only after the
+// hash check, so nothing else can change a file between its download and
+// its verification. Ownership and permissions for the item are granted
+// after the move. An empty dir downloads in place.
+func (c *Client) SetWorkDir(dir string) {
+	c.workDir = dir
+}
+
+// ensureWorkDir creates the working directory, or checks that an existing
+// one is a directory only this process's user can reach.
+func (c *Client) ensureWorkDir() error {
+	c.workMu.Lock()
+	defer c.workMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(c.workDir), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(c.workDir), err)
+	}
+	if err := os.Mkdir(c.workDir, 0700); err == nil || !os.IsExist(err) {
+		return err
+	}
+	info, err := os.Lstat(c.workDir)
+	if err != nil {
+		return err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(st.Uid) != os.Geteuid() {
+		return fmt.Errorf("download working directory %s is not a directory owned by uid %d", c.workDir, os.Geteuid())
+	}
+	return os.Chmod(c.workDir, 0700)
+}
+
+// removeWorkDir removes the working directory once it is empty.
+func (c *Client) removeWorkDir() {
+	if c.workDir == "" {
+		return
+	}
+	c.workMu.Lock()
+	defer c.workMu.Unlock()
+	if err := os.Remove(c.workDir); err != nil && !os.IsNotExist(err) {
+		c.logger.Debug("Leaving download working directory %s: %v", c.workDir, err)
+	}
+}
+
+// stagingFile creates the private file a download to dest is written to.
+func (c *Client) stagingFile(dest string) (string, error) {
+	if err := c.ensureWorkDir(); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(c.workDir, filepath.Base(dest)+"-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging file for %s: %w", dest, err)
+	}
+	f.Close()
+	return f.Name(), nil
+}
+
+// placeStaged moves a verified staging file to dest, readable by all as a
+// direct download would be. Across volumes the file is copied to a temp
+// file beside dest and renamed, so dest is never seen half written.
+func placeStaged(staged, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", dest, err)
+	}
+	if err := os.Chmod(staged, 0644); err != nil {
+		return fmt.Errorf("failed to set permissions on %s: %w", staged, err)
+	}
+	err := os.Rename(staged, dest)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	src, err := os.Open(staged)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file for %s: %w", dest, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to copy %s: %w", dest, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
@@ -0,0 +1,265 @@
+// Package identity authenticates HTTP requests with a device identity from
+// the System keychain, such as the one MDM enrollment installs, so a server
+// can hand out per-device manifests without a shared secret in the profile.
+// The private key never leaves the keychain: Security.framework signs with
+// it, for a TLS client certificate or a request signature header.
+package identity
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// SystemKeychain holds the identities installed by MDM enrollment.
+const SystemKeychain = "/Library/Keychains/System.keychain"
+
+// Request signature headers, see SignRequest.
+const (
+	HeaderCertificate = "X-Device-Certificate"
+	HeaderDate        = "X-Device-Signature-Date"
+	HeaderSignature   = "X-Device-Signature"
+)
+
+// Identity is a certificate of the System keychain and its private key. It
+// is a crypto.Signer.
+type Identity struct {
+	Certificate *x509.Certificate
+	key         crypto.Signer // nil: the key is in the keychain
+}
+
+// New returns an identity whose key is outside the keychain, such as one
+// loaded from a file for a test server.
+func New(cert *x509.Certificate, key crypto.Signer) *Identity {
+	return &Identity{Certificate: cert, key: key}
+}
+
+// Find returns the identity whose certificate has commonName as its subject
+// common name; of several, the one that expires last, as a renewed MDM
+// identity sits next to the old one until it is removed.
+func Find(commonName string) (*Identity, error) {
+	out, err := findCertificates(commonName)
+	if err != nil {
+		return nil, fmt.Errorf("no certificate %q in the System keychain: %w", commonName, err)
+	}
+	var best *x509.Certificate
+	for block, rest := pem.Decode(out); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil || cert.Subject.CommonName != commonName {
+			continue // security -c matches substrings
+		}
+		if best == nil || cert.NotAfter.After(best.NotAfter) {
+			best = cert
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no certificate %q in the System keychain", commonName)
+	}
+	return &Identity{Certificate: best}, nil
+}
+
+// findCertificates returns the PEM certificates of the System keychain
+// whose name contains name; a variable so tests can replace it.
+var findCertificates = func(name string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "find-certificate", "-a", "-c", name, "-p", SystemKeychain)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// Public returns the certificate's public key.
+func (i *Identity) Public() crypto.PublicKey {
+	return i.Certificate.PublicKey
+}
+
+// Sign signs digest with the identity's private key in the keychain, or
+// with the key given to New.
+func (i *Identity) Sign(random io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if i.key != nil {
+		return i.key.Sign(random, digest, opts)
+	}
+	algorithm, err := keyAlgorithm(i.Certificate.PublicKey, opts)
+	if err != nil {
+		return nil, err
+	}
+	return keychainSign(i.Certificate.Subject.CommonName, algorithm, digest)
+}
+
+// keyAlgorithm is the Security.framework SecKeyAlgorithm constant for a
+// digest signature with pub's key type and opts.
+func keyAlgorithm(pub crypto.PublicKey, opts crypto.SignerOpts) (string, error) {
+	hashes := map[crypto.Hash]string{
+		crypto.SHA1: "SHA1", crypto.SHA256: "SHA256", crypto.SHA384: "SHA384", crypto.SHA512: "SHA512",
+	}
+	hash, ok := hashes[opts.HashFunc()]
+	if !ok {
+		return "", fmt.Errorf("unsupported signature hash %v", opts.HashFunc())
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return "kSecKeyAlgorithmECDSASignatureDigestX962" + hash, nil
+	case *rsa.PublicKey:
+		if _, pss := opts.(*rsa.PSSOptions); pss {
+			return "kSecKeyAlgorithmRSASignatureDigestPSS" + hash, nil
+		}
+		return "kSecKeyAlgorithmRSASignatureDigestPKCS1v15" + hash, nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", pub)
+}
+
+// keychainSignScript signs the base64 digest given after the identity's
+// common name and the SecKeyAlgorithm constant with the identity from the
+// keychain given last, and prints the signature in base64. Only that
+// keychain is searched, so an identity of the same name in a user's
+// keychain is never used.
+const keychainSignScript = `ObjC.import('Foundation');
+ObjC.import('Security');
+function run(argv) {
+	var keychain = Ref();
+	var status = $.SecKeychainOpen(argv[3], keychain);
+	if (status != 0) {
+		throw new Error('cannot open ' + argv[3] + ': OSStatus ' + status);
+	}
+	var query = $.NSMutableDictionary.dictionary;
+	query.setObjectForKey($.NSArray.arrayWithObject(keychain[0]), $.kSecMatchSearchList);
+	query.setObjectForKey($.kSecClassIdentity, $.kSecClass);
+	query.setObjectForKey($(argv[0]), $.kSecMatchSubjectWholeString);
+	query.setObjectForKey($.kSecMatchLimitOne, $.kSecMatchLimit);
+	query.setObjectForKey(true, $.kSecReturnRef);
+	var identity = Ref();
+	status = $.SecItemCopyMatching(query, identity);
+	if (status != 0) {
+		throw new Error('no identity ' + argv[0] + ': OSStatus ' + status);
+	}
+	var key = Ref();
+	status = $.SecIdentityCopyPrivateKey(identity[0], key);
+	if (status != 0) {
+		throw new Error('no private key for ' + argv[0] + ': OSStatus ' + status);
+	}
+	var digest = $.NSData.alloc.initWithBase64EncodedStringOptions($(argv[2]), 0);
+	var error = Ref();
+	var signature = $.SecKeyCreateSignature(key[0], $[argv[1]], digest, error);
+	if (!signature || signature.isNil()) {
+		throw new Error('cannot sign with ' + argv[0]);
+	}
+	return ObjC.castRefToObject(signature).base64EncodedStringWithOptions(0).js;
+}`
+
+// keychainSign signs digest with the private key of the identity named
+// commonName in the System keychain; a variable so tests can replace it.
+var keychainSign = func(commonName, algorithm string, digest []byte) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("osascript", keychainSignArgs(commonName, algorithm, digest)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("keychain signature: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+}
+
+// keychainSignArgs are the osascript arguments that run keychainSignScript
+// against the System keychain.
+func keychainSignArgs(commonName, algorithm string, digest []byte) []string {
+	return []string{"-l", "JavaScript", "-e", keychainSignScript,
+		commonName, algorithm, base64.StdEncoding.EncodeToString(digest), SystemKeychain}
+}
+
+// TLSCertificate presents the identity as a TLS client certificate.
+func (i *Identity) TLSCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{i.Certificate.Raw},
+		PrivateKey:  i,
+		Leaf:        i.Certificate,
+	}
+}
+
+// signedString is what a request signature covers: the method, the full
+// URL and the signing time, one per line.
+func signedString(method, url, date string) []byte {
+	return []byte(method + "\n" + url + "\n" + date)
+}
+
+// SignRequest adds the certificate (base64 DER), the signing time (RFC 3339)
+// and a signature over both and the request's method and URL (base64;
+// PKCS #1 v1.5 for RSA keys, ASN.1 ECDSA otherwise, with SHA-256).
+func (i *Identity) SignRequest(req *http.Request, now time.Time) error {
+	date := now.UTC().Format(time.RFC3339)
+	digest := sha256.Sum256(signedString(req.Method, req.URL.String(), date))
+	signature, err := i.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HeaderCertificate, base64.StdEncoding.EncodeToString(i.Certificate.Raw))
+	req.Header.Set(HeaderDate, date)
+	req.Header.Set(HeaderSignature, base64.StdEncoding.EncodeToString(signature))
+	return nil
+}
+
+// VerifyRequest checks the signature SignRequest added, for servers written
+// in Go, and returns the certificate that made it. Signatures older or
+// newer than maxSkew are rejected. Checking that the certificate was issued
+// by the MDM's CA and names a known device is up to the caller.
+func VerifyRequest(req *http.Request, now time.Time, maxSkew time.Duration) (*x509.Certificate, error) {
+	der, err := base64.StdEncoding.DecodeString(req.Header.Get(HeaderCertificate))
+	if err != nil || len(der) == 0 {
+		return nil, errors.New("missing or invalid " + HeaderCertificate)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", HeaderCertificate, err)
+	}
+	date := req.Header.Get(HeaderDate)
+	signed, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return nil, errors.New("missing or invalid " + HeaderDate)
+	}
+	if skew := now.Sub(signed); skew > maxSkew || skew < -maxSkew {
+		return nil, fmt.Errorf("signature date %s is too far from now", date)
+	}
+	signature, err := base64.StdEncoding.DecodeString(req.Header.Get(HeaderSignature))
+	if err != nil || len(signature) == 0 {
+		return nil, errors.New("missing or invalid " + HeaderSignature)
+	}
+	url := req.URL.String()
+	if req.Host != "" && !req.URL.IsAbs() {
+		// a server sees the request URI; rebuild the URL the client signed
+		scheme := "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+		url = scheme + "://" + req.Host + req.URL.RequestURI()
+	}
+	digest := sha256.Sum256(signedString(req.Method, url, date))
+	switch pub := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest[:], signature) {
+			return nil, errors.New("signature does not match")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, errors.New("signature does not match")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", cert.PublicKey)
+	}
+	return cert, nil
+}
@@ -0,0 +1,134 @@
+package identity
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCert(t *testing.T, cn string, notAfter time.Time) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestFind(t *testing.T) {
+	old, _ := newCert(t, "0000-ABCD", time.Now().Add(24*time.Hour))
+	renewed, _ := newCert(t, "0000-ABCD", time.Now().Add(365*24*time.Hour))
+	other, _ := newCert(t, "0000-ABCD-other", time.Now().Add(2*365*24*time.Hour))
+	orig := findCertificates
+	findCertificates = func(name string) ([]byte, error) {
+		var out []byte
+		for _, c := range []*x509.Certificate{old, other, renewed} {
+			out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+		}
+		return out, nil
+	}
+	t.Cleanup(func() { findCertificates = orig })
+
+	id, err := Find("0000-ABCD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.Certificate.Equal(renewed) {
+		t.Errorf("Find picked %s expiring %s, want the renewed certificate", id.Certificate.Subject.CommonName, id.Certificate.NotAfter)
+	}
+	if _, err := Find("0000"); err == nil {
+		t.Errorf("a partial common name should not match")
+	}
+}
+
+func TestKeyAlgorithm(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for _, c := range []struct {
+		pub  crypto.PublicKey
+		opts crypto.SignerOpts
+		want string
+	}{
+		{&ecKey.PublicKey, crypto.SHA256, "kSecKeyAlgorithmECDSASignatureDigestX962SHA256"},
+		{&rsaKey.PublicKey, crypto.SHA384, "kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA384"},
+		{&rsaKey.PublicKey, &rsa.PSSOptions{Hash: crypto.SHA256}, "kSecKeyAlgorithmRSASignatureDigestPSSSHA256"},
+	} {
+		if got, err := keyAlgorithm(c.pub, c.opts); err != nil || got != c.want {
+			t.Errorf("keyAlgorithm(%T, %v) = %q, %v, want %q", c.pub, c.opts.HashFunc(), got, err, c.want)
+		}
+	}
+	if _, err := keyAlgorithm(&ecKey.PublicKey, crypto.MD5SHA1); err == nil {
+		t.Errorf("MD5SHA1 should be unsupported")
+	}
+}
+
+// The signing key is only looked up in the System keychain, never in a
+// user's keychain that happens to hold an identity of the same name.
+func TestKeychainSignArgsSearchSystemKeychain(t *testing.T) {
+	args := keychainSignArgs("0000-ABCD", "kSecKeyAlgorithmECDSASignatureDigestX962SHA256", []byte{1})
+	if last := args[len(args)-1]; last != SystemKeychain {
+		t.Errorf("last argument = %q, want %s", last, SystemKeychain)
+	}
+	if !strings.Contains(keychainSignScript, "kSecMatchSearchList") {
+		t.Errorf("keychainSignScript does not limit the search to its keychain")
+	}
+}
+
+func TestSignAndVerifyRequest(t *testing.T) {
+	cert, key := newCert(t, "0000-ABCD", time.Now().Add(time.Hour))
+	// the keychain path, with the key standing in for Security.framework
+	orig := keychainSign
+	keychainSign = func(commonName, algorithm string, digest []byte) ([]byte, error) {
+		if commonName != "0000-ABCD" || algorithm != "kSecKeyAlgorithmECDSASignatureDigestX962SHA256" {
+			t.Errorf("keychainSign(%s, %s)", commonName, algorithm)
+		}
+		return key.Sign(rand.Reader, digest, crypto.SHA256)
+	}
+	t.Cleanup(func() { keychainSign = orig })
+
+	now := time.Now()
+	req, _ := http.NewRequest("GET", "https://server.example/bootstrap.json?group=a", nil)
+	if err := (&Identity{Certificate: cert}).SignRequest(req, now); err != nil {
+		t.Fatal(err)
+	}
+	got, err := VerifyRequest(req, now.Add(time.Minute), 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(cert) {
+		t.Errorf("VerifyRequest returned another certificate")
+	}
+
+	if _, err := VerifyRequest(req, now.Add(time.Hour), 5*time.Minute); err == nil || !strings.Contains(err.Error(), "too far") {
+		t.Errorf("stale signature: %v", err)
+	}
+	req.URL.RawQuery = "group=b"
+	if _, err := VerifyRequest(req, now, 5*time.Minute); err == nil {
+		t.Errorf("a signature for another URL should not verify")
+	}
+}
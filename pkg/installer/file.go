@@ -3,36 +3,102 @@ package installer
 import (
 	"fmt"
 	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/go-installapplications/pkg/audit"
+	"github.com/go-installapplications/pkg/config"
 	"github.com/go-installapplications/pkg/utils"
 )
 
+// Default permissions of placed files
+const (
+	RootFileMode os.FileMode = 0644
+	UserFileMode os.FileMode = 0755
+)
+
+// FileOptions are the ownership and permissions a file is placed with
+type FileOptions struct {
+	// Owner is "user", "user:group" or ":group"; empty keeps the owner
+	Owner string
+	// Mode replaces the default mode of the file type when non-zero
+	Mode os.FileMode
+	// StripQuarantine removes com.apple.quarantine; XAttrs are extended
+	// attributes to set, name to value
+	StripQuarantine bool
+	XAttrs          map[string]string
+	// VerifySignature checks the code signature of the file before its
+	// permissions are set; a non-empty TeamID or SigningID must also match,
+	// see VerifyCodeSignature
+	VerifySignature bool
+	TeamID          string
+	SigningID       string
+}
+
+// FileOptionsFor builds the placement options of a rootfile or userfile
+// item. The mode was checked when the bootstrap was validated.
+func FileOptionsFor(item config.Item) FileOptions {
+	mode, _ := config.ParseFileMode(item.Mode)
+	return FileOptions{
+		Owner:           item.Owner,
+		Mode:            mode,
+		StripQuarantine: item.StripQuarantine,
+		XAttrs:          item.XAttrs,
+		VerifySignature: item.VerifySignature || item.TeamID != "" || item.SigningID != "",
+		TeamID:          item.TeamID,
+		SigningID:       item.SigningID,
+	}
+}
+
 // FilePlacer handles file placement with appropriate permissions
 type FilePlacer struct {
 	dryRun      bool
-	logger      *utils.Logger
+	logger      utils.Logger
 	isAgentMode bool
+	// run executes codesign; swapped out in tests
+	run func(args []string) (string, error)
 }
 
 // NewFilePlacer creates a new file placer
-func NewFilePlacer(dryRun bool, logger *utils.Logger, isAgentMode bool) *FilePlacer {
+func NewFilePlacer(dryRun bool, logger utils.Logger, isAgentMode bool) *FilePlacer {
 	return &FilePlacer{
 		dryRun:      dryRun,
 		logger:      logger,
 		isAgentMode: isAgentMode,
+		run:         utils.RunCommandCapture,
 	}
 }
 
-// PlaceFile handles placing files with appropriate permissions
-func (fp *FilePlacer) PlaceFile(filePath, fileType string) error {
+// PlaceFile sets the ownership and permissions of a placed file: opts.Mode,
+// or RootFileMode/UserFileMode by type, and opts.Owner if set
+func (fp *FilePlacer) PlaceFile(filePath, fileType string, opts FileOptions) (err error) {
 	fp.logger.Info("Placing %s file: %s", fileType, filePath)
 	fp.logger.Debug("File placer dry-run mode: %t", fp.dryRun)
 
 	if fp.dryRun {
+		if opts.VerifySignature {
+			fp.logger.Info("[DRY RUN] Would verify the code signature of %s", filePath)
+		}
 		fp.logger.Info("[DRY RUN] Would place file: %s (%s)", filePath, fileType)
+		audit.Record(audit.Event{Action: audit.ActionFilePlace, Target: filePath, Outcome: audit.OutcomeDryRun,
+			Details: map[string]string{"type": fileType}})
 		return nil
 	}
 
+	details := map[string]string{"type": fileType}
+	defer func() {
+		audit.Record(audit.Event{
+			Action:  audit.ActionFilePlace,
+			Target:  filePath,
+			SHA256:  audit.FileSHA256(filePath),
+			Outcome: audit.Outcome(err),
+			Error:   audit.ErrorString(err),
+			Details: details,
+		})
+	}()
+
 	// Log execution context
 	if fileType == "rootfile" && fp.isAgentMode {
 		fp.logger.Debug("Placing rootfile in agent mode - relies on proper authorization")
@@ -50,24 +116,120 @@ func (fp *FilePlacer) PlaceFile(filePath, fileType string) error {
 
 	fp.logger.Debug("File exists, setting permissions based on type: %s", fileType)
 
-	// Set appropriate permissions based on file type
+	mode := opts.Mode
 	switch fileType {
 	case "rootfile":
-		// Root-only readable
-		if err := os.Chmod(filePath, 0644); err != nil {
-			return fmt.Errorf("failed to set permissions on root file: %w", err)
+		if mode == 0 {
+			mode = RootFileMode
 		}
-		fp.logger.Verbose("Set permissions to 0644 for root file: %s", filePath)
 	case "userfile":
-		// User-readable
-		if err := os.Chmod(filePath, 0755); err != nil {
-			return fmt.Errorf("failed to set permissions on user file: %w", err)
+		if mode == 0 {
+			mode = UserFileMode
 		}
-		fp.logger.Verbose("Set permissions to 0755 for user file: %s", filePath)
 	default:
 		return fmt.Errorf("unknown file type: %s", fileType)
 	}
 
+	// A substituted or corrupted binary is caught before it is made
+	// executable
+	if opts.VerifySignature {
+		if err := fp.VerifyCodeSignature(filePath, opts); err != nil {
+			return err
+		}
+		details["signature"] = "verified"
+		if opts.TeamID != "" {
+			details["team_id"] = opts.TeamID
+		}
+		if opts.SigningID != "" {
+			details["signing_id"] = opts.SigningID
+		}
+	}
+
+	// chown clears the setuid and setgid bits, so it goes first
+	if opts.Owner != "" {
+		uid, gid, err := lookupOwner(opts.Owner)
+		if err != nil {
+			return err
+		}
+		if err := os.Chown(filePath, uid, gid); err != nil {
+			return fmt.Errorf("failed to set owner of %s file: %w", fileType, err)
+		}
+		details["owner"] = opts.Owner
+		fp.logger.Verbose("Set owner to %s for %s: %s", opts.Owner, fileType, filePath)
+	}
+	if err := os.Chmod(filePath, mode); err != nil {
+		return fmt.Errorf("failed to set permissions on %s: %w", fileType, err)
+	}
+	details["mode"] = fmt.Sprintf("%#o", modeBits(mode))
+	fp.logger.Verbose("Set permissions to %#o for %s: %s", modeBits(mode), fileType, filePath)
+
+	if opts.StripQuarantine || len(opts.XAttrs) > 0 {
+		if err := utils.SetXAttrs(filePath, opts.StripQuarantine, opts.XAttrs); err != nil {
+			return err
+		}
+		if opts.StripQuarantine {
+			details["quarantine"] = "removed"
+		}
+		if len(opts.XAttrs) > 0 {
+			details["xattrs"] = xattrNames(opts.XAttrs)
+		}
+		fp.logger.Verbose("Set extended attributes for %s: %s", fileType, filePath)
+	}
+
 	fp.logger.Info("File placed successfully: %s", filePath)
 	return nil
 }
+
+// lookupOwner resolves "user", "user:group" or ":group" to the IDs for
+// os.Chown, with -1 for the part that is not changed. Names may be numeric
+// IDs.
+func lookupOwner(owner string) (uid, gid int, err error) {
+	name, group, _ := strings.Cut(owner, ":")
+	uid, gid = -1, -1
+	if name != "" {
+		if uid, err = strconv.Atoi(name); err != nil {
+			u, lerr := user.Lookup(name)
+			if lerr != nil {
+				return 0, 0, fmt.Errorf("unknown owner %q: %w", name, lerr)
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			g, lerr := user.LookupGroup(group)
+			if lerr != nil {
+				return 0, 0, fmt.Errorf("unknown group %q: %w", group, lerr)
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid, nil
+}
+
+// xattrNames lists the names of attrs, sorted and comma-separated, for the
+// audit log.
+func xattrNames(attrs map[string]string) string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// modeBits returns mode as the octal number chmod takes, special bits
+// included.
+func modeBits(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
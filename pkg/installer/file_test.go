@@ -1,6 +1,7 @@
 package installer
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -25,7 +26,7 @@ func TestFilePlacer_RootFilePermissions(t *testing.T) {
 	fp := NewFilePlacer(false, logger, false)
 
 	path := writeTempFile(t, "root.txt", 0600)
-	if err := fp.PlaceFile(path, "rootfile"); err != nil {
+	if err := fp.PlaceFile(path, "rootfile", FileOptions{}); err != nil {
 		t.Fatalf("place: %v", err)
 	}
 	info, err := os.Stat(path)
@@ -42,7 +43,7 @@ func TestFilePlacer_UserFilePermissions(t *testing.T) {
 	fp := NewFilePlacer(false, logger, true)
 
 	path := writeTempFile(t, "user.sh", 0600)
-	if err := fp.PlaceFile(path, "userfile"); err != nil {
+	if err := fp.PlaceFile(path, "userfile", FileOptions{}); err != nil {
 		t.Fatalf("place: %v", err)
 	}
 	info, err := os.Stat(path)
@@ -57,7 +58,7 @@ func TestFilePlacer_UserFilePermissions(t *testing.T) {
 func TestFilePlacer_MissingFile(t *testing.T) {
 	logger := utils.NewLogger(false, false)
 	fp := NewFilePlacer(false, logger, false)
-	err := fp.PlaceFile("/this/path/does/not/exist", "rootfile")
+	err := fp.PlaceFile("/this/path/does/not/exist", "rootfile", FileOptions{})
 	if err == nil {
 		t.Fatalf("expected error for missing file")
 	}
@@ -67,7 +68,7 @@ func TestFilePlacer_UnknownType(t *testing.T) {
 	logger := utils.NewLogger(false, false)
 	fp := NewFilePlacer(false, logger, false)
 	path := writeTempFile(t, "x", 0644)
-	if err := fp.PlaceFile(path, "bogus"); err == nil {
+	if err := fp.PlaceFile(path, "bogus", FileOptions{}); err == nil {
 		t.Fatalf("expected error for unknown file type")
 	}
 }
@@ -77,7 +78,7 @@ func TestFilePlacer_DryRunDoesNothing(t *testing.T) {
 	fp := NewFilePlacer(true, logger, false)
 
 	path := writeTempFile(t, "dr.txt", 0600)
-	if err := fp.PlaceFile(path, "rootfile"); err != nil {
+	if err := fp.PlaceFile(path, "rootfile", FileOptions{}); err != nil {
 		t.Fatalf("dry-run: %v", err)
 	}
 	info, err := os.Stat(path)
@@ -89,3 +90,40 @@ func TestFilePlacer_DryRunDoesNothing(t *testing.T) {
 		t.Fatalf("dry-run should not change perms; got %v", info.Mode().Perm())
 	}
 }
+
+func TestFilePlacer_OwnerAndMode(t *testing.T) {
+	logger := utils.NewLogger(false, false)
+	fp := NewFilePlacer(false, logger, false)
+
+	path := writeTempFile(t, "sudoers", 0644)
+	// Chowning to our own IDs works without root
+	owner := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	if err := fp.PlaceFile(path, "rootfile", FileOptions{Owner: owner, Mode: 0440}); err != nil {
+		t.Fatalf("place: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Mode().Perm() != 0440 {
+		t.Fatalf("mode = %v, want 0440", info.Mode().Perm())
+	}
+
+	if err := fp.PlaceFile(path, "rootfile", FileOptions{Owner: "no-such-user-xyz"}); err == nil {
+		t.Fatalf("expected error for unknown owner")
+	}
+}
+
+func TestLookupOwner(t *testing.T) {
+	for owner, want := range map[string][2]int{
+		"501":    {501, -1},
+		"501:20": {501, 20},
+		":20":    {-1, 20},
+		"root:0": {0, 0},
+	} {
+		uid, gid, err := lookupOwner(owner)
+		if err != nil || uid != want[0] || gid != want[1] {
+			t.Errorf("%s: got %d:%d %v, want %d:%d", owner, uid, gid, err, want[0], want[1])
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package installer
 
 import (
+	"io"
 	"time"
 
 	"github.com/go-installapplications/pkg/utils"
@@ -8,10 +9,15 @@ import (
 
 // Installer defines what an installer should be able to do
 type Installer interface {
-	InstallPackage(pkgPath, target string) error
+	InstallPackage(pkgPath string, opts PackageOptions) error
 	ExecuteScript(scriptPath, scriptType string, doNotWait bool, trackBackgroundProcesses bool) error
+	ExecuteScriptAs(scriptPath, runAs string, doNotWait bool, trackBackgroundProcesses bool) error
+	ExecuteUserScript(scriptPath string, uc UserContext, doNotWait bool, trackBackgroundProcesses bool) error
 	ExecuteScriptForPreflight(scriptPath, scriptType string, doNotWait bool, trackBackgroundProcesses bool) error
-	PlaceFile(filePath, fileType string) error
+	PlaceFile(filePath, fileType string, opts FileOptions) error
+	ConfigureMunki(opts MunkiOptions) error
+	ManageService(opts ServiceOptions) error
+	InstallAppStoreApp(opts VPPAppOptions) error
 	WaitForBackgroundProcesses(timeout time.Duration) []error
 	GetBackgroundProcessCount() int
 }
@@ -21,22 +27,29 @@ type SystemInstaller struct {
 	packageInstaller *PackageInstaller
 	scriptExecutor   *ScriptExecutor
 	filePlacer       *FilePlacer
-	logger           *utils.Logger
+	munki            *MunkiConfigurator
+	services         *ServiceManager
+	appStore         *AppStoreInstaller
+	logger           utils.Logger
 }
 
 // NewSystemInstaller creates a new system installer
-func NewSystemInstaller(dryRun bool, logger *utils.Logger, isAgentMode bool) *SystemInstaller {
+func NewSystemInstaller(dryRun bool, logger utils.Logger, isAgentMode bool) *SystemInstaller {
+	logger = utils.Component(logger, utils.ComponentInstaller)
 	return &SystemInstaller{
 		packageInstaller: NewPackageInstaller(dryRun, logger, isAgentMode),
 		scriptExecutor:   NewScriptExecutor(dryRun, logger, isAgentMode),
 		filePlacer:       NewFilePlacer(dryRun, logger, isAgentMode),
+		munki:            NewMunkiConfigurator(dryRun, logger),
+		services:         NewServiceManager(dryRun, logger),
+		appStore:         NewAppStoreInstaller(dryRun, logger),
 		logger:           logger,
 	}
 }
 
 // InstallPackage installs a package
-func (si *SystemInstaller) InstallPackage(pkgPath, target string) error {
-	return si.packageInstaller.InstallPackage(pkgPath, target)
+func (si *SystemInstaller) InstallPackage(pkgPath string, opts PackageOptions) error {
+	return si.packageInstaller.InstallPackage(pkgPath, opts)
 }
 
 // ExecuteScript executes a script with donotwait and tracking support
@@ -44,14 +57,66 @@ func (si *SystemInstaller) ExecuteScript(scriptPath, scriptType string, doNotWai
 	return si.scriptExecutor.ExecuteScript(scriptPath, scriptType, doNotWait, trackBackgroundProcesses)
 }
 
+// ExecuteScriptAs executes a rootscript as the console user or a named account
+func (si *SystemInstaller) ExecuteScriptAs(scriptPath, runAs string, doNotWait bool, trackBackgroundProcesses bool) error {
+	return si.scriptExecutor.ExecuteScriptAs(scriptPath, runAs, doNotWait, trackBackgroundProcesses)
+}
+
+// ExecuteUserScript executes a userscript with the user context environment
+func (si *SystemInstaller) ExecuteUserScript(scriptPath string, uc UserContext, doNotWait bool, trackBackgroundProcesses bool) error {
+	return si.scriptExecutor.ExecuteUserScript(scriptPath, uc, doNotWait, trackBackgroundProcesses)
+}
+
+// ExecuteUserScriptStreaming executes a foreground userscript with the user
+// context environment, streaming its output; closing cancel stops it
+func (si *SystemInstaller) ExecuteUserScriptStreaming(scriptPath string, uc UserContext, trackBackgroundProcesses bool, stdout, stderr io.Writer, cancel <-chan struct{}) error {
+	return si.scriptExecutor.ExecuteUserScriptStreaming(scriptPath, uc, trackBackgroundProcesses, stdout, stderr, cancel)
+}
+
+// ExecuteScriptStreaming executes a script and copies its output to stdout
+// and stderr as it runs; closing cancel stops it
+func (si *SystemInstaller) ExecuteScriptStreaming(scriptPath, scriptType string, doNotWait bool, trackBackgroundProcesses bool, stdout, stderr io.Writer, cancel <-chan struct{}) error {
+	return si.scriptExecutor.ExecuteScriptStreaming(scriptPath, scriptType, doNotWait, trackBackgroundProcesses, stdout, stderr, cancel)
+}
+
 // ExecuteScriptForPreflight executes a script with special preflight exit code handling
 func (si *SystemInstaller) ExecuteScriptForPreflight(scriptPath, scriptType string, doNotWait bool, trackBackgroundProcesses bool) error {
 	return si.scriptExecutor.ExecuteScriptForPreflight(scriptPath, scriptType, doNotWait, trackBackgroundProcesses)
 }
 
-// PlaceFile places a file with appropriate permissions
-func (si *SystemInstaller) PlaceFile(filePath, fileType string) error {
-	return si.filePlacer.PlaceFile(filePath, fileType)
+// PlaceFile places a file with appropriate ownership and permissions
+func (si *SystemInstaller) PlaceFile(filePath, fileType string, opts FileOptions) error {
+	return si.filePlacer.PlaceFile(filePath, fileType, opts)
+}
+
+// ConfigureMunki configures Munki and starts its first run
+func (si *SystemInstaller) ConfigureMunki(opts MunkiOptions) error {
+	return si.munki.Configure(opts)
+}
+
+// ManageService loads, unloads, enables, disables or kickstarts a launchd
+// service
+func (si *SystemInstaller) ManageService(opts ServiceOptions) error {
+	return si.services.Manage(opts)
+}
+
+// InstallAppStoreApp asks the MDM to install a VPP-licensed App Store app
+func (si *SystemInstaller) InstallAppStoreApp(opts VPPAppOptions) error {
+	return si.appStore.Install(opts)
+}
+
+// SetStop cancels foreground scripts, and the wait for App Store apps, when
+// stop is closed
+func (si *SystemInstaller) SetStop(stop <-chan struct{}) {
+	si.scriptExecutor.SetStop(stop)
+	si.appStore.SetStop(stop)
+}
+
+// SetLogDir saves the output of every installer run and foreground script to
+// its own file in dir
+func (si *SystemInstaller) SetLogDir(dir string) {
+	si.packageInstaller.SetLogDir(dir)
+	si.scriptExecutor.SetLogDir(dir)
 }
 
 // WaitForBackgroundProcesses waits for all background processes to complete
@@ -63,3 +128,8 @@ func (si *SystemInstaller) WaitForBackgroundProcesses(timeout time.Duration) []e
 func (si *SystemInstaller) GetBackgroundProcessCount() int {
 	return si.scriptExecutor.GetBackgroundProcessCount()
 }
+
+// GetBackgroundStatus returns the status of every tracked background process
+func (si *SystemInstaller) GetBackgroundStatus() []utils.ProcessStatus {
+	return si.scriptExecutor.GetBackgroundStatus()
+}
@@ -0,0 +1,60 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-installapplications/pkg/utils"
+)
+
+var unsafeLogNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// saveItemLog writes the full output of one run of file (a script or a
+// package) to <dir>/<name>-<timestamp>.log, named after the file without its
+// extension, and returns the log's path. The header records the command and
+// how it ended so the file can be read on its own.
+func saveItemLog(dir, file, command string, output []byte, runErr error, now time.Time) (string, error) {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	name = strings.Trim(unsafeLogNameChars.ReplaceAllString(name, "-"), "-.")
+	if name == "" {
+		name = "item"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("create item log directory: %w", err)
+	}
+	result := "success"
+	if runErr != nil {
+		result = runErr.Error()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Time: %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "File: %s\n", file)
+	fmt.Fprintf(&b, "Command: %s\n", command)
+	fmt.Fprintf(&b, "Result: %s\n\n", result)
+	b.Write(output)
+
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.log", name, now.Format("20060102-150405.000")))
+	// Output can hold anything a vendor script prints; only root reads it
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		return "", fmt.Errorf("write item log: %w", err)
+	}
+	return path, nil
+}
+
+// logRun saves the output of a run of file to dir, when set, and points to
+// the saved log from the main log.
+func logRun(logger utils.Logger, dir, file, command string, output []byte, runErr error) {
+	if dir == "" {
+		return
+	}
+	path, err := saveItemLog(dir, file, command, output, runErr, time.Now())
+	if err != nil {
+		logger.Error("Failed to save the output of %s: %v", file, err)
+		return
+	}
+	logger.Info("📝 Output of %s saved to %s", filepath.Base(file), path)
+}
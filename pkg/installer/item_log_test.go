@@ -0,0 +1,54 @@
+package installer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-installapplications/pkg/utils"
+)
+
+func TestSaveItemLog(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	now := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	path, err := saveItemLog(dir, "/Library/installapplications/Vendor Tool.pkg", "installer -pkg x", []byte("postinstall: boom\n"), errors.New("exit status 1"), now)
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if want := filepath.Join(dir, "Vendor-Tool-20240301-093000.000.log"); path != want {
+		t.Errorf("path = %s, want %s", path, want)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Command: installer -pkg x", "Result: exit status 1", "postinstall: boom"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("log lacks %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestExecuteScript_SavesOutputToLogDir(t *testing.T) {
+	dir := t.TempDir()
+	se := NewScriptExecutor(false, utils.NewLogger(false, false), false)
+	se.SetLogDir(dir)
+	script := writeScript(t, "#!/bin/sh\necho from stdout\necho from stderr >&2\nexit 3\n")
+	if err := se.ExecuteScript(script, "rootscript", false, false); err == nil {
+		t.Fatal("expected the script to fail")
+	}
+
+	logs, _ := filepath.Glob(filepath.Join(dir, "script-*.log"))
+	if len(logs) != 1 {
+		t.Fatalf("logs = %v, want one", logs)
+	}
+	data, _ := os.ReadFile(logs[0])
+	for _, want := range []string{"from stdout", "from stderr", "Result: exit status 3"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("log lacks %q:\n%s", want, data)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+
+	"howett.net/plist"
+
+	"github.com/go-installapplications/pkg/audit"
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// ServiceOptions describe what a launchd item does to its service
+type ServiceOptions struct {
+	// Action is one of config.LaunchdActions
+	Action string
+	// Label is the service label; read from Plist when empty
+	Label string
+	// Plist is the job's property list
+	Plist string
+	// Domain is "system" or "gui", the console user's session
+	Domain string
+}
+
+// ServiceOptionsFor builds the service options of a launchd item
+func ServiceOptionsFor(item config.Item) ServiceOptions {
+	domain := item.Domain
+	if domain == "" {
+		domain = "system"
+	}
+	return ServiceOptions{Action: item.Action, Label: item.Label, Plist: item.File, Domain: domain}
+}
+
+var serviceAuditActions = map[string]string{
+	"bootstrap": audit.ActionServiceLoad,
+	"bootout":   audit.ActionServiceBootout,
+	"enable":    audit.ActionServiceEnable,
+	"disable":   audit.ActionServiceDisable,
+	"kickstart": audit.ActionServiceKickstart,
+}
+
+// ServiceManager runs launchctl for launchd items
+type ServiceManager struct {
+	dryRun bool
+	logger utils.Logger
+	// run executes launchctl and consoleUID finds the gui domain's user;
+	// both are swapped out in tests
+	run        func(args []string) (string, error)
+	consoleUID func() (string, error)
+}
+
+// NewServiceManager creates a new service manager
+func NewServiceManager(dryRun bool, logger utils.Logger) *ServiceManager {
+	return &ServiceManager{
+		dryRun:     dryRun,
+		logger:     logger,
+		run:        utils.RunCommandCapture,
+		consoleUID: utils.GetConsoleUserUID,
+	}
+}
+
+// Manage runs the launchctl action of opts. bootstrap reloads a service
+// that is already loaded, so a job updated by an earlier item takes effect;
+// kickstart restarts a running service (-k).
+func (sm *ServiceManager) Manage(opts ServiceOptions) error {
+	event := audit.Event{Action: serviceAuditActions[opts.Action], Target: opts.Plist, Details: map[string]string{"domain": opts.Domain}}
+	if event.Target == "" {
+		event.Target = opts.Label
+	}
+	if sm.dryRun {
+		sm.logger.Info("[DRY RUN] Would run: launchctl %s for %s (%s domain)", opts.Action, event.Target, opts.Domain)
+		event.Outcome = audit.OutcomeDryRun
+		audit.Record(event)
+		return nil
+	}
+
+	domain := "system"
+	if opts.Domain == "gui" {
+		uid, err := sm.consoleUID()
+		if err != nil || uid == "" || uid == "0" {
+			return fmt.Errorf("gui domain: no console user is logged in")
+		}
+		domain = "gui/" + uid
+	}
+	event.Details["domain"] = domain
+	label := opts.Label
+	if label == "" {
+		var err error
+		if label, err = plistLabel(opts.Plist); err != nil {
+			return err
+		}
+	}
+	service := domain + "/" + label
+
+	var args []string
+	switch opts.Action {
+	case "bootstrap":
+		if err := prepareJobPlist(opts.Plist, domain == "system"); err != nil {
+			return err
+		}
+		// Ignore the error: the service is usually not loaded yet
+		if _, err := sm.run([]string{"launchctl", "bootout", service}); err == nil {
+			sm.logger.Debug("Unloaded %s before loading it again", service)
+		}
+		args = []string{"launchctl", "bootstrap", domain, opts.Plist}
+	case "bootout":
+		args = []string{"launchctl", "bootout", service}
+	case "enable", "disable":
+		args = []string{"launchctl", opts.Action, service}
+	case "kickstart":
+		args = []string{"launchctl", "kickstart", "-k", service}
+	default:
+		return fmt.Errorf("unknown launchd action: %s", opts.Action)
+	}
+
+	sm.logger.Info("Running launchctl %s for %s", opts.Action, service)
+	_, err := sm.run(args)
+	event.Outcome, event.Error = audit.Outcome(err), audit.ErrorString(err)
+	audit.Record(event)
+	if err != nil {
+		return fmt.Errorf("launchctl %s %s failed: %w", opts.Action, service, err)
+	}
+	return nil
+}
+
+// prepareJobPlist makes a job's plist loadable: launchd refuses jobs that
+// are writable by others and, in the system domain, not owned by root. A
+// downloaded plist is neither.
+func prepareJobPlist(path string, system bool) error {
+	if err := os.Chmod(path, 0644); err != nil {
+		return fmt.Errorf("failed to set permissions on %s: %w", path, err)
+	}
+	if system {
+		if err := os.Chown(path, 0, 0); err != nil {
+			return fmt.Errorf("failed to set ownership on %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// plistLabel reads the Label of a launchd job's property list
+func plistLabel(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("no label and no plist to read it from")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var job struct {
+		Label string `plist:"Label"`
+	}
+	if _, err := plist.Unmarshal(data, &job); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if job.Label == "" {
+		return "", fmt.Errorf("%s has no Label", path)
+	}
+	return job.Label, nil
+}
@@ -0,0 +1,93 @@
+package installer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// newTestServices returns a service manager that records launchctl commands
+// instead of running them; commands containing fail return an error.
+func newTestServices(fail string) (*ServiceManager, *[]string) {
+	var calls []string
+	sm := NewServiceManager(false, utils.NewLogger(false, false))
+	sm.run = func(args []string) (string, error) {
+		cmd := strings.Join(args, " ")
+		calls = append(calls, cmd)
+		if fail != "" && strings.Contains(cmd, fail) {
+			return "", errors.New("exit status 5")
+		}
+		return "", nil
+	}
+	sm.consoleUID = func() (string, error) { return "501", nil }
+	return sm, &calls
+}
+
+func writeJobPlist(t *testing.T, label string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), label+".plist")
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>Label</key><string>` + label + `</string></dict></plist>`
+	if err := os.WriteFile(p, []byte(body), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestManageService_Commands(t *testing.T) {
+	for _, tc := range []struct {
+		opts ServiceOptions
+		want string
+	}{
+		{ServiceOptions{Action: "kickstart", Label: "com.example.agent", Domain: "gui"}, "launchctl kickstart -k gui/501/com.example.agent"},
+		{ServiceOptions{Action: "enable", Label: "com.example.daemon", Domain: "system"}, "launchctl enable system/com.example.daemon"},
+		{ServiceOptions{Action: "disable", Label: "com.example.daemon", Domain: "system"}, "launchctl disable system/com.example.daemon"},
+		{ServiceOptions{Action: "bootout", Label: "com.example.daemon", Domain: "system"}, "launchctl bootout system/com.example.daemon"},
+	} {
+		sm, calls := newTestServices("")
+		if err := sm.Manage(tc.opts); err != nil {
+			t.Fatalf("%s: %v", tc.opts.Action, err)
+		}
+		if len(*calls) != 1 || (*calls)[0] != tc.want {
+			t.Errorf("%s: calls = %q, want %q", tc.opts.Action, *calls, tc.want)
+		}
+	}
+}
+
+// bootstrap reads the label from the plist, makes the plist loadable and
+// reloads a service that is already loaded.
+func TestManageService_BootstrapReloads(t *testing.T) {
+	plistPath := writeJobPlist(t, "com.example.agent")
+	sm, calls := newTestServices("")
+	if err := sm.Manage(ServiceOptionsFor(config.Item{Type: "launchd", Action: "bootstrap", File: plistPath, Domain: "gui"})); err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	want := []string{"launchctl bootout gui/501/com.example.agent", "launchctl bootstrap gui/501 " + plistPath}
+	if strings.Join(*calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("calls = %q, want %q", *calls, want)
+	}
+	if info, _ := os.Stat(plistPath); info.Mode().Perm() != 0644 {
+		t.Errorf("plist mode = %v, want 0644", info.Mode().Perm())
+	}
+}
+
+func TestManageService_Errors(t *testing.T) {
+	sm, _ := newTestServices("kickstart")
+	if err := sm.Manage(ServiceOptions{Action: "kickstart", Label: "com.example.x", Domain: "system"}); err == nil {
+		t.Error("a failing launchctl should fail the item")
+	}
+
+	sm, calls := newTestServices("")
+	sm.consoleUID = func() (string, error) { return "0", nil }
+	if err := sm.Manage(ServiceOptions{Action: "kickstart", Label: "com.example.x", Domain: "gui"}); err == nil || len(*calls) != 0 {
+		t.Errorf("gui domain without a user: err = %v, calls = %q", err, *calls)
+	}
+	if err := sm.Manage(ServiceOptions{Action: "enable", Plist: filepath.Join(t.TempDir(), "missing.plist"), Domain: "system"}); err == nil {
+		t.Error("a label that cannot be read should fail")
+	}
+}
@@ -0,0 +1,121 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/go-installapplications/pkg/audit"
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// Munki install locations
+const (
+	MunkiManagedSoftwareUpdate = "/usr/local/munki/managedsoftwareupdate"
+	MunkiPreferencesDomain     = "/Library/Preferences/ManagedInstalls"
+)
+
+// MunkiOptions configures the Munki handoff
+type MunkiOptions struct {
+	SoftwareRepoURL  string
+	ClientIdentifier string
+	// DoNotWait starts managedsoftwareupdate without waiting for it to finish
+	DoNotWait bool
+}
+
+// MunkiOptionsFor builds the handoff options for a munki item from the
+// Munki* configuration keys
+func MunkiOptionsFor(item config.Item, cfg *config.Config) MunkiOptions {
+	return MunkiOptions{
+		SoftwareRepoURL:  cfg.MunkiSoftwareRepoURL,
+		ClientIdentifier: cfg.MunkiClientIdentifier,
+		DoNotWait:        item.DoNotWait,
+	}
+}
+
+// MunkiConfigurator writes the ManagedInstalls preferences and starts the
+// first managedsoftwareupdate run
+type MunkiConfigurator struct {
+	dryRun bool
+	logger utils.Logger
+	// run executes a command and waits for it; start launches one without
+	// waiting. Both are swapped out in tests.
+	run   func(args []string) (string, error)
+	start func(args []string) error
+	msu   string
+}
+
+// NewMunkiConfigurator creates a new Munki configurator
+func NewMunkiConfigurator(dryRun bool, logger utils.Logger) *MunkiConfigurator {
+	return &MunkiConfigurator{
+		dryRun: dryRun,
+		logger: logger,
+		run:    utils.RunCommandCapture,
+		start: func(args []string) error {
+			return exec.Command(args[0], args[1:]...).Start()
+		},
+		msu: MunkiManagedSoftwareUpdate,
+	}
+}
+
+// Configure points Munki at the repo and kicks off the initial run. Munki
+// itself must already be installed (the handoff item's package, or an earlier
+// item).
+func (mc *MunkiConfigurator) Configure(opts MunkiOptions) error {
+	if opts.SoftwareRepoURL == "" {
+		return fmt.Errorf("MunkiSoftwareRepoURL is not configured")
+	}
+	mc.logger.Info("Handing off to Munki: %s", opts.SoftwareRepoURL)
+
+	if mc.dryRun {
+		mc.logger.Info("[DRY RUN] Would configure Munki (repo: %s, client identifier: %q) and run managedsoftwareupdate", opts.SoftwareRepoURL, opts.ClientIdentifier)
+		return nil
+	}
+
+	if _, err := os.Stat(mc.msu); err != nil {
+		return fmt.Errorf("munki is not installed: %s not found", mc.msu)
+	}
+
+	prefs := [][2]string{{"SoftwareRepoURL", opts.SoftwareRepoURL}}
+	if opts.ClientIdentifier != "" {
+		prefs = append(prefs, [2]string{"ClientIdentifier", opts.ClientIdentifier})
+	}
+	for _, p := range prefs {
+		mc.logger.Debug("Setting ManagedInstalls %s = %s", p[0], p[1])
+		if _, err := mc.run([]string{"defaults", "write", MunkiPreferencesDomain, p[0], "-string", p[1]}); err != nil {
+			return fmt.Errorf("failed to set ManagedInstalls %s: %w", p[0], err)
+		}
+	}
+
+	// --auto runs a check and installs unattended, as the launchd job would
+	args := []string{mc.msu, "--auto"}
+	event := audit.Event{
+		Action:  audit.ActionScriptExecute,
+		Target:  mc.msu,
+		Details: map[string]string{"type": "munki", "repo": opts.SoftwareRepoURL},
+	}
+	if opts.DoNotWait {
+		err := mc.start(args)
+		event.Outcome, event.Error = audit.OutcomeStarted, audit.ErrorString(err)
+		if err != nil {
+			event.Outcome = audit.OutcomeFailure
+		}
+		audit.Record(event)
+		if err != nil {
+			return fmt.Errorf("failed to start managedsoftwareupdate: %w", err)
+		}
+		mc.logger.Info("Started managedsoftwareupdate in background")
+		return nil
+	}
+
+	output, err := mc.run(args)
+	event.Outcome, event.Error = audit.Outcome(err), audit.ErrorString(err)
+	audit.Record(event)
+	if err != nil {
+		return fmt.Errorf("managedsoftwareupdate failed: %w", err)
+	}
+	mc.logger.Debug("managedsoftwareupdate output: %s", output)
+	mc.logger.Info("Initial managedsoftwareupdate run completed")
+	return nil
+}
@@ -0,0 +1,77 @@
+package installer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// newTestMunki returns a configurator with a fake managedsoftwareupdate that
+// records commands instead of running them.
+func newTestMunki(t *testing.T, fail string) (*MunkiConfigurator, *[]string) {
+	t.Helper()
+	msu := filepath.Join(t.TempDir(), "managedsoftwareupdate")
+	if err := os.WriteFile(msu, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("write fake msu: %v", err)
+	}
+	var calls []string
+	mc := NewMunkiConfigurator(false, utils.NewLogger(false, false))
+	mc.msu = msu
+	mc.run = func(args []string) (string, error) {
+		cmd := strings.Join(args, " ")
+		calls = append(calls, cmd)
+		if strings.Contains(cmd, fail) && fail != "" {
+			return "", errors.New("exit status 1")
+		}
+		return "", nil
+	}
+	mc.start = func(args []string) error {
+		calls = append(calls, "start "+strings.Join(args, " "))
+		return nil
+	}
+	return mc, &calls
+}
+
+func TestMunkiConfigure_WritesPrefsThenRuns(t *testing.T) {
+	mc, calls := newTestMunki(t, "")
+	err := mc.Configure(MunkiOptions{SoftwareRepoURL: "https://munki.example/repo", ClientIdentifier: "eng"})
+	if err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	want := []string{
+		"defaults write /Library/Preferences/ManagedInstalls SoftwareRepoURL -string https://munki.example/repo",
+		"defaults write /Library/Preferences/ManagedInstalls ClientIdentifier -string eng",
+		mc.msu + " --auto",
+	}
+	if strings.Join(*calls, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("calls:\n%s\nwant:\n%s", strings.Join(*calls, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMunkiConfigure_DoNotWaitStartsInBackground(t *testing.T) {
+	mc, calls := newTestMunki(t, "")
+	if err := mc.Configure(MunkiOptions{SoftwareRepoURL: "https://r", DoNotWait: true}); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	if last := (*calls)[len(*calls)-1]; last != "start "+mc.msu+" --auto" {
+		t.Fatalf("expected background start, got %v", *calls)
+	}
+}
+
+func TestMunkiConfigure_Errors(t *testing.T) {
+	mc, _ := newTestMunki(t, "--auto")
+	if err := mc.Configure(MunkiOptions{}); err == nil {
+		t.Fatalf("expected error without a repo URL")
+	}
+	if err := mc.Configure(MunkiOptions{SoftwareRepoURL: "https://r"}); err == nil || !strings.Contains(err.Error(), "managedsoftwareupdate failed") {
+		t.Fatalf("expected managedsoftwareupdate failure, got %v", err)
+	}
+	mc.msu = filepath.Join(t.TempDir(), "missing")
+	if err := mc.Configure(MunkiOptions{SoftwareRepoURL: "https://r"}); err == nil || !strings.Contains(err.Error(), "not installed") {
+		t.Fatalf("expected not-installed error, got %v", err)
+	}
+}
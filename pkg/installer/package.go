@@ -1,33 +1,113 @@
 package installer
 
 import (
+	"bytes"
 	"fmt"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/go-installapplications/pkg/audit"
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/retry"
 	"github.com/go-installapplications/pkg/utils"
 )
 
+// PackageOptions are the installer(8) options of a package item
+type PackageOptions struct {
+	// Name is the item's name, recorded when the package requires a
+	// restart; the package's file name when empty
+	Name string
+	// Target is the volume to install to; "/" when empty
+	Target string
+	// ChoicesXML is passed to -applyChoiceChangesXML when set
+	ChoicesXML string
+	// AllowUntrusted passes -allowUntrusted, for packages signed with an
+	// untrusted or expired certificate
+	AllowUntrusted bool
+	// VerifySignature checks the signature before installing, see
+	// verifySignature; a non-empty TeamIDs also requires one of those
+	VerifySignature bool
+	TeamIDs         []string
+	// Progress, when set, runs installer with -verboseR and is called as the
+	// install moves on; see ReportProgress
+	Progress func(InstallProgress)
+}
+
+// PackageOptionsFor builds the installer options of a package item from the
+// item and the VerifyPackageSignatures and AllowedTeamIDs configuration keys
+func PackageOptionsFor(item config.Item, cfg *config.Config) PackageOptions {
+	opts := PackageOptions{
+		Name:            item.Name,
+		Target:          item.Target,
+		ChoicesXML:      item.ChoicesXML,
+		AllowUntrusted:  item.AllowUntrusted,
+		VerifySignature: cfg.VerifyPackageSignatures || item.VerifySignature || item.TeamID != "",
+		TeamIDs:         cfg.AllowedTeamIDs,
+	}
+	if item.TeamID != "" {
+		opts.TeamIDs = []string{item.TeamID}
+	}
+	return opts
+}
+
+// installerArgs returns the installer(8) arguments that install pkgPath
+func installerArgs(pkgPath string, opts PackageOptions) []string {
+	target := opts.Target
+	if target == "" {
+		target = "/" // Default to root volume
+	}
+	args := []string{"-pkg", pkgPath, "-target", target}
+	if opts.ChoicesXML != "" {
+		args = append(args, "-applyChoiceChangesXML", opts.ChoicesXML)
+	}
+	if opts.AllowUntrusted {
+		args = append(args, "-allowUntrusted")
+	}
+	if opts.Progress != nil {
+		args = append(args, "-verboseR")
+	}
+	return args
+}
+
 // PackageInstaller handles macOS package installation
 type PackageInstaller struct {
 	dryRun      bool
-	logger      *utils.Logger
+	logger      utils.Logger
 	isAgentMode bool
+	// run executes the signature checks; swapped out in tests
+	run func(args []string) (string, error)
+	// logDir receives the output of every installer run (see SetLogDir)
+	logDir string
 }
 
 // NewPackageInstaller creates a new package installer
-func NewPackageInstaller(dryRun bool, logger *utils.Logger, isAgentMode bool) *PackageInstaller {
+func NewPackageInstaller(dryRun bool, logger utils.Logger, isAgentMode bool) *PackageInstaller {
 	return &PackageInstaller{
 		dryRun:      dryRun,
 		logger:      logger,
 		isAgentMode: isAgentMode,
+		run:         utils.RunCommandCapture,
 	}
 }
 
+// SetLogDir saves the full output of every installer run to its own file in
+// dir.
+func (pi *PackageInstaller) SetLogDir(dir string) {
+	pi.logDir = dir
+}
+
 // InstallPackage installs a .pkg file using the macOS installer command
-func (pi *PackageInstaller) InstallPackage(pkgPath, target string) error {
-	if target == "" {
-		target = "/" // Default to root volume
+func (pi *PackageInstaller) InstallPackage(pkgPath string, opts PackageOptions) error {
+	args := installerArgs(pkgPath, opts)
+	target := args[3]
+	details := map[string]string{"install_target": target}
+	if opts.ChoicesXML != "" {
+		details["choices_xml"] = opts.ChoicesXML
+	}
+	if opts.AllowUntrusted {
+		details["allow_untrusted"] = "true"
 	}
 
 	pi.logger.Info("Installing package: %s to %s", pkgPath, target)
@@ -39,14 +119,31 @@ func (pi *PackageInstaller) InstallPackage(pkgPath, target string) error {
 	}
 
 	if pi.dryRun {
-		pi.logger.Info("[DRY RUN] Would install: %s", pkgPath)
+		if opts.VerifySignature {
+			pi.logger.Info("[DRY RUN] Would verify the signature of %s", pkgPath)
+		}
+		pi.logger.Info("[DRY RUN] Would run: installer %s", strings.Join(args, " "))
+		audit.Record(audit.Event{Action: audit.ActionPackageInstall, Target: pkgPath, Outcome: audit.OutcomeDryRun,
+			Details: details})
 		return nil
 	}
 
+	if opts.VerifySignature {
+		teamID, err := pi.verifySignature(pkgPath, opts.TeamIDs)
+		if err != nil {
+			pi.logger.Error("❌ Refusing to install %s: %v", pkgPath, err)
+			audit.Record(audit.Event{Action: audit.ActionPackageInstall, Target: pkgPath, SHA256: audit.FileSHA256(pkgPath),
+				Outcome: audit.OutcomeFailure, Error: err.Error(), Details: details})
+			return retry.Tag(retry.CategoryValidation, fmt.Errorf("signature verification failed: %w", err))
+		}
+		details["team_id"] = teamID
+		pi.logger.Info("🔏 Signature verified: %s (Team ID %s)", pkgPath, teamID)
+	}
+
 	// Build installer command
 	// Both daemon and agent can install packages
 	// Agent relies on proper authorization/signing to run installer
-	cmd := exec.Command("installer", "-pkg", pkgPath, "-target", target)
+	cmd := exec.Command("installer", args...)
 	pi.logger.Debug("Executing installer (mode: %s): %s", func() string {
 		if pi.isAgentMode {
 			return "agent"
@@ -57,7 +154,16 @@ func (pi *PackageInstaller) InstallPackage(pkgPath, target string) error {
 	pi.logger.Verbose("Command args: %v", cmd.Args)
 
 	// Capture both stdout and stderr
-	output, err := cmd.CombinedOutput()
+	output, err := pi.runInstaller(cmd, opts.Progress)
+	logRun(pi.logger, pi.logDir, pkgPath, cmd.String(), output, err)
+	audit.Record(audit.Event{
+		Action:  audit.ActionPackageInstall,
+		Target:  pkgPath,
+		SHA256:  audit.FileSHA256(pkgPath),
+		Outcome: audit.Outcome(err),
+		Error:   audit.ErrorString(err),
+		Details: details,
+	})
 	if err != nil {
 		pi.logger.Error("Installer command failed: %v", err)
 		pi.logger.Debug("Installer output: %s", string(output))
@@ -67,5 +173,60 @@ func (pi *PackageInstaller) InstallPackage(pkgPath, target string) error {
 	outputStr := strings.TrimSpace(string(output))
 	pi.logger.Info("Package installed successfully")
 	pi.logger.Debug("Installer output: %s", outputStr)
+	if requiresRestart(outputStr) {
+		name := opts.Name
+		if name == "" {
+			name = filepath.Base(pkgPath)
+		}
+		pi.logger.Info("🔄 %s requires a restart", name)
+		utils.RecordRestartRequired(name)
+	}
 	return nil
 }
+
+// requiresRestart reports whether installer output says the package needs
+// a restart, as installer(8) prints for a restart or shutdown
+// postinstall-action or a Distribution's onConclusion, e.g.
+// "installer: The install requires restarting now."
+func requiresRestart(output string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.ToLower(line)
+		if !strings.HasPrefix(strings.TrimSpace(line), "installer:") {
+			continue
+		}
+		if strings.Contains(line, "requires restarting") || strings.Contains(line, "requires shutting down") {
+			return true
+		}
+	}
+	return false
+}
+
+// runInstaller runs cmd and returns its combined output. With a progress
+// callback the output is read line by line as it arrives and the -verboseR
+// percentages go to the callback instead of the output.
+func (pi *PackageInstaller) runInstaller(cmd *exec.Cmd, progress func(InstallProgress)) ([]byte, error) {
+	if progress == nil {
+		return cmd.CombinedOutput()
+	}
+	parser := newProgressParser(time.Now)
+	var output bytes.Buffer
+	phase := ""
+	w := &lineWriter{fn: func(line string) {
+		if ip, changed := parser.feed(line); changed {
+			if ip.Phase != phase {
+				phase = ip.Phase
+				pi.logger.Info("📦 %s", phase)
+			}
+			pi.logger.Verbose("Installer progress: %s", ip)
+			progress(ip)
+		}
+		if !isProgressLine(line) {
+			output.WriteString(line + "\n")
+		}
+	}}
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err := cmd.Run()
+	w.flush()
+	return output.Bytes(), err
+}
@@ -0,0 +1,146 @@
+package installer
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/progress"
+)
+
+// InstallProgress is a progress update parsed from installer -verboseR output
+type InstallProgress struct {
+	// Percent is how much of the install is done, 0-100
+	Percent float64
+	// Phase is the last PHASE or STATUS line, e.g. "Running package scripts…"
+	Phase string
+	// ETA estimates the time left from the pace so far; 0 until known
+	ETA time.Duration
+}
+
+// String formats the update for the progress display, e.g.
+// "42% – Running package scripts… (about 2m left)"
+func (p InstallProgress) String() string {
+	s := fmt.Sprintf("%d%%", int(p.Percent))
+	if p.Phase != "" {
+		s += " – " + p.Phase
+	}
+	if p.ETA > 0 {
+		s += fmt.Sprintf(" (about %s left)", roundETA(p.ETA))
+	}
+	return s
+}
+
+// roundETA rounds an estimate so it does not flicker with every update
+func roundETA(d time.Duration) time.Duration {
+	if d < time.Minute {
+		return d.Round(5 * time.Second)
+	}
+	return d.Round(time.Minute)
+}
+
+// etaMinPercent is how far an install must be before its pace is trusted;
+// installer spends its first seconds preparing and reports almost nothing
+const etaMinPercent = 5
+
+// progressParser turns installer -verboseR output into InstallProgress
+// updates. -verboseR prints lines like:
+//
+//	installer:PHASE:Preparing for installation…
+//	installer:STATUS:Running package scripts…
+//	installer:%42.857143
+type progressParser struct {
+	start time.Time
+	now   func() time.Time
+	last  InstallProgress
+}
+
+func newProgressParser(now func() time.Time) *progressParser {
+	return &progressParser{start: now(), now: now}
+}
+
+// feed parses one line of output and returns the new progress, or false when
+// the line changes nothing.
+func (p *progressParser) feed(line string) (InstallProgress, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(line), "installer:")
+	if !ok {
+		return p.last, false
+	}
+	next := p.last
+	switch {
+	case strings.HasPrefix(rest, "%"):
+		pct, err := strconv.ParseFloat(rest[1:], 64)
+		if err != nil || pct < 0 || pct > 100 {
+			return p.last, false
+		}
+		next.Percent = pct
+		next.ETA = 0
+		if pct >= etaMinPercent && pct < 100 {
+			elapsed := p.now().Sub(p.start)
+			next.ETA = time.Duration(float64(elapsed) * (100 - pct) / pct)
+		}
+	case strings.HasPrefix(rest, "PHASE:"), strings.HasPrefix(rest, "STATUS:"):
+		_, phase, _ := strings.Cut(rest, ":")
+		if phase = strings.TrimSpace(phase); phase == "" {
+			return p.last, false
+		}
+		next.Phase = phase
+	default:
+		return p.last, false
+	}
+	if int(next.Percent) == int(p.last.Percent) && next.Phase == p.last.Phase {
+		// Sub-percent steps are not worth a display update
+		p.last = next
+		return next, false
+	}
+	p.last = next
+	return next, true
+}
+
+// isProgressLine reports whether line is a -verboseR percentage, which is
+// left out of the output kept for logs and errors.
+func isProgressLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "installer:%")
+}
+
+// lineWriter calls fn with every complete line written to it. exec.Cmd
+// serialises writes when Stdout and Stderr are the same lineWriter.
+type lineWriter struct {
+	buf bytes.Buffer
+	fn  func(line string)
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		line := string(w.buf.Next(i + 1))
+		w.fn(strings.TrimRight(line, "\r\n"))
+	}
+}
+
+// flush passes on a last line that did not end in a newline.
+func (w *lineWriter) flush() {
+	if w.buf.Len() > 0 {
+		w.fn(w.buf.String())
+		w.buf.Reset()
+	}
+}
+
+// ReportProgress makes InstallPackage send the progress of item to r when r
+// shows per-item progress, see progress.ItemProgresser.
+func (o *PackageOptions) ReportProgress(item config.Item, r progress.Reporter) {
+	p, ok := r.(progress.ItemProgresser)
+	if !ok {
+		return
+	}
+	o.Progress = func(ip InstallProgress) {
+		p.ItemProgress(item, int(ip.Percent), fmt.Sprintf("Installing %s… %s", item.Name, ip))
+	}
+}
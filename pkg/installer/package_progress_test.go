@@ -0,0 +1,103 @@
+package installer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/progress"
+)
+
+func TestProgressParser(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	p := newProgressParser(func() time.Time { return now })
+
+	lines := []struct {
+		line    string
+		elapsed time.Duration
+	}{
+		{"installer: Package name is Example", 0},
+		{"installer:PHASE:Preparing for installation…", 0},
+		{"installer:%2.000000", time.Second},
+		{"installer:%2.400000", 2 * time.Second}, // same whole percent
+		{"installer:STATUS:Running package scripts…", 3 * time.Second},
+		{"installer:%25.000000", 30 * time.Second},
+		{"installer:%bogus", 31 * time.Second},
+		{"installer:%100.000000", 60 * time.Second},
+	}
+	var got []InstallProgress
+	for _, l := range lines {
+		now = start.Add(l.elapsed)
+		if ip, ok := p.feed(l.line); ok {
+			got = append(got, ip)
+		}
+	}
+	want := []InstallProgress{
+		{Phase: "Preparing for installation…"},
+		{Percent: 2, Phase: "Preparing for installation…"},
+		{Percent: 2.4, Phase: "Running package scripts…"},
+		{Percent: 25, Phase: "Running package scripts…", ETA: 90 * time.Second},
+		{Percent: 100, Phase: "Running package scripts…"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("updates:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestInstallProgressString(t *testing.T) {
+	ip := InstallProgress{Percent: 42.8, Phase: "Running package scripts…", ETA: 152 * time.Second}
+	if got, want := ip.String(), "42% – Running package scripts… (about 3m0s left)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := (InstallProgress{Percent: 5}).String(); got != "5%" {
+		t.Errorf("String() = %q, want 5%%", got)
+	}
+}
+
+func TestLineWriter_SplitsLines(t *testing.T) {
+	var lines []string
+	w := &lineWriter{fn: func(l string) { lines = append(lines, l) }}
+	for _, chunk := range []string{"installer:%1", "0.0\r\ninstaller:PHASE:A\n", "tail"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.flush()
+	if want := []string{"installer:%10.0", "installer:PHASE:A", "tail"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+}
+
+// progressRecorder is a Reporter that records ItemProgress calls.
+type progressRecorder struct {
+	progress.Nop
+	texts []string
+}
+
+func (r *progressRecorder) ItemProgress(item config.Item, percent int, text string) {
+	r.texts = append(r.texts, text)
+}
+
+func TestReportProgress(t *testing.T) {
+	var opts PackageOptions
+	opts.ReportProgress(config.Item{Name: "Chrome"}, progress.Nop{})
+	if opts.Progress != nil {
+		t.Fatal("a reporter without ItemProgress should not enable progress")
+	}
+	if args := installerArgs("/tmp/a.pkg", opts); strings.Contains(strings.Join(args, " "), "-verboseR") {
+		t.Errorf("args = %v, want no -verboseR", args)
+	}
+
+	r := &progressRecorder{}
+	opts.ReportProgress(config.Item{Name: "Chrome"}, r)
+	if args := installerArgs("/tmp/a.pkg", opts); args[len(args)-1] != "-verboseR" {
+		t.Errorf("args = %v, want -verboseR", args)
+	}
+	opts.Progress(InstallProgress{Percent: 50})
+	if want := []string{"Installing Chrome… 50%"}; !reflect.DeepEqual(r.texts, want) {
+		t.Errorf("texts = %q, want %q", r.texts, want)
+	}
+}
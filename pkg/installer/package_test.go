@@ -0,0 +1,63 @@
+package installer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+)
+
+func TestInstallerArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts PackageOptions
+		want []string
+	}{
+		{"defaults", PackageOptions{}, []string{"-pkg", "/tmp/a.pkg", "-target", "/"}},
+		{"target volume", PackageOptions{Target: "/Volumes/Data"}, []string{"-pkg", "/tmp/a.pkg", "-target", "/Volumes/Data"}},
+		{"choices and untrusted", PackageOptions{ChoicesXML: "/tmp/choices.xml", AllowUntrusted: true},
+			[]string{"-pkg", "/tmp/a.pkg", "-target", "/", "-applyChoiceChangesXML", "/tmp/choices.xml", "-allowUntrusted"}},
+	} {
+		if got := installerArgs("/tmp/a.pkg", tc.opts); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPackageOptionsFor(t *testing.T) {
+	cfg := config.NewConfig()
+	item := config.Item{Name: "App", Type: "package", ChoicesXML: "/tmp/choices.xml", AllowUntrusted: true, Target: "/Volumes/Data"}
+	want := PackageOptions{Name: "App", Target: "/Volumes/Data", ChoicesXML: "/tmp/choices.xml", AllowUntrusted: true}
+	if got := PackageOptionsFor(item, cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// The global setting verifies every package against AllowedTeamIDs; an
+	// item's team_id replaces the list
+	cfg.VerifyPackageSignatures = true
+	cfg.AllowedTeamIDs = []string{"ABCDE12345"}
+	if got := PackageOptionsFor(config.Item{Type: "package"}, cfg); !got.VerifySignature || !reflect.DeepEqual(got.TeamIDs, cfg.AllowedTeamIDs) {
+		t.Errorf("global verification: %+v", got)
+	}
+	cfg.VerifyPackageSignatures = false
+	if got := PackageOptionsFor(config.Item{Type: "package", TeamID: "UBF8T346G9"}, cfg); !got.VerifySignature || !reflect.DeepEqual(got.TeamIDs, []string{"UBF8T346G9"}) {
+		t.Errorf("item team_id: %+v", got)
+	}
+}
+
+func TestRequiresRestart(t *testing.T) {
+	for _, tc := range []struct {
+		output string
+		want   bool
+	}{
+		{"installer: Package name is App\ninstaller: The install was successful.", false},
+		{"installer: The install was successful.\ninstaller: The install requires restarting now.", true},
+		{"installer:PHASE:Finishing\ninstaller:The upgrade requires restarting now.", true},
+		{"installer: The install requires shutting down now.", true},
+		{"postinstall: the app requires restarting", false},
+	} {
+		if got := requiresRestart(tc.output); got != tc.want {
+			t.Errorf("requiresRestart(%q) = %t, want %t", tc.output, got, tc.want)
+		}
+	}
+}
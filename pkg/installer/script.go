@@ -2,13 +2,20 @@ package installer
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-installapplications/pkg/audit"
+	"github.com/go-installapplications/pkg/config"
 	"github.com/go-installapplications/pkg/utils"
 )
 
@@ -20,16 +27,39 @@ func (e *PreflightSuccessError) Error() string {
 	return "preflight script passed - cleaning up and exiting"
 }
 
+// ScriptCancelledError is returned when a script is stopped through its
+// cancel channel. Output holds whatever the script printed before it was killed.
+type ScriptCancelledError struct {
+	Output []byte
+}
+
+func (e *ScriptCancelledError) Error() string {
+	return "script cancelled"
+}
+
+// cancelGracePeriod is how long a cancelled script's process group has to
+// exit after SIGTERM before it is sent SIGKILL. A variable so tests can
+// shorten it.
+var cancelGracePeriod = 5 * time.Second
+
+// stragglerWait is how long a script's background children may keep its
+// output open after the script exits. A variable so tests can shorten it.
+var stragglerWait = 5 * time.Second
+
 // ScriptExecutor handles script execution
 type ScriptExecutor struct {
 	dryRun         bool
-	logger         *utils.Logger
+	logger         utils.Logger
 	processTracker *utils.ProcessTracker
 	isAgentMode    bool // true if running as agent (user context), false if daemon (root context)
+	// stop, when closed, cancels every foreground script (see SetStop)
+	stop <-chan struct{}
+	// logDir receives the output of every foreground script (see SetLogDir)
+	logDir string
 }
 
 // NewScriptExecutor creates a new script executor
-func NewScriptExecutor(dryRun bool, logger *utils.Logger, isAgentMode bool) *ScriptExecutor {
+func NewScriptExecutor(dryRun bool, logger utils.Logger, isAgentMode bool) *ScriptExecutor {
 	return &ScriptExecutor{
 		dryRun:         dryRun,
 		logger:         logger,
@@ -90,41 +120,122 @@ func (se *ScriptExecutor) detectScriptInterpreter(scriptPath string) (string, er
 
 // ExecuteScript runs a script with appropriate permissions and donotwait support
 func (se *ScriptExecutor) ExecuteScript(scriptPath, scriptType string, doNotWait bool, trackBackgroundProcesses bool) error {
-	return se.executeScript(scriptPath, scriptType, doNotWait, trackBackgroundProcesses, false)
+	return se.executeScript(scriptPath, scriptType, "", doNotWait, trackBackgroundProcesses, false, nil, UserContext{})
+}
+
+// ExecuteScriptAs runs a rootscript like ExecuteScript as another user:
+// config.RunAsConsole for the console user, in their launchd session, or a
+// local account name such as a service account. An empty runAs runs it as
+// root.
+func (se *ScriptExecutor) ExecuteScriptAs(scriptPath, runAs string, doNotWait bool, trackBackgroundProcesses bool) error {
+	return se.executeScript(scriptPath, "rootscript", runAs, doNotWait, trackBackgroundProcesses, false, nil, UserContext{})
+}
+
+// ExecuteUserScript runs a userscript like ExecuteScript with the documented
+// user context environment (see UserContext.Env)
+func (se *ScriptExecutor) ExecuteUserScript(scriptPath string, uc UserContext, doNotWait bool, trackBackgroundProcesses bool) error {
+	return se.executeScript(scriptPath, "userscript", "", doNotWait, trackBackgroundProcesses, false, nil, uc)
+}
+
+// ExecuteUserScriptStreaming runs a userscript in the foreground like
+// ExecuteScriptStreaming with the user context environment
+func (se *ScriptExecutor) ExecuteUserScriptStreaming(scriptPath string, uc UserContext, trackBackgroundProcesses bool, stdout, stderr io.Writer, cancel <-chan struct{}) error {
+	return se.executeScript(scriptPath, "userscript", "", false, trackBackgroundProcesses, false, &scriptOutput{stdout: stdout, stderr: stderr, cancel: cancel}, uc)
+}
+
+// ExecuteScriptStreaming runs a script like ExecuteScript and also copies its
+// stdout and stderr to the given writers as it runs. Background scripts are
+// not streamed. Closing cancel (may be nil) kills the script's process group
+// and returns a *ScriptCancelledError.
+func (se *ScriptExecutor) ExecuteScriptStreaming(scriptPath, scriptType string, doNotWait bool, trackBackgroundProcesses bool, stdout, stderr io.Writer, cancel <-chan struct{}) error {
+	return se.executeScript(scriptPath, scriptType, "", doNotWait, trackBackgroundProcesses, false, &scriptOutput{stdout: stdout, stderr: stderr, cancel: cancel}, UserContext{})
 }
 
 // ExecuteScriptForPreflight runs a script with special preflight exit code handling
 func (se *ScriptExecutor) ExecuteScriptForPreflight(scriptPath, scriptType string, doNotWait bool, trackBackgroundProcesses bool) error {
-	return se.executeScript(scriptPath, scriptType, doNotWait, trackBackgroundProcesses, true)
+	return se.executeScript(scriptPath, scriptType, "", doNotWait, trackBackgroundProcesses, true, nil, UserContext{})
 }
 
 // executeScript is the internal implementation that handles both normal and preflight scripts
-func (se *ScriptExecutor) executeScript(scriptPath, scriptType string, doNotWait bool, trackBackgroundProcesses bool, isPreflight bool) error {
+func (se *ScriptExecutor) executeScript(scriptPath, scriptType, runAs string, doNotWait bool, trackBackgroundProcesses bool, isPreflight bool, out *scriptOutput, uc UserContext) (err error) {
 	se.logger.Info("Executing %s script: %s", scriptType, scriptPath)
 	se.logger.Debug("Script executor dry-run mode: %t, donotwait: %t, track-bg: %t", se.dryRun, doNotWait, trackBackgroundProcesses)
 
+	// Hash before running so the audit record reflects what was executed
+	event := audit.Event{
+		Action:  audit.ActionScriptExecute,
+		Target:  scriptPath,
+		SHA256:  audit.FileSHA256(scriptPath),
+		Details: map[string]string{"type": scriptType},
+	}
+	if isPreflight {
+		event.Details["preflight"] = "true"
+	}
+	if runAs != "" {
+		event.Details["run_as"] = runAs
+	}
+
 	if se.dryRun {
+		event.Outcome = audit.OutcomeDryRun
+		audit.Record(event)
+		if runAs != "" {
+			se.logger.Info("[DRY RUN] Would run %s as %s", scriptPath, runAs)
+		}
 		return se.handleDryRunExecution(scriptPath, scriptType, doNotWait)
 	}
 
+	background := doNotWait && !isPreflight
+	defer func() {
+		var preflightPassed *PreflightSuccessError
+		switch {
+		case errors.As(err, &preflightPassed):
+			event.Outcome = audit.OutcomeSuccess
+		case err == nil && background:
+			event.Outcome = audit.OutcomeStarted
+		default:
+			event.Outcome = audit.Outcome(err)
+			event.Error = audit.ErrorString(err)
+		}
+		audit.Record(event)
+	}()
+
 	// Validate and prepare script
 	if err := se.validateAndPrepareScript(scriptPath); err != nil {
 		return err
 	}
 
 	// Create and configure command
-	cmd, err := se.createScriptCommand(scriptPath, scriptType)
+	cmd, err := se.createScriptCommand(scriptPath, scriptType, runAs, uc)
 	if err != nil {
 		return err
 	}
 
 	// Handle background execution
-	if doNotWait && !isPreflight {
+	if background {
 		return se.handleBackgroundExecution(cmd, scriptPath, scriptType, trackBackgroundProcesses)
 	}
 
 	// Execute and handle result
-	return se.executeAndHandleResult(cmd, scriptPath, scriptType, isPreflight)
+	if se.stop != nil {
+		if out == nil {
+			out = &scriptOutput{stdout: io.Discard, stderr: io.Discard}
+		}
+		out.stop = se.stop
+	}
+	return se.executeAndHandleResult(cmd, scriptPath, scriptType, isPreflight, out)
+}
+
+// SetStop makes closing stop cancel any foreground script that is running
+// or started afterwards, as a per-call cancel channel does. Background
+// (donotwait) scripts are detached by design and keep running.
+func (se *ScriptExecutor) SetStop(stop <-chan struct{}) {
+	se.stop = stop
+}
+
+// SetLogDir saves the full output of every foreground script to its own file
+// in dir. Background (donotwait) scripts are not captured.
+func (se *ScriptExecutor) SetLogDir(dir string) {
+	se.logDir = dir
 }
 
 // WaitForBackgroundProcesses waits for all background processes to complete
@@ -137,6 +248,11 @@ func (se *ScriptExecutor) GetBackgroundProcessCount() int {
 	return se.processTracker.GetActiveCount()
 }
 
+// GetBackgroundStatus returns the status of every tracked background process
+func (se *ScriptExecutor) GetBackgroundStatus() []utils.ProcessStatus {
+	return se.processTracker.Status()
+}
+
 // getCurrentLoggedInUserUID returns the UID of the currently logged-in user
 func (se *ScriptExecutor) getCurrentLoggedInUserUID() (string, error) {
 	return utils.GetConsoleUserUID()
@@ -178,8 +294,17 @@ func (se *ScriptExecutor) validateAndPrepareScript(scriptPath string) error {
 	return nil
 }
 
-// createScriptCommand creates and configures the appropriate command for script execution
-func (se *ScriptExecutor) createScriptCommand(scriptPath, scriptType string) (*exec.Cmd, error) {
+// createScriptCommand creates and configures the appropriate command for script execution.
+// Userscripts run as the console user with uc's environment; rootscripts run
+// as root unless runAs names another user.
+func (se *ScriptExecutor) createScriptCommand(scriptPath, scriptType, runAs string, uc UserContext) (*exec.Cmd, error) {
+	if runAs != "" {
+		if scriptType != "rootscript" {
+			return nil, fmt.Errorf("run_as only applies to rootscripts, not %s", scriptType)
+		}
+		return se.createRunAsCommand(scriptPath, runAs)
+	}
+
 	var cmd *exec.Cmd
 
 	switch scriptType {
@@ -198,16 +323,24 @@ func (se *ScriptExecutor) createScriptCommand(scriptPath, scriptType string) (*e
 	case "userscript":
 		// User-context scripts
 		if se.isAgentMode {
+			// The agent already runs as the user in their Aqua session
 			se.logger.Debug("Running userscript as user (agent mode)")
+			uc = uc.withUser(strconv.Itoa(os.Getuid()), true)
 			cmd = exec.Command(scriptPath)
+			cmd.Env = append(os.Environ(), uc.Env()...)
 		} else {
-			// Standalone mode: use launchctl asuser to execute as logged-in user
+			// Standalone mode: launchctl asuser enters the user's launchd
+			// context and sudo drops to the user; env carries the variables
+			// across sudo's environment reset
 			se.logger.Debug("Running userscript as logged-in user via launchctl asuser (standalone mode)")
 			userUID, err := se.getCurrentLoggedInUserUID()
 			if err != nil {
 				return nil, fmt.Errorf("failed to get user UID for userscript: %w", err)
 			}
-			cmd = exec.Command("launchctl", "asuser", userUID, scriptPath)
+			uc = uc.withUser(userUID, false)
+			args := []string{"asuser", userUID, "sudo", "-u", "#" + userUID, "/usr/bin/env"}
+			args = append(args, uc.Env()...)
+			cmd = exec.Command("launchctl", append(args, scriptPath)...)
 		}
 	default:
 		return nil, fmt.Errorf("unknown script type: %s", scriptType)
@@ -221,8 +354,39 @@ func (se *ScriptExecutor) createScriptCommand(scriptPath, scriptType string) (*e
 	return cmd, nil
 }
 
+// createRunAsCommand builds the command that runs a rootscript as runAs.
+// The console user gets the script in their launchd session, like a
+// standalone userscript; any other account is switched to with sudo, with
+// HOME set to that account's home.
+func (se *ScriptExecutor) createRunAsCommand(scriptPath, runAs string) (*exec.Cmd, error) {
+	if se.isAgentMode {
+		return nil, fmt.Errorf("run_as %s needs root; the agent cannot switch users", runAs)
+	}
+	var cmd *exec.Cmd
+	if runAs == config.RunAsConsole {
+		uid, err := se.getCurrentLoggedInUserUID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the console user for run_as: %w", err)
+		}
+		if uid == "" || uid == "0" {
+			return nil, fmt.Errorf("run_as console: no user is logged in")
+		}
+		se.logger.Debug("Running rootscript as console user %s via launchctl asuser", uid)
+		cmd = exec.Command("launchctl", "asuser", uid, "sudo", "-H", "-u", "#"+uid, scriptPath)
+	} else {
+		se.logger.Debug("Running rootscript as %s via sudo", runAs)
+		cmd = exec.Command("sudo", "-H", "-u", runAs, scriptPath)
+	}
+	cmd.Dir = filepath.Dir(scriptPath)
+	se.logger.Verbose("Executing command: %s", cmd.String())
+	return cmd, nil
+}
+
 // handleBackgroundExecution handles script execution in background mode
 func (se *ScriptExecutor) handleBackgroundExecution(cmd *exec.Cmd, scriptPath, scriptType string, trackBackgroundProcesses bool) error {
+	// Own session so a timeout kills everything the script spawned, and the
+	// script outlives the signals sent to this process
+	utils.StartInOwnSession(cmd)
 	if trackBackgroundProcesses {
 		// Modern mode: Track the background process
 		se.logger.Info("Starting script in background (tracked): %s", scriptPath)
@@ -239,15 +403,28 @@ func (se *ScriptExecutor) handleBackgroundExecution(cmd *exec.Cmd, scriptPath, s
 }
 
 // executeAndHandleResult executes the command and handles the result based on context
-func (se *ScriptExecutor) executeAndHandleResult(cmd *exec.Cmd, scriptPath, scriptType string, isPreflight bool) error {
+func (se *ScriptExecutor) executeAndHandleResult(cmd *exec.Cmd, scriptPath, scriptType string, isPreflight bool, out *scriptOutput) error {
 	// Normal execution: wait for completion
-	output, err := cmd.CombinedOutput()
+	output, err := out.run(cmd)
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The script itself succeeded; what it left running is not waited for
+		se.logger.Info("⚠️  %s exited but left processes holding its output open; not waiting for them", scriptPath)
+		err = nil
+	}
+	logRun(se.logger, se.logDir, scriptPath, cmd.String(), output, err)
 
 	// Preflight: exit 0 triggers cleanup and exit; non-zero continues bootstrap
 	if isPreflight && scriptType == "rootscript" {
 		return se.handlePreflightResult(err, output)
 	}
 
+	var cancelled *ScriptCancelledError
+	if errors.As(err, &cancelled) {
+		se.logger.Info("⛔ Script cancelled: %s", scriptPath)
+		se.logger.Debug("Script output before cancellation: %s", string(output))
+		return err
+	}
+
 	// Normal script execution (non-preflight)
 	if err != nil {
 		se.logger.Error("Script execution failed: %v", err)
@@ -265,6 +442,73 @@ func (se *ScriptExecutor) executeAndHandleResult(cmd *exec.Cmd, scriptPath, scri
 	return nil
 }
 
+// scriptOutput receives a script's output while it runs
+type scriptOutput struct {
+	stdout, stderr io.Writer
+	// cancel, when closed, stops the script
+	cancel <-chan struct{}
+	// stop is the executor-wide cancel channel (SetStop)
+	stop <-chan struct{}
+}
+
+// run runs cmd and returns its combined output. With a non-nil receiver the
+// output is also copied to stdout/stderr as it is produced.
+//
+// The script runs in a session and process group of its own: cancelling it
+// stops everything it spawned (which would otherwise keep the output pipes,
+// and Wait, open), and once the script itself has exited, children it left
+// running in the background are given stragglerWait to close the pipes
+// before run stops waiting for them and returns exec.ErrWaitDelay.
+func (o *scriptOutput) run(cmd *exec.Cmd) ([]byte, error) {
+	combined := &lockedBuffer{}
+	cmd.Stdout, cmd.Stderr = combined, combined
+	var cancel, stop <-chan struct{}
+	if o != nil {
+		cmd.Stdout = io.MultiWriter(combined, o.stdout)
+		cmd.Stderr = io.MultiWriter(combined, o.stderr)
+		cancel, stop = o.cancel, o.stop
+	}
+	utils.StartInOwnSession(cmd)
+	cmd.WaitDelay = stragglerWait
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	var waitErr error
+	exited := make(chan struct{})
+	go func() {
+		waitErr = cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+		return combined.Bytes(), waitErr
+	case <-cancel:
+	case <-stop:
+	}
+	utils.TerminateTree(cmd.Process.Pid, exited, cancelGracePeriod)
+	<-exited
+	return combined.Bytes(), &ScriptCancelledError{Output: combined.Bytes()}
+}
+
+// lockedBuffer is a bytes.Buffer safe for the concurrent stdout and stderr
+// copies of exec.Cmd
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
+
 // handlePreflightResult handles the special preflight exit code logic
 func (se *ScriptExecutor) handlePreflightResult(err error, output []byte) error {
 	if err != nil {
@@ -1,10 +1,17 @@
 package installer
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-installapplications/pkg/audit"
 	"github.com/go-installapplications/pkg/utils"
 )
 
@@ -72,3 +79,146 @@ func TestPreflightSuccessError(t *testing.T) {
 		t.Fatalf("Error() should be non-empty")
 	}
 }
+
+// Executed scripts are recorded in the audit log with their hash and outcome.
+func TestExecuteScript_RecordsAudit(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	l, err := audit.Open(logPath, "standalone")
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	prev := audit.SetDefault(l)
+	defer func() { audit.SetDefault(prev); l.Close() }()
+
+	path := writeScript(t, "#!/bin/sh\nexit 3\n")
+	se := NewScriptExecutor(false, utils.NewLogger(false, false), false)
+	if err := se.ExecuteScript(path, "rootscript", false, false); err == nil {
+		t.Fatalf("expected failing script to return an error")
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	var e audit.Event
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatalf("decode %q: %v", data, err)
+	}
+	if e.Action != audit.ActionScriptExecute || e.Target != path || e.Outcome != audit.OutcomeFailure ||
+		e.SHA256 != audit.FileSHA256(path) || e.SHA256 == "" || e.Details["type"] != "rootscript" {
+		t.Fatalf("unexpected audit event: %+v", e)
+	}
+}
+
+func TestExecuteScriptStreaming_CancelKillsProcessGroup(t *testing.T) {
+	defer func(d time.Duration) { cancelGracePeriod = d }(cancelGracePeriod)
+	cancelGracePeriod = 200 * time.Millisecond
+
+	// The child ignores SIGTERM and holds the output pipe open, so only a
+	// process group SIGKILL lets the script return
+	path := writeScript(t, "#!/bin/sh\necho before\nsh -c 'trap \"\" TERM; sleep 30' &\nwait\n")
+	se := NewScriptExecutor(false, utils.NewLogger(false, false), false)
+	cancel := make(chan struct{})
+	time.AfterFunc(300*time.Millisecond, func() { close(cancel) })
+
+	start := time.Now()
+	err := se.ExecuteScriptStreaming(path, "rootscript", false, false, io.Discard, io.Discard, cancel)
+	var cancelled *ScriptCancelledError
+	if !errors.As(err, &cancelled) {
+		t.Fatalf("expected ScriptCancelledError, got %v", err)
+	}
+	if !strings.Contains(string(cancelled.Output), "before") {
+		t.Fatalf("expected partial output, got %q", cancelled.Output)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("cancel took %v", elapsed)
+	}
+}
+
+func TestExecuteUserScript_SetsUserContextEnv(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "env.txt")
+	path := writeScript(t, "#!/bin/sh\nenv > '"+out+"'\n")
+	se := NewScriptExecutor(false, utils.NewLogger(false, false), true)
+	uc := UserContext{InstallPath: "/Library/installapplications", ItemName: "Dock setup"}
+	if err := se.ExecuteUserScript(path, uc, false, false); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read env: %v", err)
+	}
+	env := string(data)
+	for _, want := range []string{
+		EnvItemName + "=Dock setup",
+		EnvInstallPath + "=/Library/installapplications",
+		EnvConsoleUID + "=" + strconv.Itoa(os.Getuid()),
+	} {
+		if !strings.Contains(env, want+"\n") {
+			t.Fatalf("missing %q in environment:\n%s", want, env)
+		}
+	}
+}
+
+func TestUserContextEnv_SkipsEmptyValues(t *testing.T) {
+	env := UserContext{UID: "501", User: "ada", ItemName: "x"}.Env()
+	want := []string{"USER=ada", "LOGNAME=ada", EnvConsoleUID + "=501", EnvItemName + "=x"}
+	if strings.Join(env, ",") != strings.Join(want, ",") {
+		t.Fatalf("Env() = %v, want %v", env, want)
+	}
+}
+
+// SetStop cancels foreground scripts that were started without a per-call
+// cancel channel.
+func TestExecuteScript_StopCancelsForegroundScript(t *testing.T) {
+	path := writeScript(t, "#!/bin/sh\nsleep 30\n")
+	se := NewScriptExecutor(false, utils.NewLogger(false, false), false)
+	stop := make(chan struct{})
+	se.SetStop(stop)
+	time.AfterFunc(200*time.Millisecond, func() { close(stop) })
+
+	start := time.Now()
+	err := se.ExecuteScript(path, "rootscript", false, false)
+	var cancelled *ScriptCancelledError
+	if !errors.As(err, &cancelled) {
+		t.Fatalf("expected ScriptCancelledError, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("stop took %v", elapsed)
+	}
+}
+
+func TestCreateRunAsCommand(t *testing.T) {
+	se := NewScriptExecutor(false, utils.NewLogger(false, false), false)
+	cmd, err := se.createScriptCommand("/tmp/ia/svc.sh", "rootscript", "_svc", UserContext{})
+	if err != nil {
+		t.Fatalf("command: %v", err)
+	}
+	if got, want := strings.Join(cmd.Args, " "), "sudo -H -u _svc /tmp/ia/svc.sh"; got != want || cmd.Dir != "/tmp/ia" {
+		t.Errorf("args = %q in %s, want %q in /tmp/ia", got, cmd.Dir, want)
+	}
+
+	if _, err := se.createScriptCommand("/tmp/ia/u.sh", "userscript", "_svc", UserContext{}); err == nil {
+		t.Error("run_as on a userscript should be refused")
+	}
+	agent := NewScriptExecutor(false, utils.NewLogger(false, false), true)
+	if _, err := agent.createScriptCommand("/tmp/ia/svc.sh", "rootscript", "_svc", UserContext{}); err == nil {
+		t.Error("the agent cannot switch users")
+	}
+}
+
+// A script that leaves a child holding its output open still returns once
+// it has exited itself.
+func TestExecuteScript_DoesNotWaitForStragglers(t *testing.T) {
+	defer func(d time.Duration) { stragglerWait = d }(stragglerWait)
+	stragglerWait = 200 * time.Millisecond
+
+	path := writeScript(t, "#!/bin/sh\necho started\nsleep 5 &\nexit 0\n")
+	se := NewScriptExecutor(false, utils.NewLogger(false, false), false)
+	start := time.Now()
+	if err := se.ExecuteScript(path, "rootscript", false, false); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("waited %v for the background child", elapsed)
+	}
+}
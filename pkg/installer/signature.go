@@ -0,0 +1,74 @@
+package installer
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-installapplications/pkg/retry"
+)
+
+// leafTeamID matches the first certificate of `pkgutil --check-signature`'s
+// chain, e.g. "1. Developer ID Installer: Example Corp (ABCDE12345)".
+var leafTeamID = regexp.MustCompile(`(?m)^\s*1\.\s.*\(([A-Z0-9]{10})\)\s*$`)
+
+// verifySignature checks a package before it is installed, whatever its hash
+// says: pkgutil must find a valid signature, Gatekeeper (spctl) must accept
+// it for install, which for Developer ID packages means notarized, and when
+// teamIDs is not empty the signing Team ID must be one of them. It returns
+// the Team ID.
+func (pi *PackageInstaller) verifySignature(pkgPath string, teamIDs []string) (string, error) {
+	out, err := pi.run([]string{"pkgutil", "--check-signature", pkgPath})
+	if err != nil {
+		return "", fmt.Errorf("package is unsigned or its signature is invalid: %w", err)
+	}
+	pi.logger.Verbose("pkgutil --check-signature %s:\n%s", pkgPath, out)
+	m := leafTeamID.FindStringSubmatch(out)
+	if m == nil {
+		return "", fmt.Errorf("no Team ID in the package signature")
+	}
+	teamID := m[1]
+	if _, err := pi.run([]string{"spctl", "--assess", "--type", "install", pkgPath}); err != nil {
+		return teamID, fmt.Errorf("rejected by Gatekeeper (not notarized?): %w", err)
+	}
+	if len(teamIDs) > 0 && !slices.Contains(teamIDs, teamID) {
+		return teamID, fmt.Errorf("signed by Team ID %s, which is not allowed (allowed: %v)", teamID, teamIDs)
+	}
+	return teamID, nil
+}
+
+// codeRequirement is the code requirement a placed file must satisfy for
+// opts: signed through Apple (Developer ID or App Store) by the Team ID,
+// with the signing identifier. Empty when neither is set.
+func codeRequirement(opts FileOptions) string {
+	var parts []string
+	if opts.TeamID != "" {
+		parts = append(parts, "anchor apple generic", fmt.Sprintf(`certificate leaf[subject.OU] = "%s"`, opts.TeamID))
+	}
+	if opts.SigningID != "" {
+		parts = append(parts, fmt.Sprintf(`identifier "%s"`, opts.SigningID))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// VerifyCodeSignature checks a placed file before the item succeeds:
+// codesign must find a valid signature (--strict, so a modified bundle
+// fails too) that satisfies the Team ID and signing identifier of opts.
+func (fp *FilePlacer) VerifyCodeSignature(filePath string, opts FileOptions) error {
+	if fp.dryRun {
+		fp.logger.Info("[DRY RUN] Would verify the code signature of %s", filePath)
+		return nil
+	}
+	args := []string{"codesign", "--verify", "--strict"}
+	if req := codeRequirement(opts); req != "" {
+		// A leading "=" makes codesign read the requirement as text
+		args = append(args, "-R", "="+req)
+	}
+	if _, err := fp.run(append(args, filePath)); err != nil {
+		fp.logger.Error("❌ Code signature check failed for %s: %v", filePath, err)
+		return retry.Tag(retry.CategoryValidation, fmt.Errorf("code signature verification failed for %s: %w", filePath, err))
+	}
+	fp.logger.Info("🔏 Code signature verified: %s", filePath)
+	return nil
+}
@@ -0,0 +1,104 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/utils"
+)
+
+const signedOutput = `Package "Example.pkg":
+   Status: signed by a developer certificate issued by Apple for distribution
+   Notarization: trusted by the Apple notary service
+   Signed with a trusted timestamp on: 2024-01-01 00:00:00 +0000
+   Certificate Chain:
+    1. Developer ID Installer: Example Corp (ABCDE12345)
+       Expires: 2027-02-01 22:12:15 +0000
+    2. Developer ID Certification Authority
+    3. Apple Root CA`
+
+// fakeSignatureRunner answers pkgutil with pkgutilOut (failing when it is
+// empty) and fails spctl when spctlErr is set.
+func fakeSignatureRunner(pkgutilOut string, spctlErr error) func(args []string) (string, error) {
+	return func(args []string) (string, error) {
+		switch args[0] {
+		case "pkgutil":
+			if pkgutilOut == "" {
+				return "", fmt.Errorf("exit status 1: ")
+			}
+			return pkgutilOut, nil
+		case "spctl":
+			return "", spctlErr
+		}
+		return "", fmt.Errorf("unexpected command %v", args)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		pkgutil  string
+		spctlErr error
+		teamIDs  []string
+		wantErr  string
+	}{
+		{name: "signed and notarized", pkgutil: signedOutput},
+		{name: "allowed team", pkgutil: signedOutput, teamIDs: []string{"XYZ9876543", "ABCDE12345"}},
+		{name: "other team", pkgutil: signedOutput, teamIDs: []string{"XYZ9876543"}, wantErr: "not allowed"},
+		{name: "unsigned", wantErr: "unsigned"},
+		{name: "not notarized", pkgutil: signedOutput, spctlErr: fmt.Errorf("exit status 3"), wantErr: "Gatekeeper"},
+		{name: "no team id", pkgutil: "   Status: signed Apple Software\n   Certificate Chain:\n    1. Software Signing", wantErr: "no Team ID"},
+	} {
+		pi := NewPackageInstaller(false, utils.NewLogger(false, false), false)
+		pi.run = fakeSignatureRunner(tc.pkgutil, tc.spctlErr)
+		teamID, err := pi.verifySignature("/tmp/Example.pkg", tc.teamIDs)
+		if tc.wantErr == "" {
+			if err != nil || teamID != "ABCDE12345" {
+				t.Errorf("%s: got %q, %v", tc.name, teamID, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: error %v, want one containing %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestVerifyCodeSignature(t *testing.T) {
+	fp := NewFilePlacer(false, utils.NewLogger(false, false), false)
+	var got []string
+	fp.run = func(args []string) (string, error) {
+		got = args
+		return "", nil
+	}
+	if err := fp.VerifyCodeSignature("/tmp/helper", FileOptions{VerifySignature: true}); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if strings.Join(got, " ") != "codesign --verify --strict /tmp/helper" {
+		t.Errorf("args = %q", got)
+	}
+
+	err := fp.VerifyCodeSignature("/tmp/helper", FileOptions{VerifySignature: true, TeamID: "ABCDE12345", SigningID: "com.example.helper"})
+	want := []string{"codesign", "--verify", "--strict", "-R",
+		`=anchor apple generic and certificate leaf[subject.OU] = "ABCDE12345" and identifier "com.example.helper"`, "/tmp/helper"}
+	if err != nil || strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("args = %q, %v; want %q", got, err, want)
+	}
+}
+
+func TestPlaceFile_RejectsBadCodeSignature(t *testing.T) {
+	fp := NewFilePlacer(false, utils.NewLogger(false, false), false)
+	fp.run = func(args []string) (string, error) {
+		return "", fmt.Errorf("exit status 3: test-requirement: code failed to satisfy specified code requirement(s)")
+	}
+	path := writeTempFile(t, "helper", 0600)
+	err := fp.PlaceFile(path, "rootfile", FileOptions{Mode: 0755, VerifySignature: true, TeamID: "ABCDE12345"})
+	if err == nil || !strings.Contains(err.Error(), "code signature") {
+		t.Fatalf("err = %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("rejected file was made %v", info.Mode().Perm())
+	}
+}
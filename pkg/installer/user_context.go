@@ -0,0 +1,60 @@
+package installer
+
+import (
+	"os"
+	"os/user"
+)
+
+// Environment variables set for userscripts, in addition to USER, LOGNAME
+// and HOME for the console user.
+const (
+	EnvConsoleUID  = "INSTALLAPPLICATIONS_CONSOLE_UID"
+	EnvSessionID   = "INSTALLAPPLICATIONS_SESSION_ID"
+	EnvInstallPath = "INSTALLAPPLICATIONS_INSTALL_PATH"
+	EnvItemName    = "INSTALLAPPLICATIONS_ITEM_NAME"
+)
+
+// UserContext describes the user session a userscript runs in. Callers set
+// InstallPath and ItemName; the executor fills in the user.
+type UserContext struct {
+	UID  string
+	User string
+	Home string
+	// SessionID is the Aqua security session ID (SECURITYSESSIONID), when
+	// known
+	SessionID   string
+	InstallPath string
+	ItemName    string
+}
+
+// Env returns the userscript environment variables for uc. Empty values are
+// left out so the inherited environment is not clobbered.
+func (uc UserContext) Env() []string {
+	var env []string
+	add := func(key, value string) {
+		if value != "" {
+			env = append(env, key+"="+value)
+		}
+	}
+	add("USER", uc.User)
+	add("LOGNAME", uc.User)
+	add("HOME", uc.Home)
+	add(EnvConsoleUID, uc.UID)
+	add(EnvSessionID, uc.SessionID)
+	add(EnvInstallPath, uc.InstallPath)
+	add(EnvItemName, uc.ItemName)
+	return env
+}
+
+// withUser fills in the account for uid. The agent also knows its session
+// ID, which launchd sets for processes in the user's Aqua session.
+func (uc UserContext) withUser(uid string, agent bool) UserContext {
+	uc.UID = uid
+	if u, err := user.LookupId(uid); err == nil {
+		uc.User, uc.Home = u.Username, u.HomeDir
+	}
+	if agent {
+		uc.SessionID = os.Getenv("SECURITYSESSIONID")
+	}
+	return uc
+}
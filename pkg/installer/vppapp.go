@@ -0,0 +1,204 @@
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-installapplications/pkg/audit"
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+	"github.com/go-installapplications/pkg/version"
+)
+
+// VPPAppOptions configure the install of a VPP-licensed App Store app
+type VPPAppOptions struct {
+	Name     string
+	AdamID   string
+	BundleID string
+	// AppPath is where the app lands. When set, the install is skipped if
+	// it exists and otherwise waited for until Timeout.
+	AppPath string
+	// HookURL receives the install request, with Headers
+	HookURL string
+	Headers map[string]string
+	Timeout time.Duration
+	// DoNotWait returns as soon as the hook has accepted the request
+	DoNotWait bool
+}
+
+// VPPAppOptionsFor builds the install options of a vppapp item from the
+// VPPInstall* configuration keys
+func VPPAppOptionsFor(item config.Item, cfg *config.Config) VPPAppOptions {
+	return VPPAppOptions{
+		Name:      item.Name,
+		AdamID:    item.AdamID,
+		BundleID:  item.BundleID,
+		AppPath:   item.File,
+		HookURL:   cfg.VPPInstallURL,
+		Headers:   cfg.VPPInstallHeaders,
+		Timeout:   cfg.VPPInstallTimeout,
+		DoNotWait: item.DoNotWait,
+	}
+}
+
+// vppInstallRequest is POSTed to the hook, which sends the device an MDM
+// InstallApplication command for the app
+type vppInstallRequest struct {
+	RequestType   string `json:"request_type"`
+	UDID          string `json:"udid"`
+	SerialNumber  string `json:"serial_number,omitempty"`
+	ITunesStoreID uint64 `json:"itunes_store_id"`
+	BundleID      string `json:"bundle_id,omitempty"`
+	Name          string `json:"name,omitempty"`
+}
+
+// AppStoreInstaller asks the MDM to install App Store apps for vppapp items
+type AppStoreInstaller struct {
+	dryRun bool
+	logger utils.Logger
+	client *http.Client
+	// device returns this Mac's UDID and serial number; swapped out in tests
+	device func() (udid, serial string, err error)
+	// poll is how often AppPath is checked while waiting
+	poll time.Duration
+	// stop, when closed, abandons the wait for an app (see SetStop)
+	stop <-chan struct{}
+}
+
+// NewAppStoreInstaller creates a new App Store installer
+func NewAppStoreInstaller(dryRun bool, logger utils.Logger) *AppStoreInstaller {
+	return &AppStoreInstaller{
+		dryRun: dryRun,
+		logger: logger,
+		client: &http.Client{Timeout: 30 * time.Second},
+		device: func() (string, string, error) {
+			udid, err := utils.GetHardwareUUID()
+			if err != nil {
+				return "", "", err
+			}
+			serial, _ := utils.GetSerialNumber()
+			return udid, serial, nil
+		},
+		poll: 10 * time.Second,
+	}
+}
+
+// SetStop makes closing stop abandon the wait for an app
+func (ai *AppStoreInstaller) SetStop(stop <-chan struct{}) {
+	ai.stop = stop
+}
+
+// Install requests the app from the MDM and, unless DoNotWait is set or
+// there is no AppPath to watch, waits for it to be installed. The MDM
+// queues the command and the App Store installs the app in its own time, so
+// the wait is what keeps the items after it in order.
+func (ai *AppStoreInstaller) Install(opts VPPAppOptions) error {
+	if opts.AppPath != "" {
+		if _, err := os.Stat(opts.AppPath); err == nil {
+			ai.logger.Info("⏭️  %s is already installed: %s", opts.Name, opts.AppPath)
+			return nil
+		}
+	}
+	if opts.HookURL == "" {
+		return fmt.Errorf("VPPInstallURL is not configured")
+	}
+	storeID, err := strconv.ParseUint(opts.AdamID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid adam_id %q: %w", opts.AdamID, err)
+	}
+
+	event := audit.Event{
+		Action:  audit.ActionAppInstall,
+		Target:  opts.AdamID,
+		Details: map[string]string{"name": opts.Name},
+	}
+	if opts.BundleID != "" {
+		event.Details["bundle_id"] = opts.BundleID
+	}
+	if ai.dryRun {
+		ai.logger.Info("[DRY RUN] Would request App Store app %s (%s) from %s", opts.Name, opts.AdamID, opts.HookURL)
+		event.Outcome = audit.OutcomeDryRun
+		audit.Record(event)
+		return nil
+	}
+
+	udid, serial, err := ai.device()
+	if err != nil {
+		return fmt.Errorf("failed to identify this Mac for the MDM: %w", err)
+	}
+	err = ai.request(opts, vppInstallRequest{
+		RequestType:   "InstallApplication",
+		UDID:          udid,
+		SerialNumber:  serial,
+		ITunesStoreID: storeID,
+		BundleID:      opts.BundleID,
+		Name:          opts.Name,
+	})
+	if err != nil {
+		event.Outcome, event.Error = audit.OutcomeFailure, err.Error()
+		audit.Record(event)
+		return fmt.Errorf("failed to request %s from the MDM: %w", opts.Name, err)
+	}
+	ai.logger.Info("Requested App Store app %s (%s) from the MDM", opts.Name, opts.AdamID)
+
+	if opts.DoNotWait || opts.AppPath == "" {
+		event.Outcome = audit.OutcomeStarted
+		audit.Record(event)
+		return nil
+	}
+	err = ai.waitFor(opts.AppPath, opts.Timeout)
+	event.Outcome, event.Error = audit.Outcome(err), audit.ErrorString(err)
+	audit.Record(event)
+	return err
+}
+
+func (ai *AppStoreInstaller) request(opts VPPAppOptions, body vppInstallRequest) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, opts.HookURL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+	for k, v := range opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := ai.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("install request failed with status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// waitFor polls until path exists, timeout passes or the run is stopped
+func (ai *AppStoreInstaller) waitFor(path string, timeout time.Duration) error {
+	ai.logger.Info("Waiting up to %s for %s", timeout, path)
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(ai.poll)
+	defer ticker.Stop()
+	for {
+		if _, err := os.Stat(path); err == nil {
+			ai.logger.Info("App Store app installed: %s", path)
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return fmt.Errorf("%s did not appear within %s", path, timeout)
+		case <-ai.stop:
+			return fmt.Errorf("stopped while waiting for %s", path)
+		}
+	}
+}
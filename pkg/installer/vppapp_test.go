@@ -0,0 +1,86 @@
+package installer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// newTestAppStore returns an installer for a fixed device that polls
+// quickly, and a hook that records the requests it receives.
+func newTestAppStore(t *testing.T, status int) (*AppStoreInstaller, *httptest.Server, *[]vppInstallRequest) {
+	t.Helper()
+	var got []vppInstallRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req vppInstallRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer t0k" {
+			t.Errorf("unexpected request: %s %v", r.Method, r.Header)
+		}
+		got = append(got, req)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	ai := NewAppStoreInstaller(false, utils.NewLogger(false, false))
+	ai.device = func() (string, string, error) { return "UDID-1", "C02TEST", nil }
+	ai.poll = 10 * time.Millisecond
+	return ai, srv, &got
+}
+
+func TestAppStoreInstall_RequestsAndWaits(t *testing.T) {
+	ai, srv, got := newTestAppStore(t, http.StatusAccepted)
+	app := filepath.Join(t.TempDir(), "Keynote.app")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		os.Mkdir(app, 0755)
+	}()
+	err := ai.Install(VPPAppOptions{
+		Name: "Keynote", AdamID: "409183694", BundleID: "com.apple.iWork.Keynote", AppPath: app,
+		HookURL: srv.URL, Headers: map[string]string{"Authorization": "Bearer t0k"}, Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	want := vppInstallRequest{RequestType: "InstallApplication", UDID: "UDID-1", SerialNumber: "C02TEST", ITunesStoreID: 409183694, BundleID: "com.apple.iWork.Keynote", Name: "Keynote"}
+	if len(*got) != 1 || (*got)[0] != want {
+		t.Fatalf("requests = %+v, want %+v", *got, want)
+	}
+}
+
+func TestAppStoreInstall_SkipsInstalledApp(t *testing.T) {
+	ai, srv, got := newTestAppStore(t, http.StatusOK)
+	app := t.TempDir()
+	if err := ai.Install(VPPAppOptions{Name: "Keynote", AdamID: "409183694", AppPath: app, HookURL: srv.URL}); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	if len(*got) != 0 {
+		t.Errorf("installed app requested again: %+v", *got)
+	}
+}
+
+func TestAppStoreInstall_Errors(t *testing.T) {
+	ai, srv, _ := newTestAppStore(t, http.StatusForbidden)
+	headers := map[string]string{"Authorization": "Bearer t0k"}
+	if err := ai.Install(VPPAppOptions{Name: "Keynote", AdamID: "409183694"}); err == nil || !strings.Contains(err.Error(), "VPPInstallURL") {
+		t.Errorf("no hook: err = %v", err)
+	}
+	if err := ai.Install(VPPAppOptions{Name: "Keynote", AdamID: "409183694", HookURL: srv.URL, Headers: headers}); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("rejected request: err = %v", err)
+	}
+
+	ai, srv, _ = newTestAppStore(t, http.StatusOK)
+	app := filepath.Join(t.TempDir(), "Keynote.app")
+	err := ai.Install(VPPAppOptions{Name: "Keynote", AdamID: "409183694", AppPath: app, HookURL: srv.URL, Headers: headers, Timeout: 30 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "did not appear") {
+		t.Errorf("timeout: err = %v", err)
+	}
+}
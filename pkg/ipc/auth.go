@@ -0,0 +1,217 @@
+package ipc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// keySize is the length of the per-run signing secret in bytes.
+const keySize = 32
+
+// replayWindow is how far behind the highest counter seen a request may
+// arrive. The daemon sends on several connections at once (progress updates
+// alongside item requests), so counters can be delivered slightly out of
+// order.
+const replayWindow = 64
+
+// KeyPathForSocket returns the path of the signing secret for an agent
+// socket: agent-<run>.sock is paired with agent-<run>.key.
+func KeyPathForSocket(sockPath string) string {
+	return strings.TrimSuffix(sockPath, ".sock") + ".key"
+}
+
+// CreateKey generates a fresh signing secret next to the agent socket. The
+// file is created 0600 and owned by the socket's owner (the console user),
+// so only root and the agent can read it. Any previous secret is replaced.
+func CreateKey(sockPath string) ([]byte, error) {
+	info, err := os.Stat(sockPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat agent socket: %w", err)
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("failed to read agent socket owner")
+	}
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	path := KeyPathForSocket(sockPath)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove old key: %w", err)
+	}
+	// O_EXCL so a file or symlink planted in the user's socket directory is
+	// never reused, and the owner is set on the open file for the same reason
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+	_, werr := f.WriteString(hex.EncodeToString(key))
+	if werr == nil && int(st.Uid) != os.Geteuid() {
+		if err := f.Chown(int(st.Uid), -1); err != nil {
+			werr = fmt.Errorf("failed to set key owner: %w", err)
+		}
+	}
+	if cerr := f.Close(); werr == nil {
+		werr = cerr
+	}
+	if werr != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write key: %w", werr)
+	}
+	return key, nil
+}
+
+// LoadKey reads the signing secret for an agent socket. The file must be
+// owned by root or the current user and not accessible to anyone else.
+func LoadKey(sockPath string) ([]byte, error) {
+	path := KeyPathForSocket(sockPath)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat key: %w", err)
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("key is not a regular file: %s", path)
+	}
+	if int(st.Uid) != 0 && int(st.Uid) != os.Geteuid() {
+		return nil, fmt.Errorf("key %s is owned by uid %d", path, st.Uid)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("key %s is accessible by other users (%v)", path, info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("malformed key: %s", path)
+	}
+	return key, nil
+}
+
+// requestMAC computes the HMAC-SHA256 of the request with its MAC field
+// cleared. Encoding the struct is deterministic since fields are marshalled
+// in declaration order.
+func requestMAC(key []byte, req RPCRequest) (string, error) {
+	req.MAC = ""
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Signer stamps requests with a counter and MAC. It is used by the daemon
+// and is safe for concurrent use.
+type Signer struct {
+	key []byte
+
+	mu      sync.Mutex
+	counter uint64
+}
+
+// NewSigner creates a Signer for key.
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// Sign sets req's Counter and MAC.
+func (s *Signer) Sign(req *RPCRequest) error {
+	s.mu.Lock()
+	s.counter++
+	req.Counter = s.counter
+	s.mu.Unlock()
+
+	mac, err := requestMAC(s.key, *req)
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	req.MAC = mac
+	return nil
+}
+
+// Verifier checks request MACs and rejects replayed counters. It is used by
+// the agent and is safe for concurrent use. The key is loaded on every
+// request because the daemon creates a new one each run; replay state resets
+// whenever the key changes.
+type Verifier struct {
+	load func() ([]byte, error)
+
+	mu      sync.Mutex
+	key     []byte
+	highest uint64
+	seen    uint64 // bit i set: counter highest-i was accepted
+}
+
+// NewVerifier creates a Verifier that reads its key from the file paired
+// with sockPath.
+func NewVerifier(sockPath string) *Verifier {
+	return &Verifier{load: func() ([]byte, error) { return LoadKey(sockPath) }}
+}
+
+// Verify returns an error unless req carries a valid MAC and a counter that
+// has not been accepted before.
+func (v *Verifier) Verify(req RPCRequest) error {
+	if req.MAC == "" {
+		return errors.New("request is not signed")
+	}
+	key, err := v.load()
+	if err != nil {
+		return err
+	}
+	want, err := requestMAC(key, req)
+	if err != nil {
+		return fmt.Errorf("failed to verify request: %w", err)
+	}
+	if !hmac.Equal([]byte(want), []byte(req.MAC)) {
+		return errors.New("invalid request signature")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !hmac.Equal(key, v.key) {
+		v.key, v.highest, v.seen = key, 0, 0
+	}
+	return v.accept(req.Counter)
+}
+
+// accept records counter in the sliding replay window. Callers hold v.mu.
+func (v *Verifier) accept(counter uint64) error {
+	switch {
+	case counter == 0:
+		return errors.New("request has no counter")
+	case counter > v.highest:
+		shift := counter - v.highest
+		if shift >= replayWindow {
+			v.seen = 0
+		} else {
+			v.seen <<= shift
+		}
+		v.seen |= 1
+		v.highest = counter
+		return nil
+	case v.highest-counter >= replayWindow:
+		return fmt.Errorf("request counter %d is too old", counter)
+	default:
+		bit := uint64(1) << (v.highest - counter)
+		if v.seen&bit != 0 {
+			return fmt.Errorf("replayed request counter %d", counter)
+		}
+		v.seen |= bit
+		return nil
+	}
+}
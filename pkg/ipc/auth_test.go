@@ -0,0 +1,190 @@
+package ipc
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// newKeyedSocket creates a listening socket with a fresh key beside it.
+func newKeyedSocket(t *testing.T) (string, []byte) {
+	t.Helper()
+	sockPath := filepath.Join(t.TempDir(), "agent-501.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	key, err := CreateKey(sockPath)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	return sockPath, key
+}
+
+func TestCreateKeyAndLoadKey(t *testing.T) {
+	sockPath, key := newKeyedSocket(t)
+	if got := KeyPathForSocket(sockPath); filepath.Base(got) != "agent-501.key" {
+		t.Fatalf("key path = %s", got)
+	}
+	loaded, err := LoadKey(sockPath)
+	if err != nil {
+		t.Fatalf("load key: %v", err)
+	}
+	if string(loaded) != string(key) {
+		t.Fatalf("loaded key differs from created key")
+	}
+
+	if err := os.Chmod(KeyPathForSocket(sockPath), 0644); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if _, err := LoadKey(sockPath); err == nil {
+		t.Fatalf("expected world-readable key to be rejected")
+	}
+}
+
+func TestCreateKeyDoesNotFollowPlantedSymlink(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "agent-501.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	if os.Geteuid() == 0 {
+		// The key goes to the socket's owner, as for a console user's agent
+		if err := os.Chown(sockPath, 65534, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	victim := filepath.Join(t.TempDir(), "victim")
+	if err := os.WriteFile(victim, []byte("root only"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(victim, KeyPathForSocket(sockPath)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateKey(sockPath); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	info, err := os.Lstat(KeyPathForSocket(sockPath))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("key = %v, %v; want a regular file", info, err)
+	}
+	if data, _ := os.ReadFile(victim); string(data) != "root only" {
+		t.Errorf("key written through the symlink: %q", data)
+	}
+	victimInfo, err := os.Stat(victim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := victimInfo.Sys().(*syscall.Stat_t).Uid; int(uid) != os.Geteuid() {
+		t.Errorf("symlink target chowned to %d", uid)
+	}
+	if os.Geteuid() == 0 {
+		if uid := info.Sys().(*syscall.Stat_t).Uid; uid != 65534 {
+			t.Errorf("key owned by %d, want the socket's owner 65534", uid)
+		}
+	}
+}
+
+func TestVerifier_AcceptsSignedRequests(t *testing.T) {
+	sockPath, key := newKeyedSocket(t)
+	signer := NewSigner(key)
+	verifier := NewVerifier(sockPath)
+
+	first := RPCRequest{ID: "1", Command: "RunUserScript", Path: "/tmp/a.sh"}
+	second := RPCRequest{ID: "2", Command: "Ping"}
+	if err := signer.Sign(&first); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if err := signer.Sign(&second); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	// Delivered out of order, as concurrent connections may be
+	if err := verifier.Verify(second); err != nil {
+		t.Fatalf("second: %v", err)
+	}
+	if err := verifier.Verify(first); err != nil {
+		t.Fatalf("first: %v", err)
+	}
+}
+
+func TestVerifier_RejectsForgedAndReplayedRequests(t *testing.T) {
+	sockPath, key := newKeyedSocket(t)
+	signer := NewSigner(key)
+	verifier := NewVerifier(sockPath)
+
+	req := RPCRequest{ID: "1", Command: "RunUserScript", Path: "/tmp/a.sh"}
+	if err := verifier.Verify(req); err == nil {
+		t.Fatalf("expected unsigned request to be rejected")
+	}
+	if err := signer.Sign(&req); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	tampered := req
+	tampered.Path = "/tmp/evil.sh"
+	if err := verifier.Verify(tampered); err == nil {
+		t.Fatalf("expected tampered request to be rejected")
+	}
+	forged := req
+	if err := NewSigner([]byte("not-the-key-not-the-key-not-the-")).Sign(&forged); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if err := verifier.Verify(forged); err == nil {
+		t.Fatalf("expected request signed with another key to be rejected")
+	}
+
+	if err := verifier.Verify(req); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := verifier.Verify(req); err == nil {
+		t.Fatalf("expected replayed request to be rejected")
+	}
+
+	// Counters far behind the newest accepted one are rejected
+	var latest RPCRequest
+	for i := 0; i < replayWindow+1; i++ {
+		latest = RPCRequest{Command: "Ping"}
+		if err := signer.Sign(&latest); err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+	}
+	if err := verifier.Verify(latest); err != nil {
+		t.Fatalf("verify latest: %v", err)
+	}
+	if err := verifier.accept(2); err == nil {
+		t.Fatalf("expected counter outside the window to be rejected")
+	}
+}
+
+func TestVerifier_ResetsOnNewKey(t *testing.T) {
+	sockPath, key := newKeyedSocket(t)
+	verifier := NewVerifier(sockPath)
+	req := RPCRequest{Command: "Ping"}
+	if err := NewSigner(key).Sign(&req); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if err := verifier.Verify(req); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// A restarted daemon creates a new key and starts counting from 1 again
+	newKey, err := CreateKey(sockPath)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	next := RPCRequest{Command: "Ping"}
+	if err := NewSigner(newKey).Sign(&next); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if err := verifier.Verify(next); err != nil {
+		t.Fatalf("verify after key rotation: %v", err)
+	}
+	if err := verifier.Verify(req); err == nil {
+		t.Fatalf("expected request signed with the old key to be rejected")
+	}
+}
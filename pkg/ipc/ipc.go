@@ -4,12 +4,24 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/go-installapplications/pkg/progress"
+	"github.com/go-installapplications/pkg/utils"
 )
 
-// SocketDir is the directory where agent sockets are created.
-// We use /var/tmp to avoid user-home coupling and keep paths stable across contexts.
-// Declared as a var so tests can redirect it without root access.
-var SocketDir = "/var/tmp/go-installapplications"
+// SocketDir holds the IPC state: the run file naming the daemon's current
+// run and a directory per user, SocketDir/<uid>, with that user's agent
+// socket and IPC key. SocketDir is owned by root and only root creates
+// entries in it; each user directory is owned by its user with mode 0700,
+// so no other user can plant or reach a socket there. /var/run is cleared
+// at boot. Declared as a var so tests can redirect it without root access.
+var SocketDir = "/var/run/go-installapplications"
+
+// runFileName is the file in SocketDir naming the daemon's current run.
+const runFileName = "run"
 
 // SocketDirVar returns the current SocketDir value (helper for tests).
 func SocketDirVar() string { return SocketDir }
@@ -17,69 +29,262 @@ func SocketDirVar() string { return SocketDir }
 // SetSocketDir overrides the socket directory location. Intended for tests.
 func SetSocketDir(path string) { SocketDir = path }
 
-// GetAgentSocketPathForUID returns the Unix domain socket path for a given user UID
-func GetAgentSocketPathForUID(uid string) string {
+// UserSocketDir returns the directory of uid's agent socket.
+func UserSocketDir(uid string) string {
 	if uid == "" {
 		uid = "unknown"
 	}
-	return filepath.Join(SocketDir, fmt.Sprintf("agent-%s.sock", uid))
+	return filepath.Join(SocketDir, uid)
+}
+
+// AgentSocketPath returns the socket of uid's agent during the daemon run
+// runID. Sockets are named per run, so a socket left behind by a crashed
+// run is never mistaken for the agent of the next one.
+func AgentSocketPath(uid, runID string) string {
+	return filepath.Join(UserSocketDir(uid), fmt.Sprintf("agent-%s.sock", runID))
+}
+
+// GetAgentSocketPathForUID returns the socket of uid's agent for the
+// daemon's current run (see CurrentRun).
+func GetAgentSocketPathForUID(uid string) (string, error) {
+	runID, err := CurrentRun()
+	if err != nil {
+		return "", err
+	}
+	return AgentSocketPath(uid, runID), nil
 }
 
-// EnsureSocketDir ensures the socket directory exists with safe permissions
-// that allow both root and regular users to create sockets.
+// EnsureSocketDir creates SocketDir owned by root with mode 0755, and
+// tightens the mode of one that already exists. Only root runs it.
 func EnsureSocketDir() error {
-	// Create directory if it doesn't exist
-	if err := os.MkdirAll(SocketDir, 0777); err != nil {
+	if err := os.MkdirAll(SocketDir, 0755); err != nil {
 		return fmt.Errorf("failed to create socket dir %s: %w", SocketDir, err)
 	}
+	if err := checkOwned(SocketDir, false); err != nil {
+		return err
+	}
+	if err := os.Chmod(SocketDir, 0755); err != nil {
+		return fmt.Errorf("failed to set socket dir permissions: %w", err)
+	}
+	return nil
+}
 
-	// Set world-writable permissions to allow both root and users to create sockets
-	// This is safe for /var/tmp as it's a temporary directory
-	if err := os.Chmod(SocketDir, 0777); err != nil {
+// EnsureUserSocketDir creates the socket directory of uid, owned by uid
+// with mode 0700, for its agent to listen in. Only root runs it.
+func EnsureUserSocketDir(uid string) error {
+	if err := EnsureSocketDir(); err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %q", uid)
+	}
+	dir := UserSocketDir(uid)
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to create socket dir %s: %w", dir, err)
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to stat socket dir %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("socket dir %s is not a directory", dir)
+	}
+	if err := os.Lchown(dir, id, -1); err != nil {
+		return fmt.Errorf("failed to set socket dir owner: %w", err)
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
 		return fmt.Errorf("failed to set socket dir permissions: %w", err)
 	}
+	return nil
+}
 
-	// Try to set ownership to root, but don't fail if we can't (e.g., regular user)
-	// This allows the daemon (root) to manage the directory when possible
-	if err := os.Chown(SocketDir, 0, 0); err != nil {
-		// Log but don't fail - this is expected when running as a regular user
-		// The directory will still work with world-writable permissions
+// CheckUserSocketDir verifies, before the agent listens in it, that the
+// socket directory of the current user is its own with mode 0700, inside a
+// SocketDir that only root can change.
+func CheckUserSocketDir(uid string) error {
+	if err := checkOwned(SocketDir, true); err != nil {
+		return err
+	}
+	dir := UserSocketDir(uid)
+	if err := checkOwned(dir, false); err != nil {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("socket dir %s is accessible by other users (%v)", dir, info.Mode().Perm())
 	}
+	return nil
+}
 
+// checkOwned returns an error unless path is a directory (not a symlink)
+// owned by root or the current user; with rootOnly, it must also not be
+// writable by group or others.
+func checkOwned(path string, rootOnly bool) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat socket dir: %w", err)
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || !info.IsDir() {
+		return fmt.Errorf("socket dir %s is not a directory", path)
+	}
+	if int(st.Uid) != 0 && int(st.Uid) != os.Geteuid() {
+		return fmt.Errorf("socket dir %s is owned by uid %d", path, st.Uid)
+	}
+	if rootOnly && info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("socket dir %s is writable by other users (%v)", path, info.Mode().Perm())
+	}
+	return nil
+}
+
+// StartRun begins a daemon run: it records runID in SocketDir for the
+// agents to name their sockets after, and removes the sockets and keys of
+// earlier runs. Only root runs it.
+func StartRun(runID string) error {
+	if err := EnsureSocketDir(); err != nil {
+		return err
+	}
+	path := filepath.Join(SocketDir, runFileName)
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+	if err := os.WriteFile(tmp, []byte(runID+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write run file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write run file: %w", err)
+	}
+	stale, _ := filepath.Glob(filepath.Join(SocketDir, "*", "agent-*"))
+	for _, p := range stale {
+		name := filepath.Base(p)
+		if name != "agent-"+runID+".sock" && name != "agent-"+runID+".key" {
+			_ = os.Remove(p)
+		}
+	}
 	return nil
 }
 
+// CurrentRun returns the run ID StartRun recorded. The run file must be
+// owned by root (or the current user) and writable by no one else.
+func CurrentRun() (string, error) {
+	path := filepath.Join(SocketDir, runFileName)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", fmt.Errorf("no daemon run: %w", err)
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("run file is not a regular file: %s", path)
+	}
+	if int(st.Uid) != 0 && int(st.Uid) != os.Geteuid() {
+		return "", fmt.Errorf("run file %s is owned by uid %d", path, st.Uid)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return "", fmt.Errorf("run file %s is writable by other users (%v)", path, info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read run file: %w", err)
+	}
+	runID := strings.TrimSpace(string(data))
+	if runID == "" || strings.ContainsAny(runID, "/. ") {
+		return "", fmt.Errorf("malformed run file: %s", path)
+	}
+	return runID, nil
+}
+
 // RPCRequest represents a request from the daemon to the agent.
 //
 // Supported commands:
+//   - Hello                      — exchange ProtocolVersion; the agent replies
+//                                  with its version and Commands (see Protocol)
 //   - Ping                       — readiness probe
 //   - Shutdown                   — request graceful exit (idempotent)
-//   - RunUserScript              — execute a userscript at Path (DoNotWait => background)
-//   - PlaceUserFile              — chmod a user file at Path
-//   - WaitForBackgroundProcesses — block until tracked donotwait processes
+//   - RunUserScript              — execute a userscript at Path (DoNotWait => background).
+//                                  ItemName is exported to the script's
+//                                  environment
+//                                  With Stream set, output lines are sent back
+//                                  as Partial responses while it runs
+//   - Cancel                     — stop the running RunUserScript whose
+//                                  request ID is Target; its process group
+//                                  is killed and the partial output returned
+//   - PlaceUserFile              — chmod a user file at Path (to Mode,
+//                                  if set) and apply StripQuarantine and
+//                                  XAttrs
+//   - TransferFile               — write the FileChunks that follow the
+//                                  request to Path ("~/" is the agent user's
+//                                  home) with Mode, as the agent's user.
+//                                  Size and SHA256 describe the content;
+//                                  missing directories get DirMode.
+//                                  StripQuarantine and XAttrs are applied
+//                                  before the file is moved into place
+//   - WaitForBackground          — block until tracked donotwait processes
 //                                  finish or TimeoutSeconds elapses
+//                                  (WaitForBackgroundProcesses is an alias)
 //   - GetBackgroundProcessCount  — return current tracked count in Count
+//   - GetBackgroundStatus        — return every tracked process in Background
+//                                  (running or exited, with runtime and error)
+//   - ShowProgress               — open the agent's progress UI with Progress
+//   - UpdateProgress             — apply a new Progress snapshot to the open UI
+//   - DismissProgress            — apply the final Progress snapshot and
+//                                  close (or unlock) the UI
+//   - Notify                     — show Message to the agent's user in a
+//                                  dialog that closes after TimeoutSeconds
+//
+// Every request is signed by the daemon (see Signer): Counter increases with
+// each request and MAC authenticates all other fields.
 type RPCRequest struct {
-	ID             string `json:"id"`
-	Command        string `json:"command"`
-	Path           string `json:"path,omitempty"`
-	Source         string `json:"source,omitempty"`
-	DoNotWait      bool   `json:"donotwait,omitempty"`
-	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
+	ID              string            `json:"id"`
+	Command         string            `json:"command"`
+	Path            string            `json:"path,omitempty"`
+	ItemName        string            `json:"itemName,omitempty"`
+	Mode            uint32            `json:"mode,omitempty"`
+	DirMode         uint32            `json:"dirMode,omitempty"`
+	StripQuarantine bool              `json:"stripQuarantine,omitempty"`
+	XAttrs          map[string]string `json:"xattrs,omitempty"`
+	Size            int64             `json:"size,omitempty"`
+	SHA256          string            `json:"sha256,omitempty"`
+	Source          string            `json:"source,omitempty"`
+	DoNotWait       bool              `json:"donotwait,omitempty"`
+	TimeoutSeconds  int               `json:"timeoutSeconds,omitempty"`
+	Progress        *progress.State   `json:"progress,omitempty"`
+	Stream          bool              `json:"stream,omitempty"`
+	Target          string            `json:"target,omitempty"`
+	Message         string            `json:"message,omitempty"`
+	ProtocolVersion int               `json:"protocolVersion,omitempty"`
+	Counter         uint64            `json:"counter,omitempty"`
+	MAC             string            `json:"mac,omitempty"`
 }
 
 // RPCResponse represents a response from the agent back to the daemon.
 //
 // Count carries the result of GetBackgroundProcessCount.
-// Errors carries per-process error strings from WaitForBackgroundProcesses
+// Background carries the result of GetBackgroundStatus.
+// Errors carries per-process error strings from WaitForBackground
 // (non-empty means at least one tracked process failed or timed out).
+// Partial responses carry one line of streamed script output in Output,
+// from OutputStream ("stdout" or "stderr"); the final response follows them
+// on the same connection.
+// Cancelled is set on the response to a RunUserScript that was stopped by
+// Cancel, and on the Cancel response itself; Output then holds the script's
+// output up to that point. ProtocolVersion and Commands answer Hello.
 type RPCResponse struct {
-	ID       string   `json:"id"`
-	OK       bool     `json:"ok"`
-	Started  bool     `json:"started,omitempty"`
-	ExitCode int      `json:"exitCode,omitempty"`
-	Output   string   `json:"output,omitempty"`
-	Error    string   `json:"error,omitempty"`
-	Count    int      `json:"count,omitempty"`
-	Errors   []string `json:"errors,omitempty"`
+	ID              string                `json:"id"`
+	OK              bool                  `json:"ok"`
+	Started         bool                  `json:"started,omitempty"`
+	ExitCode        int                   `json:"exitCode,omitempty"`
+	Output          string                `json:"output,omitempty"`
+	Error           string                `json:"error,omitempty"`
+	Count           int                   `json:"count,omitempty"`
+	Errors          []string              `json:"errors,omitempty"`
+	Background      []utils.ProcessStatus `json:"background,omitempty"`
+	Partial         bool                  `json:"partial,omitempty"`
+	OutputStream    string                `json:"outputStream,omitempty"`
+	Cancelled       bool                  `json:"cancelled,omitempty"`
+	ProtocolVersion int                   `json:"protocolVersion,omitempty"`
+	Commands        []string              `json:"commands,omitempty"`
 }
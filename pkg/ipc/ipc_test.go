@@ -1,42 +1,163 @@
 package ipc
 
 import (
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
 
+// useSocketDir points SocketDir at a temporary directory for one test.
+func useSocketDir(t *testing.T) string {
+	t.Helper()
+	original := SocketDirVar()
+	SetSocketDir(filepath.Join(t.TempDir(), "go-ia-sockets"))
+	t.Cleanup(func() { SetSocketDir(original) })
+	return SocketDirVar()
+}
+
 func TestGetAgentSocketPathForUID(t *testing.T) {
-	got := GetAgentSocketPathForUID("501")
-	want := filepath.Join(SocketDir, "agent-501.sock")
-	if got != want {
-		t.Fatalf("got %q want %q", got, want)
+	dir := useSocketDir(t)
+	if _, err := GetAgentSocketPathForUID("501"); err == nil {
+		t.Fatal("expected an error before the daemon starts a run")
+	}
+	if err := StartRun("run1"); err != nil {
+		t.Fatalf("start run: %v", err)
+	}
+	got, err := GetAgentSocketPathForUID("501")
+	want := filepath.Join(dir, "501", "agent-run1.sock")
+	if err != nil || got != want {
+		t.Fatalf("got %q, %v; want %q", got, err, want)
 	}
 
 	// Empty UID degrades to "unknown" rather than producing a malformed path
-	got = GetAgentSocketPathForUID("")
-	if !strings.HasSuffix(got, "agent-unknown.sock") {
+	if got := AgentSocketPath("", "run1"); !strings.HasSuffix(got, "unknown/agent-run1.sock") {
 		t.Fatalf("empty uid path = %q", got)
 	}
 }
 
 func TestEnsureSocketDir_CreatesDir(t *testing.T) {
-	// Use a temp directory under TMPDIR so tests don't depend on /var/tmp permissions.
-	tmp := t.TempDir()
-	// Swap the package's socket dir for the test.
-	original := SocketDirVar()
-	SetSocketDir(filepath.Join(tmp, "go-ia-sockets"))
-	t.Cleanup(func() { SetSocketDir(original) })
-
+	dir := useSocketDir(t)
 	if err := EnsureSocketDir(); err != nil {
 		t.Fatalf("ensure: %v", err)
 	}
-	info, err := os.Stat(SocketDirVar())
+	info, err := os.Stat(dir)
 	if err != nil {
 		t.Fatalf("stat: %v", err)
 	}
-	if !info.IsDir() {
-		t.Fatalf("expected directory")
+	if !info.IsDir() || info.Mode().Perm() != 0755 {
+		t.Fatalf("expected a 0755 directory, got %v", info.Mode())
+	}
+
+	// A world-writable directory left by an older version is tightened
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnsureSocketDir(); err != nil {
+		t.Fatalf("ensure: %v", err)
+	}
+	if info, _ := os.Stat(dir); info.Mode().Perm() != 0755 {
+		t.Fatalf("mode = %v, want 0755", info.Mode().Perm())
+	}
+}
+
+func TestEnsureUserSocketDir(t *testing.T) {
+	useSocketDir(t)
+	uid := strconv.Itoa(os.Getuid())
+	if err := CheckUserSocketDir(uid); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+	if err := EnsureUserSocketDir(uid); err != nil {
+		t.Fatalf("ensure: %v", err)
+	}
+	info, err := os.Stat(UserSocketDir(uid))
+	if err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("user socket dir: %v, %v", info, err)
+	}
+	if err := CheckUserSocketDir(uid); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+
+	if err := os.Chmod(UserSocketDir(uid), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckUserSocketDir(uid); err == nil {
+		t.Fatal("expected an error for a directory other users can read")
+	}
+	if err := EnsureUserSocketDir("nobody"); err == nil {
+		t.Fatal("expected an error for a non-numeric uid")
+	}
+}
+
+func TestStartRun_RemovesStaleSockets(t *testing.T) {
+	dir := useSocketDir(t)
+	stale := filepath.Join(dir, "501", "agent-old.sock")
+	staleKey := filepath.Join(dir, "501", "agent-old.key")
+	current := filepath.Join(dir, "501", "agent-run2.key")
+	if err := os.MkdirAll(filepath.Dir(stale), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{stale, staleKey, current} {
+		if err := os.WriteFile(p, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := StartRun("run2"); err != nil {
+		t.Fatalf("start run: %v", err)
+	}
+	if runID, err := CurrentRun(); err != nil || runID != "run2" {
+		t.Fatalf("current run = %q, %v", runID, err)
+	}
+	for _, p := range []string{stale, staleKey} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", p)
+		}
+	}
+	if _, err := os.Stat(current); err != nil {
+		t.Errorf("current run's key removed: %v", err)
+	}
+
+	// A run file others can write is not trusted
+	if err := os.Chmod(filepath.Join(dir, runFileName), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CurrentRun(); err == nil {
+		t.Fatal("expected an error for a world-writable run file")
+	}
+}
+
+func TestAuthorizePeer(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "peer.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+
+	client, err := net.Dial("unix", sockPath)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer server.Close()
+
+	uid, err := PeerUID(server)
+	if err != nil {
+		t.Fatalf("peer uid: %v", err)
+	}
+	if uid != os.Geteuid() {
+		t.Fatalf("peer uid = %d, want %d", uid, os.Geteuid())
+	}
+	if err := AuthorizePeer(server, 0, os.Geteuid()); err != nil {
+		t.Fatalf("own uid rejected: %v", err)
+	}
+	if err := AuthorizePeer(server, os.Geteuid()+1); err == nil {
+		t.Fatalf("expected other uid to be rejected")
 	}
 }
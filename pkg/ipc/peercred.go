@@ -0,0 +1,47 @@
+package ipc
+
+import (
+	"fmt"
+	"net"
+)
+
+// PeerUID returns the effective UID of the process at the other end of a
+// Unix domain socket connection, as recorded by the kernel when it connected
+// (LOCAL_PEERCRED on macOS, SO_PEERCRED on Linux).
+func PeerUID(conn net.Conn) (int, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return -1, fmt.Errorf("not a unix socket connection: %T", conn)
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return -1, fmt.Errorf("failed to access socket: %w", err)
+	}
+	uid := -1
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		uid, credErr = peerUID(int(fd))
+	}); err != nil {
+		return -1, fmt.Errorf("failed to access socket: %w", err)
+	}
+	if credErr != nil {
+		return -1, fmt.Errorf("failed to read peer credentials: %w", credErr)
+	}
+	return uid, nil
+}
+
+// AuthorizePeer returns an error unless the connection's peer runs as one of
+// the allowed UIDs. Connections whose credentials cannot be read are
+// rejected.
+func AuthorizePeer(conn net.Conn, allowed ...int) error {
+	uid, err := PeerUID(conn)
+	if err != nil {
+		return err
+	}
+	for _, a := range allowed {
+		if uid == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("peer uid %d is not allowed", uid)
+}
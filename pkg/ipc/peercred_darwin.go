@@ -0,0 +1,31 @@
+package ipc
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// From <sys/un.h> and <sys/ucred.h>; not exposed by package syscall.
+const (
+	solLocal      = 0
+	localPeerCred = 1
+)
+
+// xucred mirrors struct xucred.
+type xucred struct {
+	Version uint32
+	UID     uint32
+	Ngroups int16
+	Groups  [16]uint32
+}
+
+func peerUID(fd int) (int, error) {
+	var cred xucred
+	size := uint32(unsafe.Sizeof(cred))
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(fd), solLocal, localPeerCred,
+		uintptr(unsafe.Pointer(&cred)), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(cred.UID), nil
+}
@@ -0,0 +1,9 @@
+//go:build !darwin && !linux
+
+package ipc
+
+import "errors"
+
+func peerUID(int) (int, error) {
+	return -1, errors.New("peer credentials are not supported on this platform")
+}
@@ -0,0 +1,92 @@
+package ipc
+
+import (
+	"fmt"
+)
+
+// ProtocolVersion is the IPC protocol this binary speaks. Bump it whenever a
+// command is added or changes meaning.
+const ProtocolVersion = 3
+
+// MinProtocolVersion is the oldest peer protocol this binary works with.
+// Version 0 is an agent that predates Hello.
+const MinProtocolVersion = 0
+
+// LegacyCommands are supported by every agent, including those that predate
+// Hello.
+var LegacyCommands = []string{
+	"Ping",
+	"Shutdown",
+	"RunUserScript",
+	"PlaceUserFile",
+	"GetBackgroundProcessCount",
+	"WaitForBackgroundProcesses",
+}
+
+// Commands are the commands supported at ProtocolVersion.
+var Commands = []string{
+	"Hello",
+	"Ping",
+	"Shutdown",
+	"RunUserScript",
+	"Cancel",
+	"PlaceUserFile",
+	"TransferFile",
+	"GetBackgroundProcessCount",
+	"GetBackgroundStatus",
+	"WaitForBackground",
+	"WaitForBackgroundProcesses",
+	"ShowProgress",
+	"UpdateProgress",
+	"DismissProgress",
+	"Notify",
+}
+
+// commandAliases maps a command to older names for the same operation.
+var commandAliases = map[string][]string{
+	"WaitForBackground": {"WaitForBackgroundProcesses"},
+}
+
+// Protocol is what an agent reported in its Hello response.
+type Protocol struct {
+	Version  int
+	Commands []string
+}
+
+// Resolve returns the name to send cmd under: cmd itself, or an older alias
+// the agent supports. ok is false when the agent cannot handle it at all.
+func (p Protocol) Resolve(cmd string) (name string, ok bool) {
+	for _, name := range append([]string{cmd}, commandAliases[cmd]...) {
+		for _, c := range p.Commands {
+			if c == name {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// HelloResponse is the agent's answer to a Hello request.
+func HelloResponse(req RPCRequest) RPCResponse {
+	resp := RPCResponse{ID: req.ID, OK: true, ProtocolVersion: ProtocolVersion, Commands: Commands}
+	if req.ProtocolVersion < MinProtocolVersion {
+		resp.OK = false
+		resp.Error = fmt.Sprintf("daemon speaks IPC protocol %d; this agent requires %d or newer", req.ProtocolVersion, MinProtocolVersion)
+	}
+	return resp
+}
+
+// ProtocolFromHello interprets an agent's response to Hello. An agent that
+// answers "unknown command" predates Hello and is served LegacyCommands.
+func ProtocolFromHello(resp RPCResponse) (Protocol, error) {
+	if !resp.OK {
+		if resp.Error == "unknown command" {
+			return Protocol{Version: 0, Commands: LegacyCommands}, nil
+		}
+		return Protocol{}, fmt.Errorf("agent rejected IPC handshake: %s", resp.Error)
+	}
+	if resp.ProtocolVersion < MinProtocolVersion {
+		return Protocol{}, fmt.Errorf("agent speaks IPC protocol %d but this daemon requires %d or newer; restart the agent (log out and back in) so it runs the installed binary", resp.ProtocolVersion, MinProtocolVersion)
+	}
+	return Protocol{Version: resp.ProtocolVersion, Commands: resp.Commands}, nil
+}
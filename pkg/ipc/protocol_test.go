@@ -0,0 +1,33 @@
+package ipc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProtocolFromHello(t *testing.T) {
+	proto, err := ProtocolFromHello(HelloResponse(RPCRequest{ID: "h", Command: "Hello", ProtocolVersion: ProtocolVersion}))
+	if err != nil || proto.Version != ProtocolVersion {
+		t.Fatalf("current agent: %+v %v", proto, err)
+	}
+	if _, ok := proto.Resolve("Cancel"); !ok {
+		t.Fatalf("current agent should support Cancel")
+	}
+
+	// An agent that predates Hello gets the legacy subset
+	proto, err = ProtocolFromHello(RPCResponse{ID: "h", Error: "unknown command"})
+	if err != nil || proto.Version != 0 {
+		t.Fatalf("legacy agent: %+v %v", proto, err)
+	}
+	if _, ok := proto.Resolve("GetBackgroundStatus"); ok {
+		t.Fatalf("legacy agent should not support GetBackgroundStatus")
+	}
+	if name, ok := proto.Resolve("WaitForBackground"); !ok || name != "WaitForBackgroundProcesses" {
+		t.Fatalf("WaitForBackground resolved to %q, %t", name, ok)
+	}
+
+	// Any other rejection is surfaced
+	if _, err := ProtocolFromHello(RPCResponse{ID: "h", Error: "unauthorized request"}); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Fatalf("expected handshake error, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package ipc
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// chunkSize bounds the content of one FileChunk.
+const chunkSize = 64 << 10
+
+// FileChunk is one frame of TransferFile content. The daemon sends chunks on
+// the same connection right after the request; the last one has EOF set.
+// Data is base64-encoded by encoding/json.
+type FileChunk struct {
+	Data []byte `json:"data,omitempty"`
+	EOF  bool   `json:"eof,omitempty"`
+}
+
+// SendFile writes the content of r to enc as FileChunks.
+func SendFile(enc *json.Encoder, r io.Reader) error {
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if werr := enc.Encode(FileChunk{Data: buf[:n]}); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return enc.Encode(FileChunk{EOF: true})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ChunkReader reads content sent with SendFile.
+type ChunkReader struct {
+	dec  *json.Decoder
+	buf  []byte
+	done bool
+}
+
+// NewChunkReader returns a reader over the FileChunks that follow a request
+// on dec.
+func NewChunkReader(dec *json.Decoder) *ChunkReader {
+	return &ChunkReader{dec: dec}
+}
+
+// Read implements io.Reader.
+func (r *ChunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		var chunk FileChunk
+		if err := r.dec.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				return 0, errors.New("file transfer ended without EOF chunk")
+			}
+			return 0, err
+		}
+		r.buf, r.done = chunk.Data, chunk.EOF
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,39 @@
+package ipc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func TestSendFile_RoundTripsThroughChunkReader(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), chunkSize/4) // several chunks
+	var wire bytes.Buffer
+	if err := SendFile(json.NewEncoder(&wire), bytes.NewReader(content)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	// A following message must still be readable from the same decoder
+	_ = json.NewEncoder(&wire).Encode(RPCResponse{ID: "next"})
+
+	dec := json.NewDecoder(&wire)
+	got, err := io.ReadAll(NewChunkReader(dec))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("content mismatch: got %d bytes, want %d", len(got), len(content))
+	}
+	var next RPCResponse
+	if err := dec.Decode(&next); err != nil || next.ID != "next" {
+		t.Fatalf("decoder out of sync: %v %+v", err, next)
+	}
+}
+
+func TestChunkReader_TruncatedTransfer(t *testing.T) {
+	var wire bytes.Buffer
+	_ = json.NewEncoder(&wire).Encode(FileChunk{Data: []byte("partial")})
+	if _, err := io.ReadAll(NewChunkReader(json.NewDecoder(&wire))); err == nil {
+		t.Fatalf("expected error for transfer without EOF chunk")
+	}
+}
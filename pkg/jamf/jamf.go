@@ -0,0 +1,182 @@
+// Package jamf updates Jamf Pro once bootstrap completes: it submits
+// inventory so the server sees the freshly installed software, and fires
+// custom policy triggers so follow-on policies run right away instead of at
+// the next check-in. It can also have Jamf Pro restart the Mac.
+package jamf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-installapplications/pkg/utils"
+	"github.com/go-installapplications/pkg/version"
+)
+
+// DefaultBinaryPath is where the Jamf management framework installs its CLI.
+const DefaultBinaryPath = "/usr/local/bin/jamf"
+
+// Options controls which Jamf actions run.
+type Options struct {
+	// Recon submits inventory (`jamf recon`, or an UpdateInventory MDM
+	// command when URL and APIToken are set).
+	Recon bool
+	// PolicyEvents are custom triggers run with `jamf policy -event`.
+	PolicyEvents []string
+	// BinaryPath is the jamf CLI; defaults to DefaultBinaryPath.
+	BinaryPath string
+	// URL and APIToken switch inventory updates to the Jamf Pro API, for
+	// machines where the jamf binary is not installed yet.
+	URL      string
+	APIToken string
+	// SerialNumber identifies the computer for API calls; looked up from the
+	// IORegistry when empty.
+	SerialNumber string
+
+	// Run executes a command; defaults to utils.RunCommandCapture.
+	Run func(args []string) (string, error)
+	// Client is used for API calls; defaults to a client with a 30s timeout.
+	Client *http.Client
+}
+
+// Enabled reports whether any Jamf action is configured.
+func (o Options) Enabled() bool {
+	return o.Recon || len(o.PolicyEvents) > 0
+}
+
+// Update runs the configured actions: inventory first so policies scoped on
+// the new inventory see it, then each policy trigger in order. Every action
+// is attempted; the returned error joins all failures.
+func Update(opts Options, logger utils.Logger) error {
+	if opts.BinaryPath == "" {
+		opts.BinaryPath = DefaultBinaryPath
+	}
+	if opts.Run == nil {
+		opts.Run = utils.RunCommandCapture
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	var errs []error
+	if opts.Recon {
+		var err error
+		if opts.URL != "" && opts.APIToken != "" {
+			err = updateInventoryAPI(opts, logger)
+		} else {
+			err = runBinary(opts, logger, "recon")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("inventory update failed: %w", err))
+		} else {
+			logger.Info("✅ Jamf inventory updated")
+		}
+	}
+	for _, event := range opts.PolicyEvents {
+		if err := runBinary(opts, logger, "policy", "-event", event); err != nil {
+			errs = append(errs, fmt.Errorf("policy event %q failed: %w", event, err))
+			continue
+		}
+		logger.Info("✅ Jamf policy event triggered: %s", event)
+	}
+	return errors.Join(errs...)
+}
+
+func runBinary(opts Options, logger utils.Logger, args ...string) error {
+	if _, err := os.Stat(opts.BinaryPath); err != nil {
+		return fmt.Errorf("jamf binary not found at %s", opts.BinaryPath)
+	}
+	cmd := append([]string{opts.BinaryPath}, args...)
+	logger.Debug("Running: %s", strings.Join(cmd, " "))
+	out, err := opts.Run(cmd)
+	if out != "" {
+		logger.Verbose("jamf output: %s", out)
+	}
+	return err
+}
+
+// updateInventoryAPI sends the computer an UpdateInventory MDM command
+// through the Classic API.
+func updateInventoryAPI(opts Options, logger utils.Logger) error {
+	return sendCommand(opts, "UpdateInventory", logger)
+}
+
+// Restart sends the computer a RestartDevice MDM command through the Classic
+// API, so the restart is the MDM's like any other it sends. URL and APIToken
+// must be set.
+func Restart(opts Options, logger utils.Logger) error {
+	if opts.URL == "" || opts.APIToken == "" {
+		return errors.New("a Jamf Pro URL and API token are required")
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return sendCommand(opts, "RestartDevice", logger)
+}
+
+// sendCommand looks the computer up by serial number and sends it an MDM
+// command through the Classic API.
+func sendCommand(opts Options, name string, logger utils.Logger) error {
+	serial := opts.SerialNumber
+	if serial == "" {
+		var err error
+		if serial, err = utils.GetSerialNumber(); err != nil {
+			return fmt.Errorf("failed to read serial number: %w", err)
+		}
+	}
+	base := strings.TrimRight(opts.URL, "/")
+
+	lookup := fmt.Sprintf("%s/JSSResource/computers/serialnumber/%s/subset/General", base, url.PathEscape(serial))
+	resp, err := apiRequest(opts, http.MethodGet, lookup)
+	if err != nil {
+		return fmt.Errorf("computer lookup failed: %w", err)
+	}
+	defer resp.Body.Close()
+	var computer struct {
+		Computer struct {
+			General struct {
+				ID int `json:"id"`
+			} `json:"general"`
+		} `json:"computer"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&computer); err != nil {
+		return fmt.Errorf("failed to decode computer record: %w", err)
+	}
+	id := computer.Computer.General.ID
+	if id == 0 {
+		return fmt.Errorf("no computer record for serial %s", serial)
+	}
+	logger.Debug("Jamf computer ID for %s: %d", serial, id)
+
+	command := fmt.Sprintf("%s/JSSResource/computercommands/command/%s/id/%d", base, name, id)
+	resp, err = apiRequest(opts, http.MethodPost, command)
+	if err != nil {
+		return fmt.Errorf("%s command failed: %w", name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func apiRequest(opts Options, method, target string) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+opts.APIToken)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+	resp, err := opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("request failed with status: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
@@ -0,0 +1,115 @@
+package jamf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// fakeBinary creates an executable placeholder and returns its path plus a
+// Run func that records commands instead of executing them.
+func fakeBinary(t *testing.T, fail string) (string, func([]string) (string, error), *[]string) {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "jamf")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("write fake jamf: %v", err)
+	}
+	var calls []string
+	run := func(args []string) (string, error) {
+		cmd := strings.Join(args[1:], " ")
+		calls = append(calls, cmd)
+		if cmd == fail {
+			return "", os.ErrPermission
+		}
+		return "", nil
+	}
+	return bin, run, &calls
+}
+
+func TestUpdate_ReconThenPolicies(t *testing.T) {
+	bin, run, calls := fakeBinary(t, "policy -event broken")
+	err := Update(Options{
+		Recon:        true,
+		PolicyEvents: []string{"enrollmentComplete", "broken", "dock"},
+		BinaryPath:   bin,
+		Run:          run,
+	}, utils.NewLogger(false, false))
+
+	want := "recon|policy -event enrollmentComplete|policy -event broken|policy -event dock"
+	if got := strings.Join(*calls, "|"); got != want {
+		t.Fatalf("calls = %s, want %s", got, want)
+	}
+	if err == nil || !strings.Contains(err.Error(), `"broken"`) {
+		t.Fatalf("expected error naming the failed event, got %v", err)
+	}
+}
+
+func TestUpdate_MissingBinary(t *testing.T) {
+	err := Update(Options{Recon: true, BinaryPath: filepath.Join(t.TempDir(), "missing")}, utils.NewLogger(false, false))
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected missing binary error, got %v", err)
+	}
+}
+
+func TestUpdate_ReconViaAPI(t *testing.T) {
+	var commanded bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/JSSResource/computers/serialnumber/C02TEST/subset/General":
+			_, _ = w.Write([]byte(`{"computer":{"general":{"id":42}}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/JSSResource/computercommands/command/UpdateInventory/id/42":
+			commanded = true
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	err := Update(Options{
+		Recon:        true,
+		URL:          srv.URL + "/",
+		APIToken:     "tok",
+		SerialNumber: "C02TEST",
+		Run: func([]string) (string, error) {
+			t.Fatal("binary must not run when the API is configured")
+			return "", nil
+		},
+	}, utils.NewLogger(false, false))
+	if err != nil || !commanded {
+		t.Fatalf("expected UpdateInventory command, err=%v commanded=%v", err, commanded)
+	}
+}
+
+func TestRestart_ViaAPI(t *testing.T) {
+	var commanded bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/JSSResource/computers/serialnumber/C02TEST/subset/General":
+			_, _ = w.Write([]byte(`{"computer":{"general":{"id":42}}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/JSSResource/computercommands/command/RestartDevice/id/42":
+			commanded = r.Header.Get("Authorization") == "Bearer tok"
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	logger := utils.NewLogger(false, false)
+	if err := Restart(Options{URL: srv.URL, APIToken: "tok", SerialNumber: "C02TEST"}, logger); err != nil || !commanded {
+		t.Fatalf("expected RestartDevice command, err=%v commanded=%v", err, commanded)
+	}
+	if err := Restart(Options{SerialNumber: "C02TEST"}, logger); err == nil {
+		t.Error("expected an error without a URL and API token")
+	}
+}
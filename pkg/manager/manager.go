@@ -1,22 +1,28 @@
 package manager
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/go-installapplications/pkg/config"
 	"github.com/go-installapplications/pkg/download"
 	"github.com/go-installapplications/pkg/installer"
+	"github.com/go-installapplications/pkg/progress"
+	"github.com/go-installapplications/pkg/tracing"
 	"github.com/go-installapplications/pkg/utils"
 )
 
 // itemResult carries the outcome of a single item execution so callers can
 // apply fail_policy uniformly across sequential and parallel paths.
 type itemResult struct {
-	item        config.Item
-	err         error
-	operation   string // for handleItemError ("script execution", "package installation", ...)
-	startedBg   bool   // true if a tracked background process was started
+	item       config.Item
+	err        error
+	operation  string // for handleItemError ("script execution", "package installation", ...)
+	startedBg  bool   // true if a tracked background process was started
+	skipReason string // non-empty when the item was intentionally not installed
 }
 
 // Manager orchestrates the three-phase installation process
@@ -24,26 +30,177 @@ type Manager struct {
 	downloader     download.Downloader
 	installer      installer.Installer
 	config         *config.Config
-	logger         *utils.Logger
+	logger         utils.Logger
 	cleanupTracker *download.CleanupTracker
+	reporter       progress.Reporter
+	tracer         *tracing.Tracer
+	ctx            context.Context
+	hooks          *config.Hooks
+	preflight      *config.PreflightOptions
 }
 
 // NewManager creates a new phase manager
-func NewManager(downloader download.Downloader, installer installer.Installer, cfg *config.Config, logger *utils.Logger) *Manager {
+func NewManager(downloader download.Downloader, installer installer.Installer, cfg *config.Config, logger utils.Logger) *Manager {
+	logger = utils.Component(logger, utils.ComponentManager)
+	cleanupTracker := download.NewCleanupTracker(logger)
+	cleanupTracker.SetDryRun(cfg.DryRun)
+	cleanupTracker.SetPreserve(cfg.Preserved)
 	return &Manager{
 		downloader:     downloader,
 		installer:      installer,
 		config:         cfg,
 		logger:         logger,
-		cleanupTracker: download.NewCleanupTracker(),
+		cleanupTracker: cleanupTracker,
+		reporter:       progress.Nop{},
+		ctx:            context.Background(),
 	}
 }
 
-// ProcessItems downloads and installs a list of items with cleanup
+// SetReporter attaches a progress reporter that receives per-item events.
+// Passing nil restores the no-op reporter.
+func (m *Manager) SetReporter(r progress.Reporter) {
+	if r == nil {
+		r = progress.Nop{}
+	}
+	m.reporter = r
+}
+
+// SetTracer records phase, download and install spans in t. A nil Tracer
+// disables tracing.
+func (m *Manager) SetTracer(t *tracing.Tracer) {
+	m.tracer = t
+}
+
+// SetContext stops the run when ctx is done: no further items are started
+// and the phase returns an error wrapping context.Cause(ctx). Cancelling
+// in-flight downloads and scripts is up to the downloader and installer.
+func (m *Manager) SetContext(ctx context.Context) {
+	m.ctx = ctx
+}
+
+// SetHooks runs the bootstrap's pre- and post-phase hooks around each phase
+// processed by ProcessItems. A nil Hooks runs none.
+func (m *Manager) SetHooks(h *config.Hooks) {
+	m.hooks = h
+}
+
+// SetPreflightOptions applies the bootstrap's preflight_options; nil keeps
+// the single-script behavior.
+func (m *Manager) SetPreflightOptions(o *config.PreflightOptions) {
+	m.preflight = o
+}
+
+// usePhaseConfig runs the phase with the profile's overrides for it (see
+// config.Config.ForPhase), passing its retry defaults on to the downloader.
+// The returned func switches back to the run's config.
+func (m *Manager) usePhaseConfig(phaseName string) func() {
+	runCfg := m.config
+	phaseCfg := runCfg.ForPhase(phaseName)
+	if phaseCfg == runCfg {
+		return func() {}
+	}
+	m.config = phaseCfg
+	d, retries := m.downloader.(interface{ SetRetryDefaults(int, int) })
+	if retries {
+		d.SetRetryDefaults(phaseCfg.MaxRetries, phaseCfg.RetryDelay)
+	}
+	return func() {
+		m.config = runCfg
+		if retries {
+			d.SetRetryDefaults(runCfg.MaxRetries, runCfg.RetryDelay)
+		}
+	}
+}
+
+// limitPhase stops the phase once PhaseTimeout has passed. The downloader
+// and installer are switched to the phase's context when they support it, so
+// in-flight downloads and scripts are cancelled too. The returned func ends
+// the limit and switches them back.
+func (m *Manager) limitPhase(phaseName string) func() {
+	return m.limit(m.ctx, m.config.PhaseTimeout, phaseName+" phase")
+}
+
+// limit binds a context derived from parent that stops once timeout has
+// passed, and returns the func that ends the limit.
+func (m *Manager) limit(parent context.Context, timeout time.Duration, what string) func() {
+	ctx, cancel := utils.WithTimeout(parent, timeout, what, m.logger)
+	m.bindContext(ctx)
+	return func() {
+		cancel()
+		m.bindContext(parent)
+	}
+}
+
+// bindContext makes ctx stop the manager, downloader and installer.
+func (m *Manager) bindContext(ctx context.Context) {
+	m.ctx = ctx
+	if d, ok := m.downloader.(interface{ SetContext(context.Context) }); ok {
+		d.SetContext(ctx)
+	}
+	switch inst := m.installer.(type) {
+	case interface{ SetStop(<-chan struct{}) }:
+		inst.SetStop(ctx.Done())
+	case interface{ SetContext(context.Context) }:
+		inst.SetContext(ctx)
+	}
+}
+
+// stopped returns a non-nil error once the run has been told to stop.
+func (m *Manager) stopped(phaseName string) error {
+	if m.ctx.Err() == nil {
+		return nil
+	}
+	return fmt.Errorf("%s phase stopped: %w", phaseName, context.Cause(m.ctx))
+}
+
+// ProcessItems downloads and installs a list of items with cleanup, running
+// the phase's hooks before and after them
 func (m *Manager) ProcessItems(items []config.Item, phaseName string) error {
 	if len(items) == 0 {
 		return nil
 	}
+	defer m.usePhaseConfig(phaseName)()
+	if m.config.PhaseTimeout > 0 {
+		defer m.limitPhase(phaseName)()
+	}
+	if err := m.RunHooks(m.hooks.Pre(phaseName), "pre_"+phaseName); err != nil {
+		return err
+	}
+	if err := m.processPhase(items, phaseName); err != nil {
+		return err
+	}
+	return m.RunHooks(m.hooks.Post(phaseName), "post_"+phaseName)
+}
+
+// RunHooks runs hook items as a step of their own named hookName (e.g.
+// "pre_userland"), with the same downloads, retries and fail_policy as a
+// phase.
+func (m *Manager) RunHooks(items []config.Item, hookName string) error {
+	if len(items) == 0 {
+		return nil
+	}
+	m.logger.Info("🪝 Running %s hooks (%d)", hookName, len(items))
+	if err := m.processPhase(items, hookName); err != nil {
+		return fmt.Errorf("%s hook failed: %w", hookName, err)
+	}
+	return nil
+}
+
+// processPhase runs items as one traced phase.
+func (m *Manager) processPhase(items []config.Item, phaseName string) error {
+	span := m.tracer.StartPhase(phaseName)
+	err := m.processItems(items, phaseName)
+	if _, ok := err.(*installer.PreflightSuccessError); ok {
+		span.End(nil)
+	} else {
+		span.End(err)
+	}
+	return err
+}
+
+// processItems runs one phase: skip_if filtering, parallel downloads, then
+// installs in parallel_group batches.
+func (m *Manager) processItems(items []config.Item, phaseName string) error {
 
 	// Validate phase restrictions
 	if err := m.validatePhaseRestrictions(items, phaseName); err != nil {
@@ -59,6 +216,7 @@ func (m *Manager) ProcessItems(items []config.Item, phaseName string) error {
 	for _, item := range items {
 		if utils.ShouldSkipItem(item.SkipIf, m.logger) {
 			m.logger.Info("⏭️  Skipping %s: matches skip_if criteria '%s'", item.Name, item.SkipIf)
+			m.reporter.ItemSkipped(item, fmt.Sprintf("skip_if %s", item.SkipIf))
 			skippedCount++
 		} else {
 			filteredItems = append(filteredItems, item)
@@ -83,9 +241,13 @@ func (m *Manager) ProcessItems(items []config.Item, phaseName string) error {
 	if !cleanupFailed && m.config.CleanupOnFailure {
 		m.logger.Debug("KeepFailedFiles=true: preserving failed downloads for troubleshooting")
 	}
-	// Track all target file paths for potential cleanup-on-success
+	// Track all target file paths for potential cleanup-on-success. A
+	// launchd item's file is the job itself and a vppapp item's the
+	// installed app; both stay, as does a file placed with backup, which
+	// only rollback undoes.
 	for _, item := range filteredItems {
-		if item.File != "" {
+		m.tracer.StartItem(item)
+		if item.File != "" && item.Type != "launchd" && item.Type != "vppapp" && !item.Backup {
 			m.cleanupTracker.TrackFile(item.File)
 		}
 	}
@@ -99,6 +261,7 @@ func (m *Manager) ProcessItems(items []config.Item, phaseName string) error {
 	for _, result := range results {
 		if result.Error != nil {
 			m.logger.Error("❌ Download failed: %s - %v", result.Item.Name, result.Error)
+			m.reporter.ItemFinished(result.Item, result.Error)
 			downloadErrors = append(downloadErrors, result.Error)
 		} else {
 			m.logger.Debug("✅ Download success: %s", result.Item.Name)
@@ -106,6 +269,10 @@ func (m *Manager) ProcessItems(items []config.Item, phaseName string) error {
 		}
 	}
 
+	if err := m.stopped(phaseName); err != nil {
+		return err
+	}
+
 	// If any downloads failed, stop here
 	if len(downloadErrors) > 0 {
 		return fmt.Errorf("failed to download %d items in %s phase, first error: %w", len(downloadErrors), phaseName, downloadErrors[0])
@@ -116,18 +283,21 @@ func (m *Manager) ProcessItems(items []config.Item, phaseName string) error {
 
 	var backgroundProcessCount int
 
-	// Preflight is a single-item phase with bespoke control flow; route it
-	// directly through the preflight handler regardless of parallel_group.
+	// Preflight has bespoke control flow; route it directly through the
+	// preflight handler regardless of parallel_group.
 	if phaseName == "preflight" {
-		for _, item := range successfulItems {
-			if item.Type == "rootscript" {
-				return m.handlePreflightScript(item)
-			}
-		}
+		return m.runPreflight(successfulItems)
 	}
 
 	batches := config.BatchByParallelGroup(successfulItems)
+	failedGroups := make(config.FailedGroups)
 	for batchIdx, batch := range batches {
+		if err := m.stopped(phaseName); err != nil {
+			return err
+		}
+		if batch = m.skipFailedGroups(batch, failedGroups); len(batch) == 0 {
+			continue
+		}
 		if len(batch) == 1 {
 			item := batch[0]
 			m.logger.Debug("Processing item %d/%d (batch %d): %s (%s)", batchIdx+1, len(batches), batchIdx+1, item.Name, item.Type)
@@ -142,28 +312,41 @@ func (m *Manager) ProcessItems(items []config.Item, phaseName string) error {
 			if res.startedBg {
 				backgroundProcessCount++
 			}
+			if res.err != nil && m.ctx.Err() != nil {
+				return m.stopped(phaseName)
+			}
 			if res.err != nil {
 				if m.handleItemError(item, res.err, res.operation) {
 					return fmt.Errorf("%s failed in %s phase for %s: %w", res.operation, phaseName, item.Name, res.err)
 				}
+				failedGroups.Fail(item)
 			}
 			continue
 		}
 
-		// Parallel batch — every item in the batch shares the same non-empty group.
-		groupName := batch[0].ParallelGroup
-		m.logger.Info("🔀 parallel_group %q: running %d items concurrently", groupName, len(batch))
+		// Parallel batch — every item in the batch shares the same non-empty
+		// group, or is parallel_safe.
+		groupName := config.BatchName(batch)
+		limit := m.config.InstallLimit(len(batch))
+		m.logger.Info("🔀 parallel_group %q: running %d items concurrently (%d at a time)", groupName, len(batch), limit)
 
 		results := make([]itemResult, len(batch))
+		semaphore := make(chan struct{}, limit)
 		var wg sync.WaitGroup
 		for i := range batch {
 			wg.Add(1)
 			go func(i int) {
 				defer wg.Done()
+				defer utils.Recover(m.logger, "install of "+batch[i].Name)
+				semaphore <- struct{}{}
+				defer func() { <-semaphore }()
 				results[i] = m.runItem(batch[i], phaseName)
 			}(i)
 		}
 		wg.Wait()
+		if err := m.stopped(phaseName); err != nil {
+			return err
+		}
 
 		// Apply fail_policy to each result in the batch's declared order so
 		// log output remains deterministic.
@@ -175,6 +358,7 @@ func (m *Manager) ProcessItems(items []config.Item, phaseName string) error {
 				if m.handleItemError(res.item, res.err, res.operation) {
 					return fmt.Errorf("parallel_group %q: %s failed for %s: %w", groupName, res.operation, res.item.Name, res.err)
 				}
+				failedGroups.Fail(res.item)
 			}
 		}
 		m.logger.Info("✅ parallel_group %q complete", groupName)
@@ -199,7 +383,7 @@ func (m *Manager) ProcessItems(items []config.Item, phaseName string) error {
 	m.logger.Info("✅ Completed %s phase", phaseName)
 
 	// Cleanup on success, if configured
-	if m.config.CleanupOnSuccess {
+	if m.config.CleanupOnSuccess && m.config.CleansUp(config.CleanupDownloads) {
 		m.logger.Debug("CleanupOnSuccess=true: removing downloaded artifacts for %s phase", phaseName)
 		if err := m.cleanupTracker.CleanupAll(); err != nil {
 			m.logger.Debug("CleanupOnSuccess encountered errors: %v", err)
@@ -213,89 +397,206 @@ func (m *Manager) ProcessItems(items []config.Item, phaseName string) error {
 // decides what to do with the error. This is the unifying primitive used by
 // both the singleton and parallel-batch paths.
 func (m *Manager) runItem(item config.Item, phaseName string) itemResult {
+	m.reporter.ItemStarted(item)
+	span := m.tracer.Item(item.Name).StartChild("install")
+	span.SetAttr("item.type", item.Type)
+	res := m.dispatchItem(item, phaseName)
+	span.End(res.err)
+	if res.skipReason != "" {
+		m.reporter.ItemSkipped(item, res.skipReason)
+	} else {
+		m.reporter.ItemFinished(item, res.err)
+		if res.err == nil {
+			utils.RecordInstalled()
+		}
+	}
+	return res
+}
+
+// dispatchItem routes an item to the handler for its type.
+func (m *Manager) dispatchItem(item config.Item, phaseName string) itemResult {
+	log := utils.With(m.logger, "phase", phaseName, "item", item.Name)
 	switch item.Type {
 	case "package":
-		return m.runPackage(item)
+		return m.runPackage(item, log)
 	case "rootscript":
 		// Preflight is handled separately at the ProcessItems level.
 		_ = phaseName
-		return m.runRootScript(item)
+		return m.runRootScript(item, log)
 	case "userscript":
-		return m.runUserScript(item)
+		return m.runUserScript(item, log)
 	case "rootfile":
-		return m.runFilePlacement(item, "rootfile")
+		return m.runFilePlacement(item, "rootfile", log)
 	case "userfile":
-		return m.runFilePlacement(item, "userfile")
+		return m.runFilePlacement(item, "userfile", log)
+	case "munki":
+		return m.runMunki(item, log)
+	case "launchd":
+		return m.runService(item, log)
+	case "vppapp":
+		return m.runAppStoreApp(item, log)
 	default:
-		m.logger.Info("⚠️  Unknown item type: %s for %s", item.Type, item.Name)
+		log.Info("⚠️  Unknown item type: %s for %s", item.Type, item.Name)
 		return itemResult{item: item, operation: "dispatch"}
 	}
 }
 
-func (m *Manager) runRootScript(item config.Item) itemResult {
-	err := m.installer.ExecuteScript(item.File, "rootscript", item.DoNotWait, m.config.TrackBackgroundProcesses)
+func (m *Manager) runRootScript(item config.Item, log utils.Logger) itemResult {
+	var err error
+	if item.RunAs != "" {
+		err = m.installer.ExecuteScriptAs(item.File, item.RunAs, item.DoNotWait, m.config.TrackBackgroundProcesses)
+	} else {
+		err = m.installer.ExecuteScript(item.File, "rootscript", item.DoNotWait, m.config.TrackBackgroundProcesses)
+	}
 	res := itemResult{item: item, operation: "script execution", err: err}
 	if err == nil {
 		if item.DoNotWait {
 			if m.config.TrackBackgroundProcesses {
 				res.startedBg = true
-				m.logger.Info("✅ Root script started in background: %s", item.Name)
+				log.Info("✅ Root script started in background: %s", item.Name)
 			} else {
-				m.logger.Info("✅ Root script started (fire-and-forget): %s", item.Name)
+				log.Info("✅ Root script started (fire-and-forget): %s", item.Name)
 			}
 		} else {
-			m.logger.Info("✅ Root script executed: %s", item.Name)
+			log.Info("✅ Root script executed: %s", item.Name)
 		}
 	}
 	return res
 }
 
-func (m *Manager) runFilePlacement(item config.Item, fileType string) itemResult {
-	err := m.installer.PlaceFile(item.File, fileType)
+func (m *Manager) runFilePlacement(item config.Item, fileType string, log utils.Logger) itemResult {
+	err := m.installer.PlaceFile(item.File, fileType, installer.FileOptionsFor(item))
 	res := itemResult{item: item, operation: "file placement", err: err}
 	if err == nil {
-		m.logger.Info("✅ %s placed: %s", fileType, item.Name)
+		log.Info("✅ %s placed: %s", fileType, item.Name)
 	}
 	return res
 }
 
-func (m *Manager) runUserScript(item config.Item) itemResult {
-	err := m.installer.ExecuteScript(item.File, "userscript", item.DoNotWait, m.config.TrackBackgroundProcesses)
+func (m *Manager) runUserScript(item config.Item, log utils.Logger) itemResult {
+	uc := installer.UserContext{InstallPath: m.config.InstallPath, ItemName: item.Name}
+	err := m.installer.ExecuteUserScript(item.File, uc, item.DoNotWait, m.config.TrackBackgroundProcesses)
 	res := itemResult{item: item, operation: "script execution", err: err}
 	if err == nil {
 		if item.DoNotWait {
 			if m.config.TrackBackgroundProcesses {
 				res.startedBg = true
-				m.logger.Info("✅ User script started in background: %s", item.Name)
+				log.Info("✅ User script started in background: %s", item.Name)
 			} else {
-				m.logger.Info("✅ User script started (fire-and-forget): %s", item.Name)
+				log.Info("✅ User script started (fire-and-forget): %s", item.Name)
 			}
 		} else {
-			m.logger.Info("✅ User script executed: %s", item.Name)
+			log.Info("✅ User script executed: %s", item.Name)
 		}
 	}
 	return res
 }
 
-func (m *Manager) runPackage(item config.Item) itemResult {
+func (m *Manager) runPackage(item config.Item, log utils.Logger) itemResult {
 	if !item.PkgRequired && item.PackageID != "" {
-		alreadySatisfied, err := utils.CheckPackageReceipt(item.PackageID, item.Version, m.logger)
+		alreadySatisfied, err := utils.CheckPackageReceipt(item.PackageID, item.Version, log)
 		if err != nil {
 			return itemResult{item: item, operation: "package receipt check", err: err}
 		}
 		if alreadySatisfied {
-			m.logger.Info("⏭️  Skipping %s - already installed.", item.Name)
-			return itemResult{item: item, operation: "package installation"}
+			log.Info("⏭️  Skipping %s - already installed.", item.Name)
+			return itemResult{item: item, operation: "package installation", skipReason: "already installed"}
 		}
 	}
-	err := m.installer.InstallPackage(item.File, "/")
+	opts := installer.PackageOptionsFor(item, m.config)
+	opts.ReportProgress(item, m.reporter)
+	err := m.installer.InstallPackage(item.File, opts)
+	res := itemResult{item: item, operation: "package installation", err: err}
+	if err == nil {
+		log.Info("✅ Package installed: %s", item.Name)
+	}
+	return res
+}
+
+// runService runs the launchctl action of a launchd item.
+func (m *Manager) runService(item config.Item, log utils.Logger) itemResult {
+	err := m.installer.ManageService(installer.ServiceOptionsFor(item))
+	res := itemResult{item: item, operation: "service management", err: err}
+	if err == nil {
+		log.Info("✅ launchctl %s done: %s", item.Action, item.Name)
+	}
+	return res
+}
+
+// runAppStoreApp has the MDM install a vppapp item's App Store app. A
+// failure counts as a package installation failure.
+func (m *Manager) runAppStoreApp(item config.Item, log utils.Logger) itemResult {
+	err := m.installer.InstallAppStoreApp(installer.VPPAppOptionsFor(item, m.config))
 	res := itemResult{item: item, operation: "package installation", err: err}
 	if err == nil {
-		m.logger.Info("✅ Package installed: %s", item.Name)
+		log.Info("✅ App Store app requested: %s", item.Name)
 	}
 	return res
 }
 
+// runMunki installs the item's Munki package, if it has one, then configures
+// Munki and starts its first run. Package failures are reported as package
+// installation and the Munki run as script execution, so fail_policy treats
+// each like its standalone equivalent.
+func (m *Manager) runMunki(item config.Item, log utils.Logger) itemResult {
+	if item.File != "" {
+		if res := m.runPackage(item, log); res.err != nil {
+			return res
+		}
+	}
+	err := m.installer.ConfigureMunki(installer.MunkiOptionsFor(item, m.config))
+	res := itemResult{item: item, operation: "script execution", err: err}
+	if err == nil {
+		log.Info("✅ Handed off to Munki: %s", item.Name)
+	}
+	return res
+}
+
+// preflightLimit names the preflight_options timeout
+const preflightLimit = "preflight checks"
+
+// runPreflight runs the preflight rootscripts in order, within the combined
+// timeout of preflight_options. The Mac is provisioned (PreflightSuccessError)
+// only when every script exits 0; the first non-zero exit, or the timeout,
+// continues the bootstrap without running the rest.
+func (m *Manager) runPreflight(items []config.Item) error {
+	if m.preflight != nil && m.preflight.Timeout > 0 {
+		defer m.limit(m.ctx, time.Duration(m.preflight.Timeout)*time.Second, preflightLimit)()
+	}
+	ran := 0
+	for _, item := range items {
+		if item.Type != "rootscript" {
+			continue
+		}
+		ran++
+		err := m.handlePreflightScript(item)
+		if _, passed := err.(*installer.PreflightSuccessError); passed {
+			m.tracer.ItemFinished(item, nil)
+			continue
+		}
+		m.tracer.ItemFinished(item, err)
+		if err != nil && m.preflightTimedOut() {
+			m.logger.Info("⚠️  Preflight checks timed out during %s - continuing with bootstrap", item.Name)
+			return nil
+		}
+		return err
+	}
+	if ran == 0 {
+		return nil
+	}
+	m.logger.Info("✅ Preflight passed (%d script(s) exited 0) - performing full cleanup and exiting", ran)
+	// Perform complete cleanup (files, services, reboot if configured)
+	m.Cleanup("preflight success")
+	return &installer.PreflightSuccessError{}
+}
+
+// preflightTimedOut reports whether the preflight_options timeout stopped
+// the preflight scripts.
+func (m *Manager) preflightTimedOut() bool {
+	var timeout *utils.TimeoutError
+	return errors.As(context.Cause(m.ctx), &timeout) && timeout.What == preflightLimit
+}
+
 // handlePreflightScript handles the special case of preflight rootscript execution
 // Returns PreflightSuccessError on exit code 0, nil on exit code 1+, or error on execution failure
 func (m *Manager) handlePreflightScript(item config.Item) error {
@@ -304,12 +605,8 @@ func (m *Manager) handlePreflightScript(item config.Item) error {
 
 	// Check if this is a preflight success signal
 	if _, ok := err.(*installer.PreflightSuccessError); ok {
-		m.logger.Info("✅ Preflight script %s passed (exit code 0) - performing full cleanup and exiting", item.Name)
-
-		// Perform complete cleanup (files, services, reboot if configured)
-		m.Cleanup("preflight success")
-
-		return err // Return the PreflightSuccessError to signal preflight success
+		m.logger.Info("✅ Preflight script %s passed (exit code 0)", item.Name)
+		return err
 	} else if err != nil {
 		// Script execution failed (e.g., script not found, permission denied)
 		// Note: Preflight ignores fail_policy - only execution errors stop the process
@@ -328,7 +625,9 @@ func (m *Manager) Cleanup(cleanupType string) {
 	m.logger.Info("🧹 Performing %s cleanup", cleanupType)
 
 	// Always clean up files if either flag is true
-	if m.config.CleanupOnSuccess || m.config.CleanupOnFailure {
+	if !m.config.CleansUp(config.CleanupDownloads) {
+		m.logger.Debug("CleanupScope excludes downloads: preserving downloaded artifacts")
+	} else if m.config.CleanupOnSuccess || m.config.CleanupOnFailure {
 		m.logger.Debug("Cleanup flags enabled: removing downloaded artifacts")
 		if err := m.cleanupTracker.CleanupAll(); err != nil {
 			m.logger.Debug("File cleanup encountered errors: %v", err)
@@ -338,6 +637,21 @@ func (m *Manager) Cleanup(cleanupType string) {
 	}
 }
 
+// skipFailedGroups reports the members of failed groups in batch as skipped
+// and returns the rest.
+func (m *Manager) skipFailedGroups(batch []config.Item, failed config.FailedGroups) []config.Item {
+	var run []config.Item
+	for _, item := range batch {
+		if failed.Skipped(item) {
+			m.logger.Info("⏭️  Skipping %s: group %q failed", item.Name, item.Group)
+			m.reporter.ItemSkipped(item, fmt.Sprintf("group %s failed", item.Group))
+			continue
+		}
+		run = append(run, item)
+	}
+	return run
+}
+
 // handleItemError processes errors according to the item's fail policy
 // Returns true if the phase should stop, false if it should continue
 func (m *Manager) handleItemError(item config.Item, err error, operation string) bool {
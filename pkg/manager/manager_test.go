@@ -1,7 +1,14 @@
 package manager
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -9,6 +16,7 @@ import (
 	"github.com/go-installapplications/pkg/config"
 	"github.com/go-installapplications/pkg/download"
 	"github.com/go-installapplications/pkg/installer"
+	"github.com/go-installapplications/pkg/tracing"
 	"github.com/go-installapplications/pkg/utils"
 )
 
@@ -29,11 +37,19 @@ func (f *fakeDownloader) DownloadMultipleWithCleanup(items []config.Item, max in
 
 // fake installer tracks calls. Uses atomic ops so parallel_group batches
 // don't race the script counter.
-type fakeInstaller struct{ scripts int32 }
+type fakeInstaller struct {
+	scripts  int32
+	munki    []installer.MunkiOptions
+	runAs    []string
+	services []installer.ServiceOptions
+	apps     []installer.VPPAppOptions
+}
 
 func (f *fakeInstaller) callCount() int { return int(atomic.LoadInt32(&f.scripts)) }
 
-func (f *fakeInstaller) InstallPackage(pkgPath, target string) error { return nil }
+func (f *fakeInstaller) InstallPackage(pkgPath string, opts installer.PackageOptions) error {
+	return nil
+}
 func (f *fakeInstaller) ExecuteScript(scriptPath, scriptType string, doNotWait bool, track bool) error {
 	atomic.AddInt32(&f.scripts, 1)
 	if scriptPath == "fail.sh" {
@@ -41,6 +57,13 @@ func (f *fakeInstaller) ExecuteScript(scriptPath, scriptType string, doNotWait b
 	}
 	return nil
 }
+func (f *fakeInstaller) ExecuteScriptAs(scriptPath, runAs string, doNotWait bool, track bool) error {
+	f.runAs = append(f.runAs, runAs)
+	return f.ExecuteScript(scriptPath, "rootscript", doNotWait, track)
+}
+func (f *fakeInstaller) ExecuteUserScript(scriptPath string, uc installer.UserContext, doNotWait bool, track bool) error {
+	return f.ExecuteScript(scriptPath, "userscript", doNotWait, track)
+}
 func (f *fakeInstaller) ExecuteScriptForPreflight(scriptPath, scriptType string, doNotWait bool, track bool) error {
 	atomic.AddInt32(&f.scripts, 1)
 	if scriptPath == "fail.sh" {
@@ -48,9 +71,23 @@ func (f *fakeInstaller) ExecuteScriptForPreflight(scriptPath, scriptType string,
 	}
 	return nil
 }
-func (f *fakeInstaller) PlaceFile(filePath, fileType string) error                { return nil }
+func (f *fakeInstaller) PlaceFile(filePath, fileType string, opts installer.FileOptions) error {
+	return nil
+}
 func (f *fakeInstaller) WaitForBackgroundProcesses(timeout time.Duration) []error { return nil }
 func (f *fakeInstaller) GetBackgroundProcessCount() int                           { return 0 }
+func (f *fakeInstaller) ManageService(opts installer.ServiceOptions) error {
+	f.services = append(f.services, opts)
+	return nil
+}
+func (f *fakeInstaller) InstallAppStoreApp(opts installer.VPPAppOptions) error {
+	f.apps = append(f.apps, opts)
+	return nil
+}
+func (f *fakeInstaller) ConfigureMunki(opts installer.MunkiOptions) error {
+	f.munki = append(f.munki, opts)
+	return nil
+}
 
 var _ installer.Installer = (*fakeInstaller)(nil)
 
@@ -74,3 +111,291 @@ func TestManagerProcessItems_FailPolicy(t *testing.T) {
 		t.Fatalf("expected at least two script executions")
 	}
 }
+
+func TestManagerProcessItems_StopsWhenContextDone(t *testing.T) {
+	inst := &fakeInstaller{}
+	m := NewManager(&fakeDownloader{}, inst, config.NewConfig(), utils.NewLogger(false, false))
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errors.New("interrupted by SIGTERM"))
+	m.SetContext(ctx)
+
+	err := m.ProcessItems([]config.Item{{Name: "a", File: "ok.sh", Type: "rootscript", FailPolicy: "failable_execution"}}, "userland")
+	if err == nil || err.Error() != "userland phase stopped: interrupted by SIGTERM" {
+		t.Fatalf("expected stop error, got %v", err)
+	}
+	if inst.callCount() != 0 {
+		t.Fatalf("no item should start after the run is stopped")
+	}
+}
+
+func TestManagerProcessItems_RecordsSpans(t *testing.T) {
+	var names []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						Name string `json:"name"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		for _, s := range req.ResourceSpans[0].ScopeSpans[0].Spans {
+			names = append(names, s.Name)
+		}
+	}))
+	defer srv.Close()
+
+	logger := utils.NewLogger(false, false)
+	tracer := tracing.NewTracer(tracing.Options{Endpoint: srv.URL}, logger)
+	m := NewManager(&fakeDownloader{}, &fakeInstaller{}, config.NewConfig(), logger)
+	m.SetReporter(tracer)
+	m.SetTracer(tracer)
+
+	items := []config.Item{{Name: "good", File: "ok.sh", Type: "rootscript"}}
+	tracer.Start(items)
+	if err := m.ProcessItems(items, "setupassistant"); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	tracer.Finish(nil)
+
+	want := map[string]bool{"bootstrap": false, "phase setupassistant": false, "item good": false, "install": false}
+	for _, n := range names {
+		if _, ok := want[n]; ok {
+			want[n] = true
+		}
+	}
+	for n, seen := range want {
+		if !seen {
+			t.Fatalf("span %q not exported; got %v", n, names)
+		}
+	}
+}
+
+// A munki item is handed to the installer with the Munki* config keys and
+// the item's donotwait.
+func TestManagerProcessItems_MunkiHandoff(t *testing.T) {
+	inst := &fakeInstaller{}
+	cfg := config.NewConfig()
+	cfg.MunkiSoftwareRepoURL = "https://munki.example/repo"
+	cfg.MunkiClientIdentifier = "engineering"
+	m := NewManager(&fakeDownloader{}, inst, cfg, utils.NewLogger(false, false))
+
+	items := []config.Item{{Name: "Munki", Type: "munki", DoNotWait: true}}
+	if err := m.ProcessItems(items, "userland"); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	want := installer.MunkiOptions{SoftwareRepoURL: "https://munki.example/repo", ClientIdentifier: "engineering", DoNotWait: true}
+	if len(inst.munki) != 1 || inst.munki[0] != want {
+		t.Fatalf("munki handoff = %+v, want %+v", inst.munki, want)
+	}
+}
+
+// Only rootscripts with run_as go through ExecuteScriptAs.
+func TestManagerProcessItems_RunAs(t *testing.T) {
+	inst := &fakeInstaller{}
+	m := NewManager(&fakeDownloader{}, inst, config.NewConfig(), utils.NewLogger(false, false))
+
+	items := []config.Item{
+		{Name: "as root", Type: "rootscript", File: "a.sh"},
+		{Name: "as console", Type: "rootscript", File: "b.sh", RunAs: config.RunAsConsole},
+		{Name: "as service", Type: "rootscript", File: "c.sh", RunAs: "_svc"},
+	}
+	if err := m.ProcessItems(items, "userland"); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if want := []string{"console", "_svc"}; !reflect.DeepEqual(inst.runAs, want) || inst.callCount() != 3 {
+		t.Fatalf("run_as = %v (%d scripts), want %v", inst.runAs, inst.callCount(), want)
+	}
+}
+
+// A launchd item is handed to the installer and its plist is not cleaned up
+// with the downloads.
+func TestManagerProcessItems_Launchd(t *testing.T) {
+	inst := &fakeInstaller{}
+	cfg := config.NewConfig()
+	cfg.CleanupOnSuccess = true
+	m := NewManager(&fakeDownloader{}, inst, cfg, utils.NewLogger(false, false))
+
+	plistPath := filepath.Join(t.TempDir(), "com.example.d.plist")
+	if err := os.WriteFile(plistPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	items := []config.Item{{Name: "Load daemon", Type: "launchd", Action: "bootstrap", File: plistPath}}
+	if err := m.ProcessItems(items, "setupassistant"); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	want := installer.ServiceOptions{Action: "bootstrap", Plist: plistPath, Domain: "system"}
+	if len(inst.services) != 1 || inst.services[0] != want {
+		t.Fatalf("services = %+v, want %+v", inst.services, want)
+	}
+	m.Cleanup("success")
+	if _, err := os.Stat(plistPath); err != nil {
+		t.Errorf("job plist removed by cleanup: %v", err)
+	}
+}
+
+// A vppapp item is handed to the installer with the VPPInstall* config keys,
+// and the installed app it waits for is not cleaned up.
+func TestManagerProcessItems_VPPApp(t *testing.T) {
+	inst := &fakeInstaller{}
+	cfg := config.NewConfig()
+	cfg.CleanupOnSuccess = true
+	cfg.VPPInstallURL = "https://mdm.example/vpp"
+	m := NewManager(&fakeDownloader{}, inst, cfg, utils.NewLogger(false, false))
+
+	appPath := filepath.Join(t.TempDir(), "Keynote.app")
+	if err := os.Mkdir(appPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	items := []config.Item{{Name: "Keynote", Type: "vppapp", AdamID: "409183694", File: appPath}}
+	if err := m.ProcessItems(items, "userland"); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if len(inst.apps) != 1 || inst.apps[0].AdamID != "409183694" || inst.apps[0].AppPath != appPath ||
+		inst.apps[0].HookURL != cfg.VPPInstallURL || inst.apps[0].Timeout != cfg.VPPInstallTimeout {
+		t.Fatalf("apps = %+v", inst.apps)
+	}
+	m.Cleanup("success")
+	if _, err := os.Stat(appPath); err != nil {
+		t.Errorf("app removed by cleanup: %v", err)
+	}
+}
+
+func TestManagerProcessItems_Hooks(t *testing.T) {
+	inst := &fakeInstaller{}
+	cfg := config.NewConfig()
+	m := NewManager(&fakeDownloader{}, inst, cfg, utils.NewLogger(false, false))
+	hooks := &config.Hooks{
+		PreSetupAssistant:  []config.Item{{Name: "network", Type: "rootscript", File: "fail.sh", FailPolicy: "failure_is_not_an_option"}},
+		PostSetupAssistant: []config.Item{{Name: "tidy", Type: "rootscript", File: "tidy.sh"}},
+	}
+	m.SetHooks(hooks)
+	items := []config.Item{{Name: "app", Type: "rootscript", File: "app.sh"}}
+
+	if err := m.ProcessItems(items, "setupassistant"); err == nil {
+		t.Fatal("expected the pre hook to stop the phase")
+	}
+	if inst.callCount() != 1 {
+		t.Fatalf("phase items ran after a failed pre hook: %d scripts", inst.callCount())
+	}
+
+	// The default fail_policy tolerates the hook's script failure
+	hooks.PreSetupAssistant[0].FailPolicy = ""
+	if err := m.ProcessItems(items, "setupassistant"); err != nil {
+		t.Fatal(err)
+	}
+	if inst.callCount() != 4 {
+		t.Fatalf("want pre hook, item and post hook to run, got %d scripts in total", inst.callCount())
+	}
+
+	if err := m.ProcessItems(items, "userland"); err != nil || inst.callCount() != 5 {
+		t.Fatalf("setupassistant hooks ran for userland: %v, %d scripts", err, inst.callCount())
+	}
+}
+
+// stoppableInstaller blocks every script until the channel given to SetStop
+// is closed.
+func TestManagerProcessItems_Groups(t *testing.T) {
+	inst := &fakeInstaller{}
+	m := NewManager(&fakeDownloader{}, inst, config.NewConfig(), utils.NewLogger(false, false))
+	items := []config.Item{
+		{Name: "chrome", Type: "rootscript", File: "fail.sh", FailPolicy: "failable", Group: "browsers"},
+		{Name: "firefox", Type: "rootscript", File: "firefox.sh", FailPolicy: "failable", Group: "browsers"},
+		{Name: "dock", Type: "rootscript", File: "dock.sh"},
+	}
+
+	// A failed member skips the rest of its group; the phase goes on
+	if err := m.ProcessItems(items, "userland"); err != nil {
+		t.Fatal(err)
+	}
+	if inst.callCount() != 2 {
+		t.Fatalf("want chrome and dock to run, got %d scripts", inst.callCount())
+	}
+
+	items[0].FailPolicy, items[1].FailPolicy = "failure_is_not_an_option", "failure_is_not_an_option"
+	if err := m.ProcessItems(items, "userland"); err == nil {
+		t.Fatal("expected a mandatory group to stop the phase")
+	}
+}
+
+type stoppableInstaller struct {
+	fakeInstaller
+	stop <-chan struct{}
+}
+
+func (s *stoppableInstaller) SetStop(stop <-chan struct{}) { s.stop = stop }
+func (s *stoppableInstaller) ExecuteScript(scriptPath, scriptType string, doNotWait bool, track bool) error {
+	<-s.stop
+	return errors.New("script cancelled")
+}
+
+func TestManagerProcessItems_PhaseTimeout(t *testing.T) {
+	inst := &stoppableInstaller{}
+	cfg := config.NewConfig()
+	cfg.PhaseTimeout = 20 * time.Millisecond
+	m := NewManager(&fakeDownloader{}, inst, cfg, utils.NewLogger(false, false))
+
+	// The script's failure would be tolerated by fail_policy; the timeout is not
+	items := []config.Item{{Name: "hang", Type: "rootscript", File: "hang.sh", FailPolicy: "failable"}}
+	err := m.ProcessItems(items, "setupassistant")
+	var timeout *utils.TimeoutError
+	if !errors.As(err, &timeout) || timeout.What != "setupassistant phase" {
+		t.Fatalf("err = %v, want a setupassistant timeout", err)
+	}
+	if inst.stop != nil || m.ctx.Err() != nil {
+		t.Fatal("the phase context was not released after the phase")
+	}
+}
+
+// preflightInstaller passes preflight scripts named pass.sh, exits non-zero
+// for the others and blocks hang.sh until stopped, recording the order.
+type preflightInstaller struct {
+	stoppableInstaller
+	ran []string
+}
+
+func (p *preflightInstaller) ExecuteScriptForPreflight(scriptPath, scriptType string, doNotWait bool, track bool) error {
+	p.ran = append(p.ran, scriptPath)
+	switch scriptPath {
+	case "pass.sh":
+		return &installer.PreflightSuccessError{}
+	case "hang.sh":
+		return p.ExecuteScript(scriptPath, scriptType, doNotWait, track)
+	}
+	return nil
+}
+
+func TestManagerProcessItems_MultiplePreflight(t *testing.T) {
+	check := func(files ...string) []config.Item {
+		items := make([]config.Item, len(files))
+		for i, f := range files {
+			items[i] = config.Item{Name: f, Type: "rootscript", File: f}
+		}
+		return items
+	}
+	for _, tc := range []struct {
+		name    string
+		files   []string
+		passed  bool
+		wantRan []string
+	}{
+		{name: "all pass", files: []string{"pass.sh", "pass.sh"}, passed: true, wantRan: []string{"pass.sh", "pass.sh"}},
+		{name: "first fails", files: []string{"fail-mdm.sh", "pass.sh"}, wantRan: []string{"fail-mdm.sh"}},
+		{name: "last fails", files: []string{"pass.sh", "fail-edr.sh"}, wantRan: []string{"pass.sh", "fail-edr.sh"}},
+		{name: "timeout", files: []string{"pass.sh", "hang.sh", "pass.sh"}, wantRan: []string{"pass.sh", "hang.sh"}},
+	} {
+		inst := &preflightInstaller{}
+		m := NewManager(&fakeDownloader{}, inst, config.NewConfig(), utils.NewLogger(false, false))
+		m.SetPreflightOptions(&config.PreflightOptions{Multiple: true, Timeout: 1})
+		err := m.ProcessItems(check(tc.files...), "preflight")
+		_, passed := err.(*installer.PreflightSuccessError)
+		if passed != tc.passed || (!passed && err != nil) {
+			t.Errorf("%s: err = %v, want passed=%t", tc.name, err, tc.passed)
+		}
+		if !reflect.DeepEqual(inst.ran, tc.wantRan) {
+			t.Errorf("%s: ran %v, want %v", tc.name, inst.ran, tc.wantRan)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/installer"
 	"github.com/go-installapplications/pkg/utils"
 )
 
@@ -29,7 +30,9 @@ func (r *recordingInstaller) trackEntry() {
 }
 func (r *recordingInstaller) trackExit() { atomic.AddInt32(&r.inFlight, -1) }
 
-func (r *recordingInstaller) InstallPackage(_, _ string) error { return nil }
+func (r *recordingInstaller) InstallPackage(_ string, _ installer.PackageOptions) error {
+	return nil
+}
 func (r *recordingInstaller) ExecuteScript(_, _ string, _ bool, _ bool) error {
 	r.trackEntry()
 	defer r.trackExit()
@@ -39,8 +42,17 @@ func (r *recordingInstaller) ExecuteScript(_, _ string, _ bool, _ bool) error {
 	}
 	return nil
 }
+func (r *recordingInstaller) ExecuteScriptAs(path, _ string, doNotWait bool, track bool) error {
+	return r.ExecuteScript(path, "rootscript", doNotWait, track)
+}
+func (r *recordingInstaller) ExecuteUserScript(path string, _ installer.UserContext, doNotWait bool, track bool) error {
+	return r.ExecuteScript(path, "userscript", doNotWait, track)
+}
 func (r *recordingInstaller) ExecuteScriptForPreflight(_, _ string, _ bool, _ bool) error { return nil }
-func (r *recordingInstaller) PlaceFile(_, _ string) error                                 { return nil }
+func (r *recordingInstaller) PlaceFile(_, _ string, _ installer.FileOptions) error        { return nil }
+func (r *recordingInstaller) ManageService(_ installer.ServiceOptions) error              { return nil }
+func (r *recordingInstaller) InstallAppStoreApp(_ installer.VPPAppOptions) error          { return nil }
+func (r *recordingInstaller) ConfigureMunki(_ installer.MunkiOptions) error               { return nil }
 func (r *recordingInstaller) WaitForBackgroundProcesses(_ time.Duration) []error          { return nil }
 func (r *recordingInstaller) GetBackgroundProcessCount() int                              { return 0 }
 
@@ -130,3 +142,28 @@ func TestManager_NoParallelGroupRunsSerially(t *testing.T) {
 		t.Fatalf("expected sequential execution (maxInFlight=1), got %d", inst.maxInFlight)
 	}
 }
+
+func TestManager_ParallelSafeBoundedByInstallMaxConcurrency(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.InstallMaxConcurrency = 2
+	logger := utils.NewLogger(false, false)
+
+	inst := &recordingInstaller{delay: 50 * time.Millisecond}
+	m := NewManager(&fakeDownloader{}, inst, cfg, logger)
+
+	items := []config.Item{
+		{Name: "a", File: "a.sh", Type: "rootscript", ParallelSafe: true},
+		{Name: "b", File: "b.sh", Type: "rootscript", ParallelSafe: true},
+		{Name: "c", File: "c.sh", Type: "rootscript", ParallelSafe: true},
+		{Name: "d", File: "d.sh", Type: "rootscript", ParallelSafe: true},
+	}
+	if err := m.ProcessItems(items, "userland"); err != nil {
+		t.Fatalf("ProcessItems: %v", err)
+	}
+	if atomic.LoadInt32(&inst.scriptCount) != 4 {
+		t.Fatalf("expected 4 script runs, got %d", inst.scriptCount)
+	}
+	if got := atomic.LoadInt32(&inst.maxInFlight); got != 2 {
+		t.Fatalf("expected 2 items at a time, got %d", got)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/installer"
 	"github.com/go-installapplications/pkg/utils"
 )
 
@@ -19,13 +20,21 @@ type countingInstaller struct {
 
 func (c *countingInstaller) callCount() int { return int(c.scripts.Load()) }
 
-func (c *countingInstaller) InstallPackage(_, _ string) error                   { c.packages.Add(1); return nil }
+func (c *countingInstaller) InstallPackage(_ string, _ installer.PackageOptions) error { c.packages.Add(1); return nil }
 func (c *countingInstaller) ExecuteScript(_, _ string, _ bool, _ bool) error    { c.scripts.Add(1); return nil }
+func (c *countingInstaller) ExecuteScriptAs(_, _ string, _ bool, _ bool) error  { c.scripts.Add(1); return nil }
+func (c *countingInstaller) ExecuteUserScript(_ string, _ installer.UserContext, _ bool, _ bool) error {
+	c.scripts.Add(1)
+	return nil
+}
 func (c *countingInstaller) ExecuteScriptForPreflight(_, _ string, _ bool, _ bool) error {
 	c.scripts.Add(1)
 	return nil
 }
-func (c *countingInstaller) PlaceFile(_, _ string) error                          { c.files.Add(1); return nil }
+func (c *countingInstaller) PlaceFile(_, _ string, _ installer.FileOptions) error { c.files.Add(1); return nil }
+func (c *countingInstaller) ManageService(_ installer.ServiceOptions) error     { return nil }
+func (c *countingInstaller) InstallAppStoreApp(_ installer.VPPAppOptions) error { return nil }
+func (c *countingInstaller) ConfigureMunki(_ installer.MunkiOptions) error     { return nil }
 func (c *countingInstaller) WaitForBackgroundProcesses(_ time.Duration) []error { return nil }
 func (c *countingInstaller) GetBackgroundProcessCount() int                      { return 0 }
 
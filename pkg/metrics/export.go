@@ -0,0 +1,336 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-installapplications/pkg/otlp"
+	"github.com/go-installapplications/pkg/utils"
+	"github.com/go-installapplications/pkg/version"
+)
+
+// Push formats accepted by ExportOptions.PushFormat.
+const (
+	FormatPushgateway = "pushgateway"
+	FormatOTLP        = "otlp"
+)
+
+// DefaultJob is the Pushgateway job / OTLP service name.
+const DefaultJob = "go-installapplications"
+
+// WriteText writes all metrics in the Prometheus text exposition format.
+func (r *Recorder) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, f := range r.snapshot() {
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.kind)
+		for _, s := range f.series {
+			if f.kind != kindHistogram {
+				fmt.Fprintf(&b, "%s%s %s\n", f.name, formatLabels(s.labels), formatFloat(s.value))
+				continue
+			}
+			var cum uint64
+			for i, n := range s.buckets {
+				cum += n
+				le := "+Inf"
+				if i < len(durationBuckets) {
+					le = formatFloat(durationBuckets[i])
+				}
+				labels := append(append([][2]string(nil), s.labels...), [2]string{"le", le})
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, formatLabels(labels), cum)
+			}
+			fmt.Fprintf(&b, "%s_sum%s %s\n", f.name, formatLabels(s.labels), formatFloat(s.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", f.name, formatLabels(s.labels), s.count)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatLabels(labels [][2]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels))
+	for _, l := range labels {
+		parts = append(parts, fmt.Sprintf("%s=%q", l[0], l[1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// OTLP/HTTP JSON payload types (opentelemetry-proto metrics/v1). Only the
+// fields we emit are modelled.
+type otlpNumberPoint struct {
+	Attributes        []otlp.KeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlp.KeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+}
+
+type otlpMetric struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Sum         *struct {
+		DataPoints             []otlpNumberPoint `json:"dataPoints"`
+		AggregationTemporality int               `json:"aggregationTemporality"`
+		IsMonotonic            bool              `json:"isMonotonic"`
+	} `json:"sum,omitempty"`
+	Gauge *struct {
+		DataPoints []otlpNumberPoint `json:"dataPoints"`
+	} `json:"gauge,omitempty"`
+	Histogram *struct {
+		DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+		AggregationTemporality int                  `json:"aggregationTemporality"`
+	} `json:"histogram,omitempty"`
+}
+
+// aggregationCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const aggregationCumulative = 2
+
+// OTLPJSON encodes all metrics as an OTLP ExportMetricsServiceRequest.
+func (r *Recorder) OTLPJSON(serviceName, instance string) ([]byte, error) {
+	r.mu.Lock()
+	start := strconv.FormatInt(r.start.UnixNano(), 10)
+	r.mu.Unlock()
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	var metrics []otlpMetric
+	for _, f := range r.snapshot() {
+		m := otlpMetric{Name: f.name, Description: f.help}
+		for _, s := range f.series {
+			attrs := make([]otlp.KeyValue, 0, len(s.labels))
+			for _, l := range s.labels {
+				attrs = append(attrs, otlp.String(l[0], l[1]))
+			}
+			switch f.kind {
+			case kindCounter:
+				if m.Sum == nil {
+					m.Sum = &struct {
+						DataPoints             []otlpNumberPoint `json:"dataPoints"`
+						AggregationTemporality int               `json:"aggregationTemporality"`
+						IsMonotonic            bool              `json:"isMonotonic"`
+					}{AggregationTemporality: aggregationCumulative, IsMonotonic: true}
+				}
+				m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberPoint{attrs, start, now, s.value})
+			case kindGauge:
+				if m.Gauge == nil {
+					m.Gauge = &struct {
+						DataPoints []otlpNumberPoint `json:"dataPoints"`
+					}{}
+				}
+				m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberPoint{attrs, start, now, s.value})
+			case kindHistogram:
+				if m.Histogram == nil {
+					m.Histogram = &struct {
+						DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+						AggregationTemporality int                  `json:"aggregationTemporality"`
+					}{AggregationTemporality: aggregationCumulative}
+				}
+				counts := make([]string, len(s.buckets))
+				for i, n := range s.buckets {
+					counts[i] = strconv.FormatUint(n, 10)
+				}
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, otlpHistogramPoint{
+					Attributes:        attrs,
+					StartTimeUnixNano: start,
+					TimeUnixNano:      now,
+					Count:             strconv.FormatUint(s.count, 10),
+					Sum:               s.sum,
+					BucketCounts:      counts,
+					ExplicitBounds:    durationBuckets,
+				})
+			}
+		}
+		// OTLP rejects metrics without data points
+		if m.Sum != nil || m.Gauge != nil || m.Histogram != nil {
+			metrics = append(metrics, m)
+		}
+	}
+
+	payload := map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource": otlp.NewResource(serviceName, instance),
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope":   otlp.Scope{Name: "github.com/go-installapplications/pkg/metrics"},
+				"metrics": metrics,
+			}},
+		}},
+	}
+	return json.Marshal(payload)
+}
+
+// ExportOptions controls how an Exporter publishes metrics.
+type ExportOptions struct {
+	// ListenAddress serves /metrics while the run is in progress (e.g.
+	// 127.0.0.1:9464). Empty disables the endpoint.
+	ListenAddress string
+	// Linger keeps the endpoint up after the run ends so a scraper can
+	// collect the final values.
+	Linger time.Duration
+	// PushURL receives the metrics once at run end. Empty disables pushing.
+	PushURL string
+	// PushFormat is FormatPushgateway (default) or FormatOTLP.
+	PushFormat string
+	// Job is the Pushgateway job / OTLP service name.
+	Job string
+	// Instance identifies this machine; defaults to the hostname.
+	Instance string
+}
+
+// Exporter is a Recorder that publishes its metrics when the run finishes.
+type Exporter struct {
+	*Recorder
+	opts   ExportOptions
+	logger utils.Logger
+	client *http.Client
+	server *http.Server
+	addr   net.Addr
+}
+
+// NewExporter creates an Exporter with a fresh Recorder.
+func NewExporter(opts ExportOptions, logger utils.Logger) *Exporter {
+	if opts.Job == "" {
+		opts.Job = DefaultJob
+	}
+	if opts.PushFormat == "" {
+		opts.PushFormat = FormatPushgateway
+	}
+	if opts.Instance == "" {
+		opts.Instance = otlp.Hostname()
+	}
+	return &Exporter{
+		Recorder: NewRecorder(),
+		opts:     opts,
+		logger:   logger,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Serve starts the /metrics endpoint if a listen address is configured.
+func (e *Exporter) Serve() error {
+	if e.opts.ListenAddress == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", e.opts.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", e.opts.ListenAddress, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = e.WriteText(w)
+	})
+	e.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	e.addr = ln.Addr()
+	go func() {
+		if err := e.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			e.logger.Debug("Metrics endpoint stopped: %v", err)
+		}
+	}()
+	e.logger.Info("📈 Serving metrics on http://%s/metrics", ln.Addr())
+	return nil
+}
+
+// listenAddr returns the bound endpoint address, or "" when not serving.
+func (e *Exporter) listenAddr() string {
+	if e.addr == nil {
+		return ""
+	}
+	return e.addr.String()
+}
+
+// Finish records the run outcome, pushes the metrics and shuts the endpoint
+// down after the configured linger. Publishing errors are logged only.
+func (e *Exporter) Finish(err error) {
+	e.Recorder.Finish(err)
+	if e.opts.PushURL != "" {
+		if perr := e.Push(); perr != nil {
+			e.logger.Info("⚠️  Failed to push metrics: %v", perr)
+		} else {
+			e.logger.Debug("Pushed metrics to %s", e.opts.PushURL)
+		}
+	}
+	if e.server != nil {
+		if e.opts.Linger > 0 {
+			e.logger.Debug("Keeping metrics endpoint up for %v", e.opts.Linger)
+			time.Sleep(e.opts.Linger)
+		}
+		_ = e.server.Close()
+		e.server = nil
+	}
+}
+
+// Push sends the current metrics to PushURL in the configured format.
+func (e *Exporter) Push() error {
+	var (
+		method, target, contentType string
+		body                        bytes.Buffer
+	)
+	switch strings.ToLower(e.opts.PushFormat) {
+	case FormatOTLP:
+		data, err := e.OTLPJSON(e.opts.Job, e.opts.Instance)
+		if err != nil {
+			return fmt.Errorf("failed to encode OTLP metrics: %w", err)
+		}
+		body.Write(data)
+		method, target, contentType = http.MethodPost, e.opts.PushURL, "application/json"
+	case FormatPushgateway:
+		if err := e.WriteText(&body); err != nil {
+			return err
+		}
+		method, contentType = http.MethodPut, "text/plain; version=0.0.4"
+		target = pushgatewayURL(e.opts.PushURL, e.opts.Job, e.opts.Instance)
+	default:
+		return fmt.Errorf("unknown metrics push format %q", e.opts.PushFormat)
+	}
+
+	req, err := http.NewRequest(method, target, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", version.UserAgent())
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("metrics push failed with status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// pushgatewayURL appends the grouping key unless the URL already has one.
+func pushgatewayURL(base, job, instance string) string {
+	if strings.Contains(base, "/metrics/job/") {
+		return base
+	}
+	return fmt.Sprintf("%s/metrics/job/%s/instance/%s", strings.TrimRight(base, "/"), url.PathEscape(job), url.PathEscape(instance))
+}
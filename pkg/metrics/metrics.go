@@ -0,0 +1,249 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+)
+
+// Metric names. All metrics share the installapplications_ prefix so fleet
+// dashboards can select them with a single matcher.
+const (
+	DownloadsTotal          = "installapplications_downloads_total"
+	DownloadBytesTotal      = "installapplications_download_bytes_total"
+	DownloadRetriesTotal    = "installapplications_download_retries_total"
+	DownloadDurationSeconds = "installapplications_download_duration_seconds"
+	ItemsTotal              = "installapplications_items_total"
+	ItemDurationSeconds     = "installapplications_item_duration_seconds"
+	RunDurationSeconds      = "installapplications_run_duration_seconds"
+	RunSuccess              = "installapplications_run_success"
+)
+
+// durationBuckets are histogram upper bounds in seconds, sized for package
+// installs that range from sub-second scripts to multi-minute Xcode pkgs.
+var durationBuckets = []float64{0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// family is one named metric with all its label combinations.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	series map[string]*sample // keyed by encoded label pairs
+}
+
+// sample is one labelled series. Counters and gauges use value; histograms
+// use count, sum and cumulative-at-export bucket counts.
+type sample struct {
+	labels  [][2]string
+	value   float64
+	count   uint64
+	sum     float64
+	buckets []uint64 // per-bucket (non-cumulative) counts; last entry is +Inf
+}
+
+// Recorder collects run metrics in memory. It satisfies progress.Reporter so
+// it can be attached next to the progress UI, and the download client feeds
+// it directly. All methods are safe on a nil *Recorder.
+type Recorder struct {
+	mu       sync.Mutex
+	families []*family
+	byName   map[string]*family
+	start    time.Time
+	started  map[string]time.Time // item name -> start time
+}
+
+// NewRecorder creates a Recorder with every metric registered, so exports
+// always list the full set even when a value is still zero.
+func NewRecorder() *Recorder {
+	r := &Recorder{
+		byName:  map[string]*family{},
+		start:   time.Now(),
+		started: map[string]time.Time{},
+	}
+	r.register(DownloadsTotal, kindCounter, "Downloads attempted, by result.")
+	r.register(DownloadBytesTotal, kindCounter, "Bytes written by download attempts.")
+	r.register(DownloadRetriesTotal, kindCounter, "Download attempts beyond the first.")
+	r.register(DownloadDurationSeconds, kindHistogram, "Download duration including retries and hash check.")
+	r.register(ItemsTotal, kindCounter, "Bootstrap items processed, by type and result (success, failure, skipped).")
+	r.register(ItemDurationSeconds, kindHistogram, "Install/execution duration per item, by type.")
+	r.register(RunDurationSeconds, kindGauge, "Wall-clock duration of the run.")
+	r.register(RunSuccess, kindGauge, "1 if the run completed successfully, 0 otherwise.")
+	return r
+}
+
+func (r *Recorder) register(name, kind, help string) {
+	f := &family{name: name, help: help, kind: kind, series: map[string]*sample{}}
+	r.families = append(r.families, f)
+	r.byName[name] = f
+}
+
+// seriesLocked returns the sample for name+labels, creating it on first use;
+// callers must hold r.mu. labels are name/value pairs.
+func (r *Recorder) seriesLocked(name string, labels ...string) *sample {
+	f := r.byName[name]
+	var key strings.Builder
+	pairs := make([][2]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, [2]string{labels[i], labels[i+1]})
+		key.WriteString(labels[i] + "\x00" + labels[i+1] + "\x00")
+	}
+	s, ok := f.series[key.String()]
+	if !ok {
+		s = &sample{labels: pairs}
+		if f.kind == kindHistogram {
+			s.buckets = make([]uint64, len(durationBuckets)+1)
+		}
+		f.series[key.String()] = s
+	}
+	return s
+}
+
+func (r *Recorder) add(name string, v float64, labels ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seriesLocked(name, labels...).value += v
+}
+
+func (r *Recorder) set(name string, v float64, labels ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seriesLocked(name, labels...).value = v
+}
+
+func (r *Recorder) observe(name string, v float64, labels ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.seriesLocked(name, labels...)
+	s.count++
+	s.sum += v
+	i := sort.SearchFloat64s(durationBuckets, v)
+	s.buckets[i]++
+}
+
+// ObserveDownload records one download (all attempts) and its outcome.
+func (r *Recorder) ObserveDownload(d time.Duration, attempts int, bytes int64, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	r.add(DownloadsTotal, 1, "result", result)
+	if attempts > 1 {
+		r.add(DownloadRetriesTotal, float64(attempts-1))
+	}
+	if bytes > 0 {
+		r.add(DownloadBytesTotal, float64(bytes))
+	}
+	r.observe(DownloadDurationSeconds, d.Seconds())
+}
+
+// Start resets the run clock.
+func (r *Recorder) Start([]config.Item) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.start = time.Now()
+}
+
+// ItemStarted records when the item began.
+func (r *Recorder) ItemStarted(item config.Item) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started[item.Name] = time.Now()
+}
+
+// ItemFinished counts the item and observes its duration if it was started.
+func (r *Recorder) ItemFinished(item config.Item, err error) {
+	if r == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	r.add(ItemsTotal, 1, "type", item.Type, "result", result)
+
+	r.mu.Lock()
+	began, ok := r.started[item.Name]
+	delete(r.started, item.Name)
+	r.mu.Unlock()
+	if ok {
+		r.observe(ItemDurationSeconds, time.Since(began).Seconds(), "type", item.Type)
+	}
+}
+
+// ItemSkipped counts the item as skipped.
+func (r *Recorder) ItemSkipped(item config.Item, _ string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	delete(r.started, item.Name)
+	r.mu.Unlock()
+	r.add(ItemsTotal, 1, "type", item.Type, "result", "skipped")
+}
+
+// Finish records the run duration and outcome.
+func (r *Recorder) Finish(err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	elapsed := time.Since(r.start)
+	r.mu.Unlock()
+	r.set(RunDurationSeconds, elapsed.Seconds())
+	if err != nil {
+		r.set(RunSuccess, 0)
+	} else {
+		r.set(RunSuccess, 1)
+	}
+}
+
+// snapshot returns a deep copy of all families with series in stable order.
+func (r *Recorder) snapshot() []familySnapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]familySnapshot, 0, len(r.families))
+	for _, f := range r.families {
+		fs := familySnapshot{name: f.name, help: f.help, kind: f.kind}
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := *f.series[k]
+			s.buckets = append([]uint64(nil), s.buckets...)
+			fs.series = append(fs.series, s)
+		}
+		out = append(out, fs)
+	}
+	return out
+}
+
+type familySnapshot struct {
+	name, help, kind string
+	series           []sample
+}
@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+func recordRun(r *Recorder) {
+	pkg := config.Item{Name: "Tool", Type: "package"}
+	script := config.Item{Name: "Setup", Type: "rootscript"}
+	r.Start(nil)
+	r.ItemStarted(pkg)
+	r.ItemFinished(pkg, nil)
+	r.ItemStarted(script)
+	r.ItemFinished(script, errors.New("exit 1"))
+	r.ItemSkipped(config.Item{Name: "Rosetta", Type: "package"}, "skip_if arm64")
+	r.ObserveDownload(2*time.Second, 3, 1024, nil)
+	r.Finish(errors.New("failed"))
+}
+
+func TestWriteText(t *testing.T) {
+	r := NewRecorder()
+	recordRun(r)
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE installapplications_downloads_total counter",
+		`installapplications_downloads_total{result="success"} 1`,
+		"installapplications_download_retries_total 2",
+		"installapplications_download_bytes_total 1024",
+		`installapplications_download_duration_seconds_bucket{le="1"} 0`,
+		`installapplications_download_duration_seconds_bucket{le="5"} 1`,
+		`installapplications_download_duration_seconds_bucket{le="+Inf"} 1`,
+		"installapplications_download_duration_seconds_count 1",
+		`installapplications_items_total{type="package",result="success"} 1`,
+		`installapplications_items_total{type="package",result="skipped"} 1`,
+		`installapplications_items_total{type="rootscript",result="failure"} 1`,
+		`installapplications_item_duration_seconds_count{type="rootscript"} 1`,
+		"installapplications_run_success 0",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestNilRecorderIsSafe(t *testing.T) {
+	var r *Recorder
+	recordRun(r)
+}
+
+func TestPushPushgateway(t *testing.T) {
+	var method, path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := io.ReadAll(req.Body)
+		method, path, body = req.Method, req.URL.Path, string(data)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	e := NewExporter(ExportOptions{PushURL: srv.URL + "/", Instance: "mac-01"}, utils.NewLogger(false, false))
+	recordRun(e.Recorder)
+	if err := e.Push(); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/go-installapplications/instance/mac-01" {
+		t.Fatalf("unexpected request %s %s", method, path)
+	}
+	if !strings.Contains(body, "installapplications_run_success 0") {
+		t.Fatalf("body missing metrics:\n%s", body)
+	}
+}
+
+func TestPushOTLP(t *testing.T) {
+	var payload struct {
+		ResourceMetrics []struct {
+			ScopeMetrics []struct {
+				Metrics []struct {
+					Name      string          `json:"name"`
+					Sum       json.RawMessage `json:"sum"`
+					Histogram json.RawMessage `json:"histogram"`
+				} `json:"metrics"`
+			} `json:"scopeMetrics"`
+		} `json:"resourceMetrics"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewDecoder(req.Body).Decode(&payload)
+	}))
+	defer srv.Close()
+
+	e := NewExporter(ExportOptions{PushURL: srv.URL + "/v1/metrics", PushFormat: FormatOTLP}, utils.NewLogger(false, false))
+	recordRun(e.Recorder)
+	if err := e.Push(); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	names := map[string]bool{}
+	for _, m := range payload.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		names[m.Name] = true
+		if m.Name == DownloadDurationSeconds && m.Histogram == nil {
+			t.Fatalf("%s should be a histogram", m.Name)
+		}
+		if m.Name == DownloadsTotal && m.Sum == nil {
+			t.Fatalf("%s should be a sum", m.Name)
+		}
+	}
+	for _, want := range []string{DownloadsTotal, DownloadDurationSeconds, ItemsTotal, RunSuccess} {
+		if !names[want] {
+			t.Fatalf("OTLP payload missing %s: %v", want, names)
+		}
+	}
+}
+
+func TestServeEndpoint(t *testing.T) {
+	e := NewExporter(ExportOptions{ListenAddress: "127.0.0.1:0"}, utils.NewLogger(false, false))
+	if err := e.Serve(); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	defer e.Finish(nil)
+
+	resp, err := http.Get("http://" + e.listenAddr() + "/metrics")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(data), "# TYPE installapplications_run_success gauge") {
+		t.Fatalf("unexpected body:\n%s", data)
+	}
+}
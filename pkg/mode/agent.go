@@ -1,30 +1,56 @@
 package mode
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-installapplications/pkg/config"
 	"github.com/go-installapplications/pkg/installer"
 	"github.com/go-installapplications/pkg/ipc"
+	"github.com/go-installapplications/pkg/progress"
 	"github.com/go-installapplications/pkg/utils"
 )
 
 // RunAgent executes the agent mode workflow
-func RunAgent(cfg *config.Config, logger *utils.Logger) {
+func RunAgent(cfg *config.Config, logger utils.Logger) {
 	logger.Info("Starting agent mode")
+	ctx := watchShutdown(logger)
 
 	// A single long-lived SystemInstaller so its ProcessTracker survives across
 	// requests. Without this, donotwait userscripts would be tracked in a
 	// per-request tracker that gets GC'd, defeating TrackBackgroundProcesses.
 	systemInstaller := installer.NewSystemInstaller(cfg.DryRun, logger, true)
 
+	// Progress UI requested by the daemon; runs in this user session.
+	progressUI := &agentProgressUI{cfg: cfg, logger: logger}
+
+	// Foreground userscripts by request ID, so Cancel can stop them.
+	running := newRunningScripts()
+
 	// Start IPC server to receive requests from daemon for user-context actions.
 	// shutdownOnce guards close(done) so repeated Shutdown commands cannot panic.
 	done := make(chan struct{})
 	var shutdownOnce sync.Once
-	_, err := startAgentIPCServer(logger, func(req ipc.RPCRequest) ipc.RPCResponse {
+	server, err := startAgentIPCServer(logger, func(req ipc.RPCRequest, body io.Reader, stream func(name, line string)) ipc.RPCResponse {
+		if ctx.Err() != nil {
+			return ipc.RPCResponse{ID: req.ID, OK: false, Error: "agent is shutting down"}
+		}
 		switch req.Command {
+		case "Hello":
+			resp := ipc.HelloResponse(req)
+			if !resp.OK {
+				logger.Error("Rejected IPC handshake: %s", resp.Error)
+			}
+			return resp
 		case "Ping":
 			return ipc.RPCResponse{ID: req.ID, OK: true}
 		case "Shutdown":
@@ -32,18 +58,75 @@ func RunAgent(cfg *config.Config, logger *utils.Logger) {
 			shutdownOnce.Do(func() { close(done) })
 			return ipc.RPCResponse{ID: req.ID, OK: true}
 		case "RunUserScript":
-			if err := systemInstaller.ExecuteScript(req.Path, "userscript", req.DoNotWait, cfg.TrackBackgroundProcesses); err != nil {
+			uc := installer.UserContext{InstallPath: cfg.InstallPath, ItemName: req.ItemName}
+			if req.DoNotWait {
+				if err := systemInstaller.ExecuteUserScript(req.Path, uc, true, cfg.TrackBackgroundProcesses); err != nil {
+					return ipc.RPCResponse{ID: req.ID, OK: false, Error: err.Error()}
+				}
+				return ipc.RPCResponse{ID: req.ID, OK: true, Started: true}
+			}
+			script := running.start(req.ID)
+			var stdout, stderr io.Writer = io.Discard, io.Discard
+			if req.Stream {
+				outStreamer, errStreamer := newLineStreamer("stdout", stream), newLineStreamer("stderr", stream)
+				// Deferred so trailing partial lines go out before the final response
+				defer errStreamer.Flush()
+				defer outStreamer.Flush()
+				stdout, stderr = outStreamer, errStreamer
+			}
+			err := systemInstaller.ExecuteUserScriptStreaming(req.Path, uc, cfg.TrackBackgroundProcesses, stdout, stderr, script.cancel)
+			var cancelled *installer.ScriptCancelledError
+			if errors.As(err, &cancelled) {
+				running.finish(req.ID, string(cancelled.Output))
+				return ipc.RPCResponse{ID: req.ID, OK: false, Cancelled: true, Output: string(cancelled.Output), Error: err.Error()}
+			}
+			running.finish(req.ID, "")
+			if err != nil {
 				return ipc.RPCResponse{ID: req.ID, OK: false, Error: err.Error()}
 			}
-			return ipc.RPCResponse{ID: req.ID, OK: true, Started: req.DoNotWait}
+			return ipc.RPCResponse{ID: req.ID, OK: true}
+		case "Cancel":
+			output, err := running.cancel(req.Target, agentCancelWait)
+			if err != nil {
+				return ipc.RPCResponse{ID: req.ID, OK: false, Error: err.Error()}
+			}
+			logger.Info("⛔ Cancelled userscript request %s", req.Target)
+			return ipc.RPCResponse{ID: req.ID, OK: true, Cancelled: true, Output: output}
 		case "PlaceUserFile":
-			if err := systemInstaller.PlaceFile(req.Path, "userfile"); err != nil {
+			if err := systemInstaller.PlaceFile(req.Path, "userfile", installer.FileOptions{Mode: os.FileMode(req.Mode).Perm(), StripQuarantine: req.StripQuarantine, XAttrs: req.XAttrs}); err != nil {
+				return ipc.RPCResponse{ID: req.ID, OK: false, Error: err.Error()}
+			}
+			return ipc.RPCResponse{ID: req.ID, OK: true}
+		case "TransferFile":
+			if cfg.DryRun {
+				_, _ = io.Copy(io.Discard, body)
+				logger.Info("[DRY RUN] Would place file: %s (userfile)", req.Path)
+				return ipc.RPCResponse{ID: req.ID, OK: true}
+			}
+			target, err := receiveFile(req, body)
+			if err != nil {
+				// Drain the rest so the daemon reads this error, not a broken pipe
+				_, _ = io.Copy(io.Discard, body)
+				return ipc.RPCResponse{ID: req.ID, OK: false, Error: err.Error()}
+			}
+			logger.Info("File placed: %s", target)
+			return ipc.RPCResponse{ID: req.ID, OK: true}
+		case "ShowProgress", "UpdateProgress", "DismissProgress":
+			if err := progressUI.handle(req); err != nil {
+				return ipc.RPCResponse{ID: req.ID, OK: false, Error: err.Error()}
+			}
+			return ipc.RPCResponse{ID: req.ID, OK: true}
+		case "Notify":
+			if err := execLauncher(logger)(osascriptPath, notifyDialogArgs(req.Message, time.Duration(req.TimeoutSeconds)*time.Second)); err != nil {
 				return ipc.RPCResponse{ID: req.ID, OK: false, Error: err.Error()}
 			}
 			return ipc.RPCResponse{ID: req.ID, OK: true}
 		case "GetBackgroundProcessCount":
 			return ipc.RPCResponse{ID: req.ID, OK: true, Count: systemInstaller.GetBackgroundProcessCount()}
-		case "WaitForBackgroundProcesses":
+		case "GetBackgroundStatus":
+			statuses := systemInstaller.GetBackgroundStatus()
+			return ipc.RPCResponse{ID: req.ID, OK: true, Count: len(statuses), Background: statuses}
+		case "WaitForBackground", "WaitForBackgroundProcesses":
 			timeout := time.Duration(req.TimeoutSeconds) * time.Second
 			if timeout <= 0 {
 				timeout = cfg.BackgroundTimeout
@@ -63,9 +146,241 @@ func RunAgent(cfg *config.Config, logger *utils.Logger) {
 	})
 	if err != nil {
 		logger.Error("Failed to start agent IPC: %v", err)
-		utils.Exit(cfg, logger, 1, "failed to start agent IPC")
+		utils.Exit(cfg, logger, utils.ExitFailure, "failed to start agent IPC")
 	}
 
-	// Keep the agent process alive until a shutdown request is received
-	<-done
+	// Keep the agent process alive until a shutdown request or signal is
+	// received. On a signal, new requests are refused and running userscripts
+	// are stopped before exiting.
+	select {
+	case <-done:
+	case <-ctx.Done():
+		server.Close()
+		for _, err := range running.cancelAll(agentCancelWait) {
+			logger.Error("Failed to stop userscript: %v", err)
+		}
+		utils.ExitInterrupted(ctx, logger)
+	}
+}
+
+// receiveFile writes a TransferFile body to req.Path with req.Mode, creating
+// missing directories with req.DirMode (0755 if unset) and replacing
+// any existing file atomically. The agent runs as the user, so the file is
+// owned by them and protected per-user locations are reachable.
+func receiveFile(req ipc.RPCRequest, body io.Reader) (string, error) {
+	target := req.Path
+	if strings.HasPrefix(target, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve home directory: %w", err)
+		}
+		target = filepath.Join(home, target[2:])
+	}
+	if !filepath.IsAbs(target) {
+		return "", fmt.Errorf("transfer target must be an absolute path: %s", req.Path)
+	}
+	mode := os.FileMode(req.Mode).Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+
+	dir := filepath.Dir(target)
+	if dirMode := os.FileMode(req.DirMode).Perm(); dirMode != 0 {
+		if err := utils.EnsureDirForFileMode(target, dirMode); err != nil {
+			return "", err
+		}
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".transfer-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(body, req.Size+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("failed to receive file: %w", err)
+	case n != req.Size:
+		return "", fmt.Errorf("received %d bytes, expected %d", n, req.Size)
+	case hex.EncodeToString(h.Sum(nil)) != req.SHA256:
+		return "", fmt.Errorf("checksum mismatch for %s", target)
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return "", fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if req.StripQuarantine || len(req.XAttrs) > 0 {
+		if err := utils.SetXAttrs(tmp.Name(), req.StripQuarantine, req.XAttrs); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return "", fmt.Errorf("failed to place file: %w", err)
+	}
+	return target, nil
+}
+
+// agentCancelWait bounds how long Cancel waits for the script to exit. It
+// covers the SIGTERM grace period before the process group is killed.
+var agentCancelWait = 15 * time.Second
+
+// runningScripts tracks foreground userscripts by the ID of the request that
+// started them.
+type runningScripts struct {
+	mu      sync.Mutex
+	scripts map[string]*runningScript
+}
+
+type runningScript struct {
+	cancel     chan struct{}
+	cancelOnce sync.Once
+	done       chan struct{}
+	output     string // valid once done is closed
+}
+
+func newRunningScripts() *runningScripts {
+	return &runningScripts{scripts: map[string]*runningScript{}}
+}
+
+// start registers a script for request id.
+func (r *runningScripts) start(id string) *runningScript {
+	s := &runningScript{cancel: make(chan struct{}), done: make(chan struct{})}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scripts[id] = s
+	return s
+}
+
+// finish records the output a cancelled script produced and unregisters it.
+func (r *runningScripts) finish(id, output string) {
+	r.mu.Lock()
+	s := r.scripts[id]
+	delete(r.scripts, id)
+	r.mu.Unlock()
+	if s != nil {
+		s.output = output
+		close(s.done)
+	}
+}
+
+// cancel stops the script started by request id and waits up to timeout for
+// it to exit, returning its partial output.
+func (r *runningScripts) cancel(id string, timeout time.Duration) (string, error) {
+	r.mu.Lock()
+	s := r.scripts[id]
+	r.mu.Unlock()
+	if s == nil {
+		return "", fmt.Errorf("no running userscript for request %q", id)
+	}
+	s.cancelOnce.Do(func() { close(s.cancel) })
+	select {
+	case <-s.done:
+		return s.output, nil
+	case <-time.After(timeout):
+		return "", fmt.Errorf("userscript for request %q did not exit within %v", id, timeout)
+	}
+}
+
+// cancelAll stops every running script, as cancel does, and reports those
+// that did not exit in time.
+func (r *runningScripts) cancelAll(timeout time.Duration) []error {
+	r.mu.Lock()
+	ids := make([]string, 0, len(r.scripts))
+	for id := range r.scripts {
+		ids = append(ids, id)
+	}
+	r.mu.Unlock()
+	var errs []error
+	for _, id := range ids {
+		if _, err := r.cancel(id, timeout); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// agentProgressUI owns the progress display driven by Show/Update/Dismiss
+// Progress requests. Requests may arrive on concurrent connections.
+type agentProgressUI struct {
+	cfg    *config.Config
+	logger utils.Logger
+
+	mu      sync.Mutex
+	display progress.Display
+}
+
+func (u *agentProgressUI) handle(req ipc.RPCRequest) error {
+	if req.Progress == nil {
+		return fmt.Errorf("%s requires a progress state", req.Command)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	switch req.Command {
+	case "ShowProgress":
+		if u.display != nil {
+			return u.display.Update(*req.Progress)
+		}
+		d := newProgressDisplay(u.cfg, execLauncher(u.logger), u.logger)
+		if err := d.Show(*req.Progress); err != nil {
+			return err
+		}
+		u.display = d
+		return nil
+	case "UpdateProgress":
+		if u.display == nil {
+			return fmt.Errorf("no progress UI is open")
+		}
+		return u.display.Update(*req.Progress)
+	default: // DismissProgress
+		if u.display == nil {
+			return nil
+		}
+		err := u.display.Dismiss(*req.Progress)
+		u.display = nil
+		return err
+	}
+}
+
+// maxStreamLine bounds a streamed line so output without newlines is still
+// forwarded in pieces.
+const maxStreamLine = 4096
+
+// lineStreamer is an io.Writer that forwards complete lines of script output
+// to an IPC stream.
+type lineStreamer struct {
+	name string
+	send func(name, line string)
+	buf  []byte
+}
+
+func newLineStreamer(name string, send func(name, line string)) *lineStreamer {
+	return &lineStreamer{name: name, send: send}
+}
+
+func (l *lineStreamer) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			if len(l.buf) >= maxStreamLine {
+				l.send(l.name, string(l.buf))
+				l.buf = l.buf[:0]
+			}
+			return len(p), nil
+		}
+		l.send(l.name, strings.TrimSuffix(string(l.buf[:i]), "\r"))
+		l.buf = l.buf[i+1:]
+	}
+}
+
+// Flush forwards any trailing output that did not end in a newline.
+func (l *lineStreamer) Flush() {
+	if len(l.buf) > 0 {
+		l.send(l.name, string(l.buf))
+		l.buf = nil
+	}
 }
@@ -2,81 +2,218 @@ package mode
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/go-installapplications/pkg/ipc"
 	"github.com/go-installapplications/pkg/utils"
 )
 
+// agentHandler answers one daemon request; see startAgentIPCServer.
+type agentHandler func(req ipc.RPCRequest, body io.Reader, stream func(name, line string)) ipc.RPCResponse
+
+// agentRunPoll is how often the agent looks for a new daemon run, and for
+// the first one while it waits. A variable so tests can shorten it.
+var agentRunPoll = 2 * time.Second
+
+// agentServer serves the agent socket of the daemon's current run and
+// moves to the socket of the next run when the daemon starts one.
+type agentServer struct {
+	logger  utils.Logger
+	uid     string
+	handler agentHandler
+
+	mu       sync.Mutex
+	runID    string
+	sockPath string
+	listener net.Listener
+}
+
 // startAgentIPCServer starts a Unix domain socket server to handle user-context requests from the daemon.
 // The agent executes only user-context actions (userscripts/userfiles) upon daemon request.
-func startAgentIPCServer(logger *utils.Logger, handler func(req ipc.RPCRequest) ipc.RPCResponse) (string, error) {
-	if err := ipc.EnsureSocketDir(); err != nil {
-		return "", err
-	}
+// The handler may call stream to send lines of output back before its final
+// response. body reads the content that follows a TransferFile request.
+// It waits until the daemon has started a run and prepared this user's
+// socket directory.
+func startAgentIPCServer(logger utils.Logger, handler agentHandler) (*agentServer, error) {
+	logger = utils.Component(logger, utils.ComponentIPC)
 
 	// Determine UID to namespace the socket
 	uid, err := utils.GetConsoleUserUID()
 	if err != nil {
-		return "", fmt.Errorf("failed to get console user uid: %w", err)
+		return nil, fmt.Errorf("failed to get console user uid: %w", err)
 	}
 
-	sockPath := ipc.GetAgentSocketPathForUID(uid)
+	s := &agentServer{logger: logger, uid: uid, handler: handler}
+	waiting := false
+	for {
+		runID, err := s.nextRun()
+		if err == nil {
+			if err := s.listen(runID); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if !waiting {
+			logger.Info("Waiting for the daemon to start a run: %v", err)
+			waiting = true
+		}
+		if shutdownCtx.Err() != nil {
+			return nil, context.Cause(shutdownCtx)
+		}
+		time.Sleep(agentRunPoll)
+	}
+	go s.followRuns()
+	return s, nil
+}
+
+// nextRun returns the daemon's current run once this user's socket
+// directory is safe to listen in.
+func (s *agentServer) nextRun() (string, error) {
+	runID, err := ipc.CurrentRun()
+	if err != nil {
+		return "", err
+	}
+	if err := ipc.CheckUserSocketDir(s.uid); err != nil {
+		return "", err
+	}
+	return runID, nil
+}
+
+// followRuns moves the socket to each new daemon run, so a daemon relaunched
+// by launchd finds the agent at the socket of its own run.
+func (s *agentServer) followRuns() {
+	defer utils.Recover(s.logger, "agent IPC run watcher")
+	for shutdownCtx.Err() == nil {
+		time.Sleep(agentRunPoll)
+		runID, err := s.nextRun()
+		s.mu.Lock()
+		current := s.runID
+		s.mu.Unlock()
+		if err != nil || runID == current {
+			continue
+		}
+		s.logger.Info("Daemon started run %s; moving the agent socket", runID)
+		if err := s.listen(runID); err != nil {
+			s.logger.Error("Failed to move the agent socket: %v", err)
+		}
+	}
+}
+
+// Close stops listening and removes the socket.
+func (s *agentServer) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil {
+		_ = s.listener.Close()
+		_ = os.Remove(s.sockPath)
+		s.listener = nil
+	}
+}
+
+// listen serves the socket of runID, then closes the previous run's.
+func (s *agentServer) listen(runID string) error {
+	sockPath := ipc.AgentSocketPath(s.uid, runID)
 
 	// Remove any stale socket
 	_ = os.Remove(sockPath)
 
 	l, err := net.Listen("unix", sockPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to listen on %s: %w", sockPath, err)
+		return fmt.Errorf("failed to listen on %s: %w", sockPath, err)
 	}
 
-	// Set socket file permissions to allow the daemon (root) to connect
-	// The socket file is owned by the agent user but readable/writable by root
-	if err := os.Chmod(sockPath, 0666); err != nil {
-		logger.Info("Failed to set socket permissions: %v", err)
+	// The directory (0700, this user's) keeps other users out; the socket
+	// itself only needs to be reachable by its owner and root
+	if err := os.Chmod(sockPath, 0600); err != nil {
+		s.logger.Info("Failed to set socket permissions: %v", err)
 	}
 
-	logger.Info("Agent IPC listening at %s", sockPath)
+	s.logger.Info("Agent IPC listening at %s", sockPath)
+
+	s.mu.Lock()
+	previous, previousPath := s.listener, s.sockPath
+	s.runID, s.sockPath, s.listener = runID, sockPath, l
+	s.mu.Unlock()
+	if previous != nil {
+		_ = previous.Close()
+		_ = os.Remove(previousPath)
+	}
+
+	go s.serve(l, sockPath)
+	return nil
+}
+
+// serve accepts connections on l until it is closed.
+func (s *agentServer) serve(l net.Listener, sockPath string) {
+	logger, handler := s.logger, s.handler
+	// Only the daemon (root) and this agent's own user may send requests.
+	allowedUIDs := []int{0, os.Getuid()}
+	// Requests must also be signed with the daemon's per-run secret.
+	verifier := ipc.NewVerifier(sockPath)
 
-	go func() {
-		for {
-			conn, err := l.Accept()
-			if err != nil {
-				logger.Debug("IPC accept error: %v", err)
-				time.Sleep(100 * time.Millisecond)
-				continue
+	defer utils.Recover(logger, "agent IPC listener")
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			logger.Debug("IPC accept error: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		go func(c net.Conn) {
+			defer utils.Recover(logger, "agent IPC handler")
+			defer c.Close()
+			if err := ipc.AuthorizePeer(c, allowedUIDs...); err != nil {
+				logger.Error("Rejected IPC connection: %v", err)
+				return
 			}
+			decoder := json.NewDecoder(bufio.NewReader(c))
+			encoder := json.NewEncoder(c)
 
-			go func(c net.Conn) {
-				defer c.Close()
-				decoder := json.NewDecoder(bufio.NewReader(c))
-				encoder := json.NewEncoder(c)
-
-				var req ipc.RPCRequest
-				if err := decoder.Decode(&req); err != nil {
-					if err == io.EOF {
-						// Likely a readiness probe (connect/close). Not an error.
-						logger.Debug("IPC decode EOF (probe) - ignoring")
-						return
-					}
-					logger.Error("IPC decode error: %v", err)
+			var req ipc.RPCRequest
+			if err := decoder.Decode(&req); err != nil {
+				if err == io.EOF {
+					// Likely a readiness probe (connect/close). Not an error.
+					logger.Debug("IPC decode EOF (probe) - ignoring")
 					return
 				}
+				logger.Error("IPC decode error: %v", err)
+				return
+			}
 
-				logger.Debug("IPC request: id=%s cmd=%s path=%s donotwait=%t", req.ID, req.Command, req.Path, req.DoNotWait)
-				resp := handler(req)
-				if err := encoder.Encode(resp); err != nil {
-					logger.Error("IPC encode error: %v", err)
-				}
-			}(conn)
-		}
-	}()
+			if err := verifier.Verify(req); err != nil {
+				logger.Error("Rejected IPC request id=%s cmd=%s: %v", req.ID, req.Command, err)
+				_ = encoder.Encode(ipc.RPCResponse{ID: req.ID, OK: false, Error: "unauthorized request"})
+				return
+			}
 
-	return sockPath, nil
+			logger.Debug("IPC request: id=%s cmd=%s path=%s donotwait=%t", req.ID, req.Command, req.Path, req.DoNotWait)
+			// stdout and stderr stream from separate goroutines
+			var sendMu sync.Mutex
+			stream := func(name, line string) {
+				sendMu.Lock()
+				defer sendMu.Unlock()
+				if err := encoder.Encode(ipc.RPCResponse{ID: req.ID, OK: true, Partial: true, OutputStream: name, Output: line}); err != nil {
+					logger.Debug("IPC stream error: %v", err)
+				}
+			}
+			resp := handler(req, ipc.NewChunkReader(decoder), stream)
+			sendMu.Lock()
+			defer sendMu.Unlock()
+			if err := encoder.Encode(resp); err != nil {
+				logger.Error("IPC encode error: %v", err)
+			}
+		}(conn)
+	}
 }
@@ -2,26 +2,32 @@ package mode
 
 import (
 	"bufio"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"io"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/go-installapplications/pkg/config"
 	"github.com/go-installapplications/pkg/installer"
 	"github.com/go-installapplications/pkg/ipc"
+	"github.com/go-installapplications/pkg/progress"
 	"github.com/go-installapplications/pkg/utils"
 )
 
 // newAgentInstallerForTest returns a real SystemInstaller configured for
 // agent mode and not dry-run, so its ProcessTracker is exercisable.
-func newAgentInstallerForTest(t *testing.T, logger *utils.Logger) *installer.SystemInstaller {
+func newAgentInstallerForTest(t *testing.T, logger utils.Logger) *installer.SystemInstaller {
 	t.Helper()
 	return installer.NewSystemInstaller(false, logger, true)
 }
@@ -29,7 +35,7 @@ func newAgentInstallerForTest(t *testing.T, logger *utils.Logger) *installer.Sys
 // startAgentLikeServer mirrors the production agent handler in RunAgent —
 // same commands, same singleton SystemInstaller — without needing root or a
 // real LaunchAgent.
-func startAgentLikeServer(t *testing.T, sockPath string, si *installer.SystemInstaller, _ *utils.Logger) net.Listener {
+func startAgentLikeServer(t *testing.T, sockPath string, si *installer.SystemInstaller, _ utils.Logger) net.Listener {
 	t.Helper()
 	l, err := net.Listen("unix", sockPath)
 	if err != nil {
@@ -49,11 +55,16 @@ func startAgentLikeServer(t *testing.T, sockPath string, si *installer.SystemIns
 				}
 				resp := ipc.RPCResponse{ID: req.ID, OK: true}
 				switch req.Command {
+				case "Hello":
+					resp = ipc.HelloResponse(req)
 				case "Ping":
 					// ok
 				case "GetBackgroundProcessCount":
 					resp.Count = si.GetBackgroundProcessCount()
-				case "WaitForBackgroundProcesses":
+				case "GetBackgroundStatus":
+					resp.Background = si.GetBackgroundStatus()
+					resp.Count = len(resp.Background)
+				case "WaitForBackground", "WaitForBackgroundProcesses":
 					timeout := time.Duration(req.TimeoutSeconds) * time.Second
 					if timeout <= 0 {
 						timeout = 5 * time.Second
@@ -310,7 +321,10 @@ func TestCallAgent_RoundTrip(t *testing.T) {
 	// UID. Spin up a tiny server at the same path manually rather than
 	// invoking startAgentIPCServer (which calls into stat /dev/console).
 	uid := "9999"
-	sockPath := ipc.GetAgentSocketPathForUID(uid)
+	sockPath := ipc.AgentSocketPath(uid, "run1")
+	if err := os.MkdirAll(ipc.UserSocketDir(uid), 0700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
 
 	var pingCount int32
 	l, err := net.Listen("unix", sockPath)
@@ -338,7 +352,7 @@ func TestCallAgent_RoundTrip(t *testing.T) {
 	}()
 
 	logger := utils.NewLogger(false, false)
-	resp, err := callAgent(logger, sockPath, ipc.RPCRequest{Command: "Ping"}, 2*time.Second)
+	resp, err := callAgent(context.Background(), logger, sockPath, ipc.RPCRequest{Command: "Ping"}, 2*time.Second)
 	if err != nil {
 		t.Fatalf("callAgent: %v", err)
 	}
@@ -349,3 +363,405 @@ func TestCallAgent_RoundTrip(t *testing.T) {
 		t.Fatalf("expected exactly one ping, got %d", pingCount)
 	}
 }
+
+// A streamed RunUserScript delivers each output line to the daemon before the
+// final response, through the same lineStreamer the agent uses.
+func TestCallAgentStreaming_DeliversOutputLines(t *testing.T) {
+	sockPath := shortSockPath(t)
+	si := newAgentInstallerForTest(t, utils.NewLogger(false, false))
+	script := filepath.Join(t.TempDir(), "chatty.sh")
+	body := "#!/bin/sh\necho one\necho two >&2\nprintf three\n"
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var req ipc.RPCRequest
+		if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil || !req.Stream {
+			return
+		}
+		enc := json.NewEncoder(conn)
+		var mu sync.Mutex
+		stream := func(name, line string) {
+			mu.Lock()
+			defer mu.Unlock()
+			_ = enc.Encode(ipc.RPCResponse{ID: req.ID, OK: true, Partial: true, OutputStream: name, Output: line})
+		}
+		stdout, stderr := newLineStreamer("stdout", stream), newLineStreamer("stderr", stream)
+		err = si.ExecuteScriptStreaming(req.Path, "userscript", false, false, stdout, stderr, nil)
+		stdout.Flush()
+		stderr.Flush()
+		_ = enc.Encode(ipc.RPCResponse{ID: req.ID, OK: err == nil})
+	}()
+
+	var lines []string
+	resp, err := callAgentStreaming(context.Background(), utils.NewLogger(false, false), sockPath, ipc.RPCRequest{Command: "RunUserScript", Path: script}, 5*time.Second,
+		func(stream, line string) { lines = append(lines, stream+":"+line) })
+	if err != nil || !resp.OK {
+		t.Fatalf("callAgentStreaming: %v %+v", err, resp)
+	}
+	got := map[string]bool{}
+	for _, l := range lines {
+		got[l] = true
+	}
+	for _, want := range []string{"stdout:one", "stderr:two", "stdout:three"} {
+		if !got[want] {
+			t.Fatalf("missing streamed line %q in %v", want, lines)
+		}
+	}
+}
+
+func TestLineStreamer_SplitsLines(t *testing.T) {
+	var lines []string
+	ls := newLineStreamer("stdout", func(_, line string) { lines = append(lines, line) })
+	_, _ = ls.Write([]byte("a\r\nb"))
+	_, _ = ls.Write([]byte("c\nd"))
+	ls.Flush()
+	if want := []string{"a", "bc", "d"}; len(lines) != 3 || lines[0] != want[0] || lines[1] != want[1] || lines[2] != want[2] {
+		t.Fatalf("lines = %q, want %q", lines, want)
+	}
+}
+
+// The daemon trusts the agent's own view of its background processes: a
+// failed process the daemon did not count is still waited on and reported.
+func TestWaitForAgentBackground_UsesAgentStatus(t *testing.T) {
+	logger := utils.NewLogger(false, false)
+	si := newAgentInstallerForTest(t, logger)
+	sockPath := shortSockPath(t)
+	l := startAgentLikeServer(t, sockPath, si, logger)
+	defer l.Close()
+
+	startTrackedFailing(t, si, "untracked-by-daemon")
+	cfg := config.NewConfig()
+	cfg.BackgroundTimeout = 5 * time.Second
+	cfg.AgentRequestTimeout = 10 * time.Second
+
+	if err := waitForAgentBackground(context.Background(), sockPath, 0, cfg, logger); err == nil {
+		t.Fatalf("expected the failed agent-side process to be reported")
+	}
+	if n := si.GetBackgroundProcessCount(); n != 0 {
+		t.Fatalf("expected tracker to be drained, got %d", n)
+	}
+	// Nothing left: no wait and no error
+	if err := waitForAgentBackground(context.Background(), sockPath, 0, cfg, logger); err != nil {
+		t.Fatalf("unexpected error with nothing to wait for: %v", err)
+	}
+}
+
+// setAgentTimings shortens the heartbeat and reconnect timings for a test.
+func setAgentTimings(t *testing.T, interval time.Duration, misses int, reconnect time.Duration) {
+	t.Helper()
+	origInterval, origMisses, origReconnect := agentHeartbeatInterval, agentHeartbeatMisses, agentReconnectTimeout
+	agentHeartbeatInterval, agentHeartbeatMisses, agentReconnectTimeout = interval, misses, reconnect
+	t.Cleanup(func() {
+		agentHeartbeatInterval, agentHeartbeatMisses, agentReconnectTimeout = origInterval, origMisses, origReconnect
+	})
+}
+
+// A request made while the agent is being relaunched waits for the socket to
+// come back instead of failing.
+func TestCallAgent_WaitsForRelaunchedAgent(t *testing.T) {
+	setAgentTimings(t, 30*time.Second, 3, 10*time.Second)
+	logger := utils.NewLogger(false, false)
+	si := newAgentInstallerForTest(t, logger)
+	sockPath := shortSockPath(t)
+
+	go func() {
+		time.Sleep(700 * time.Millisecond)
+		l := startAgentLikeServer(t, sockPath, si, logger)
+		t.Cleanup(func() { l.Close() })
+	}()
+
+	resp, err := callAgent(context.Background(), logger, sockPath, ipc.RPCRequest{Command: "Ping"}, 5*time.Second)
+	if err != nil || !resp.OK {
+		t.Fatalf("expected ping to succeed once the agent is back: %v %+v", err, resp)
+	}
+
+	// Progress updates are best-effort and never wait
+	missing := shortSockPath(t)
+	start := time.Now()
+	state := progress.State{}
+	if _, err := callAgent(context.Background(), logger, missing, ipc.RPCRequest{Command: "UpdateProgress", Progress: &state}, 5*time.Second); err == nil {
+		t.Fatalf("expected progress update to a missing agent to fail")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("progress update waited for the agent")
+	}
+}
+
+// A hung agent is detected by missed heartbeats long before the request
+// timeout.
+func TestCallAgent_HeartbeatDetectsLostAgent(t *testing.T) {
+	setAgentTimings(t, 50*time.Millisecond, 2, time.Second)
+	sockPath := shortSockPath(t)
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	// Accept the request, then stop listening and never answer it
+	held := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		l.Close()
+		held <- conn
+	}()
+	t.Cleanup(func() {
+		select {
+		case c := <-held:
+			c.Close()
+		default:
+		}
+	})
+
+	start := time.Now()
+	_, err = callAgent(context.Background(), utils.NewLogger(false, false), sockPath, ipc.RPCRequest{Command: "RunUserScript", Path: "/tmp/x.sh"}, 30*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "heartbeat") {
+		t.Fatalf("expected heartbeat failure, got %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Fatalf("lost agent detected too slowly: %v", time.Since(start))
+	}
+}
+
+// Cancel stops a foreground userscript and hands back what it printed.
+func TestRunningScripts_CancelReturnsPartialOutput(t *testing.T) {
+	si := newAgentInstallerForTest(t, utils.NewLogger(false, false))
+	script := filepath.Join(t.TempDir(), "slow.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho started\nsleep 30\n"), 0755); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	running := newRunningScripts()
+	rs := running.start("req-1")
+	lines := make(chan string, 1)
+	stdout := newLineStreamer("stdout", func(_, line string) { lines <- line })
+	go func() {
+		err := si.ExecuteScriptStreaming(script, "userscript", false, false, stdout, io.Discard, rs.cancel)
+		var cancelled *installer.ScriptCancelledError
+		if errors.As(err, &cancelled) {
+			running.finish("req-1", string(cancelled.Output))
+		} else {
+			running.finish("req-1", "")
+		}
+	}()
+	<-lines
+
+	output, err := running.cancel("req-1", 10*time.Second)
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if !strings.Contains(output, "started") {
+		t.Fatalf("expected partial output, got %q", output)
+	}
+	if _, err := running.cancel("req-1", time.Second); err == nil {
+		t.Fatalf("expected error cancelling a finished request")
+	}
+}
+
+// A userscript request that times out is cancelled on the agent.
+func TestCallAgent_TimeoutCancelsUserScript(t *testing.T) {
+	sockPath := shortSockPath(t)
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	cancelled := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var req ipc.RPCRequest
+				if err := json.NewDecoder(bufio.NewReader(c)).Decode(&req); err != nil {
+					return
+				}
+				if req.Command != "Cancel" {
+					time.Sleep(2 * time.Second) // never answers in time
+					return
+				}
+				cancelled <- req.Target
+				_ = json.NewEncoder(c).Encode(ipc.RPCResponse{ID: req.ID, OK: true, Cancelled: true, Output: "partial"})
+			}(conn)
+		}
+	}()
+
+	resp, err := callAgent(context.Background(), utils.NewLogger(false, false), sockPath, ipc.RPCRequest{ID: "run-1", Command: "RunUserScript", Path: "/tmp/x.sh"}, 200*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("expected timeout cancellation error, got %v", err)
+	}
+	if !resp.Cancelled || resp.Output != "partial" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if target := <-cancelled; target != "run-1" {
+		t.Fatalf("Cancel targeted %q", target)
+	}
+}
+
+// Commands outside the negotiated protocol are refused (or sent under an
+// older alias) instead of reaching an agent that would misread them.
+func TestNegotiateAgent_LegacyAgentLimitsCommands(t *testing.T) {
+	logger := utils.NewLogger(false, false)
+	sockPath := shortSockPath(t)
+	done := make(chan struct{})
+	// startTestAgentServer answers Hello with "unknown command", like an
+	// agent that predates the handshake
+	l := startTestAgentServer(t, sockPath, done)
+	defer l.Close()
+
+	if err := negotiateAgent(logger, sockPath); err != nil {
+		t.Fatalf("negotiate: %v", err)
+	}
+	t.Cleanup(func() {
+		agentProtocolsMu.Lock()
+		delete(agentProtocols, sockPath)
+		agentProtocolsMu.Unlock()
+	})
+
+	if _, err := callAgent(context.Background(), logger, sockPath, ipc.RPCRequest{Command: "GetBackgroundStatus"}, 5*time.Second); err == nil || !strings.Contains(err.Error(), "does not support") {
+		t.Fatalf("expected unsupported command error, got %v", err)
+	}
+	if got, err := resolveAgentCommand(sockPath, "WaitForBackground"); err != nil || got != "WaitForBackgroundProcesses" {
+		t.Fatalf("WaitForBackground resolved to %q, %v", got, err)
+	}
+	if resp, err := callAgent(context.Background(), logger, sockPath, ipc.RPCRequest{Command: "Ping"}, 5*time.Second); err != nil || !resp.OK {
+		t.Fatalf("ping: %v %+v", err, resp)
+	}
+}
+
+// A downloaded userfile is streamed to the agent, which writes it under the
+// user's home with the requested mode.
+func TestTransferFileToAgent_WritesTarget(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	sockPath := shortSockPath(t)
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				dec := json.NewDecoder(bufio.NewReader(c))
+				var req ipc.RPCRequest
+				if err := dec.Decode(&req); err != nil {
+					return
+				}
+				resp := ipc.RPCResponse{ID: req.ID, OK: true}
+				body := ipc.NewChunkReader(dec)
+				if _, err := receiveFile(req, body); err != nil {
+					_, _ = io.Copy(io.Discard, body)
+					resp.OK, resp.Error = false, err.Error()
+				}
+				_ = json.NewEncoder(c).Encode(resp)
+			}(conn)
+		}
+	}()
+
+	src := filepath.Join(t.TempDir(), "prefs.plist")
+	if err := os.WriteFile(src, []byte("<plist/>"), 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	logger := utils.NewLogger(false, false)
+	target := "~/Library/Containers/com.example.app/Data/prefs.plist"
+	if err := transferFileToAgent(context.Background(), logger, sockPath, src, target, installer.FileOptions{Mode: 0640}, 0, 5*time.Second); err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+	placed := filepath.Join(home, "Library/Containers/com.example.app/Data/prefs.plist")
+	info, err := os.Stat(placed)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Fatalf("mode = %v, want 0640", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(placed); string(data) != "<plist/>" {
+		t.Fatalf("content = %q", data)
+	}
+
+	// Relative destinations are refused with the agent's error
+	if err := transferFileToAgent(context.Background(), logger, sockPath, src, "relative/prefs.plist", installer.FileOptions{Mode: 0640}, 0, 5*time.Second); err == nil || !strings.Contains(err.Error(), "absolute") {
+		t.Fatalf("expected absolute path error, got %v", err)
+	}
+}
+
+func TestReceiveFile_RejectsChecksumMismatch(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "f")
+	req := ipc.RPCRequest{Path: target, Size: 3, SHA256: "00"}
+	if _, err := receiveFile(req, strings.NewReader("abc")); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("target should not exist after a failed transfer")
+	}
+}
+
+// A shutdown signal abandons a pending userscript request and cancels the
+// script on the agent, as a timeout does.
+func TestCallAgent_ShutdownCancelsUserScript(t *testing.T) {
+	sockPath := shortSockPath(t)
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	cancelled := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var req ipc.RPCRequest
+				if err := json.NewDecoder(bufio.NewReader(c)).Decode(&req); err != nil {
+					return
+				}
+				if req.Command != "Cancel" {
+					time.Sleep(5 * time.Second)
+					return
+				}
+				cancelled <- req.Target
+				_ = json.NewEncoder(c).Encode(ipc.RPCResponse{ID: req.ID, OK: true, Cancelled: true})
+			}(conn)
+		}
+	}()
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(200*time.Millisecond, func() { cancel(errors.New("interrupted by SIGTERM")) })
+
+	start := time.Now()
+	_, err = callAgent(ctx, utils.NewLogger(false, false), sockPath, ipc.RPCRequest{ID: "run-1", Command: "RunUserScript", Path: "/tmp/x.sh"}, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "interrupted by SIGTERM; userscript cancelled") {
+		t.Fatalf("expected interruption error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("request was not abandoned promptly (%v)", elapsed)
+	}
+	if target := <-cancelled; target != "run-1" {
+		t.Fatalf("Cancel targeted %q", target)
+	}
+}
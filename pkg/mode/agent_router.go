@@ -0,0 +1,142 @@
+package mode
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/ipc"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// agentRouter sends user-context items to the agent of whoever owns the
+// console when each item runs. With fast user switching the console user can
+// change mid-bootstrap; resolving it once at the start would chown files to
+// one user and hand them to another user's agent (or to an agent that has
+// gone away). Every agent used during the run is remembered so its
+// background processes can be drained and it can be shut down at the end.
+type agentRouter struct {
+	cfg    *config.Config
+	logger utils.Logger
+	// wait resolves the console user and waits for their agent; swapped out
+	// in tests.
+	wait func() (uid, sockPath string, err error)
+	// negotiate runs the protocol handshake with a newly used agent.
+	negotiate func(sockPath string) error
+	// asUser sends user items through launchctl asuser from the daemon,
+	// standalone-style, instead of to an agent (UserlandGatePolicy "login",
+	// or "deadline" once it has passed). bestEffort makes their failures
+	// non-fatal.
+	asUser     bool
+	bestEffort bool
+
+	mu         sync.Mutex
+	uid        string
+	sockPath   string
+	used       []string       // agent sockets in first-use order
+	background map[string]int // tracked donotwait userscripts per socket
+}
+
+func newAgentRouter(cfg *config.Config, logger utils.Logger) *agentRouter {
+	logger = utils.Component(logger, utils.ComponentIPC)
+	return &agentRouter{
+		cfg:    cfg,
+		logger: logger,
+		wait: func() (string, string, error) {
+			return waitForConsoleAgent(logger, cfg.WaitForAgentTimeout)
+		},
+		negotiate: func(sockPath string) error {
+			return negotiateAgent(logger, sockPath)
+		},
+		background: map[string]int{},
+	}
+}
+
+// route returns the current console user and their agent socket, waiting for
+// the agent if the console user has changed since the previous item.
+// Concurrent items in a parallel_group are routed one at a time.
+func (r *agentRouter) route() (uid, sockPath string, err error) {
+	if r == nil {
+		return "", "", fmt.Errorf("no agent available for user-context items")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	uid, sockPath, err = r.wait()
+	if err != nil {
+		return "", "", fmt.Errorf("agent readiness wait failed: %w", err)
+	}
+	if sockPath == r.sockPath {
+		return uid, sockPath, nil
+	}
+	if r.sockPath != "" {
+		r.logger.Info("👥 Console user changed (UID %s → %s); routing user items to the new session", r.uid, uid)
+		if sessions, err := utils.GetGUISessionUIDs(); err == nil && len(sessions) > 1 {
+			r.logger.Debug("Active GUI sessions: %s", strings.Join(sessions, ", "))
+		}
+	} else {
+		r.logger.Info("Agent socket is ready (UID %s)", uid)
+	}
+	if !containsString(r.used, sockPath) {
+		if err := r.negotiate(sockPath); err != nil {
+			return "", "", err
+		}
+		r.used = append(r.used, sockPath)
+	}
+	r.uid, r.sockPath = uid, sockPath
+	return uid, sockPath, nil
+}
+
+// addBackground records a tracked background userscript on sockPath's agent.
+func (r *agentRouter) addBackground(sockPath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.background[sockPath]++
+}
+
+// waitForBackground drains tracked background processes on every agent used
+// during the run, until ctx is done.
+func (r *agentRouter) waitForBackground(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	for _, sockPath := range r.sockets() {
+		r.mu.Lock()
+		count := r.background[sockPath]
+		r.mu.Unlock()
+		if err := waitForAgentBackground(ctx, sockPath, count, r.cfg, r.logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shutdown asks every agent used during the run to exit. Failures are
+// non-fatal; an agent that logged out with its user is already gone.
+func (r *agentRouter) shutdown() {
+	if r == nil {
+		return
+	}
+	for _, sockPath := range r.sockets() {
+		if _, err := callAgent(shutdownCtx, r.logger, sockPath, ipc.RPCRequest{Command: "Shutdown"}, r.cfg.AgentRequestTimeout); err != nil {
+			r.logger.Debug("Agent shutdown request failed for %s (non-fatal): %v", sockPath, err)
+		}
+	}
+}
+
+func (r *agentRouter) sockets() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.used...)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
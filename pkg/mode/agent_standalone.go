@@ -0,0 +1,105 @@
+package mode
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/installer"
+	"github.com/go-installapplications/pkg/manager"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// userWorkDir is where agent-standalone keeps the bootstrap and downloaded
+// userscripts, relative to the user's home. The user cannot write InstallPath.
+const userWorkDir = "Library/Application Support/go-installapplications"
+
+// RunAgentStandalone re-runs the user part of the bootstrap as the logged-in
+// user, without root, daemon or agent: the userscripts and userfiles of the
+// userland phase are downloaded and run in this process, and everything else
+// is skipped. It is meant for self-service, e.g. after a first login whose
+// user items failed. Failures exit 1.
+func RunAgentStandalone(cfg *config.Config, logger utils.Logger) {
+	logger.Info("Starting agent-standalone mode")
+	watchShutdown(logger)
+	ctx := limitRun(cfg, logger)
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		logger.Error("❌ Cannot find the home directory: %v", err)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "no home directory")
+	}
+	// Downloads go to the user's work directory. Cleanup is done here rather
+	// than by the manager, which would also remove the userfiles just placed.
+	runCfg := *cfg
+	runCfg.InstallPath = filepath.Join(home, userWorkDir)
+	runCfg.CleanupOnSuccess = false
+	if err := os.MkdirAll(runCfg.InstallPath, 0755); err != nil {
+		logger.Error("❌ Cannot create %s: %v", runCfg.InstallPath, err)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "no work directory")
+	}
+
+	bootstrap, err := getBootstrap(&runCfg, logger)
+	if err == nil {
+		bootstrap, err = restrictBootstrap(bootstrap, cfg, logger)
+	}
+	if err != nil {
+		exitIfInterrupted(ctx, nil, logger)
+		logger.Error("❌ Failed to load bootstrap: %v", err)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitBootstrapFetch, "bootstrap unavailable")
+	}
+	items, skipped := userItemsForUser(bootstrap.Userland, cfg.InstallPath, runCfg.InstallPath, home)
+	for _, item := range skipped {
+		logger.Info("⏭️  Skipping %s: %s items need root", item.Name, item.Type)
+	}
+	if len(items) == 0 {
+		logger.Info("No userscript or userfile items in the userland phase")
+		return
+	}
+
+	// Agent mode: userscripts run directly as this user
+	systemInstaller := installer.NewSystemInstaller(runCfg.DryRun, logger, true)
+	systemInstaller.SetStop(shutdownCtx.Done())
+	mgr := manager.NewManager(newItemDownloader(&runCfg, logger), systemInstaller, &runCfg, logger)
+	mgr.SetContext(shutdownCtx)
+	if err := mgr.ProcessItems(items, "userland"); err != nil {
+		exitIfInterrupted(ctx, nil, logger)
+		logger.Error("❌ User items failed: %v", err)
+		utils.ExitWithoutCleanup(cfg, logger, exitCodeFor(err), "user items failed")
+	}
+
+	if cfg.CleanupOnSuccess && !cfg.DryRun {
+		for _, item := range items {
+			if item.Type == "userscript" && strings.HasPrefix(item.File, runCfg.InstallPath+"/") {
+				_ = os.Remove(item.File)
+			}
+		}
+	}
+	logger.Info("✅ %d user items completed", len(items))
+}
+
+// userItemsForUser splits the userland items into the userscripts and
+// userfiles a user can run and the rest. Downloaded and inline userscripts
+// under installPath are moved to workDir and "~/" userfile destinations are
+// expanded to home, since this process is not the agent the daemon would
+// hand them to.
+func userItemsForUser(userland []config.Item, installPath, workDir, home string) (items, skipped []config.Item) {
+	for _, item := range userland {
+		switch item.Type {
+		case "userscript", "userfile":
+		default:
+			skipped = append(skipped, item)
+			continue
+		}
+		if item.Delivered() {
+			if strings.HasPrefix(item.File, "~/") {
+				item.File = filepath.Join(home, item.File[2:])
+			} else if rel, err := filepath.Rel(installPath, item.File); item.Type == "userscript" && err == nil && !strings.HasPrefix(rel, "..") {
+				item.File = filepath.Join(workDir, rel)
+			}
+		}
+		items = append(items, item)
+	}
+	return items, skipped
+}
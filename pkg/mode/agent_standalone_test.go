@@ -0,0 +1,40 @@
+package mode
+
+import (
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+)
+
+func TestUserItemsForUser(t *testing.T) {
+	userland := []config.Item{
+		{Name: "pkg", Type: "package", File: "/Library/go-installapplications/userland/a.pkg", URL: "https://example.com/a.pkg"},
+		{Name: "script", Type: "userscript", File: "/Library/go-installapplications/userland/s.sh", URL: "https://example.com/s.sh"},
+		{Name: "local", Type: "userscript", File: "/Library/go-installapplications/userland/local.sh"},
+		{Name: "dock", Type: "userfile", File: "~/Library/Preferences/com.apple.dock.plist", URL: "https://example.com/dock.plist"},
+		{Name: "inline", Type: "userscript", File: "/Library/go-installapplications/userland/i.sh", Content: "defaults write -g AppleShowAllExtensions -bool true"},
+		{Name: "root", Type: "rootscript", File: "/Library/go-installapplications/userland/r.sh", URL: "https://example.com/r.sh"},
+	}
+	items, skipped := userItemsForUser(userland, "/Library/go-installapplications", "/Users/ada/work", "/Users/ada")
+
+	if len(skipped) != 2 || skipped[0].Name != "pkg" || skipped[1].Name != "root" {
+		t.Fatalf("skipped = %+v", skipped)
+	}
+	want := map[string]string{
+		"script": "/Users/ada/work/userland/s.sh",
+		"local":  "/Library/go-installapplications/userland/local.sh", // already on disk
+		"dock":   "/Users/ada/Library/Preferences/com.apple.dock.plist",
+		"inline": "/Users/ada/work/userland/i.sh",
+	}
+	if len(items) != len(want) {
+		t.Fatalf("items = %+v", items)
+	}
+	for _, item := range items {
+		if item.File != want[item.Name] {
+			t.Errorf("%s: File = %q, want %q", item.Name, item.File, want[item.Name])
+		}
+	}
+	if userland[1].File != "/Library/go-installapplications/userland/s.sh" {
+		t.Fatalf("input was modified: %q", userland[1].File)
+	}
+}
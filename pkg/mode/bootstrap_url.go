@@ -0,0 +1,25 @@
+package mode
+
+import (
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// bootstrapURL is cfg.JSONURL with its placeholders ({{serial}}, {{udid}},
+// {{profile_value:Key}}) filled in for this Mac, resolved each time the
+// bootstrap is fetched.
+func bootstrapURL(cfg *config.Config) (string, error) {
+	if !config.HasPlaceholders(cfg.JSONURL) {
+		return cfg.JSONURL, nil
+	}
+	return config.ExpandURL(cfg.JSONURL, func(name, arg string) (string, error) {
+		switch name {
+		case config.PlaceholderSerial:
+			return utils.GetSerialNumber()
+		case config.PlaceholderUDID:
+			return utils.GetHardwareUUID()
+		default:
+			return cfg.ProfileValue(arg)
+		}
+	})
+}
@@ -1,28 +1,41 @@
 package mode
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/go-installapplications/pkg/audit"
 	"github.com/go-installapplications/pkg/config"
 	"github.com/go-installapplications/pkg/download"
 	"github.com/go-installapplications/pkg/installer"
 	"github.com/go-installapplications/pkg/ipc"
 	"github.com/go-installapplications/pkg/manager"
+	"github.com/go-installapplications/pkg/progress"
 	"github.com/go-installapplications/pkg/retry"
+	"github.com/go-installapplications/pkg/status"
+	"github.com/go-installapplications/pkg/tracing"
 	"github.com/go-installapplications/pkg/utils"
 )
 
 // RunDaemon executes the daemon mode workflow
-func RunDaemon(cfg *config.Config, logger *utils.Logger) {
+func RunDaemon(cfg *config.Config, logger utils.Logger) {
 	logger.Info("Starting daemon mode")
+	watchShutdown(logger)
+	ctx := limitRun(cfg, logger)
 
 	// Check retry logic
 	if shouldRetry, err := retry.ShouldRetry(); !shouldRetry {
 		logger.Error("Maximum retry attempts exceeded: %v", err)
-		utils.Exit(cfg, logger, 0, "max retries exceeded")
+		utils.Exit(cfg, logger, utils.ExitMaxRetries, "max retries exceeded")
 	}
 
 	logger.Info("Daemon attempt: %s", retry.GetRetryInfo())
@@ -31,38 +44,67 @@ func RunDaemon(cfg *config.Config, logger *utils.Logger) {
 		logger.Error("Failed to update retry count: %v", err)
 	}
 
+	// Agents name their sockets after this run, so a socket left by an
+	// earlier run is never used
+	if err := ipc.StartRun(status.NewRunID()); err != nil {
+		logger.Error("Failed to prepare the agent socket directory: %v", err)
+	}
+
 	// Get bootstrap and create components
 	bootstrap, downloader, systemInstaller, manager, err := setupBootstrapAndComponents(cfg, logger)
 	if err != nil {
+		exitIfInterrupted(ctx, nil, logger)
 		logger.Error("Failed to setup bootstrap and components: %v", err)
-		retry.IncrementRetryCount(fmt.Sprintf("setup failed: %v", err))
+		retry.RecordFailure(err, fmt.Sprintf("setup failed: %v", err))
 		// Exit without cleanup (no components created yet)
-		utils.Exit(cfg, logger, 1, "setup failed")
+		utils.Exit(cfg, logger, exitCodeFor(err), "setup failed")
+	}
+
+	if cfg.DryRun {
+		reviewPlan(bootstrap, downloader.ContentLength, cfg, logger)
 	}
 
+	// Progress UI, metrics and tracing (no-ops unless enabled in the config)
+	reporter, tracer := newRunReporter(cfg, downloader, logger)
+	manager.SetReporter(reporter)
+	manager.SetTracer(tracer)
+	reporter.Start(progressItems(bootstrap))
+
+	// Logging, timeout and concurrency settings of a profile pushed
+	// mid-run apply from the next phase
+	reload := watchProfile(ctx, cfg, downloader, logger)
+
 	// Process preflight and setupassistant phases
-	if err := processSystemPhases(bootstrap, manager, cfg, logger); err != nil {
+	if err := processSystemPhases(bootstrap, manager, reload, cfg, logger); err != nil {
 		// Check if this is a preflight success signal
 		if _, ok := err.(*installer.PreflightSuccessError); ok {
 			logger.Info("Preflight script passed - cleaning up and exiting")
+			reporter.Finish(nil)
 			// Perform manager cleanup, then exit with system cleanup
 			manager.Cleanup("preflight success")
-			utils.Exit(cfg, logger, 0, "preflight success")
+			utils.Exit(cfg, logger, utils.ExitSuccess, "preflight success")
 		}
 		// Actual error occurred
-		retry.IncrementRetryCount(fmt.Sprintf("system phases failed: %v", err))
+		exitIfInterrupted(ctx, reporter, logger)
+		reporter.Finish(err)
+		retry.RecordFailure(err, fmt.Sprintf("system phases failed: %v", err))
 		// Perform manager cleanup, then exit with system cleanup
 		manager.Cleanup("system phases error")
-		utils.Exit(cfg, logger, 1, "system phases failed")
+		utils.Exit(cfg, logger, exitCodeFor(err), "system phases failed")
 	}
 
 	// Process userland phase
 	if len(bootstrap.Userland) > 0 {
-		if err := processUserlandPhase(bootstrap.Userland, downloader, systemInstaller, cfg, logger); err != nil {
-			retry.IncrementRetryCount(fmt.Sprintf("userland failed: %v", err))
+		reload()
+		userlandCfg := cfg.ForPhase("userland")
+		downloader.SetRetryDefaults(userlandCfg.MaxRetries, userlandCfg.RetryDelay)
+		if err := processUserlandPhase(userlandWithHooks(bootstrap), downloader, systemInstaller, reporter, tracer, userlandCfg, logger); err != nil {
+			exitIfInterrupted(ctx, reporter, logger)
+			reporter.Finish(err)
+			retry.RecordFailure(err, fmt.Sprintf("userland failed: %v", err))
 			// Perform manager cleanup, then exit with system cleanup
 			manager.Cleanup("userland error")
-			utils.Exit(cfg, logger, 1, "userland phase failed")
+			utils.Exit(cfg, logger, exitCodeFor(err), "userland phase failed")
 		}
 		logger.Info("Userland phase completed successfully")
 	} else {
@@ -71,19 +113,52 @@ func RunDaemon(cfg *config.Config, logger *utils.Logger) {
 
 	// Success!
 	logger.Info("Daemon completed all phases successfully!")
+	updateJamf(cfg, logger)
+	reporter.Finish(nil)
 
 	// Clear retry counter
 	if err := retry.ClearRetryCount(); err != nil {
 		logger.Error("Failed to clear retry count: %v", err)
 	}
 
+	if cfg.RemediationInterval > 0 {
+		if err := installRemediation(bootstrap, cfg, logger); err != nil {
+			logger.Error("⚠️  Failed to schedule remediation: %v", err)
+		}
+	}
+
 	// Perform manager cleanup, then exit with system cleanup
 	manager.Cleanup("daemon completion")
-	utils.Exit(cfg, logger, 0, "daemon successful completion")
+	utils.Exit(cfg, logger, utils.ExitSuccess, "daemon successful completion")
+}
+
+// errBootstrapFetch marks a bootstrap that could not be downloaded.
+var errBootstrapFetch = errors.New("failed to download bootstrap")
+
+// exitCodeFor maps the error that failed a run to its exit code, by the
+// failure category it was tagged with. A timeout exits ExitFailure whatever
+// it interrupted.
+func exitCodeFor(err error) utils.ExitCode {
+	var timeout *utils.TimeoutError
+	if errors.As(err, &timeout) {
+		return utils.ExitFailure
+	}
+	if errors.Is(err, errBootstrapFetch) {
+		return utils.ExitBootstrapFetch
+	}
+	switch retry.CategoryOf(err) {
+	case retry.CategoryNetwork:
+		return utils.ExitDownload
+	case retry.CategoryValidation:
+		return utils.ExitValidation
+	case retry.CategoryIPC:
+		return utils.ExitIPCTimeout
+	}
+	return utils.ExitInstall
 }
 
 // setupBootstrapAndComponents loads bootstrap and creates all necessary components
-func setupBootstrapAndComponents(cfg *config.Config, logger *utils.Logger) (*config.Bootstrap, *download.Client, *installer.SystemInstaller, *manager.Manager, error) {
+func setupBootstrapAndComponents(cfg *config.Config, logger utils.Logger) (*config.Bootstrap, *download.Client, *installer.SystemInstaller, *manager.Manager, error) {
 	// Get bootstrap from either JSON URL or embedded mobile config
 	bootstrap, err := getBootstrap(cfg, logger)
 	if err != nil {
@@ -91,32 +166,63 @@ func setupBootstrapAndComponents(cfg *config.Config, logger *utils.Logger) (*con
 	}
 
 	logger.Info("Bootstrap loaded successfully")
+	if bootstrap, err = restrictBootstrap(bootstrap, cfg, logger); err != nil {
+		return nil, nil, nil, nil, err
+	}
 	logger.Debug("Preflight items: %d, SetupAssistant items: %d, Userland items: %d",
 		len(bootstrap.Preflight), len(bootstrap.SetupAssistant), len(bootstrap.Userland))
 
 	// Create components with authentication support
-	var downloader *download.Client
-	if cfg.HTTPAuthUser != "" || len(cfg.HTTPHeaders) > 0 {
-		downloader = download.NewClientWithAuth(logger, cfg.HTTPAuthUser, cfg.HTTPAuthPassword, cfg.HTTPHeaders)
-		logger.Debug("Created authenticated download client")
-	} else {
-		downloader = download.NewClient(logger)
-	}
-	downloader.SetRetryDefaults(cfg.MaxRetries, cfg.RetryDelay)
-	// Apply redirect behavior to item downloader as well
-	downloader.SetFollowRedirects(cfg.FollowRedirects)
-	downloader.SetHashCheckPolicy(download.ParseHashCheckPolicy(cfg.HashCheckPolicy))
+	downloader := newItemDownloader(cfg, logger)
 
 	systemInstaller := installer.NewSystemInstaller(cfg.DryRun, logger, false) // false = daemon mode (root)
+	systemInstaller.SetStop(shutdownCtx.Done())
+	systemInstaller.SetLogDir(cfg.ItemLogDir())
 	manager := manager.NewManager(downloader, systemInstaller, cfg, logger)
+	manager.SetContext(shutdownCtx)
+	manager.SetHooks(bootstrap.Hooks)
+	manager.SetPreflightOptions(bootstrap.PreflightOptions)
 
 	return bootstrap, downloader, systemInstaller, manager, nil
 }
 
-// processSystemPhases processes preflight and setupassistant phases
-func processSystemPhases(bootstrap *config.Bootstrap, manager *manager.Manager, cfg *config.Config, logger *utils.Logger) error {
+// restrictBootstrap applies --only-phase and --only-item to bootstrap.
+func restrictBootstrap(bootstrap *config.Bootstrap, cfg *config.Config, logger utils.Logger) (*config.Bootstrap, error) {
+	if cfg.OnlyPhase == "" && len(cfg.OnlyItems) == 0 {
+		return bootstrap, nil
+	}
+	restricted, err := bootstrap.Restrict(cfg.OnlyPhase, cfg.OnlyItems)
+	if err != nil {
+		return nil, retry.Tag(retry.CategoryValidation, fmt.Errorf("failed to restrict bootstrap: %w", err))
+	}
+	if cfg.OnlyPhase != "" {
+		logger.Info("🎯 Running only the %s phase", cfg.OnlyPhase)
+	}
+	if len(cfg.OnlyItems) > 0 {
+		logger.Info("🎯 Running only: %s", strings.Join(cfg.OnlyItems, ", "))
+	}
+	return restricted, nil
+}
+
+// newItemDownloader creates the client that downloads bootstrap items, with
+// the configured authentication (device identity included), retries,
+// redirects and hash policy.
+func newItemDownloader(cfg *config.Config, logger utils.Logger) *download.Client {
+	downloader, err := download.NewItemClient(cfg, logger)
+	if err != nil {
+		// The server turns the unauthenticated downloads down
+		logger.Error("⚠️  Cannot use the device identity for downloads: %v", err)
+	}
+	downloader.SetContext(shutdownCtx)
+	return downloader
+}
+
+// processSystemPhases processes preflight and setupassistant phases,
+// calling reload before each
+func processSystemPhases(bootstrap *config.Bootstrap, manager *manager.Manager, reload func(), cfg *config.Config, logger utils.Logger) error {
 	// Process preflight phase
 	if len(bootstrap.Preflight) > 0 {
+		reload()
 		logger.Info("Starting preflight phase")
 		if err := manager.ProcessItems(bootstrap.Preflight, "preflight"); err != nil {
 			return err
@@ -128,7 +234,11 @@ func processSystemPhases(bootstrap *config.Bootstrap, manager *manager.Manager,
 
 	// Process setupassistant phase
 	if len(bootstrap.SetupAssistant) > 0 {
+		reload()
 		logger.Info("Starting setupassistant phase")
+		if !utils.InSetupAssistant() {
+			logger.Info("Setup Assistant has already finished; running setupassistant items now")
+		}
 		if err := manager.ProcessItems(bootstrap.SetupAssistant, "setupassistant"); err != nil {
 			return err
 		}
@@ -141,10 +251,17 @@ func processSystemPhases(bootstrap *config.Bootstrap, manager *manager.Manager,
 }
 
 // getBootstrap retrieves bootstrap configuration from either JSON URL or embedded mobile config
-func getBootstrap(cfg *config.Config, logger *utils.Logger) (*config.Bootstrap, error) {
+func getBootstrap(cfg *config.Config, logger utils.Logger) (*config.Bootstrap, error) {
 	// First check if we have a JSON URL
 	if cfg.JSONURL != "" {
 		logger.Info("Loading bootstrap from JSON URL: %s", cfg.JSONURL)
+		jsonURL, err := bootstrapURL(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errBootstrapFetch, err)
+		}
+		if jsonURL != cfg.JSONURL {
+			logger.Info("Bootstrap URL for this Mac: %s", jsonURL)
+		}
 
 		// Download bootstrap to consistent path
 		bootstrapPath := cfg.InstallPath + "/bootstrap.json"
@@ -163,6 +280,10 @@ func getBootstrap(cfg *config.Config, logger *utils.Logger) (*config.Bootstrap,
 		// honor follow-redirects compat flag
 		downloader.SetFollowRedirects(cfg.FollowRedirects)
 		downloader.SetHashCheckPolicy(download.ParseHashCheckPolicy(cfg.HashCheckPolicy))
+		downloader.SetContext(shutdownCtx)
+		if err := downloader.UseDeviceIdentity(cfg); err != nil {
+			return nil, fmt.Errorf("%w: %w", errBootstrapFetch, err)
+		}
 
 		// When skip_validation is false, remove existing bootstrap so we always re-download
 		if !cfg.SkipValidation {
@@ -174,13 +295,12 @@ func getBootstrap(cfg *config.Config, logger *utils.Logger) (*config.Bootstrap,
 			}
 		}
 
-		if err := downloader.DownloadFile(cfg.JSONURL, bootstrapPath, ""); err != nil {
-			return nil, fmt.Errorf("failed to download bootstrap: %w", err)
+		if err := downloader.DownloadFile(jsonURL, bootstrapPath, ""); err != nil {
+			return nil, fmt.Errorf("%w: %w", errBootstrapFetch, err)
 		}
 
 		// Load and parse bootstrap
 		var bootstrap *config.Bootstrap
-		var err error
 		if cfg.SkipValidation {
 			logger.Debug("SkipValidation=true: loading bootstrap without validation")
 			bootstrap, err = config.LoadBootstrapWithOptions(bootstrapPath, false)
@@ -188,7 +308,7 @@ func getBootstrap(cfg *config.Config, logger *utils.Logger) (*config.Bootstrap,
 			bootstrap, err = config.LoadBootstrap(bootstrapPath)
 		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse bootstrap: %w", err)
+			return nil, retry.Tag(retry.CategoryValidation, fmt.Errorf("failed to parse bootstrap: %w", err))
 		}
 
 		return bootstrap, nil
@@ -196,31 +316,40 @@ func getBootstrap(cfg *config.Config, logger *utils.Logger) (*config.Bootstrap,
 
 	// No JSON URL, try to load from mobile config
 	logger.Info("Loading bootstrap from embedded mobile config")
-	bootstrap, err := cfg.LoadBootstrapFromProfile(config.DefaultProfileDomain)
+	bootstrap, err := cfg.LoadBootstrapFromProfile(cfg.ProfileDomain)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load bootstrap from mobile config: %w", err)
+		return nil, retry.Tag(retry.CategoryValidation, fmt.Errorf("failed to load bootstrap from mobile config: %w", err))
 	}
 
 	return bootstrap, nil
 }
 
-// changeFileOwnershipToConsoleUser changes the ownership of a file to the current console user
-// so that the agent (running as that user) can modify the file's permissions
-func changeFileOwnershipToConsoleUser(filePath string, logger *utils.Logger) error {
-	// Get the console user UID
-	uid, err := utils.GetConsoleUserUID()
-	if err != nil {
-		return fmt.Errorf("failed to get console user UID: %w", err)
-	}
-
+// changeFileOwnershipToUser changes the ownership of a file to the user whose agent
+// will handle it, so that the agent (running as that user) can modify the file's permissions
+func changeFileOwnershipToUser(filePath, uid string, cfg *config.Config, logger utils.Logger) error {
 	// Convert UID string to int
 	var uidInt int
 	if _, err := fmt.Sscanf(uid, "%d", &uidInt); err != nil {
 		return fmt.Errorf("failed to parse UID %s: %w", uid, err)
 	}
 
+	if cfg.DryRun {
+		logger.Info("[DRY RUN] Would change ownership of %s to UID %d", filePath, uidInt)
+		audit.Record(audit.Event{Action: audit.ActionChown, Target: filePath, Outcome: audit.OutcomeDryRun,
+			Details: map[string]string{"owner_uid": uid}})
+		return nil
+	}
+
 	// Change ownership to the console user
-	if err := os.Chown(filePath, uidInt, -1); err != nil {
+	err := os.Chown(filePath, uidInt, -1)
+	audit.Record(audit.Event{
+		Action:  audit.ActionChown,
+		Target:  filePath,
+		Outcome: audit.Outcome(err),
+		Error:   audit.ErrorString(err),
+		Details: map[string]string{"owner_uid": uid},
+	})
+	if err != nil {
 		return fmt.Errorf("failed to change ownership of %s to UID %d: %w", filePath, uidInt, err)
 	}
 
@@ -228,15 +357,49 @@ func changeFileOwnershipToConsoleUser(filePath string, logger *utils.Logger) err
 	return nil
 }
 
+// userlandWithHooks returns the userland items between their pre and post
+// hooks. The daemon runs userland as a single step so agents are gated and
+// shut down once: the hooks are downloaded along with the items but still
+// run first and last, with the same fail_policy.
+func userlandWithHooks(bootstrap *config.Bootstrap) []config.Item {
+	pre, post := bootstrap.Hooks.Pre("userland"), bootstrap.Hooks.Post("userland")
+	if len(pre) == 0 && len(post) == 0 {
+		return bootstrap.Userland
+	}
+	items := make([]config.Item, 0, len(pre)+len(bootstrap.Userland)+len(post))
+	items = append(items, pre...)
+	items = append(items, bootstrap.Userland...)
+	return append(items, post...)
+}
+
+// skipFailedGroups reports the members of failed groups in batch as skipped
+// and returns the rest.
+func skipFailedGroups(batch []config.Item, failed config.FailedGroups, reporter progress.Reporter, logger utils.Logger) []config.Item {
+	var run []config.Item
+	for _, item := range batch {
+		if failed.Skipped(item) {
+			logger.Info("⏭️  Skipping %s: group %q failed", item.Name, item.Group)
+			reporter.ItemSkipped(item, fmt.Sprintf("group %s failed", item.Group))
+			continue
+		}
+		run = append(run, item)
+	}
+	return run
+}
+
 // processUserlandPhase handles the complete userland phase including downloads and execution.
 // Filters items by skip_if BEFORE downloading and applies each item's fail_policy
 // to per-item errors so userland behaves consistently with the manager-driven phases.
-func processUserlandPhase(userlandItems []config.Item, downloader *download.Client, systemInstaller *installer.SystemInstaller, cfg *config.Config, logger *utils.Logger) error {
+func processUserlandPhase(userlandItems []config.Item, downloader *download.Client, systemInstaller *installer.SystemInstaller, reporter progress.Reporter, tracer *tracing.Tracer, cfg *config.Config, logger utils.Logger) (err error) {
+	phaseSpan := tracer.StartPhase("userland")
+	defer func() { phaseSpan.End(err) }()
+
 	// Filter items by skip_if criteria (parity with manager.ProcessItems)
 	var filtered []config.Item
 	for _, item := range userlandItems {
 		if utils.ShouldSkipItem(item.SkipIf, logger) {
 			logger.Info("⏭️  Skipping %s: matches skip_if criteria '%s'", item.Name, item.SkipIf)
+			reporter.ItemSkipped(item, fmt.Sprintf("skip_if %s", item.SkipIf))
 			continue
 		}
 		filtered = append(filtered, item)
@@ -248,23 +411,33 @@ func processUserlandPhase(userlandItems []config.Item, downloader *download.Clie
 
 	// Pre-download userland items
 	logger.Info("Pre-downloading %d userland items", len(filtered))
+	for _, item := range filtered {
+		tracer.StartItem(item)
+	}
 	cleanupFailed := cfg.CleanupOnFailure && !cfg.KeepFailedFiles
 	if !cleanupFailed && cfg.CleanupOnFailure {
 		logger.Debug("KeepFailedFiles=true: preserving failed downloads for troubleshooting")
 	}
-	results := downloader.DownloadMultipleWithCleanup(filtered, cfg.DownloadMaxConcurrency, cleanupFailed)
+	results := downloader.DownloadMultipleWithCleanup(stageUserFiles(filtered, cfg), cfg.DownloadMaxConcurrency, cleanupFailed)
+	if err := userlandStopped(shutdownCtx, nil); err != nil {
+		return err
+	}
 
 	// Map download outcomes back to items so we can honor fail_policy for download errors
 	downloadErrByName := map[string]error{}
+	failedGroups := make(config.FailedGroups)
 	successItems := make([]config.Item, 0, len(filtered))
-	for _, result := range results {
+	for i, result := range results {
+		result.Item = filtered[i] // undo userfile staging; results are in input order
 		if result.Error != nil {
 			logger.Error("Failed to download userland item '%s': %v", result.Item.Name, result.Error)
+			reporter.ItemFinished(result.Item, result.Error)
 			if result.Item.ShouldStopOnError("download") {
 				return fmt.Errorf("userland download failed for %s (fail_policy enforced): %w", result.Item.Name, result.Error)
 			}
 			logger.Info("⚠️  Download failure tolerated by fail_policy for %s; skipping item", result.Item.Name)
 			downloadErrByName[result.Item.Name] = result.Error
+			failedGroups.Fail(result.Item)
 			continue
 		}
 		logger.Debug("Pre-downloaded userland item: %s", result.Item.Name)
@@ -276,7 +449,14 @@ func processUserlandPhase(userlandItems []config.Item, downloader *download.Clie
 		return nil
 	}
 
-	// Wait for agent socket only if there are user-context items to delegate
+	// Userland runs once Setup Assistant is over, user-context or not. An
+	// agent answering is not enough: one can run in _mbsetupuser's session.
+	if err := utils.WaitForSetupAssistant(shutdownCtx, cfg.WaitForAgentTimeout, logger); err != nil {
+		return fmt.Errorf("userland phase not started: %w", err)
+	}
+
+	// Wait for the agent only if there are user-context items to delegate,
+	// unless UserlandGatePolicy says otherwise
 	needsAgent := false
 	for _, item := range successItems {
 		if item.Type == "userscript" || item.Type == "userfile" {
@@ -284,77 +464,85 @@ func processUserlandPhase(userlandItems []config.Item, downloader *download.Clie
 			break
 		}
 	}
-	var sockPath string
-	if needsAgent {
-		logger.Info("Waiting for GUI login and agent readiness to process userland phase")
-		p, err := waitForAgentSocket(logger, cfg.WaitForAgentTimeout)
-		if err != nil {
-			return fmt.Errorf("agent readiness wait failed: %w", err)
-		}
-		sockPath = p
-	} else {
-		logger.Debug("No user-context items in userland; skipping wait for agent socket")
+	router, err := gateUserland(needsAgent, reporter, cfg, logger)
+	if err != nil {
+		return retry.Tag(retry.CategoryIPC, err)
+	}
+	ctx := shutdownCtx
+	if cfg.PhaseTimeout > 0 {
+		var done func()
+		ctx, done = limitUserlandPhase(systemInstaller, cfg, logger)
+		defer done()
 	}
 
 	// Process userland items in declared order, batched by parallel_group.
 	logger.Info("Starting ordered userland processing")
-	var daemonBackgroundCount, agentBackgroundCount int
+	var daemonBackgroundCount int
 
 	batches := config.BatchByParallelGroup(successItems)
 	for _, batch := range batches {
+		if err := userlandStopped(ctx, router); err != nil {
+			return err
+		}
+		if batch = skipFailedGroups(batch, failedGroups, reporter, logger); len(batch) == 0 {
+			continue
+		}
 		if len(batch) == 1 {
 			item := batch[0]
-			res := runUserlandItem(item, sockPath, needsAgent, systemInstaller, cfg, logger)
+			res := runUserlandItem(ctx, item, router, systemInstaller, reporter, tracer, cfg, logger)
 			daemonBackgroundCount += res.daemonBg
-			agentBackgroundCount += res.agentBg
 			if res.err != nil {
+				if err := userlandStopped(ctx, router); err != nil {
+					return err
+				}
 				policy := item.GetEffectiveFailPolicy()
 				if item.ShouldStopOnError(res.operation) {
 					logger.Error("❌ %s failed for %s (fail_policy: %s): %v", res.operation, item.Name, policy, res.err)
-					if needsAgent && sockPath != "" {
-						if _, err := callAgent(logger, sockPath, ipc.RPCRequest{Command: "Shutdown"}, cfg.AgentRequestTimeout); err != nil {
-							logger.Debug("Agent shutdown request failed (non-fatal): %v", err)
-						}
-					}
+					router.shutdown()
 					return fmt.Errorf("userland %s failed for %s: %w", res.operation, item.Name, res.err)
 				}
 				logger.Info("⚠️  %s failed for %s (fail_policy: %s): %v - continuing", res.operation, item.Name, policy, res.err)
+				failedGroups.Fail(item)
 			}
 			continue
 		}
 
 		// Parallel batch
-		groupName := batch[0].ParallelGroup
-		logger.Info("🔀 parallel_group %q: running %d userland items concurrently", groupName, len(batch))
+		groupName := config.BatchName(batch)
+		limit := cfg.InstallLimit(len(batch))
+		logger.Info("🔀 parallel_group %q: running %d userland items concurrently (%d at a time)", groupName, len(batch), limit)
 		results := make([]userlandResult, len(batch))
+		semaphore := make(chan struct{}, limit)
 		var wg sync.WaitGroup
 		for i := range batch {
 			wg.Add(1)
 			go func(i int) {
 				defer wg.Done()
-				results[i] = runUserlandItem(batch[i], sockPath, needsAgent, systemInstaller, cfg, logger)
+				defer utils.Recover(logger, "userland item "+batch[i].Name)
+				semaphore <- struct{}{}
+				defer func() { <-semaphore }()
+				results[i] = runUserlandItem(ctx, batch[i], router, systemInstaller, reporter, tracer, cfg, logger)
 			}(i)
 		}
 		wg.Wait()
+		if err := userlandStopped(ctx, router); err != nil {
+			return err
+		}
 
 		for idx, res := range results {
 			item := batch[idx]
 			daemonBackgroundCount += res.daemonBg
-			agentBackgroundCount += res.agentBg
 			if res.err == nil {
 				continue
 			}
 			policy := item.GetEffectiveFailPolicy()
 			if item.ShouldStopOnError(res.operation) {
 				logger.Error("❌ %s failed for %s (fail_policy: %s, parallel_group=%q): %v", res.operation, item.Name, policy, groupName, res.err)
-				if needsAgent && sockPath != "" {
-					if _, err := callAgent(logger, sockPath, ipc.RPCRequest{Command: "Shutdown"}, cfg.AgentRequestTimeout); err != nil {
-						logger.Debug("Agent shutdown request failed (non-fatal): %v", err)
-					}
-				}
+				router.shutdown()
 				return fmt.Errorf("parallel_group %q: %s failed for %s: %w", groupName, res.operation, item.Name, res.err)
 			}
 			logger.Info("⚠️  %s failed for %s (fail_policy: %s, parallel_group=%q): %v - continuing", res.operation, item.Name, policy, groupName, res.err)
+			failedGroups.Fail(item)
 		}
 		logger.Info("✅ parallel_group %q complete", groupName)
 	}
@@ -364,26 +552,8 @@ func processUserlandPhase(userlandItems []config.Item, downloader *download.Clie
 	// TrackBackgroundProcesses — when tracking is off, donotwait items are
 	// fire-and-forget and there is nothing to wait for on either side.
 	if cfg.TrackBackgroundProcesses {
-		if agentBackgroundCount > 0 && sockPath != "" {
-			logger.Info("Waiting for %d agent-side background processes to complete", agentBackgroundCount)
-			timeoutSec := int(cfg.BackgroundTimeout / time.Second)
-			if timeoutSec <= 0 {
-				timeoutSec = 300
-			}
-			resp, err := callAgent(logger, sockPath, ipc.RPCRequest{
-				Command:        "WaitForBackgroundProcesses",
-				TimeoutSeconds: timeoutSec,
-			}, cfg.AgentRequestTimeout)
-			if err != nil {
-				return fmt.Errorf("agent background wait IPC failed: %w", err)
-			}
-			if !resp.OK {
-				for _, e := range resp.Errors {
-					logger.Error("  - %s", e)
-				}
-				return fmt.Errorf("agent background processes failed: %d errors", len(resp.Errors))
-			}
-			logger.Info("All agent-side background processes completed successfully")
+		if err := router.waitForBackground(ctx); err != nil {
+			return err
 		}
 
 		if daemonBackgroundCount > 0 {
@@ -402,12 +572,8 @@ func processUserlandPhase(userlandItems []config.Item, downloader *download.Clie
 
 	logger.Info("Userland processing completed")
 
-	// Request agent shutdown
-	if needsAgent && sockPath != "" {
-		if _, err := callAgent(logger, sockPath, ipc.RPCRequest{Command: "Shutdown"}, cfg.AgentRequestTimeout); err != nil {
-			logger.Debug("Agent shutdown request failed (non-fatal): %v", err)
-		}
-	}
+	// Request shutdown of every agent used
+	router.shutdown()
 
 	if len(downloadErrByName) > 0 {
 		logger.Info("Userland phase completed with %d tolerated download failures", len(downloadErrByName))
@@ -415,26 +581,73 @@ func processUserlandPhase(userlandItems []config.Item, downloader *download.Clie
 	return nil
 }
 
-// userlandResult is the per-item outcome of runUserlandItem. daemonBg and
-// agentBg are 0 or 1 depending on whether a tracked background process was
-// started on the daemon or the agent side respectively.
+// limitUserlandPhase applies PhaseTimeout to the userland phase from the
+// point its gate opens: waiting for Setup Assistant and the user does not
+// count. It returns the phase's context, which agent requests and root
+// items stop on, and a func that ends it.
+func limitUserlandPhase(si *installer.SystemInstaller, cfg *config.Config, logger utils.Logger) (context.Context, func()) {
+	ctx, cancel := utils.WithTimeout(shutdownCtx, cfg.PhaseTimeout, "userland phase", logger)
+	si.SetStop(ctx.Done())
+	return ctx, func() {
+		cancel()
+		si.SetStop(shutdownCtx.Done())
+	}
+}
+
+// userlandStopped returns a non-nil error once ctx, the userland phase's
+// context, is done: a shutdown signal has stopped the run or the phase ran
+// out of time. In-flight agent requests have been abandoned (and foreground
+// userscripts cancelled) by then; the agents used are asked to exit.
+func userlandStopped(ctx context.Context, router *agentRouter) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	router.shutdown()
+	return fmt.Errorf("userland phase stopped: %w", context.Cause(ctx))
+}
+
+// userlandResult is the per-item outcome of runUserlandItem. daemonBg is 1
+// when a tracked background process was started on the daemon side; agent-side
+// ones are counted per agent by the router.
 type userlandResult struct {
 	operation string
 	err       error
 	daemonBg  int
-	agentBg   int
 }
 
 // runUserlandItem dispatches a single userland item without consulting
-// fail_policy. The caller decides whether to abort.
-func runUserlandItem(item config.Item, sockPath string, _ bool, si *installer.SystemInstaller, cfg *config.Config, logger *utils.Logger) userlandResult {
+// fail_policy and reports its outcome. The caller decides whether to abort.
+func runUserlandItem(ctx context.Context, item config.Item, router *agentRouter, si *installer.SystemInstaller, reporter progress.Reporter, tracer *tracing.Tracer, cfg *config.Config, logger utils.Logger) userlandResult {
+	reporter.ItemStarted(item)
+	span := tracer.Item(item.Name).StartChild("install")
+	span.SetAttr("item.type", item.Type)
+	res := dispatchUserlandItem(ctx, item, router, si, reporter, cfg, logger)
+	span.End(res.err)
+	reporter.ItemFinished(item, res.err)
+	if res.err == nil {
+		utils.RecordInstalled()
+	}
+	return res
+}
+
+// dispatchUserlandItem routes a userland item to the daemon or the console
+// user's agent.
+func dispatchUserlandItem(ctx context.Context, item config.Item, router *agentRouter, si *installer.SystemInstaller, reporter progress.Reporter, cfg *config.Config, logger utils.Logger) userlandResult {
 	switch item.Type {
 	case "userscript":
+		if router != nil && router.asUser {
+			return runUserItemAsUser(item, router.bestEffort, si, cfg, logger)
+		}
 		res := userlandResult{operation: "script execution"}
-		res.err = processUserScript(item, sockPath, cfg, logger)
+		uid, sockPath, err := router.route()
+		if err != nil {
+			res.err = err
+			return res
+		}
+		res.err = processUserScript(ctx, item, uid, sockPath, cfg, logger)
 		if res.err == nil {
 			if item.DoNotWait && cfg.TrackBackgroundProcesses {
-				res.agentBg = 1
+				router.addBackground(sockPath)
 				logger.Info("✅ User script delegated (background): %s", item.Name)
 			} else if item.DoNotWait {
 				logger.Info("✅ User script delegated (fire-and-forget): %s", item.Name)
@@ -444,22 +657,34 @@ func runUserlandItem(item config.Item, sockPath string, _ bool, si *installer.Sy
 		}
 		return res
 	case "userfile":
+		if router != nil && router.asUser {
+			return runUserItemAsUser(item, router.bestEffort, si, cfg, logger)
+		}
 		res := userlandResult{operation: "file placement"}
-		res.err = processUserFile(item, sockPath, cfg, logger)
+		uid, sockPath, err := router.route()
+		if err != nil {
+			res.err = err
+			return res
+		}
+		res.err = processUserFile(ctx, item, uid, sockPath, cfg, logger)
 		if res.err == nil {
 			logger.Info("✅ User file placed: %s", item.Name)
 		}
 		return res
 	case "package":
 		res := userlandResult{operation: "package installation"}
-		res.err = processPackage(item, si, logger)
+		res.err = processPackage(item, si, reporter, cfg, logger)
 		if res.err == nil {
 			logger.Info("✅ Package installed: %s", item.Name)
 		}
 		return res
 	case "rootscript":
 		res := userlandResult{operation: "script execution"}
-		res.err = si.ExecuteScript(item.File, "rootscript", item.DoNotWait, cfg.TrackBackgroundProcesses)
+		if item.RunAs != "" {
+			res.err = si.ExecuteScriptAs(item.File, item.RunAs, item.DoNotWait, cfg.TrackBackgroundProcesses)
+		} else {
+			res.err = si.ExecuteScript(item.File, "rootscript", item.DoNotWait, cfg.TrackBackgroundProcesses)
+		}
 		if res.err == nil {
 			if item.DoNotWait && cfg.TrackBackgroundProcesses {
 				res.daemonBg = 1
@@ -471,9 +696,35 @@ func runUserlandItem(item config.Item, sockPath string, _ bool, si *installer.Sy
 			}
 		}
 		return res
+	case "launchd":
+		res := userlandResult{operation: "service management"}
+		res.err = si.ManageService(installer.ServiceOptionsFor(item))
+		if res.err == nil {
+			logger.Info("✅ launchctl %s done: %s", item.Action, item.Name)
+		}
+		return res
+	case "vppapp":
+		res := userlandResult{operation: "package installation"}
+		res.err = si.InstallAppStoreApp(installer.VPPAppOptionsFor(item, cfg))
+		if res.err == nil {
+			logger.Info("✅ App Store app requested: %s", item.Name)
+		}
+		return res
+	case "munki":
+		if item.File != "" {
+			if err := processPackage(item, si, reporter, cfg, logger); err != nil {
+				return userlandResult{operation: "package installation", err: err}
+			}
+		}
+		res := userlandResult{operation: "script execution"}
+		res.err = si.ConfigureMunki(installer.MunkiOptionsFor(item, cfg))
+		if res.err == nil {
+			logger.Info("✅ Handed off to Munki: %s", item.Name)
+		}
+		return res
 	case "rootfile":
 		res := userlandResult{operation: "file placement"}
-		res.err = si.PlaceFile(item.File, "rootfile")
+		res.err = si.PlaceFile(item.File, "rootfile", installer.FileOptionsFor(item))
 		if res.err == nil {
 			logger.Info("✅ Root file placed: %s", item.Name)
 		}
@@ -484,37 +735,242 @@ func runUserlandItem(item config.Item, sockPath string, _ bool, si *installer.Sy
 	}
 }
 
+// waitForAgentBackground asks the agent which background processes it is
+// tracking, reports them, and waits for them to finish. The agent's own
+// count is authoritative; localCount (the donotwait items the daemon
+// delegated) is only used if the agent cannot report its status.
+func waitForAgentBackground(ctx context.Context, sockPath string, localCount int, cfg *config.Config, logger utils.Logger) error {
+	count := localCount
+	resp, err := callAgent(ctx, logger, sockPath, ipc.RPCRequest{Command: "GetBackgroundStatus"}, cfg.AgentRequestTimeout)
+	if err == nil && resp.OK {
+		count = len(resp.Background)
+		logBackgroundStatus(resp.Background, logger)
+	} else {
+		logger.Debug("Agent background status unavailable, using local count %d: %v %s", localCount, err, resp.Error)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	logger.Info("Waiting for %d agent-side background processes to complete", count)
+	timeoutSec := int(cfg.BackgroundTimeout / time.Second)
+	if timeoutSec <= 0 {
+		timeoutSec = 300
+	}
+	resp, err = callAgent(ctx, logger, sockPath, ipc.RPCRequest{
+		Command:        "WaitForBackground",
+		TimeoutSeconds: timeoutSec,
+	}, cfg.AgentRequestTimeout)
+	if err != nil {
+		return fmt.Errorf("agent background wait IPC failed: %w", err)
+	}
+	if !resp.OK {
+		for _, e := range resp.Errors {
+			logger.Error("  - %s", e)
+		}
+		return fmt.Errorf("agent background processes failed: %d errors", len(resp.Errors))
+	}
+	logger.Info("All agent-side background processes completed successfully")
+	return nil
+}
+
+// logBackgroundStatus logs one line per agent-side background process
+func logBackgroundStatus(statuses []utils.ProcessStatus, logger utils.Logger) {
+	for _, st := range statuses {
+		switch {
+		case st.Running:
+			logger.Info("  ⏳ %s (PID %d) running for %.0fs", st.Name, st.PID, st.RuntimeSeconds)
+		case st.Error != "":
+			logger.Info("  ❌ %s (PID %d) failed after %.0fs: %s", st.Name, st.PID, st.RuntimeSeconds, st.Error)
+		default:
+			logger.Info("  ✅ %s (PID %d) finished after %.0fs", st.Name, st.PID, st.RuntimeSeconds)
+		}
+	}
+}
+
 // processUserScript handles userscript execution via agent IPC
-func processUserScript(item config.Item, sockPath string, cfg *config.Config, logger *utils.Logger) error {
-	// Change ownership of user scripts to console user so agent can execute them
-	if err := changeFileOwnershipToConsoleUser(item.File, logger); err != nil {
+func processUserScript(ctx context.Context, item config.Item, uid, sockPath string, cfg *config.Config, logger utils.Logger) error {
+	// Change ownership of user scripts to the agent's user so it can execute them
+	if err := changeFileOwnershipToUser(item.File, uid, cfg, logger); err != nil {
 		return fmt.Errorf("failed to change ownership of user script %s: %w", item.Name, err)
 	}
 
-	// Delegate to agent via IPC
-	resp, err := callAgent(logger, sockPath, ipc.RPCRequest{Command: "RunUserScript", Path: item.File, DoNotWait: item.DoNotWait}, cfg.AgentRequestTimeout)
+	// Delegate to agent via IPC. The agent runs unprivileged and cannot write
+	// the audit log, so the daemon records the delegated execution.
+	event := audit.Event{
+		Action:  audit.ActionScriptExecute,
+		Target:  item.File,
+		SHA256:  audit.FileSHA256(item.File),
+		Details: map[string]string{"type": "userscript", "via": "agent"},
+	}
+	// Log output live rather than only when the script finishes
+	onOutput := func(stream, line string) {
+		logger.Info("[%s %s] %s", item.Name, stream, line)
+	}
+	resp, err := callAgentStreaming(ctx, logger, sockPath, ipc.RPCRequest{Command: "RunUserScript", Path: item.File, ItemName: item.Name, DoNotWait: item.DoNotWait}, cfg.AgentRequestTimeout, onOutput)
 	if err != nil || !resp.OK {
-		return fmt.Errorf("agent userscript failed: %v %s", err, resp.Error)
+		err = fmt.Errorf("agent userscript failed: %v %s", err, resp.Error)
 	}
-	return nil
+	event.Outcome, event.Error = audit.Outcome(err), audit.ErrorString(err)
+	if err == nil && item.DoNotWait {
+		event.Outcome = audit.OutcomeStarted
+	}
+	audit.Record(event)
+	return err
 }
 
-// processUserFile handles userfile placement via agent IPC
-func processUserFile(item config.Item, sockPath string, cfg *config.Config, logger *utils.Logger) error {
-	// Change ownership of user files to console user so agent can modify them
-	if err := changeFileOwnershipToConsoleUser(item.File, logger); err != nil {
+// userFileMode is the mode userfiles are placed with (as FilePlacer does).
+const userFileMode = installer.UserFileMode
+
+// userFileOptions returns the placement options of a userfile, with its
+// mode filled in, and the mode of the directories created for it; a zero
+// dirMode means the default.
+func userFileOptions(item config.Item) (opts installer.FileOptions, dirMode os.FileMode) {
+	opts = installer.FileOptionsFor(item)
+	if opts.Mode == 0 {
+		opts.Mode = userFileMode
+	}
+	dirMode, _ = config.ParseFileMode(item.DirMode)
+	return opts, dirMode
+}
+
+// userFileStagingPath is where a downloaded userfile waits until the agent
+// writes it to item.File. The root daemon may not be allowed to write the
+// destination itself (e.g. TCC-protected ~/Library/Containers), and "~/"
+// destinations are only meaningful to the agent.
+func userFileStagingPath(item config.Item, cfg *config.Config) string {
+	sum := sha256.Sum256([]byte(item.File))
+	return filepath.Join(cfg.InstallPath, "userfiles", hex.EncodeToString(sum[:6])+"-"+filepath.Base(item.File))
+}
+
+// stageUserFiles returns a copy of items with downloaded and inline
+// userfiles pointed at their staging paths.
+func stageUserFiles(items []config.Item, cfg *config.Config) []config.Item {
+	staged := make([]config.Item, len(items))
+	for i, item := range items {
+		if item.Type == "userfile" && item.Delivered() {
+			item.File = userFileStagingPath(item, cfg)
+			// dir_mode and backup are for the destination, not the staging
+			// directory
+			item.DirMode = ""
+			item.Backup = false
+		}
+		staged[i] = item
+	}
+	return staged
+}
+
+// processUserFile handles userfile placement via agent IPC. Downloaded and
+// inline files are transferred to the agent, which writes them as the user. Files already
+// on disk, and agents without TransferFile, use the older flow: chown the
+// file to the user and have the agent set its permissions.
+func processUserFile(ctx context.Context, item config.Item, uid, sockPath string, cfg *config.Config, logger utils.Logger) (err error) {
+	src := item.File
+	if item.Delivered() {
+		src = userFileStagingPath(item, cfg)
+	}
+	event := audit.Event{
+		Action:  audit.ActionFilePlace,
+		Target:  item.File,
+		SHA256:  audit.FileSHA256(src),
+		Details: map[string]string{"type": "userfile", "via": "agent"},
+	}
+	defer func() {
+		event.Outcome, event.Error = audit.Outcome(err), audit.ErrorString(err)
+		audit.Record(event)
+	}()
+
+	opts, dirMode := userFileOptions(item)
+	// The agent only writes the file, so the daemon checks it first
+	if opts.VerifySignature {
+		if err := installer.NewFilePlacer(cfg.DryRun, logger, false).VerifyCodeSignature(src, opts); err != nil {
+			return err
+		}
+		event.Details["signature"] = "verified"
+	}
+	if src != item.File && item.Backup {
+		if err := backupUserFile(item, cfg, logger); err != nil {
+			return err
+		}
+	}
+	if src != item.File && agentSupports(sockPath, "TransferFile") {
+		event.Details["via"] = "agent-transfer"
+		if err := transferFileToAgent(ctx, logger, sockPath, src, item.File, opts, dirMode, cfg.AgentRequestTimeout); err != nil {
+			return fmt.Errorf("agent userfile transfer failed: %w", err)
+		}
+		_ = os.Remove(src)
+		return nil
+	}
+
+	if src != item.File {
+		if strings.HasPrefix(item.File, "~/") {
+			return fmt.Errorf("agent does not support TransferFile; cannot place %s", item.File)
+		}
+		if err := moveFile(src, item.File, dirMode); err != nil {
+			return fmt.Errorf("failed to place user file %s: %w", item.Name, err)
+		}
+	}
+	// Change ownership of user files to the agent's user so it can modify them
+	if err := changeFileOwnershipToUser(item.File, uid, cfg, logger); err != nil {
 		return fmt.Errorf("failed to change ownership of user file %s: %w", item.Name, err)
 	}
 
-	resp, err := callAgent(logger, sockPath, ipc.RPCRequest{Command: "PlaceUserFile", Path: item.File}, cfg.AgentRequestTimeout)
+	req := ipc.RPCRequest{Command: "PlaceUserFile", Path: item.File, Mode: uint32(opts.Mode.Perm()), StripQuarantine: opts.StripQuarantine, XAttrs: opts.XAttrs}
+	resp, err := callAgent(ctx, logger, sockPath, req, cfg.AgentRequestTimeout)
 	if err != nil || !resp.OK {
 		return fmt.Errorf("agent userfile failed: %v %s", err, resp.Error)
 	}
 	return nil
 }
 
-// processPackage installs a package. Skips if already installed (version >= required) unless pkg_required is true.
-func processPackage(item config.Item, systemInstaller *installer.SystemInstaller, logger *utils.Logger) error {
+// backupUserFile keeps the file a downloaded userfile is about to replace.
+// The daemon does it as root, since the agent's user may not be able to
+// write the backup location.
+func backupUserFile(item config.Item, cfg *config.Config, logger utils.Logger) error {
+	backedUp, err := utils.BackupFile(item.File, cfg.FileBackupDir)
+	if err != nil {
+		return err
+	}
+	if backedUp {
+		logger.Info("Backed up %s to %s", item.File, utils.BackupPath(item.File, cfg.FileBackupDir))
+	}
+	return nil
+}
+
+// moveFile moves src to dst, copying when they are on different volumes.
+// Missing directories are created with dirMode, or 0755 when it is zero.
+func moveFile(src, dst string, dirMode os.FileMode) error {
+	if dirMode != 0 {
+		if err := utils.EnsureDirForFileMode(dst, dirMode); err != nil {
+			return err
+		}
+	} else if err := utils.EnsureDirForFile(dst); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, userFileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// processPackage installs a package, sending its progress to reporter. Skips if already installed (version >= required) unless pkg_required is true.
+func processPackage(item config.Item, systemInstaller *installer.SystemInstaller, reporter progress.Reporter, cfg *config.Config, logger utils.Logger) error {
 	if !item.PkgRequired && item.PackageID != "" {
 		alreadySatisfied, checkErr := utils.CheckPackageReceipt(item.PackageID, item.Version, logger)
 		if checkErr != nil {
@@ -525,7 +981,9 @@ func processPackage(item config.Item, systemInstaller *installer.SystemInstaller
 			return nil
 		}
 	}
-	if err := systemInstaller.InstallPackage(item.File, "/"); err != nil {
+	opts := installer.PackageOptionsFor(item, cfg)
+	opts.ReportProgress(item, reporter)
+	if err := systemInstaller.InstallPackage(item.File, opts); err != nil {
 		return fmt.Errorf("failed to install package: %w", err)
 	}
 	return nil
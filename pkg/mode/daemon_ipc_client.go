@@ -2,14 +2,23 @@ package mode
 
 import (
 	"bufio"
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-installapplications/pkg/installer"
 	"github.com/go-installapplications/pkg/ipc"
+	"github.com/go-installapplications/pkg/retry"
 	"github.com/go-installapplications/pkg/utils"
 )
 
@@ -22,46 +31,358 @@ func generateRequestID() string {
 	return fmt.Sprintf("req-%s-%d", hex.EncodeToString(b), time.Now().UnixNano())
 }
 
-// waitForAgentSocket waits until the agent socket is available or times out.
+// waitForConsoleAgent waits until the console user's agent socket is
+// available or times out, returning that user's UID and socket. The console
+// user is re-resolved on every poll so a login or user switch during the
+// wait is followed instead of waiting on a socket that will never appear.
 // This replaces the older file-based "userland ready" signal and is more reliable.
-func waitForAgentSocket(logger *utils.Logger, timeout time.Duration) (string, error) {
-	uid, err := utils.GetConsoleUserUID()
-	if err != nil {
-		return "", err
+func waitForConsoleAgent(logger utils.Logger, timeout time.Duration) (uid, sockPath string, err error) {
+	start := time.Now()
+	atLoginWindow := false
+	for {
+		if uid, err = consoleUID(); err != nil {
+			return "", "", err
+		}
+		if uid == "0" && !atLoginWindow {
+			logger.Info("At the login window; user items wait for a login")
+		}
+		atLoginWindow = uid == "0"
+		if isUserUID(uid) {
+			// The agent can only listen once its directory exists
+			if err := ipc.EnsureUserSocketDir(uid); err != nil {
+				return "", "", err
+			}
+			p, err := ipc.GetAgentSocketPathForUID(uid)
+			if err != nil {
+				return "", "", err
+			}
+			if p != sockPath {
+				logger.Debug("Waiting for agent socket: %s", p)
+				sockPath = p
+			}
+			conn, err := net.DialTimeout("unix", sockPath, 2*time.Second)
+			if err == nil {
+				_ = conn.Close()
+				return uid, sockPath, nil
+			}
+		}
+		if shutdownCtx.Err() != nil {
+			return "", "", context.Cause(shutdownCtx)
+		}
+		if time.Since(start) > timeout {
+			if sockPath == "" {
+				return "", "", fmt.Errorf("timeout waiting for a console user to log in")
+			}
+			return "", "", fmt.Errorf("timeout waiting for agent socket: %s", sockPath)
+		}
+		time.Sleep(1 * time.Second)
 	}
-	sockPath := ipc.GetAgentSocketPathForUID(uid)
+}
+
+// consoleUID is utils.GetConsoleUserUID; swapped out in tests.
+var consoleUID = utils.GetConsoleUserUID
+
+// isUserUID reports whether uid is a person's session. UID 0 owns the
+// console at the login window and _mbsetupuser during Setup Assistant.
+func isUserUID(uid string) bool {
+	return uid != "" && uid != "0" && uid != utils.SetupAssistantUID
+}
 
-	logger.Debug("Waiting for agent socket: %s", sockPath)
+// waitForConsoleUser waits until a user owns the console or times out and
+// returns their UID.
+func waitForConsoleUser(logger utils.Logger, timeout time.Duration) (string, error) {
 	start := time.Now()
+	logged := false
 	for {
-		conn, err := net.DialTimeout("unix", sockPath, 2*time.Second)
-		if err == nil {
-			_ = conn.Close()
-			logger.Info("Agent socket is ready")
-			return sockPath, nil
+		uid, err := consoleUID()
+		if err != nil {
+			return "", err
+		}
+		if isUserUID(uid) {
+			return uid, nil
+		}
+		if !logged {
+			logger.Info("Waiting for a user to log in")
+			logged = true
+		}
+		if shutdownCtx.Err() != nil {
+			return "", context.Cause(shutdownCtx)
 		}
 		if time.Since(start) > timeout {
-			return "", fmt.Errorf("timeout waiting for agent socket: %s", sockPath)
+			return "", fmt.Errorf("timeout waiting for a console user to log in")
 		}
 		time.Sleep(1 * time.Second)
 	}
 }
 
+// agentSigners holds the signer for each agent socket. The daemon creates
+// one secret per socket per run.
+var (
+	agentSignersMu sync.Mutex
+	agentSigners   = map[string]*ipc.Signer{}
+)
+
+// agentSigner returns the signer for sockPath, creating its secret on first
+// use.
+func agentSigner(sockPath string) (*ipc.Signer, error) {
+	agentSignersMu.Lock()
+	defer agentSignersMu.Unlock()
+	if s, ok := agentSigners[sockPath]; ok {
+		return s, nil
+	}
+	key, err := ipc.CreateKey(sockPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IPC key: %w", err)
+	}
+	s := ipc.NewSigner(key)
+	agentSigners[sockPath] = s
+	return s, nil
+}
+
+// agentProtocols holds the protocol each agent socket reported in Hello.
+// Sockets that have not been negotiated with are not restricted.
+var (
+	agentProtocolsMu sync.Mutex
+	agentProtocols   = map[string]ipc.Protocol{}
+)
+
+// negotiateAgent exchanges Hello with the agent at sockPath and records the
+// protocol it speaks, so later requests only use commands it understands.
+// Agents that predate Hello get the legacy command subset; an incompatible
+// agent fails here with a clear error instead of misbehaving mid-run.
+func negotiateAgent(logger utils.Logger, sockPath string) error {
+	conn, err := net.DialTimeout("unix", sockPath, 2*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect agent: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	resp, err := exchange(logger, sockPath, conn, ipc.RPCRequest{ID: generateRequestID(), Command: "Hello", ProtocolVersion: ipc.ProtocolVersion}, nil, nil)
+	if err != nil {
+		return fmt.Errorf("agent handshake failed: %w", err)
+	}
+	proto, err := ipc.ProtocolFromHello(resp)
+	if err != nil {
+		return err
+	}
+	if proto.Version < ipc.ProtocolVersion {
+		logger.Info("⚠️  Agent speaks IPC protocol %d (daemon: %d); newer features are disabled for this session", proto.Version, ipc.ProtocolVersion)
+	} else {
+		logger.Debug("Agent at %s speaks IPC protocol %d", sockPath, proto.Version)
+	}
+	agentProtocolsMu.Lock()
+	defer agentProtocolsMu.Unlock()
+	agentProtocols[sockPath] = proto
+	return nil
+}
+
+// resolveAgentCommand returns the name to send cmd to sockPath's agent
+// under, or an error if the negotiated protocol does not include it.
+func resolveAgentCommand(sockPath, cmd string) (string, error) {
+	agentProtocolsMu.Lock()
+	proto, ok := agentProtocols[sockPath]
+	agentProtocolsMu.Unlock()
+	if !ok {
+		return cmd, nil
+	}
+	name, ok := proto.Resolve(cmd)
+	if !ok {
+		return "", fmt.Errorf("agent does not support %s (IPC protocol %d); update the agent to use it", cmd, proto.Version)
+	}
+	return name, nil
+}
+
+// Heartbeat and reconnect tuning. Variables so tests can shorten them.
+var (
+	// agentHeartbeatInterval is how often a long-running request pings the
+	// agent on a separate connection.
+	agentHeartbeatInterval = 30 * time.Second
+	// agentHeartbeatMisses consecutive failed pings abandon the request.
+	agentHeartbeatMisses = 3
+	// agentReconnectTimeout bounds how long a request waits for a relaunched
+	// agent (launchd KeepAlive) to accept connections again.
+	agentReconnectTimeout = 5 * time.Minute
+)
+
 // callAgent sends an RPC to the agent and waits for a response.
 // Requests are synchronous to preserve strict item ordering across userland.
-func callAgent(logger *utils.Logger, sockPath string, req ipc.RPCRequest, callTimeout time.Duration) (ipc.RPCResponse, error) {
+// Once ctx is done, item requests are abandoned as if they had timed out.
+func callAgent(ctx context.Context, logger utils.Logger, sockPath string, req ipc.RPCRequest, callTimeout time.Duration) (ipc.RPCResponse, error) {
+	return callAgentStreaming(ctx, logger, sockPath, req, callTimeout, nil)
+}
+
+// callAgentStreaming is callAgent for requests that stream output: each line
+// the agent sends before its final response is passed to onOutput.
+//
+// If the agent is not accepting connections (e.g. it crashed and launchd is
+// relaunching it), item requests wait for it with backoff; progress updates,
+// notifications and Shutdown are best-effort and fail right away. While waiting for
+// the response, the agent is pinged every agentHeartbeatInterval; if it stops
+// answering the request fails right away instead of after callTimeout.
+// A foreground userscript that outlives callTimeout is cancelled on the agent
+// so it does not keep running unobserved.
+func callAgentStreaming(ctx context.Context, logger utils.Logger, sockPath string, req ipc.RPCRequest, callTimeout time.Duration, onOutput func(stream, line string)) (ipc.RPCResponse, error) {
+	return callAgentWithBody(ctx, logger, sockPath, req, nil, callTimeout, onOutput)
+}
+
+// callAgentWithBody is callAgentStreaming for requests followed by content,
+// which is sent as FileChunks right after the request.
+func callAgentWithBody(ctx context.Context, logger utils.Logger, sockPath string, req ipc.RPCRequest, body io.Reader, callTimeout time.Duration, onOutput func(stream, line string)) (ipc.RPCResponse, error) {
 	// ensure request id
 	if req.ID == "" {
 		req.ID = generateRequestID()
 	}
+	req.Stream = onOutput != nil
+	command, err := resolveAgentCommand(sockPath, req.Command)
+	if err != nil {
+		return ipc.RPCResponse{}, err
+	}
+	req.Command = command
 
-	conn, err := net.DialTimeout("unix", sockPath, 2*time.Second)
+	bestEffort := req.Command == "Shutdown" || req.Command == "Cancel" || req.Command == "Notify" || req.Progress != nil
+	conn, err := dialAgent(ctx, logger, sockPath, !bestEffort)
 	if err != nil {
-		return ipc.RPCResponse{}, fmt.Errorf("failed to connect agent: %w", err)
+		return ipc.RPCResponse{}, retry.Tag(retry.CategoryIPC, fmt.Errorf("failed to connect agent: %w", err))
 	}
 	defer conn.Close()
-
 	_ = conn.SetDeadline(time.Now().Add(callTimeout))
+	if !bestEffort {
+		// A shutdown signal abandons the request the way a timeout does
+		stopWatch := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+		defer stopWatch()
+	}
+
+	var resp ipc.RPCResponse
+	if callTimeout > agentHeartbeatInterval {
+		var lost atomic.Bool
+		stop := make(chan struct{})
+		defer close(stop)
+		go heartbeat(logger, sockPath, conn, &lost, stop)
+		resp, err = exchange(logger, sockPath, conn, req, body, onOutput)
+		if err != nil && lost.Load() {
+			return ipc.RPCResponse{}, retry.Tag(retry.CategoryIPC, fmt.Errorf("agent stopped responding to heartbeats"))
+		}
+	} else {
+		resp, err = exchange(logger, sockPath, conn, req, body, onOutput)
+	}
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) && req.Command == "RunUserScript" && !req.DoNotWait {
+		reason := fmt.Sprintf("timed out after %v", callTimeout)
+		if ctx.Err() != nil {
+			reason = context.Cause(ctx).Error()
+		}
+		logger.Error("Userscript request %s %s; cancelling it on the agent", req.ID, reason)
+		output, cerr := cancelAgentRequest(ctx, logger, sockPath, req.ID)
+		if cerr != nil {
+			logger.Info("⚠️  Failed to cancel userscript on agent: %v", cerr)
+			return ipc.RPCResponse{}, fmt.Errorf("agent request %s: %w", reason, err)
+		}
+		return ipc.RPCResponse{ID: req.ID, Cancelled: true, Output: output}, fmt.Errorf("agent request %s; userscript cancelled", reason)
+	}
+	return resp, retry.Tag(retry.CategoryIPC, err)
+}
+
+// transferFileToAgent sends the file at src to the agent, which writes it to
+// target as its user, with the mode and extended attributes of opts.
+func transferFileToAgent(ctx context.Context, logger utils.Logger, sockPath, src, target string, opts installer.FileOptions, dirMode os.FileMode, callTimeout time.Duration) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", src, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind %s: %w", src, err)
+	}
+
+	req := ipc.RPCRequest{
+		Command: "TransferFile", Path: target, Mode: uint32(opts.Mode.Perm()), DirMode: uint32(dirMode.Perm()),
+		StripQuarantine: opts.StripQuarantine, XAttrs: opts.XAttrs, Size: size, SHA256: hex.EncodeToString(h.Sum(nil)),
+	}
+	logger.Debug("Transferring %s (%d bytes) to agent as %s", src, size, target)
+	resp, err := callAgentWithBody(ctx, logger, sockPath, req, f, callTimeout, nil)
+	if err != nil {
+		return err
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+// agentSupports reports whether the agent at sockPath negotiated cmd.
+// Agents that have not been negotiated with are assumed current.
+func agentSupports(sockPath, cmd string) bool {
+	_, err := resolveAgentCommand(sockPath, cmd)
+	return err == nil
+}
+
+// cancelAgentRequest asks the agent to stop the userscript started by request
+// id and returns the output it produced before it was killed. Used when a
+// request times out or the run is being torn down.
+func cancelAgentRequest(ctx context.Context, logger utils.Logger, sockPath, id string) (string, error) {
+	resp, err := callAgent(ctx, logger, sockPath, ipc.RPCRequest{Command: "Cancel", Target: id}, agentCancelWait+5*time.Second)
+	if err != nil {
+		return "", err
+	}
+	if !resp.OK {
+		return "", errors.New(resp.Error)
+	}
+	return resp.Output, nil
+}
+
+// dialAgent connects to the agent socket. With wait set it retries with
+// backoff for up to agentReconnectTimeout while the agent is unavailable.
+// A relaunched agent may be a different binary, so the protocol is
+// negotiated again after reconnecting. The wait ends early once ctx is done.
+func dialAgent(ctx context.Context, logger utils.Logger, sockPath string, wait bool) (net.Conn, error) {
+	deadline := time.Now()
+	if wait {
+		deadline = deadline.Add(agentReconnectTimeout)
+	}
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		conn, err := net.DialTimeout("unix", sockPath, 2*time.Second)
+		if err == nil {
+			if attempt > 1 {
+				logger.Info("✅ Reconnected to agent after %d attempts", attempt)
+				if err := negotiateAgent(logger, sockPath); err != nil {
+					conn.Close()
+					return nil, err
+				}
+			}
+			return conn, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+		if wait && ctx.Err() != nil {
+			return nil, fmt.Errorf("%w (%v)", err, context.Cause(ctx))
+		}
+		if attempt == 1 {
+			logger.Info("⚠️  Agent unavailable, waiting up to %v for it to come back: %v", agentReconnectTimeout, err)
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > 15*time.Second {
+			backoff = 15 * time.Second
+		}
+	}
+}
+
+// exchange signs and sends req on conn, followed by body if set, and reads
+// the response, passing any streamed output to onOutput.
+func exchange(logger utils.Logger, sockPath string, conn net.Conn, req ipc.RPCRequest, body io.Reader, onOutput func(stream, line string)) (ipc.RPCResponse, error) {
+	// Sign once connected: the key is created next to the live socket
+	signer, err := agentSigner(sockPath)
+	if err != nil {
+		return ipc.RPCResponse{}, err
+	}
+	if err := signer.Sign(&req); err != nil {
+		return ipc.RPCResponse{}, err
+	}
 
 	enc := json.NewEncoder(conn)
 	dec := json.NewDecoder(bufio.NewReader(conn))
@@ -70,12 +391,70 @@ func callAgent(logger *utils.Logger, sockPath string, req ipc.RPCRequest, callTi
 	if err := enc.Encode(req); err != nil {
 		return ipc.RPCResponse{}, fmt.Errorf("encode error: %w", err)
 	}
-	var resp ipc.RPCResponse
-	if err := dec.Decode(&resp); err != nil {
-		return ipc.RPCResponse{}, fmt.Errorf("decode error: %w", err)
+	if body != nil {
+		if err := ipc.SendFile(enc, body); err != nil {
+			return ipc.RPCResponse{}, fmt.Errorf("failed to send file: %w", err)
+		}
+	}
+	for {
+		var resp ipc.RPCResponse
+		if err := dec.Decode(&resp); err != nil {
+			return ipc.RPCResponse{}, fmt.Errorf("decode error: %w", err)
+		}
+		if resp.ID != req.ID {
+			return ipc.RPCResponse{}, fmt.Errorf("mismatched response id")
+		}
+		if !resp.Partial {
+			return resp, nil
+		}
+		if onOutput != nil {
+			onOutput(resp.OutputStream, resp.Output)
+		}
+	}
+}
+
+// heartbeat pings the agent until stop is closed. After agentHeartbeatMisses
+// consecutive failures it marks the agent lost and closes conn, which
+// unblocks the pending request.
+func heartbeat(logger utils.Logger, sockPath string, conn net.Conn, lost *atomic.Bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(agentHeartbeatInterval)
+	defer ticker.Stop()
+	misses := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := pingAgent(logger, sockPath); err != nil {
+			misses++
+			logger.Info("⚠️  Agent heartbeat missed (%d/%d): %v", misses, agentHeartbeatMisses, err)
+			if misses >= agentHeartbeatMisses {
+				logger.Error("Agent stopped responding; abandoning the pending request")
+				lost.Store(true)
+				_ = conn.Close()
+				return
+			}
+			continue
+		}
+		misses = 0
+	}
+}
+
+// pingAgent sends a single Ping without waiting for a relaunch.
+func pingAgent(logger utils.Logger, sockPath string) error {
+	conn, err := net.DialTimeout("unix", sockPath, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	resp, err := exchange(logger, sockPath, conn, ipc.RPCRequest{ID: generateRequestID(), Command: "Ping"}, nil, nil)
+	if err != nil {
+		return err
 	}
-	if resp.ID != req.ID {
-		return ipc.RPCResponse{}, fmt.Errorf("mismatched response id")
+	if !resp.OK {
+		return fmt.Errorf("ping rejected: %s", resp.Error)
 	}
-	return resp, nil
+	return nil
 }
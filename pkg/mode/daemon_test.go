@@ -1,12 +1,18 @@
 package mode
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/installer"
+	"github.com/go-installapplications/pkg/retry"
 	"github.com/go-installapplications/pkg/utils"
 )
 
@@ -46,6 +52,25 @@ func TestGetBootstrap_FollowRedirects(t *testing.T) {
 	}
 }
 
+func TestExitCodeFor(t *testing.T) {
+	base := errors.New("boom")
+	for _, tc := range []struct {
+		err  error
+		want utils.ExitCode
+	}{
+		{fmt.Errorf("%w: %w", errBootstrapFetch, retry.Tag(retry.CategoryNetwork, base)), utils.ExitBootstrapFetch},
+		{fmt.Errorf("phase: %w", retry.Tag(retry.CategoryNetwork, base)), utils.ExitDownload},
+		{retry.Tag(retry.CategoryValidation, base), utils.ExitValidation},
+		{retry.Tag(retry.CategoryIPC, base), utils.ExitIPCTimeout},
+		{base, utils.ExitInstall},
+		{fmt.Errorf("userland phase stopped: %w", &utils.TimeoutError{What: "userland phase", Limit: time.Minute}), utils.ExitFailure},
+	} {
+		if got := exitCodeFor(tc.err); got != tc.want {
+			t.Errorf("exitCodeFor(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
 func TestGetBootstrap_SkipValidation(t *testing.T) {
 	// server returns invalid item type
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -73,3 +98,73 @@ func TestGetBootstrap_SkipValidation(t *testing.T) {
 		t.Fatalf("unexpected error with skip-validation: %v", err)
 	}
 }
+
+func TestAgentRouter_FollowsConsoleUserChanges(t *testing.T) {
+	cfg := config.NewConfig()
+	router := newAgentRouter(cfg, utils.NewLogger(false, false))
+	consoleUID := "501"
+	router.wait = func() (string, string, error) {
+		return consoleUID, "/tmp/agent-" + consoleUID + ".sock", nil
+	}
+	negotiated := 0
+	router.negotiate = func(string) error { negotiated++; return nil }
+
+	uid, sockPath, err := router.route()
+	if err != nil || uid != "501" || sockPath != "/tmp/agent-501.sock" {
+		t.Fatalf("first route = %q %q %v", uid, sockPath, err)
+	}
+	router.addBackground(sockPath)
+
+	// User switches accounts mid-bootstrap
+	consoleUID = "502"
+	uid, sockPath, err = router.route()
+	if err != nil || uid != "502" || sockPath != "/tmp/agent-502.sock" {
+		t.Fatalf("route after switch = %q %q %v", uid, sockPath, err)
+	}
+
+	// Switching back reuses the first agent rather than recording it twice
+	consoleUID = "501"
+	if _, _, err := router.route(); err != nil {
+		t.Fatal(err)
+	}
+	got := router.sockets()
+	if len(got) != 2 || got[0] != "/tmp/agent-501.sock" || got[1] != "/tmp/agent-502.sock" {
+		t.Fatalf("sockets = %v", got)
+	}
+	if negotiated != 2 {
+		t.Fatalf("expected one handshake per agent, got %d", negotiated)
+	}
+	if router.background["/tmp/agent-501.sock"] != 1 || router.background["/tmp/agent-502.sock"] != 0 {
+		t.Fatalf("background counts = %v", router.background)
+	}
+}
+
+func TestAgentRouter_NilRejectsUserItems(t *testing.T) {
+	var router *agentRouter
+	if _, _, err := router.route(); err == nil {
+		t.Fatalf("expected error routing without an agent")
+	}
+	router.shutdown()
+	if err := router.waitForBackground(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimitUserlandPhaseLeavesShutdownContext(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.PhaseTimeout = 50 * time.Millisecond
+	logger := utils.NewLoggerWithWriter(false, false, io.Discard)
+	parent := shutdownCtx
+	ctx, done := limitUserlandPhase(installer.NewSystemInstaller(false, logger, false), cfg, logger)
+	defer done()
+	if shutdownCtx != parent {
+		t.Fatal("limitUserlandPhase replaced shutdownCtx")
+	}
+	<-ctx.Done()
+	if err := userlandStopped(ctx, nil); err == nil {
+		t.Error("userland phase not stopped after PhaseTimeout")
+	}
+	if err := userlandStopped(shutdownCtx, nil); err != nil {
+		t.Errorf("run stopped with the phase: %v", err)
+	}
+}
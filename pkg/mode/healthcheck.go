@@ -0,0 +1,157 @@
+package mode
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/download"
+	"github.com/go-installapplications/pkg/ipc"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// minFreeBytes is the free space InstallPath needs for healthcheck to pass.
+const minFreeBytes = 1 << 30
+
+// healthCheck is the result of one healthcheck.
+type healthCheck struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// RunHealthcheck verifies that a run could start on this machine: the
+// profile can be read, the bootstrap is reachable, InstallPath has free
+// space and the socket directory is usable. It exits 1 if any check fails,
+// so it can back an MDM or monitoring script. Nothing is changed.
+func RunHealthcheck(cfg *config.Config, logger utils.Logger) {
+	checks := []healthCheck{
+		checkProfile(cfg),
+		checkBootstrap(cfg, logger),
+		checkDiskSpace(cfg.InstallPath, minFreeBytes),
+		checkSocketDir(ipc.SocketDir),
+	}
+	failed := 0
+	for _, c := range checks {
+		if c.OK {
+			logger.Info("✅ %s: %s", c.Name, c.Detail)
+		} else {
+			logger.Error("❌ %s: %s", c.Name, c.Detail)
+			failed++
+		}
+	}
+	if failed > 0 {
+		logger.Error("Healthcheck failed: %d of %d checks", failed, len(checks))
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "healthcheck failed")
+	}
+	logger.Info("Healthcheck passed")
+}
+
+// checkProfile reports whether the configuration profile, if installed, can
+// be read and applied. Running without a profile is valid.
+func checkProfile(cfg *config.Config) healthCheck {
+	c := healthCheck{Name: "Profile"}
+	found, err := config.CheckProfile(cfg.ProfileDomain, "daemon")
+	switch {
+	case err != nil:
+		c.Detail = fmt.Sprintf("%s: %v", cfg.ProfileDomain, err)
+	case !found:
+		c.OK, c.Detail = true, "no profile for "+cfg.ProfileDomain+", using flags and defaults"
+	default:
+		c.OK, c.Detail = true, cfg.ProfileDomain
+	}
+	return c
+}
+
+// checkBootstrap sends a HEAD request to JSONURL, or without one looks for
+// a bootstrap embedded in the profile. Servers that do not allow HEAD still
+// count as reachable.
+func checkBootstrap(cfg *config.Config, logger utils.Logger) healthCheck {
+	c := healthCheck{Name: "Bootstrap"}
+	if cfg.JSONURL == "" {
+		if _, err := cfg.LoadBootstrapFromProfile(cfg.ProfileDomain); err != nil {
+			c.Detail = fmt.Sprintf("no JSONURL and no bootstrap in the profile: %v", err)
+			return c
+		}
+		c.OK, c.Detail = true, "embedded in the profile"
+		return c
+	}
+
+	jsonURL, err := bootstrapURL(cfg)
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	var client *download.Client
+	if cfg.HTTPAuthUser != "" || len(cfg.HTTPHeaders) > 0 {
+		client = download.NewClientWithAuth(logger, cfg.HTTPAuthUser, cfg.HTTPAuthPassword, cfg.HTTPHeaders)
+	} else {
+		client = download.NewClient(logger)
+	}
+	client.SetFollowRedirects(cfg.FollowRedirects)
+	if err := client.UseDeviceIdentity(cfg); err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	code, err := client.Head(jsonURL)
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	c.Detail = fmt.Sprintf("HTTP %d", code)
+	c.OK = code < 400 || code == http.StatusMethodNotAllowed
+	return c
+}
+
+// checkDiskSpace reports whether the volume holding path, or its nearest
+// existing parent before the first run, has at least min bytes free.
+func checkDiskSpace(path string, min uint64) healthCheck {
+	c := healthCheck{Name: "Disk space"}
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		c.Detail = fmt.Sprintf("%s: %v", path, err)
+		return c
+	}
+	free := uint64(st.Bavail) * uint64(st.Bsize)
+	c.Detail = fmt.Sprintf("%d MB free on %s", free>>20, path)
+	c.OK = free >= min
+	return c
+}
+
+// checkSocketDir reports whether dir is safe for the agent sockets: owned
+// by root and writable by no one else. A missing directory is created on
+// the first run.
+func checkSocketDir(dir string) healthCheck {
+	c := healthCheck{Name: "Socket directory"}
+	info, err := os.Lstat(dir)
+	switch {
+	case os.IsNotExist(err):
+		c.OK, c.Detail = true, dir+" not present, created on first run"
+	case err != nil:
+		c.Detail = err.Error()
+	case !info.IsDir():
+		c.Detail = dir + " is not a directory"
+	case info.Mode().Perm()&0022 != 0:
+		c.Detail = fmt.Sprintf("%s has mode %o, want 755", dir, info.Mode().Perm())
+	default:
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != 0 && int(st.Uid) != os.Geteuid() {
+			c.Detail = fmt.Sprintf("%s is owned by uid %d, want root", dir, st.Uid)
+			break
+		}
+		c.OK, c.Detail = true, dir
+	}
+	return c
+}
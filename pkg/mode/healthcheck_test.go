@@ -0,0 +1,74 @@
+package mode
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+func TestCheckBootstrap_HeadStatus(t *testing.T) {
+	logger := utils.NewLogger(false, false)
+	for _, tc := range []struct {
+		code int
+		ok   bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusMethodNotAllowed, true},
+		{http.StatusNotFound, false},
+		{http.StatusInternalServerError, false},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.code)
+		}))
+		cfg := config.NewConfig()
+		cfg.JSONURL = srv.URL + "/bootstrap.json"
+		if got := checkBootstrap(cfg, logger); got.OK != tc.ok {
+			t.Errorf("HTTP %d: OK = %v, want %v (%s)", tc.code, got.OK, tc.ok, got.Detail)
+		}
+		srv.Close()
+	}
+}
+
+func TestCheckDiskSpace_WalksUpToExistingParent(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "not", "yet", "installed")
+	if got := checkDiskSpace(missing, 1); !got.OK {
+		t.Fatalf("expected free space: %s", got.Detail)
+	}
+	if got := checkDiskSpace(missing, ^uint64(0)); got.OK {
+		t.Fatalf("expected too little space: %s", got.Detail)
+	}
+}
+
+func TestCheckSocketDir(t *testing.T) {
+	root := t.TempDir()
+	if got := checkSocketDir(filepath.Join(root, "missing")); !got.OK {
+		t.Fatalf("missing dir should pass: %s", got.Detail)
+	}
+
+	dir := filepath.Join(root, "sockets")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := checkSocketDir(dir); !got.OK {
+		t.Fatalf("0755 dir should pass: %s", got.Detail)
+	}
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if got := checkSocketDir(dir); got.OK {
+		t.Fatalf("0777 dir should fail: %s", got.Detail)
+	}
+
+	file := filepath.Join(root, "file")
+	if err := os.WriteFile(file, nil, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if got := checkSocketDir(file); got.OK {
+		t.Fatalf("file should fail: %s", got.Detail)
+	}
+}
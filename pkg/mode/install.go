@@ -0,0 +1,286 @@
+package mode
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-installapplications/pkg/audit"
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+	"howett.net/plist"
+)
+
+// binaryName is the file name of the binary inside InstallPath.
+const binaryName = "go-installapplications"
+
+// launchdJob is the subset of launchd.plist(5) keys the daemon, the agent and
+// the remediation job use.
+type launchdJob struct {
+	Label             string          `plist:"Label"`
+	Program           string          `plist:"Program"`
+	ProgramArguments  []string        `plist:"ProgramArguments"`
+	RunAtLoad         bool            `plist:"RunAtLoad"`
+	KeepAlive         map[string]bool `plist:"KeepAlive,omitempty"`
+	ThrottleInterval  int             `plist:"ThrottleInterval,omitempty"`
+	StartInterval     int             `plist:"StartInterval,omitempty"`
+	StandardOutPath   string          `plist:"StandardOutPath"`
+	StandardErrorPath string          `plist:"StandardErrorPath"`
+	// Aqua keeps the agent out of the login window and SSH sessions
+	LimitLoadToSessionType string `plist:"LimitLoadToSessionType,omitempty"`
+}
+
+// RunInstall sets go-installapplications up from the binary that is running:
+// it copies the binary to InstallPath, writes the LaunchDaemon and LaunchAgent
+// plists and loads them, so a pkg only has to carry the binary and run
+// `go-installapplications --mode install` from its postinstall. Failures exit
+// without utils.Exit, whose cleanup would remove what was just installed.
+func RunInstall(cfg *config.Config, logger utils.Logger) {
+	logger.Info("Starting install mode")
+	if cfg.DryRun {
+		logger.Info("[DRY RUN] Nothing will be written or loaded")
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		logger.Error("❌ Cannot locate the running binary: %v", err)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "install failed")
+	}
+	if err := installBinary(self, installedBinaryPath(cfg), cfg.DryRun, logger); err != nil {
+		logger.Error("❌ Failed to install binary: %v", err)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "install failed")
+	}
+	ensureLogDirs(cfg, logger)
+
+	daemonPlist, agentPlist, err := writeLaunchdPlists(cfg, logger)
+	if err != nil {
+		logger.Error("❌ Failed to write launchd plists: %v", err)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "install failed")
+	}
+	if err := loadServices(cfg, daemonPlist, agentPlist, logger); err != nil {
+		logger.Error("❌ Failed to load LaunchDaemon: %v", err)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "install failed")
+	}
+	logger.Info("✅ go-installapplications installed to %s", cfg.InstallPath)
+}
+
+// RunPlists writes the LaunchDaemon and LaunchAgent plists for cfg to
+// PlistOutputDir without installing or loading anything, for orgs that ship
+// their own pkg. They are the plists install mode would write.
+func RunPlists(cfg *config.Config, logger utils.Logger) {
+	if _, _, err := writeLaunchdPlistsTo(cfg, cfg.PlistOutputDir, cfg.PlistOutputDir, logger); err != nil {
+		logger.Error("❌ Failed to write launchd plists: %v", err)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "plists not written")
+	}
+}
+
+func installedBinaryPath(cfg *config.Config) string {
+	return filepath.Join(cfg.InstallPath, binaryName)
+}
+
+// daemonJob describes the LaunchDaemon for cfg. Like the shipped plist it is
+// restarted until it exits cleanly.
+func daemonJob(cfg *config.Config) launchdJob {
+	return launchdJob{
+		Label:             cfg.LaunchDaemonIdentifier,
+		Program:           installedBinaryPath(cfg),
+		ProgramArguments:  launchdArgs(cfg, "daemon"),
+		RunAtLoad:         true,
+		KeepAlive:         map[string]bool{"SuccessfulExit": false},
+		ThrottleInterval:  30,
+		StandardOutPath:   cfg.DefaultDaemonLogPath,
+		StandardErrorPath: cfg.DefaultDaemonLogPath,
+	}
+}
+
+// agentJob describes the LaunchAgent for cfg.
+func agentJob(cfg *config.Config) launchdJob {
+	return launchdJob{
+		Label:             cfg.LaunchAgentIdentifier,
+		Program:           installedBinaryPath(cfg),
+		ProgramArguments:  launchdArgs(cfg, "agent"),
+		RunAtLoad:         true,
+		KeepAlive:         map[string]bool{"SuccessfulExit": false},
+		ThrottleInterval:  10,
+		StandardOutPath:   cfg.DefaultAgentLogPath,
+		StandardErrorPath: cfg.DefaultAgentLogPath,
+
+		LimitLoadToSessionType: "Aqua",
+	}
+}
+
+// launchdArgs returns ProgramArguments for mode. Settings that differ from
+// the defaults and that the daemon needs before it has read the profile are
+// passed as flags; everything else keeps coming from the profile.
+func launchdArgs(cfg *config.Config, mode string) []string {
+	defaults := config.NewConfig()
+	args := []string{installedBinaryPath(cfg), "--mode", mode}
+	if cfg.Compat {
+		// Both need it: the agent finds the daemon's socket in the compat folder
+		defaults.ApplyCompat()
+		args = append(args, "--compat")
+	}
+	if cfg.ProfileDomain != "" && cfg.ProfileDomain != defaults.ProfileDomain {
+		args = append(args, "--profile-domain", cfg.ProfileDomain)
+	}
+	if mode != "daemon" {
+		return args
+	}
+	if cfg.InstallPath != defaults.InstallPath {
+		args = append(args, "--installpath", cfg.InstallPath)
+	}
+	if cfg.LaunchDaemonIdentifier != defaults.LaunchDaemonIdentifier {
+		args = append(args, "--ldidentifier", cfg.LaunchDaemonIdentifier)
+	}
+	if cfg.LaunchAgentIdentifier != defaults.LaunchAgentIdentifier {
+		args = append(args, "--laidentifier", cfg.LaunchAgentIdentifier)
+	}
+	if cfg.JSONURL != "" {
+		args = append(args, "--jsonurl", cfg.JSONURL)
+	}
+	return args
+}
+
+// marshalLaunchdJob encodes job as an XML plist.
+func marshalLaunchdJob(job launchdJob) ([]byte, error) {
+	return plist.MarshalIndent(job, plist.XMLFormat, "\t")
+}
+
+// installBinary copies src to dest (mode 0755) unless it is already the
+// installed binary. The copy is written next to dest and renamed over it, so
+// a running daemon keeps its old executable.
+func installBinary(src, dest string, dryRun bool, logger utils.Logger) error {
+	if resolved, err := filepath.EvalSymlinks(src); err == nil {
+		src = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(dest); err == nil && resolved == src {
+		logger.Info("Binary already installed at %s", dest)
+		return nil
+	}
+	if dryRun {
+		logger.Info("[DRY RUN] Would copy %s to %s", src, dest)
+		return nil
+	}
+	err := copyExecutable(src, dest)
+	audit.Record(audit.Event{Action: audit.ActionFilePlace, Target: dest, Outcome: audit.Outcome(err), Error: audit.ErrorString(err), Details: map[string]string{"source": src, "reason": "install"}})
+	if err != nil {
+		return err
+	}
+	logger.Info("✅ Installed binary to %s", dest)
+	return nil
+}
+
+func copyExecutable(src, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+binaryName+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// ensureLogDirs creates the launchd log directories. The agent runs as the
+// user, so its directory is world-writable with the sticky bit, as the
+// postinstall script sets it up.
+func ensureLogDirs(cfg *config.Config, logger utils.Logger) {
+	if cfg.DryRun {
+		return
+	}
+	for _, path := range []string{cfg.DefaultDaemonLogPath, cfg.DefaultAgentLogPath} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			logger.Info("⚠️  Could not create log directory for %s: %v", path, err)
+		}
+	}
+	_ = os.Chmod(filepath.Dir(cfg.DefaultAgentLogPath), 0o1777)
+}
+
+// writeLaunchdPlists writes the LaunchDaemon and LaunchAgent plists and
+// returns their paths.
+func writeLaunchdPlists(cfg *config.Config, logger utils.Logger) (string, string, error) {
+	return writeLaunchdPlistsTo(cfg, launchDaemonsDir, launchAgentsDir, logger)
+}
+
+func writeLaunchdPlistsTo(cfg *config.Config, daemonDir, agentDir string, logger utils.Logger) (string, string, error) {
+	daemonPlist := filepath.Join(daemonDir, cfg.LaunchDaemonIdentifier+".plist")
+	agentPlist := filepath.Join(agentDir, cfg.LaunchAgentIdentifier+".plist")
+	for _, p := range []struct {
+		path string
+		job  launchdJob
+	}{{daemonPlist, daemonJob(cfg)}, {agentPlist, agentJob(cfg)}} {
+		path, job := p.path, p.job
+		data, err := marshalLaunchdJob(job)
+		if err != nil {
+			return "", "", fmt.Errorf("encode %s: %w", job.Label, err)
+		}
+		if cfg.DryRun {
+			logger.Info("[DRY RUN] Would write %s", path)
+			logger.Debug("%s", data)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return "", "", err
+		}
+		err = os.WriteFile(path, data, 0644)
+		audit.Record(audit.Event{Action: audit.ActionFilePlace, Target: path, Outcome: audit.Outcome(err), Error: audit.ErrorString(err), Details: map[string]string{"reason": "install"}})
+		if err != nil {
+			return "", "", err
+		}
+		// WriteFile keeps the mode of an existing file; launchd refuses
+		// group- or world-writable plists
+		if err := os.Chmod(path, 0644); err != nil {
+			return "", "", err
+		}
+		logger.Info("✅ Wrote %s", path)
+	}
+	return daemonPlist, agentPlist, nil
+}
+
+// loadServices (re)loads the daemon and the agent in every GUI session. At
+// the login window there is no session; launchd starts the agent at login.
+// Only a daemon that fails to load is an error.
+func loadServices(cfg *config.Config, daemonPlist, agentPlist string, logger utils.Logger) error {
+	uids, err := utils.GetGUISessionUIDs()
+	if err != nil {
+		logger.Debug("Could not list GUI sessions: %v", err)
+	}
+	if cfg.DryRun {
+		logger.Info("[DRY RUN] Would load %s and the LaunchAgent in %d GUI sessions", daemonPlist, len(uids))
+		return nil
+	}
+
+	_ = utils.Bootout("system", daemonPlist)
+	if err := utils.Bootstrap("system", daemonPlist); err != nil {
+		return err
+	}
+	logger.Info("✅ Loaded LaunchDaemon %s", cfg.LaunchDaemonIdentifier)
+
+	for _, uid := range uids {
+		domain := "gui/" + uid
+		_ = utils.Bootout(domain, agentPlist)
+		if err := utils.Bootstrap(domain, agentPlist); err != nil {
+			logger.Info("⚠️  Could not load LaunchAgent for UID %s: %v", uid, err)
+			continue
+		}
+		logger.Info("✅ Loaded LaunchAgent for UID %s", uid)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package mode
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+	"howett.net/plist"
+)
+
+func TestLaunchdArgs_PassesNonDefaultSettings(t *testing.T) {
+	cfg := config.NewConfig()
+	if got, want := launchdArgs(cfg, "daemon"), []string{"/Library/go-installapplications/go-installapplications", "--mode", "daemon"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("default daemon args = %v, want %v", got, want)
+	}
+
+	cfg.InstallPath = "/Library/installapplications"
+	cfg.ProfileDomain = "com.example.ia"
+	cfg.LaunchDaemonIdentifier = "com.example.ia.daemon"
+	cfg.JSONURL = "https://example.com/bootstrap.json"
+	want := []string{"/Library/installapplications/go-installapplications", "--mode", "daemon",
+		"--profile-domain", "com.example.ia",
+		"--installpath", "/Library/installapplications",
+		"--ldidentifier", "com.example.ia.daemon",
+		"--jsonurl", "https://example.com/bootstrap.json"}
+	if got := launchdArgs(cfg, "daemon"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("daemon args = %v, want %v", got, want)
+	}
+	want = []string{"/Library/installapplications/go-installapplications", "--mode", "agent", "--profile-domain", "com.example.ia"}
+	if got := launchdArgs(cfg, "agent"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("agent args = %v, want %v", got, want)
+	}
+}
+
+func TestWriteLaunchdPlists_WritesJobs(t *testing.T) {
+	root := t.TempDir()
+	prevDaemons, prevAgents := launchDaemonsDir, launchAgentsDir
+	t.Cleanup(func() { launchDaemonsDir, launchAgentsDir = prevDaemons, prevAgents })
+	launchDaemonsDir = filepath.Join(root, "LaunchDaemons")
+	launchAgentsDir = filepath.Join(root, "LaunchAgents")
+
+	cfg := config.NewConfig()
+	cfg.DefaultAgentLogPath = "/tmp/agent.log"
+	daemonPlist, agentPlist, err := writeLaunchdPlists(cfg, utils.NewLogger(false, false))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for path, want := range map[string]launchdJob{daemonPlist: daemonJob(cfg), agentPlist: agentJob(cfg)} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		var got launchdJob
+		if _, err := plist.Unmarshal(data, &got); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %+v, want %+v", path, got, want)
+		}
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0644 {
+			t.Errorf("%s mode = %v", path, info.Mode())
+		}
+	}
+	if job := agentJob(cfg); job.StandardOutPath != "/tmp/agent.log" || job.Label != cfg.LaunchAgentIdentifier {
+		t.Errorf("agent job = %+v", job)
+	}
+}
+
+func TestInstallBinary_CopiesAndSkipsSelf(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(root, "pkg", "go-installapplications")
+	dest := filepath.Join(root, "install", "go-installapplications")
+	if err := os.MkdirAll(filepath.Dir(src), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(src, []byte("binary"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	logger := utils.NewLogger(false, false)
+
+	if err := installBinary(src, dest, false, logger); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	info, err := os.Stat(dest)
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Fatalf("dest = %v, %v", info, err)
+	}
+	// Running the installed binary again leaves it alone
+	if err := installBinary(dest, dest, false, logger); err != nil {
+		t.Fatalf("reinstall self: %v", err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "binary" {
+		t.Fatalf("dest content = %q", data)
+	}
+}
+
+func TestPayloadPlistsMatchGenerated(t *testing.T) {
+	cfg := config.NewConfig()
+	for path, want := range map[string]launchdJob{
+		"../../payload/Library/LaunchDaemons/" + cfg.LaunchDaemonIdentifier + ".plist": daemonJob(cfg),
+		"../../payload/Library/LaunchAgents/" + cfg.LaunchAgentIdentifier + ".plist":   agentJob(cfg),
+	} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		var got launchdJob
+		if _, err := plist.Unmarshal(data, &got); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s drifted from the generated plist:\n got %+v\nwant %+v", path, got, want)
+		}
+	}
+}
+
+func TestRunPlists_WritesToOutputDir(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.PlistOutputDir = t.TempDir()
+	cfg.LaunchAgentIdentifier = "com.example.agent"
+	RunPlists(cfg, utils.NewLogger(false, false))
+
+	data, err := os.ReadFile(filepath.Join(cfg.PlistOutputDir, "com.example.agent.plist"))
+	if err != nil {
+		t.Fatalf("agent plist: %v", err)
+	}
+	var job launchdJob
+	if _, err := plist.Unmarshal(data, &job); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if job.Label != "com.example.agent" || job.LimitLoadToSessionType != "Aqua" || !job.RunAtLoad {
+		t.Fatalf("agent job = %+v", job)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.PlistOutputDir, cfg.LaunchDaemonIdentifier+".plist")); err != nil {
+		t.Fatalf("daemon plist: %v", err)
+	}
+}
+
+func TestLaunchdArgs_Compat(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.ApplyCompat()
+	want := []string{"/Library/installapplications/go-installapplications", "--mode", "daemon", "--compat"}
+	if got := launchdArgs(cfg, "daemon"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("daemon args = %v, want %v", got, want)
+	}
+	want = []string{"/Library/installapplications/go-installapplications", "--mode", "agent", "--compat"}
+	if got := launchdArgs(cfg, "agent"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("agent args = %v, want %v", got, want)
+	}
+	if daemonJob(cfg).Label != config.CompatIdentifier || agentJob(cfg).Label != config.CompatIdentifier {
+		t.Fatalf("labels = %s, %s", daemonJob(cfg).Label, agentJob(cfg).Label)
+	}
+}
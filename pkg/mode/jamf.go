@@ -0,0 +1,31 @@
+package mode
+
+import (
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/jamf"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// updateJamf runs the configured Jamf inventory update and policy triggers
+// after a successful run. Failures are logged only: bootstrap itself
+// succeeded and Jamf catches up at the next check-in.
+func updateJamf(cfg *config.Config, logger utils.Logger) {
+	opts := jamf.Options{
+		Recon:        cfg.JamfRecon,
+		PolicyEvents: cfg.JamfPolicyEvents,
+		BinaryPath:   cfg.JamfBinaryPath,
+		URL:          cfg.JamfURL,
+		APIToken:     cfg.JamfAPIToken,
+	}
+	if !opts.Enabled() {
+		return
+	}
+	if cfg.DryRun {
+		logger.Info("[DRY RUN] Would update Jamf (recon: %t, policy events: %v)", opts.Recon, opts.PolicyEvents)
+		return
+	}
+	logger.Info("📋 Updating Jamf Pro")
+	if err := jamf.Update(opts, logger); err != nil {
+		logger.Info("⚠️  Jamf update incomplete: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package mode
+
+import (
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/metrics"
+	"github.com/go-installapplications/pkg/progress"
+	"github.com/go-installapplications/pkg/rollback"
+	"github.com/go-installapplications/pkg/status"
+	"github.com/go-installapplications/pkg/tracing"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// newMetricsExporter returns an Exporter when a metrics endpoint or push URL
+// is configured, nil otherwise. The endpoint is started right away so it
+// covers the whole run.
+func newMetricsExporter(cfg *config.Config, logger utils.Logger) *metrics.Exporter {
+	if cfg.MetricsListenAddress == "" && cfg.MetricsPushURL == "" {
+		return nil
+	}
+	exporter := metrics.NewExporter(metrics.ExportOptions{
+		ListenAddress: cfg.MetricsListenAddress,
+		Linger:        cfg.MetricsLinger,
+		PushURL:       cfg.MetricsPushURL,
+		PushFormat:    cfg.MetricsPushFormat,
+		Job:           cfg.MetricsJob,
+	}, logger)
+	if err := exporter.Serve(); err != nil {
+		logger.Info("⚠️  Metrics endpoint disabled: %v", err)
+	}
+	return exporter
+}
+
+// newTracer returns a Tracer when an OTLP traces endpoint is configured,
+// nil otherwise (a nil Tracer records nothing).
+func newTracer(cfg *config.Config, logger utils.Logger) *tracing.Tracer {
+	if cfg.TracingEndpoint == "" {
+		return nil
+	}
+	tracer := tracing.NewTracer(tracing.Options{
+		Endpoint:    cfg.TracingEndpoint,
+		ServiceName: cfg.TracingServiceName,
+	}, logger)
+	logger.Debug("Tracing enabled (trace ID %s)", tracer.TraceID())
+	return tracer
+}
+
+// instrumentedDownloader is a downloader that reports to the run's metrics
+// and tracer: *download.Client, or the simulator in simulate mode.
+type instrumentedDownloader interface {
+	SetMetrics(m *metrics.Recorder)
+	SetTracer(t *tracing.Tracer)
+}
+
+// newRunReporter combines the progress UI, metrics, tracing, status
+// reporting, the status plist and the rollback journal into the reporter the
+// phases report to, and
+// hooks the downloader up to the same metrics and tracer. The tracer is
+// returned so callers can open phase and install spans.
+func newRunReporter(cfg *config.Config, downloader instrumentedDownloader, logger utils.Logger) (progress.Reporter, *tracing.Tracer) {
+	reporters := progress.Multi{newProgressReporter(cfg, logger)}
+	if exporter := newMetricsExporter(cfg, logger); exporter != nil {
+		downloader.SetMetrics(exporter.Recorder)
+		reporters = append(reporters, exporter)
+	}
+	tracer := newTracer(cfg, logger)
+	if tracer != nil {
+		downloader.SetTracer(tracer)
+		reporters = append(reporters, tracer)
+	}
+	// Share the trace ID so server-side reports link to the run's trace
+	runID := tracer.TraceID()
+	if runID == "" {
+		runID = status.NewRunID()
+	}
+	if cfg.StatusURL != "" {
+		reporters = append(reporters, status.NewReporter(status.Options{
+			URL:     cfg.StatusURL,
+			Headers: cfg.StatusHeaders,
+			RunID:   runID,
+			Mode:    cfg.Mode,
+		}, logger))
+	}
+	if cfg.StatusPlistPath != "" {
+		writer := status.NewPlistWriter(cfg.StatusPlistPath, cfg.Mode, runID, cfg.DryRun, logger)
+		// Cleanup runs after the reporters finish
+		utils.OnCleanup(writer.Cleanup)
+		reporters = append(reporters, writer)
+	}
+	// Last, so a failed run is reported before it is rolled back
+	if cfg.RollbackOnFailure {
+		reporters = append(reporters, rollback.NewJournal(cfg, logger))
+	}
+	if len(reporters) == 1 {
+		return reporters[0], tracer
+	}
+	return reporters, tracer
+}
@@ -0,0 +1,202 @@
+package mode
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// runPlan is what a dry run prints before it walks the bootstrap. The JSON
+// field names are part of the --dry-run --json output and are only ever
+// added to.
+type runPlan struct {
+	Mode          string     `json:"mode"`
+	Items         []planItem `json:"items"`
+	DownloadBytes int64      `json:"download_bytes"` // known sizes of the items that will run
+	UnknownSizes  int        `json:"unknown_sizes"`  // items to download whose size the server did not give
+}
+
+type planItem struct {
+	Phase         string `json:"phase"`
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	RunsAs        string `json:"runs_as"` // root or user
+	Action        string `json:"action"`  // run or skip
+	Reason        string `json:"reason,omitempty"`
+	File          string `json:"file"`
+	Size          int64  `json:"size"` // bytes to download; -1 when unknown, 0 when nothing is downloaded
+	ParallelGroup string `json:"parallel_group,omitempty"`
+	ParallelSafe  bool   `json:"parallel_safe,omitempty"`
+	Group         string `json:"group,omitempty"`
+	DoNotWait     bool   `json:"donotwait,omitempty"`
+}
+
+// packageSatisfied is utils.CheckPackageReceipt; swapped out in tests.
+var packageSatisfied = utils.CheckPackageReceipt
+
+// reviewPlan prints the plan for bootstrap at the start of a dry run. With
+// --json the plan is printed as JSON on stdout and the run ends there, so
+// the output can be parsed.
+func reviewPlan(bootstrap *config.Bootstrap, sizeOf func(url string) (int64, error), cfg *config.Config, logger utils.Logger) {
+	plan := buildPlan(bootstrap, sizeOf, cfg, logger)
+	if cfg.StatusJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plan); err != nil {
+			logger.Error("Failed to print plan: %v", err)
+			utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "")
+		}
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitSuccess, "")
+	}
+	var b strings.Builder
+	_ = writePlan(&b, plan)
+	logger.Info("📝 Execution plan")
+	for _, line := range strings.Split(strings.TrimRight(b.String(), "\n"), "\n") {
+		logger.Info("%s", line)
+	}
+}
+
+// buildPlan resolves, in run order, which items will run and which will be
+// skipped and why, and how much each one downloads.
+func buildPlan(bootstrap *config.Bootstrap, sizeOf func(url string) (int64, error), cfg *config.Config, logger utils.Logger) runPlan {
+	plan := runPlan{Mode: cfg.Mode, Items: []planItem{}}
+	for _, phase := range []planStep{
+		{"preflight", bootstrap.Preflight},
+		{"setupassistant", bootstrap.SetupAssistant},
+		{"userland", bootstrap.Userland},
+	} {
+		// Hooks only run around a phase that has items
+		if len(phase.items) == 0 {
+			continue
+		}
+		for _, step := range []planStep{
+			{"pre_" + phase.name, bootstrap.Hooks.Pre(phase.name)},
+			phase,
+			{"post_" + phase.name, bootstrap.Hooks.Post(phase.name)},
+		} {
+			plan.addItems(step, phase.name, sizeOf, cfg, logger)
+		}
+	}
+	return plan
+}
+
+// planStep is a phase or one of its hook lists, named as the run logs it.
+type planStep struct {
+	name  string
+	items []config.Item
+}
+
+// addItems adds the items of step, which runs as part of phase, to plan.
+func (plan *runPlan) addItems(step planStep, phase string, sizeOf func(url string) (int64, error), cfg *config.Config, logger utils.Logger) {
+	for _, item := range step.items {
+		p := planItem{
+			Phase:         step.name,
+			Name:          item.Name,
+			Type:          item.Type,
+			RunsAs:        "root",
+			Action:        "run",
+			File:          item.File,
+			ParallelGroup: item.ParallelGroup,
+			ParallelSafe:  item.ParallelSafe && item.ParallelGroup == "",
+			Group:         item.Group,
+			DoNotWait:     item.DoNotWait,
+		}
+		if item.Type == "userscript" || item.Type == "userfile" {
+			p.RunsAs = "user"
+		}
+		p.Reason = planSkipReason(item, phase, cfg, logger)
+		if p.Reason != "" {
+			p.Action = "skip"
+		} else if item.URL != "" {
+			size, err := sizeOf(item.URL)
+			if err != nil {
+				logger.Debug("Could not get the size of %s: %v", item.Name, err)
+				size = -1
+			}
+			p.Size = size
+			if size < 0 {
+				plan.UnknownSizes++
+			} else {
+				plan.DownloadBytes += size
+			}
+		}
+		plan.Items = append(plan.Items, p)
+	}
+}
+
+// planSkipReason returns why item will not run, or "" if it will.
+func planSkipReason(item config.Item, phase string, cfg *config.Config, logger utils.Logger) string {
+	switch item.Type {
+	case "package", "rootscript", "userscript", "rootfile", "userfile", "munki", "launchd", "vppapp":
+	default:
+		return "unknown item type"
+	}
+	if phase == "preflight" && cfg.Mode == "standalone" && !cfg.WithPreflight {
+		return "preflight needs --with-preflight in standalone mode"
+	}
+	if utils.ShouldSkipItem(item.SkipIf, logger) {
+		return "skip_if " + item.SkipIf
+	}
+	if item.Type == "package" && !item.PkgRequired && item.PackageID != "" {
+		if ok, err := packageSatisfied(item.PackageID, item.Version, logger); err == nil && ok {
+			return "already installed (" + item.PackageID + ")"
+		}
+	}
+	if item.Type == "vppapp" && item.File != "" {
+		if _, err := os.Stat(item.File); err == nil {
+			return "already installed"
+		}
+	}
+	return ""
+}
+
+// writePlan prints plan as a table.
+func writePlan(w io.Writer, plan runPlan) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tPHASE\tNAME\tTYPE\tAS\tACTION\tSIZE\tTARGET")
+	for i, item := range plan.Items {
+		action := item.Action
+		if item.Reason != "" {
+			action += " (" + item.Reason + ")"
+		}
+		if item.Group != "" {
+			action += " [group " + item.Group + "]"
+		}
+		if item.ParallelGroup != "" {
+			action += " [parallel " + item.ParallelGroup + "]"
+		} else if item.ParallelSafe {
+			action += " [parallel_safe]"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", i+1, item.Phase, item.Name, item.Type, item.RunsAs, action, planSize(item.Size), item.File)
+	}
+	total := planSize(plan.DownloadBytes)
+	if plan.UnknownSizes > 0 {
+		total += fmt.Sprintf(" + %d of unknown size", plan.UnknownSizes)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "Total download: %s\n", total)
+	return err
+}
+
+// planSize formats a byte count for the plan table.
+func planSize(n int64) string {
+	switch {
+	case n < 0:
+		return "?"
+	case n == 0:
+		return "-"
+	case n < 1<<20:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	case n < 1<<30:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+}
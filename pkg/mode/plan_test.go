@@ -0,0 +1,82 @@
+package mode
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+func TestBuildPlan_ResolvesSkipsAndSizes(t *testing.T) {
+	prev := packageSatisfied
+	t.Cleanup(func() { packageSatisfied = prev })
+	packageSatisfied = func(id, version string, logger utils.Logger) (bool, error) {
+		return id == "com.example.installed", nil
+	}
+	sizes := map[string]int64{"https://example.com/app.pkg": 3 << 20, "https://example.com/dock.sh": 2048}
+	sizeOf := func(url string) (int64, error) {
+		if n, ok := sizes[url]; ok {
+			return n, nil
+		}
+		return -1, errors.New("HTTP 404")
+	}
+
+	bootstrap := &config.Bootstrap{
+		Preflight: []config.Item{{Name: "check", Type: "rootscript", File: "/tmp/check.sh", URL: "https://example.com/check.sh"}},
+		SetupAssistant: []config.Item{
+			{Name: "App", Type: "package", File: "/tmp/app.pkg", URL: "https://example.com/app.pkg", PackageID: "com.example.app"},
+			{Name: "Agent", Type: "package", File: "/tmp/agent.pkg", URL: "https://example.com/agent.pkg", PackageID: "com.example.installed"},
+		},
+		Userland: []config.Item{
+			{Name: "Dock", Type: "userscript", File: "/tmp/dock.sh", URL: "https://example.com/dock.sh", ParallelGroup: "ui"},
+			{Name: "Wallpaper", Type: "userfile", File: "/tmp/wall.jpg", URL: "https://example.com/wall.jpg"},
+			{Name: "Odd", Type: "profile", File: "/tmp/odd"},
+		},
+	}
+	cfg := config.NewConfig()
+	cfg.Mode = "standalone"
+	plan := buildPlan(bootstrap, sizeOf, cfg, utils.NewLogger(false, false))
+
+	want := []struct{ name, action, runsAs string }{
+		{"check", "skip", "root"},
+		{"App", "run", "root"},
+		{"Agent", "skip", "root"},
+		{"Dock", "run", "user"},
+		{"Wallpaper", "run", "user"},
+		{"Odd", "skip", "root"},
+	}
+	if len(plan.Items) != len(want) {
+		t.Fatalf("items = %+v", plan.Items)
+	}
+	for i, w := range want {
+		got := plan.Items[i]
+		if got.Name != w.name || got.Action != w.action || got.RunsAs != w.runsAs {
+			t.Errorf("item %d = %+v, want %+v", i, got, w)
+		}
+	}
+	if plan.DownloadBytes != 3<<20+2048 || plan.UnknownSizes != 1 {
+		t.Fatalf("download bytes = %d, unknown = %d", plan.DownloadBytes, plan.UnknownSizes)
+	}
+	if plan.Items[2].Reason != "already installed (com.example.installed)" || plan.Items[2].Size != 0 {
+		t.Fatalf("installed package = %+v", plan.Items[2])
+	}
+
+	var b bytes.Buffer
+	if err := writePlan(&b, plan); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, s := range []string{"PHASE", "3.0 MB", "run [parallel ui]", "skip (unknown item type)", "Total download: 3.0 MB + 1 of unknown size"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("table missing %q:\n%s", s, out)
+		}
+	}
+
+	cfg.WithPreflight = true
+	if plan := buildPlan(bootstrap, sizeOf, cfg, utils.NewLogger(false, false)); plan.Items[0].Action != "run" {
+		t.Fatalf("preflight with --with-preflight = %+v", plan.Items[0])
+	}
+}
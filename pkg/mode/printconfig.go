@@ -0,0 +1,127 @@
+package mode
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// ConfigLayer is the redacted configuration (see
+// config.Config.RedactedForLogging) after one source was applied:
+// "default", "profile", "env" or "flag".
+type ConfigLayer struct {
+	Source   string
+	Settings map[string]interface{}
+}
+
+// configReport is what --print-config prints. The JSON field names are part
+// of the --json output and are only ever added to.
+type configReport struct {
+	Mode            string        `json:"mode"`
+	ProfileDomain   string        `json:"profile_domain"`
+	BootstrapSource string        `json:"bootstrap_source"`
+	Settings        []configValue `json:"settings"`
+	Problems        []string      `json:"problems"`
+}
+
+type configValue struct {
+	Key    string      `json:"key"`
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// runModes read a bootstrap.
+var runModes = map[string]bool{"daemon": true, "standalone": true, "simulate": true, "agent-standalone": true}
+
+// PrintConfig prints the effective configuration, the last of layers, with
+// the source each value came from, then the problems: those found while
+// reading it, those ValidateSettings finds and a missing bootstrap. It exits
+// with ExitConfig when there are problems, and 0 otherwise. Nothing is run.
+func PrintConfig(cfg *config.Config, layers []ConfigLayer, bootstrapSource string, readProblems []string, logger utils.Logger) {
+	report := buildConfigReport(cfg, layers, bootstrapSource, readProblems)
+	var err error
+	if cfg.StatusJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = writeConfigReport(os.Stdout, report)
+	}
+	if err != nil {
+		logger.Error("Failed to print the configuration: %v", err)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "")
+	}
+	if len(report.Problems) > 0 {
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitConfig, "")
+	}
+	os.Exit(int(utils.ExitSuccess))
+}
+
+// buildConfigReport attributes each setting to the last layer that changed
+// it and collects the problems of cfg.
+func buildConfigReport(cfg *config.Config, layers []ConfigLayer, bootstrapSource string, readProblems []string) configReport {
+	report := configReport{
+		Mode:            cfg.Mode,
+		ProfileDomain:   cfg.ProfileDomain,
+		BootstrapSource: bootstrapSource,
+		Settings:        []configValue{},
+		Problems:        append([]string{}, readProblems...),
+	}
+	final := layers[len(layers)-1].Settings
+	keys := make([]string, 0, len(final))
+	for key := range final {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		source := layers[0].Source
+		for i := 1; i < len(layers); i++ {
+			if !reflect.DeepEqual(layers[i].Settings[key], layers[i-1].Settings[key]) {
+				source = layers[i].Source
+			}
+		}
+		report.Settings = append(report.Settings, configValue{Key: key, Value: final[key], Source: source})
+	}
+
+	if err := cfg.ValidateSettings(); err != nil {
+		report.Problems = append(report.Problems, strings.Split(err.Error(), "\n")...)
+	}
+	if runModes[cfg.Mode] {
+		if cfg.JSONURL == "" && bootstrapSource != "embedded" {
+			report.Problems = append(report.Problems, "no bootstrap: set JSONURL or embed a bootstrap in the profile")
+		}
+	}
+	return report
+}
+
+func writeConfigReport(w io.Writer, report configReport) error {
+	bw := bufio.NewWriter(w)
+	p := func(format string, args ...interface{}) { fmt.Fprintf(bw, format+"\n", args...) }
+
+	p("Effective configuration (mode %s, profile %s, bootstrap %s)", report.Mode, report.ProfileDomain, report.BootstrapSource)
+	width := 0
+	for _, v := range report.Settings {
+		width = max(width, len(v.Key))
+	}
+	for _, v := range report.Settings {
+		p("  %-*s  %-7s  %v", width, v.Key, v.Source, v.Value)
+	}
+	p("")
+	if len(report.Problems) == 0 {
+		p("No problems found")
+	} else {
+		p("Problems")
+		for _, problem := range report.Problems {
+			p("  %s", problem)
+		}
+	}
+	return bw.Flush()
+}
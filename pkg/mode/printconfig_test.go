@@ -0,0 +1,44 @@
+package mode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+)
+
+func TestBuildConfigReport(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Mode = "daemon"
+	layers := []ConfigLayer{{Source: "default", Settings: cfg.RedactedForLogging()}}
+	cfg.MaxRetries = 5
+	cfg.HTTPAuthPassword = "secret"
+	layers = append(layers, ConfigLayer{Source: "profile", Settings: cfg.RedactedForLogging()})
+	cfg.Debug = true
+	layers = append(layers, ConfigLayer{Source: "env", Settings: cfg.RedactedForLogging()})
+	cfg.MaxRetries = 7
+	layers = append(layers, ConfigLayer{Source: "flag", Settings: cfg.RedactedForLogging()})
+
+	report := buildConfigReport(cfg, layers, "none", []string{"GIA_BOGUS is not a known setting"})
+	want := map[string]string{"MaxRetries": "flag", "Debug": "env", "HTTPAuthPassword": "profile", "RetryDelay": "default"}
+	for _, v := range report.Settings {
+		if source, ok := want[v.Key]; ok && v.Source != source {
+			t.Errorf("%s: source = %s, want %s", v.Key, v.Source, source)
+		}
+		if v.Key == "HTTPAuthPassword" && v.Value == "secret" {
+			t.Error("HTTPAuthPassword is not redacted")
+		}
+	}
+	if len(report.Problems) != 2 || !strings.Contains(report.Problems[1], "no bootstrap") {
+		t.Errorf("problems = %q", report.Problems)
+	}
+
+	var out bytes.Buffer
+	if err := writeConfigReport(&out, report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "MaxRetries") || !strings.Contains(out.String(), "GIA_BOGUS") {
+		t.Errorf("output:\n%s", out.String())
+	}
+}
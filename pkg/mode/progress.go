@@ -0,0 +1,140 @@
+package mode
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/ipc"
+	"github.com/go-installapplications/pkg/progress"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// newProgressReporter builds the progress reporter selected by the config.
+// Returns progress.Nop when no progress UI is enabled.
+func newProgressReporter(cfg *config.Config, logger utils.Logger) progress.Reporter {
+	if !cfg.SwiftDialog {
+		return progress.Nop{}
+	}
+	logger.Debug("swiftDialog progress UI enabled (path: %s, command file: %s)", cfg.SwiftDialogPath, cfg.SwiftDialogCommandFile)
+	return progress.NewTracker(cfg.SwiftDialogTitle, cfg.SwiftDialogMessage, logger)
+}
+
+// newProgressDisplay builds the UI helper that renders progress in the
+// current user session. swiftDialog is the only built-in helper.
+func newProgressDisplay(cfg *config.Config, launch progress.Launcher, logger utils.Logger) progress.Display {
+	return progress.NewSwiftDialog(progress.SwiftDialogOptions{
+		Path:        cfg.SwiftDialogPath,
+		CommandFile: cfg.SwiftDialogCommandFile,
+		Icon:        cfg.SwiftDialogIcon,
+		ExtraArgs:   cfg.SwiftDialogArgs,
+		Launch:      launch,
+	}, logger)
+}
+
+// progressItems returns the items shown in the progress UI. Preflight is an
+// internal check and is not displayed.
+func progressItems(bootstrap *config.Bootstrap) []config.Item {
+	items := make([]config.Item, 0, len(bootstrap.SetupAssistant)+len(bootstrap.Userland))
+	items = append(items, bootstrap.SetupAssistant...)
+	return append(items, bootstrap.Userland...)
+}
+
+// agentDisplay is a progress.Display that forwards every snapshot to the
+// agent, which renders it with its own UI helper in the user's session.
+type agentDisplay struct {
+	sockPath string
+	cfg      *config.Config
+	logger   utils.Logger
+}
+
+func newAgentDisplay(sockPath string, cfg *config.Config, logger utils.Logger) *agentDisplay {
+	return &agentDisplay{sockPath: sockPath, cfg: cfg, logger: logger}
+}
+
+func (a *agentDisplay) Show(state progress.State) error   { return a.send("ShowProgress", state) }
+func (a *agentDisplay) Update(state progress.State) error { return a.send("UpdateProgress", state) }
+func (a *agentDisplay) Dismiss(state progress.State) error {
+	return a.send("DismissProgress", state)
+}
+
+func (a *agentDisplay) send(command string, state progress.State) error {
+	resp, err := callAgent(shutdownCtx, a.logger, a.sockPath, ipc.RPCRequest{Command: command, Progress: &state}, a.cfg.AgentRequestTimeout)
+	if err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("agent %s failed: %s", command, resp.Error)
+	}
+	return nil
+}
+
+// execLauncher starts UI helpers directly. Used by the agent, which already
+// runs in the user's GUI session. The process is reaped in the background so
+// it never lingers as a zombie.
+func execLauncher(logger utils.Logger) progress.Launcher {
+	return func(path string, args []string) error {
+		cmd := exec.Command(path, args...)
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("failed to start %s: %w", path, err)
+		}
+		logger.Info("Started UI helper: %s (PID: %d)", path, cmd.Process.Pid)
+		go func() {
+			if err := cmd.Wait(); err != nil {
+				logger.Debug("UI helper %s exited: %v", path, err)
+			}
+		}()
+		return nil
+	}
+}
+
+// asUserLauncher starts UI helpers in the console user's session via
+// launchctl asuser. Used by standalone mode, which has no agent.
+func asUserLauncher(uid string, logger utils.Logger) progress.Launcher {
+	return func(path string, args []string) error {
+		cmd := exec.Command("launchctl", append([]string{"asuser", uid, path}, args...)...)
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		go func() {
+			if err := cmd.Wait(); err != nil {
+				logger.Debug("UI helper %s exited: %v", path, err)
+			}
+		}()
+		return nil
+	}
+}
+
+// attachProgressUI connects the reporter to a display if it supports one.
+// Failures are logged and otherwise ignored: progress display must never
+// block a run.
+func attachProgressUI(reporter progress.Reporter, display progress.Display, logger utils.Logger) {
+	a, ok := reporter.(progress.Attacher)
+	if !ok {
+		return
+	}
+	if err := a.Attach(display); err != nil {
+		logger.Info("⚠️  Progress UI could not be launched: %v", err)
+	}
+}
+
+// agentSocketIfReady returns the agent socket path when an agent is already
+// accepting connections, without waiting. Returns "" otherwise.
+func agentSocketIfReady() string {
+	uid, err := consoleUID()
+	if err != nil {
+		return ""
+	}
+	sockPath, err := ipc.GetAgentSocketPathForUID(uid)
+	if err != nil {
+		return ""
+	}
+	conn, err := net.DialTimeout("unix", sockPath, 2*time.Second)
+	if err != nil {
+		return ""
+	}
+	_ = conn.Close()
+	return sockPath
+}
@@ -0,0 +1,91 @@
+package mode
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/ipc"
+	"github.com/go-installapplications/pkg/progress"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// The daemon drives the agent's progress UI purely through Show/Update/
+// DismissProgress; verify a Tracker attached to an agentDisplay ends up
+// writing swiftDialog commands on the agent side.
+func TestAgentDisplay_DrivesAgentProgressUI(t *testing.T) {
+	logger := utils.NewLogger(false, false)
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "dialog")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatalf("write fake dialog: %v", err)
+	}
+	cmdFile := filepath.Join(dir, "dialog.log")
+
+	agentCfg := config.NewConfig()
+	agentCfg.SwiftDialogPath = bin
+	agentCfg.SwiftDialogCommandFile = cmdFile
+	ui := &agentProgressUI{cfg: agentCfg, logger: logger}
+
+	sockPath := shortSockPath(t)
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var req ipc.RPCRequest
+				if err := json.NewDecoder(bufio.NewReader(c)).Decode(&req); err != nil {
+					return
+				}
+				resp := ipc.RPCResponse{ID: req.ID, OK: true}
+				if err := ui.handle(req); err != nil {
+					resp.OK, resp.Error = false, err.Error()
+				}
+				_ = json.NewEncoder(c).Encode(resp)
+			}(conn)
+		}
+	}()
+
+	daemonCfg := config.NewConfig()
+	daemonCfg.AgentRequestTimeout = 5 * time.Second
+	tracker := progress.NewTracker("", "", logger)
+	item := config.Item{Name: "Tool"}
+	tracker.Start([]config.Item{item})
+	if err := tracker.Attach(newAgentDisplay(sockPath, daemonCfg, logger)); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	tracker.ItemStarted(item)
+	tracker.ItemFinished(item, nil)
+	tracker.Finish(nil)
+
+	data, err := os.ReadFile(cmdFile)
+	if err != nil {
+		t.Fatalf("read command file: %v", err)
+	}
+	got := string(data)
+	for _, want := range []string{"status: success, statustext: Installed", "progress: 1", "quit:"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("command file missing %q:\n%s", want, got)
+		}
+	}
+
+	// Updates without an open UI are rejected
+	resp := roundTrip(t, sockPath, ipc.RPCRequest{Command: "UpdateProgress", Progress: &progress.State{}})
+	if resp.OK {
+		t.Fatalf("UpdateProgress after dismiss should fail: %+v", resp)
+	}
+}
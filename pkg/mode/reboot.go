@@ -0,0 +1,70 @@
+package mode
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/ipc"
+	"github.com/go-installapplications/pkg/jamf"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+const osascriptPath = "/usr/bin/osascript"
+
+// notifyDialogScript shows its first argument in a dialog that closes by
+// itself after the second, in seconds. Passing the message as an argument
+// keeps it out of the AppleScript source.
+var notifyDialogScript = []string{
+	"on run argv",
+	`display dialog (item 1 of argv) with title "Restart" buttons {"OK"} default button 1 with icon caution giving up after (item 2 of argv as integer)`,
+	"end run",
+}
+
+// notifyDialogArgs are the osascript arguments that show message for delay.
+func notifyDialogArgs(message string, delay time.Duration) []string {
+	var args []string
+	for _, line := range notifyDialogScript {
+		args = append(args, "-e", line)
+	}
+	seconds := int(delay.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	return append(args, message, strconv.Itoa(seconds))
+}
+
+// RebootHooks warns the console user through their agent, or with
+// launchctl asuser when no agent answers (standalone mode, or after the
+// daemon shut the agents down), and restarts through the Jamf Pro API.
+func RebootHooks(cfg *config.Config, logger utils.Logger) utils.RebootHooks {
+	return utils.RebootHooks{
+		Notify: func(message string, delay time.Duration) error {
+			user, err := utils.GetConsoleUser()
+			if err != nil {
+				return err
+			}
+			if user.AtLoginWindow() || user.UID == utils.SetupAssistantUID {
+				logger.Debug("No user at the console to warn about the reboot")
+				return nil
+			}
+			sockPath, err := ipc.GetAgentSocketPathForUID(user.UID)
+			if err == nil {
+				req := ipc.RPCRequest{Command: "Notify", Message: message, TimeoutSeconds: int(delay.Seconds())}
+				var resp ipc.RPCResponse
+				if resp, err = callAgent(shutdownCtx, logger, sockPath, req, cfg.AgentRequestTimeout); err == nil {
+					if resp.OK {
+						return nil
+					}
+					err = fmt.Errorf("agent Notify failed: %s", resp.Error)
+				}
+			}
+			logger.Debug("Warning the user without the agent: %v", err)
+			return asUserLauncher(user.UID, logger)(osascriptPath, notifyDialogArgs(message, delay))
+		},
+		MDMRestart: func() error {
+			return jamf.Restart(jamf.Options{URL: cfg.JamfURL, APIToken: cfg.JamfAPIToken}, logger)
+		},
+	}
+}
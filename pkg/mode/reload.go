@@ -0,0 +1,40 @@
+package mode
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/download"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// watchProfile rereads the profile whenever MDM pushes a new version during
+// the run. The returned func applies the reloadable settings that changed
+// (see config.Reloadable); the daemon calls it between phases, where
+// nothing else reads cfg.
+func watchProfile(ctx context.Context, cfg *config.Config, downloader *download.Client, logger utils.Logger) func() {
+	reloader := config.NewReloader(cfg)
+	go func() {
+		defer utils.Recover(logger, "profile watch")
+		config.WatchProfile(ctx, cfg.ProfileDomain, func() {
+			changed, err := reloader.Refresh()
+			if err != nil {
+				logger.Error("⚠️  Failed to reload the changed profile: %v", err)
+			} else if changed {
+				logger.Info("Profile changed; its new settings apply from the next phase")
+			}
+		})
+	}()
+	return func() {
+		names := reloader.Apply(cfg)
+		if len(names) == 0 {
+			return
+		}
+		if err := utils.ApplyLogLevels(logger, cfg); err != nil {
+			logger.Error("⚠️  Ignoring the reloaded LogLevels: %v", err)
+		}
+		downloader.SetRetryDefaults(cfg.MaxRetries, cfg.RetryDelay)
+		logger.Info("Reloaded from the profile: %s", strings.Join(names, ", "))
+	}
+}
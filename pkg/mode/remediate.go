@@ -0,0 +1,194 @@
+package mode
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/download"
+	"github.com/go-installapplications/pkg/installer"
+	"github.com/go-installapplications/pkg/manager"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// remediationBootstrapName is the file name of the bootstrap the remediation
+// job checks, inside RemediationPath.
+const remediationBootstrapName = "bootstrap.json"
+
+// remediable reports whether remediation can tell that item drifted: a
+// package by its receipt, a root file by its presence and hash. Scripts and
+// user items leave nothing to check.
+func remediable(item config.Item) bool {
+	switch item.Type {
+	case "package":
+		return item.PackageID != ""
+	case "rootfile":
+		return true
+	}
+	return false
+}
+
+// remediationBootstrap keeps the remediable items of the setupassistant and
+// userland phases. Preflight and hooks are not run again.
+func remediationBootstrap(bootstrap *config.Bootstrap) *config.Bootstrap {
+	keep := func(items []config.Item) []config.Item {
+		var kept []config.Item
+		for _, item := range items {
+			if remediable(item) {
+				kept = append(kept, item)
+			}
+		}
+		return kept
+	}
+	return &config.Bootstrap{
+		SetupAssistant: keep(bootstrap.SetupAssistant),
+		Userland:       keep(bootstrap.Userland),
+	}
+}
+
+// remediationJob describes the LaunchDaemon that runs remediate mode every
+// RemediationInterval from the copy of the binary in RemediationPath.
+func remediationJob(cfg *config.Config) launchdJob {
+	args := launchdArgs(cfg, "remediate")
+	args[0] = filepath.Join(cfg.RemediationPath, binaryName)
+	if cfg.RemediationPath != config.NewConfig().RemediationPath {
+		args = append(args, "--remediation-path", cfg.RemediationPath)
+	}
+	return launchdJob{
+		Label:             cfg.RemediationIdentifier(),
+		Program:           args[0],
+		ProgramArguments:  args,
+		StartInterval:     int(cfg.RemediationInterval / time.Second),
+		StandardOutPath:   cfg.DefaultDaemonLogPath,
+		StandardErrorPath: cfg.DefaultDaemonLogPath,
+	}
+}
+
+// installRemediation sets up the remediation job after a successful daemon
+// run: it copies the binary and the remediable items of bootstrap to
+// RemediationPath, which cleanup leaves alone, then writes and loads the
+// LaunchDaemon. Nothing is set up when there is nothing to check.
+func installRemediation(bootstrap *config.Bootstrap, cfg *config.Config, logger utils.Logger) error {
+	checked := remediationBootstrap(bootstrap)
+	if len(checked.SetupAssistant)+len(checked.Userland) == 0 {
+		logger.Info("No packages with a packageid or root files to remediate; not scheduling remediation")
+		return nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot locate the running binary: %w", err)
+	}
+	if err := installBinary(self, filepath.Join(cfg.RemediationPath, binaryName), cfg.DryRun, logger); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(checked, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode remediation bootstrap: %w", err)
+	}
+	plistPath := filepath.Join(launchDaemonsDir, cfg.RemediationIdentifier()+".plist")
+	job, err := marshalLaunchdJob(remediationJob(cfg))
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", cfg.RemediationIdentifier(), err)
+	}
+	if cfg.DryRun {
+		logger.Info("[DRY RUN] Would write %s and %s and load it", filepath.Join(cfg.RemediationPath, remediationBootstrapName), plistPath)
+		return nil
+	}
+	// The bootstrap may carry URLs with credentials; only root reads it
+	if err := utils.WriteFileAtomic(filepath.Join(cfg.RemediationPath, remediationBootstrapName), data, 0600); err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(plistPath, job, 0644); err != nil {
+		return err
+	}
+	_ = utils.Bootout("system", plistPath)
+	if err := utils.Bootstrap("system", plistPath); err != nil {
+		return fmt.Errorf("load %s: %w", plistPath, err)
+	}
+	logger.Info("✅ Scheduled remediation every %v (%s)", cfg.RemediationInterval, cfg.RemediationIdentifier())
+	return nil
+}
+
+// driftedItems returns the items that no longer match the bootstrap: a
+// package whose receipt is missing or older than its version, or a root file
+// that is missing or whose hash changed.
+func driftedItems(items []config.Item, downloader *download.Client, logger utils.Logger) []config.Item {
+	var drifted []config.Item
+	for _, item := range items {
+		switch item.Type {
+		case "package":
+			satisfied, err := utils.CheckPackageReceipt(item.PackageID, item.Version, logger)
+			if err != nil || !satisfied {
+				logger.Info("🔧 %s drifted: receipt %s not satisfied", item.Name, item.PackageID)
+				drifted = append(drifted, item)
+			}
+		case "rootfile":
+			if _, err := os.Stat(item.File); err != nil {
+				logger.Info("🔧 %s drifted: %s is missing", item.Name, item.File)
+				drifted = append(drifted, item)
+			} else if item.Hash != "" {
+				if err := downloader.VerifyFileHash(item.File, item.Hash); err != nil {
+					logger.Info("🔧 %s drifted: %v", item.Name, err)
+					drifted = append(drifted, item)
+				}
+			}
+		}
+	}
+	return drifted
+}
+
+// RunRemediate is run by the remediation LaunchDaemon. It re-checks the
+// items saved by the last successful daemon run and reinstalls the ones that
+// drifted, with the daemon's downloads, retries and fail_policy. Unlike the
+// daemon it never cleans up the installation, so the job keeps running.
+func RunRemediate(cfg *config.Config, logger utils.Logger) {
+	logger.Info("Starting remediation check")
+	watchShutdown(logger)
+
+	path := filepath.Join(cfg.RemediationPath, remediationBootstrapName)
+	bootstrap, err := config.LoadBootstrapWithOptions(path, false)
+	if err != nil {
+		logger.Error("❌ Failed to load remediation bootstrap %s: %v", path, err)
+		os.Exit(int(utils.ExitBootstrapFetch))
+	}
+
+	downloader := newItemDownloader(cfg, logger)
+	systemInstaller := installer.NewSystemInstaller(cfg.DryRun, logger, false)
+	systemInstaller.SetStop(shutdownCtx.Done())
+	systemInstaller.SetLogDir(cfg.ItemLogDir())
+	m := manager.NewManager(downloader, systemInstaller, cfg, logger)
+	m.SetContext(shutdownCtx)
+
+	checked, reinstalled := 0, 0
+	for _, phase := range []struct {
+		name  string
+		items []config.Item
+	}{{"setupassistant", bootstrap.SetupAssistant}, {"userland", bootstrap.Userland}} {
+		checked += len(phase.items)
+		drifted := driftedItems(phase.items, downloader, logger)
+		if len(drifted) == 0 {
+			continue
+		}
+		err := m.ProcessItems(drifted, phase.name)
+		// Downloaded packages are not needed once installed; root files are
+		// the items themselves and stay
+		for _, item := range drifted {
+			if item.Type == "package" && !cfg.DryRun {
+				_ = os.Remove(item.File)
+			}
+		}
+		if err != nil {
+			logger.Error("❌ Remediation failed: %v", err)
+			os.Exit(int(exitCodeFor(err)))
+		}
+		reinstalled += len(drifted)
+	}
+	if reinstalled == 0 {
+		logger.Info("✅ No drift found in %d items", checked)
+		return
+	}
+	logger.Info("✅ Reinstalled %d of %d items", reinstalled, checked)
+}
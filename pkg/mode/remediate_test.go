@@ -0,0 +1,85 @@
+package mode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/download"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+func TestRemediationJob(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.RemediationInterval = 24 * time.Hour
+	cfg.RemediationPath = "/Library/custom-remediation"
+	job := remediationJob(cfg)
+
+	bin := "/Library/custom-remediation/go-installapplications"
+	want := []string{bin, "--mode", "remediate", "--remediation-path", "/Library/custom-remediation"}
+	if job.Label != cfg.LaunchDaemonIdentifier+".remediate" || job.Program != bin {
+		t.Errorf("label/program = %s %s", job.Label, job.Program)
+	}
+	if !reflect.DeepEqual(job.ProgramArguments, want) {
+		t.Errorf("args = %v, want %v", job.ProgramArguments, want)
+	}
+	if job.StartInterval != 86400 || job.RunAtLoad || job.KeepAlive != nil {
+		t.Errorf("job should only run every 86400s: %+v", job)
+	}
+}
+
+func TestRemediationBootstrap_KeepsCheckableItems(t *testing.T) {
+	b := &config.Bootstrap{
+		Preflight: []config.Item{{Name: "pre", Type: "rootscript"}},
+		SetupAssistant: []config.Item{
+			{Name: "pkg", Type: "package", PackageID: "com.example.pkg"},
+			{Name: "no receipt", Type: "package"},
+			{Name: "script", Type: "rootscript"},
+		},
+		Userland: []config.Item{
+			{Name: "file", Type: "rootfile", File: "/tmp/x"},
+			{Name: "user file", Type: "userfile", File: "/tmp/y"},
+		},
+	}
+	got := remediationBootstrap(b)
+	if len(got.Preflight) != 0 || len(got.SetupAssistant) != 1 || got.SetupAssistant[0].Name != "pkg" ||
+		len(got.Userland) != 1 || got.Userland[0].Name != "file" {
+		t.Errorf("unexpected remediation bootstrap: %+v", got)
+	}
+}
+
+func TestDriftedItems_RootFiles(t *testing.T) {
+	dir := t.TempDir()
+	intact := filepath.Join(dir, "intact")
+	changed := filepath.Join(dir, "changed")
+	for _, p := range []string{intact, changed} {
+		if err := os.WriteFile(p, []byte("original"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum := sha256.Sum256([]byte("original"))
+	hash := hex.EncodeToString(sum[:])
+	if err := os.WriteFile(changed, []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := utils.NewLogger(false, false)
+	items := []config.Item{
+		{Name: "intact", Type: "rootfile", File: intact, Hash: hash},
+		{Name: "changed", Type: "rootfile", File: changed, Hash: hash},
+		{Name: "missing", Type: "rootfile", File: filepath.Join(dir, "missing")},
+		{Name: "unhashed", Type: "rootfile", File: intact},
+	}
+	var names []string
+	for _, item := range driftedItems(items, download.NewClient(logger), logger) {
+		names = append(names, item.Name)
+	}
+	if want := []string{"changed", "missing"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("drifted = %v, want %v", names, want)
+	}
+}
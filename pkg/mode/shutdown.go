@@ -0,0 +1,50 @@
+package mode
+
+import (
+	"context"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/progress"
+	"github.com/go-installapplications/pkg/retry"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// shutdownCtx is cancelled when the process receives SIGTERM or SIGINT. It
+// is installed by watchShutdown at the start of a run and stays Background
+// otherwise (e.g. in tests). Components built by setupBootstrapAndComponents
+// and pending agent requests stop when it is done.
+var shutdownCtx = context.Background()
+
+// watchShutdown installs the shutdown signal handlers for a run.
+func watchShutdown(logger utils.Logger) context.Context {
+	shutdownCtx = utils.ShutdownContext(logger)
+	return shutdownCtx
+}
+
+// limitRun makes shutdownCtx, and everything built from it, stop once
+// cfg.RunDeadline has passed. The run then fails like any other, with the
+// configured cleanup, rather than exiting as interrupted.
+func limitRun(cfg *config.Config, logger utils.Logger) context.Context {
+	if cfg.RunDeadline > 0 {
+		shutdownCtx, _ = utils.WithTimeout(shutdownCtx, cfg.RunDeadline, "run", logger)
+	}
+	return shutdownCtx
+}
+
+// exitIfInterrupted ends the run with utils.ExitInterrupted if a shutdown
+// signal stopped it. The progress UI (which also pushes metrics and traces)
+// and the retry state are told why first; reporter may be nil. A run stopped
+// by RunDeadline is left to its caller's failure path.
+func exitIfInterrupted(ctx context.Context, reporter progress.Reporter, logger utils.Logger) {
+	if ctx.Err() == nil || utils.IsTimeout(ctx) {
+		return
+	}
+	cause := context.Cause(ctx)
+	if reporter != nil {
+		reporter.Finish(cause)
+	}
+	if err := retry.RecordReason(cause.Error()); err != nil {
+		logger.Debug("Failed to record interruption in retry state: %v", err)
+	}
+	utils.ExitInterrupted(ctx, logger)
+}
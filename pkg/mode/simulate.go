@@ -0,0 +1,111 @@
+package mode
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/installer"
+	"github.com/go-installapplications/pkg/manager"
+	"github.com/go-installapplications/pkg/simulate"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// RunSimulate runs the bootstrap with the downloader and installer replaced
+// by a simulation: every phase, item order, parallel_group, download retry,
+// fail_policy and the progress UI behave as in standalone mode, but items
+// only take the time SimulationFile gives them and fail where it says. The
+// machine is not changed: nothing is cleaned up or written to the status
+// plist, and root is not needed. Failures exit 1.
+func RunSimulate(cfg *config.Config, logger utils.Logger) {
+	logger.Info("Starting simulate mode: nothing will be downloaded or installed")
+	watchShutdown(logger)
+	ctx := limitRun(cfg, logger)
+
+	spec, err := simulate.Load(cfg.SimulationFile)
+	if err != nil {
+		logger.Error("❌ %v", err)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitConfig, "invalid simulation file")
+	}
+
+	// A JSONURL bootstrap is downloaded to a scratch directory
+	scratch, err := os.MkdirTemp("", "go-installapplications-simulate-")
+	if err != nil {
+		logger.Error("❌ Cannot create a scratch directory: %v", err)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "no scratch directory")
+	}
+	defer os.RemoveAll(scratch)
+	runCfg := *cfg
+	runCfg.InstallPath = scratch
+	runCfg.StatusPlistPath = ""
+	runCfg.CleanupOnSuccess = false
+	runCfg.CleanupOnFailure = false
+	runCfg.RollbackOnFailure = false
+
+	bootstrap, err := getBootstrap(&runCfg, logger)
+	if err == nil {
+		bootstrap, err = restrictBootstrap(bootstrap, cfg, logger)
+	}
+	if err != nil {
+		logger.Error("❌ Failed to load bootstrap: %v", err)
+		os.RemoveAll(scratch)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitBootstrapFetch, "bootstrap unavailable")
+	}
+
+	sim := simulate.New(spec, bootstrap, &runCfg, logger)
+	sim.SetContext(shutdownCtx)
+	reporter, tracer := newRunReporter(&runCfg, sim, logger)
+	mgr := manager.NewManager(sim, sim, &runCfg, logger)
+	mgr.SetReporter(reporter)
+	mgr.SetTracer(tracer)
+	mgr.SetContext(shutdownCtx)
+	mgr.SetHooks(bootstrap.Hooks)
+	mgr.SetPreflightOptions(bootstrap.PreflightOptions)
+	reporter.Start(progressItems(bootstrap))
+	if utils.IsRootUser() {
+		if uid, err := consoleUID(); err == nil && isUserUID(uid) {
+			attachProgressUI(reporter, newProgressDisplay(cfg, asUserLauncher(uid, logger), logger), logger)
+		}
+	} else {
+		attachProgressUI(reporter, newProgressDisplay(cfg, execLauncher(logger), logger), logger)
+	}
+
+	err = runSimulatedPhases(bootstrap, mgr, logger)
+	reporter.Finish(err)
+	if ctx.Err() != nil && !utils.IsTimeout(ctx) {
+		os.RemoveAll(scratch)
+		utils.ExitInterrupted(ctx, logger)
+	}
+	if err != nil {
+		logger.Error("❌ Simulated run failed: %v", err)
+		os.RemoveAll(scratch)
+		utils.ExitWithoutCleanup(cfg, logger, exitCodeFor(err), "simulated run failed")
+	}
+	logger.Info("🎉 Simulated run completed")
+}
+
+// runSimulatedPhases runs the phases in order. Preflight always runs; a
+// passing preflight ends the run successfully as it would for real.
+func runSimulatedPhases(bootstrap *config.Bootstrap, mgr *manager.Manager, logger utils.Logger) error {
+	for _, phase := range []struct {
+		name  string
+		items []config.Item
+	}{
+		{"preflight", bootstrap.Preflight},
+		{"setupassistant", bootstrap.SetupAssistant},
+		{"userland", bootstrap.Userland},
+	} {
+		if len(phase.items) == 0 {
+			continue
+		}
+		err := mgr.ProcessItems(phase.items, phase.name)
+		if _, ok := err.(*installer.PreflightSuccessError); ok {
+			logger.Info("Preflight passed; a real run would clean up and exit here")
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s phase failed: %w", phase.name, err)
+		}
+	}
+	return nil
+}
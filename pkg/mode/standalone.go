@@ -3,7 +3,6 @@ package mode
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 
 	"github.com/go-installapplications/pkg/config"
@@ -14,15 +13,20 @@ import (
 // RunStandalone executes the standalone mode workflow
 // Cleans existing state and runs complete bootstrap process using standard configuration hierarchy
 // Only supports server-based (jsonurl) or MDM-embedded bootstrap sources
-func RunStandalone(cfg *config.Config, logger *utils.Logger) {
+// Any failure exits non-zero, NoRestartOnError or not
+func RunStandalone(cfg *config.Config, logger utils.Logger) {
 	logger.Info("Starting standalone mode")
+	watchShutdown(logger)
+	ctx := limitRun(cfg, logger)
 
 	// Step 1: Clean existing state (but preserve binary)
 	logger.Info("🧹 Step 1: Cleaning existing installation state")
-	if err := cleanInstallationState(cfg, logger); err != nil {
+	if cfg.DryRun {
+		logger.Info("[DRY RUN] Leaving the existing installation state in place")
+	} else if err := cleanInstallationState(cfg, logger); err != nil {
 		logger.Error("Failed to clean installation state: %v", err)
 		// No cleanup needed - we haven't started bootstrap yet
-		return
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "failed to clean installation state")
 	}
 
 	// Step 2: Check if we have a valid bootstrap source (server-based or MDM-embedded only)
@@ -33,7 +37,7 @@ func RunStandalone(cfg *config.Config, logger *utils.Logger) {
 		hasBootstrapSource = true
 	} else {
 		// Check for embedded bootstrap in mobileconfig
-		_, err := cfg.LoadBootstrapFromProfile(config.DefaultProfileDomain)
+		_, err := cfg.LoadBootstrapFromProfile(cfg.ProfileDomain)
 		if err == nil {
 			logger.Info("📱 Bootstrap source: Embedded in mobileconfig")
 			hasBootstrapSource = true
@@ -46,29 +50,31 @@ func RunStandalone(cfg *config.Config, logger *utils.Logger) {
 		logger.Error("  1. Remote URL: --jsonurl https://company.com/bootstrap.json")
 		logger.Error("  2. Embedded in mobileconfig (deployed via MDM)")
 		// No cleanup needed - we haven't started bootstrap yet
-		return
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitConfig, "missing bootstrap source")
 	}
 
 	// Step 3: Run complete bootstrap process
 	logger.Info("🚀 Step 2: Running complete bootstrap process")
 	if err := runCompleteBootstrap(cfg, logger); err != nil {
+		// The progress UI was already finished with err
+		exitIfInterrupted(ctx, nil, logger)
 		logger.Error("Bootstrap process failed: %v", err)
 		logger.Error("⚠️  Manual intervention may be required")
 		// Cleanup needed since bootstrap process was started
 		// We need to create a temporary manager for cleanup
 		if _, _, _, manager, setupErr := setupBootstrapAndComponents(cfg, logger); setupErr == nil {
 			manager.Cleanup("standalone bootstrap failure")
-			utils.Exit(cfg, logger, 1, "bootstrap process failed")
+			utils.Exit(cfg, logger, exitCodeFor(err), "bootstrap process failed")
 		} else {
 			// If we can't create manager, just exit without cleanup
-			utils.Exit(cfg, logger, 1, "bootstrap process failed")
+			utils.Exit(cfg, logger, exitCodeFor(err), "bootstrap process failed")
 		}
 	}
 
 }
 
 // cleanInstallationState cleans existing state but preserves the binary
-func cleanInstallationState(cfg *config.Config, logger *utils.Logger) error {
+func cleanInstallationState(cfg *config.Config, logger utils.Logger) error {
 	logger.Info("🧹 Cleaning installation state (preserving binary)")
 
 	// Stop all running services
@@ -94,7 +100,7 @@ func cleanInstallationState(cfg *config.Config, logger *utils.Logger) error {
 }
 
 // stopInstallApplicationsServices stops any running LaunchDaemon/LaunchAgent services
-func stopInstallApplicationsServices(cfg *config.Config, logger *utils.Logger) error {
+func stopInstallApplicationsServices(cfg *config.Config, logger utils.Logger) error {
 	// Build plist paths from identifiers
 	daemonPlist := "/Library/LaunchDaemons/" + cfg.LaunchDaemonIdentifier + ".plist"
 	agentPlist := "/Library/LaunchAgents/" + cfg.LaunchAgentIdentifier + ".plist"
@@ -108,17 +114,17 @@ func stopInstallApplicationsServices(cfg *config.Config, logger *utils.Logger) e
 	guiDomain := "gui/" + uid
 
 	services := []struct {
-		label string
-		cmd   []string
+		label  string
+		domain string
+		plist  string
 	}{
-		{label: "LaunchDaemon", cmd: []string{"launchctl", "bootout", "system", daemonPlist}},
-		{label: "LaunchAgent", cmd: []string{"launchctl", "bootout", guiDomain, agentPlist}},
+		{label: "LaunchDaemon", domain: "system", plist: daemonPlist},
+		{label: "LaunchAgent", domain: guiDomain, plist: agentPlist},
 	}
 
 	for _, svc := range services {
 		logger.Debug("Stopping %s service", svc.label)
-		cmd := exec.Command(svc.cmd[0], svc.cmd[1:]...)
-		if err := cmd.Run(); err != nil {
+		if err := utils.Bootout(svc.domain, svc.plist); err != nil {
 			logger.Debug("%s service stop failed (may not be running): %v", svc.label, err)
 		} else {
 			logger.Info("✅ Stopped %s service", svc.label)
@@ -128,38 +134,36 @@ func stopInstallApplicationsServices(cfg *config.Config, logger *utils.Logger) e
 	return nil
 }
 
-// cleanSignalFiles removes signal files that track installation state
-func cleanSignalFiles(cfg *config.Config, logger *utils.Logger) error {
-	signalDirs := []string{
-		cfg.InstallPath,
+// cleanSignalFiles removes signal files that track installation state. The
+// CleanupPreserve paths, and the per-item logs unless logs are in
+// CleanupScope, are kept as they are by cleanup.
+func cleanSignalFiles(cfg *config.Config, logger utils.Logger) error {
+	if cfg.InstallPath == "" {
+		return nil
 	}
 
-	for _, dir := range signalDirs {
-		if dir == "" {
-			continue
-		}
-
-		logger.Debug("Cleaning signal directory: %s", dir)
-		if err := os.RemoveAll(dir); err != nil {
-			logger.Debug("Failed to remove %s: %v", dir, err)
-		} else {
-			logger.Verbose("Cleaned signal directory: %s", dir)
-		}
+	logger.Debug("Cleaning signal directory: %s", cfg.InstallPath)
+	if err := utils.RemoveInstallPath(cfg); err != nil {
+		logger.Debug("Failed to remove %s: %v", cfg.InstallPath, err)
+	} else {
+		logger.Verbose("Cleaned signal directory: %s", cfg.InstallPath)
+	}
 
-		// Recreate the directory for future use
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			logger.Debug("Failed to recreate %s: %v", dir, err)
-		}
+	// Recreate the directory for future use
+	if err := os.MkdirAll(cfg.InstallPath, 0755); err != nil {
+		logger.Debug("Failed to recreate %s: %v", cfg.InstallPath, err)
 	}
 
 	return nil
 }
 
 // clearCachedState removes any cached application or download state (but preserves binary)
-func clearCachedState(cfg *config.Config, logger *utils.Logger) error {
+func clearCachedState(cfg *config.Config, logger utils.Logger) error {
 	// Clear any cached downloads
 	cacheDir := filepath.Join(cfg.InstallPath, "cache")
-	if err := os.RemoveAll(cacheDir); err != nil {
+	if cfg.Preserved(cacheDir) {
+		logger.Verbose("Keeping preserved cache directory: %s", cacheDir)
+	} else if err := os.RemoveAll(cacheDir); err != nil {
 		logger.Debug("Failed to clear cache directory %s: %v", cacheDir, err)
 	} else {
 		logger.Verbose("Cleared cache directory: %s", cacheDir)
@@ -171,6 +175,10 @@ func clearCachedState(cfg *config.Config, logger *utils.Logger) error {
 	}
 
 	for _, file := range bootstrapFiles {
+		if cfg.Preserved(file) {
+			logger.Verbose("Keeping preserved bootstrap file: %s", file)
+			continue
+		}
 		if err := os.Remove(file); err != nil {
 			logger.Debug("Failed to remove bootstrap file %s: %v", file, err)
 		} else {
@@ -186,25 +194,47 @@ func clearCachedState(cfg *config.Config, logger *utils.Logger) error {
 }
 
 // runCompleteBootstrap executes the full bootstrap process using standard logic
-func runCompleteBootstrap(cfg *config.Config, logger *utils.Logger) error {
+func runCompleteBootstrap(cfg *config.Config, logger utils.Logger) error {
 	logger.Info("🔄 Starting complete bootstrap process")
 
 	// Get bootstrap and create components using shared logic
-	bootstrap, _, _, manager, err := setupBootstrapAndComponents(cfg, logger)
+	bootstrap, downloader, _, manager, err := setupBootstrapAndComponents(cfg, logger)
 	if err != nil {
 		return fmt.Errorf("failed to setup bootstrap and components: %w", err)
 	}
 
-	// Run all phases in order (like the complete daemon + agent flow)
-	if len(bootstrap.Preflight) > 0 && cfg.WithPreflight {
+	if cfg.DryRun {
+		reviewPlan(bootstrap, downloader.ContentLength, cfg, logger)
+	}
+
+	// Progress UI, metrics and tracing. Standalone has no agent, so the UI is launched
+	// via launchctl asuser when a user is logged in at the console.
+	reporter, tracer := newRunReporter(cfg, downloader, logger)
+	manager.SetReporter(reporter)
+	manager.SetTracer(tracer)
+	reporter.Start(progressItems(bootstrap))
+	if uid, err := utils.GetConsoleUserUID(); err == nil && uid != "" && uid != "0" {
+		attachProgressUI(reporter, newProgressDisplay(cfg, asUserLauncher(uid, logger), logger), logger)
+	}
+
+	// Run all phases in order (like the complete daemon + agent flow).
+	// Preflight is opt-in so a machine can be re-run without its preflight
+	// script ending the run early.
+	if len(bootstrap.Preflight) > 0 && !cfg.WithPreflight {
+		logger.Info("⏭️  Skipping preflight phase (%d items); pass --with-preflight to run it", len(bootstrap.Preflight))
+	} else if len(bootstrap.Preflight) > 0 {
 		logger.Info("🎯 Starting preflight phase")
 		if err := manager.ProcessItems(bootstrap.Preflight, "preflight"); err != nil {
 			// Check if this is a preflight success signal
 			if _, ok := err.(*installer.PreflightSuccessError); ok {
+				// Same contract as the daemon: exit 0 means nothing else to do
 				logger.Info("Preflight script passed - cleaning up and exiting")
-				return nil // Return success to indicate completion
+				reporter.Finish(nil)
+				manager.Cleanup("preflight success")
+				utils.Exit(cfg, logger, utils.ExitSuccess, "preflight success")
 			}
 			// Actual error occurred
+			reporter.Finish(err)
 			return fmt.Errorf("preflight phase failed: %w", err)
 		}
 		logger.Info("✅ Preflight phase completed successfully")
@@ -213,6 +243,7 @@ func runCompleteBootstrap(cfg *config.Config, logger *utils.Logger) error {
 	if len(bootstrap.SetupAssistant) > 0 {
 		logger.Info("⚙️  Starting setupassistant phase")
 		if err := manager.ProcessItems(bootstrap.SetupAssistant, "setupassistant"); err != nil {
+			reporter.Finish(err)
 			return fmt.Errorf("setupassistant phase failed: %w", err)
 		}
 		logger.Info("✅ Setupassistant phase completed successfully")
@@ -221,15 +252,18 @@ func runCompleteBootstrap(cfg *config.Config, logger *utils.Logger) error {
 	if len(bootstrap.Userland) > 0 {
 		logger.Info("👤 Starting userland phase")
 		if err := manager.ProcessItems(bootstrap.Userland, "userland"); err != nil {
+			reporter.Finish(err)
 			return fmt.Errorf("userland phase failed: %w", err)
 		}
 		logger.Info("✅ Userland phase completed successfully")
 	}
 
 	logger.Info("🎉 All phases completed successfully")
+	updateJamf(cfg, logger)
+	reporter.Finish(nil)
 
 	// Perform cleanup and exit
 	manager.Cleanup("standalone completion")
-	utils.Exit(cfg, logger, 0, "standalone successful completion")
+	utils.Exit(cfg, logger, utils.ExitSuccess, "standalone successful completion")
 	return nil // This line will never be reached due to os.Exit
 }
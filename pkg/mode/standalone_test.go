@@ -0,0 +1,60 @@
+package mode
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+func TestResetCleanKeepsPreservedPaths(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.InstallPath = t.TempDir()
+	cfg.DefaultBootstrapPath = filepath.Join(cfg.InstallPath, "bootstrap.json")
+	diagnostics := filepath.Join(cfg.InstallPath, "diagnostics")
+	cfg.CleanupPreserve = []string{diagnostics, cfg.DefaultBootstrapPath}
+	logger := utils.NewLoggerWithWriter(false, false, io.Discard)
+
+	files := map[string]bool{
+		filepath.Join(diagnostics, "sysdiagnose.txt"):             true,
+		filepath.Join(cfg.ItemLogDir(), "Setup.log"):              true,
+		cfg.DefaultBootstrapPath:                                  true,
+		filepath.Join(cfg.InstallPath, "setupassistant", "a.pkg"): false,
+		filepath.Join(cfg.InstallPath, "cache", "old"):            false,
+	}
+	for path := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cleanSignalFiles(cfg, logger); err != nil {
+		t.Fatal(err)
+	}
+	if err := clearCachedState(cfg, logger); err != nil {
+		t.Fatal(err)
+	}
+	for path, kept := range files {
+		if _, err := os.Stat(path); (err == nil) != kept {
+			t.Errorf("%s: kept = %v, want %v", path, err == nil, kept)
+		}
+	}
+
+	// With logs in scope, the per-item logs go too
+	cfg.CleanupScope = []string{config.CleanupInstallPath, config.CleanupLogs}
+	if err := cleanSignalFiles(cfg, logger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cfg.ItemLogDir()); !os.IsNotExist(err) {
+		t.Errorf("item logs kept with logs in scope: %v", err)
+	}
+	if _, err := os.Stat(diagnostics); err != nil {
+		t.Errorf("preserved path removed: %v", err)
+	}
+}
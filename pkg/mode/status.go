@@ -0,0 +1,284 @@
+package mode
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/ipc"
+	"github.com/go-installapplications/pkg/retry"
+	"github.com/go-installapplications/pkg/status"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// statusReport is what status mode prints. The JSON field names are part of
+// the --json output and are only ever added to.
+type statusReport struct {
+	Retry      *retry.RetryState   `json:"retry,omitempty"`
+	MaxRetries int                 `json:"max_retries"`
+	LastRun    *status.PlistStatus `json:"last_run,omitempty"`
+	Services   []serviceStatus     `json:"services"`
+	Agents     []agentStatus       `json:"agents"`
+	Logs       []logTail           `json:"logs"`
+	Errors     []string            `json:"errors,omitempty"` // state that could not be read
+}
+
+type serviceStatus struct {
+	Label     string `json:"label"`
+	Domain    string `json:"domain"`
+	Plist     string `json:"plist"`
+	Installed bool   `json:"installed"` // plist present
+	Loaded    bool   `json:"loaded"`
+}
+
+type agentStatus struct {
+	UID       string `json:"uid"`
+	Socket    string `json:"socket"`
+	Available bool   `json:"available"` // accepting connections
+}
+
+type logTail struct {
+	Path  string   `json:"path"`
+	Lines []string `json:"lines"`
+}
+
+// serviceLoaded is utils.ServiceLoaded; swapped out in tests.
+var serviceLoaded = utils.ServiceLoaded
+
+// RunStatus prints the retry state, the last run summary, whether the
+// launchd services are loaded, which agent sockets answer and the end of each
+// log, for triage on a user's machine. Nothing is changed. With StatusJSON
+// the same report is printed as JSON.
+func RunStatus(cfg *config.Config, logger utils.Logger) {
+	report := collectStatus(cfg, logger)
+	var err error
+	if cfg.StatusJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = writeStatus(os.Stdout, report)
+	}
+	if err != nil {
+		logger.Error("Failed to print status: %v", err)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "")
+	}
+}
+
+// collectStatus gathers the status report. Missing state is normal (e.g. no
+// run yet) and is left empty; state that exists but cannot be read is listed
+// in Errors.
+func collectStatus(cfg *config.Config, logger utils.Logger) statusReport {
+	report := statusReport{MaxRetries: retry.MaxRetries, Services: []serviceStatus{}, Agents: []agentStatus{}, Logs: []logTail{}}
+
+	state, err := retry.ReadState()
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("retry state: %v", err))
+	}
+	report.Retry = state
+
+	if cfg.StatusPlistPath != "" {
+		lastRun, err := status.ReadPlist(cfg.StatusPlistPath)
+		if err != nil && !os.IsNotExist(err) {
+			report.Errors = append(report.Errors, fmt.Sprintf("status plist: %v", err))
+		}
+		report.LastRun = lastRun
+	}
+
+	// The agent is checked in every GUI session; at the login window there
+	// is none to check.
+	uids, err := utils.GetGUISessionUIDs()
+	if err != nil {
+		logger.Debug("Could not list GUI sessions: %v", err)
+	}
+	services := []serviceStatus{{Label: cfg.LaunchDaemonIdentifier, Domain: "system", Plist: filepath.Join(launchDaemonsDir, cfg.LaunchDaemonIdentifier+".plist")}}
+	for _, uid := range uids {
+		services = append(services, serviceStatus{Label: cfg.LaunchAgentIdentifier, Domain: "gui/" + uid, Plist: filepath.Join(launchAgentsDir, cfg.LaunchAgentIdentifier+".plist")})
+	}
+	for _, svc := range services {
+		_, err := os.Stat(svc.Plist)
+		svc.Installed = err == nil
+		svc.Loaded = serviceLoaded(svc.Domain + "/" + svc.Label)
+		report.Services = append(report.Services, svc)
+	}
+
+	socks, _ := filepath.Glob(filepath.Join(ipc.SocketDir, "*", "agent-*.sock"))
+	sort.Strings(socks)
+	for _, sock := range socks {
+		uid := filepath.Base(filepath.Dir(sock))
+		agent := agentStatus{UID: uid, Socket: sock}
+		// Connecting without a request is treated by the agent as a probe
+		if conn, err := net.DialTimeout("unix", sock, 2*time.Second); err == nil {
+			_ = conn.Close()
+			agent.Available = true
+		}
+		report.Agents = append(report.Agents, agent)
+	}
+
+	for _, path := range statusLogPaths(cfg) {
+		lines, err := tailFile(path, cfg.StatusLogLines)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("log %s: %v", path, err))
+			continue
+		}
+		report.Logs = append(report.Logs, logTail{Path: path, Lines: lines})
+	}
+	return report
+}
+
+// statusLogPaths lists the log files to show, without duplicates.
+func statusLogPaths(cfg *config.Config) []string {
+	var paths []string
+	for _, p := range []string{cfg.LogFilePath, cfg.DefaultDaemonLogPath, cfg.DefaultAgentLogPath, cfg.DefaultStandaloneLogPath} {
+		if p != "" && !containsString(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// tailFile returns the last n lines of the file at path.
+func tailFile(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lines := []string{}
+	if n <= 0 {
+		return lines, nil
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// writeStatus prints report for a person reading it.
+func writeStatus(w io.Writer, report statusReport) error {
+	bw := bufio.NewWriter(w)
+	p := func(format string, args ...interface{}) { fmt.Fprintf(bw, format+"\n", args...) }
+
+	p("Retry state")
+	if report.Retry == nil {
+		p("  no failed attempts recorded")
+	} else {
+		if report.MaxRetries > 0 {
+			p("  attempts: %d/%d", report.Retry.Count, report.MaxRetries)
+		} else {
+			p("  attempts: %d (no limit)", report.Retry.Count)
+		}
+		if len(report.Retry.Failures) > 0 {
+			var failures []string
+			for _, category := range retry.Categories {
+				if n := report.Retry.Failures[category]; n > 0 {
+					failures = append(failures, fmt.Sprintf("%s=%d", category, n))
+				}
+			}
+			line := strings.Join(failures, " ")
+			if report.Retry.LastCategory != "" {
+				line += " (last: " + report.Retry.LastCategory + ")"
+			}
+			p("  failures: %s", line)
+		}
+		p("  first:    %s", report.Retry.FirstTry.Format(time.RFC3339))
+		p("  last:     %s", report.Retry.LastTry.Format(time.RFC3339))
+		if report.Retry.Reason != "" {
+			p("  reason:   %s", report.Retry.Reason)
+		}
+	}
+
+	p("")
+	p("Last run")
+	if run := report.LastRun; run == nil {
+		p("  no status recorded")
+	} else {
+		p("  %s (%s mode, run %s)", run.Status, run.Mode, run.RunID)
+		p("  started:  %s", run.StartTime.Format(time.RFC3339))
+		if run.EndTime != nil {
+			p("  ended:    %s", run.EndTime.Format(time.RFC3339))
+		} else {
+			p("  updated:  %s", run.LastUpdate.Format(time.RFC3339))
+		}
+		p("  items:    %d total, %d succeeded, %d failed, %d skipped, %d pending", run.Total, run.Succeeded, run.Failed, run.Skipped, run.Pending)
+		if run.OSVersion != "" {
+			p("  system:   macOS %s (%s), %s", run.OSVersion, run.OSBuild, run.Model)
+		}
+		if run.Error != "" {
+			p("  error:    %s", run.Error)
+		}
+		for _, item := range run.Items {
+			if item.Status == "failed" {
+				p("  ❌ %s: %s", item.Name, item.Error)
+			}
+		}
+		if len(run.RestartRequired) > 0 {
+			p("  restart:  required by %s", strings.Join(run.RestartRequired, ", "))
+		}
+		if run.CleanupSteps > 0 {
+			p("  cleanup:  %d steps, %d failed", run.CleanupSteps, len(run.CleanupErrors))
+		}
+		for _, e := range run.CleanupErrors {
+			p("  ⚠️  cleanup: %s", e)
+		}
+	}
+
+	p("")
+	p("Services")
+	for _, svc := range report.Services {
+		state := "not installed"
+		switch {
+		case svc.Loaded:
+			state = "loaded"
+		case svc.Installed:
+			state = "installed, not loaded"
+		}
+		p("  %s/%s: %s", svc.Domain, svc.Label, state)
+	}
+
+	p("")
+	p("Agent sockets")
+	if len(report.Agents) == 0 {
+		p("  none")
+	}
+	for _, agent := range report.Agents {
+		state := "not responding"
+		if agent.Available {
+			state = "accepting connections"
+		}
+		p("  UID %s (%s): %s", agent.UID, agent.Socket, state)
+	}
+
+	for _, tail := range report.Logs {
+		p("")
+		p("Last %d lines of %s", len(tail.Lines), tail.Path)
+		for _, line := range tail.Lines {
+			p("  %s", line)
+		}
+	}
+
+	if len(report.Errors) > 0 {
+		p("")
+		p("Could not read")
+		for _, e := range report.Errors {
+			p("  %s", e)
+		}
+	}
+	return bw.Flush()
+}
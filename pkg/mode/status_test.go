@@ -0,0 +1,104 @@
+package mode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/ipc"
+	"github.com/go-installapplications/pkg/status"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+func TestTailFile_LastLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.log")
+	var b strings.Builder
+	for i := 1; i <= 50; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	lines, err := tailFile(path, 3)
+	if err != nil {
+		t.Fatalf("tail: %v", err)
+	}
+	if strings.Join(lines, ",") != "line 48,line 49,line 50" {
+		t.Fatalf("lines = %v", lines)
+	}
+}
+
+func TestCollectStatus_ReportsStateAndRenders(t *testing.T) {
+	root := t.TempDir()
+	prevSockets, prevLoaded := ipc.SocketDir, serviceLoaded
+	t.Cleanup(func() { ipc.SocketDir, serviceLoaded = prevSockets, prevLoaded })
+	ipc.SocketDir = root
+	serviceLoaded = func(target string) bool { return strings.HasPrefix(target, "system/") }
+
+	cfg := config.NewConfig()
+	cfg.StatusPlistPath = filepath.Join(root, "status.plist")
+	cfg.DefaultDaemonLogPath = filepath.Join(root, "daemon.log")
+	cfg.DefaultAgentLogPath = filepath.Join(root, "missing-agent.log")
+	cfg.DefaultStandaloneLogPath = filepath.Join(root, "missing-standalone.log")
+	cfg.StatusLogLines = 2
+
+	logger := utils.NewLogger(false, false)
+	w := status.NewPlistWriter(cfg.StatusPlistPath, "daemon", "run-7", false, logger)
+	item := config.Item{Name: "Setup", Type: "rootscript"}
+	w.Start([]config.Item{item})
+	w.ItemFinished(item, fmt.Errorf("exit status 3"))
+	w.Finish(fmt.Errorf("setupassistant phase failed"))
+	if err := os.WriteFile(cfg.DefaultDaemonLogPath, []byte("a\nb\nc\n"), 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "501"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", ipc.AgentSocketPath("501", "run7"))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+
+	report := collectStatus(cfg, logger)
+	if report.LastRun == nil || report.LastRun.RunID != "run-7" || report.LastRun.Failed != 1 {
+		t.Fatalf("last run = %+v", report.LastRun)
+	}
+	if len(report.Services) == 0 || !report.Services[0].Loaded || report.Services[0].Domain != "system" {
+		t.Fatalf("services = %+v", report.Services)
+	}
+	if len(report.Agents) != 1 || report.Agents[0].UID != "501" || !report.Agents[0].Available {
+		t.Fatalf("agents = %+v", report.Agents)
+	}
+	if len(report.Logs) != 1 || strings.Join(report.Logs[0].Lines, ",") != "b,c" {
+		t.Fatalf("logs = %+v", report.Logs)
+	}
+
+	var out bytes.Buffer
+	if err := writeStatus(&out, report); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for _, want := range []string{"failed (daemon mode, run run-7)", "❌ Setup: exit status 3", "system/" + cfg.LaunchDaemonIdentifier + ": loaded", "UID 501", "accepting connections", "Last 2 lines of " + cfg.DefaultDaemonLogPath} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var decoded map[string]interface{}
+	_ = json.Unmarshal(data, &decoded)
+	for _, key := range []string{"last_run", "services", "agents", "logs", "max_retries"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("JSON missing %q: %s", key, data)
+		}
+	}
+}
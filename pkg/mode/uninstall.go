@@ -0,0 +1,151 @@
+package mode
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-installapplications/pkg/audit"
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/ipc"
+	"github.com/go-installapplications/pkg/retry"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// Where the launchd plists live. Variables so tests can redirect them.
+var (
+	launchDaemonsDir = "/Library/LaunchDaemons"
+	launchAgentsDir  = "/Library/LaunchAgents"
+)
+
+// RunUninstall removes go-installapplications from this Mac: it boots out the
+// LaunchDaemon, the remediation job and every user's LaunchAgent, then
+// deletes their plists, InstallPath (including the binary), RemediationPath,
+// the socket directory, the retry state, the status plist and, unless
+// KeepLogs is set, the logs.
+func RunUninstall(cfg *config.Config, logger utils.Logger) {
+	logger.Info("Starting uninstall mode")
+	if cfg.DryRun {
+		logger.Info("[DRY RUN] Nothing will be stopped or removed")
+	}
+
+	stopServicesForUninstall(cfg, logger)
+
+	if errs := removeInstallation(cfg, logger); len(errs) > 0 {
+		for _, err := range errs {
+			logger.Error("  - %v", err)
+		}
+		logger.Error("❌ Uninstall incomplete: %d paths could not be removed", len(errs))
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "uninstall incomplete")
+	}
+	logger.Info("✅ go-installapplications has been removed")
+}
+
+// stopServicesForUninstall boots out the agent in every GUI session (with
+// fast user switching each logged-in user has one) and then the daemon.
+// Services that are not loaded are skipped.
+func stopServicesForUninstall(cfg *config.Config, logger utils.Logger) {
+	agentPlist := filepath.Join(launchAgentsDir, cfg.LaunchAgentIdentifier+".plist")
+	daemonPlist := filepath.Join(launchDaemonsDir, cfg.LaunchDaemonIdentifier+".plist")
+	remediationPlist := filepath.Join(launchDaemonsDir, cfg.RemediationIdentifier()+".plist")
+
+	uids, err := utils.GetGUISessionUIDs()
+	if err != nil || len(uids) == 0 {
+		logger.Debug("Could not list GUI sessions, using the console user: %v", err)
+		uids = nil
+		if uid, err := utils.GetConsoleUserUID(); err == nil && uid != "" && uid != "0" {
+			uids = []string{uid}
+		}
+	}
+	type service struct{ label, domain, plist string }
+	var services []service
+	for _, uid := range uids {
+		services = append(services, service{label: "LaunchAgent (UID " + uid + ")", domain: "gui/" + uid, plist: agentPlist})
+	}
+	services = append(services, service{label: "LaunchDaemon", domain: "system", plist: daemonPlist},
+		service{label: "remediation LaunchDaemon", domain: "system", plist: remediationPlist})
+
+	for _, svc := range services {
+		if cfg.DryRun {
+			logger.Info("[DRY RUN] Would boot out %s: %s", svc.label, svc.plist)
+			continue
+		}
+		if err := utils.Bootout(svc.domain, svc.plist); err != nil {
+			logger.Debug("%s not stopped (may not be loaded): %v", svc.label, err)
+		} else {
+			logger.Info("✅ Stopped %s", svc.label)
+		}
+	}
+}
+
+// removeInstallation deletes everything go-installapplications wrote to the
+// machine and returns the paths that could not be removed. Paths that do not
+// exist are skipped.
+func removeInstallation(cfg *config.Config, logger utils.Logger) []error {
+	paths := []string{
+		filepath.Join(launchDaemonsDir, cfg.LaunchDaemonIdentifier+".plist"),
+		filepath.Join(launchAgentsDir, cfg.LaunchAgentIdentifier+".plist"),
+		filepath.Join(launchDaemonsDir, cfg.RemediationIdentifier()+".plist"),
+		cfg.InstallPath,
+		cfg.RemediationPath,
+		// Agent sockets and their IPC keys
+		ipc.SocketDir,
+		cfg.StatusPlistPath,
+	}
+	logs := []string{
+		cfg.DefaultDaemonLogPath,
+		cfg.DefaultAgentLogPath,
+		cfg.DefaultStandaloneLogPath,
+		cfg.LogFilePath,
+		cfg.AuditLogPath,
+	}
+	if cfg.KeepLogs {
+		logger.Info("Keeping log files (--keep-logs)")
+	} else {
+		paths = append(paths, logs...)
+	}
+
+	var errs []error
+	for _, path := range paths {
+		if err := removeForUninstall(path, cfg.DryRun, logger); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if !cfg.DryRun {
+		if err := retry.ClearRetryCount(); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("failed to clear retry state: %w", err))
+		}
+	}
+	if !cfg.KeepLogs && !cfg.DryRun {
+		// Log directories are removed only once empty
+		for _, path := range logs {
+			if path != "" {
+				_ = os.Remove(filepath.Dir(path))
+			}
+		}
+	}
+	return errs
+}
+
+// removeForUninstall removes path (recursively) and records it in the audit
+// log.
+func removeForUninstall(path string, dryRun bool, logger utils.Logger) error {
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		logger.Debug("Not present: %s", path)
+		return nil
+	}
+	if dryRun {
+		logger.Info("[DRY RUN] Would remove %s", path)
+		return nil
+	}
+	err := os.RemoveAll(path)
+	audit.Record(audit.Event{Action: audit.ActionFileRemove, Target: path, Outcome: audit.Outcome(err), Error: audit.ErrorString(err), Details: map[string]string{"reason": "uninstall"}})
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	logger.Info("🗑️  Removed %s", path)
+	return nil
+}
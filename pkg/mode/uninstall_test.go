@@ -0,0 +1,97 @@
+package mode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/ipc"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// uninstallFixture lays out an installation under a temp root and returns a
+// config pointing at it.
+func uninstallFixture(t *testing.T) (*config.Config, map[string]string) {
+	t.Helper()
+	root := t.TempDir()
+	prevDaemons, prevAgents, prevSockets := launchDaemonsDir, launchAgentsDir, ipc.SocketDir
+	t.Cleanup(func() { launchDaemonsDir, launchAgentsDir, ipc.SocketDir = prevDaemons, prevAgents, prevSockets })
+	launchDaemonsDir = filepath.Join(root, "LaunchDaemons")
+	launchAgentsDir = filepath.Join(root, "LaunchAgents")
+	ipc.SocketDir = filepath.Join(root, "sockets")
+
+	cfg := config.NewConfig()
+	cfg.InstallPath = filepath.Join(root, "install")
+	cfg.RemediationPath = filepath.Join(root, "remediation")
+	cfg.StatusPlistPath = filepath.Join(root, "status.plist")
+	cfg.DefaultDaemonLogPath = filepath.Join(root, "log", "daemon.log")
+	cfg.DefaultAgentLogPath = filepath.Join(root, "log", "agent.log")
+	cfg.DefaultStandaloneLogPath = filepath.Join(root, "log", "standalone.log")
+	cfg.AuditLogPath = filepath.Join(root, "log", "audit.log")
+
+	files := map[string]string{
+		"daemon plist":      filepath.Join(launchDaemonsDir, cfg.LaunchDaemonIdentifier+".plist"),
+		"agent plist":       filepath.Join(launchAgentsDir, cfg.LaunchAgentIdentifier+".plist"),
+		"binary":            filepath.Join(cfg.InstallPath, "go-installapplications"),
+		"remediation":       filepath.Join(cfg.RemediationPath, "bootstrap.json"),
+		"remediation plist": filepath.Join(launchDaemonsDir, cfg.RemediationIdentifier()+".plist"),
+		"socket key":        filepath.Join(ipc.SocketDir, "501", "agent-run1.key"),
+		"status":            cfg.StatusPlistPath,
+		"daemon log":        cfg.DefaultDaemonLogPath,
+		"audit log":         cfg.AuditLogPath,
+	}
+	for _, p := range files {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	return cfg, files
+}
+
+func TestRemoveInstallation_RemovesEverything(t *testing.T) {
+	cfg, files := uninstallFixture(t)
+	if errs := removeInstallation(cfg, utils.NewLogger(false, false)); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	for name, p := range files {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s still present at %s", name, p)
+		}
+	}
+	if _, err := os.Stat(filepath.Dir(cfg.AuditLogPath)); !os.IsNotExist(err) {
+		t.Errorf("empty log directory not removed")
+	}
+}
+
+func TestRemoveInstallation_KeepLogs(t *testing.T) {
+	cfg, files := uninstallFixture(t)
+	cfg.KeepLogs = true
+	if errs := removeInstallation(cfg, utils.NewLogger(false, false)); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	for _, name := range []string{"daemon log", "audit log"} {
+		if _, err := os.Stat(files[name]); err != nil {
+			t.Errorf("%s should be kept: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(files["binary"]); !os.IsNotExist(err) {
+		t.Errorf("InstallPath should still be removed")
+	}
+}
+
+func TestRemoveInstallation_DryRunRemovesNothing(t *testing.T) {
+	cfg, files := uninstallFixture(t)
+	cfg.DryRun = true
+	if errs := removeInstallation(cfg, utils.NewLogger(false, false)); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	for name, p := range files {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%s removed in dry run: %v", name, err)
+		}
+	}
+}
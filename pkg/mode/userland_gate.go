@@ -0,0 +1,192 @@
+package mode
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/installer"
+	"github.com/go-installapplications/pkg/progress"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// Session probes; swapped out in tests.
+var (
+	desktopReady        = utils.DesktopReady
+	fileVaultDeferred   = utils.FileVaultDeferred
+	desktopPollInterval = 2 * time.Second
+)
+
+// gateUserland waits for what cfg.UserlandGatePolicy requires before the
+// userland items run and returns the router for user items (nil when there
+// are none and nothing to wait for). The progress UI is attached to the
+// agent if one is up.
+func gateUserland(needsAgent bool, reporter progress.Reporter, cfg *config.Config, logger utils.Logger) (*agentRouter, error) {
+	policy := cfg.UserlandGatePolicy
+	switch policy {
+	case config.UserlandGateAgent, config.UserlandGateLogin, config.UserlandGateDeadline:
+	default:
+		logger.Info("⚠️  Unknown UserlandGatePolicy %q; using %q", policy, config.UserlandGateAgent)
+		policy = config.UserlandGateAgent
+	}
+
+	if policy == config.UserlandGateLogin {
+		logger.Info("Waiting for a console user before processing userland (UserlandGatePolicy=login)")
+		uid, err := waitForConsoleUser(logger, cfg.WaitForAgentTimeout)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("User logged in (UID %s); user items run via launchctl asuser", uid)
+		if err := waitForDesktop(cfg.WaitForAgentTimeout, cfg, logger); err != nil {
+			return nil, err
+		}
+		attachAgentProgressIfReady(reporter, cfg, logger)
+		router := newAgentRouter(cfg, logger)
+		router.asUser = true
+		return router, nil
+	}
+
+	if !needsAgent {
+		logger.Debug("No user-context items in userland; skipping wait for agent socket")
+		// Don't hold root-only userland for the UI; show it only if an agent is already up.
+		attachAgentProgressIfReady(reporter, cfg, logger)
+		return nil, nil
+	}
+
+	// The console user is re-resolved for every user item (fast user
+	// switching); the progress UI stays with the session it first opened in.
+	router := newAgentRouter(cfg, logger)
+	timeout := cfg.WaitForAgentTimeout
+	if policy == config.UserlandGateDeadline {
+		timeout = cfg.UserlandGateDeadline
+		logger.Info("Waiting up to %s for GUI login and agent readiness to process userland phase", timeout)
+		router.wait = func() (string, string, error) {
+			return waitForConsoleAgent(logger, timeout)
+		}
+	} else {
+		logger.Info("Waiting for GUI login and agent readiness to process userland phase")
+	}
+	var sockPath string
+	err := waitForDesktop(timeout, cfg, logger)
+	if err == nil {
+		_, sockPath, err = router.route()
+	}
+	if err != nil {
+		if policy != config.UserlandGateDeadline || shutdownCtx.Err() != nil {
+			return nil, err
+		}
+		logger.Info("⚠️  No agent after %s (%v); running user items best-effort via launchctl asuser", cfg.UserlandGateDeadline, err)
+		router.asUser, router.bestEffort = true, true
+		return router, nil
+	}
+	attachProgressUI(reporter, newAgentDisplay(sockPath, cfg, logger), logger)
+	return router, nil
+}
+
+// waitForDesktop holds user items until the console user's login has
+// reached the desktop, if cfg asks for it or FileVault will be enabled at the
+// next login: that login shows the FileVault prompt and may restart the Mac,
+// so Setup Assistant's first session is not the one to deliver to.
+func waitForDesktop(timeout time.Duration, cfg *config.Config, logger utils.Logger) error {
+	if !cfg.UserlandWaitForDesktop {
+		if !fileVaultDeferred() {
+			return nil
+		}
+		logger.Info("🔐 FileVault will be enabled at the next login; holding user items until the desktop is up")
+	}
+	start := time.Now()
+	logged := false
+	for {
+		uid, err := consoleUID()
+		if err != nil {
+			return err
+		}
+		if isUserUID(uid) && desktopReady(uid) {
+			if logged {
+				logger.Info("Desktop is up for UID %s", uid)
+			}
+			return nil
+		}
+		if !logged {
+			logger.Info("Waiting for the console user's first full login to reach the desktop")
+			logged = true
+		}
+		if shutdownCtx.Err() != nil {
+			return context.Cause(shutdownCtx)
+		}
+		if time.Since(start) > timeout {
+			return fmt.Errorf("timeout waiting for a user's desktop")
+		}
+		time.Sleep(desktopPollInterval)
+	}
+}
+
+func attachAgentProgressIfReady(reporter progress.Reporter, cfg *config.Config, logger utils.Logger) {
+	if p := agentSocketIfReady(); p != "" {
+		attachProgressUI(reporter, newAgentDisplay(p, cfg, logger), logger)
+	}
+}
+
+// runUserItemAsUser runs a userscript or places a userfile from the daemon
+// for the console user, as standalone mode does, without an agent. In
+// best-effort mode failures (including nobody being logged in) are logged
+// and the item is treated as done.
+func runUserItemAsUser(item config.Item, bestEffort bool, si *installer.SystemInstaller, cfg *config.Config, logger utils.Logger) userlandResult {
+	res := userlandResult{operation: "script execution"}
+	if item.Type == "userfile" {
+		res.operation = "file placement"
+	}
+
+	uid, err := consoleUID()
+	if err == nil && !isUserUID(uid) {
+		err = fmt.Errorf("no user is logged in")
+	}
+	if err == nil {
+		switch item.Type {
+		case "userscript":
+			uc := installer.UserContext{InstallPath: cfg.InstallPath, ItemName: item.Name}
+			err = si.ExecuteUserScript(item.File, uc, item.DoNotWait, cfg.TrackBackgroundProcesses)
+			if err == nil && item.DoNotWait && cfg.TrackBackgroundProcesses {
+				res.daemonBg = 1
+			}
+		case "userfile":
+			err = placeUserFileAsRoot(item, uid, si, cfg, logger)
+		}
+	}
+
+	if err != nil {
+		if bestEffort {
+			logger.Info("⚠️  %s skipped for %s (best-effort without agent): %v", res.operation, item.Name, err)
+			return res
+		}
+		res.err = err
+		return res
+	}
+	logger.Info("✅ %s done via launchctl asuser: %s", item.Type, item.Name)
+	return res
+}
+
+// placeUserFileAsRoot moves a staged userfile into place, hands it to uid and
+// sets its permissions. "~/" destinations need the agent.
+func placeUserFileAsRoot(item config.Item, uid string, si *installer.SystemInstaller, cfg *config.Config, logger utils.Logger) error {
+	if strings.HasPrefix(item.File, "~/") {
+		return fmt.Errorf("%s can only be placed by the agent", item.File)
+	}
+	if item.Delivered() {
+		if item.Backup {
+			if err := backupUserFile(item, cfg, logger); err != nil {
+				return err
+			}
+		}
+		_, dirMode := userFileOptions(item)
+		if err := moveFile(userFileStagingPath(item, cfg), item.File, dirMode); err != nil {
+			return fmt.Errorf("failed to place user file %s: %w", item.Name, err)
+		}
+	}
+	if err := changeFileOwnershipToUser(item.File, uid, cfg, logger); err != nil {
+		return err
+	}
+	return si.PlaceFile(item.File, "userfile", installer.FileOptionsFor(item))
+}
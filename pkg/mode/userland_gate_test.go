@@ -0,0 +1,117 @@
+package mode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/progress"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+func stubConsoleUID(t *testing.T, uid string) {
+	t.Helper()
+	prev := consoleUID
+	t.Cleanup(func() { consoleUID = prev })
+	consoleUID = func() (string, error) { return uid, nil }
+}
+
+func TestGateUserland_DeadlineFallsBackToAsUser(t *testing.T) {
+	stubConsoleUID(t, "0") // nobody logs in
+	stubSession(t, false, func(string) bool { return false })
+	cfg := config.NewConfig()
+	cfg.UserlandGatePolicy = config.UserlandGateDeadline
+	cfg.UserlandGateDeadline = 10 * time.Millisecond
+
+	router, err := gateUserland(true, progress.Nop{}, cfg, utils.NewLogger(false, false))
+	if err != nil {
+		t.Fatalf("gate: %v", err)
+	}
+	if router == nil || !router.asUser || !router.bestEffort {
+		t.Fatalf("router = %+v; want best-effort asuser routing", router)
+	}
+}
+
+func TestGateUserland_LoginPolicyRunsAsUser(t *testing.T) {
+	stubConsoleUID(t, "501")
+	stubSession(t, false, func(string) bool { return true })
+	cfg := config.NewConfig()
+	cfg.UserlandGatePolicy = config.UserlandGateLogin
+
+	// Root-only userland waits for the login too
+	router, err := gateUserland(false, progress.Nop{}, cfg, utils.NewLogger(false, false))
+	if err != nil {
+		t.Fatalf("gate: %v", err)
+	}
+	if router == nil || !router.asUser || router.bestEffort {
+		t.Fatalf("router = %+v; want asuser routing that fails on errors", router)
+	}
+}
+
+func TestRunUserItemAsUser_NoUser(t *testing.T) {
+	stubConsoleUID(t, utils.SetupAssistantUID)
+	cfg := config.NewConfig()
+	item := config.Item{Name: "Dock", Type: "userscript", File: "/tmp/dock.sh"}
+	logger := utils.NewLogger(false, false)
+
+	if res := runUserItemAsUser(item, true, nil, cfg, logger); res.err != nil {
+		t.Fatalf("best-effort: err = %v", res.err)
+	}
+	res := runUserItemAsUser(item, false, nil, cfg, logger)
+	if res.err == nil || res.operation != "script execution" {
+		t.Fatalf("res = %+v; want no-user error", res)
+	}
+}
+
+// stubSession replaces the desktop probes; ready is called with the console
+// UID on every poll.
+func stubSession(t *testing.T, fvDeferred bool, ready func(uid string) bool) {
+	t.Helper()
+	prevReady, prevFV, prevPoll := desktopReady, fileVaultDeferred, desktopPollInterval
+	t.Cleanup(func() { desktopReady, fileVaultDeferred, desktopPollInterval = prevReady, prevFV, prevPoll })
+	desktopReady = ready
+	fileVaultDeferred = func() bool { return fvDeferred }
+	desktopPollInterval = time.Millisecond
+}
+
+func TestWaitForDesktop_SkipsSetupAssistantSession(t *testing.T) {
+	uids := []string{utils.SetupAssistantUID, "0", "501", "501"}
+	prev := consoleUID
+	t.Cleanup(func() { consoleUID = prev })
+	consoleUID = func() (string, error) {
+		uid := uids[0]
+		if len(uids) > 1 {
+			uids = uids[1:]
+		}
+		return uid, nil
+	}
+	var checked []string
+	stubSession(t, false, func(uid string) bool {
+		checked = append(checked, uid)
+		return len(checked) > 1 // Dock not up on the first poll after login
+	})
+	cfg := config.NewConfig()
+	cfg.UserlandWaitForDesktop = true
+
+	if err := waitForDesktop(time.Minute, cfg, utils.NewLogger(false, false)); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if len(checked) != 2 || checked[0] != "501" {
+		t.Fatalf("desktop checked for %v; want only UID 501, twice", checked)
+	}
+}
+
+func TestWaitForDesktop_FileVaultDeferredImpliesWait(t *testing.T) {
+	stubConsoleUID(t, "501")
+	cfg := config.NewConfig()
+	logger := utils.NewLogger(false, false)
+
+	stubSession(t, false, func(string) bool { return false })
+	if err := waitForDesktop(10*time.Millisecond, cfg, logger); err != nil {
+		t.Fatalf("not requested: err = %v", err)
+	}
+	stubSession(t, true, func(string) bool { return false })
+	if err := waitForDesktop(10*time.Millisecond, cfg, logger); err == nil {
+		t.Fatal("FileVault deferred: expected to wait for the desktop and time out")
+	}
+}
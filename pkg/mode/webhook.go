@@ -0,0 +1,128 @@
+package mode
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+	"github.com/go-installapplications/pkg/webhook"
+)
+
+// webhookOnlyFlags are the flags of webhook mode itself; they are not passed
+// on to the runs it starts.
+var webhookOnlyFlags = map[string]bool{
+	"mode":                   true,
+	"profile-domain":         true,
+	"webhook-listen":         true,
+	"webhook-profile-domain": true,
+	"print-config":           true,
+	"version":                true,
+}
+
+// WebhookRunFlags returns the flags visit reports, such as --compat or
+// --installpath given to webhook mode, as --name=value for the runs it
+// starts, leaving out webhookOnlyFlags. Pass flag.Visit.
+func WebhookRunFlags(visit func(func(*flag.Flag))) []string {
+	var args []string
+	visit(func(f *flag.Flag) {
+		if !webhookOnlyFlags[f.Name] {
+			args = append(args, "--"+f.Name+"="+f.Value.String())
+		}
+	})
+	return args
+}
+
+// RunWebhook serves the MDM webhook endpoint and starts a standalone run
+// (this binary with --mode standalone and runFlags, see WebhookRunFlags)
+// whenever this device enrolls. It is meant to run from its own
+// LaunchDaemon and never returns on its own.
+func RunWebhook(cfg *config.Config, logger utils.Logger, runFlags []string) {
+	logger.Info("Starting webhook mode")
+
+	if cfg.WebhookListenAddress == "" || cfg.WebhookSecret == "" {
+		logger.Error("Webhook mode requires WebhookListenAddress and WebhookSecret")
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitConfig, "")
+	}
+	useTLS := cfg.WebhookTLSCertFile != "" && cfg.WebhookTLSKeyFile != ""
+	if !useTLS && (cfg.WebhookTLSCertFile != "" || cfg.WebhookTLSKeyFile != "") {
+		logger.Error("WebhookTLSCertFile and WebhookTLSKeyFile must be set together")
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitConfig, "")
+	}
+	// The secret guards a root bootstrap run, so it only travels in cleartext
+	// on this Mac
+	if !useTLS && !webhook.LoopbackAddress(cfg.WebhookListenAddress) {
+		logger.Error("Webhook mode requires WebhookTLSCertFile and WebhookTLSKeyFile unless WebhookListenAddress is a loopback address (got %q)", cfg.WebhookListenAddress)
+		utils.ExitWithoutCleanup(cfg, logger, utils.ExitConfig, "")
+	}
+
+	udids := cfg.WebhookUDIDs
+	if len(udids) == 0 {
+		if uuid, err := utils.GetHardwareUUID(); err == nil {
+			udids = []string{uuid}
+		} else {
+			logger.Info("⚠️  Could not read hardware UUID, accepting events for any device: %v", err)
+		}
+	}
+	topics := cfg.WebhookTopics
+	if len(topics) == 0 {
+		topics = webhook.DefaultTopics
+	}
+	domain := cfg.WebhookProfileDomain
+	if domain == "" {
+		domain = cfg.ProfileDomain
+	}
+
+	handler := &webhook.Handler{
+		Secret:  cfg.WebhookSecret,
+		UDIDs:   udids,
+		Topics:  topics,
+		Trigger: func() (<-chan struct{}, error) { return startStandaloneRun(domain, runFlags, logger) },
+		Logger:  logger,
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/webhook", handler)
+	server := &http.Server{Addr: cfg.WebhookListenAddress, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	logger.Info("🪝 Listening for MDM webhooks on %s/webhook (topics: %v, profile domain: %s)", cfg.WebhookListenAddress, topics, domain)
+	var err error
+	if useTLS {
+		err = server.ListenAndServeTLS(cfg.WebhookTLSCertFile, cfg.WebhookTLSKeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	logger.Error("Webhook listener stopped: %v", err)
+	utils.ExitWithoutCleanup(cfg, logger, utils.ExitFailure, "")
+}
+
+// startStandaloneRun launches this binary in standalone mode against the
+// given profile domain, with runFlags. The returned channel is closed when
+// the run exits.
+func startStandaloneRun(domain string, runFlags []string, logger utils.Logger) (<-chan struct{}, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate executable: %w", err)
+	}
+	cmd := exec.Command(self, append([]string{"--mode", "standalone", "--profile-domain", domain}, runFlags...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start standalone run: %w", err)
+	}
+	logger.Debug("Standalone run started (pid %d)", cmd.Process.Pid)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := cmd.Wait(); err != nil {
+			logger.Info("⚠️  Standalone run exited with error: %v", err)
+			return
+		}
+		logger.Info("✅ Standalone run completed")
+	}()
+	return done, nil
+}
@@ -0,0 +1,26 @@
+package mode
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestWebhookRunFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.String("mode", "", "")
+	fs.String("profile-domain", "", "")
+	fs.String("webhook-listen", "", "")
+	fs.Bool("compat", false, "")
+	fs.Bool("debug", false, "")
+	fs.Int("max-retries", 3, "")
+	if err := fs.Parse([]string{"--mode", "webhook", "--compat", "--profile-domain", "com.example", "--webhook-listen", ":8443", "--max-retries", "5"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"--compat=true", "--max-retries=5"}
+	if got := WebhookRunFlags(fs.Visit); !reflect.DeepEqual(got, want) {
+		t.Errorf("WebhookRunFlags = %v, want %v", got, want)
+	}
+}
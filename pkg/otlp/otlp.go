@@ -0,0 +1,82 @@
+// Package otlp holds the small subset of the OTLP/HTTP JSON encoding shared by
+// the metrics and tracing exporters. It avoids pulling in the OpenTelemetry
+// SDK for the handful of fields we emit.
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/go-installapplications/pkg/version"
+)
+
+// KeyValue is an OTLP attribute. Only string values are used.
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue is an OTLP attribute value.
+type AnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// String returns a string attribute.
+func String(key, value string) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{StringValue: value}}
+}
+
+// Resource describes the process emitting telemetry.
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// NewResource returns a resource identified by service name and instance.
+func NewResource(serviceName, instance string) Resource {
+	return Resource{Attributes: []KeyValue{
+		String("service.name", serviceName),
+		String("service.instance.id", instance),
+	}}
+}
+
+// Scope is the instrumentation scope.
+type Scope struct {
+	Name string `json:"name"`
+}
+
+// Hostname returns the machine's hostname or "unknown".
+func Hostname() string {
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "unknown"
+}
+
+// Post sends payload as JSON to an OTLP/HTTP endpoint.
+func Post(client *http.Client, url string, headers map[string]string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode OTLP payload: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("OTLP export failed with status: %d", resp.StatusCode)
+	}
+	return nil
+}
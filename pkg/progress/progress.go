@@ -0,0 +1,143 @@
+package progress
+
+import "github.com/go-installapplications/pkg/config"
+
+// Reporter receives item lifecycle events from the phase manager and the
+// daemon's userland loop so they can be surfaced to the logged-in user.
+// Implementations must be safe for concurrent use: items in a parallel_group
+// report from separate goroutines.
+type Reporter interface {
+	// Start is called once with every item the run may process, in order.
+	Start(items []config.Item)
+	// ItemStarted is called right before an item is installed/executed.
+	ItemStarted(item config.Item)
+	// ItemFinished is called after an item completes; err is nil on success.
+	ItemFinished(item config.Item, err error)
+	// ItemSkipped is called when an item is filtered out (skip_if, receipts).
+	ItemSkipped(item config.Item, reason string)
+	// Finish is called once when the run ends; err is nil on success.
+	Finish(err error)
+}
+
+// Item statuses carried in State.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// ItemState is one entry of a progress display.
+type ItemState struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Percent is how far a running item has got, when it reports progress
+	Percent int `json:"percent,omitempty"`
+}
+
+// State is a full snapshot of the run's progress. Displays receive the whole
+// snapshot on every change so a missed update is repaired by the next one.
+type State struct {
+	Title     string      `json:"title,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	Items     []ItemState `json:"items"`
+	Completed int         `json:"completed"`
+	Text      string      `json:"text,omitempty"`
+	Done      bool        `json:"done,omitempty"`
+	Failed    bool        `json:"failed,omitempty"`
+}
+
+// Display renders progress in the user's session. Implementations wrap a
+// specific UI helper (swiftDialog) or forward to one over IPC.
+type Display interface {
+	// Show opens the UI with the initial state.
+	Show(state State) error
+	// Update applies a new snapshot to an open UI.
+	Update(state State) error
+	// Dismiss applies the final snapshot and closes (or unlocks) the UI.
+	Dismiss(state State) error
+}
+
+// Attacher is implemented by reporters that can drive a Display. Modes attach
+// a display once a user session is available.
+type Attacher interface {
+	Attach(d Display) error
+}
+
+// ItemProgresser is implemented by reporters that show how far a running
+// item has got, e.g. a package install reporting installer(8) percentages.
+type ItemProgresser interface {
+	// ItemProgress is called while item runs with its percent complete and a
+	// line of text describing the step, which may be empty.
+	ItemProgress(item config.Item, percent int, text string)
+}
+
+// Launcher starts a UI helper binary with the given arguments in the console
+// user's GUI session (e.g. directly from the agent or via launchctl asuser).
+type Launcher func(path string, args []string) error
+
+// Nop is a Reporter that discards every event.
+type Nop struct{}
+
+func (Nop) Start([]config.Item)             {}
+func (Nop) ItemStarted(config.Item)         {}
+func (Nop) ItemFinished(config.Item, error) {}
+func (Nop) ItemSkipped(config.Item, string) {}
+func (Nop) Finish(error)                    {}
+
+// Multi fans events out to several reporters, e.g. the progress UI and the
+// metrics recorder. Attach and ItemProgress are forwarded to every reporter
+// that supports them.
+type Multi []Reporter
+
+func (m Multi) Start(items []config.Item) {
+	for _, r := range m {
+		r.Start(items)
+	}
+}
+
+func (m Multi) ItemStarted(item config.Item) {
+	for _, r := range m {
+		r.ItemStarted(item)
+	}
+}
+
+func (m Multi) ItemFinished(item config.Item, err error) {
+	for _, r := range m {
+		r.ItemFinished(item, err)
+	}
+}
+
+func (m Multi) ItemSkipped(item config.Item, reason string) {
+	for _, r := range m {
+		r.ItemSkipped(item, reason)
+	}
+}
+
+func (m Multi) Finish(err error) {
+	for _, r := range m {
+		r.Finish(err)
+	}
+}
+
+// ItemProgress forwards progress to every member that shows it.
+func (m Multi) ItemProgress(item config.Item, percent int, text string) {
+	for _, r := range m {
+		if p, ok := r.(ItemProgresser); ok {
+			p.ItemProgress(item, percent, text)
+		}
+	}
+}
+
+// Attach attaches d to every member that drives a display.
+func (m Multi) Attach(d Display) error {
+	for _, r := range m {
+		if a, ok := r.(Attacher); ok {
+			if err := a.Attach(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
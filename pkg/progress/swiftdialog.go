@@ -0,0 +1,220 @@
+package progress
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/go-installapplications/pkg/utils"
+)
+
+const (
+	// DefaultSwiftDialogPath is where the swiftDialog pkg installs its CLI shim.
+	DefaultSwiftDialogPath = "/usr/local/bin/dialog"
+	// DefaultSwiftDialogCommandFile is the command file swiftDialog watches.
+	DefaultSwiftDialogCommandFile = "/var/tmp/dialog.log"
+)
+
+// SwiftDialogOptions configures the swiftDialog display. Empty values fall
+// back to the defaults above.
+type SwiftDialogOptions struct {
+	Path        string
+	CommandFile string
+	Icon        string
+	ExtraArgs   []string
+	// Launch starts the dialog process; required.
+	Launch Launcher
+}
+
+// SwiftDialog is a Display that renders progress as a swiftDialog list. Each
+// item is a list entry whose status icon is updated via the dialog command
+// file; the progress bar advances as items complete.
+type SwiftDialog struct {
+	opts   SwiftDialogOptions
+	logger utils.Logger
+
+	mu   sync.Mutex
+	last State // last snapshot written, used to emit only changed entries
+}
+
+// NewSwiftDialog creates a swiftDialog display. The dialog itself is not
+// started until Show is called.
+func NewSwiftDialog(opts SwiftDialogOptions, logger utils.Logger) *SwiftDialog {
+	if opts.Path == "" {
+		opts.Path = DefaultSwiftDialogPath
+	}
+	if opts.CommandFile == "" {
+		opts.CommandFile = DefaultSwiftDialogCommandFile
+	}
+	return &SwiftDialog{opts: opts, logger: logger}
+}
+
+// Show resets the command file and launches the dialog with the given state.
+func (d *SwiftDialog) Show(state State) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := os.Stat(d.opts.Path); err != nil {
+		return fmt.Errorf("swiftDialog not found at %s: %w", d.opts.Path, err)
+	}
+	if d.opts.Launch == nil {
+		return fmt.Errorf("no launcher configured for swiftDialog")
+	}
+	if err := utils.EnsureDirForFile(d.opts.CommandFile); err != nil {
+		return fmt.Errorf("failed to create command file directory: %w", err)
+	}
+	f, err := openCommandFile(d.opts.CommandFile)
+	if err == nil {
+		err = f.Truncate(0)
+		f.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reset command file %s: %w", d.opts.CommandFile, err)
+	}
+
+	args := d.launchArgs(state)
+	d.logger.Info("Launching swiftDialog progress window")
+	d.logger.Debug("swiftDialog args: %v", args)
+	if err := d.opts.Launch(d.opts.Path, args); err != nil {
+		return fmt.Errorf("failed to launch swiftDialog: %w", err)
+	}
+	d.last = copyState(state)
+	return nil
+}
+
+// launchArgs builds the dialog command line for the initial state.
+func (d *SwiftDialog) launchArgs(state State) []string {
+	args := []string{
+		"--title", state.Title,
+		"--message", state.Message,
+		"--commandfile", d.opts.CommandFile,
+		"--progress", fmt.Sprintf("%d", len(state.Items)),
+		"--button1text", "Done",
+		"--button1disabled",
+		"--ontop",
+		"--moveable",
+	}
+	if d.opts.Icon != "" {
+		args = append(args, "--icon", d.opts.Icon)
+	}
+	for _, it := range state.Items {
+		status, text := dialogStatus(it)
+		args = append(args, "--listitem", fmt.Sprintf("%s,status=%s,statustext=%s", sanitizeListValue(it.Name), status, text))
+	}
+	return append(args, d.opts.ExtraArgs...)
+}
+
+// Update writes commands for every entry that changed since the last state.
+func (d *SwiftDialog) Update(state State) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.writeLocked(d.diffLocked(state)...)
+}
+
+// Dismiss closes the dialog on success; on failure it leaves the window open
+// with the Done button enabled so the user can read which item failed.
+func (d *SwiftDialog) Dismiss(state State) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lines := d.diffLocked(state)
+	if state.Failed {
+		lines = append(lines, "button1: enable")
+	} else {
+		lines = append(lines, "progress: complete", "quit:")
+	}
+	return d.writeLocked(lines...)
+}
+
+// diffLocked returns the commands that move the dialog from d.last to state
+// and records state as the new baseline; callers must hold d.mu.
+func (d *SwiftDialog) diffLocked(state State) []string {
+	var lines []string
+	for i, it := range state.Items {
+		if i < len(d.last.Items) && d.last.Items[i] == it {
+			continue
+		}
+		status, text := dialogStatus(it)
+		lines = append(lines, fmt.Sprintf("listitem: index: %d, status: %s, statustext: %s", i, status, text))
+	}
+	if state.Completed != d.last.Completed {
+		lines = append(lines, fmt.Sprintf("progress: %d", state.Completed))
+	}
+	if state.Text != "" && state.Text != d.last.Text {
+		lines = append(lines, "progresstext: "+state.Text)
+	}
+	d.last = copyState(state)
+	return lines
+}
+
+// writeLocked appends commands to the command file; callers must hold d.mu.
+func (d *SwiftDialog) writeLocked(lines ...string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	f, err := openCommandFile(d.opts.CommandFile)
+	if err != nil {
+		return fmt.Errorf("failed to open command file: %w", err)
+	}
+	defer f.Close()
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(f, line); err != nil {
+			return fmt.Errorf("failed to write command: %w", err)
+		}
+	}
+	return nil
+}
+
+// openCommandFile opens the command file for appending, creating it if
+// needed. The default lives in world-writable /var/tmp, so a symlink planted
+// there is not followed, and a FIFO or a file owned by anyone but us is
+// refused rather than written to as root.
+func openCommandFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_WRONLY|os.O_APPEND|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !info.Mode().IsRegular() || !ok || int(st.Uid) != os.Geteuid() {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a regular file owned by uid %d", path, os.Geteuid())
+	}
+	return f, nil
+}
+
+// dialogStatus maps an item's progress status to swiftDialog's list status
+// and text.
+func dialogStatus(it ItemState) (string, string) {
+	switch it.Status {
+	case StatusRunning:
+		if it.Percent > 0 {
+			return "wait", fmt.Sprintf("Installing %d%%", it.Percent)
+		}
+		return "wait", "Installing"
+	case StatusSuccess:
+		return "success", "Installed"
+	case StatusFailed:
+		return "fail", "Failed"
+	case StatusSkipped:
+		return "success", "Skipped"
+	default:
+		return "pending", "Pending"
+	}
+}
+
+func copyState(s State) State {
+	s.Items = append([]ItemState(nil), s.Items...)
+	return s
+}
+
+// sanitizeListValue strips characters that swiftDialog uses as separators in
+// --listitem values.
+func sanitizeListValue(s string) string {
+	return strings.NewReplacer(",", " ", "=", " ").Replace(s)
+}
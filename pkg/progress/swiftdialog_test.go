@@ -0,0 +1,179 @@
+package progress
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// newTestDialog returns a SwiftDialog backed by a fake binary and a temp
+// command file, recording launch calls instead of starting a process.
+func newTestDialog(t *testing.T) (*SwiftDialog, string, *[]string) {
+	t.Helper()
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "dialog")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("write fake dialog: %v", err)
+	}
+	cmdFile := filepath.Join(dir, "dialog.log")
+	var launched []string
+	d := NewSwiftDialog(SwiftDialogOptions{
+		Path:        bin,
+		CommandFile: cmdFile,
+		Launch: func(path string, args []string) error {
+			launched = append([]string{path}, args...)
+			return nil
+		},
+	}, utils.NewLogger(false, false))
+	return d, cmdFile, &launched
+}
+
+func readCommands(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read command file: %v", err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func testState(statuses ...string) State {
+	s := State{Title: "T", Message: "M"}
+	for i, st := range statuses {
+		s.Items = append(s.Items, ItemState{Name: string(rune('A' + i)), Status: st})
+	}
+	return s
+}
+
+func TestSwiftDialog_ShowLaunchArgs(t *testing.T) {
+	d, cmdFile, launched := newTestDialog(t)
+	state := State{Title: "Welcome", Message: "Hi", Items: []ItemState{
+		{Name: "Chrome", Status: StatusPending},
+		{Name: "Dock, setup", Status: StatusSkipped},
+	}}
+	if err := d.Show(state); err != nil {
+		t.Fatalf("show: %v", err)
+	}
+	joined := strings.Join(*launched, " ")
+	for _, want := range []string{
+		d.opts.Path + " --title Welcome --message Hi",
+		"--commandfile " + cmdFile,
+		"--progress 2",
+		"--listitem Chrome,status=pending,statustext=Pending",
+		"--listitem Dock  setup,status=success,statustext=Skipped",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("launch missing %q: %v", want, *launched)
+		}
+	}
+}
+
+func TestSwiftDialog_ShowMissingBinary(t *testing.T) {
+	called := false
+	d := NewSwiftDialog(SwiftDialogOptions{
+		Path:        filepath.Join(t.TempDir(), "missing"),
+		CommandFile: filepath.Join(t.TempDir(), "dialog.log"),
+		Launch:      func(string, []string) error { called = true; return nil },
+	}, utils.NewLogger(false, false))
+	if err := d.Show(testState(StatusPending)); err == nil || called {
+		t.Fatalf("expected error without launching, got err=%v called=%v", err, called)
+	}
+}
+
+func TestSwiftDialog_UpdateWritesOnlyChanges(t *testing.T) {
+	d, cmdFile, _ := newTestDialog(t)
+	if err := d.Show(testState(StatusPending, StatusPending)); err != nil {
+		t.Fatalf("show: %v", err)
+	}
+	s := testState(StatusRunning, StatusPending)
+	s.Text = "Installing A…"
+	_ = d.Update(s)
+	s.Items[0].Percent, s.Text = 42, "Installing A… 42%"
+	_ = d.Update(s)
+	s = testState(StatusSuccess, StatusPending)
+	s.Completed, s.Text = 1, "Installing A… 42%"
+	_ = d.Update(s)
+	s = testState(StatusSuccess, StatusFailed)
+	s.Completed, s.Text, s.Done = 2, "Setup complete", true
+	_ = d.Dismiss(s)
+
+	want := []string{
+		"listitem: index: 0, status: wait, statustext: Installing",
+		"progresstext: Installing A…",
+		"listitem: index: 0, status: wait, statustext: Installing 42%",
+		"progresstext: Installing A… 42%",
+		"listitem: index: 0, status: success, statustext: Installed",
+		"progress: 1",
+		"listitem: index: 1, status: fail, statustext: Failed",
+		"progress: 2",
+		"progresstext: Setup complete",
+		"progress: complete",
+		"quit:",
+	}
+	got := readCommands(t, cmdFile)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("commands:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSwiftDialog_DismissFailureKeepsWindowOpen(t *testing.T) {
+	d, cmdFile, _ := newTestDialog(t)
+	if err := d.Show(testState(StatusPending)); err != nil {
+		t.Fatalf("show: %v", err)
+	}
+	s := testState(StatusFailed)
+	s.Completed, s.Done, s.Failed = 1, true, true
+	_ = d.Dismiss(s)
+
+	got := readCommands(t, cmdFile)
+	for _, line := range got {
+		if line == "quit:" {
+			t.Fatalf("failure should not quit the dialog: %v", got)
+		}
+	}
+	if got[len(got)-1] != "button1: enable" {
+		t.Fatalf("expected Done button to be enabled, got %v", got)
+	}
+}
+
+func TestSwiftDialog_RefusesPlantedCommandFile(t *testing.T) {
+	d, cmdFile, _ := newTestDialog(t)
+	target := filepath.Join(t.TempDir(), "target")
+	if err := os.WriteFile(target, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, cmdFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Show(testState(StatusPending)); err == nil {
+		t.Error("Show followed a symlinked command file")
+	}
+	if err := d.Update(testState(StatusRunning)); err == nil {
+		t.Error("Update followed a symlinked command file")
+	}
+	if data, _ := os.ReadFile(target); string(data) != "keep" {
+		t.Errorf("symlink target changed to %q", data)
+	}
+
+	if os.Geteuid() != 0 {
+		return
+	}
+	if err := os.Remove(cmdFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cmdFile, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(cmdFile, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Show(testState(StatusPending)); err == nil {
+		t.Error("Show wrote to a command file owned by another user")
+	}
+	if data, _ := os.ReadFile(cmdFile); string(data) != "keep" {
+		t.Errorf("command file owned by another user changed to %q", data)
+	}
+}
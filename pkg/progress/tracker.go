@@ -0,0 +1,184 @@
+package progress
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// DefaultTitle and DefaultMessage are shown when the config leaves them empty.
+const (
+	DefaultTitle   = "Setting up your Mac"
+	DefaultMessage = "Please wait while we install your applications."
+)
+
+// Tracker is a Reporter that keeps a State snapshot of the run and pushes it
+// to an attached Display. Events before Attach are recorded so the display
+// opens with the current state.
+type Tracker struct {
+	logger utils.Logger
+
+	mu       sync.Mutex
+	state    State
+	index    map[string]int // item name -> index in state.Items
+	display  Display
+	finished bool
+}
+
+// NewTracker creates a Tracker with the given title and message.
+func NewTracker(title, message string, logger utils.Logger) *Tracker {
+	if title == "" {
+		title = DefaultTitle
+	}
+	if message == "" {
+		message = DefaultMessage
+	}
+	return &Tracker{
+		logger: logger,
+		state:  State{Title: title, Message: message},
+		index:  map[string]int{},
+	}
+}
+
+// Attach shows d with the current state and routes later updates to it. It
+// is a no-op once a display is attached or the run has finished.
+func (t *Tracker) Attach(d Display) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.display != nil || t.finished {
+		return nil
+	}
+	if err := d.Show(t.snapshotLocked()); err != nil {
+		return err
+	}
+	t.display = d
+	return nil
+}
+
+// State returns a copy of the current snapshot.
+func (t *Tracker) State() State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshotLocked()
+}
+
+// Start records the items to display. Duplicate names share one entry.
+func (t *Tracker) Start(items []config.Item) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.Items = t.state.Items[:0]
+	t.state.Completed = 0
+	t.index = map[string]int{}
+	for _, it := range items {
+		if _, dup := t.index[it.Name]; dup {
+			continue
+		}
+		t.index[it.Name] = len(t.state.Items)
+		t.state.Items = append(t.state.Items, ItemState{Name: it.Name, Status: StatusPending})
+	}
+	t.pushLocked()
+}
+
+// ItemStarted marks the item as running.
+func (t *Tracker) ItemStarted(item config.Item) {
+	t.set(item.Name, StatusRunning, fmt.Sprintf("Installing %s…", item.Name))
+}
+
+// ItemProgress records how far a running item has got. Updates for items that
+// are not running, or that change nothing, are dropped.
+func (t *Tracker) ItemProgress(item config.Item, percent int, text string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i, ok := t.index[item.Name]
+	if !ok || t.finished || t.state.Items[i].Status != StatusRunning {
+		return
+	}
+	if t.state.Items[i].Percent == percent && (text == "" || text == t.state.Text) {
+		return
+	}
+	t.state.Items[i].Percent = percent
+	if text != "" {
+		t.state.Text = text
+	}
+	t.pushLocked()
+}
+
+// ItemFinished marks the item as succeeded or failed.
+func (t *Tracker) ItemFinished(item config.Item, err error) {
+	if err != nil {
+		t.set(item.Name, StatusFailed, fmt.Sprintf("%s failed", item.Name))
+		return
+	}
+	t.set(item.Name, StatusSuccess, "")
+}
+
+// ItemSkipped marks the item as done without installing it.
+func (t *Tracker) ItemSkipped(item config.Item, _ string) {
+	t.set(item.Name, StatusSkipped, "")
+}
+
+// Finish records the outcome and dismisses the attached display.
+func (t *Tracker) Finish(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.finished {
+		return
+	}
+	t.finished = true
+	t.state.Done = true
+	if err != nil {
+		t.state.Failed = true
+		t.state.Text = "Setup did not complete. Please contact IT support."
+	} else {
+		t.state.Text = "Setup complete"
+	}
+	if t.display == nil {
+		return
+	}
+	if derr := t.display.Dismiss(t.snapshotLocked()); derr != nil {
+		t.logger.Debug("Progress display dismiss failed: %v", derr)
+	}
+}
+
+// set updates one item. Items not in the list (e.g. preflight) are ignored.
+func (t *Tracker) set(name, status, text string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i, ok := t.index[name]
+	if !ok || t.finished {
+		return
+	}
+	prev := t.state.Items[i].Status
+	t.state.Items[i].Status = status
+	t.state.Items[i].Percent = 0
+	if isTerminal(status) && !isTerminal(prev) {
+		t.state.Completed++
+	}
+	if text != "" {
+		t.state.Text = text
+	}
+	t.pushLocked()
+}
+
+// pushLocked sends the snapshot to the display; callers must hold t.mu.
+// Holding the lock keeps updates ordered when items run in parallel.
+func (t *Tracker) pushLocked() {
+	if t.display == nil {
+		return
+	}
+	if err := t.display.Update(t.snapshotLocked()); err != nil {
+		t.logger.Debug("Progress display update failed: %v", err)
+	}
+}
+
+func (t *Tracker) snapshotLocked() State {
+	s := t.state
+	s.Items = append([]ItemState(nil), t.state.Items...)
+	return s
+}
+
+func isTerminal(status string) bool {
+	return status == StatusSuccess || status == StatusFailed || status == StatusSkipped
+}
@@ -0,0 +1,106 @@
+package progress
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// recordingDisplay captures every call a Tracker makes.
+type recordingDisplay struct {
+	calls  []string
+	states []State
+}
+
+func (r *recordingDisplay) Show(s State) error    { return r.record("show", s) }
+func (r *recordingDisplay) Update(s State) error  { return r.record("update", s) }
+func (r *recordingDisplay) Dismiss(s State) error { return r.record("dismiss", s) }
+
+func (r *recordingDisplay) record(call string, s State) error {
+	r.calls = append(r.calls, call)
+	r.states = append(r.states, s)
+	return nil
+}
+
+func TestTracker_AttachShowsCurrentState(t *testing.T) {
+	tr := NewTracker("", "", utils.NewLogger(false, false))
+	a, b := config.Item{Name: "A"}, config.Item{Name: "B"}
+	tr.Start([]config.Item{a, b, a})
+	tr.ItemStarted(a)
+	tr.ItemFinished(a, nil)
+
+	d := &recordingDisplay{}
+	if err := tr.Attach(d); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	if len(d.calls) != 1 || d.calls[0] != "show" {
+		t.Fatalf("calls = %v, want [show]", d.calls)
+	}
+	s := d.states[0]
+	if s.Title != DefaultTitle || len(s.Items) != 2 || s.Completed != 1 ||
+		s.Items[0].Status != StatusSuccess || s.Items[1].Status != StatusPending {
+		t.Fatalf("unexpected initial state: %+v", s)
+	}
+
+	// A second display is ignored
+	if err := tr.Attach(&recordingDisplay{}); err != nil {
+		t.Fatalf("re-attach: %v", err)
+	}
+}
+
+func TestTracker_EventsAndFinish(t *testing.T) {
+	tr := NewTracker("Title", "Msg", utils.NewLogger(false, false))
+	a, b := config.Item{Name: "A"}, config.Item{Name: "B"}
+	tr.Start([]config.Item{a, b})
+	d := &recordingDisplay{}
+	_ = tr.Attach(d)
+
+	tr.ItemSkipped(a, "skip_if intel")
+	tr.ItemStarted(config.Item{Name: "preflight"}) // not displayed
+	tr.ItemStarted(b)
+	tr.ItemFinished(b, errors.New("boom"))
+	tr.Finish(errors.New("phase failed"))
+	tr.Finish(nil) // ignored after the first call
+
+	want := []string{"show", "update", "update", "update", "dismiss"}
+	if len(d.calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", d.calls, want)
+	}
+	for i := range want {
+		if d.calls[i] != want[i] {
+			t.Fatalf("calls = %v, want %v", d.calls, want)
+		}
+	}
+	final := d.states[len(d.states)-1]
+	if !final.Done || !final.Failed || final.Completed != 2 ||
+		final.Items[0].Status != StatusSkipped || final.Items[1].Status != StatusFailed {
+		t.Fatalf("unexpected final state: %+v", final)
+	}
+}
+
+func TestTracker_ItemProgress(t *testing.T) {
+	tr := NewTracker("", "", utils.NewLogger(false, false))
+	a, b := config.Item{Name: "A"}, config.Item{Name: "B"}
+	tr.Start([]config.Item{a, b})
+	d := &recordingDisplay{}
+	_ = tr.Attach(d)
+
+	tr.ItemProgress(b, 10, "ignored") // not running
+	tr.ItemStarted(a)
+	tr.ItemProgress(a, 42, "Installing A… 42%")
+	tr.ItemProgress(a, 42, "") // no change
+	if len(d.calls) != 3 {
+		t.Fatalf("calls = %v, want show and two updates", d.calls)
+	}
+	s := d.states[2]
+	if s.Items[0].Percent != 42 || s.Text != "Installing A… 42%" {
+		t.Fatalf("unexpected progress state: %+v", s)
+	}
+
+	tr.ItemFinished(a, nil)
+	if s := tr.State(); s.Items[0].Percent != 0 {
+		t.Errorf("percent should reset when the item finishes: %+v", s.Items[0])
+	}
+}
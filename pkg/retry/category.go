@@ -0,0 +1,48 @@
+package retry
+
+import "errors"
+
+// Failure categories, recorded per failed attempt so each can have its own
+// retry limit.
+const (
+	CategoryNetwork    = "network"    // downloads, including the bootstrap
+	CategoryValidation = "validation" // bootstrap parsing and checks, hash verification
+	CategoryInstall    = "install"    // packages, scripts, files; anything untagged
+	CategoryIPC        = "ipc"        // reaching the agent
+)
+
+// Categories lists the failure categories.
+var Categories = []string{CategoryNetwork, CategoryValidation, CategoryInstall, CategoryIPC}
+
+// Failure tags an error with its failure category. It reads and unwraps as
+// the error it tags.
+type Failure struct {
+	Category string
+	Err      error
+}
+
+func (f *Failure) Error() string { return f.Err.Error() }
+func (f *Failure) Unwrap() error { return f.Err }
+
+// Tag returns err tagged with category, or nil when err is nil. An error
+// that is already tagged keeps its category.
+func Tag(category string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var failure *Failure
+	if errors.As(err, &failure) {
+		return err
+	}
+	return &Failure{Category: category, Err: err}
+}
+
+// CategoryOf returns the category err was tagged with, or CategoryInstall
+// when it was not tagged.
+func CategoryOf(err error) string {
+	var failure *Failure
+	if errors.As(err, &failure) {
+		return failure.Category
+	}
+	return CategoryInstall
+}
@@ -6,13 +6,43 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/go-installapplications/pkg/utils"
 )
 
 // retryCounterFile is the location of the persisted retry state. Declared as
 // a package-level var (not a const) so tests can redirect it to a temp path.
 var retryCounterFile = "/var/tmp/go-installapplications/.retry-state"
 
-const MaxRetries = 3
+// logger receives warnings about the state file; nil until SetLogger.
+var logger utils.Logger
+
+// SetLogger sets the logger for warnings about the state file.
+func SetLogger(l utils.Logger) { logger = l }
+
+// SetStateFile moves the persisted retry state, normally to
+// Config.RetryStateFile.
+func SetStateFile(path string) { retryCounterFile = path }
+
+// DefaultMaxRetries is how many daemon attempts are allowed by default.
+const DefaultMaxRetries = 3
+
+// MaxRetries is how many daemon attempts are allowed before the daemon gives
+// up; 0 or less means no limit. Set it with SetMaxRetries.
+var MaxRetries = DefaultMaxRetries
+
+// SetMaxRetries sets MaxRetries, normally from Config.DaemonMaxRetries.
+func SetMaxRetries(n int) { MaxRetries = n }
+
+// CategoryLimits replaces MaxRetries for failures of the listed categories:
+// once the last failure was of a listed category, the daemon retries until
+// that category has failed its limit of times (0 or less = no limit). Set it
+// with SetCategoryLimits.
+var CategoryLimits map[string]int
+
+// SetCategoryLimits sets CategoryLimits, normally from
+// Config.DaemonRetryLimits.
+func SetCategoryLimits(limits map[string]int) { CategoryLimits = limits }
 
 // RetryState tracks daemon retry attempts
 type RetryState struct {
@@ -20,6 +50,10 @@ type RetryState struct {
 	FirstTry time.Time `json:"first_try"`
 	LastTry  time.Time `json:"last_try"`
 	Reason   string    `json:"reason,omitempty"`
+	// Failures counts the failed attempts per category, and LastCategory
+	// is the category of the current attempt's failure, if it failed
+	Failures     map[string]int `json:"failures,omitempty"`
+	LastCategory string         `json:"last_category,omitempty"`
 }
 
 // GetRetryCount returns current retry count
@@ -45,7 +79,39 @@ func IncrementRetryCount(reason string) error {
 	state.Count++
 	state.LastTry = time.Now()
 	state.Reason = reason
+	state.LastCategory = ""
+
+	return saveRetryState(state)
+}
+
+// RecordFailure records that the current attempt failed with err: its
+// category is counted and becomes LastCategory. It does not count a new
+// attempt.
+func RecordFailure(err error, reason string) error {
+	state, rerr := readRetryState()
+	if rerr != nil {
+		state = &RetryState{FirstTry: time.Now()}
+	}
+	category := CategoryOf(err)
+	if state.Failures == nil {
+		state.Failures = map[string]int{}
+	}
+	state.Failures[category]++
+	state.LastCategory = category
+	state.LastTry = time.Now()
+	state.Reason = reason
+	return saveRetryState(state)
+}
 
+// RecordReason updates the reason of the current attempt without counting a
+// new one. It does nothing when no attempt has been recorded.
+func RecordReason(reason string) error {
+	state, err := readRetryState()
+	if err != nil {
+		return nil
+	}
+	state.LastTry = time.Now()
+	state.Reason = reason
 	return saveRetryState(state)
 }
 
@@ -54,15 +120,37 @@ func ClearRetryCount() error {
 	return os.Remove(retryCounterFile)
 }
 
-// ShouldRetry checks if we should attempt retry
+// ShouldRetry checks if we should attempt retry. The limit for the last
+// failure's category applies when CategoryLimits has one, MaxRetries
+// otherwise.
 func ShouldRetry() (bool, error) {
-	count := GetRetryCount()
-	if count >= MaxRetries {
+	state, err := readRetryState()
+	if err != nil {
+		return true, nil // First attempt
+	}
+	if limit, ok := CategoryLimits[state.LastCategory]; ok && state.LastCategory != "" {
+		if limit > 0 && state.Failures[state.LastCategory] >= limit {
+			return false, fmt.Errorf("maximum %s retry attempts (%d) exceeded", state.LastCategory, limit)
+		}
+		return true, nil
+	}
+	count := state.Count
+	if MaxRetries > 0 && count >= MaxRetries {
 		return false, fmt.Errorf("maximum retry attempts (%d) exceeded", MaxRetries)
 	}
 	return true, nil
 }
 
+// ReadState returns the persisted retry state, or nil when no attempt has
+// been recorded.
+func ReadState() (*RetryState, error) {
+	state, err := readRetryState()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return state, err
+}
+
 // GetRetryInfo returns human-readable retry information
 func GetRetryInfo() string {
 	state, err := readRetryState()
@@ -70,13 +158,19 @@ func GetRetryInfo() string {
 		return "First attempt"
 	}
 
-	return fmt.Sprintf("Retry %d/%d (first attempt: %s, last: %s)",
-		state.Count, MaxRetries,
+	limit := "no limit"
+	if MaxRetries > 0 {
+		limit = fmt.Sprint(MaxRetries)
+	}
+	return fmt.Sprintf("Retry %d/%s (first attempt: %s, last: %s)",
+		state.Count, limit,
 		state.FirstTry.Format("15:04:05"),
 		state.LastTry.Format("15:04:05"))
 }
 
-// readRetryState reads retry state from file
+// readRetryState reads retry state from file. A file that cannot be parsed,
+// e.g. one truncated by a power loss before writes were atomic, is removed
+// with a warning and treated as missing, so counting starts over.
 func readRetryState() (*RetryState, error) {
 	data, err := os.ReadFile(retryCounterFile)
 	if err != nil {
@@ -85,7 +179,13 @@ func readRetryState() (*RetryState, error) {
 
 	var state RetryState
 	if err := json.Unmarshal(data, &state); err != nil {
-		return nil, err
+		if logger != nil {
+			logger.Info("⚠️  Retry state %s is unreadable (%v); resetting it", retryCounterFile, err)
+		}
+		if rerr := os.Remove(retryCounterFile); rerr != nil && !os.IsNotExist(rerr) {
+			return nil, rerr
+		}
+		return nil, os.ErrNotExist
 	}
 
 	return &state, nil
@@ -103,5 +203,5 @@ func saveRetryState(state *RetryState) error {
 		return err
 	}
 
-	return os.WriteFile(retryCounterFile, data, 0644)
+	return utils.WriteFileAtomic(retryCounterFile, data, 0644)
 }
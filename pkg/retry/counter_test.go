@@ -1,7 +1,10 @@
 package retry
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -87,3 +90,135 @@ func TestRetryCounter_PersistsAcrossReads(t *testing.T) {
 		t.Fatalf("state did not persist: %+v", state)
 	}
 }
+
+func TestRecordReason_KeepsCount(t *testing.T) {
+	newRetryScope(t)
+
+	if err := RecordReason("interrupted by SIGTERM"); err != nil {
+		t.Fatalf("record without state: %v", err)
+	}
+	if _, err := os.Stat(retryCounterFile); !os.IsNotExist(err) {
+		t.Fatalf("RecordReason must not create state")
+	}
+
+	_ = IncrementRetryCount("daemon started")
+	if err := RecordReason("interrupted by SIGTERM"); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	state, err := readRetryState()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if state.Count != 1 || state.Reason != "interrupted by SIGTERM" {
+		t.Fatalf("state = %+v", state)
+	}
+}
+
+func TestReadState_NilWithoutAttempts(t *testing.T) {
+	newRetryScope(t)
+
+	if state, err := ReadState(); state != nil || err != nil {
+		t.Fatalf("ReadState() = %+v, %v; want nil, nil", state, err)
+	}
+	_ = IncrementRetryCount("daemon started")
+	if state, err := ReadState(); err != nil || state.Count != 1 {
+		t.Fatalf("ReadState() = %+v, %v", state, err)
+	}
+}
+
+func TestRetryCounter_MaxRetriesConfigurable(t *testing.T) {
+	newRetryScope(t)
+	t.Cleanup(func() { SetMaxRetries(DefaultMaxRetries) })
+
+	SetMaxRetries(1)
+	if err := IncrementRetryCount("first"); err != nil {
+		t.Fatalf("increment: %v", err)
+	}
+	if ok, _ := ShouldRetry(); ok {
+		t.Fatalf("ShouldRetry must be false after 1 attempt with MaxRetries=1")
+	}
+
+	SetMaxRetries(0)
+	for i := 0; i < 5; i++ {
+		if err := IncrementRetryCount("again"); err != nil {
+			t.Fatalf("increment: %v", err)
+		}
+	}
+	if ok, err := ShouldRetry(); !ok {
+		t.Fatalf("MaxRetries=0 must not limit attempts: %v", err)
+	}
+}
+
+func TestRetryCounter_CategoryLimits(t *testing.T) {
+	newRetryScope(t)
+	t.Cleanup(func() { SetCategoryLimits(nil); SetMaxRetries(DefaultMaxRetries) })
+	SetMaxRetries(2)
+	SetCategoryLimits(map[string]int{CategoryNetwork: 0, CategoryValidation: 1})
+
+	network := Tag(CategoryNetwork, errors.New("connection refused"))
+	for i := 0; i < 4; i++ {
+		if err := IncrementRetryCount("daemon started"); err != nil {
+			t.Fatal(err)
+		}
+		if err := RecordFailure(fmt.Errorf("setup failed: %w", network), "setup failed"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := ShouldRetry(); !ok {
+		t.Fatalf("network failures have no limit, got %v", err)
+	}
+
+	if err := IncrementRetryCount("daemon started"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordFailure(Tag(CategoryValidation, errors.New("bad json")), "setup failed"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := ShouldRetry(); ok {
+		t.Fatal("a validation failure must stop retries at its limit of 1")
+	}
+
+	// An untagged failure is an install failure, limited by MaxRetries
+	if err := RecordFailure(errors.New("installer exited 1"), "userland failed"); err != nil {
+		t.Fatal(err)
+	}
+	state, err := ReadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.LastCategory != CategoryInstall || state.Failures[CategoryNetwork] != 4 || state.Failures[CategoryValidation] != 1 {
+		t.Fatalf("state = %+v", state)
+	}
+	if ok, _ := ShouldRetry(); ok {
+		t.Fatalf("install failures use MaxRetries; %d attempts exceed 2", state.Count)
+	}
+}
+
+func TestRetryCounter_CorruptStateResets(t *testing.T) {
+	newRetryScope(t)
+
+	// A write cut short by a power loss
+	if err := os.WriteFile(retryCounterFile, []byte(`{"count": 2, "first_t`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := ReadState(); state != nil || err != nil {
+		t.Fatalf("corrupt state = %+v, %v; want a reset", state, err)
+	}
+	if _, err := os.Stat(retryCounterFile); !os.IsNotExist(err) {
+		t.Fatalf("corrupt state file not removed: %v", err)
+	}
+
+	if err := IncrementRetryCount("daemon started"); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetRetryCount(); got != 1 {
+		t.Fatalf("count after reset = %d, want 1", got)
+	}
+	entries, err := os.ReadDir(filepath.Dir(retryCounterFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("temp files left behind: %v", entries)
+	}
+}
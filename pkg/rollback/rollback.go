@@ -0,0 +1,186 @@
+// Package rollback records what a run changed and undoes it when the run
+// fails: placed files are removed and each item's rollback command is run,
+// newest first. It is opt-in (RollbackOnFailure) and best effort; a package
+// is only undone by its rollback command.
+package rollback
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/go-installapplications/pkg/audit"
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/installer"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// Journal is a progress.Reporter that records every item that finished
+// successfully and rolls them back when the run finishes with an error. A
+// run stopped by a signal is not rolled back: it is left in place to run
+// again.
+type Journal struct {
+	logger    utils.Logger
+	dryRun    bool
+	backupDir string
+	// runScript runs a rollback script as root; swapped out in tests
+	runScript func(path string) error
+
+	mu      sync.Mutex
+	changed []config.Item
+}
+
+// NewJournal creates a Journal whose rollback commands run as root with
+// the script executor. With cfg.DryRun nothing is undone, only logged.
+func NewJournal(cfg *config.Config, logger utils.Logger) *Journal {
+	executor := installer.NewScriptExecutor(cfg.DryRun, logger, false)
+	return &Journal{
+		logger:    logger,
+		dryRun:    cfg.DryRun,
+		backupDir: cfg.FileBackupDir,
+		runScript: func(path string) error {
+			return executor.ExecuteScript(path, "rollback", false, false)
+		},
+	}
+}
+
+// Start forgets earlier changes.
+func (j *Journal) Start([]config.Item) {
+	j.mu.Lock()
+	j.changed = nil
+	j.mu.Unlock()
+}
+
+// ItemStarted does nothing; only finished items changed the machine.
+func (j *Journal) ItemStarted(config.Item) {}
+
+// ItemFinished records item if it succeeded.
+func (j *Journal) ItemFinished(item config.Item, err error) {
+	if err != nil {
+		return
+	}
+	j.mu.Lock()
+	j.changed = append(j.changed, item)
+	j.mu.Unlock()
+}
+
+// ItemSkipped does nothing; a skipped item changed nothing.
+func (j *Journal) ItemSkipped(config.Item, string) {}
+
+// Finish rolls the run back if it failed for any reason but a signal.
+func (j *Journal) Finish(err error) {
+	var signal *utils.SignalError
+	if err == nil || errors.As(err, &signal) {
+		return
+	}
+	j.Rollback()
+}
+
+// Rollback undoes the recorded changes, newest first, and returns what
+// could not be undone. Every change is attempted even after an error.
+func (j *Journal) Rollback() []error {
+	j.mu.Lock()
+	changed := j.changed
+	j.changed = nil
+	j.mu.Unlock()
+	if len(changed) == 0 {
+		return nil
+	}
+
+	j.logger.Info("↩️  Rolling back %d items", len(changed))
+	var errs []error
+	for i := len(changed) - 1; i >= 0; i-- {
+		if err := j.undo(changed[i]); err != nil {
+			j.logger.Error("❌ Rollback of %s failed: %v", changed[i].Name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", changed[i].Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		j.logger.Error("Rollback finished with %d errors", len(errs))
+	} else {
+		j.logger.Info("✅ Rollback complete")
+	}
+	return errs
+}
+
+// undo reverts one item: a placed file is removed, and the file it replaced
+// restored if it was backed up, then the item's rollback command, if any,
+// is run.
+func (j *Journal) undo(item config.Item) error {
+	if (item.Type == "rootfile" || item.Type == "userfile") && item.File != "" {
+		if err := j.removeFile(item.File); err != nil {
+			return err
+		}
+		if item.Backup {
+			if err := j.restoreFile(item.File); err != nil {
+				return err
+			}
+		}
+	}
+	if item.Rollback == "" {
+		if item.Type == "package" || item.Type == "munki" {
+			what := item.PackageID
+			if what == "" {
+				what = item.File
+			}
+			j.logger.Info("⚠️  %s (%s) has no rollback command; its package stays installed", item.Name, what)
+		}
+		return nil
+	}
+	return j.runCommand(item)
+}
+
+// removeFile removes a placed file. It may already be gone, e.g. removed by
+// CleanupOnFailure.
+func (j *Journal) removeFile(path string) error {
+	event := audit.Event{Action: audit.ActionFileRemove, Target: path, Details: map[string]string{"rollback": "true"}}
+	if j.dryRun {
+		j.logger.Info("[DRY RUN] Would remove %s", path)
+		event.Outcome = audit.OutcomeDryRun
+		audit.Record(event)
+		return nil
+	}
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	event.Outcome = audit.Outcome(err)
+	event.Error = audit.ErrorString(err)
+	audit.Record(event)
+	if err == nil {
+		j.logger.Info("🗑️  Removed %s", path)
+	}
+	return err
+}
+
+// restoreFile puts back the file that path replaced, if there was one.
+func (j *Journal) restoreFile(path string) error {
+	if j.dryRun {
+		j.logger.Info("[DRY RUN] Would restore %s from %s", path, utils.BackupPath(path, j.backupDir))
+		return nil
+	}
+	restored, err := utils.RestoreBackup(path, j.backupDir)
+	if restored {
+		j.logger.Info("↩️  Restored %s from its backup", path)
+	}
+	return err
+}
+
+// runCommand runs item's rollback command with /bin/sh as root.
+func (j *Journal) runCommand(item config.Item) error {
+	f, err := os.CreateTemp("", "go-installapplications-rollback-*.sh")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = fmt.Fprintf(f, "#!/bin/sh\n%s\n", item.Rollback)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	j.logger.Info("↩️  Running rollback command for %s", item.Name)
+	return j.runScript(f.Name())
+}
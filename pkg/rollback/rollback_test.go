@@ -0,0 +1,85 @@
+package rollback
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+func TestJournal_RollsBackFailedRun(t *testing.T) {
+	dir := t.TempDir()
+	placed := filepath.Join(dir, "settings.plist")
+	if err := os.WriteFile(placed, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var ran []string
+	j := NewJournal(config.NewConfig(), utils.NewLogger(false, false))
+	j.runScript = func(path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		ran = append(ran, strings.TrimSpace(strings.TrimPrefix(string(data), "#!/bin/sh\n")))
+		return nil
+	}
+
+	j.Start(nil)
+	j.ItemFinished(config.Item{Name: "App", Type: "package", PackageID: "com.example.app", Rollback: "rm -rf /Applications/App.app"}, nil)
+	j.ItemFinished(config.Item{Name: "Settings", Type: "rootfile", File: placed}, nil)
+	j.ItemFinished(config.Item{Name: "Enroll", Type: "rootscript", Rollback: "profiles remove -all"}, nil)
+	j.ItemFinished(config.Item{Name: "Broken", Type: "rootscript", Rollback: "echo never"}, errors.New("exit status 1"))
+
+	// A signal leaves the run in place to be run again
+	j.Finish(fmt.Errorf("setupassistant phase stopped: %w", &utils.SignalError{Signal: syscall.SIGTERM}))
+	if len(ran) != 0 {
+		t.Fatalf("rolled back after a signal: %v", ran)
+	}
+	if _, err := os.Stat(placed); err != nil {
+		t.Fatalf("placed file removed after a signal: %v", err)
+	}
+
+	j.Finish(errors.New("userland phase failed"))
+	want := []string{"profiles remove -all", "rm -rf /Applications/App.app"}
+	if strings.Join(ran, "|") != strings.Join(want, "|") {
+		t.Fatalf("rollback commands = %q, want %q", ran, want)
+	}
+	if _, err := os.Stat(placed); !os.IsNotExist(err) {
+		t.Fatalf("placed file not removed: %v", err)
+	}
+
+	j.Finish(errors.New("again"))
+	if len(ran) != 2 {
+		t.Fatalf("rolled back twice: %v", ran)
+	}
+}
+
+func TestJournal_RestoresBackedUpFile(t *testing.T) {
+	placed := filepath.Join(t.TempDir(), "sshd_config")
+	if err := os.WriteFile(placed, []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utils.BackupFile(placed, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(placed, []byte("placed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	j := NewJournal(config.NewConfig(), utils.NewLogger(false, false))
+	j.Start(nil)
+	j.ItemFinished(config.Item{Name: "sshd", Type: "rootfile", File: placed, Backup: true}, nil)
+	if errs := j.Rollback(); len(errs) != 0 {
+		t.Fatalf("rollback: %v", errs)
+	}
+	if data, err := os.ReadFile(placed); err != nil || string(data) != "original" {
+		t.Fatalf("original not restored: %q %v", data, err)
+	}
+}
@@ -0,0 +1,20 @@
+package runner
+
+import (
+	"io"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// Logger receives the messages of a run: a utils.StdLogger, an adapter for
+// the embedding program's logger, or utils.NewSlogLogger.
+type Logger = utils.Logger
+
+// runLogger returns logger, or one that discards messages for nil.
+func runLogger(logger Logger, cfg *config.Config) Logger {
+	if logger == nil {
+		return utils.NewLoggerWithWriter(cfg.Debug, cfg.Verbose, io.Discard)
+	}
+	return logger
+}
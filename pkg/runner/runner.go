@@ -0,0 +1,173 @@
+// Package runner runs a bootstrap in-process, for Go programs that embed
+// go-installapplications (custom provisioning agents, test harnesses)
+// instead of running the binary. A Runner takes a Config, a Bootstrap and a
+// Logger and runs its phases with the same downloads, fail_policy, hooks and
+// parallel batches as daemon and standalone mode:
+//
+//	cfg := config.NewConfig()
+//	bootstrap, err := config.LoadBootstrap("bootstrap.json")
+//	...
+//	r, err := runner.New(cfg, bootstrap, myLogger)
+//	...
+//	err = r.Run(ctx)
+//	r.Cleanup()
+//
+// What the modes do around a run is left to the caller: fetching the
+// bootstrap, waiting for Setup Assistant or a user, the progress UI, the
+// retry state, and cleanup, reboot and exit.
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/download"
+	"github.com/go-installapplications/pkg/installer"
+	"github.com/go-installapplications/pkg/manager"
+	"github.com/go-installapplications/pkg/progress"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// Phases are the bootstrap's phases in the order Run runs them.
+var Phases = []string{"preflight", "setupassistant", "userland"}
+
+// ErrPreflightPassed is returned when the preflight script exits 0: the Mac
+// needs nothing else, and the remaining phases are not run.
+var ErrPreflightPassed = errors.New("preflight passed")
+
+// Runner runs the phases of one bootstrap. Set the downloader, installer and
+// reporter before the first run; a Runner is not safe for concurrent use.
+type Runner struct {
+	cfg       *config.Config
+	bootstrap *config.Bootstrap
+	logger    Logger
+
+	downloader download.Downloader
+	installer  installer.Installer
+	reporter   progress.Reporter
+	manager    *manager.Manager
+}
+
+// New creates a Runner for bootstrap, which is validated first, with cfg's
+// settings. A nil logger discards messages.
+func New(cfg *config.Config, bootstrap *config.Bootstrap, logger Logger) (*Runner, error) {
+	if cfg == nil || bootstrap == nil {
+		return nil, errors.New("runner: a config and a bootstrap are required")
+	}
+	if err := config.ValidateBootstrap(bootstrap); err != nil {
+		return nil, fmt.Errorf("runner: %w", err)
+	}
+	return &Runner{cfg: cfg, bootstrap: bootstrap, logger: runLogger(logger, cfg)}, nil
+}
+
+// SetDownloader replaces the HTTP downloader, which otherwise is
+// download.NewItemClient with the Runner's config.
+func (r *Runner) SetDownloader(d download.Downloader) {
+	r.downloader = d
+}
+
+// SetInstaller replaces the installer, which otherwise is the one daemon
+// mode uses, running as root.
+func (r *Runner) SetInstaller(i installer.Installer) {
+	r.installer = i
+}
+
+// SetReporter sends per-item progress events to rep.
+func (r *Runner) SetReporter(rep progress.Reporter) {
+	r.reporter = rep
+}
+
+// setup creates the manager on first use.
+func (r *Runner) setup() {
+	if r.manager != nil {
+		return
+	}
+	if r.downloader == nil {
+		client, err := download.NewItemClient(r.cfg, r.logger)
+		if err != nil {
+			r.logger.Error("Cannot use the device identity for downloads: %v", err)
+		}
+		r.downloader = client
+	}
+	if r.installer == nil {
+		systemInstaller := installer.NewSystemInstaller(r.cfg.DryRun, r.logger, false)
+		systemInstaller.SetLogDir(r.cfg.ItemLogDir())
+		r.installer = systemInstaller
+	}
+	r.manager = manager.NewManager(r.downloader, r.installer, r.cfg, r.logger)
+	r.manager.SetReporter(r.reporter)
+	r.manager.SetHooks(r.bootstrap.Hooks)
+	r.manager.SetPreflightOptions(r.bootstrap.PreflightOptions)
+}
+
+// Run runs every phase of the bootstrap in order, stopping at the first
+// that fails, and returns ErrPreflightPassed when the preflight script
+// passed. Cancelling ctx stops the run: no further items are started and
+// in-flight downloads and scripts are stopped.
+func (r *Runner) Run(ctx context.Context) error {
+	for _, phase := range Phases {
+		if err := r.RunPhase(ctx, phase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunPhase runs one phase ("preflight", "setupassistant" or "userland") and
+// its hooks. A phase without items does nothing.
+func (r *Runner) RunPhase(ctx context.Context, phase string) error {
+	items, err := r.items(phase)
+	if err != nil {
+		return err
+	}
+	r.setup()
+	r.bindContext(ctx)
+	err = r.manager.ProcessItems(items, phase)
+	var passed *installer.PreflightSuccessError
+	if errors.As(err, &passed) {
+		return ErrPreflightPassed
+	}
+	if err != nil {
+		return fmt.Errorf("%s phase failed: %w", phase, err)
+	}
+	return nil
+}
+
+// bindContext makes ctx stop the manager, and the downloader and installer
+// where they support it.
+func (r *Runner) bindContext(ctx context.Context) {
+	r.manager.SetContext(ctx)
+	if d, ok := r.downloader.(interface{ SetContext(context.Context) }); ok {
+		d.SetContext(ctx)
+	}
+	switch inst := r.installer.(type) {
+	case interface{ SetStop(<-chan struct{}) }:
+		inst.SetStop(ctx.Done())
+	case interface{ SetContext(context.Context) }:
+		inst.SetContext(ctx)
+	}
+}
+
+func (r *Runner) items(phase string) ([]config.Item, error) {
+	switch phase {
+	case "preflight":
+		return r.bootstrap.Preflight, nil
+	case "setupassistant":
+		return r.bootstrap.SetupAssistant, nil
+	case "userland":
+		return r.bootstrap.Userland, nil
+	}
+	return nil, fmt.Errorf("unknown phase %q", phase)
+}
+
+// Cleanup removes the files the run downloaded, as the config's cleanup
+// settings say, logs what was removed and what could not be, and returns
+// that report.
+func (r *Runner) Cleanup() utils.CleanupReport {
+	if r.manager != nil {
+		r.manager.Cleanup("runner")
+	}
+	return utils.FinishCleanup(r.logger, "runner")
+}
@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/simulate"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// recordingLogger is a Logger of the embedding program.
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) log(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debug(format string, args ...interface{}) { l.log("debug", format, args...) }
+func (l *recordingLogger) Verbose(format string, args ...interface{}) {
+	l.log("verbose", format, args...)
+}
+func (l *recordingLogger) Info(format string, args ...interface{})  { l.log("info", format, args...) }
+func (l *recordingLogger) Error(format string, args ...interface{}) { l.log("error", format, args...) }
+
+func testRunner(t *testing.T, bootstrap *config.Bootstrap, spec *simulate.Spec) (*Runner, *recordingLogger) {
+	t.Helper()
+	cfg := config.NewConfig()
+	cfg.InstallPath = t.TempDir()
+	cfg.RetryDelay = 0
+	logger := &recordingLogger{}
+	r, err := New(cfg, bootstrap, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := simulate.New(spec, bootstrap, cfg, utils.NewLoggerWithWriter(false, false, io.Discard))
+	r.SetDownloader(sim)
+	r.SetInstaller(sim)
+	return r, logger
+}
+
+func quickSpec(items map[string]simulate.ItemSpec) *simulate.Spec {
+	quick := simulate.Duration(time.Millisecond)
+	return &simulate.Spec{Download: quick, Install: quick, Items: items}
+}
+
+func TestRunner_RunsPhasesInOrder(t *testing.T) {
+	bootstrap := &config.Bootstrap{
+		SetupAssistant: []config.Item{{Name: "App", Type: "package", File: "/tmp/app.pkg", URL: "https://example.com/app.pkg"}},
+		Userland:       []config.Item{{Name: "Dock", Type: "userscript", File: "/tmp/dock.sh", URL: "https://example.com/dock.sh"}},
+	}
+	r, logger := testRunner(t, bootstrap, quickSpec(nil))
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	log := strings.Join(logger.lines, "\n")
+	if a, d := strings.Index(log, "App"), strings.Index(log, "Dock"); a < 0 || d < 0 || a > d {
+		t.Errorf("phases did not run in order:\n%s", log)
+	}
+}
+
+func TestRunner_PreflightAndFailures(t *testing.T) {
+	bootstrap := &config.Bootstrap{
+		Preflight: []config.Item{{Name: "Check", Type: "rootscript", File: "/tmp/check.sh", URL: "https://example.com/check.sh"}},
+		Userland: []config.Item{{Name: "Dock", Type: "userscript", File: "/tmp/dock.sh", URL: "https://example.com/dock.sh",
+			FailPolicy: "failure_is_not_an_option"}},
+	}
+	r, _ := testRunner(t, bootstrap, quickSpec(map[string]simulate.ItemSpec{"Check": {PreflightPass: true}}))
+	if err := r.Run(context.Background()); !errors.Is(err, ErrPreflightPassed) {
+		t.Fatalf("Run = %v, want ErrPreflightPassed", err)
+	}
+
+	r, _ = testRunner(t, bootstrap, quickSpec(map[string]simulate.ItemSpec{"Dock": {Fail: simulate.FailInstall}}))
+	if err := r.RunPhase(context.Background(), "userland"); err == nil || !strings.Contains(err.Error(), "userland phase failed") {
+		t.Fatalf("RunPhase = %v", err)
+	}
+	if err := r.RunPhase(context.Background(), "postflight"); err == nil {
+		t.Fatal("RunPhase accepted an unknown phase")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.RunPhase(ctx, "userland"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunPhase after cancel = %v", err)
+	}
+}
+
+func TestNew_ValidatesBootstrap(t *testing.T) {
+	bootstrap := &config.Bootstrap{Userland: []config.Item{{Name: "Odd", Type: "dmg", File: "/tmp/odd.dmg", URL: "https://example.com/odd.dmg"}}}
+	if _, err := New(config.NewConfig(), bootstrap, nil); err == nil {
+		t.Fatal("New accepted an invalid bootstrap")
+	}
+}
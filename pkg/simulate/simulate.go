@@ -0,0 +1,370 @@
+// Package simulate stands in for the downloader and installer so a bootstrap
+// can be run end to end without downloading or installing anything. Each
+// item takes a configurable time and can be made to fail, which exercises
+// the orchestration, download retries, fail_policy and the progress UI on a
+// development machine.
+package simulate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/download"
+	"github.com/go-installapplications/pkg/installer"
+	"github.com/go-installapplications/pkg/metrics"
+	"github.com/go-installapplications/pkg/tracing"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// Failure points for ItemSpec.Fail
+const (
+	FailDownload = "download"
+	FailInstall  = "install"
+)
+
+// Duration is a time.Duration read from JSON as a Go duration string ("2s")
+// or a number of seconds.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+	seconds, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return fmt.Errorf("duration must be a string like \"2s\" or a number of seconds: %s", data)
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// Spec describes a simulated run. Items are keyed by bootstrap item name;
+// items without an entry use the defaults and succeed.
+type Spec struct {
+	Download Duration            `json:"download"` // default time per download attempt
+	Install  Duration            `json:"install"`  // default time per install
+	Items    map[string]ItemSpec `json:"items"`
+}
+
+// ItemSpec overrides the timing of one item and injects failures.
+type ItemSpec struct {
+	Download *Duration `json:"download,omitempty"`
+	Install  *Duration `json:"install,omitempty"`
+	// Fail is FailDownload (every attempt fails) or FailInstall
+	Fail string `json:"fail,omitempty"`
+	// FailAttempts makes the first n download attempts fail, so the item
+	// only succeeds if its retries allow it
+	FailAttempts int `json:"fail_attempts,omitempty"`
+	// PreflightPass makes a preflight script exit 0, ending the run
+	PreflightPass bool `json:"preflight_pass,omitempty"`
+}
+
+// DefaultSpec returns the spec used without a simulation file: one second
+// per download and two per install, with no failures.
+func DefaultSpec() *Spec {
+	return &Spec{Download: Duration(time.Second), Install: Duration(2 * time.Second)}
+}
+
+// Load reads a Spec from the JSON file at path, on top of DefaultSpec. An
+// empty path returns DefaultSpec.
+func Load(path string) (*Spec, error) {
+	spec := DefaultSpec()
+	if path == "" {
+		return spec, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("failed to parse simulation file %s: %w", path, err)
+	}
+	for name, item := range spec.Items {
+		switch item.Fail {
+		case "", FailDownload, FailInstall:
+		default:
+			return nil, fmt.Errorf("item %q: fail must be %q or %q, got %q", name, FailDownload, FailInstall, item.Fail)
+		}
+	}
+	return spec, nil
+}
+
+// Simulator implements download.Downloader and installer.Installer. Items
+// are recognised by their file path, which the manager passes to both.
+type Simulator struct {
+	spec   *Spec
+	logger utils.Logger
+	byFile map[string]config.Item
+
+	defaultRetries   int
+	defaultRetryWait int
+	ctx              context.Context
+	metrics          *metrics.Recorder
+	tracer           *tracing.Tracer
+
+	mu         sync.Mutex
+	attempts   map[string]int // download attempts by item name
+	background int
+}
+
+var (
+	_ download.Downloader = (*Simulator)(nil)
+	_ installer.Installer = (*Simulator)(nil)
+)
+
+// New creates a Simulator for the items of bootstrap. Download retries
+// default to cfg's MaxRetries and RetryDelay like the real downloader.
+func New(spec *Spec, bootstrap *config.Bootstrap, cfg *config.Config, logger utils.Logger) *Simulator {
+	s := &Simulator{
+		spec:             spec,
+		logger:           logger,
+		byFile:           make(map[string]config.Item),
+		defaultRetries:   cfg.MaxRetries,
+		defaultRetryWait: cfg.RetryDelay,
+		ctx:              context.Background(),
+		attempts:         make(map[string]int),
+	}
+	phases := [][]config.Item{bootstrap.Preflight, bootstrap.SetupAssistant, bootstrap.Userland}
+	for _, phase := range []string{"preflight", "setupassistant", "userland"} {
+		phases = append(phases, bootstrap.Hooks.Pre(phase), bootstrap.Hooks.Post(phase))
+	}
+	for _, phase := range phases {
+		for _, item := range phase {
+			s.byFile[item.File] = item
+		}
+	}
+	return s
+}
+
+// SetContext stops simulated work when ctx is done.
+func (s *Simulator) SetContext(ctx context.Context) { s.ctx = ctx }
+
+// SetMetrics records simulated downloads in m.
+func (s *Simulator) SetMetrics(m *metrics.Recorder) { s.metrics = m }
+
+// SetTracer records simulated downloads as spans in t.
+func (s *Simulator) SetTracer(t *tracing.Tracer) { s.tracer = t }
+
+// itemFor returns the item at path and its spec.
+func (s *Simulator) itemFor(path string) (config.Item, ItemSpec) {
+	item, ok := s.byFile[path]
+	if !ok {
+		item = config.Item{Name: path, File: path}
+	}
+	return item, s.spec.Items[item.Name]
+}
+
+// wait sleeps for d unless the run is stopped first.
+func (s *Simulator) wait(d time.Duration, what string) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-s.ctx.Done():
+		return fmt.Errorf("%s stopped: %w", what, context.Cause(s.ctx))
+	}
+}
+
+// DownloadFile simulates a download with the default retry settings.
+func (s *Simulator) DownloadFile(url, filepath, expectedHash string) error {
+	return s.DownloadFileWithRetries(url, filepath, expectedHash, 0, 0)
+}
+
+// DownloadFileWithRetries simulates downloading the item at filepath,
+// failing attempts as its spec says and retrying like the real downloader.
+func (s *Simulator) DownloadFileWithRetries(url, filepath, expectedHash string, retries int, retryWait int) (err error) {
+	item, spec := s.itemFor(filepath)
+	if retries == 0 {
+		retries = s.defaultRetries
+	}
+	if retryWait == 0 {
+		retryWait = s.defaultRetryWait
+	}
+	d := time.Duration(s.spec.Download)
+	if spec.Download != nil {
+		d = time.Duration(*spec.Download)
+	}
+
+	started := time.Now()
+	attempts := 0
+	defer func() {
+		s.metrics.ObserveDownload(time.Since(started), attempts, 0, err)
+	}()
+	attempts, err = utils.RetryContext(s.ctx, func() error {
+		if err := s.wait(d, "download of "+item.Name); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.attempts[item.Name]++
+		n := s.attempts[item.Name]
+		s.mu.Unlock()
+		if spec.Fail == FailDownload || n <= spec.FailAttempts {
+			return fmt.Errorf("simulated download failure for %s (attempt %d)", item.Name, n)
+		}
+		return nil
+	}, retries, time.Duration(retryWait)*time.Second, "download "+item.Name, s.logger)
+	if err == nil {
+		s.logger.Info("🧪 Simulated download of %s", item.Name)
+	}
+	return err
+}
+
+// VerifyFileHash always passes; nothing was downloaded.
+func (s *Simulator) VerifyFileHash(filepath, expectedHash string) error { return nil }
+
+// DownloadMultipleWithCleanup simulates downloading items with at most
+// maxConcurrency at a time. Items without a URL succeed immediately.
+func (s *Simulator) DownloadMultipleWithCleanup(items []config.Item, maxConcurrency int, cleanupOnFailure bool) []download.DownloadResult {
+	if maxConcurrency <= 0 {
+		maxConcurrency = len(items)
+	}
+	results := make([]download.DownloadResult, len(items))
+	semaphore := make(chan struct{}, maxConcurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		go func(i int, item config.Item) {
+			defer wg.Done()
+			defer utils.Recover(s.logger, "simulated download of "+item.Name)
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			results[i] = download.DownloadResult{Item: item}
+			if item.URL == "" {
+				return
+			}
+			span := s.tracer.Item(item.Name).StartChild("download")
+			err := s.DownloadFileWithRetries(item.URL, item.File, item.Hash, item.Retries, item.RetryWait)
+			span.End(err)
+			results[i].Error = err
+		}(i, item)
+	}
+	wg.Wait()
+	return results
+}
+
+// install simulates installing the item at path.
+func (s *Simulator) install(path, what string) error {
+	item, spec := s.itemFor(path)
+	d := time.Duration(s.spec.Install)
+	if spec.Install != nil {
+		d = time.Duration(*spec.Install)
+	}
+	if err := s.wait(d, what+" of "+item.Name); err != nil {
+		return err
+	}
+	if spec.Fail == FailInstall {
+		return fmt.Errorf("simulated %s failure for %s", what, item.Name)
+	}
+	s.logger.Info("🧪 Simulated %s of %s", what, item.Name)
+	return nil
+}
+
+// start simulates a script that is not waited for. Tracked scripts count as
+// background processes that finish successfully.
+func (s *Simulator) start(path string, track bool) error {
+	item, spec := s.itemFor(path)
+	if spec.Fail == FailInstall {
+		return fmt.Errorf("simulated script failure for %s", item.Name)
+	}
+	if track {
+		s.mu.Lock()
+		s.background++
+		s.mu.Unlock()
+	}
+	s.logger.Info("🧪 Simulated background start of %s", item.Name)
+	return nil
+}
+
+// InstallPackage simulates a package install.
+func (s *Simulator) InstallPackage(pkgPath string, opts installer.PackageOptions) error {
+	return s.install(pkgPath, "package install")
+}
+
+// ExecuteScript simulates a rootscript.
+func (s *Simulator) ExecuteScript(scriptPath, scriptType string, doNotWait bool, trackBackgroundProcesses bool) error {
+	if doNotWait {
+		return s.start(scriptPath, trackBackgroundProcesses)
+	}
+	return s.install(scriptPath, scriptType)
+}
+
+// ExecuteScriptAs simulates a rootscript run as another user.
+func (s *Simulator) ExecuteScriptAs(scriptPath, runAs string, doNotWait bool, trackBackgroundProcesses bool) error {
+	return s.ExecuteScript(scriptPath, "rootscript", doNotWait, trackBackgroundProcesses)
+}
+
+// ExecuteUserScript simulates a userscript.
+func (s *Simulator) ExecuteUserScript(scriptPath string, uc installer.UserContext, doNotWait bool, trackBackgroundProcesses bool) error {
+	if doNotWait {
+		return s.start(scriptPath, trackBackgroundProcesses)
+	}
+	return s.install(scriptPath, "userscript")
+}
+
+// ExecuteScriptForPreflight simulates the preflight script. It exits
+// non-zero, so the run continues, unless the item's spec sets PreflightPass.
+func (s *Simulator) ExecuteScriptForPreflight(scriptPath, scriptType string, doNotWait bool, trackBackgroundProcesses bool) error {
+	if err := s.install(scriptPath, "preflight"); err != nil {
+		return err
+	}
+	if _, spec := s.itemFor(scriptPath); spec.PreflightPass {
+		return &installer.PreflightSuccessError{}
+	}
+	return nil
+}
+
+// PlaceFile simulates placing a rootfile or userfile.
+func (s *Simulator) PlaceFile(filePath, fileType string, _ installer.FileOptions) error {
+	return s.install(filePath, fileType+" placement")
+}
+
+// ManageService simulates a launchd item.
+func (s *Simulator) ManageService(opts installer.ServiceOptions) error {
+	return s.install(opts.Plist+opts.Label, "launchctl "+opts.Action)
+}
+
+// InstallAppStoreApp simulates a vppapp item.
+func (s *Simulator) InstallAppStoreApp(opts installer.VPPAppOptions) error {
+	if opts.AppPath == "" {
+		return s.install(opts.Name, "App Store install")
+	}
+	return s.install(opts.AppPath, "App Store install")
+}
+
+// ConfigureMunki simulates the Munki handoff.
+func (s *Simulator) ConfigureMunki(opts installer.MunkiOptions) error {
+	if err := s.wait(time.Duration(s.spec.Install), "Munki handoff"); err != nil {
+		return err
+	}
+	s.logger.Info("🧪 Simulated Munki handoff to %s", opts.SoftwareRepoURL)
+	return nil
+}
+
+// WaitForBackgroundProcesses returns at once; simulated background scripts
+// always succeed.
+func (s *Simulator) WaitForBackgroundProcesses(timeout time.Duration) []error {
+	s.mu.Lock()
+	s.background = 0
+	s.mu.Unlock()
+	return nil
+}
+
+// GetBackgroundProcessCount returns the number of simulated background
+// scripts not yet waited for.
+func (s *Simulator) GetBackgroundProcessCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.background
+}
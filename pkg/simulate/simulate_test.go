@@ -0,0 +1,72 @@
+package simulate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/manager"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sim.json")
+	if err := os.WriteFile(path, []byte(`{"download": "10ms", "items": {"App": {"install": 0.5, "fail": "install"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(spec.Download) != 10*time.Millisecond || time.Duration(spec.Install) != 2*time.Second {
+		t.Fatalf("defaults = %v/%v", time.Duration(spec.Download), time.Duration(spec.Install))
+	}
+	if app := spec.Items["App"]; app.Install == nil || time.Duration(*app.Install) != 500*time.Millisecond || app.Fail != FailInstall {
+		t.Fatalf("App = %+v", app)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"items": {"App": {"fail": "sometimes"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected an error for an unknown failure point")
+	}
+}
+
+func TestSimulator_RetriesAndFailPolicy(t *testing.T) {
+	quick := Duration(time.Millisecond)
+	spec := &Spec{Download: quick, Install: quick, Items: map[string]ItemSpec{
+		"Flaky":  {FailAttempts: 1},
+		"Broken": {Fail: FailInstall},
+	}}
+	items := []config.Item{
+		{Name: "Flaky", Type: "package", File: "/tmp/flaky.pkg", URL: "https://example.com/flaky.pkg", Retries: 1},
+		{Name: "Broken", Type: "rootscript", File: "/tmp/broken.sh", URL: "https://example.com/broken.sh", FailPolicy: "failable"},
+		{Name: "Fine", Type: "userscript", File: "/tmp/fine.sh", URL: "https://example.com/fine.sh"},
+	}
+	cfg := config.NewConfig()
+	cfg.RetryDelay = 0
+	cfg.CleanupOnSuccess = false
+	logger := utils.NewLogger(false, false)
+
+	run := func(items []config.Item) error {
+		sim := New(spec, &config.Bootstrap{Userland: items}, cfg, logger)
+		return manager.NewManager(sim, sim, cfg, logger).ProcessItems(items, "userland")
+	}
+	if err := run(items); err != nil {
+		t.Fatalf("failable failure and a retried download should pass: %v", err)
+	}
+
+	items[1].FailPolicy = "failure_is_not_an_option"
+	if err := run(items); err == nil {
+		t.Fatal("expected the required item's failure to stop the phase")
+	}
+
+	items[0].Retries = 0
+	cfg.MaxRetries = 0
+	if err := run(items[:1]); err == nil {
+		t.Fatal("expected the download to fail without retries")
+	}
+}
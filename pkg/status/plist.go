@@ -0,0 +1,208 @@
+package status
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/progress"
+	"github.com/go-installapplications/pkg/utils"
+	"howett.net/plist"
+)
+
+// DefaultPlistPath is where the status plist is written unless configured
+// otherwise.
+const DefaultPlistPath = "/Library/Preferences/com.github.go-installapplications.status.plist"
+
+// RunRunning is the plist Status while a run is in progress; RunCompleted
+// and RunFailed replace it once the run ends.
+const RunRunning = "running"
+
+// PlistItem is one entry of PlistStatus.Items.
+type PlistItem struct {
+	Name   string `plist:"Name" json:"Name"`
+	Type   string `plist:"Type,omitempty" json:"Type,omitempty"`
+	Status string `plist:"Status" json:"Status"` // pending, success, failed or skipped
+	Error  string `plist:"Error,omitempty" json:"Error,omitempty"`
+	Reason string `plist:"Reason,omitempty" json:"Reason,omitempty"`
+}
+
+// PlistStatus is the documented schema of the status plist. Keys are only
+// ever added so extension attribute scripts keep working across versions.
+type PlistStatus struct {
+	Status     string      `plist:"Status" json:"Status"` // running, completed or failed
+	Error      string      `plist:"Error,omitempty" json:"Error,omitempty"`
+	Mode       string      `plist:"Mode" json:"Mode"`
+	RunID      string      `plist:"RunID" json:"RunID"`
+	DryRun     bool        `plist:"DryRun" json:"DryRun"`
+	StartTime  time.Time   `plist:"StartTime" json:"StartTime"`
+	LastUpdate time.Time   `plist:"LastUpdate" json:"LastUpdate"`
+	EndTime    *time.Time  `plist:"EndTime,omitempty" json:"EndTime,omitempty"`
+	Total      int         `plist:"ItemsTotal" json:"ItemsTotal"`
+	Succeeded  int         `plist:"ItemsSucceeded" json:"ItemsSucceeded"`
+	Failed     int         `plist:"ItemsFailed" json:"ItemsFailed"`
+	Skipped    int         `plist:"ItemsSkipped" json:"ItemsSkipped"`
+	Pending    int         `plist:"ItemsPending" json:"ItemsPending"`
+	Items      []PlistItem `plist:"Items" json:"Items"`
+
+	OSVersion string `plist:"OSVersion,omitempty" json:"OSVersion,omitempty"`
+	OSBuild   string `plist:"OSBuild,omitempty" json:"OSBuild,omitempty"`
+	Model     string `plist:"Model,omitempty" json:"Model,omitempty"` // model identifier
+	Chip      string `plist:"Chip,omitempty" json:"Chip,omitempty"`
+	Virtual   bool   `plist:"Virtual,omitempty" json:"Virtual,omitempty"`
+
+	// Cleanup after the run: the steps done and those that failed, e.g.
+	// "remove /Library/go-installapplications: permission denied"
+	CleanupSteps  int      `plist:"CleanupSteps,omitempty" json:"CleanupSteps,omitempty"`
+	CleanupErrors []string `plist:"CleanupErrors,omitempty" json:"CleanupErrors,omitempty"`
+
+	// RestartRequired names the installed packages that require a restart
+	RestartRequired []string `plist:"RestartRequired,omitempty" json:"RestartRequired,omitempty"`
+}
+
+// ReadPlist reads the status plist at path, as written by PlistWriter.
+func ReadPlist(path string) (*PlistStatus, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var st PlistStatus
+	if _, err := plist.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return &st, nil
+}
+
+// PlistWriter keeps a status plist up to date from progress events so MDM
+// extension attribute scripts (Jamf, Kandji, Mosyle, SimpleMDM...) can read
+// bootstrap state without parsing logs. It satisfies progress.Reporter. The
+// file is rewritten atomically on every event; write failures are logged and
+// never fail the run.
+type PlistWriter struct {
+	path   string
+	logger utils.Logger
+
+	mu      sync.Mutex
+	status  PlistStatus
+	results results
+}
+
+// NewPlistWriter creates a PlistWriter for path.
+func NewPlistWriter(path, mode, runID string, dryRun bool, logger utils.Logger) *PlistWriter {
+	if runID == "" {
+		runID = NewRunID()
+	}
+	now := time.Now().UTC()
+	sys := utils.GetSystemInfo()
+	return &PlistWriter{
+		path:   path,
+		logger: logger,
+		status: PlistStatus{
+			Status:     RunRunning,
+			Mode:       mode,
+			RunID:      runID,
+			DryRun:     dryRun,
+			StartTime:  now,
+			LastUpdate: now,
+			OSVersion:  sys.ProductVersion,
+			OSBuild:    sys.BuildVersion,
+			Model:      sys.ModelIdentifier,
+			Chip:       sys.Chip,
+			Virtual:    sys.Virtual,
+		},
+	}
+}
+
+// Start records every item as pending and writes the initial plist.
+func (w *PlistWriter) Start(items []config.Item) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.results.start(items)
+	w.write()
+}
+
+// ItemStarted is not recorded; the plist tracks outcomes only.
+func (w *PlistWriter) ItemStarted(config.Item) {}
+
+// ItemFinished records the item as succeeded or failed.
+func (w *PlistWriter) ItemFinished(item config.Item, err error) {
+	w.set(finished(item, err))
+}
+
+// ItemSkipped records the item as skipped.
+func (w *PlistWriter) ItemSkipped(item config.Item, reason string) {
+	w.set(skipped(item, reason))
+}
+
+// Finish records the run's outcome and the packages that require a restart.
+func (w *PlistWriter) Finish(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	end := time.Now().UTC()
+	w.status.Status, w.status.Error, w.status.EndTime = RunCompleted, "", &end
+	w.status.RestartRequired = utils.RestartRequired()
+	if err != nil {
+		w.status.Status, w.status.Error = RunFailed, err.Error()
+	}
+	w.write()
+}
+
+// Cleanup records the cleanup report; see utils.OnCleanup.
+func (w *PlistWriter) Cleanup(report utils.CleanupReport) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.CleanupSteps, w.status.CleanupErrors = len(report.Steps), nil
+	for _, s := range report.Failed() {
+		w.status.CleanupErrors = append(w.status.CleanupErrors, fmt.Sprintf("%s: %v", s, s.Err))
+	}
+	w.write()
+}
+
+func (w *PlistWriter) set(st ItemStatus) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.results.set(st)
+	w.write()
+}
+
+// write refreshes the snapshot and replaces the file. Callers hold w.mu.
+func (w *PlistWriter) write() {
+	s := &w.status
+	s.LastUpdate = time.Now().UTC()
+	s.Items = s.Items[:0]
+	s.Total, s.Succeeded, s.Failed, s.Skipped, s.Pending = 0, 0, 0, 0, 0
+	for _, st := range w.results.list() {
+		s.Items = append(s.Items, PlistItem(st))
+		s.Total++
+		switch st.Status {
+		case progress.StatusSuccess:
+			s.Succeeded++
+		case progress.StatusFailed:
+			s.Failed++
+		case progress.StatusSkipped:
+			s.Skipped++
+		default:
+			s.Pending++
+		}
+	}
+	if err := writePlist(w.path, s); err != nil {
+		w.logger.Info("⚠️  Failed to write status plist: %v", err)
+	}
+}
+
+// writePlist encodes v as an XML plist and writes it atomically so readers
+// never see a partial file.
+func writePlist(path string, v interface{}) error {
+	data, err := plist.MarshalIndent(v, plist.XMLFormat, "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode plist: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	// Readable by extension attribute scripts that do not run as root
+	return utils.WriteFileAtomic(path, data, 0644)
+}
@@ -0,0 +1,88 @@
+package status
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/progress"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+func readStatusPlist(t *testing.T, path string) PlistStatus {
+	t.Helper()
+	st, err := ReadPlist(path)
+	if err != nil {
+		t.Fatalf("read status plist: %v", err)
+	}
+	return *st
+}
+
+func TestPlistWriter_TracksRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Preferences", "status.plist")
+	w := NewPlistWriter(path, "daemon", "run-1", false, utils.NewLogger(false, false))
+
+	chrome := config.Item{Name: "Chrome", Type: "package"}
+	dock := config.Item{Name: "Dock", Type: "userscript"}
+	setup := config.Item{Name: "Setup", Type: "rootscript"}
+	var reporter progress.Reporter = w
+	reporter.Start([]config.Item{chrome, dock, setup})
+
+	st := readStatusPlist(t, path)
+	if st.Status != RunRunning || st.RunID != "run-1" || st.Mode != "daemon" || st.Pending != 3 || st.EndTime != nil {
+		t.Fatalf("unexpected initial status: %+v", st)
+	}
+
+	reporter.ItemFinished(chrome, nil)
+	reporter.ItemSkipped(dock, "already installed")
+	reporter.ItemFinished(setup, errors.New("exit status 2"))
+	reporter.Finish(errors.New("userland phase failed"))
+
+	st = readStatusPlist(t, path)
+	if st.Status != RunFailed || st.Error != "userland phase failed" || st.EndTime == nil {
+		t.Fatalf("unexpected final status: %+v", st)
+	}
+	if st.Total != 3 || st.Succeeded != 1 || st.Skipped != 1 || st.Failed != 1 || st.Pending != 0 {
+		t.Fatalf("unexpected counts: %+v", st)
+	}
+	if st.Items[1].Reason != "already installed" || st.Items[2].Error != "exit status 2" {
+		t.Fatalf("unexpected items: %+v", st.Items)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat status plist: %v", err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("status plist should be world-readable, got %v", info.Mode().Perm())
+	}
+}
+
+func TestPlistWriter_RecordsCleanup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.plist")
+	w := NewPlistWriter(path, "daemon", "run-1", false, utils.NewLogger(false, false))
+	w.Finish(nil)
+	w.Cleanup(utils.CleanupReport{Type: "exit", Steps: []utils.CleanupStep{
+		{Action: "remove", Target: "/Library/LaunchDaemons/x.plist"},
+		{Action: "remove", Target: "/Library/go-installapplications", Err: errors.New("permission denied")},
+	}})
+	st := readStatusPlist(t, path)
+	if st.Status != RunCompleted || st.CleanupSteps != 2 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if len(st.CleanupErrors) != 1 || st.CleanupErrors[0] != "remove /Library/go-installapplications: permission denied" {
+		t.Fatalf("unexpected cleanup errors: %q", st.CleanupErrors)
+	}
+}
+
+func TestPlistWriter_RecordsRestartRequired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.plist")
+	w := NewPlistWriter(path, "daemon", "run-1", false, utils.NewLogger(false, false))
+	utils.RecordRestartRequired("Security Update")
+	w.Finish(nil)
+	st := readStatusPlist(t, path)
+	if len(st.RestartRequired) != 1 || st.RestartRequired[0] != "Security Update" {
+		t.Fatalf("unexpected RestartRequired: %q", st.RestartRequired)
+	}
+}
@@ -0,0 +1,293 @@
+// Package status reports bootstrap progress to a server, in the spirit of an
+// MDM command acknowledgement: each finished item and the final outcome are
+// PUT as JSON to a configured endpoint so the server can track which devices
+// completed bootstrap and where the others failed.
+package status
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/progress"
+	"github.com/go-installapplications/pkg/utils"
+	"github.com/go-installapplications/pkg/version"
+)
+
+// Report events.
+const (
+	EventItem = "item"
+	EventRun  = "run"
+)
+
+// Run statuses carried by the final report.
+const (
+	RunCompleted = "completed"
+	RunFailed    = "failed"
+)
+
+// Options configures the status endpoint.
+type Options struct {
+	// URL receives every report via HTTP PUT.
+	URL string
+	// Headers are added to every request (e.g. Authorization).
+	Headers map[string]string
+	// DeviceID identifies the machine; defaults to the hardware serial
+	// number, then the hostname.
+	DeviceID string
+	// RunID correlates the reports of one run; a random ID is used if empty.
+	RunID string
+	// Mode is the operating mode (daemon, standalone).
+	Mode string
+	// Timeout bounds each request; defaults to 10s.
+	Timeout time.Duration
+}
+
+// ItemStatus is the outcome of one item.
+type ItemStatus struct {
+	Name   string `json:"name"`
+	Type   string `json:"type,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Reason string `json:"reason,omitempty"` // why the item was skipped
+}
+
+// Report is the JSON body of every request.
+type Report struct {
+	Event    string       `json:"event"`
+	DeviceID string       `json:"device_id"`
+	Hostname string       `json:"hostname"`
+	RunID    string       `json:"run_id"`
+	Mode     string       `json:"mode,omitempty"`
+	Time     time.Time    `json:"time"`
+	Status   string       `json:"status"`
+	Error    string       `json:"error,omitempty"`
+	Item     *ItemStatus  `json:"item,omitempty"`  // EventItem only
+	Items    []ItemStatus `json:"items,omitempty"` // EventRun only: every item's final status
+}
+
+// Reporter sends reports from progress events. It satisfies
+// progress.Reporter. Reports are delivered in order by a background sender so
+// a slow server never stalls installs; Finish waits for the queue to drain.
+// Delivery failures are logged and never fail the run.
+type Reporter struct {
+	opts     Options
+	hostname string
+	logger   utils.Logger
+	client   *http.Client
+
+	mu      sync.Mutex
+	results results
+	closed  bool // set by Finish; later events are dropped
+
+	queue chan Report
+	done  chan struct{}
+}
+
+// queueSize bounds the reports buffered for the sender; beyond it reports are
+// dropped rather than blocking installs.
+const queueSize = 256
+
+// NewReporter creates a Reporter and starts its sender.
+func NewReporter(opts Options, logger utils.Logger) *Reporter {
+	hostname := hostname()
+	if opts.DeviceID == "" {
+		if serial, err := utils.GetSerialNumber(); err == nil {
+			opts.DeviceID = serial
+		} else {
+			opts.DeviceID = hostname
+		}
+	}
+	if opts.RunID == "" {
+		opts.RunID = NewRunID()
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	r := &Reporter{
+		opts:     opts,
+		hostname: hostname,
+		logger:   logger,
+		client:   &http.Client{Timeout: opts.Timeout},
+		queue:    make(chan Report, queueSize),
+		done:     make(chan struct{}),
+	}
+	go r.send()
+	return r
+}
+
+func (r *Reporter) send() {
+	defer close(r.done)
+	for report := range r.queue {
+		if err := r.put(report); err != nil {
+			r.logger.Info("⚠️  Failed to report %s status: %v", report.Event, err)
+			continue
+		}
+		r.logger.Debug("Reported %s status %s to %s", report.Event, report.Status, r.opts.URL)
+	}
+}
+
+func (r *Reporter) put(report Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPut, r.opts.URL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+	for k, v := range r.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status report failed with status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (r *Reporter) enqueue(report Report) {
+	report.DeviceID = r.opts.DeviceID
+	report.Hostname = r.hostname
+	report.RunID = r.opts.RunID
+	report.Mode = r.opts.Mode
+	report.Time = time.Now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.queue <- report:
+	default:
+		r.logger.Debug("Status report queue full; dropping %s report", report.Event)
+	}
+}
+
+// record updates the item's status and queues an item report.
+func (r *Reporter) record(st ItemStatus) {
+	r.mu.Lock()
+	r.results.set(st)
+	r.mu.Unlock()
+	r.enqueue(Report{Event: EventItem, Status: st.Status, Error: st.Error, Item: &st})
+}
+
+// Start registers every item as pending so the final report lists items the
+// run never reached.
+func (r *Reporter) Start(items []config.Item) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results.start(items)
+}
+
+// ItemStarted is not reported; only outcomes are acknowledged.
+func (r *Reporter) ItemStarted(config.Item) {}
+
+// ItemFinished reports the item as succeeded or failed.
+func (r *Reporter) ItemFinished(item config.Item, err error) {
+	r.record(finished(item, err))
+}
+
+// ItemSkipped reports the item as skipped.
+func (r *Reporter) ItemSkipped(item config.Item, reason string) {
+	r.record(skipped(item, reason))
+}
+
+// Finish sends the final report with every item's status and waits for the
+// queue to drain. Only the first call has an effect.
+func (r *Reporter) Finish(err error) {
+	final := Report{Event: EventRun, Status: RunCompleted}
+	if err != nil {
+		final.Status, final.Error = RunFailed, err.Error()
+	}
+	r.mu.Lock()
+	final.Items = r.results.list()
+	r.mu.Unlock()
+
+	r.enqueue(final)
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.queue)
+	r.mu.Unlock()
+	<-r.done
+}
+
+// results tracks every item's latest status in run order. Callers hold the
+// owner's lock.
+type results struct {
+	order []string               // item names in run order
+	items map[string]*ItemStatus // by item name
+}
+
+// start registers items not seen yet as pending.
+func (rs *results) start(items []config.Item) {
+	for _, item := range items {
+		if _, ok := rs.items[item.Name]; !ok {
+			rs.set(ItemStatus{Name: item.Name, Type: item.Type, Status: progress.StatusPending})
+		}
+	}
+}
+
+func (rs *results) set(st ItemStatus) {
+	if rs.items == nil {
+		rs.items = map[string]*ItemStatus{}
+	}
+	if _, ok := rs.items[st.Name]; !ok {
+		rs.order = append(rs.order, st.Name)
+	}
+	rs.items[st.Name] = &st
+}
+
+func (rs *results) list() []ItemStatus {
+	list := make([]ItemStatus, 0, len(rs.order))
+	for _, name := range rs.order {
+		list = append(list, *rs.items[name])
+	}
+	return list
+}
+
+func finished(item config.Item, err error) ItemStatus {
+	st := ItemStatus{Name: item.Name, Type: item.Type, Status: progress.StatusSuccess}
+	if err != nil {
+		st.Status, st.Error = progress.StatusFailed, err.Error()
+	}
+	return st
+}
+
+func skipped(item config.Item, reason string) ItemStatus {
+	return ItemStatus{Name: item.Name, Type: item.Type, Status: progress.StatusSkipped, Reason: reason}
+}
+
+func hostname() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// NewRunID returns a random run ID for correlating a run's reports.
+func NewRunID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,114 @@
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/progress"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// recordingServer collects every PUT body and rejects requests without the
+// expected Authorization header.
+func recordingServer(t *testing.T) (*httptest.Server, func() []Report) {
+	t.Helper()
+	var (
+		mu      sync.Mutex
+		reports []Report
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Bearer t0k" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var rep Report
+		if err := json.NewDecoder(r.Body).Decode(&rep); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		reports = append(reports, rep)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []Report {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Report(nil), reports...)
+	}
+}
+
+func TestReporter_ItemAndFinalReports(t *testing.T) {
+	srv, reports := recordingServer(t)
+	r := NewReporter(Options{
+		URL:      srv.URL,
+		Headers:  map[string]string{"Authorization": "Bearer t0k"},
+		DeviceID: "C02TEST",
+		RunID:    "run-1",
+		Mode:     "daemon",
+	}, utils.NewLogger(false, false))
+
+	chrome := config.Item{Name: "Chrome", Type: "package"}
+	dock := config.Item{Name: "Dock", Type: "userscript"}
+	setup := config.Item{Name: "Setup", Type: "rootscript"}
+	never := config.Item{Name: "Never", Type: "rootfile"}
+	var reporter progress.Reporter = r
+	reporter.Start([]config.Item{chrome, dock, setup, never})
+	reporter.ItemStarted(chrome)
+	reporter.ItemFinished(chrome, nil)
+	reporter.ItemSkipped(dock, "already installed")
+	reporter.ItemFinished(setup, errors.New("exit status 2"))
+	reporter.Finish(errors.New("userland phase failed"))
+	reporter.Finish(nil) // second call is ignored
+
+	got := reports()
+	if len(got) != 4 {
+		t.Fatalf("expected 3 item reports and 1 final report, got %d: %+v", len(got), got)
+	}
+	for _, rep := range got {
+		if rep.DeviceID != "C02TEST" || rep.RunID != "run-1" || rep.Mode != "daemon" || rep.Time.IsZero() {
+			t.Fatalf("report missing identity: %+v", rep)
+		}
+	}
+	if got[0].Event != EventItem || got[0].Item.Name != "Chrome" || got[0].Status != progress.StatusSuccess {
+		t.Fatalf("unexpected first report: %+v", got[0])
+	}
+	if got[1].Item.Status != progress.StatusSkipped || got[1].Item.Reason != "already installed" {
+		t.Fatalf("unexpected skip report: %+v", got[1].Item)
+	}
+	if got[2].Status != progress.StatusFailed || got[2].Error != "exit status 2" {
+		t.Fatalf("unexpected failure report: %+v", got[2])
+	}
+
+	final := got[3]
+	if final.Event != EventRun || final.Status != RunFailed || final.Error != "userland phase failed" {
+		t.Fatalf("unexpected final report: %+v", final)
+	}
+	want := []string{progress.StatusSuccess, progress.StatusSkipped, progress.StatusFailed, progress.StatusPending}
+	if len(final.Items) != len(want) {
+		t.Fatalf("final report items: %+v", final.Items)
+	}
+	for i, st := range want {
+		if final.Items[i].Status != st {
+			t.Fatalf("item %s: status %s, want %s", final.Items[i].Name, final.Items[i].Status, st)
+		}
+	}
+}
+
+// A rejecting server must not fail or block the run.
+func TestReporter_DeliveryFailureIsNotFatal(t *testing.T) {
+	srv, reports := recordingServer(t)
+	r := NewReporter(Options{URL: srv.URL, DeviceID: "C02TEST"}, utils.NewLogger(false, false))
+	r.ItemFinished(config.Item{Name: "A"}, nil)
+	r.Finish(nil)
+	r.ItemFinished(config.Item{Name: "late"}, nil) // after Finish: dropped, no panic
+	if n := len(reports()); n != 0 {
+		t.Fatalf("expected unauthorized reports to be rejected, got %d", n)
+	}
+}
@@ -0,0 +1,392 @@
+// Package testsupport has test doubles for the downloader and installer and
+// a harness that runs a bootstrap through them, so orchestration changes can
+// be tested on any machine: nothing is downloaded and installer, pkgutil,
+// launchctl and the scripts never run. Every call is recorded, and the
+// transcript of a run can be compared with a golden file.
+//
+// Unlike pkg/simulate, which stands in for a whole run with realistic
+// timing, the doubles here return at once and their behaviour is set by the
+// test.
+package testsupport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/download"
+	"github.com/go-installapplications/pkg/installer"
+)
+
+// Call operations, besides the item types an installer call is named after
+// ("package", "rootscript", "userscript", "rootfile", "userfile", "launchd",
+// "vppapp", "munki")
+const (
+	OpDownload  = "download"
+	OpPreflight = "preflight"
+)
+
+// Call is one call of a Downloader or Installer.
+type Call struct {
+	Op   string // OpDownload, OpPreflight or an item type
+	Item string // the bootstrap item's name, or the path when it is unknown
+	Path string
+	URL  string
+	// Detail is what else tells calls apart: the user a rootscript runs
+	// as, the launchctl action or the file type of a placed file
+	Detail     string
+	Attempt    int  // download attempt, from 1
+	Background bool // a script that is not waited for
+	Err        error
+}
+
+// String formats the call as a transcript line, e.g.
+// "download App #2: connection reset" or "userscript Dock [background]".
+func (c Call) String() string {
+	var b strings.Builder
+	b.WriteString(c.Op + " " + c.Item)
+	if c.Detail != "" {
+		b.WriteString(" (" + c.Detail + ")")
+	}
+	if c.Attempt > 1 {
+		fmt.Fprintf(&b, " #%d", c.Attempt)
+	}
+	if c.Background {
+		b.WriteString(" [background]")
+	}
+	if c.Err != nil {
+		b.WriteString(": " + c.Err.Error())
+	}
+	return b.String()
+}
+
+// Behavior decides the outcome of a call: nil succeeds. Behaviors are called
+// with the lock of the Recorder held and must not call the doubles.
+type Behavior func(Call) error
+
+// Behaviors combines behaviors; the first error wins.
+func Behaviors(behaviors ...Behavior) Behavior {
+	return func(c Call) error {
+		for _, b := range behaviors {
+			if b == nil {
+				continue
+			}
+			if err := b(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ErrFailed is an error for behaviors that need no particular one.
+var ErrFailed = errors.New("scripted failure")
+
+// FailOn fails every op call of the item named item with err. An empty op
+// matches every call of the item.
+func FailOn(op, item string, err error) Behavior {
+	return func(c Call) error {
+		if c.Item == item && (op == "" || c.Op == op) {
+			return err
+		}
+		return nil
+	}
+}
+
+// FailDownloads fails the first n download attempts of item with err, so
+// the item only succeeds if its retries allow it.
+func FailDownloads(item string, n int, err error) Behavior {
+	return func(c Call) error {
+		if c.Op == OpDownload && c.Item == item && c.Attempt <= n {
+			return err
+		}
+		return nil
+	}
+}
+
+// PassPreflight makes the preflight script item exit 0, which ends the run.
+func PassPreflight(item string) Behavior {
+	return FailOn(OpPreflight, item, &installer.PreflightSuccessError{})
+}
+
+// Recorder collects the calls of a Downloader and an Installer in the order
+// they were made. Items of a parallel_group, and items installed with an
+// InstallMaxConcurrency above one, are recorded in no particular order.
+type Recorder struct {
+	mu     sync.Mutex
+	calls  []Call
+	byPath map[string]string
+	byName map[string]bool
+}
+
+// NewRecorder creates a Recorder that names calls after the items of
+// bootstrap, which may be nil.
+func NewRecorder(bootstrap *config.Bootstrap) *Recorder {
+	r := &Recorder{byPath: map[string]string{}, byName: map[string]bool{}}
+	if bootstrap == nil {
+		return r
+	}
+	phases := [][]config.Item{bootstrap.Preflight, bootstrap.SetupAssistant, bootstrap.Userland}
+	for _, phase := range []string{"preflight", "setupassistant", "userland"} {
+		phases = append(phases, bootstrap.Hooks.Pre(phase), bootstrap.Hooks.Post(phase))
+	}
+	for _, phase := range phases {
+		for _, item := range phase {
+			if item.File != "" {
+				r.byPath[item.File] = item.Name
+			}
+			r.byName[item.Name] = true
+		}
+	}
+	return r
+}
+
+// itemName returns the name of the item keys (a path, label or name)
+// belong to; of no known item, the first non-empty key.
+func (r *Recorder) itemName(keys ...string) string {
+	for _, key := range keys {
+		if name, ok := r.byPath[key]; ok {
+			return name
+		}
+		if r.byName[key] {
+			return key
+		}
+	}
+	for _, key := range keys {
+		if key != "" {
+			return key
+		}
+	}
+	return ""
+}
+
+// record decides the outcome of c with behavior and appends it.
+func (r *Recorder) record(c Call, behavior Behavior) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if behavior != nil {
+		c.Err = behavior(c)
+	}
+	r.calls = append(r.calls, c)
+	return c.Err
+}
+
+// Calls returns the recorded calls.
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Transcript returns the recorded calls, one per line.
+func (r *Recorder) Transcript() string {
+	var b strings.Builder
+	for _, c := range r.Calls() {
+		b.WriteString(c.String() + "\n")
+	}
+	return b.String()
+}
+
+// Reset forgets the recorded calls.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = nil
+}
+
+// Downloader is a download.Downloader that downloads nothing. Downloads are
+// attempted up to retries+1 times, without waiting in between.
+type Downloader struct {
+	Recorder *Recorder
+	Behavior Behavior
+	// Retries is used when an item sets none, like cfg.MaxRetries
+	Retries int
+	// WriteFiles creates an empty file at each downloaded path, for code
+	// that looks at the files afterwards
+	WriteFiles bool
+
+	ctx context.Context
+}
+
+// Installer is an installer.Installer that installs nothing. A script that
+// is not waited for fails, when its behavior says so, in
+// WaitForBackgroundProcesses if it is tracked, as a real one would.
+type Installer struct {
+	Recorder *Recorder
+	Behavior Behavior
+
+	mu         sync.Mutex
+	background []error
+}
+
+var (
+	_ download.Downloader = (*Downloader)(nil)
+	_ installer.Installer = (*Installer)(nil)
+)
+
+// NewDownloader creates a Downloader recording into r.
+func NewDownloader(r *Recorder, behavior Behavior) *Downloader {
+	return &Downloader{Recorder: r, Behavior: behavior, ctx: context.Background()}
+}
+
+// NewInstaller creates an Installer recording into r.
+func NewInstaller(r *Recorder, behavior Behavior) *Installer {
+	return &Installer{Recorder: r, Behavior: behavior}
+}
+
+// SetContext stops further download attempts when ctx is done.
+func (d *Downloader) SetContext(ctx context.Context) { d.ctx = ctx }
+
+// SetRetryDefaults sets Retries, as the manager does for each phase.
+func (d *Downloader) SetRetryDefaults(retries, retryWait int) { d.Retries = retries }
+
+// DownloadFile downloads with the default retries.
+func (d *Downloader) DownloadFile(url, filepath, expectedHash string) error {
+	return d.DownloadFileWithRetries(url, filepath, expectedHash, 0, 0)
+}
+
+// DownloadFileWithRetries records an attempt per try until one succeeds.
+func (d *Downloader) DownloadFileWithRetries(url, path, expectedHash string, retries int, retryWait int) error {
+	if retries == 0 {
+		retries = d.Retries
+	}
+	name := d.Recorder.itemName(path, url)
+	var err error
+	for attempt := 1; attempt <= retries+1; attempt++ {
+		if d.ctx != nil && d.ctx.Err() != nil {
+			return fmt.Errorf("download of %s stopped: %w", name, context.Cause(d.ctx))
+		}
+		err = d.Recorder.record(Call{Op: OpDownload, Item: name, Path: path, URL: url, Attempt: attempt}, d.Behavior)
+		if err == nil {
+			return d.write(path)
+		}
+	}
+	return err
+}
+
+// write creates the empty file at path when WriteFiles is set.
+func (d *Downloader) write(path string) error {
+	if !d.WriteFiles || path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, nil, 0644)
+}
+
+// VerifyFileHash always passes; nothing was downloaded.
+func (d *Downloader) VerifyFileHash(filepath, expectedHash string) error { return nil }
+
+// DownloadMultipleWithCleanup downloads items one at a time, in order, so
+// the transcript does not depend on scheduling. Items without a URL succeed
+// without a call.
+func (d *Downloader) DownloadMultipleWithCleanup(items []config.Item, maxConcurrency int, cleanupOnFailure bool) []download.DownloadResult {
+	results := make([]download.DownloadResult, len(items))
+	for i, item := range items {
+		results[i] = download.DownloadResult{Item: item}
+		if item.URL == "" {
+			continue
+		}
+		results[i].Error = d.DownloadFileWithRetries(item.URL, item.File, item.Hash, item.Retries, item.RetryWait)
+	}
+	return results
+}
+
+// call records c, naming it after the item of keys.
+func (i *Installer) call(c Call, keys ...string) error {
+	c.Item = i.Recorder.itemName(keys...)
+	return i.Recorder.record(c, i.Behavior)
+}
+
+// script records a script call; a background script's error is kept for
+// WaitForBackgroundProcesses when it is tracked.
+func (i *Installer) script(c Call, doNotWait, track bool) error {
+	c.Background = doNotWait
+	err := i.call(c, c.Path)
+	if !doNotWait {
+		return err
+	}
+	if track {
+		i.mu.Lock()
+		i.background = append(i.background, err)
+		i.mu.Unlock()
+	}
+	return nil
+}
+
+// InstallPackage records a package install.
+func (i *Installer) InstallPackage(pkgPath string, opts installer.PackageOptions) error {
+	return i.call(Call{Op: "package", Path: pkgPath}, pkgPath)
+}
+
+// ExecuteScript records a rootscript, or a script of scriptType.
+func (i *Installer) ExecuteScript(scriptPath, scriptType string, doNotWait bool, trackBackgroundProcesses bool) error {
+	return i.script(Call{Op: scriptType, Path: scriptPath}, doNotWait, trackBackgroundProcesses)
+}
+
+// ExecuteScriptAs records a rootscript run as runAs.
+func (i *Installer) ExecuteScriptAs(scriptPath, runAs string, doNotWait bool, trackBackgroundProcesses bool) error {
+	return i.script(Call{Op: "rootscript", Path: scriptPath, Detail: "as " + runAs}, doNotWait, trackBackgroundProcesses)
+}
+
+// ExecuteUserScript records a userscript.
+func (i *Installer) ExecuteUserScript(scriptPath string, uc installer.UserContext, doNotWait bool, trackBackgroundProcesses bool) error {
+	return i.script(Call{Op: "userscript", Path: scriptPath}, doNotWait, trackBackgroundProcesses)
+}
+
+// ExecuteScriptForPreflight records the preflight script. It exits
+// non-zero, so the run continues, unless the behavior returns a
+// *installer.PreflightSuccessError, see PassPreflight.
+func (i *Installer) ExecuteScriptForPreflight(scriptPath, scriptType string, doNotWait bool, trackBackgroundProcesses bool) error {
+	return i.call(Call{Op: OpPreflight, Path: scriptPath}, scriptPath)
+}
+
+// PlaceFile records a rootfile or userfile.
+func (i *Installer) PlaceFile(filePath, fileType string, opts installer.FileOptions) error {
+	return i.call(Call{Op: fileType, Path: filePath}, filePath)
+}
+
+// ManageService records a launchd item.
+func (i *Installer) ManageService(opts installer.ServiceOptions) error {
+	return i.call(Call{Op: "launchd", Path: opts.Plist, Detail: opts.Action}, opts.Plist, opts.Label)
+}
+
+// InstallAppStoreApp records a vppapp item.
+func (i *Installer) InstallAppStoreApp(opts installer.VPPAppOptions) error {
+	return i.call(Call{Op: "vppapp", Path: opts.AppPath}, opts.Name, opts.AppPath)
+}
+
+// ConfigureMunki records the Munki handoff.
+func (i *Installer) ConfigureMunki(opts installer.MunkiOptions) error {
+	return i.call(Call{Op: "munki", URL: opts.SoftwareRepoURL}, opts.SoftwareRepoURL)
+}
+
+// WaitForBackgroundProcesses returns the errors of the tracked background
+// scripts started since the last call.
+func (i *Installer) WaitForBackgroundProcesses(timeout time.Duration) []error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var errs []error
+	for _, err := range i.background {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	i.background = nil
+	return errs
+}
+
+// GetBackgroundProcessCount returns the number of tracked background
+// scripts not yet waited for.
+func (i *Installer) GetBackgroundProcessCount() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return len(i.background)
+}
@@ -0,0 +1,130 @@
+package testsupport
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/runner"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// UpdateGoldenEnv names the environment variable that makes AssertGolden
+// write the golden files instead of comparing with them:
+//
+//	UPDATE_GOLDEN=1 go test ./...
+const UpdateGoldenEnv = "UPDATE_GOLDEN"
+
+// Harness runs a bootstrap through a Downloader and an Installer with the
+// runner, as daemon mode would. Its fields can be changed before Run.
+type Harness struct {
+	Config     *config.Config
+	Bootstrap  *config.Bootstrap
+	Recorder   *Recorder
+	Downloader *Downloader
+	Installer  *Installer
+	Logger     utils.Logger
+}
+
+// NewHarness creates a Harness for bootstrap whose calls behave as behavior
+// says. The config installs into a temporary directory, does not wait
+// between retries and installs one item at a time, so the transcript does
+// not depend on scheduling.
+func NewHarness(t testing.TB, bootstrap *config.Bootstrap, behavior Behavior) *Harness {
+	t.Helper()
+	cfg := config.NewConfig()
+	cfg.InstallPath = t.TempDir()
+	cfg.RetryDelay = 0
+	cfg.InstallMaxConcurrency = 1
+	recorder := NewRecorder(bootstrap)
+	return &Harness{
+		Config:     cfg,
+		Bootstrap:  bootstrap,
+		Recorder:   recorder,
+		Downloader: NewDownloader(recorder, behavior),
+		Installer:  NewInstaller(recorder, behavior),
+		Logger:     utils.NewLoggerWithWriter(false, false, io.Discard),
+	}
+}
+
+// LoadHarness is NewHarness for the bootstrap JSON file at path.
+func LoadHarness(t testing.TB, path string, behavior Behavior) *Harness {
+	t.Helper()
+	bootstrap, err := config.LoadBootstrapWithOptions(path, false)
+	if err != nil {
+		t.Fatalf("load %s: %v", path, err)
+	}
+	return NewHarness(t, bootstrap, behavior)
+}
+
+// Run runs every phase and returns the transcript of the calls, ending
+// with a "result:" line with the run's error or "ok". Downloads are retried
+// Config.MaxRetries times.
+func (h *Harness) Run(ctx context.Context) (string, error) {
+	h.Downloader.Retries = h.Config.MaxRetries
+	r, err := runner.New(h.Config, h.Bootstrap, h.Logger)
+	if err != nil {
+		return "", err
+	}
+	r.SetDownloader(h.Downloader)
+	r.SetInstaller(h.Installer)
+	err = r.Run(ctx)
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	return h.Recorder.Transcript() + "result: " + result + "\n", err
+}
+
+// AssertGolden fails t when got differs from the golden file at path, or
+// writes got to it when UpdateGoldenEnv is set.
+func AssertGolden(t testing.TB, path, got string) {
+	t.Helper()
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with %s=1 to create it)", err, UpdateGoldenEnv)
+	}
+	if got != string(want) {
+		t.Errorf("transcript differs from %s (run with %s=1 to update it)\n%s", path, UpdateGoldenEnv, diffLines(string(want), got))
+	}
+}
+
+// diffLines marks the lines of want and got that differ, line by line.
+func diffLines(want, got string) string {
+	w := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	g := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	var b strings.Builder
+	for i := 0; i < len(w) || i < len(g); i++ {
+		var wl, gl string
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if wl == gl {
+			b.WriteString("  " + wl + "\n")
+			continue
+		}
+		if i < len(w) {
+			b.WriteString("want " + wl + "\n")
+		}
+		if i < len(g) {
+			b.WriteString("got  " + gl + "\n")
+		}
+	}
+	return b.String()
+}
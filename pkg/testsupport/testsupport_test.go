@@ -0,0 +1,75 @@
+package testsupport
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/runner"
+)
+
+func TestHarness_Golden(t *testing.T) {
+	cases := []struct {
+		name     string
+		behavior Behavior
+		wantErr  error
+	}{
+		{name: "success"},
+		{name: "retried_download", behavior: FailDownloads("App", 2, errors.New("connection reset"))},
+		{name: "preflight_passed", behavior: PassPreflight("Check"), wantErr: runner.ErrPreflightPassed},
+		{name: "required_item_failed", behavior: FailOn("userscript", "Dock", ErrFailed)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := LoadHarness(t, "testdata/bootstrap.json", tc.behavior)
+			h.Config.MaxRetries = 2
+			got, err := h.Run(context.Background())
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Errorf("Run: %v, want %v", err, tc.wantErr)
+			}
+			AssertGolden(t, filepath.Join("testdata", tc.name+".golden"), got)
+		})
+	}
+}
+
+func TestInstaller_BackgroundFailureReportedOnWait(t *testing.T) {
+	inst := NewInstaller(NewRecorder(nil), FailOn("", "/tmp/bg.sh", ErrFailed))
+	if err := inst.ExecuteScript("/tmp/bg.sh", "rootscript", true, true); err != nil {
+		t.Fatalf("starting a background script: %v", err)
+	}
+	if n := inst.GetBackgroundProcessCount(); n != 1 {
+		t.Errorf("background count = %d, want 1", n)
+	}
+	if errs := inst.WaitForBackgroundProcesses(0); len(errs) != 1 || !errors.Is(errs[0], ErrFailed) {
+		t.Errorf("WaitForBackgroundProcesses = %v, want [%v]", errs, ErrFailed)
+	}
+	if got := inst.Recorder.Transcript(); got != "rootscript /tmp/bg.sh [background]: scripted failure\n" {
+		t.Errorf("transcript = %q", got)
+	}
+}
+
+func TestDownloader_WriteFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "app.pkg")
+	d := NewDownloader(NewRecorder(nil), nil)
+	d.WriteFiles = true
+	if err := d.DownloadFile("https://example.com/app.pkg", path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("downloaded file not written: %v", err)
+	}
+}
+
+func TestAssertGolden_ReportsDifferences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.golden")
+	if err := os.WriteFile(path, []byte("a\nb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if diff := diffLines("a\nb\n", "a\nc\n"); !strings.Contains(diff, "want b\ngot  c") {
+		t.Errorf("diff = %q", diff)
+	}
+	AssertGolden(t, path, "a\nb\n")
+}
@@ -0,0 +1,329 @@
+// Package tracing records run → phase → item → download/install spans and
+// exports them over OTLP/HTTP JSON when the run finishes.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/otlp"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+// DefaultServiceName is the OTLP service.name used when none is configured.
+const DefaultServiceName = "go-installapplications"
+
+// Options controls where spans are exported.
+type Options struct {
+	// Endpoint is the OTLP/HTTP traces URL, e.g. http://collector:4318/v1/traces.
+	Endpoint    string
+	ServiceName string
+	Headers     map[string]string
+}
+
+// Span is one timed operation. All methods are safe on a nil *Span so
+// callers never need to check whether tracing is enabled.
+type Span struct {
+	tracer   *Tracer
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	start    time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs []otlp.KeyValue
+	err   error
+	ended bool
+}
+
+// SetAttr adds a string attribute to the span.
+func (s *Span) SetAttr(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, otlp.String(key, value))
+}
+
+// StartChild starts a span under s.
+func (s *Span) StartChild(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.newSpan(name, s)
+}
+
+// End finishes the span; err marks it failed. Only the first call counts.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.err = err
+	s.mu.Unlock()
+	s.tracer.record(s)
+}
+
+// Tracer owns the span tree for one run. It satisfies progress.Reporter so
+// item spans close on the same events that drive the progress UI; phases,
+// downloads and installs are opened explicitly by the manager and download
+// client. All methods are safe on a nil *Tracer.
+type Tracer struct {
+	opts   Options
+	logger utils.Logger
+	client *http.Client
+
+	mu       sync.Mutex
+	traceID  string
+	run      *Span
+	phase    *Span
+	items    map[string]*Span // open item spans by item name
+	finished []*Span
+}
+
+// NewTracer creates a Tracer. Spans are buffered until Finish.
+func NewTracer(opts Options, logger utils.Logger) *Tracer {
+	if opts.ServiceName == "" {
+		opts.ServiceName = DefaultServiceName
+	}
+	return &Tracer{
+		opts:    opts,
+		logger:  logger,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		traceID: randomHex(16),
+		items:   map[string]*Span{},
+	}
+}
+
+func (t *Tracer) newSpan(name string, parent *Span) *Span {
+	s := &Span{tracer: t, traceID: t.traceID, spanID: randomHex(8), name: name, start: time.Now()}
+	if parent != nil {
+		s.parentID = parent.spanID
+	}
+	return s
+}
+
+func (t *Tracer) record(s *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.finished = append(t.finished, s)
+}
+
+// StartPhase opens a phase span under the run span. Phases run one at a
+// time; item spans started afterwards are parented to it.
+func (t *Tracer) StartPhase(name string) *Span {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.phase = t.newSpan("phase "+name, t.run)
+	t.phase.SetAttr("phase", name)
+	return t.phase
+}
+
+// StartItem opens the span for item under the current phase, or returns the
+// open one. It covers the item's download and install.
+func (t *Tracer) StartItem(item config.Item) *Span {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.items[item.Name]; ok {
+		return s
+	}
+	parent := t.phase
+	if parent == nil {
+		parent = t.run
+	}
+	s := t.newSpan("item "+item.Name, parent)
+	s.SetAttr("item.name", item.Name)
+	s.SetAttr("item.type", item.Type)
+	t.items[item.Name] = s
+	return s
+}
+
+// Item returns the open span for the named item, or nil.
+func (t *Tracer) Item(name string) *Span {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.items[name]
+}
+
+func (t *Tracer) endItem(name string, err error, skipReason string) {
+	t.mu.Lock()
+	s, ok := t.items[name]
+	delete(t.items, name)
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+	if skipReason != "" {
+		s.SetAttr("item.skipped", skipReason)
+	}
+	s.End(err)
+}
+
+// Start opens the run span.
+func (t *Tracer) Start(items []config.Item) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.run == nil {
+		t.run = t.newSpan("bootstrap", nil)
+		t.run.SetAttr("items.count", strconv.Itoa(len(items)))
+	}
+}
+
+// ItemStarted opens the item span if the caller did not already.
+func (t *Tracer) ItemStarted(item config.Item) {
+	t.StartItem(item)
+}
+
+// ItemFinished closes the item span.
+func (t *Tracer) ItemFinished(item config.Item, err error) {
+	if t == nil {
+		return
+	}
+	t.endItem(item.Name, err, "")
+}
+
+// ItemSkipped records a zero-length span for the skipped item.
+func (t *Tracer) ItemSkipped(item config.Item, reason string) {
+	if t == nil {
+		return
+	}
+	t.StartItem(item)
+	t.endItem(item.Name, nil, reason)
+}
+
+// Finish closes any open spans and exports the trace. Export failures are
+// logged only.
+func (t *Tracer) Finish(err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	open := make([]*Span, 0, len(t.items)+2)
+	for _, s := range t.items {
+		open = append(open, s)
+	}
+	t.items = map[string]*Span{}
+	open = append(open, t.phase, t.run)
+	t.mu.Unlock()
+	for _, s := range open {
+		s.End(err)
+	}
+
+	if t.opts.Endpoint == "" {
+		return
+	}
+	if xerr := otlp.Post(t.client, t.opts.Endpoint, t.opts.Headers, t.payload()); xerr != nil {
+		t.logger.Info("⚠️  Failed to export trace: %v", xerr)
+		return
+	}
+	t.logger.Debug("Exported trace %s to %s", t.traceID, t.opts.Endpoint)
+}
+
+// TraceID returns the run's trace ID (hex).
+func (t *Tracer) TraceID() string {
+	if t == nil {
+		return ""
+	}
+	return t.traceID
+}
+
+// OTLP/HTTP JSON span encoding (opentelemetry-proto trace/v1).
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlp.KeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// Span kind and status codes from the OTLP spec.
+const (
+	spanKindInternal = 1
+	statusOK         = 1
+	statusError      = 2
+)
+
+// payload encodes every finished span as an ExportTraceServiceRequest.
+func (t *Tracer) payload() map[string]interface{} {
+	t.mu.Lock()
+	finished := append([]*Span(nil), t.finished...)
+	t.mu.Unlock()
+
+	spans := make([]otlpSpan, 0, len(finished))
+	for _, s := range finished {
+		s.mu.Lock()
+		out := otlpSpan{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        append([]otlp.KeyValue(nil), s.attrs...),
+			Status:            otlpStatus{Code: statusOK},
+		}
+		if s.err != nil {
+			out.Status = otlpStatus{Code: statusError, Message: s.err.Error()}
+		}
+		s.mu.Unlock()
+		spans = append(spans, out)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": otlp.NewResource(t.opts.ServiceName, otlp.Hostname()),
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": otlp.Scope{Name: "github.com/go-installapplications/pkg/tracing"},
+				"spans": spans,
+			}},
+		}},
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		// Fall back to a time-derived ID; uniqueness within a run is enough.
+		ts := time.Now().UnixNano()
+		for i := range b {
+			b[i] = byte(ts >> (8 * (i % 8)))
+		}
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,96 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+	"github.com/go-installapplications/pkg/utils"
+)
+
+type exportedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Status       struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+// collector starts an OTLP endpoint that stores the spans it receives.
+func collector(t *testing.T) (string, *[]exportedSpan) {
+	t.Helper()
+	var spans []exportedSpan
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []exportedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		spans = append(spans, req.ResourceSpans[0].ScopeSpans[0].Spans...)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/v1/traces", &spans
+}
+
+func TestTracer_SpanTree(t *testing.T) {
+	endpoint, spans := collector(t)
+	tr := NewTracer(Options{Endpoint: endpoint}, utils.NewLogger(false, false))
+
+	pkg := config.Item{Name: "Tool", Type: "package"}
+	skipped := config.Item{Name: "Rosetta", Type: "package"}
+	tr.Start([]config.Item{pkg, skipped})
+	phase := tr.StartPhase("setupassistant")
+	tr.StartItem(pkg)
+	tr.Item(pkg.Name).StartChild("download").End(nil)
+	tr.ItemSkipped(skipped, "skip_if arm64")
+	tr.Item(pkg.Name).StartChild("install").End(errors.New("installer failed"))
+	tr.ItemFinished(pkg, errors.New("installer failed"))
+	phase.End(nil)
+	tr.Finish(nil)
+
+	byName := map[string]exportedSpan{}
+	for _, s := range *spans {
+		if s.TraceID != tr.TraceID() {
+			t.Fatalf("span %s has trace %s, want %s", s.Name, s.TraceID, tr.TraceID())
+		}
+		byName[s.Name] = s
+	}
+	parentOf := func(child, parent string) {
+		t.Helper()
+		if byName[child].ParentSpanID != byName[parent].SpanID {
+			t.Fatalf("%s should be a child of %s: %+v", child, parent, *spans)
+		}
+	}
+	if len(byName) != 6 {
+		t.Fatalf("expected 6 spans, got %+v", *spans)
+	}
+	parentOf("phase setupassistant", "bootstrap")
+	parentOf("item Tool", "phase setupassistant")
+	parentOf("item Rosetta", "phase setupassistant")
+	parentOf("download", "item Tool")
+	parentOf("install", "item Tool")
+	if byName["install"].Status.Code != statusError || byName["bootstrap"].Status.Code != statusOK {
+		t.Fatalf("unexpected statuses: %+v", *spans)
+	}
+}
+
+func TestTracer_NilIsSafe(t *testing.T) {
+	var tr *Tracer
+	tr.Start(nil)
+	tr.StartPhase("userland").End(nil)
+	tr.StartItem(config.Item{Name: "x"})
+	tr.Item("x").StartChild("install").End(nil)
+	tr.ItemFinished(config.Item{Name: "x"}, nil)
+	tr.Finish(nil)
+}
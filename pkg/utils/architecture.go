@@ -54,7 +54,7 @@ func IsIntel() bool {
 }
 
 // ShouldSkipItem checks if an item should be skipped based on skip_if criteria
-func ShouldSkipItem(skipIf string, logger *Logger) bool {
+func ShouldSkipItem(skipIf string, logger Logger) bool {
 	if skipIf == "" {
 		return false // No skip criteria, don't skip
 	}
@@ -70,18 +70,70 @@ func ShouldSkipItem(skipIf string, logger *Logger) bool {
 	case "x86_64", "intel":
 		shouldSkip = IsIntel()
 		logger.Debug("Is Intel: %t", shouldSkip)
+	case "virtual_machine", "vm":
+		shouldSkip = GetSystemInfo().Virtual
+		logger.Debug("Is a virtual machine: %t", shouldSkip)
+	case "physical":
+		shouldSkip = !GetSystemInfo().Virtual
+		logger.Debug("Is a physical Mac: %t", shouldSkip)
 	default:
-		logger.Debug("Unknown skip_if criteria '%s', not skipping", skipIf)
-		return false
+		op, version, ok := parseOSCondition(skipIf)
+		if !ok {
+			logger.Debug("Unknown skip_if criteria '%s', not skipping", skipIf)
+			return false
+		}
+		current := GetSystemInfo().ProductVersion
+		cmp, err := CompareVersions(current, version)
+		if err != nil {
+			logger.Debug("Cannot compare macOS %q with skip_if '%s' (%v), not skipping", current, skipIf, err)
+			return false
+		}
+		shouldSkip = osConditionHolds(op, cmp)
+		logger.Debug("macOS %s %s %s: %t", current, op, version, shouldSkip)
 	}
 
 	if shouldSkip {
-		logger.Debug("Item should be skipped based on architecture")
+		logger.Debug("Item should be skipped based on skip_if '%s'", skipIf)
 	}
 
 	return shouldSkip
 }
 
+// osOperators are the comparisons of a macOS version condition, longest
+// first so "<=" is not read as "<".
+var osOperators = []string{"<=", ">=", "==", "<", ">"}
+
+// parseOSCondition splits a macOS version condition such as "macos<14" or
+// "macos>=15.1" into its operator and version.
+func parseOSCondition(cond string) (op, version string, ok bool) {
+	rest, ok := strings.CutPrefix(cond, "macos")
+	if !ok {
+		return "", "", false
+	}
+	rest = strings.TrimSpace(rest)
+	for _, op := range osOperators {
+		if version, ok := strings.CutPrefix(rest, op); ok && strings.TrimSpace(version) != "" {
+			return op, strings.TrimSpace(version), true
+		}
+	}
+	return "", "", false
+}
+
+// osConditionHolds applies op to the result of CompareVersions(current, wanted).
+func osConditionHolds(op string, cmp int) bool {
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return cmp == 0
+}
+
 // GetArchitectureInfo returns human-readable architecture information
 func GetArchitectureInfo() string {
 	arch := runtime.GOARCH
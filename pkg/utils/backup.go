@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/go-installapplications/pkg/audit"
+)
+
+// BackupPath is where the backup of path is kept: under dir with the full
+// path of the file, or next to it as <path>.bak when dir is empty.
+func BackupPath(path, dir string) string {
+	if dir == "" {
+		return path + ".bak"
+	}
+	return filepath.Join(dir, path)
+}
+
+// BackupFile copies path to its BackupPath, keeping its mode, owner and
+// modification time, before a download replaces it. It reports whether there
+// was a file to back up; when there was not, a backup left by an earlier run
+// is removed so that it is never restored over a file it did not come from.
+func BackupFile(path, dir string) (bool, error) {
+	backup := BackupPath(path, dir)
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("failed to remove stale backup %s: %w", backup, err)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.Mode().IsRegular() {
+		return false, fmt.Errorf("cannot back up %s: not a regular file", path)
+	}
+
+	err = copyFileWithMetadata(path, backup, info)
+	audit.Record(audit.Event{
+		Action:  audit.ActionFileBackup,
+		Target:  path,
+		SHA256:  audit.FileSHA256(path),
+		Outcome: audit.Outcome(err),
+		Error:   audit.ErrorString(err),
+		Details: map[string]string{"backup": backup},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// RestoreBackup moves the backup of path back into place. It reports
+// whether there was a backup to restore.
+func RestoreBackup(path, dir string) (bool, error) {
+	backup := BackupPath(path, dir)
+	info, err := os.Stat(backup)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err = os.Rename(backup, path); err != nil {
+		// The backup dir may be on another volume
+		if err = copyFileWithMetadata(backup, path, info); err == nil {
+			err = os.Remove(backup)
+		}
+	}
+	audit.Record(audit.Event{
+		Action:  audit.ActionFileRestore,
+		Target:  path,
+		SHA256:  audit.FileSHA256(path),
+		Outcome: audit.Outcome(err),
+		Error:   audit.ErrorString(err),
+		Details: map[string]string{"backup": backup},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to restore %s from %s: %w", path, backup, err)
+	}
+	return true, nil
+}
+
+// copyFileWithMetadata copies src, described by info, to dst with the same
+// mode, owner and modification time, creating dst's directory if needed.
+func copyFileWithMetadata(src, dst string, info os.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, in)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		// Only root can give a file away; a user keeps their own files
+		if err := os.Lchown(tmp.Name(), int(st.Uid), int(st.Gid)); err != nil && os.Geteuid() == 0 {
+			return err
+		}
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		path := filepath.Join(t.TempDir(), "sshd_config")
+		if err := os.WriteFile(path, []byte("original"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		backedUp, err := BackupFile(path, dir)
+		if err != nil || !backedUp {
+			t.Fatalf("backup: %v %v", backedUp, err)
+		}
+		info, err := os.Stat(BackupPath(path, dir))
+		if err != nil {
+			t.Fatalf("stat backup: %v", err)
+		}
+		if info.Mode().Perm() != 0600 || !info.ModTime().Equal(mtime) {
+			t.Errorf("backup metadata: mode %v, mtime %v", info.Mode().Perm(), info.ModTime())
+		}
+
+		if err := os.WriteFile(path, []byte("replacement"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		restored, err := RestoreBackup(path, dir)
+		if err != nil || !restored {
+			t.Fatalf("restore: %v %v", restored, err)
+		}
+		if data, _ := os.ReadFile(path); string(data) != "original" {
+			t.Errorf("restored content = %q", data)
+		}
+		if restored, _ := RestoreBackup(path, dir); restored {
+			t.Error("restored twice")
+		}
+	}
+}
+
+func TestBackupFile_RemovesStaleBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf")
+	if err := os.WriteFile(path+".bak", []byte("from an earlier run"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if backedUp, err := BackupFile(path, ""); err != nil || backedUp {
+		t.Fatalf("backup of a missing file: %v %v", backedUp, err)
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("stale backup kept: %v", err)
+	}
+}
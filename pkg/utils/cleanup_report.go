@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+)
+
+// CleanupStep is one removal or bootout done while cleaning up.
+type CleanupStep struct {
+	Action string // e.g. "remove", "bootout"
+	Target string
+	Err    error
+}
+
+// String describes the step, e.g. "remove /Library/go-installapplications".
+func (s CleanupStep) String() string {
+	return s.Action + " " + s.Target
+}
+
+// CleanupReport lists the steps of a cleanup, in order.
+type CleanupReport struct {
+	Type  string // the cleanupType given to Cleanup, e.g. "exit"
+	Steps []CleanupStep
+}
+
+// Failed returns the steps that failed.
+func (r CleanupReport) Failed() []CleanupStep {
+	var failed []CleanupStep
+	for _, s := range r.Steps {
+		if s.Err != nil {
+			failed = append(failed, s)
+		}
+	}
+	return failed
+}
+
+// Summary counts the steps, e.g. "5 steps, 1 failed".
+func (r CleanupReport) Summary() string {
+	return fmt.Sprintf("%d steps, %d failed", len(r.Steps), len(r.Failed()))
+}
+
+var (
+	cleanupMu    sync.Mutex
+	cleanupSteps []CleanupStep
+	cleanupHooks []func(CleanupReport)
+)
+
+// RecordCleanup adds a cleanup step to the report FinishCleanup logs. Steps
+// with nothing to do, such as removing a file that is already gone, are not
+// recorded.
+func RecordCleanup(action, target string, err error) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	cleanupSteps = append(cleanupSteps, CleanupStep{Action: action, Target: target, Err: err})
+}
+
+// OnCleanup calls fn with every report FinishCleanup logs, for reports that
+// outlive the run, such as the status plist.
+func OnCleanup(fn func(CleanupReport)) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	cleanupHooks = append(cleanupHooks, fn)
+}
+
+// FinishCleanup logs the steps recorded since the last call at Info, each
+// failure on its own line, hands the report to the OnCleanup functions and
+// returns it. Cleanup failures never fail the run, but they explain files
+// and services left behind.
+func FinishCleanup(logger Logger, cleanupType string) CleanupReport {
+	cleanupMu.Lock()
+	report := CleanupReport{Type: cleanupType, Steps: cleanupSteps}
+	cleanupSteps = nil
+	hooks := append([]func(CleanupReport){}, cleanupHooks...)
+	cleanupMu.Unlock()
+
+	for _, s := range report.Steps {
+		if s.Err == nil {
+			logger.Debug("Cleanup: %s", s)
+		}
+	}
+	failed := report.Failed()
+	for _, s := range failed {
+		logger.Info("⚠️  Cleanup could not %s: %v", s, s.Err)
+	}
+	if len(failed) > 0 {
+		logger.Info("🧹 %s cleanup finished with errors: %s", cleanupType, report.Summary())
+	} else {
+		logger.Info("🧹 %s cleanup report: %s", cleanupType, report.Summary())
+	}
+	for _, fn := range hooks {
+		fn(report)
+	}
+	return report
+}
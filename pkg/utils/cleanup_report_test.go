@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFinishCleanup_LogsFailuresAndCallsHooks(t *testing.T) {
+	var got []CleanupReport
+	OnCleanup(func(r CleanupReport) { got = append(got, r) })
+	defer func() { cleanupHooks = nil }()
+
+	RecordCleanup("remove", "/tmp/app.pkg", nil)
+	RecordCleanup("bootout", "gui/501/com.example.agent", errors.New("exit status 5"))
+	var buf bytes.Buffer
+	report := FinishCleanup(NewLoggerWithWriter(false, false, &buf), "exit")
+
+	if report.Summary() != "2 steps, 1 failed" {
+		t.Errorf("summary = %q", report.Summary())
+	}
+	log := buf.String()
+	if !strings.Contains(log, "INFO: ⚠️  Cleanup could not bootout gui/501/com.example.agent: exit status 5") {
+		t.Errorf("failure not logged at Info:\n%s", log)
+	}
+	if !strings.Contains(log, "exit cleanup finished with errors: 2 steps, 1 failed") {
+		t.Errorf("summary not logged:\n%s", log)
+	}
+	if len(got) != 1 || len(got[0].Steps) != 2 {
+		t.Errorf("hook got %+v", got)
+	}
+
+	// The steps are reported once
+	if again := FinishCleanup(NewLoggerWithWriter(false, false, &buf), "exit"); len(again.Steps) != 0 {
+		t.Errorf("steps reported twice: %+v", again.Steps)
+	}
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ConsoleUser is the user who owns the console. At the login window nobody
+// does: Name is empty and UID is "0", as /dev/console then belongs to root.
+// During Setup Assistant, including the per-user one after the first
+// login, the console belongs to _mbsetupuser (SetupAssistantUID).
+type ConsoleUser struct {
+	Name string
+	UID  string
+}
+
+// AtLoginWindow reports whether no user is logged in at the console.
+func (u ConsoleUser) AtLoginWindow() bool {
+	return u.Name == ""
+}
+
+// loginWindow is the ConsoleUser while nobody is logged in.
+var loginWindow = ConsoleUser{UID: "0"}
+
+// Console user probes; variables so tests can replace them.
+var (
+	// readConsoleUserKey prints the State:/Users/ConsoleUser key of the
+	// SystemConfiguration dynamic store, which loginwindow keeps current
+	readConsoleUserKey = func() (string, error) {
+		cmd := exec.Command("scutil")
+		cmd.Stdin = strings.NewReader("show State:/Users/ConsoleUser\n")
+		out, err := cmd.Output()
+		return string(out), err
+	}
+	// statConsole prints the owner of /dev/console, name and UID
+	statConsole = func() (string, error) {
+		return RunCommandCapture([]string{"stat", "-f", "%Su %u", "/dev/console"})
+	}
+)
+
+// GetConsoleUser returns the console user from the SystemConfiguration
+// dynamic store, like SCDynamicStoreCopyConsoleUser. The owner of
+// /dev/console, which lags behind logins and fast user switches, is only
+// the fallback when the store cannot be read.
+func GetConsoleUser() (ConsoleUser, error) {
+	out, err := readConsoleUserKey()
+	if err == nil {
+		if user, ok := parseConsoleUserKey(out); ok {
+			return user, nil
+		}
+	}
+	out, statErr := statConsole()
+	if statErr != nil {
+		if err == nil {
+			err = fmt.Errorf("unexpected scutil output")
+		}
+		return ConsoleUser{}, fmt.Errorf("console user: %v; stat /dev/console: %w", err, statErr)
+	}
+	return parseConsoleOwner(out)
+}
+
+// GetConsoleUserUID returns the UID of the console user, "0" at the login
+// window.
+func GetConsoleUserUID() (string, error) {
+	user, err := GetConsoleUser()
+	if err != nil {
+		return "", err
+	}
+	return user.UID, nil
+}
+
+// parseConsoleUserKey reads the top-level Name and UID of `scutil` output for
+// State:/Users/ConsoleUser, skipping the nested SessionInfo dictionaries. A
+// missing key or "loginwindow" as the name means nobody is logged in; ok is
+// false when the output is neither a user nor one of those.
+func parseConsoleUserKey(out string) (user ConsoleUser, ok bool) {
+	if strings.Contains(out, "No such key") {
+		return loginWindow, true
+	}
+	depth := 0
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "}") {
+			depth--
+			continue
+		}
+		key, value, found := strings.Cut(line, " : ")
+		if depth == 1 && found {
+			switch key {
+			case "Name":
+				user.Name = value
+			case "UID":
+				user.UID = value
+			}
+		}
+		if strings.HasSuffix(line, "{") {
+			depth++
+		}
+	}
+	if user.Name == "loginwindow" || (user.Name == "" && user.UID == "0") {
+		return loginWindow, true
+	}
+	return user, user.Name != "" && user.UID != ""
+}
+
+// parseConsoleOwner reads `stat -f "%Su %u" /dev/console`; root owns the
+// console at the login window.
+func parseConsoleOwner(out string) (ConsoleUser, error) {
+	name, uid, found := strings.Cut(strings.TrimSpace(out), " ")
+	if !found || uid == "" {
+		return ConsoleUser{}, fmt.Errorf("unexpected stat output %q", out)
+	}
+	if uid == "0" || name == "root" {
+		return loginWindow, nil
+	}
+	return ConsoleUser{Name: name, UID: uid}, nil
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+const scutilConsoleUser = `<dictionary> {
+  GID : 20
+  Name : alice
+  SessionInfo : <array> {
+    0 : <dictionary> {
+      kCGSSessionAuditIDKey : 100008
+      kCGSSessionUserIDKey : 501
+      kCGSSessionUserNameKey : alice
+      kCGSessionLoginDoneKey : TRUE
+    }
+    1 : <dictionary> {
+      kCGSSessionUserIDKey : 502
+      kCGSSessionUserNameKey : bob
+    }
+  }
+  UID : 501
+}`
+
+func TestParseConsoleUserKey(t *testing.T) {
+	cases := []struct {
+		name string
+		out  string
+		want ConsoleUser
+		ok   bool
+	}{
+		{"user", scutilConsoleUser, ConsoleUser{Name: "alice", UID: "501"}, true},
+		{"setup assistant", "<dictionary> {\n  Name : _mbsetupuser\n  UID : 248\n}", ConsoleUser{Name: "_mbsetupuser", UID: SetupAssistantUID}, true},
+		{"login window", "<dictionary> {\n  Name : loginwindow\n  UID : 0\n}", loginWindow, true},
+		{"no key", "  No such key", loginWindow, true},
+		{"garbage", "scutil: command not found", ConsoleUser{}, false},
+	}
+	for _, tc := range cases {
+		got, ok := parseConsoleUserKey(tc.out)
+		if ok != tc.ok || (ok && got != tc.want) {
+			t.Errorf("%s: got %+v, %v; want %+v, %v", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestGetConsoleUserFallsBackToStat(t *testing.T) {
+	oldRead, oldStat := readConsoleUserKey, statConsole
+	defer func() { readConsoleUserKey, statConsole = oldRead, oldStat }()
+	readConsoleUserKey = func() (string, error) { return "", errors.New("no scutil") }
+
+	statConsole = func() (string, error) { return "alice 501", nil }
+	if user, err := GetConsoleUser(); err != nil || user != (ConsoleUser{Name: "alice", UID: "501"}) {
+		t.Errorf("GetConsoleUser() = %+v, %v", user, err)
+	}
+
+	statConsole = func() (string, error) { return "root 0", nil }
+	user, err := GetConsoleUser()
+	if err != nil || !user.AtLoginWindow() || user.UID != "0" {
+		t.Errorf("at the login window: GetConsoleUser() = %+v, %v", user, err)
+	}
+
+	statConsole = func() (string, error) { return "", errors.New("no stat") }
+	if _, err := GetConsoleUserUID(); err == nil {
+		t.Error("GetConsoleUserUID() succeeded without a source")
+	}
+}
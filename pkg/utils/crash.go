@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/go-installapplications/pkg/audit"
+	"github.com/go-installapplications/pkg/version"
+)
+
+// crashDir is where crash reports are written. Set it with SetCrashDir; when
+// it cannot be written the reports go to the temporary directory.
+var crashDir = os.TempDir()
+
+// SetCrashDir sets the directory crash reports are written to.
+func SetCrashDir(dir string) {
+	crashDir = dir
+}
+
+// Recover turns a panic into a crash report and a clean exit. Defer it first
+// thing in mode entry points and in goroutines, naming what is running:
+//
+//	defer utils.Recover(logger, "download worker")
+//
+// The panic and its stack trace are logged, written to a crash report and
+// recorded in the audit log, then the process exits with ExitPanic, without
+// cleanup, so launchd runs the daemon again like after any other failure.
+func Recover(logger Logger, where string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := debug.Stack()
+	if logger != nil {
+		logger.Error("💥 Panic in %s: %v\n%s", where, r, stack)
+	} else {
+		fmt.Fprintf(os.Stderr, "Panic in %s: %v\n%s", where, r, stack)
+	}
+	path, err := WriteCrashReport(where, r, stack)
+	if logger != nil {
+		if err != nil {
+			logger.Error("Failed to write crash report: %v", err)
+		} else {
+			logger.Error("Crash report written to %s", path)
+		}
+	}
+	audit.Record(audit.Event{Action: audit.ActionCrash, Target: where, Outcome: audit.OutcomeFailure, Error: fmt.Sprint(r), Details: map[string]string{"report": path}})
+	_ = audit.Default().Close()
+	os.Exit(int(ExitPanic))
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// WriteCrashReport writes a crash report for a panic with value r in where
+// and returns its path: crash-<where>-<time>.log in the crash directory, or
+// in the temporary directory when that fails.
+func WriteCrashReport(where string, r interface{}, stack []byte) (string, error) {
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "Time: %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Build: %s\n", version.Get())
+	fmt.Fprintf(&b, "Command: %s\n", strings.Join(os.Args, " "))
+	fmt.Fprintf(&b, "Where: %s\n", where)
+	fmt.Fprintf(&b, "Panic: %v\n\n%s", r, stack)
+
+	name := fmt.Sprintf("crash-%s-%s.log", strings.Trim(unsafeFileChars.ReplaceAllString(where, "-"), "-"), now.Format("20060102-150405"))
+	var err error
+	for _, dir := range []string{crashDir, os.TempDir()} {
+		path := filepath.Join(dir, name)
+		if err = os.MkdirAll(dir, 0755); err == nil {
+			if err = os.WriteFile(path, []byte(b.String()), 0644); err == nil {
+				return path, nil
+			}
+		}
+	}
+	return "", err
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteCrashReport(t *testing.T) {
+	prev := crashDir
+	t.Cleanup(func() { crashDir = prev })
+	SetCrashDir(t.TempDir())
+
+	path, err := WriteCrashReport("download of Example App", "boom", []byte("goroutine 1 [running]:"))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if filepath.Dir(path) != crashDir || !strings.HasPrefix(filepath.Base(path), "crash-download-of-Example-App-") {
+		t.Errorf("unexpected report path %s", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	for _, want := range []string{"Where: download of Example App", "Panic: boom", "goroutine 1 [running]:"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("report lacks %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestWriteCrashReport_FallsBackToTempDir(t *testing.T) {
+	prev := crashDir
+	t.Cleanup(func() { crashDir = prev })
+	// A file where the directory should be cannot hold the report
+	blocker := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	SetCrashDir(blocker)
+
+	path, err := WriteCrashReport("agent mode", "boom", nil)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	defer os.Remove(path)
+	if filepath.Dir(path) != filepath.Clean(os.TempDir()) {
+		t.Errorf("report written to %s, want the temporary directory", path)
+	}
+}
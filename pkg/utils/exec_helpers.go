@@ -5,9 +5,10 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
-	"time"
 
+	"github.com/go-installapplications/pkg/audit"
 	"github.com/go-installapplications/pkg/config"
 )
 
@@ -26,13 +27,71 @@ func RunCommandCapture(args []string) (string, error) {
 	return strings.TrimSpace(stdout.String()), nil
 }
 
-// GetConsoleUserUID returns the current GUI console user UID
-func GetConsoleUserUID() (string, error) {
-	out, err := RunCommandCapture([]string{"stat", "-f", "%u", "/dev/console"})
+// GetGUISessionUIDs returns the UIDs of every logged-in GUI session, in the
+// order ps lists them. With fast user switching several users can be logged
+// in while only one owns the console.
+func GetGUISessionUIDs() ([]string, error) {
+	out, err := RunCommandCapture([]string{"ps", "-axo", "uid=,comm="})
+	if err != nil {
+		return nil, err
+	}
+	return parseGUISessionUIDs(out), nil
+}
+
+// parseGUISessionUIDs extracts the owners of loginwindow processes from
+// `ps -axo uid=,comm=` output. Each GUI session has its own loginwindow;
+// the one owned by root is the login screen itself and is skipped.
+func parseGUISessionUIDs(out string) []string {
+	var uids []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] == "0" || seen[fields[0]] {
+			continue
+		}
+		if !strings.HasSuffix(strings.Join(fields[1:], " "), "/loginwindow") {
+			continue
+		}
+		seen[fields[0]] = true
+		uids = append(uids, fields[0])
+	}
+	return uids
+}
+
+// GetSerialNumber returns the hardware serial number from the IORegistry
+func GetSerialNumber() (string, error) {
+	return getPlatformValue("IOPlatformSerialNumber")
+}
+
+// GetHardwareUUID returns the IOPlatformUUID, which MDM servers report as the
+// device UDID on Intel Macs and as the hardware UUID on Apple silicon
+func GetHardwareUUID() (string, error) {
+	return getPlatformValue("IOPlatformUUID")
+}
+
+func getPlatformValue(key string) (string, error) {
+	out, err := RunCommandCapture([]string{"ioreg", "-rd1", "-c", "IOPlatformExpertDevice"})
 	if err != nil {
 		return "", err
 	}
-	return out, nil
+	if value := parseIORegValue(out, key); value != "" {
+		return value, nil
+	}
+	return "", fmt.Errorf("%s not found", key)
+}
+
+// parseIORegValue extracts a string property from `ioreg -rd1` output,
+// e.g. `"IOPlatformSerialNumber" = "C02XL0GZJGH5"`.
+func parseIORegValue(out, key string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.Contains(line, `"`+key+`"`) {
+			continue
+		}
+		if i := strings.Index(line, "="); i >= 0 {
+			return strings.Trim(strings.TrimSpace(line[i+1:]), `"`)
+		}
+	}
+	return ""
 }
 
 // IsRootUser checks if the current process is running with root privileges
@@ -41,80 +100,259 @@ func IsRootUser() bool {
 }
 
 // Exit handles program exit with cleanup and optional message
-func Exit(cfg *config.Config, logger *Logger, exitCode int, message string) {
+func Exit(cfg *config.Config, logger Logger, exitCode ExitCode, message string) {
 	if message != "" {
-		logger.Info("Exiting with code %d: %s", exitCode, message)
+		logger.Info("Exiting with code %d (%s): %s", exitCode, exitCode, message)
 	}
 
-	// Honor daemon no-restart-on-error: coerce non-zero to 0 so launchd doesn't relaunch
-	if cfg.Mode == "daemon" && cfg.NoRestartOnError && exitCode != 0 {
-		logger.Info("no-restart-on-error enabled: coercing exit code %d to 0", exitCode)
-		exitCode = 0
+	succeeded := exitCode == ExitSuccess
+	exitCode = restartExitCode(cfg, logger, exitCode)
+
+	// Reboot only on successful completion; the user is warned before
+	// cleanup so the delay doesn't run after the daemon is booted out
+	rebooting := shouldReboot(cfg, logger, succeeded)
+	if rebooting {
+		warnReboot(cfg, logger)
 	}
 
 	// Always call cleanup (cleanup handles flag logic)
-	Cleanup(cfg, logger, "exit")
-
-	// Reboot only on successful completion
-	if cfg.Reboot && exitCode == 0 {
-		logger.Info("🔄 Reboot flag is set; system will reboot in 5 seconds")
-		time.Sleep(5 * time.Second)
-		cmd := exec.Command("/sbin/shutdown", "-r", "now")
-		if err := cmd.Start(); err != nil {
-			logger.Error("Failed to initiate reboot: %v", err)
+	cleanup(cfg, logger, "exit", succeeded)
+
+	if rebooting {
+		reboot(cfg, logger)
+	}
+
+	os.Exit(int(exitCode))
+}
+
+// ExitWithoutCleanup exits like Exit but skips Cleanup and reboot, for
+// failures before a run has started where there is nothing to clean up and
+// the installation must stay in place.
+func ExitWithoutCleanup(cfg *config.Config, logger Logger, exitCode ExitCode, message string) {
+	if message != "" {
+		logger.Info("Exiting with code %d (%s): %s", exitCode, exitCode, message)
+	}
+	os.Exit(int(restartExitCode(cfg, logger, exitCode)))
+}
+
+// restartExitCode honors no-restart-on-error in daemon mode, which launchd
+// runs with KeepAlive: a non-zero code is coerced to 0 so launchd doesn't
+// relaunch. Other modes keep their code for the scripts that check it.
+func restartExitCode(cfg *config.Config, logger Logger, exitCode ExitCode) ExitCode {
+	if cfg.Mode == "daemon" && cfg.NoRestartOnError && exitCode != ExitSuccess {
+		logger.Info("no-restart-on-error enabled: coercing exit code %d to 0", exitCode)
+		return ExitSuccess
+	}
+	return exitCode
+}
+
+// cleanupKeep returns the paths a removal of InstallPath keeps: those of
+// CleanupPreserve and, unless they are in scope, the per-item logs.
+func cleanupKeep(cfg *config.Config) []string {
+	keep := append([]string{}, cfg.CleanupPreserve...)
+	if !cfg.CleansUp(config.CleanupLogs) {
+		keep = append(keep, cfg.ItemLogDir())
+	}
+	return keep
+}
+
+// RemoveInstallPath removes cfg.InstallPath the way cleanup does, keeping
+// the CleanupPreserve paths and, unless they are in scope, the per-item
+// logs.
+func RemoveInstallPath(cfg *config.Config) error {
+	return removeExcept(cfg.InstallPath, cleanupKeep(cfg)...)
+}
+
+// removeExcept removes dir like os.RemoveAll, except for the paths of keep
+// that exist: they, everything under them and the directories above them
+// stay, and everything else is removed.
+func removeExcept(dir string, keep ...string) error {
+	dir = filepath.Clean(dir)
+	inside := false
+	for _, k := range keep {
+		k = filepath.Clean(k)
+		if dir == k || strings.HasPrefix(dir, k+string(filepath.Separator)) {
+			return nil
+		}
+		if strings.HasPrefix(k, dir+string(filepath.Separator)) {
+			if _, err := os.Lstat(k); err == nil {
+				inside = true
+			}
 		}
 	}
+	if !inside {
+		return os.RemoveAll(dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, e := range entries {
+		if err := removeExcept(filepath.Join(dir, e.Name()), keep...); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
 
-	os.Exit(exitCode)
+// Cleanup performs system cleanup (plists, services, reboot) - file cleanup is
+// handled by components. What is removed follows cfg.CleanupScope, and the
+// paths of cfg.CleanupPreserve stay. Every step is recorded and the report,
+// with the steps the components recorded, is logged by FinishCleanup.
+func Cleanup(cfg *config.Config, logger Logger, cleanupType string) {
+	cleanup(cfg, logger, cleanupType, true)
 }
 
-// Cleanup performs system cleanup (plists, services, reboot) - file cleanup is handled by components
-func Cleanup(cfg *config.Config, logger *Logger, cleanupType string) {
+// cleanup is Cleanup; the retry state is only removed when the run
+// succeeded, as launchd's next attempt needs the count.
+func cleanup(cfg *config.Config, logger Logger, cleanupType string, succeeded bool) {
 	logger.Debug("Performing system cleanup (plists, services, reboot)")
 
 	// Build paths
 	daemonPlist := "/Library/LaunchDaemons/" + cfg.LaunchDaemonIdentifier + ".plist"
 	agentPlist := "/Library/LaunchAgents/" + cfg.LaunchAgentIdentifier + ".plist"
+	launchd := cfg.CleansUp(config.CleanupLaunchd)
+	retryState := cfg.CleansUp(config.CleanupRetryState) && succeeded
 
-	// Remove LaunchDaemon plist file
-	logger.Debug("Removing LaunchDaemon plist: %s", daemonPlist)
-	if err := os.Remove(daemonPlist); err != nil && !os.IsNotExist(err) {
-		logger.Debug("Failed to remove LaunchDaemon plist: %v", err)
+	keep := cleanupKeep(cfg)
+	kept := strings.Join(keep, ", ")
+	if kept == "" {
+		kept = "nothing"
 	}
 
-	// Remove LaunchAgent plist file
-	logger.Debug("Removing LaunchAgent plist: %s", agentPlist)
-	if err := os.Remove(agentPlist); err != nil && !os.IsNotExist(err) {
-		logger.Debug("Failed to remove LaunchAgent plist: %v", err)
+	if cfg.DryRun {
+		if launchd {
+			logger.Info("[DRY RUN] Would boot out and remove %s and %s", daemonPlist, agentPlist)
+		}
+		if cfg.CleansUp(config.CleanupInstallPath) {
+			logger.Info("[DRY RUN] Would remove installation directory: %s (keeping %s)", cfg.InstallPath, kept)
+		} else if cfg.CleansUp(config.CleanupLogs) {
+			logger.Info("[DRY RUN] Would remove item logs: %s", cfg.ItemLogDir())
+		}
+		if retryState {
+			logger.Info("[DRY RUN] Would remove retry state: %s", cfg.RetryStateFile())
+		}
+		logger.Info("✅ %s cleanup completed (dry run)", cleanupType)
+		return
 	}
 
-	// Boot out LaunchAgent from user context
-	logger.Debug("Booting out LaunchAgent from user context")
-	uid, err := GetConsoleUserUID()
-	if err != nil || uid == "" {
-		logger.Debug("Could not determine console user UID, defaulting to gui/501: %v", err)
-		uid = "501"
+	if launchd {
+		// Remove LaunchDaemon plist file
+		logger.Debug("Removing LaunchDaemon plist: %s", daemonPlist)
+		removeUnlessPreserved(cfg, daemonPlist, removeAudited)
+
+		// Remove LaunchAgent plist file
+		logger.Debug("Removing LaunchAgent plist: %s", agentPlist)
+		removeUnlessPreserved(cfg, agentPlist, removeAudited)
+
+		// Boot out LaunchAgent from user context
+		logger.Debug("Booting out LaunchAgent from user context")
+		uid, err := GetConsoleUserUID()
+		if err != nil || uid == "" {
+			logger.Debug("Could not determine console user UID, defaulting to gui/501: %v", err)
+			uid = "501"
+		}
+		guiDomain := "gui/" + uid
+		if ServiceLoaded(guiDomain + "/" + cfg.LaunchAgentIdentifier) {
+			RecordCleanup("bootout", guiDomain+"/"+cfg.LaunchAgentIdentifier, Bootout(guiDomain, agentPlist))
+		} else {
+			logger.Debug("LaunchAgent is not loaded in %s", guiDomain)
+		}
 	}
-	guiDomain := "gui/" + uid
 
-	cmd := exec.Command("launchctl", "bootout", guiDomain, agentPlist)
-	if err := cmd.Run(); err != nil {
-		logger.Debug("Failed to boot out LaunchAgent (may not be running): %v", err)
+	switch {
+	case cfg.CleansUp(config.CleanupInstallPath):
+		// Remove the installation directory, keeping the per-item logs
+		// and the preserved paths
+		logger.Debug("Removing installation directory: %s (keeping %s)", cfg.InstallPath, kept)
+		recordRemove(cfg.InstallPath, RemoveInstallPath(cfg))
+	case cfg.CleansUp(config.CleanupLogs):
+		logger.Debug("Removing item logs: %s", cfg.ItemLogDir())
+		recordRemove(cfg.ItemLogDir(), removeExcept(cfg.ItemLogDir(), cfg.CleanupPreserve...))
 	}
 
-	// Remove entire installation directory
-	logger.Debug("Removing installation directory: %s", cfg.InstallPath)
-	if err := os.RemoveAll(cfg.InstallPath); err != nil {
-		logger.Debug("Failed to remove installation directory: %v", err)
+	if retryState {
+		logger.Debug("Removing retry state: %s", cfg.RetryStateFile())
+		removeUnlessPreserved(cfg, cfg.RetryStateFile(), os.Remove)
 	}
 
+	// Report before the LaunchDaemon is booted out: in daemon mode that
+	// stops this process
+	FinishCleanup(logger, cleanupType)
+
 	// Boot out LaunchDaemon
-	logger.Debug("Booting out LaunchDaemon")
-	cmd = exec.Command("launchctl", "bootout", "system", daemonPlist)
-	if err := cmd.Run(); err != nil {
-		logger.Debug("Failed to boot out LaunchDaemon (may not be running): %v", err)
+	if launchd && ServiceLoaded("system/"+cfg.LaunchDaemonIdentifier) {
+		logger.Debug("Booting out LaunchDaemon")
+		if err := Bootout("system", daemonPlist); err != nil {
+			logger.Info("⚠️  Cleanup could not boot out system/%s: %v", cfg.LaunchDaemonIdentifier, err)
+		}
 	}
 
 	// Reboot handling moved to Exit() to gate on success
 	logger.Info("✅ %s cleanup completed", cleanupType)
 }
+
+// removeUnlessPreserved removes path with remove and records it, unless it
+// is one of cfg.CleanupPreserve.
+func removeUnlessPreserved(cfg *config.Config, path string, remove func(string) error) {
+	if cfg.Preserved(path) {
+		return
+	}
+	recordRemove(path, remove(path))
+}
+
+// recordRemove records the removal of path, unless it was already gone.
+func recordRemove(path string, err error) {
+	if os.IsNotExist(err) {
+		return
+	}
+	RecordCleanup("remove", path, err)
+}
+
+// Bootout runs `launchctl bootout <domain> <plist>` and records it in the
+// audit log.
+func Bootout(domain, plist string) error {
+	err := exec.Command("launchctl", "bootout", domain, plist).Run()
+	audit.Record(audit.Event{
+		Action:  audit.ActionServiceBootout,
+		Target:  plist,
+		Outcome: audit.Outcome(err),
+		Error:   audit.ErrorString(err),
+		Details: map[string]string{"domain": domain},
+	})
+	return err
+}
+
+// Bootstrap runs `launchctl bootstrap <domain> <plist>` and records it in the
+// audit log.
+func Bootstrap(domain, plist string) error {
+	out, err := exec.Command("launchctl", "bootstrap", domain, plist).CombinedOutput()
+	if err != nil && len(out) > 0 {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	audit.Record(audit.Event{
+		Action:  audit.ActionServiceLoad,
+		Target:  plist,
+		Outcome: audit.Outcome(err),
+		Error:   audit.ErrorString(err),
+		Details: map[string]string{"domain": domain},
+	})
+	return err
+}
+
+// ServiceLoaded reports whether launchd has the service target loaded, e.g.
+// "system/<label>" or "gui/<uid>/<label>".
+func ServiceLoaded(target string) bool {
+	return exec.Command("launchctl", "print", target).Run() == nil
+}
+
+// removeAudited removes path and records the removal in the audit log.
+// Files that were already gone are not recorded.
+func removeAudited(path string) error {
+	err := os.Remove(path)
+	if !os.IsNotExist(err) {
+		audit.Record(audit.Event{Action: audit.ActionFileRemove, Target: path, Outcome: audit.Outcome(err), Error: audit.ErrorString(err)})
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+)
+
+func TestParseIORegValue(t *testing.T) {
+	out := `+-o J314sAP  <class IOPlatformExpertDevice, id 0x100000220, registered, matched, active, busy 0 (1 ms), retain 38>
+    {
+      "IOPlatformUUID" = "0A1B2C3D-0000-1111-2222-333344445555"
+      "IOPlatformSerialNumber" = "C02XL0GZJGH5"
+      "manufacturer" = <"Apple Inc.">
+    }`
+	if got := parseIORegValue(out, "IOPlatformSerialNumber"); got != "C02XL0GZJGH5" {
+		t.Fatalf("got %q", got)
+	}
+	if got := parseIORegValue(out, "IOPlatformUUID"); got != "0A1B2C3D-0000-1111-2222-333344445555" {
+		t.Fatalf("got %q", got)
+	}
+	if got := parseIORegValue("{}", "IOPlatformSerialNumber"); got != "" {
+		t.Fatalf("expected empty serial, got %q", got)
+	}
+}
+
+func TestParseGUISessionUIDs(t *testing.T) {
+	out := `    0 /System/Library/CoreServices/loginwindow.app/Contents/MacOS/loginwindow
+  501 /System/Library/CoreServices/loginwindow.app/Contents/MacOS/loginwindow
+  501 /usr/sbin/cfprefsd
+  502 /System/Library/CoreServices/loginwindow.app/Contents/MacOS/loginwindow
+  503 /usr/local/bin/loginwindow-helper`
+	got := parseGUISessionUIDs(out)
+	if len(got) != 2 || got[0] != "501" || got[1] != "502" {
+		t.Fatalf("got %v, want [501 502]", got)
+	}
+	if got := parseGUISessionUIDs(""); len(got) != 0 {
+		t.Fatalf("expected no sessions, got %v", got)
+	}
+}
+
+func TestRestartExitCode(t *testing.T) {
+	logger := NewLogger(false, false)
+	for _, tc := range []struct {
+		mode      string
+		noRestart bool
+		code      ExitCode
+		want      ExitCode
+	}{
+		{"daemon", false, 1, 1},
+		{"daemon", true, 1, 0},
+		{"standalone", false, 1, 1},
+		{"standalone", true, 1, 1}, // not kept alive by launchd
+		{"standalone", true, 6, 6},
+		{"standalone", true, 0, 0},
+		{"agent", true, 1, 1},
+	} {
+		cfg := config.NewConfig()
+		cfg.Mode, cfg.NoRestartOnError = tc.mode, tc.noRestart
+		if got := restartExitCode(cfg, logger, tc.code); got != tc.want {
+			t.Errorf("%s noRestart=%v code=%d: got %d, want %d", tc.mode, tc.noRestart, tc.code, got, tc.want)
+		}
+	}
+}
+
+func TestRemoveExcept_KeepsLogs(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "install")
+	logs := filepath.Join(dir, "logs")
+	for _, p := range []string{logs, filepath.Join(dir, "cache")} {
+		if err := os.MkdirAll(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app.pkg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeExcept(dir, logs); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "logs" {
+		t.Errorf("left %v, want only logs", entries)
+	}
+
+	// Without logs the whole directory goes
+	if err := os.Remove(logs); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeExcept(dir, logs); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("%s should be removed, stat err = %v", dir, err)
+	}
+}
+
+func TestRemoveExcept_KeepsNestedPaths(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "install")
+	diag := filepath.Join(dir, "support", "diag")
+	for _, p := range []string{diag, filepath.Join(dir, "cache")} {
+		if err := os.MkdirAll(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{filepath.Join(diag, "report.txt"), filepath.Join(dir, "support", "tmp.txt"), filepath.Join(dir, "app.pkg")} {
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := removeExcept(dir, diag, filepath.Join(dir, "missing")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	var left []string
+	_ = filepath.Walk(dir, func(path string, _ os.FileInfo, _ error) error {
+		rel, _ := filepath.Rel(dir, path)
+		left = append(left, rel)
+		return nil
+	})
+	want := []string{".", "support", "support/diag", "support/diag/report.txt"}
+	if strings.Join(left, " ") != strings.Join(want, " ") {
+		t.Errorf("left %v, want %v", left, want)
+	}
+}
+
+func TestCleanup_FollowsScopeAndPreserve(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.InstallPath = filepath.Join(t.TempDir(), "install")
+	cfg.RetryStatePath = filepath.Join(t.TempDir(), ".retry-state")
+	cfg.CleanupScope = []string{config.CleanupInstallPath, config.CleanupRetryState}
+	diag := filepath.Join(cfg.InstallPath, "diagnostics")
+	pkg := filepath.Join(cfg.InstallPath, "app.pkg")
+	for _, p := range []string{diag, cfg.ItemLogDir()} {
+		if err := os.MkdirAll(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{pkg, cfg.RetryStatePath} {
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg.CleanupPreserve = []string{diag}
+
+	// A failed run keeps the retry state for the next attempt
+	cleanup(cfg, NewLoggerWithWriter(false, false, io.Discard), "exit", false)
+	if _, err := os.Stat(cfg.RetryStatePath); err != nil {
+		t.Errorf("retry state removed after a failure: %v", err)
+	}
+	report := FinishCleanup(NewLoggerWithWriter(false, false, io.Discard), "test")
+	if len(report.Steps) != 0 {
+		t.Errorf("steps reported twice: %v", report.Steps)
+	}
+
+	Cleanup(cfg, NewLoggerWithWriter(false, false, io.Discard), "exit")
+	for path, kept := range map[string]bool{diag: true, cfg.ItemLogDir(): true, pkg: false, cfg.RetryStatePath: false} {
+		if _, err := os.Stat(path); (err == nil) != kept {
+			t.Errorf("%s: kept = %v, want %v", path, err == nil, kept)
+		}
+	}
+}
+
+func TestCleanup_DryRunKeepsInstallPath(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.DryRun = true
+	cfg.InstallPath = t.TempDir()
+	pkg := filepath.Join(cfg.InstallPath, "app.pkg")
+	if err := os.WriteFile(pkg, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	Cleanup(cfg, NewLogger(false, false), "exit")
+	if _, err := os.Stat(pkg); err != nil {
+		t.Errorf("dry run removed %s: %v", pkg, err)
+	}
+}
@@ -0,0 +1,43 @@
+package utils
+
+import "fmt"
+
+// ExitCode is the exit status of a run. Each class of failure has its own
+// code so launchd, MDM scripts and CI can tell why a run failed. The codes are
+// part of the command-line interface: do not renumber them. A run stopped by
+// a signal exits with ExitCodeInterrupted.
+type ExitCode int
+
+const (
+	ExitSuccess        ExitCode = 0
+	ExitFailure        ExitCode = 1 // a failure not covered below
+	ExitConfig         ExitCode = 2 // invalid flags, profile settings or mode
+	ExitBootstrapFetch ExitCode = 3 // the bootstrap could not be downloaded or read
+	ExitValidation     ExitCode = 4 // the bootstrap or a download failed validation
+	ExitDownload       ExitCode = 5 // an item could not be downloaded
+	ExitInstall        ExitCode = 6 // a package, script or file failed to install
+	ExitIPCTimeout     ExitCode = 7 // the agent could not be reached or did not answer in time
+	ExitMaxRetries     ExitCode = 8 // the daemon gave up after DaemonMaxRetries attempts
+	ExitPanic          ExitCode = 9 // a panic, see Recover
+)
+
+var exitCodeNames = map[ExitCode]string{
+	ExitSuccess:         "success",
+	ExitFailure:         "failure",
+	ExitConfig:          "config error",
+	ExitBootstrapFetch:  "bootstrap fetch failed",
+	ExitValidation:      "validation failed",
+	ExitDownload:        "download failed",
+	ExitInstall:         "install failed",
+	ExitIPCTimeout:      "ipc timeout",
+	ExitMaxRetries:      "max retries exceeded",
+	ExitPanic:           "panic",
+	ExitCodeInterrupted: "interrupted",
+}
+
+func (c ExitCode) String() string {
+	if name, ok := exitCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("exit code %d", int(c))
+}
@@ -35,3 +35,66 @@ func EnsureDirForFile(filePath string) error {
 	dir := filepath.Dir(filePath)
 	return EnsureDir(dir)
 }
+
+// EnsureDirForFileMode creates the missing directories above filePath with
+// mode, set explicitly so the umask does not narrow it. Directories that
+// already exist are left alone.
+func EnsureDirForFileMode(filePath string, mode os.FileMode) error {
+	var missing []string
+	for dir := filepath.Dir(filePath); ; dir = filepath.Dir(dir) {
+		if info, err := os.Stat(dir); err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("path %s exists but is not a directory", dir)
+			}
+			break
+		}
+		missing = append(missing, dir)
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], mode); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed to create directory %s: %w", missing[i], err)
+		}
+		if err := os.Chmod(missing[i], mode); err != nil {
+			return fmt.Errorf("failed to set permissions on %s: %w", missing[i], err)
+		}
+	}
+	return nil
+}
+
+// WriteFileAtomic writes data to path so that a crash or power loss leaves
+// either the old file or the new one, never a partial file: the data is
+// written to a temp file in the same directory, synced, and renamed over
+// path, and the directory is synced so the rename itself survives.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsureDirForFileMode(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Chmod(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(root, "a", "b", "conf")
+	if err := EnsureDirForFileMode(file, 0750); err != nil {
+		t.Fatalf("ensure: %v", err)
+	}
+	for _, dir := range []string{filepath.Join(root, "a"), filepath.Join(root, "a", "b")} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		if info.Mode().Perm() != 0750 {
+			t.Errorf("%s: mode = %v, want 0750", dir, info.Mode().Perm())
+		}
+	}
+	// Existing directories keep their mode
+	if info, _ := os.Stat(root); info.Mode().Perm() != 0755 {
+		t.Errorf("existing directory changed to %v", info.Mode().Perm())
+	}
+}
@@ -1,33 +1,128 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
-	"time"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-installapplications/pkg/config"
+)
+
+// Logger is what every package logs through. StdLogger, from NewLogger and
+// friends, is the default; an embedding program can pass its own, e.g. an
+// adapter for zap or os_log, or NewSlogLogger for log/slog, and combine
+// several with MultiLogger. Debug and Verbose messages are for the
+// implementation to filter.
+type Logger interface {
+	Debug(format string, args ...interface{})
+	Verbose(format string, args ...interface{})
+	Info(format string, args ...interface{})
+	Error(format string, args ...interface{})
+}
+
+// Log components, see Component.
+const (
+	ComponentDownload  = "download"
+	ComponentInstaller = "installer"
+	ComponentIPC       = "ipc"
+	ComponentManager   = "manager"
 )
 
-// Logger provides different logging levels
-type Logger struct {
-	debug   bool
-	verbose bool
-	writer  io.Writer // Where to write logs (os.Stdout by default)
+// LogComponents are the components whose level can be set on its own.
+var LogComponents = []string{ComponentDownload, ComponentInstaller, ComponentIPC, ComponentManager}
 
-	// optional remote shipping
-	// shipper *httpShipper
+// componentKey is the attribute that names a logger's component.
+const componentKey = "component"
+
+// With returns a logger that adds the slog-style key-value pairs in args,
+// such as "item", name, to each message, when logger supports attributes
+// (StdLogger, NewSlogLogger, MultiLogger); otherwise logger itself.
+func With(logger Logger, args ...any) Logger {
+	if l, ok := logger.(interface{ With(args ...any) Logger }); ok {
+		return l.With(args...)
+	}
+	return logger
 }
 
-// NewLogger creates a new logger with the specified levels
-func NewLogger(debug, verbose bool) *Logger {
-	return &Logger{
-		debug:   debug,
-		verbose: verbose,
-		writer:  os.Stdout, // Default to stdout
+// Component returns the child logger of a component, one of LogComponents,
+// whose level SetComponentLevels can change on its own.
+func Component(logger Logger, name string) Logger {
+	return With(logger, componentKey, name)
+}
+
+// LevelVerbose is the slog level of Verbose messages, between Debug and
+// Info.
+const LevelVerbose = slog.LevelDebug + 2
+
+// ParseLevel reads a level name: "debug", "verbose", "info" or "error".
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "verbose":
+		return LevelVerbose, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (valid: debug, verbose, info, error)", name)
+}
+
+// ParseComponentLevels reads a component to level name map such as the
+// LogLevels setting, e.g. {"download": "debug", "ipc": "error"}.
+func ParseComponentLevels(names map[string]string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level, len(names))
+	for component, name := range names {
+		if !slices.Contains(LogComponents, component) {
+			return nil, fmt.Errorf("unknown log component %q (valid: %s)", component, strings.Join(LogComponents, ", "))
+		}
+		level, err := ParseLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", component, err)
+		}
+		levels[component] = level
+	}
+	return levels, nil
+}
+
+// ApplyLogLevels applies cfg's Debug, Verbose and LogLevels to logger when
+// it is a StdLogger; other loggers have levels of their own.
+func ApplyLogLevels(logger Logger, cfg *config.Config) error {
+	std, ok := logger.(*StdLogger)
+	if !ok {
+		return nil
 	}
+	std.SetLevels(cfg.Debug, cfg.Verbose)
+	levels, err := ParseComponentLevels(cfg.LogLevels)
+	if err != nil {
+		return err
+	}
+	std.SetComponentLevels(levels)
+	return nil
+}
+
+// StdLogger is the default Logger: a printf front end for log/slog whose
+// handler writes timestamped lines with the level, the component and any
+// attributes. Debug and Verbose messages are only shown when enabled, or
+// when the component's level allows them.
+type StdLogger struct {
+	slog *slog.Logger
+}
+
+// NewLogger creates a new logger with the specified levels
+func NewLogger(debug, verbose bool) *StdLogger {
+	return NewLoggerWithWriter(debug, verbose, os.Stdout) // Default to stdout
 }
 
 // NewLoggerWithFile creates a new logger that writes to a file
-func NewLoggerWithFile(debug, verbose bool, logFilePath string) (*Logger, error) {
+func NewLoggerWithFile(debug, verbose bool, logFilePath string) (*StdLogger, error) {
 	// Ensure directory for the specific log file exists (handles nested paths)
 	if err := EnsureDirForFile(logFilePath); err != nil {
 		return nil, fmt.Errorf("failed to create log directory for %s: %w", logFilePath, err)
@@ -42,65 +137,193 @@ func NewLoggerWithFile(debug, verbose bool, logFilePath string) (*Logger, error)
 	// Create a multi-writer to write to both stdout and file
 	multiWriter := io.MultiWriter(os.Stdout, logFile)
 
-	return &Logger{
-		debug:   debug,
-		verbose: verbose,
-		writer:  multiWriter,
-	}, nil
+	return NewLoggerWithWriter(debug, verbose, multiWriter), nil
 }
 
-// EnableRemoteShipping attaches a non-blocking HTTP shipper. If destination is empty, no-op.
-// func (l *Logger) EnableRemoteShipping(destination string, headers map[string]string, provider string) {
-// 	if destination == "" {
-// 		return
-// 	}
-// 	l.shipper = newHTTPShipper(destination, headers, provider)
-// }
+// NewLoggerWithWriter creates a logger that writes to w, e.g. os.Stderr to
+// keep stdout free for command output
+func NewLoggerWithWriter(debug, verbose bool, w io.Writer) *StdLogger {
+	h := &lineHandler{state: &logState{writer: w}}
+	h.state.components.Store(&map[string]slog.Level{})
+	l := &StdLogger{slog: slog.New(h)}
+	l.SetLevels(debug, verbose)
+	return l
+}
+
+// SetLevels turns debug and verbose messages on or off; safe to call while
+// other goroutines log, e.g. when a profile change is reloaded mid-run. It
+// applies to the logger and all its children.
+func (l *StdLogger) SetLevels(debug, verbose bool) {
+	state := l.handler().state
+	state.debug.Store(debug)
+	state.verbose.Store(verbose)
+}
+
+// SetComponentLevels sets the lowest level shown for the messages of a
+// component, e.g. {"download": LevelDebug, "ipc": LevelError}, in place of
+// the Debug and Verbose settings. Components left out follow those again.
+func (l *StdLogger) SetComponentLevels(levels map[string]slog.Level) {
+	copied := make(map[string]slog.Level, len(levels))
+	for component, level := range levels {
+		copied[component] = level
+	}
+	l.handler().state.components.Store(&copied)
+}
+
+// Slog returns the logger as a *slog.Logger, for code that logs structured
+// records directly.
+func (l *StdLogger) Slog() *slog.Logger {
+	return l.slog
+}
+
+// With returns a child logger that adds args, slog-style key-value pairs,
+// to each message.
+func (l *StdLogger) With(args ...any) Logger {
+	return &StdLogger{slog: l.slog.With(args...)}
+}
+
+func (l *StdLogger) handler() *lineHandler {
+	return l.slog.Handler().(*lineHandler)
+}
+
+func (l *StdLogger) log(level slog.Level, format string, args []interface{}) {
+	ctx := context.Background()
+	if l.slog.Enabled(ctx, level) {
+		l.slog.Log(ctx, level, fmt.Sprintf(format, args...))
+	}
+}
 
 // Info logs informational messages (always shown)
-func (l *Logger) Info(format string, args ...interface{}) {
-	timestamp := time.Now().Format("15:04:05")
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintf(l.writer, "[%s] INFO: %s\n", timestamp, msg)
-	// if l.shipper != nil {
-	// 	l.shipper.enqueue("info", msg)
-	// }
+func (l *StdLogger) Info(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, format, args)
 }
 
 // Debug logs debug messages (only if debug enabled)
-func (l *Logger) Debug(format string, args ...interface{}) {
-	if l.debug {
-		timestamp := time.Now().Format("15:04:05")
-		msg := fmt.Sprintf(format, args...)
-		fmt.Fprintf(l.writer, "[%s] DEBUG: %s\n", timestamp, msg)
-		// if l.shipper != nil {
-		// 	l.shipper.enqueue("debug", msg)
-		// }
-	}
+func (l *StdLogger) Debug(format string, args ...interface{}) {
+	l.log(slog.LevelDebug, format, args)
 }
 
 // Verbose logs verbose messages (only if verbose enabled)
-func (l *Logger) Verbose(format string, args ...interface{}) {
-	if l.verbose {
-		timestamp := time.Now().Format("15:04:05")
-		msg := fmt.Sprintf(format, args...)
-		fmt.Fprintf(l.writer, "[%s] VERBOSE: %s\n", timestamp, msg)
-		// if l.shipper != nil {
-		// 	l.shipper.enqueue("verbose", msg)
-		// }
-	}
+func (l *StdLogger) Verbose(format string, args ...interface{}) {
+	l.log(LevelVerbose, format, args)
 }
 
 // Error logs error messages (always shown)
-func (l *Logger) Error(format string, args ...interface{}) {
-	timestamp := time.Now().Format("15:04:05")
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintf(l.writer, "[%s] ERROR: %s\n", timestamp, msg)
-	// if l.shipper != nil {
-	// 	l.shipper.enqueue("error", msg)
-	// }
+func (l *StdLogger) Error(format string, args ...interface{}) {
+	l.log(slog.LevelError, format, args)
+}
+
+// logState is shared by a StdLogger and its children.
+type logState struct {
+	mu         sync.Mutex
+	writer     io.Writer // Where to write logs (os.Stdout by default)
+	debug      atomic.Bool
+	verbose    atomic.Bool
+	components atomic.Pointer[map[string]slog.Level]
+}
+
+// lineHandler is the slog.Handler of StdLogger. It writes
+//
+//	[15:04:05] INFO: [download] message item=App phase=userland
+//
+// with the component, if any, in brackets.
+type lineHandler struct {
+	state     *logState
+	component string
+	attrs     string // preformatted " key=value" pairs
+	group     string
+}
+
+func (h *lineHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if min, ok := (*h.state.components.Load())[h.component]; ok && h.component != "" {
+		return level >= min
+	}
+	switch level {
+	case slog.LevelDebug:
+		return h.state.debug.Load()
+	case LevelVerbose:
+		return h.state.verbose.Load()
+	}
+	return level >= slog.LevelInfo
 }
 
+func (h *lineHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString("[" + r.Time.Format("15:04:05") + "] " + levelName(r.Level) + ": ")
+	if h.component != "" {
+		b.WriteString("[" + h.component + "] ")
+	}
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&b, h.group, a)
+		return true
+	})
+	b.WriteString("\n")
+	h.state.mu.Lock()
+	defer h.state.mu.Unlock()
+	_, err := io.WriteString(h.state.writer, b.String())
+	return err
+}
+
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	child := *h
+	var b strings.Builder
+	for _, a := range attrs {
+		if a.Key == componentKey && h.group == "" {
+			child.component = a.Value.String()
+			continue
+		}
+		writeAttr(&b, h.group, a)
+	}
+	child.attrs += b.String()
+	return &child
+}
+
+func (h *lineHandler) WithGroup(name string) slog.Handler {
+	child := *h
+	if child.group != "" {
+		name = child.group + "." + name
+	}
+	child.group = name
+	return &child
+}
+
+func writeAttr(b *strings.Builder, group string, a slog.Attr) {
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	key := a.Key
+	if group != "" {
+		key = group + "." + key
+	}
+	value := a.Value.Resolve().String()
+	if strings.ContainsAny(value, " \t\"=") || value == "" {
+		value = fmt.Sprintf("%q", value)
+	}
+	b.WriteString(" " + key + "=" + value)
+}
+
+func levelName(level slog.Level) string {
+	switch {
+	case level < LevelVerbose:
+		return "DEBUG"
+	case level < slog.LevelInfo:
+		return "VERBOSE"
+	case level < slog.LevelError:
+		return "INFO"
+	}
+	return "ERROR"
+}
+
+// EnableRemoteShipping attaches a non-blocking HTTP shipper. If destination is empty, no-op.
+// func (l *StdLogger) EnableRemoteShipping(destination string, headers map[string]string, provider string) {
+// 	if destination == "" {
+// 		return
+// 	}
+// 	l.shipper = newHTTPShipper(destination, headers, provider)
+// }
+
 // httpShipper implements a simple non-blocking, batched HTTP log shipper.
 // type httpShipper struct {
 // 	destURL  string
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// MultiLogger sends every message to each of loggers, e.g. the default
+// StdLogger and a remote sink.
+func MultiLogger(loggers ...Logger) Logger {
+	return multiLogger(loggers)
+}
+
+type multiLogger []Logger
+
+// With adds args to the messages of each logger that supports attributes.
+func (m multiLogger) With(args ...any) Logger {
+	children := make(multiLogger, len(m))
+	for i, l := range m {
+		children[i] = With(l, args...)
+	}
+	return children
+}
+
+func (m multiLogger) Debug(format string, args ...interface{}) {
+	for _, l := range m {
+		l.Debug(format, args...)
+	}
+}
+
+func (m multiLogger) Verbose(format string, args ...interface{}) {
+	for _, l := range m {
+		l.Verbose(format, args...)
+	}
+}
+
+func (m multiLogger) Info(format string, args ...interface{}) {
+	for _, l := range m {
+		l.Info(format, args...)
+	}
+}
+
+func (m multiLogger) Error(format string, args ...interface{}) {
+	for _, l := range m {
+		l.Error(format, args...)
+	}
+}
+
+// NewSlogLogger logs through l. The handler's level decides which Debug
+// and Verbose messages are kept.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// With returns the adapter of l.With(args...).
+func (s slogLogger) With(args ...any) Logger {
+	return slogLogger{s.l.With(args...)}
+}
+
+func (s slogLogger) log(level slog.Level, format string, args []interface{}) {
+	ctx := context.Background()
+	if s.l.Enabled(ctx, level) {
+		s.l.Log(ctx, level, fmt.Sprintf(format, args...))
+	}
+}
+
+func (s slogLogger) Debug(format string, args ...interface{}) {
+	s.log(slog.LevelDebug, format, args)
+}
+
+func (s slogLogger) Verbose(format string, args ...interface{}) {
+	s.log(LevelVerbose, format, args)
+}
+
+func (s slogLogger) Info(format string, args ...interface{}) {
+	s.log(slog.LevelInfo, format, args)
+}
+
+func (s slogLogger) Error(format string, args ...interface{}) {
+	s.log(slog.LevelError, format, args)
+}
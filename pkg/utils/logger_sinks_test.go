@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestMultiLoggerAndSlog(t *testing.T) {
+	var std, structured bytes.Buffer
+	handler := slog.NewTextHandler(&structured, &slog.HandlerOptions{
+		Level: LevelVerbose,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	logger := MultiLogger(NewLoggerWithWriter(false, false, &std), NewSlogLogger(slog.New(handler)))
+
+	logger.Debug("hidden %d", 1)
+	logger.Verbose("shown %d", 2)
+	logger.Info("installed %s", "App")
+	logger.Error("failed %s", "Dock")
+
+	if got := std.String(); strings.Contains(got, "hidden") || strings.Contains(got, "shown") ||
+		!strings.Contains(got, "INFO: installed App") || !strings.Contains(got, "ERROR: failed Dock") {
+		t.Errorf("StdLogger wrote:\n%s", got)
+	}
+	want := "level=DEBUG+2 msg=\"shown 2\"\nlevel=INFO msg=\"installed App\"\nlevel=ERROR msg=\"failed Dock\"\n"
+	if got := structured.String(); got != want {
+		t.Errorf("slog handler got:\n%s\nwant:\n%s", got, want)
+	}
+}
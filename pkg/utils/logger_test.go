@@ -1,5 +1,64 @@
 package utils
 
+import (
+	"bytes"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-installapplications/pkg/config"
+)
+
+func TestStdLogger_ComponentsAndAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLoggerWithWriter(false, false, &buf)
+	download := Component(logger, ComponentDownload)
+	item := With(Component(logger, ComponentManager), "phase", "userland", "item", "Google Chrome")
+
+	cfg := config.NewConfig()
+	cfg.LogLevels = map[string]string{"download": "debug", "manager": "error"}
+	if err := ApplyLogLevels(logger, cfg); err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("hidden: debug is off")
+	download.Debug("fetching %s", "app.pkg")
+	item.Info("hidden: manager logs errors only")
+	item.Error("install failed")
+
+	got := regexp.MustCompile(`(?m)^\[\d\d:\d\d:\d\d\] `).ReplaceAllString(buf.String(), "")
+	want := "DEBUG: [download] fetching app.pkg\n" +
+		"ERROR: [manager] install failed phase=userland item=\"Google Chrome\"\n"
+	if got != want {
+		t.Errorf("logged:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Dropping LogLevels brings the components back to Debug and Verbose
+	buf.Reset()
+	cfg.LogLevels = nil
+	cfg.Verbose = true
+	if err := ApplyLogLevels(logger, cfg); err != nil {
+		t.Fatal(err)
+	}
+	download.Debug("hidden")
+	item.Verbose("shown")
+	if got := buf.String(); strings.Contains(got, "hidden") || !strings.Contains(got, "VERBOSE: [manager] shown") {
+		t.Errorf("after reset:\n%s", got)
+	}
+}
+
+func TestParseComponentLevels(t *testing.T) {
+	levels, err := ParseComponentLevels(map[string]string{"ipc": "Verbose", "installer": "info"})
+	if err != nil || levels["ipc"] != LevelVerbose || levels["installer"] != slog.LevelInfo {
+		t.Errorf("ParseComponentLevels = %v, %v", levels, err)
+	}
+	for _, bad := range []map[string]string{{"network": "debug"}, {"ipc": "trace"}} {
+		if _, err := ParseComponentLevels(bad); err == nil {
+			t.Errorf("ParseComponentLevels(%v) succeeded", bad)
+		}
+	}
+}
+
 // TODO: Implement remote logging tests
 
 // import (
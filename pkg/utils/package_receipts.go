@@ -60,7 +60,7 @@ func parseLooseVersion(s string) []int {
 
 // CheckPackageReceipt reports whether the package is installed and satisfies the required version (loose: installed >= required).
 // Caller skips install when true and pkg_required is false.
-func CheckPackageReceipt(packageID, version string, logger *Logger) (bool, error) {
+func CheckPackageReceipt(packageID, version string, logger Logger) (bool, error) {
 	if packageID == "" {
 		logger.Debug("No package ID provided - skipping receipt check")
 		return false, nil // Not "already satisfied" so caller will proceed
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// StartInOwnSession makes cmd start in a new session, as the leader of its
+// own process group, so KillTree reaches everything it spawns and signals
+// meant for this process do not reach it.
+func StartInOwnSession(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+}
+
+// KillTree sends sig to the process group led by pid and to every descendant
+// of pid that left the group, e.g. a daemon that called setsid. Descendants
+// whose parent already exited were re-parented to launchd and cannot be
+// found; only the group reaches them.
+func KillTree(pid int, sig syscall.Signal) {
+	killTree(pid, Descendants(pid), sig)
+}
+
+func killTree(pid int, descendants []int, sig syscall.Signal) {
+	_ = syscall.Kill(-pid, sig)
+	for _, d := range descendants {
+		_ = syscall.Kill(d, sig)
+	}
+}
+
+// TerminateTree stops the tree of pid: SIGTERM first, then SIGKILL for
+// whatever is left once exited is closed or grace has passed. The tree is
+// listed before the first signal, while the leader can still be followed.
+func TerminateTree(pid int, exited <-chan struct{}, grace time.Duration) {
+	descendants := Descendants(pid)
+	killTree(pid, descendants, syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(grace):
+	}
+	// The leader is gone but its children may not be
+	killTree(pid, descendants, syscall.SIGKILL)
+}
+
+// GroupAlive reports whether any process is left in the process group led
+// by pid.
+func GroupAlive(pid int) bool {
+	return syscall.Kill(-pid, 0) == nil
+}
+
+// Descendants returns the PIDs of every running descendant of pid, or nil
+// when the process table cannot be read.
+func Descendants(pid int) []int {
+	out, err := RunCommandCapture([]string{"ps", "-axo", "pid=,ppid="})
+	if err != nil {
+		return nil
+	}
+	return descendantsIn(parseProcessTable(out), pid)
+}
+
+// parseProcessTable maps each parent PID to its children from `ps -o
+// pid=,ppid=` output.
+func parseProcessTable(out string) map[int][]int {
+	children := map[int][]int{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		pid, err1 := strconv.Atoi(fields[0])
+		ppid, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		children[ppid] = append(children[ppid], pid)
+	}
+	return children
+}
+
+func descendantsIn(children map[int][]int, pid int) []int {
+	var found []int
+	queue := children[pid]
+	seen := map[int]bool{pid: true}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		found = append(found, p)
+		queue = append(queue, children[p]...)
+	}
+	return found
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDescendantsIn(t *testing.T) {
+	table := parseProcessTable("  1     0\n 10     1\n 11    10\n 12    11\n 13    10\n 20     1\nbogus line\n")
+	if got, want := descendantsIn(table, 10), []int{11, 13, 12}; !reflect.DeepEqual(got, want) {
+		t.Errorf("descendants = %v, want %v", got, want)
+	}
+	if got := descendantsIn(table, 20); got != nil {
+		t.Errorf("descendants of a leaf = %v, want none", got)
+	}
+}
+
+// A child that moved to its own session escapes the process group; KillTree
+// still finds it through its parent.
+func TestTerminateTree_KillsChildInOwnSession(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid not available")
+	}
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	cmd := exec.Command("sh", "-c", "setsid sh -c 'echo $$ > "+pidFile+"; trap \"\" TERM; sleep 30' & wait")
+	StartInOwnSession(cmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	go func() { _ = cmd.Wait(); close(exited) }()
+
+	var child int
+	for deadline := time.Now().Add(5 * time.Second); child == 0 && time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		data, _ := os.ReadFile(pidFile)
+		child, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	if child == 0 {
+		t.Fatal("child did not start")
+	}
+
+	TerminateTree(cmd.Process.Pid, exited, 200*time.Millisecond)
+	<-exited
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if syscall.Kill(child, 0) != nil {
+			return
+		}
+	}
+	t.Fatalf("child %d in its own session survived", child)
+}
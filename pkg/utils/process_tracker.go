@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os/exec"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -12,19 +13,35 @@ type BackgroundProcess struct {
 	Cmd     *exec.Cmd
 	Name    string
 	Started time.Time
+
+	// Set by the reaper goroutine before done is closed
+	done     chan struct{}
+	err      error
+	finished time.Time
+}
+
+// ProcessStatus is a snapshot of one tracked background process
+type ProcessStatus struct {
+	Name    string    `json:"name"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	Running bool      `json:"running"`
+	// Runtime so far, or the total runtime once the process has exited
+	RuntimeSeconds float64 `json:"runtimeSeconds"`
+	Error          string  `json:"error,omitempty"`
 }
 
 // ProcessTracker manages background processes started with donotwait
 type ProcessTracker struct {
-	processes []BackgroundProcess
+	processes []*BackgroundProcess
 	mutex     sync.Mutex
-	logger    *Logger
+	logger    Logger
 }
 
 // NewProcessTracker creates a new process tracker
-func NewProcessTracker(logger *Logger) *ProcessTracker {
+func NewProcessTracker(logger Logger) *ProcessTracker {
 	return &ProcessTracker{
-		processes: make([]BackgroundProcess, 0),
+		processes: make([]*BackgroundProcess, 0),
 		logger:    logger,
 	}
 }
@@ -39,12 +56,19 @@ func (pt *ProcessTracker) StartBackgroundProcess(cmd *exec.Cmd, name string) err
 		return fmt.Errorf("failed to start background process %s: %w", name, err)
 	}
 
-	// Track it
-	bgProcess := BackgroundProcess{
+	// Track it. The process is reaped as soon as it exits so Status can
+	// tell running and finished processes apart.
+	bgProcess := &BackgroundProcess{
 		Cmd:     cmd,
 		Name:    name,
 		Started: time.Now(),
+		done:    make(chan struct{}),
 	}
+	go func() {
+		bgProcess.err = cmd.Wait()
+		bgProcess.finished = time.Now()
+		close(bgProcess.done)
+	}()
 
 	pt.processes = append(pt.processes, bgProcess)
 	pt.logger.Info("Started background process: %s (PID: %d)", name, cmd.Process.Pid)
@@ -63,7 +87,7 @@ func (pt *ProcessTracker) WaitForCompletion(timeout time.Duration) []error {
 	}
 
 	// Get current processes and prepare to clear them after waiting
-	processes := make([]BackgroundProcess, len(pt.processes))
+	processes := make([]*BackgroundProcess, len(pt.processes))
 	copy(processes, pt.processes)
 	pt.mutex.Unlock()
 
@@ -76,11 +100,13 @@ func (pt *ProcessTracker) WaitForCompletion(timeout time.Duration) []error {
 
 	// Wait for each process in a separate goroutine
 	for i, bgProcess := range processes {
-		go func(index int, bp BackgroundProcess) {
+		go func(index int, bp *BackgroundProcess) {
+			defer Recover(pt.logger, "background process waiter")
 			pt.logger.Verbose("Waiting for background process: %s", bp.Name)
 
-			err := bp.Cmd.Wait()
-			runtime := time.Since(bp.Started)
+			<-bp.done
+			err := bp.err
+			runtime := bp.finished.Sub(bp.Started)
 
 			if err != nil {
 				pt.logger.Error("Background process %s failed after %v: %v", bp.Name, runtime, err)
@@ -90,6 +116,9 @@ func (pt *ProcessTracker) WaitForCompletion(timeout time.Duration) []error {
 			} else {
 				pt.logger.Info("✅ Background process completed: %s (runtime: %v)", bp.Name, runtime)
 			}
+			if GroupAlive(bp.Cmd.Process.Pid) {
+				pt.logger.Info("⚠️  %s exited but left processes running in its process group", bp.Name)
+			}
 
 			done <- index
 		}(i, bgProcess)
@@ -108,20 +137,22 @@ func (pt *ProcessTracker) WaitForCompletion(timeout time.Duration) []error {
 		case <-timeoutChan:
 			pt.logger.Error("Timeout waiting for background processes (%d/%d completed)", completed, len(processes))
 
-			// Kill remaining processes. Don't read Cmd.ProcessState here — the
-			// per-process wait goroutine writes it concurrently and would race.
-			// Kill on an already-exited process is a no-op and only returns an
-			// "already finished" error we ignore.
+			// Kill remaining processes with everything they spawned. Don't
+			// read Cmd.ProcessState here — the reaper goroutine writes it
+			// concurrently and would race. Signalling an already-exited
+			// process or empty group is a no-op.
 			for _, bgProcess := range processes {
 				if bgProcess.Cmd.Process == nil {
 					continue
 				}
 				pt.logger.Error("Killing timed-out background process: %s", bgProcess.Name)
-				_ = bgProcess.Cmd.Process.Kill()
+				KillTree(bgProcess.Cmd.Process.Pid, syscall.SIGKILL)
 			}
 
+			// Waiters still running may add to errors, so return a copy
 			errorMutex.Lock()
 			errors = append(errors, fmt.Errorf("timeout waiting for %d background processes", len(processes)-completed))
+			timedOut := append([]error(nil), errors...)
 			errorMutex.Unlock()
 
 			// Clear processes even on timeout to prevent future issues
@@ -130,7 +161,7 @@ func (pt *ProcessTracker) WaitForCompletion(timeout time.Duration) []error {
 			pt.logger.Debug("Cleared timed-out background processes from tracker")
 			pt.mutex.Unlock()
 
-			return errors
+			return timedOut
 		}
 	}
 
@@ -151,3 +182,28 @@ func (pt *ProcessTracker) GetActiveCount() int {
 	defer pt.mutex.Unlock()
 	return len(pt.processes)
 }
+
+// Status returns a snapshot of every tracked process, in start order
+func (pt *ProcessTracker) Status() []ProcessStatus {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+	statuses := make([]ProcessStatus, 0, len(pt.processes))
+	for _, bp := range pt.processes {
+		st := ProcessStatus{Name: bp.Name, Started: bp.Started, Running: true}
+		if bp.Cmd.Process != nil {
+			st.PID = bp.Cmd.Process.Pid
+		}
+		select {
+		case <-bp.done:
+			st.Running = false
+			st.RuntimeSeconds = bp.finished.Sub(bp.Started).Seconds()
+			if bp.err != nil {
+				st.Error = bp.err.Error()
+			}
+		default:
+			st.RuntimeSeconds = time.Since(bp.Started).Seconds()
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
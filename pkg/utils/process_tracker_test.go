@@ -71,3 +71,35 @@ func TestProcessTracker_EmptyTracker(t *testing.T) {
 		t.Fatalf("expected nil errors, got %v", errs)
 	}
 }
+
+// Status distinguishes running processes from exited ones and carries errors.
+func TestProcessTracker_Status(t *testing.T) {
+	pt := NewProcessTracker(NewLogger(false, false))
+	if err := pt.StartBackgroundProcess(exec.Command("/bin/sh", "-c", "exit 3"), "failing"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if err := pt.StartBackgroundProcess(exec.Command("/bin/sh", "-c", "sleep 30"), "sleeping"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var statuses []ProcessStatus
+	for {
+		statuses = pt.Status()
+		if !statuses[0].Running || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 statuses, got %d", len(statuses))
+	}
+	if failing := statuses[0]; failing.Running || failing.Error == "" || failing.PID == 0 {
+		t.Fatalf("unexpected status for exited process: %+v", failing)
+	}
+	if sleeping := statuses[1]; !sleeping.Running || sleeping.Error != "" {
+		t.Fatalf("unexpected status for running process: %+v", sleeping)
+	}
+
+	pt.WaitForCompletion(100 * time.Millisecond) // kills the sleeper
+}
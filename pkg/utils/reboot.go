@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-installapplications/pkg/audit"
+	"github.com/go-installapplications/pkg/config"
+)
+
+// RebootHooks are how Exit warns the user and restarts through MDM; the
+// mode package provides them, as they need the agent and the Jamf Pro API.
+type RebootHooks struct {
+	// Notify shows message to the console user, who has delay to save work.
+	Notify func(message string, delay time.Duration) error
+	// MDMRestart has the MDM restart the Mac (RebootMethod "mdm").
+	MDMRestart func() error
+}
+
+var (
+	rebootMu        sync.Mutex
+	rebootHooks     RebootHooks
+	installed       int
+	restartRequired []string
+)
+
+// SetRebootHooks sets the hooks Exit reboots with.
+func SetRebootHooks(hooks RebootHooks) {
+	rebootMu.Lock()
+	defer rebootMu.Unlock()
+	rebootHooks = hooks
+}
+
+// RecordInstalled counts an item that was installed or run rather than
+// skipped, for RebootCondition "installed".
+func RecordInstalled() {
+	rebootMu.Lock()
+	defer rebootMu.Unlock()
+	installed++
+}
+
+// Installed returns how many items RecordInstalled counted.
+func Installed() int {
+	rebootMu.Lock()
+	defer rebootMu.Unlock()
+	return installed
+}
+
+// RecordRestartRequired notes that the package item name asked for a
+// restart, for RebootCondition "required" and the run's status.
+func RecordRestartRequired(name string) {
+	rebootMu.Lock()
+	defer rebootMu.Unlock()
+	restartRequired = append(restartRequired, name)
+}
+
+// RestartRequired returns the items RecordRestartRequired noted, in order.
+func RestartRequired() []string {
+	rebootMu.Lock()
+	defer rebootMu.Unlock()
+	return append([]string(nil), restartRequired...)
+}
+
+// shouldReboot reports whether a run that ended with succeeded reboots.
+func shouldReboot(cfg *config.Config, logger Logger, succeeded bool) bool {
+	required := RestartRequired()
+	if len(required) > 0 {
+		logger.Info("🔄 Restart required by: %s", strings.Join(required, ", "))
+	}
+	if !cfg.Reboot || !succeeded {
+		if len(required) > 0 && succeeded {
+			logger.Info("⚠️  Reboot is off; restart the Mac to finish installing")
+		}
+		return false
+	}
+	switch {
+	case cfg.RebootCondition == config.RebootIfInstalled && Installed() == 0:
+		logger.Info("🔄 Reboot skipped: nothing was installed")
+		return false
+	case cfg.RebootCondition == config.RebootIfRequired && len(required) == 0:
+		logger.Info("🔄 Reboot skipped: no package requires a restart")
+		return false
+	}
+	return true
+}
+
+// rebootMessage is cfg.RebootMessage with "{{delay}}" replaced by delay.
+func rebootMessage(cfg *config.Config) string {
+	return strings.ReplaceAll(cfg.RebootMessage, "{{delay}}", cfg.RebootDelay.String())
+}
+
+// sleep waits before the reboot; a variable so tests can replace it.
+var sleep = time.Sleep
+
+// warnReboot tells the console user about the reboot, when there is a
+// RebootMessage, and waits RebootDelay.
+func warnReboot(cfg *config.Config, logger Logger) {
+	logger.Info("🔄 Reboot flag is set; system will reboot in %s", cfg.RebootDelay)
+	rebootMu.Lock()
+	notify := rebootHooks.Notify
+	rebootMu.Unlock()
+	if message := rebootMessage(cfg); message != "" && notify != nil {
+		if err := notify(message, cfg.RebootDelay); err != nil {
+			logger.Info("⚠️  Could not warn the user about the reboot: %v", err)
+		}
+	}
+	sleep(cfg.RebootDelay)
+}
+
+// shutdownCommand restarts the Mac; a variable so tests can replace it.
+var shutdownCommand = func() error {
+	return exec.Command("/sbin/shutdown", "-r", "now").Start()
+}
+
+// reboot restarts the Mac with cfg.RebootMethod, falling back to shutdown
+// when the MDM restart fails.
+func reboot(cfg *config.Config, logger Logger) {
+	rebootMu.Lock()
+	mdmRestart := rebootHooks.MDMRestart
+	rebootMu.Unlock()
+	if cfg.RebootMethod == config.RebootMDM && mdmRestart != nil {
+		err := mdmRestart()
+		audit.Record(audit.Event{Action: audit.ActionReboot, Target: "mdm RestartDevice", Outcome: audit.Outcome(err), Error: audit.ErrorString(err)})
+		if err == nil {
+			logger.Info("🔄 Restart requested from the MDM")
+			return
+		}
+		logger.Error("MDM restart failed, using shutdown: %v", err)
+	}
+	err := shutdownCommand()
+	audit.Record(audit.Event{Action: audit.ActionReboot, Target: "/sbin/shutdown -r now", Outcome: audit.Outcome(err), Error: audit.ErrorString(err)})
+	if err != nil {
+		logger.Error("Failed to initiate reboot: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-installapplications/pkg/config"
+)
+
+func TestShouldReboot(t *testing.T) {
+	logger := NewLoggerWithWriter(false, false, io.Discard)
+	defer func() { installed, restartRequired = 0, nil }()
+	cfg := config.NewConfig()
+	if shouldReboot(cfg, logger, true) {
+		t.Error("rebooted without Reboot")
+	}
+	cfg.Reboot = true
+	if shouldReboot(cfg, logger, false) {
+		t.Error("rebooted after a failed run")
+	}
+	if !shouldReboot(cfg, logger, true) {
+		t.Error("RebootCondition always did not reboot")
+	}
+	cfg.RebootCondition = config.RebootIfInstalled
+	if shouldReboot(cfg, logger, true) {
+		t.Error("rebooted with nothing installed")
+	}
+	RecordInstalled()
+	if !shouldReboot(cfg, logger, true) {
+		t.Error("did not reboot after an install")
+	}
+	cfg.RebootCondition = config.RebootIfRequired
+	if shouldReboot(cfg, logger, true) {
+		t.Error("rebooted with no package requiring it")
+	}
+	RecordRestartRequired("macOS Update")
+	if !shouldReboot(cfg, logger, true) {
+		t.Error("did not reboot for a package requiring it")
+	}
+}
+
+func TestWarnRebootAndReboot(t *testing.T) {
+	logger := NewLoggerWithWriter(false, false, io.Discard)
+	var slept time.Duration
+	var notified string
+	var shutdowns int
+	restoreSleep, restoreShutdown := sleep, shutdownCommand
+	defer func() {
+		sleep, shutdownCommand = restoreSleep, restoreShutdown
+		SetRebootHooks(RebootHooks{})
+	}()
+	sleep = func(d time.Duration) { slept = d }
+	shutdownCommand = func() error { shutdowns++; return nil }
+	SetRebootHooks(RebootHooks{
+		Notify:     func(message string, delay time.Duration) error { notified = message; return nil },
+		MDMRestart: func() error { return errors.New("no MDM") },
+	})
+
+	cfg := config.NewConfig()
+	cfg.RebootDelay = time.Minute
+	cfg.RebootMessage = "Restarting in {{delay}}"
+	warnReboot(cfg, logger)
+	if notified != "Restarting in 1m0s" || slept != time.Minute {
+		t.Errorf("notified %q, slept %v", notified, slept)
+	}
+
+	cfg.RebootMethod = config.RebootMDM
+	reboot(cfg, logger)
+	if shutdowns != 1 {
+		t.Errorf("shutdown ran %d times after the MDM restart failed, want 1", shutdowns)
+	}
+	SetRebootHooks(RebootHooks{MDMRestart: func() error { return nil }})
+	reboot(cfg, logger)
+	if shutdowns != 1 {
+		t.Error("shutdown ran after the MDM restart")
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
@@ -9,16 +10,29 @@ import (
 type RetryFunc func() error
 
 // Retry executes a function with retry logic
-func Retry(operation RetryFunc, maxRetries int, delay time.Duration, description string, logger *Logger) (int, error) {
+func Retry(operation RetryFunc, maxRetries int, delay time.Duration, description string, logger Logger) (int, error) {
+	return RetryContext(context.Background(), operation, maxRetries, delay, description, logger)
+}
+
+// RetryContext is Retry that gives up, without further attempts, once ctx is
+// done.
+func RetryContext(ctx context.Context, operation RetryFunc, maxRetries int, delay time.Duration, description string, logger Logger) (int, error) {
 	var lastError error
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		if attempt > 0 {
 			logger.Info("Retry attempt %d/%d for %s (waiting %v)\n", attempt, maxRetries, description, delay)
-			time.Sleep(delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return attempt, fmt.Errorf("%s stopped: %w", description, context.Cause(ctx))
+			}
 		}
 
 		err := operation()
+		if err != nil && ctx.Err() != nil {
+			return attempt + 1, fmt.Errorf("%s stopped: %w", description, context.Cause(ctx))
+		}
 		if err == nil {
 			if attempt > 0 {
 				logger.Info("Succeeded on attempt %d for %s", attempt+1, description)
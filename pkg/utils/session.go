@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// FileVaultDeferred reports whether FileVault is set to be turned on at the
+// next login (fdesetup -defer, as MDM profiles commonly do). That login shows
+// the FileVault prompt and may end with a restart.
+func FileVaultDeferred() bool {
+	out, err := RunCommandCapture([]string{"fdesetup", "status"})
+	if err != nil {
+		return false
+	}
+	return parseFileVaultDeferred(out)
+}
+
+// parseFileVaultDeferred recognizes the `fdesetup status` lines printed while
+// enablement is deferred.
+func parseFileVaultDeferred(out string) bool {
+	lower := strings.ToLower(out)
+	return strings.Contains(lower, "deferred enablement appears to be active") ||
+		strings.Contains(lower, "will be enabled after the next login")
+}
+
+// DesktopReady reports whether uid's login has reached the desktop: the Dock
+// is running for the user and no Setup Assistant (such as the per-user one
+// shown on first login) is on screen.
+func DesktopReady(uid string) bool {
+	if exec.Command("pgrep", "-u", uid, "-x", "Dock").Run() != nil {
+		return false
+	}
+	return !setupAssistantRunning()
+}
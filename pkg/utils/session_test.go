@@ -0,0 +1,18 @@
+package utils
+
+import "testing"
+
+func TestParseFileVaultDeferred(t *testing.T) {
+	cases := map[string]bool{
+		"FileVault is On.":  false,
+		"FileVault is Off.": false,
+		"FileVault is Off, but will be enabled after the next login by alice.":          true,
+		"FileVault is Off.\nDeferred enablement appears to be active for user 'alice'.": true,
+		"FileVault is On.\nEncryption in progress: Percent completed = 42.1":            false,
+	}
+	for out, want := range cases {
+		if got := parseFileVaultDeferred(out); got != want {
+			t.Errorf("parseFileVaultDeferred(%q) = %v, want %v", out, got, want)
+		}
+	}
+}
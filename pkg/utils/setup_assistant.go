@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// SetupAssistantUID is the UID of _mbsetupuser, which owns the console while
+// Setup Assistant runs. It is not a real user: nothing should be delivered to
+// it.
+const SetupAssistantUID = "248"
+
+// Setup Assistant probes; variables so tests can replace them.
+var (
+	// setupDonePath is created once Setup Assistant has finished
+	setupDonePath = "/private/var/db/.AppleSetupDone"
+	// setupAssistantRunning reports whether a Setup Assistant process is
+	// running, including the per-user one shown after the first login
+	setupAssistantRunning = func() bool {
+		return exec.Command("pgrep", "-x", "Setup Assistant").Run() == nil
+	}
+	consoleUserUID         = GetConsoleUserUID
+	setupAssistantInterval = 5 * time.Second
+)
+
+// InSetupAssistant reports whether the Mac is still in Setup Assistant:
+// .AppleSetupDone is missing, _mbsetupuser owns the console, or Setup
+// Assistant is running.
+func InSetupAssistant() bool {
+	if _, err := os.Stat(setupDonePath); os.IsNotExist(err) {
+		return true
+	}
+	if uid, err := consoleUserUID(); err == nil && uid == SetupAssistantUID {
+		return true
+	}
+	return setupAssistantRunning()
+}
+
+// WaitForSetupAssistant blocks until InSetupAssistant is false, ctx is done
+// or timeout has passed.
+func WaitForSetupAssistant(ctx context.Context, timeout time.Duration, logger Logger) error {
+	if !InSetupAssistant() {
+		return nil
+	}
+	logger.Info("⏳ Waiting for Setup Assistant to finish")
+	start := time.Now()
+	for InSetupAssistant() {
+		if time.Since(start) > timeout {
+			return fmt.Errorf("timeout waiting for Setup Assistant to finish")
+		}
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(setupAssistantInterval):
+		}
+	}
+	logger.Info("Setup Assistant finished after %s", time.Since(start).Round(time.Second))
+	return nil
+}
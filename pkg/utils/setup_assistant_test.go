@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setupAssistantScope points the probes at a temp .AppleSetupDone and
+// returns a function that creates it.
+func setupAssistantScope(t *testing.T, consoleUID string, running bool) (markDone func()) {
+	t.Helper()
+	prevPath, prevRunning, prevUID, prevInterval := setupDonePath, setupAssistantRunning, consoleUserUID, setupAssistantInterval
+	t.Cleanup(func() {
+		setupDonePath, setupAssistantRunning, consoleUserUID, setupAssistantInterval = prevPath, prevRunning, prevUID, prevInterval
+	})
+	// markDone may run on another goroutine, so it uses its own copy of
+	// the path rather than the global the cleanup restores
+	donePath := filepath.Join(t.TempDir(), ".AppleSetupDone")
+	setupDonePath = donePath
+	setupAssistantRunning = func() bool { return running }
+	consoleUserUID = func() (string, error) { return consoleUID, nil }
+	setupAssistantInterval = 10 * time.Millisecond
+	return func() {
+		if err := os.WriteFile(donePath, nil, 0644); err != nil {
+			t.Errorf("create %s: %v", donePath, err)
+		}
+	}
+}
+
+func TestInSetupAssistant(t *testing.T) {
+	tests := []struct {
+		name    string
+		done    bool
+		uid     string
+		running bool
+		want    bool
+	}{
+		{"setup not done", false, "0", false, true},
+		{"mbsetupuser owns console", true, SetupAssistantUID, false, true},
+		{"user setup assistant running", true, "501", true, true},
+		{"finished", true, "501", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			markDone := setupAssistantScope(t, tt.uid, tt.running)
+			if tt.done {
+				markDone()
+			}
+			if got := InSetupAssistant(); got != tt.want {
+				t.Fatalf("InSetupAssistant() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitForSetupAssistant_ReturnsOnceDone(t *testing.T) {
+	markDone := setupAssistantScope(t, "501", false)
+	marked := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() {
+		defer close(marked)
+		markDone()
+	})
+	// The timer is done before the cleanup restores the probes
+	defer func() { <-marked }()
+	if err := WaitForSetupAssistant(context.Background(), time.Minute, NewLogger(false, false)); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+}
+
+func TestWaitForSetupAssistant_StopsOnCancelAndTimeout(t *testing.T) {
+	setupAssistantScope(t, SetupAssistantUID, true)
+	logger := NewLogger(false, false)
+
+	if err := WaitForSetupAssistant(context.Background(), 30*time.Millisecond, logger); err == nil {
+		t.Fatal("expected timeout")
+	}
+	cause := errors.New("stopped")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(cause)
+	if err := WaitForSetupAssistant(ctx, time.Minute, logger); !errors.Is(err, cause) {
+		t.Fatalf("err = %v, want %v", err, cause)
+	}
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-installapplications/pkg/audit"
+)
+
+// ExitCodeInterrupted is the exit code of a run stopped by SIGTERM or SIGINT
+// (128 + SIGINT, as shells report an interrupted command).
+const ExitCodeInterrupted ExitCode = 130
+
+// SignalError is the cause of a context cancelled by ShutdownContext.
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (e *SignalError) Error() string {
+	return "interrupted by " + signalName(e.Signal)
+}
+
+// TimeoutError is the cause of a context cancelled by WithTimeout.
+type TimeoutError struct {
+	What  string // "run" or "<phase> phase"
+	Limit time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %v", e.What, e.Limit)
+}
+
+// IsTimeout reports whether ctx was cancelled by WithTimeout rather than by
+// a shutdown signal.
+func IsTimeout(ctx context.Context) bool {
+	var timeout *TimeoutError
+	return ctx.Err() != nil && errors.As(context.Cause(ctx), &timeout)
+}
+
+// WithTimeout returns a copy of ctx that is cancelled after limit with a
+// *TimeoutError cause naming what. Reaching the limit is logged and recorded
+// in the audit log. Unlike a signal, a timeout is a failure: callers let it
+// take their normal failure and cleanup path.
+func WithTimeout(ctx context.Context, limit time.Duration, what string, logger Logger) (context.Context, context.CancelFunc) {
+	cause := &TimeoutError{What: what, Limit: limit}
+	ctx, cancel := context.WithTimeoutCause(ctx, limit, cause)
+	stop := context.AfterFunc(ctx, func() {
+		if context.Cause(ctx) != cause {
+			return
+		}
+		logger.Error("⏰ %v; stopping", cause)
+		audit.Record(audit.Event{Action: audit.ActionTimeout, Target: what, Outcome: audit.OutcomeTimedOut, Error: cause.Error()})
+	})
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// ShutdownContext returns a context that is cancelled when the process
+// receives SIGTERM (e.g. launchctl bootout) or SIGINT; context.Cause returns a
+// *SignalError. A second signal exits immediately with ExitCodeInterrupted.
+func ShutdownContext(logger Logger) context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-sigs
+		logger.Info("🛑 Received %s; finishing up and stopping (send again to exit immediately)", signalName(sig))
+		cancel(&SignalError{Signal: sig})
+		sig = <-sigs
+		logger.Error("Received %s again; exiting immediately", signalName(sig))
+		_ = audit.Default().Close()
+		os.Exit(int(ExitCodeInterrupted))
+	}()
+	return ctx
+}
+
+// ExitInterrupted ends a run stopped by a shutdown signal. Unlike Exit it
+// skips Cleanup and reboot so the installation is left in place to run again,
+// and the exit code is never coerced by NoRestartOnError. The interruption is
+// recorded in the audit log, which is closed before exiting.
+func ExitInterrupted(ctx context.Context, logger Logger) {
+	cause := context.Cause(ctx)
+	target := "signal"
+	if sigErr, ok := cause.(*SignalError); ok {
+		target = signalName(sigErr.Signal)
+	}
+	logger.Info("Exiting with code %d: %v", ExitCodeInterrupted, cause)
+	audit.Record(audit.Event{Action: audit.ActionShutdown, Target: target, Outcome: audit.OutcomeInterrupted, Error: audit.ErrorString(cause)})
+	_ = audit.Default().Close()
+	os.Exit(int(ExitCodeInterrupted))
+}
+
+func signalName(sig os.Signal) string {
+	switch sig {
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	case syscall.SIGINT:
+		return "SIGINT"
+	}
+	return sig.String()
+}
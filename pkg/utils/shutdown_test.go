@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownContext_CancelledBySignal(t *testing.T) {
+	ctx := ShutdownContext(NewLogger(false, false))
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("kill: %v", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("context not cancelled by SIGTERM")
+	}
+	var sigErr *SignalError
+	if !errors.As(context.Cause(ctx), &sigErr) || sigErr.Signal != syscall.SIGTERM {
+		t.Fatalf("cause = %v", context.Cause(ctx))
+	}
+	if got := sigErr.Error(); got != "interrupted by SIGTERM" {
+		t.Fatalf("Error() = %q", got)
+	}
+}
+
+func TestRetryContext_StopsRetrying(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	calls := 0
+	op := func() error {
+		calls++
+		cancel(errors.New("interrupted by SIGINT"))
+		return errors.New("connection reset")
+	}
+	attempts, err := RetryContext(ctx, op, 5, time.Minute, "download x", NewLogger(false, false))
+	if err == nil || err.Error() != "download x stopped: interrupted by SIGINT" {
+		t.Fatalf("err = %v", err)
+	}
+	if calls != 1 || attempts != 1 {
+		t.Fatalf("calls = %d, attempts = %d; want 1, 1", calls, attempts)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	ctx, cancel := WithTimeout(context.Background(), 10*time.Millisecond, "setupassistant phase", NewLogger(false, false))
+	defer cancel()
+	<-ctx.Done()
+	if !IsTimeout(ctx) || context.Cause(ctx).Error() != "setupassistant phase timed out after 10ms" {
+		t.Fatalf("cause = %v", context.Cause(ctx))
+	}
+
+	ctx, cancel = WithTimeout(context.Background(), time.Minute, "run", NewLogger(false, false))
+	cancel()
+	if IsTimeout(ctx) {
+		t.Fatal("a cancelled context is not a timeout")
+	}
+}
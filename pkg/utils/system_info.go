@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SystemInfo describes the Mac and its macOS release. A field that cannot be
+// read is empty.
+type SystemInfo struct {
+	ProductVersion  string // e.g. "14.4.1"
+	BuildVersion    string // e.g. "23E224"
+	ModelIdentifier string // e.g. "MacBookPro18,3"
+	MarketingName   string // e.g. "MacBook Pro (14-inch, 2021)"; Apple silicon only
+	Chip            string // CPU brand, e.g. "Apple M1 Pro" or "Intel(R) Core(TM) i7-8700B CPU @ 3.20GHz"
+	Virtual         bool   // running in a virtual machine
+}
+
+// ChipFamily is the chip generation: "M1", "M2"... on Apple silicon, "Intel"
+// on Intel Macs, or "" when the chip is unknown.
+func (s SystemInfo) ChipFamily() string {
+	if strings.HasPrefix(s.Chip, "Intel") {
+		return "Intel"
+	}
+	if name, ok := strings.CutPrefix(s.Chip, "Apple "); ok {
+		return strings.Fields(name + " ")[0]
+	}
+	return ""
+}
+
+// String summarizes the system for a log header, e.g. "macOS 14.4.1 (23E224),
+// MacBook Pro (14-inch, 2021) (MacBookPro18,3), Apple M1 Pro".
+func (s SystemInfo) String() string {
+	parts := []string{"macOS " + orUnknown(s.ProductVersion)}
+	if s.BuildVersion != "" {
+		parts[0] += " (" + s.BuildVersion + ")"
+	}
+	switch {
+	case s.MarketingName != "" && s.ModelIdentifier != "":
+		parts = append(parts, s.MarketingName+" ("+s.ModelIdentifier+")")
+	case s.ModelIdentifier != "":
+		parts = append(parts, s.ModelIdentifier)
+	}
+	if s.Chip != "" {
+		parts = append(parts, s.Chip)
+	}
+	if s.Virtual {
+		parts = append(parts, "virtual machine")
+	}
+	return strings.Join(parts, ", ")
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+var (
+	systemInfoOnce sync.Once
+	systemInfo     SystemInfo
+)
+
+// GetSystemInfo returns the system's description, read once per process:
+// none of it changes without a restart.
+func GetSystemInfo() SystemInfo {
+	systemInfoOnce.Do(func() {
+		systemInfo = readSystemInfo()
+	})
+	return systemInfo
+}
+
+// runSystemQuery runs a read-only system command; a variable so tests can
+// replace it.
+var runSystemQuery = RunCommandCapture
+
+func readSystemInfo() SystemInfo {
+	var info SystemInfo
+	info.ProductVersion, _ = runSystemQuery([]string{"sw_vers", "-productVersion"})
+	info.BuildVersion, _ = runSystemQuery([]string{"sw_vers", "-buildVersion"})
+	info.ModelIdentifier, _ = runSystemQuery([]string{"sysctl", "-n", "hw.model"})
+	info.Chip, _ = runSystemQuery([]string{"sysctl", "-n", "machdep.cpu.brand_string"})
+	if out, err := runSystemQuery([]string{"sysctl", "-n", "kern.hv_vmm_present"}); err == nil {
+		info.Virtual = out == "1"
+	}
+	// Only Apple silicon Macs carry their marketing name in the device tree
+	if out, err := runSystemQuery([]string{"ioreg", "-arc", "IOPlatformDevice", "-k", "product-name"}); err == nil {
+		info.MarketingName = parseIORegData(out, "product-name")
+	}
+	return info
+}
+
+// parseIORegData extracts a data property holding a C string from `ioreg`
+// output, e.g. `"product-name" = <"MacBook Air (M1, 2020)">`.
+func parseIORegData(out, key string) string {
+	value := parseIORegValue(out, key)
+	value = strings.TrimSuffix(strings.TrimPrefix(value, `<"`), `">`)
+	return strings.TrimRight(value, "\x00")
+}
+
+// CompareVersions compares dotted numeric versions such as macOS product
+// versions: -1 when a is older than b, 0 when equal, 1 when newer. Missing
+// components count as 0, so "14" equals "14.0.0".
+func CompareVersions(a, b string) (int, error) {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, err := versionComponent(as, i)
+		if err != nil {
+			return 0, fmt.Errorf("invalid version %q", a)
+		}
+		y, err := versionComponent(bs, i)
+		if err != nil {
+			return 0, fmt.Errorf("invalid version %q", b)
+		}
+		if x != y {
+			if x < y {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func versionComponent(parts []string, i int) (int, error) {
+	if i >= len(parts) {
+		return 0, nil
+	}
+	return strconv.Atoi(strings.TrimSpace(parts[i]))
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReadSystemInfo(t *testing.T) {
+	old := runSystemQuery
+	defer func() { runSystemQuery = old }()
+	answers := map[string]string{
+		"sw_vers -productVersion":            "14.4.1",
+		"sw_vers -buildVersion":              "23E224",
+		"sysctl -n hw.model":                 "MacBookPro18,3",
+		"sysctl -n machdep.cpu.brand_string": "Apple M1 Pro",
+		"sysctl -n kern.hv_vmm_present":      "0",
+		"ioreg -arc IOPlatformDevice -k product-name": `+-o J314sAP  <class IOPlatformDevice>
+    {
+      "product-name" = <"MacBook Pro (14-inch, 2021)">
+    }`,
+	}
+	runSystemQuery = func(args []string) (string, error) {
+		if out, ok := answers[strings.Join(args, " ")]; ok {
+			return out, nil
+		}
+		return "", errors.New("unexpected command")
+	}
+
+	info := readSystemInfo()
+	want := SystemInfo{
+		ProductVersion:  "14.4.1",
+		BuildVersion:    "23E224",
+		ModelIdentifier: "MacBookPro18,3",
+		MarketingName:   "MacBook Pro (14-inch, 2021)",
+		Chip:            "Apple M1 Pro",
+	}
+	if info != want {
+		t.Fatalf("readSystemInfo() = %+v, want %+v", info, want)
+	}
+	if got := info.String(); got != "macOS 14.4.1 (23E224), MacBook Pro (14-inch, 2021) (MacBookPro18,3), Apple M1 Pro" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestChipFamily(t *testing.T) {
+	cases := map[string]string{
+		"Apple M1":     "M1",
+		"Apple M2 Max": "M2",
+		"Intel(R) Core(TM) i7-8700B CPU @ 3.20GHz": "Intel",
+		"": "",
+	}
+	for chip, want := range cases {
+		if got := (SystemInfo{Chip: chip}).ChipFamily(); got != want {
+			t.Errorf("ChipFamily(%q) = %q, want %q", chip, got, want)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"14.4.1", "14.4.1", 0},
+		{"14", "14.0.0", 0},
+		{"13.6", "14", -1},
+		{"14.10", "14.9", 1},
+		{"15.0.1", "15", 1},
+	}
+	for _, tc := range cases {
+		if got, err := CompareVersions(tc.a, tc.b); err != nil || got != tc.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, %v; want %d", tc.a, tc.b, got, err, tc.want)
+		}
+	}
+	if _, err := CompareVersions("14.x", "14"); err == nil {
+		t.Error("CompareVersions accepted 14.x")
+	}
+}
+
+func TestParseOSCondition(t *testing.T) {
+	cases := []struct {
+		cond, op, version string
+		ok                bool
+	}{
+		{"macos<14", "<", "14", true},
+		{"macos<=14.4", "<=", "14.4", true},
+		{"macos >= 15", ">=", "15", true},
+		{"macos==13.6.1", "==", "13.6.1", true},
+		{"macos<", "", "", false},
+		{"macos14", "", "", false},
+		{"ios<17", "", "", false},
+	}
+	for _, tc := range cases {
+		op, version, ok := parseOSCondition(tc.cond)
+		if ok != tc.ok || op != tc.op || version != tc.version {
+			t.Errorf("parseOSCondition(%q) = %q, %q, %v", tc.cond, op, version, ok)
+		}
+	}
+	if !osConditionHolds("<", -1) || osConditionHolds("<", 0) || !osConditionHolds(">=", 0) || osConditionHolds("==", 1) {
+		t.Error("osConditionHolds disagrees with its operators")
+	}
+}
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"howett.net/plist"
+)
+
+// Deferral policies for user-disruptive actions such as a reboot, a logout
+// or a notification; see UserActivity.Allows.
+const (
+	// DeferUntilIdle holds an action until the user is away: the screen is
+	// locked, the screensaver is on or there has been no input for a while
+	DeferUntilIdle = "idle"
+	// DeferUntilActive holds an action until the user is at the Mac, e.g. a
+	// notification they should see
+	DeferUntilActive = "active"
+)
+
+// UserActivity is what the console user is doing.
+type UserActivity struct {
+	Idle         time.Duration // since the last keyboard or mouse input
+	ScreenLocked bool
+	ScreenSaver  bool
+	// Presenting lists the processes keeping the display awake, such as
+	// Keynote or a video call; interrupting them would interrupt a
+	// presentation or a meeting
+	Presenting []string
+}
+
+// Away reports whether the user has left the Mac: the screen is locked, the
+// screensaver runs or there has been no input for idleAfter.
+func (a UserActivity) Away(idleAfter time.Duration) bool {
+	return a.ScreenLocked || a.ScreenSaver || a.Idle >= idleAfter
+}
+
+// Allows reports whether an action deferred with policy may run now.
+// DeferUntilIdle waits for the user to be Away, DeferUntilActive for them to
+// be back, and neither lets an action run during a presentation. Any other
+// policy, such as "", does not defer.
+func (a UserActivity) Allows(policy string, idleAfter time.Duration) bool {
+	if len(a.Presenting) > 0 {
+		return policy != DeferUntilIdle && policy != DeferUntilActive
+	}
+	switch policy {
+	case DeferUntilIdle:
+		return a.Away(idleAfter)
+	case DeferUntilActive:
+		return !a.Away(idleAfter)
+	}
+	return true
+}
+
+func (a UserActivity) String() string {
+	s := fmt.Sprintf("idle %s", a.Idle.Round(time.Second))
+	if a.ScreenLocked {
+		s += ", screen locked"
+	}
+	if a.ScreenSaver {
+		s += ", screensaver"
+	}
+	if len(a.Presenting) > 0 {
+		s += fmt.Sprintf(", presenting (%v)", a.Presenting)
+	}
+	return s
+}
+
+// GetUserActivity reads the console user's activity. Only the idle time is
+// required; the other probes count as false when they fail.
+func GetUserActivity() (UserActivity, error) {
+	var activity UserActivity
+	out, err := runSystemQuery([]string{"ioreg", "-r", "-c", "IOHIDSystem", "-d", "1"})
+	if err != nil {
+		return activity, fmt.Errorf("idle time: %w", err)
+	}
+	if activity.Idle, err = parseHIDIdleTime(out); err != nil {
+		return activity, err
+	}
+	if out, err := runSystemQuery([]string{"ioreg", "-n", "Root", "-d", "1", "-a"}); err == nil {
+		activity.ScreenLocked = parseScreenLocked([]byte(out))
+	}
+	_, err = runSystemQuery([]string{"pgrep", "-x", "ScreenSaverEngine"})
+	activity.ScreenSaver = err == nil
+	if out, err := runSystemQuery([]string{"pmset", "-g", "assertions"}); err == nil {
+		activity.Presenting = parseDisplayAssertions(out)
+	}
+	return activity, nil
+}
+
+// parseHIDIdleTime reads HIDIdleTime, in nanoseconds, from `ioreg` output for
+// IOHIDSystem.
+func parseHIDIdleTime(out string) (time.Duration, error) {
+	value := parseIORegValue(out, "HIDIdleTime")
+	ns, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("no HIDIdleTime in ioreg output")
+	}
+	return time.Duration(ns), nil
+}
+
+// parseScreenLocked reports whether the session on the console is locked,
+// from the IOConsoleUsers of `ioreg -n Root -d 1 -a`.
+func parseScreenLocked(out []byte) bool {
+	var root struct {
+		ConsoleUsers []struct {
+			OnConsole bool `plist:"kCGSSessionOnConsoleKey"`
+			Locked    bool `plist:"CGSSessionScreenIsLocked"`
+		} `plist:"IOConsoleUsers"`
+	}
+	if _, err := plist.Unmarshal(out, &root); err != nil {
+		return false
+	}
+	for _, session := range root.ConsoleUsers {
+		if session.OnConsole && session.Locked {
+			return true
+		}
+	}
+	return false
+}
+
+// displayAssertion matches a process's assertion in `pmset -g assertions`,
+// e.g. `pid 812(zoom.us): [0x...] 00:12:01 PreventUserIdleDisplaySleep named: "..."`.
+var displayAssertion = regexp.MustCompile(`pid \d+\((.+?)\): .*\bPreventUserIdleDisplaySleep named`)
+
+// notPresenting hold display assertions without anyone presenting.
+var notPresenting = map[string]bool{"caffeinate": true, "powerd": true}
+
+// parseDisplayAssertions returns the processes that keep the display awake,
+// sorted, leaving out notPresenting.
+func parseDisplayAssertions(out string) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, m := range displayAssertion.FindAllStringSubmatch(out, -1) {
+		if name := m[1]; !seen[name] && !notPresenting[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// userActivity and userActivityInterval are variables so tests can replace
+// them.
+var (
+	userActivity         = GetUserActivity
+	userActivityInterval = 30 * time.Second
+)
+
+// WaitForUserActivity blocks until the console user's activity Allows
+// policy, ctx is done or timeout has passed. The action should go ahead on
+// a timeout rather than be deferred forever; that is up to the caller.
+func WaitForUserActivity(ctx context.Context, policy string, idleAfter, timeout time.Duration, logger Logger) error {
+	start := time.Now()
+	logged := false
+	for {
+		activity, err := userActivity()
+		if err != nil {
+			return err
+		}
+		if activity.Allows(policy, idleAfter) {
+			if logged {
+				logger.Info("User is %s after %s (%s)", policy, time.Since(start).Round(time.Second), activity)
+			}
+			return nil
+		}
+		if !logged {
+			logger.Info("⏳ Waiting for the user to be %s (%s)", policy, activity)
+			logged = true
+		}
+		if time.Since(start) > timeout {
+			return fmt.Errorf("timeout waiting for the user to be %s", policy)
+		}
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(userActivityInterval):
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUserActivityProbes(t *testing.T) {
+	idle, err := parseHIDIdleTime(`+-o IOHIDSystem  <class IOHIDSystem>
+    {
+      "HIDIdleTime" = 95000000000
+      "IOGeneralInterest" = "IOCommand is not serializable"
+    }`)
+	if err != nil || idle != 95*time.Second {
+		t.Errorf("parseHIDIdleTime = %v, %v", idle, err)
+	}
+	if _, err := parseHIDIdleTime("no such class"); err == nil {
+		t.Error("parseHIDIdleTime accepted output without HIDIdleTime")
+	}
+
+	root := `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>IOConsoleUsers</key><array>
+<dict><key>kCGSSessionUserIDKey</key><integer>502</integer><key>kCGSSessionOnConsoleKey</key><false/><key>CGSSessionScreenIsLocked</key><true/></dict>
+<dict><key>kCGSSessionUserIDKey</key><integer>501</integer><key>kCGSSessionOnConsoleKey</key><true/>%s</dict>
+</array></dict></plist>`
+	if parseScreenLocked([]byte(strings.Replace(root, "%s", "", 1))) {
+		t.Error("a switched-out user's lock counted for the console")
+	}
+	if !parseScreenLocked([]byte(strings.Replace(root, "%s", "<key>CGSSessionScreenIsLocked</key><true/>", 1))) {
+		t.Error("locked console session not detected")
+	}
+
+	assertions := `Assertion status system-wide:
+   PreventUserIdleDisplaySleep    1
+Listed by owning process:
+   pid 812(zoom.us): [0x0000a1b2000193e1] 00:12:01 PreventUserIdleDisplaySleep named: "Zoom is sharing"
+   pid 95(powerd): [0x0000a1b200010000] 00:30:00 PreventUserIdleDisplaySleep named: "Powerd - Prevent sleep while display is on"
+   pid 401(coreaudiod): [0x0000a1b200010001] 00:01:00 PreventUserIdleSystemSleep named: "com.apple.audio.context"
+   pid 903(Keynote): [0x0000a1b200010002] 00:02:00 PreventUserIdleDisplaySleep named: "Playing slideshow"`
+	if got := parseDisplayAssertions(assertions); !reflect.DeepEqual(got, []string{"Keynote", "zoom.us"}) {
+		t.Errorf("parseDisplayAssertions = %v", got)
+	}
+}
+
+func TestUserActivityAllows(t *testing.T) {
+	active := UserActivity{Idle: 5 * time.Second}
+	idle := UserActivity{Idle: 20 * time.Minute}
+	locked := UserActivity{Idle: time.Second, ScreenLocked: true}
+	presenting := UserActivity{Idle: 20 * time.Minute, Presenting: []string{"Keynote"}}
+	cases := []struct {
+		activity UserActivity
+		policy   string
+		want     bool
+	}{
+		{active, DeferUntilIdle, false},
+		{idle, DeferUntilIdle, true},
+		{locked, DeferUntilIdle, true},
+		{presenting, DeferUntilIdle, false},
+		{active, DeferUntilActive, true},
+		{locked, DeferUntilActive, false},
+		{presenting, DeferUntilActive, false},
+		{presenting, "", true},
+	}
+	for _, tc := range cases {
+		if got := tc.activity.Allows(tc.policy, 10*time.Minute); got != tc.want {
+			t.Errorf("%s: Allows(%q) = %v, want %v", tc.activity, tc.policy, got, tc.want)
+		}
+	}
+}
+
+func TestWaitForUserActivity(t *testing.T) {
+	oldActivity, oldInterval := userActivity, userActivityInterval
+	defer func() { userActivity, userActivityInterval = oldActivity, oldInterval }()
+	userActivityInterval = time.Millisecond
+
+	polls := 0
+	userActivity = func() (UserActivity, error) {
+		polls++
+		if polls < 3 {
+			return UserActivity{Idle: time.Second}, nil
+		}
+		return UserActivity{ScreenLocked: true}, nil
+	}
+	logger := NewLogger(false, false)
+	if err := WaitForUserActivity(context.Background(), DeferUntilIdle, time.Minute, time.Minute, logger); err != nil || polls != 3 {
+		t.Fatalf("WaitForUserActivity = %v after %d polls", err, polls)
+	}
+
+	userActivity = func() (UserActivity, error) { return UserActivity{}, nil }
+	if err := WaitForUserActivity(context.Background(), DeferUntilIdle, time.Minute, 0, logger); err == nil {
+		t.Error("WaitForUserActivity did not time out")
+	}
+}
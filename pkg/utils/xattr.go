@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// QuarantineAttr is set by macOS on downloaded files; Gatekeeper checks
+// quarantined apps at first launch.
+const QuarantineAttr = "com.apple.quarantine"
+
+// runXAttr runs the xattr command; a variable so tests can replace it.
+var runXAttr = RunCommandCapture
+
+// SetXAttrs removes the quarantine attribute from path when stripQuarantine
+// is set, then writes attrs to it in name order. A file that is not
+// quarantined is left as it is.
+func SetXAttrs(path string, stripQuarantine bool, attrs map[string]string) error {
+	if stripQuarantine {
+		if _, err := runXAttr([]string{"xattr", "-d", QuarantineAttr, path}); err != nil && !strings.Contains(err.Error(), "No such xattr") {
+			return fmt.Errorf("failed to remove %s from %s: %w", QuarantineAttr, path, err)
+		}
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := runXAttr([]string{"xattr", "-w", name, attrs[name], path}); err != nil {
+			return fmt.Errorf("failed to set %s on %s: %w", name, path, err)
+		}
+	}
+	return nil
+}
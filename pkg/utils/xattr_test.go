@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func stubXAttr(t *testing.T, fail string) *[][]string {
+	t.Helper()
+	var calls [][]string
+	orig := runXAttr
+	runXAttr = func(args []string) (string, error) {
+		calls = append(calls, args)
+		if args[1] == fail {
+			return "", errors.New("exit status 1: xattr: [Errno 93] No such xattr: " + args[2])
+		}
+		return "", nil
+	}
+	t.Cleanup(func() { runXAttr = orig })
+	return &calls
+}
+
+func TestSetXAttrs(t *testing.T) {
+	calls := stubXAttr(t, "")
+	err := SetXAttrs("/tmp/helper", true, map[string]string{"com.example.b": "2", "com.example.a": "1"})
+	if err != nil {
+		t.Fatalf("SetXAttrs: %v", err)
+	}
+	want := [][]string{
+		{"xattr", "-d", QuarantineAttr, "/tmp/helper"},
+		{"xattr", "-w", "com.example.a", "1", "/tmp/helper"},
+		{"xattr", "-w", "com.example.b", "2", "/tmp/helper"},
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("calls = %v, want %v", *calls, want)
+	}
+}
+
+func TestSetXAttrs_NotQuarantined(t *testing.T) {
+	stubXAttr(t, "-d")
+	if err := SetXAttrs("/tmp/helper", true, nil); err != nil {
+		t.Errorf("unquarantined file: %v", err)
+	}
+
+	stubXAttr(t, "-w")
+	if err := SetXAttrs("/tmp/helper", false, map[string]string{"com.example.a": "1"}); err == nil || !strings.Contains(err.Error(), "com.example.a") {
+		t.Errorf("failed write: err = %v", err)
+	}
+}
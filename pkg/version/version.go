@@ -0,0 +1,68 @@
+// Package version holds the build metadata of the binary. The values are
+// injected at link time, e.g.
+//
+//	go build -ldflags "-X github.com/go-installapplications/pkg/version.Version=1.2.0 \
+//	  -X github.com/go-installapplications/pkg/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/go-installapplications/pkg/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// See the Makefile.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set via -ldflags -X. Builds without them report "dev" and, when the Go
+// toolchain stamped VCS information, the commit and time from that.
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info is the build metadata of the running binary.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build metadata, falling back to the VCS stamp recorded by
+// the Go toolchain for values not set via ldflags.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+				if len(info.Commit) > 12 {
+					info.Commit = info.Commit[:12]
+				}
+			case s.Key == "vcs.time" && info.Date == "":
+				info.Date = s.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.Date == "" {
+		info.Date = "unknown"
+	}
+	return info
+}
+
+// String formats the build metadata for --version output.
+func (i Info) String() string {
+	return fmt.Sprintf("go-installapplications %s (commit %s, built %s, %s)", i.Version, i.Commit, i.Date, i.GoVersion)
+}
+
+// UserAgent is the User-Agent header sent with every HTTP request, so
+// servers can tell which build a device is running.
+func UserAgent() string {
+	return "go-installapplications/" + Version
+}
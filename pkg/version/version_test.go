@@ -0,0 +1,35 @@
+package version
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGet_UsesLinkedValues(t *testing.T) {
+	prevVersion, prevCommit, prevDate := Version, Commit, Date
+	t.Cleanup(func() { Version, Commit, Date = prevVersion, prevCommit, prevDate })
+	Version, Commit, Date = "1.2.0", "abc1234", "2024-05-01T10:00:00Z"
+
+	info := Get()
+	if info.Version != "1.2.0" || info.Commit != "abc1234" || info.Date != "2024-05-01T10:00:00Z" || info.GoVersion != runtime.Version() {
+		t.Fatalf("Get() = %+v", info)
+	}
+	if got := info.String(); !strings.Contains(got, "1.2.0") || !strings.Contains(got, "commit abc1234") {
+		t.Errorf("String() = %q", got)
+	}
+	if got := UserAgent(); got != "go-installapplications/1.2.0" {
+		t.Errorf("UserAgent() = %q", got)
+	}
+}
+
+func TestGet_FillsUnsetValues(t *testing.T) {
+	prevCommit, prevDate := Commit, Date
+	t.Cleanup(func() { Commit, Date = prevCommit, prevDate })
+	Commit, Date = "", ""
+
+	info := Get()
+	if info.Commit == "" || info.Date == "" {
+		t.Fatalf("Get() = %+v; want commit and date filled in", info)
+	}
+}
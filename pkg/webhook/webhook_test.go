@@ -0,0 +1,140 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-installapplications/pkg/utils"
+)
+
+const authenticate = `{"topic":"mdm.Authenticate","event_id":"e1","checkin_event":{"udid":"0000-abcd"}}`
+
+func newHandler(trigger func() (<-chan struct{}, error)) *Handler {
+	return &Handler{
+		Secret:  "s3cret",
+		UDIDs:   []string{"0000-ABCD"},
+		Topics:  DefaultTopics,
+		Trigger: trigger,
+		Logger:  utils.NewLogger(false, false),
+	}
+}
+
+func post(h http.Handler, target, auth, body string) int {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestHandler_RejectsBadToken(t *testing.T) {
+	h := newHandler(func() (<-chan struct{}, error) {
+		t.Fatal("trigger called without a valid token")
+		return nil, nil
+	})
+	if code := post(h, "/webhook", "", authenticate); code != http.StatusUnauthorized {
+		t.Fatalf("no token: got %d", code)
+	}
+	if code := post(h, "/webhook", "Bearer nope", authenticate); code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: got %d", code)
+	}
+	if code := post(h, "/webhook", "Basic d2ViaG9vazpub3Bl", authenticate); code != http.StatusUnauthorized {
+		t.Fatalf("wrong basic auth password: got %d", code)
+	}
+	// The secret in the URL ends up in proxy and access logs
+	if code := post(h, "/webhook?token=s3cret", "", authenticate); code != http.StatusUnauthorized {
+		t.Fatalf("query token: got %d", code)
+	}
+	h.Secret = ""
+	if code := post(h, "/webhook", "Bearer ", authenticate); code != http.StatusUnauthorized {
+		t.Fatalf("empty secret: got %d", code)
+	}
+}
+
+// MicroMDM cannot set headers, but the user info of its webhook URL is sent
+// as basic auth.
+func TestHandler_AcceptsSecretInURLUserInfo(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	srv := httptest.NewServer(newHandler(func() (<-chan struct{}, error) { return done, nil }))
+	defer srv.Close()
+	resp, err := http.Post(strings.Replace(srv.URL, "://", "://webhook:s3cret@", 1)+"/webhook", "application/json", strings.NewReader(authenticate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+}
+
+func TestHandler_IgnoresOtherTopicsAndDevices(t *testing.T) {
+	h := newHandler(func() (<-chan struct{}, error) {
+		t.Fatal("trigger called for an ignored event")
+		return nil, nil
+	})
+	events := []string{
+		`{"topic":"mdm.TokenUpdate","checkin_event":{"udid":"0000-ABCD"}}`,
+		`{"topic":"mdm.Authenticate","checkin_event":{"udid":"FFFF-0000"}}`,
+	}
+	for _, body := range events {
+		if code := post(h, "/webhook", "Bearer s3cret", body); code != http.StatusOK {
+			t.Fatalf("%s: got %d, want 200", body, code)
+		}
+	}
+}
+
+func TestHandler_OneRunAtATime(t *testing.T) {
+	runs := 0
+	done := make(chan struct{})
+	h := newHandler(func() (<-chan struct{}, error) {
+		runs++
+		return done, nil
+	})
+
+	if code := post(h, "/webhook", "Bearer s3cret", authenticate); code != http.StatusAccepted {
+		t.Fatalf("first event: got %d, want 202", code)
+	}
+	if code := post(h, "/webhook", "Bearer s3cret", authenticate); code != http.StatusConflict {
+		t.Fatalf("event during run: got %d, want 409", code)
+	}
+
+	close(done)
+	for i := 0; i < 1000; i++ {
+		h.mu.Lock()
+		running := h.running
+		h.mu.Unlock()
+		if !running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if code := post(h, "/webhook", "Bearer s3cret", authenticate); code != http.StatusAccepted {
+		t.Fatalf("event after run: got %d, want 202", code)
+	}
+	if runs != 2 {
+		t.Fatalf("expected 2 runs, got %d", runs)
+	}
+}
+
+func TestLoopbackAddress(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:8443": true,
+		"[::1]:8443":     true,
+		"localhost:8443": true,
+		":8443":          false,
+		"0.0.0.0:8443":   false,
+		"10.0.0.5:8443":  false,
+		"example.com:80": false,
+		"8443":           false,
+	} {
+		if got := LoopbackAddress(addr); got != want {
+			t.Errorf("LoopbackAddress(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}